@@ -3,10 +3,18 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/pprof"
+	"net/netip"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/miekg/dns"
@@ -15,6 +23,12 @@ import (
 	"github.com/semihalev/sdns/config"
 	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/sdns/middleware/blocklist"
+	"github.com/semihalev/sdns/middleware/cache"
+	"github.com/semihalev/sdns/middleware/forwarder"
+	"github.com/semihalev/sdns/middleware/querylog"
+	"github.com/semihalev/sdns/middleware/resolver"
+	"github.com/semihalev/sdns/middleware/stats"
+	"github.com/semihalev/sdns/server"
 	"github.com/semihalev/zlog/v2"
 )
 
@@ -23,6 +37,20 @@ import (
 // unbounded request.
 const maxBlockBatchBody = 8 << 20 // 8 MiB
 
+// maxBlockSearch caps a page of blocklist search results.
+const maxBlockSearch = 1000
+
+// maxCacheList caps a page of the cache listing.
+const maxCacheList = 1000
+
+// maxTrustAnchorBody caps a trust anchor import. IANA's root-anchors.xml
+// is a few kilobytes.
+const maxTrustAnchorBody = 1 << 20 // 1 MiB
+
+// streamKeepalive is how often an idle query log stream sends a comment,
+// so proxies and clients can tell a quiet server from a dead connection.
+const streamKeepalive = 15 * time.Second
+
 // blockBatchRequest is the wire format for POST /api/v1/block/{set,remove}/batch.
 type blockBatchRequest struct {
 	Keys []string `json:"keys"`
@@ -32,8 +60,32 @@ type blockBatchRequest struct {
 type API struct {
 	addr        string
 	bearerToken string
+	tokens      []apiToken
+	certs       []clientCert
+	audit       *auditLog
 	router      *Router
 	blocklist   *blocklist.BlockList
+	resolver    *resolver.DNSHandler
+	history     *querylog.History
+	stats       *stats.Stats
+	forwarder   *forwarder.Forwarder
+	cache       *cache.Cache
+	server      DNSServer
+	dashboard   bool
+
+	tlsCert      string
+	tlsKey       string
+	clientCA     string
+	certRequired bool
+	socket       string
+	socketMode   os.FileMode
+	// sockets are the bound listeners, for an upgrade to take over.
+	sockets []server.PassedSocket
+
+	// stop ends the long-lived query log streams when the server shuts
+	// down; Shutdown waits for handlers, and a stream never returns on
+	// its own.
+	stop <-chan struct{}
 	// metricsHandler is built once: promhttp.Handler() constructed a new
 	// instrumented handler per call — fresh collectors and a registry
 	// registration attempt per scrape — and its gzip writers, though
@@ -47,6 +99,19 @@ type API struct {
 
 var debugpprof bool
 
+// Activation names of the API's sockets: what a systemd socket unit
+// names them, and what an upgrade hands them over as.
+const (
+	apiSocketName     = "api"
+	apiUnixSocketName = "apisocket"
+)
+
+// Sockets returns the API's bound listeners, for Server.Upgrade to hand
+// over with the DNS ones.
+func (a *API) Sockets() []server.PassedSocket {
+	return a.sockets
+}
+
 func init() {
 	_, debugpprof = os.LookupEnv("SDNS_PPROF")
 }
@@ -60,46 +125,82 @@ func New(cfg *config.Config) *API {
 		bl = b.(*blocklist.BlockList)
 	}
 
-	a := &API{
-		addr:      cfg.API,
-		blocklist: bl,
-		router:    NewRouter(),
-		metricsHandler: promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
-			DisableCompression: true,
-		}),
-		bearerToken: cfg.BearerToken,
+	var rs *resolver.DNSHandler
+
+	if h, ok := middleware.Get("resolver").(*resolver.DNSHandler); ok {
+		rs = h
 	}
 
-	return a
-}
+	var hs *querylog.History
 
-func (a *API) checkToken(ctx *Context) bool {
-	if a.bearerToken == "" {
-		return true
+	if q, ok := middleware.Get("querylog").(*querylog.QueryLog); ok {
+		hs = q.History()
 	}
 
-	authHeader := ctx.Request.Header.Get("Authorization")
-	if authHeader == "" {
-		ctx.JSON(http.StatusUnauthorized, Json{"error": "unauthorized"})
-		return false
+	var st *stats.Stats
+
+	if s, ok := middleware.Get("stats").(*stats.Stats); ok && s.Enabled() {
+		st = s
 	}
 
-	tokenSplit := strings.Split(authHeader, " ")
-	if len(tokenSplit) != 2 {
-		ctx.JSON(http.StatusUnauthorized, Json{"error": "unauthorized"})
-		return false
+	var fw *forwarder.Forwarder
+
+	if f, ok := middleware.Get("forwarder").(*forwarder.Forwarder); ok && len(f.Upstreams()) > 0 {
+		fw = f
 	}
 
-	if tokenSplit[0] == "Bearer" && a.bearerToken == tokenSplit[1] {
-		return true
+	var ch *cache.Cache
+
+	if c, ok := middleware.Get("cache").(*cache.Cache); ok {
+		ch = c
 	}
 
-	ctx.JSON(http.StatusUnauthorized, Json{"error": "unauthorized"})
-	return false
+	audit := new(auditLog)
+	if (cfg.API != "" || cfg.APISocket != "") && cfg.APIAuditLog != "" {
+		l, err := newAuditLog(cfg.APIAuditLog)
+		if err != nil {
+			zlog.Error("API audit log unavailable, auditing to the server log", "file", cfg.APIAuditLog, "error", err.Error())
+		} else {
+			audit = l
+		}
+	}
+
+	a := &API{
+		addr:      cfg.API,
+		blocklist: bl,
+		resolver:  rs,
+		history:   hs,
+		stats:     st,
+		forwarder: fw,
+		cache:     ch,
+		dashboard: cfg.Dashboard,
+		router:    NewRouter(),
+		metricsHandler: promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+			DisableCompression: true,
+		}),
+		bearerToken: cfg.BearerToken,
+		tokens:      newTokens(cfg),
+		certs:       newClientCerts(cfg),
+		audit:       audit,
+
+		tlsCert:      cfg.APITLSCertificate,
+		tlsKey:       cfg.APITLSKey,
+		clientCA:     cfg.APIClientCA,
+		certRequired: cfg.APIClientCertRequired,
+		socket:       cfg.APISocket,
+	}
+	if m, err := cfg.SocketMode(); err == nil {
+		a.socketMode = m
+	} else {
+		zlog.Error("API socket mode invalid, using 0600", "error", err.Error())
+		a.socketMode = 0600
+	}
+
+	return a
 }
 
 func (a *API) existsBlock(ctx *Context) {
-	if !a.checkToken(ctx) {
+	if !a.checkToken(ctx, scopeReadStats, scopeManageBlocklist) {
 		return
 	}
 
@@ -107,7 +208,7 @@ func (a *API) existsBlock(ctx *Context) {
 }
 
 func (a *API) getBlock(ctx *Context) {
-	if !a.checkToken(ctx) {
+	if !a.checkToken(ctx, scopeReadStats, scopeManageBlocklist) {
 		return
 	}
 
@@ -119,7 +220,7 @@ func (a *API) getBlock(ctx *Context) {
 }
 
 func (a *API) removeBlock(ctx *Context) {
-	if !a.checkToken(ctx) {
+	if !a.checkToken(ctx, scopeManageBlocklist) {
 		return
 	}
 
@@ -127,7 +228,7 @@ func (a *API) removeBlock(ctx *Context) {
 }
 
 func (a *API) setBlock(ctx *Context) {
-	if !a.checkToken(ctx) {
+	if !a.checkToken(ctx, scopeManageBlocklist) {
 		return
 	}
 
@@ -151,11 +252,12 @@ func (a *API) readBatchKeys(ctx *Context) []string {
 		ctx.JSON(http.StatusBadRequest, Json{"error": "keys is required and must be non-empty"})
 		return nil
 	}
+	ctx.auditKeys = req.Keys
 	return req.Keys
 }
 
 func (a *API) setBlockBatch(ctx *Context) {
-	if !a.checkToken(ctx) {
+	if !a.checkToken(ctx, scopeManageBlocklist) {
 		return
 	}
 	keys := a.readBatchKeys(ctx)
@@ -171,7 +273,7 @@ func (a *API) setBlockBatch(ctx *Context) {
 }
 
 func (a *API) removeBlockBatch(ctx *Context) {
-	if !a.checkToken(ctx) {
+	if !a.checkToken(ctx, scopeManageBlocklist) {
 		return
 	}
 	keys := a.readBatchKeys(ctx)
@@ -186,45 +288,498 @@ func (a *API) removeBlockBatch(ctx *Context) {
 	})
 }
 
+// searchBlock lists the blocklist entries containing q, sorted; limit
+// (default 100, at most 1000) caps the page and total counts them all.
+func (a *API) searchBlock(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats, scopeManageBlocklist) {
+		return
+	}
+
+	v := ctx.Request.URL.Query()
+	limit := 100
+	if s := v.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxBlockSearch {
+			ctx.JSON(http.StatusBadRequest, Json{"error": "invalid limit: " + s})
+			return
+		}
+	}
+
+	entries, total := a.blocklist.Search(v.Get("q"), limit)
+	if entries == nil {
+		entries = []string{}
+	}
+	ctx.JSON(http.StatusOK, Json{"entries": entries, "total": total})
+}
+
+// blockSources lists where the blocklist entries came from, with the
+// number of entries each listed and the blocklist's size.
+func (a *API) blockSources(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats, scopeManageBlocklist) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, Json{"sources": a.blocklist.Sources(), "total": a.blocklist.Length()})
+}
+
 func (a *API) metrics(ctx *Context) {
-	if !a.checkToken(ctx) {
+	if !a.checkToken(ctx, scopeReadMetrics) {
 		return
 	}
 
 	a.metricsHandler.ServeHTTP(ctx.Writer, ctx.Request)
 }
 
+// purge removes what is cached about qname for qtype. A qtype of ALL
+// purges every type, and subtree=true every name below qname as well.
 func (a *API) purge(ctx *Context) {
-	if !a.checkToken(ctx) {
+	if !a.checkToken(ctx, scopePurgeCache) {
 		return
 	}
 
-	qtypeName := strings.ToUpper(ctx.Param("qtype"))
-	qtype, ok := dns.StringToType[qtypeName]
+	scope, ok := cacheScope(ctx, ctx.Param("qname"), ctx.Param("qtype"), false)
 	if !ok {
-		ctx.JSON(http.StatusBadRequest, Json{"error": "unknown qtype: " + qtypeName})
 		return
 	}
-	q := dns.Question{
-		Name:   dns.Fqdn(ctx.Param("qname")),
-		Qtype:  qtype,
-		Qclass: dns.ClassINET,
-	}
 
 	// Invalidate every purger the pipeline exposes — today that's
-	// the cache middleware (positive + negative entries) and the
-	// resolver handler (NS cache, TypeNS only). No synthesised
-	// CHAOS-NULL query, no base64 encoding; just a direct call.
+	// the cache middleware (answers, failure states, NXDOMAIN cuts and
+	// denial proofs) and the resolver handler (delegations). No
+	// synthesised CHAOS-NULL query, no base64 encoding; just a direct
+	// call.
+	removed := 0
 	for _, p := range middleware.GlobalPipeline().Purgers() {
-		p.Purge(q)
+		removed += p.PurgeMatching(scope)
 	}
 
+	ctx.JSON(http.StatusOK, Json{"success": true, "removed": removed})
+}
+
+// cacheEntries lists the cached answers for name and every name below it
+// (subtree=false for name alone), of one type when type is given.
+func (a *API) cacheEntries(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats) {
+		return
+	}
+
+	v := ctx.Request.URL.Query()
+	name := v.Get("name")
+	if name == "" {
+		name = "."
+	}
+	qtype := v.Get("type")
+	if qtype == "" {
+		qtype = "ALL"
+	}
+	scope, ok := cacheScope(ctx, name, qtype, true)
+	if !ok {
+		return
+	}
+	limit := 100
+	if s := v.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxCacheList {
+			ctx.JSON(http.StatusBadRequest, Json{"error": "invalid limit: " + s})
+			return
+		}
+	}
+
+	entries, total := a.cache.Entries(scope, limit)
+	if entries == nil {
+		entries = []cache.EntryInfo{}
+	}
+	ctx.JSON(http.StatusOK, Json{"entries": entries, "total": total})
+}
+
+// cacheScope reads the scope of a cache call from its name, its type (ALL
+// for every type) and the subtree query parameter, answering 400 itself
+// when one is malformed.
+func cacheScope(ctx *Context, name, qtype string, subtree bool) (middleware.PurgeScope, bool) {
+	scope := middleware.PurgeScope{Name: dns.Fqdn(name), Qclass: dns.ClassINET}
+	if _, ok := dns.IsDomainName(scope.Name); !ok {
+		ctx.JSON(http.StatusBadRequest, Json{"error": "invalid name: " + name})
+		return scope, false
+	}
+
+	qtype = strings.ToUpper(qtype)
+	if qtype != "ALL" {
+		t, ok := dns.StringToType[qtype]
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, Json{"error": "unknown qtype: " + qtype})
+			return scope, false
+		}
+		scope.Qtype = t
+	}
+
+	scope.Subtree = subtree
+	if s := ctx.Request.URL.Query().Get("subtree"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, Json{"error": "invalid subtree: " + s})
+			return scope, false
+		}
+		scope.Subtree = b
+	}
+	return scope, true
+}
+
+func (a *API) trustAnchors(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats) {
+		return
+	}
+
+	anchors, err := a.resolver.TrustAnchors()
+	if err != nil {
+		trustAnchorError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, Json{"trustanchors": anchors})
+}
+
+// importTrustAnchors takes the request body as an IANA root-anchors.xml
+// document or as DS / DNSKEY records in zone-file format.
+func (a *API) importTrustAnchors(ctx *Context) {
+	if !a.checkToken(ctx, scopeAdmin) {
+		return
+	}
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxTrustAnchorBody)
+	data, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, Json{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	tags, err := a.resolver.ImportTrustAnchors(ctx.Request.Context(), data)
+	if err != nil {
+		trustAnchorError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, Json{"success": true, "imported": tags})
+}
+
+func (a *API) forceTrustAnchorState(ctx *Context) {
+	if !a.checkToken(ctx, scopeAdmin) {
+		return
+	}
+
+	tag, err := strconv.ParseUint(ctx.Param("keytag"), 10, 16)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, Json{"error": "invalid key tag: " + ctx.Param("keytag")})
+		return
+	}
+
+	if err := a.resolver.ForceTrustAnchorState(uint16(tag), ctx.Param("state")); err != nil {
+		trustAnchorError(ctx, err)
+		return
+	}
 	ctx.JSON(http.StatusOK, Json{"success": true})
 }
 
+// authorities lists the root servers and cached delegations with their
+// per-server measurements. ?zone= narrows it to one zone and the zones
+// delegated below it.
+func (a *API) authorities(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats) {
+		return
+	}
+
+	zone := ctx.Request.URL.Query().Get("zone")
+	if zone != "" {
+		if _, ok := dns.IsDomainName(zone); !ok {
+			ctx.JSON(http.StatusBadRequest, Json{"error": "invalid zone: " + zone})
+			return
+		}
+	}
+
+	delegations, total := a.resolver.Authorities(zone)
+	ctx.JSON(http.StatusOK, Json{"delegations": delegations, "total": total})
+}
+
+// upstreams lists the forwarder's upstreams with how each has been doing.
+func (a *API) upstreams(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, Json{"upstreams": a.forwarder.Upstreams()})
+}
+
+// queryLog searches the query history, newest first. Filters are client
+// (an address or prefix), qname (the name and below), rcode, blocked and
+// since (RFC 3339, or a duration back from now); limit and before page
+// through the results, before being the next value of the previous page.
+func (a *API) queryLog(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats) {
+		return
+	}
+
+	v := ctx.Request.URL.Query()
+	f, err := queryLogFilter(v, time.Now())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, Json{"error": err.Error()})
+		return
+	}
+	var before uint64
+	if s := v.Get("before"); s != "" {
+		if before, err = strconv.ParseUint(s, 10, 64); err != nil {
+			ctx.JSON(http.StatusBadRequest, Json{"error": "invalid before: " + s})
+			return
+		}
+	}
+	limit := 100
+	if s := v.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > querylog.MaxPage {
+			ctx.JSON(http.StatusBadRequest, Json{"error": "invalid limit: " + s})
+			return
+		}
+	}
+
+	entries, next := a.history.Search(f, before, limit)
+	if entries == nil {
+		entries = []querylog.Entry{}
+	}
+	body := Json{"entries": entries}
+	if next != 0 {
+		body["next"] = next
+	}
+	ctx.JSON(http.StatusOK, body)
+}
+
+// queryLogStream sends new history entries as Server-Sent Events, one
+// JSON entry per event, under the same filters as queryLog less the
+// paging ones.
+func (a *API) queryLogStream(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats) {
+		return
+	}
+
+	f, err := queryLogFilter(ctx.Request.URL.Query(), time.Now())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, Json{"error": err.Error()})
+		return
+	}
+	flusher, ok := ctx.Writer.(http.Flusher)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, Json{"error": "streaming unsupported"})
+		return
+	}
+
+	entries, cancel := a.history.Subscribe(f)
+	defer cancel()
+
+	h := ctx.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	ctx.Writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+
+	var buf []byte
+	for {
+		select {
+		case e := <-entries:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			buf = append(buf[:0], "id: "...)
+			buf = strconv.AppendUint(buf, e.ID, 10)
+			buf = append(buf, "\ndata: "...)
+			buf = append(buf, data...)
+			buf = append(buf, "\n\n"...)
+			if _, err := ctx.Writer.Write(buf); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := io.WriteString(ctx.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-ctx.Request.Context().Done():
+			return
+		case <-a.stop:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// statsQuery reads the window (1h or 24h, default 24h) and, for a top
+// list, the limit (default 10) of a stats request.
+func statsQuery(ctx *Context) (stats.Window, int, bool) {
+	v := ctx.Request.URL.Query()
+	w := stats.Day
+	if s := v.Get("window"); s != "" {
+		var err error
+		if w, err = stats.ParseWindow(s); err != nil {
+			ctx.JSON(http.StatusBadRequest, Json{"error": err.Error()})
+			return 0, 0, false
+		}
+	}
+	limit := 10
+	if s := v.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > stats.MaxTop {
+			ctx.JSON(http.StatusBadRequest, Json{"error": "invalid limit: " + s})
+			return 0, 0, false
+		}
+	}
+	return w, limit, true
+}
+
+// statsSummary reports the query, blocked, NXDOMAIN and cache hit totals
+// over the window.
+func (a *API) statsSummary(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats) {
+		return
+	}
+	w, _, ok := statsQuery(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, a.stats.Summary(w))
+}
+
+// statsSeries reports the window bucket by bucket, for graphing.
+func (a *API) statsSeries(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats) {
+		return
+	}
+	w, _, ok := statsQuery(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, Json{"window": w.String(), "series": a.stats.Series(w)})
+}
+
+// statsDomains lists the most queried domains over the window.
+func (a *API) statsDomains(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats) {
+		return
+	}
+	w, limit, ok := statsQuery(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, Json{"window": w.String(), "domains": a.stats.TopDomains(w, limit)})
+}
+
+// statsBlocked lists the most blocked domains over the window.
+func (a *API) statsBlocked(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats) {
+		return
+	}
+	w, limit, ok := statsQuery(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, Json{"window": w.String(), "domains": a.stats.TopBlocked(w, limit)})
+}
+
+// statsClients lists the clients that sent the most queries over the
+// window, with their blocked and NXDOMAIN counts.
+func (a *API) statsClients(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats) {
+		return
+	}
+	w, limit, ok := statsQuery(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, Json{"window": w.String(), "clients": a.stats.TopClients(w, limit)})
+}
+
+// statsClient reports one client's counts over the window.
+func (a *API) statsClient(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats) {
+		return
+	}
+	addr, err := netip.ParseAddr(ctx.Param("ip"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, Json{"error": "invalid client address: " + ctx.Param("ip")})
+		return
+	}
+	w, _, ok := statsQuery(ctx)
+	if !ok {
+		return
+	}
+	c, tracked := a.stats.Client(w, addr)
+	ctx.JSON(http.StatusOK, Json{"window": w.String(), "client": c, "tracked": tracked})
+}
+
+// queryLogFilter reads the history filters from a query string.
+func queryLogFilter(v url.Values, now time.Time) (querylog.Filter, error) {
+	var f querylog.Filter
+
+	if s := v.Get("client"); s != "" {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			f.Client = p.Masked()
+		} else if ip, err := netip.ParseAddr(s); err == nil {
+			ip = ip.Unmap()
+			f.Client = netip.PrefixFrom(ip, ip.BitLen())
+		} else {
+			return f, errors.New("invalid client: " + s)
+		}
+	}
+	f.ClientID = v.Get("client_id")
+	if s := v.Get("qname"); s != "" {
+		if _, ok := dns.IsDomainName(s); !ok {
+			return f, errors.New("invalid qname: " + s)
+		}
+		f.Qname = dns.Fqdn(s)
+	}
+	if s := v.Get("rcode"); s != "" {
+		if code, ok := dns.StringToRcode[strings.ToUpper(s)]; ok {
+			f.Rcode = dns.RcodeToString[code]
+		} else if code, err := strconv.Atoi(s); err == nil && dns.RcodeToString[code] != "" {
+			f.Rcode = dns.RcodeToString[code]
+		} else {
+			return f, errors.New("invalid rcode: " + s)
+		}
+	}
+	if s := v.Get("blocked"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return f, errors.New("invalid blocked: " + s)
+		}
+		f.Blocked = &b
+	}
+	if s := v.Get("since"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+			f.Since = now.Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, s); err == nil {
+			f.Since = t
+		} else {
+			return f, errors.New("invalid since: " + s)
+		}
+	}
+	return f, nil
+}
+
+func trustAnchorError(ctx *Context, err error) {
+	code := http.StatusBadRequest
+	switch {
+	case errors.Is(err, resolver.ErrTrustAnchorNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, resolver.ErrTrustAnchorTombstoned):
+		code = http.StatusConflict
+	case errors.Is(err, resolver.ErrTrustAnchorFetch):
+		code = http.StatusBadGateway
+	case errors.Is(err, resolver.ErrDNSSECDisabled):
+		code = http.StatusServiceUnavailable
+	case errors.Is(err, resolver.ErrTrustAnchorInvalid):
+	default:
+		code = http.StatusInternalServerError
+	}
+	ctx.JSON(code, Json{"error": err.Error()})
+}
+
 // (*API).Run run API server.
 func (a *API) Run(ctx context.Context) {
-	if a.addr == "" {
+	if a.addr == "" && a.socket == "" {
 		return
 	}
 
@@ -234,12 +789,12 @@ func (a *API) Run(ctx context.Context) {
 			profiler.GET("/", func(ctx *Context) {
 				http.Redirect(ctx.Writer, ctx.Request, profiler.path+"/pprof/", http.StatusMovedPermanently)
 			})
-			profiler.GET("/pprof/", func(ctx *Context) { pprof.Index(ctx.Writer, ctx.Request) })
-			profiler.GET("/pprof/*", func(ctx *Context) { pprof.Index(ctx.Writer, ctx.Request) })
-			profiler.GET("/pprof/cmdline", func(ctx *Context) { pprof.Cmdline(ctx.Writer, ctx.Request) })
-			profiler.GET("/pprof/profile", func(ctx *Context) { pprof.Profile(ctx.Writer, ctx.Request) })
-			profiler.GET("/pprof/symbol", func(ctx *Context) { pprof.Symbol(ctx.Writer, ctx.Request) })
-			profiler.GET("/pprof/trace", func(ctx *Context) { pprof.Trace(ctx.Writer, ctx.Request) })
+			profiler.GET("/pprof/", a.admin(pprof.Index))
+			profiler.GET("/pprof/*", a.admin(pprof.Index))
+			profiler.GET("/pprof/cmdline", a.admin(pprof.Cmdline))
+			profiler.GET("/pprof/profile", a.admin(pprof.Profile))
+			profiler.GET("/pprof/symbol", a.admin(pprof.Symbol))
+			profiler.GET("/pprof/trace", a.admin(pprof.Trace))
 		}
 	}
 
@@ -248,35 +803,150 @@ func (a *API) Run(ctx context.Context) {
 		{
 			block.GET("/exists/:key", a.existsBlock)
 			block.GET("/get/:key", a.getBlock)
-			block.GET("/remove/:key", a.removeBlock)
-			block.GET("/set/:key", a.setBlock)
-			block.POST("/set/batch", a.setBlockBatch)
-			block.POST("/remove/batch", a.removeBlockBatch)
+			block.GET("/remove/:key", a.audited(a.removeBlock))
+			block.GET("/set/:key", a.audited(a.setBlock))
+			block.POST("/set/batch", a.audited(a.setBlockBatch))
+			block.POST("/remove/batch", a.audited(a.removeBlockBatch))
+			block.GET("/search", a.searchBlock)
+			block.GET("/sources", a.blockSources)
 		}
 	}
 
-	a.router.GET("/api/v1/purge/:qname/:qtype", a.purge)
+	if a.resolver != nil {
+		ta := a.router.Group("/api/v1/trustanchors")
+		{
+			ta.GET("", a.trustAnchors)
+			ta.POST("/import", a.audited(a.importTrustAnchors))
+			ta.POST("/state/:keytag/:state", a.audited(a.forceTrustAnchorState))
+		}
+
+		a.router.GET("/api/v1/authorities", a.authorities)
+	}
+
+	if a.history != nil {
+		a.stop = ctx.Done()
+		a.router.GET("/api/v1/querylog", a.queryLog)
+		a.router.GET("/api/v1/querylog/stream", a.queryLogStream)
+	}
+
+	if a.stats != nil {
+		st := a.router.Group("/api/v1/stats")
+		{
+			st.GET("/summary", a.statsSummary)
+			st.GET("/series", a.statsSeries)
+			st.GET("/domains", a.statsDomains)
+			st.GET("/blocked", a.statsBlocked)
+			st.GET("/clients", a.statsClients)
+			st.GET("/clients/:ip", a.statsClient)
+		}
+	}
+
+	if a.forwarder != nil {
+		a.router.GET("/api/v1/upstreams", a.upstreams)
+	}
+
+	if a.dashboard {
+		a.router.GET("/dashboard", a.dashboardRedirect)
+		a.router.GET("/dashboard/", a.dashboardFiles)
+		a.router.GET("/dashboard/*", a.dashboardFiles)
+	}
+
+	if a.cache != nil {
+		a.router.GET("/api/v1/cache", a.cacheEntries)
+	}
+
+	a.router.GET("/api/v1/purge/:qname/:qtype", a.audited(a.purge))
 
 	a.router.GET("/metrics", a.metrics)
 
-	srv := &http.Server{
-		Addr:              a.addr,
-		Handler:           a.router,
-		ReadHeaderTimeout: 10 * time.Second,
+	a.router.GET("/api/v1/loglevel", a.logLevel)
+	a.router.Handle(http.MethodPut, "/api/v1/loglevel", a.audited(a.setLogLevel))
+	a.router.Handle(http.MethodDelete, "/api/v1/loglevel", a.audited(a.resetLogLevel))
+
+	a.router.GET("/healthz", a.healthz)
+	a.router.GET("/readyz", a.readyz)
+
+	if a.server != nil {
+		a.router.POST("/api/v1/drain", a.audited(a.drain))
 	}
 
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	var (
+		servers []*http.Server
+		certs   *server.CertManager
+	)
+
+	if a.addr != "" {
+		conf, cm, err := a.serverTLS()
+		if err != nil {
+			// Falling back to plain HTTP would send the tokens in the
+			// clear; better no TCP listener at all.
+			zlog.Error("API TLS setup failed, not listening", "addr", a.addr, "error", err.Error())
+		} else if ln, err := listenAPI(a.addr); err != nil {
 			zlog.Error("Start API server failed", "error", err.Error())
+			if cm != nil {
+				cm.Stop()
+			}
+		} else {
+			certs = cm
+			if sc, ok := ln.(syscall.Conn); ok {
+				a.sockets = append(a.sockets, server.PassedSocket{Name: apiSocketName, Conn: sc})
+			}
+			srv := &http.Server{
+				Addr:              a.addr,
+				Handler:           a.router,
+				ReadHeaderTimeout: 10 * time.Second,
+				TLSConfig:         conf,
+			}
+			servers = append(servers, srv)
+
+			go func() {
+				var err error
+				if conf != nil {
+					err = srv.ServeTLS(ln, "", "")
+				} else {
+					err = srv.Serve(ln)
+				}
+				if err != nil && err != http.ErrServerClosed {
+					zlog.Error("Start API server failed", "error", err.Error())
+				}
+			}()
+
+			zlog.Info("API server listening...", "addr", a.addr, "tls", conf != nil, "client_certs", conf != nil && conf.ClientCAs != nil)
 		}
-	}()
+	}
 
-	zlog.Info("API server listening...", "addr", a.addr)
-	if a.bearerToken != "" {
-		// Never log the token itself — anyone who can read process
+	if a.socket != "" {
+		ln, err := listenSocket(a.socket, a.socketMode)
+		if err != nil {
+			zlog.Error("API socket listen failed", "path", a.socket, "error", err.Error())
+		} else {
+			if sc, ok := ln.(syscall.Conn); ok {
+				a.sockets = append(a.sockets, server.PassedSocket{Name: apiUnixSocketName, Conn: sc})
+			}
+			srv := &http.Server{
+				Handler:           a.router,
+				ReadHeaderTimeout: 10 * time.Second,
+				ConnContext: func(ctx context.Context, _ net.Conn) context.Context {
+					return context.WithValue(ctx, socketKey{}, true)
+				},
+			}
+			servers = append(servers, srv)
+
+			go func() {
+				if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+					zlog.Error("API socket server failed", "error", err.Error())
+				}
+			}()
+
+			zlog.Info("API server listening...", "socket", a.socket, "mode", fmt.Sprintf("%04o", a.socketMode))
+		}
+	}
+
+	if len(a.tokens) > 0 || len(a.certs) > 0 {
+		// Never log the tokens themselves — anyone who can read process
 		// or aggregated logs would be able to call the protected
 		// endpoints (cache purge, blocklist mutation, metrics).
-		zlog.Info("API bearer-token authorization enabled")
+		zlog.Info("API authorization enabled", "tokens", len(a.tokens), "client_certs", len(a.certs))
 	}
 
 	go func() { //nolint:gosec // G118 - intentionally using Background() for shutdown grace period after parent ctx is cancelled
@@ -287,8 +957,14 @@ func (a *API) Run(ctx context.Context) {
 		apiCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		if err := srv.Shutdown(apiCtx); err != nil {
-			zlog.Error("Shutdown API server failed:", "error", err.Error())
+		for _, srv := range servers {
+			if err := srv.Shutdown(apiCtx); err != nil {
+				zlog.Error("Shutdown API server failed:", "error", err.Error())
+			}
+		}
+		if certs != nil {
+			certs.Stop()
 		}
+		_ = a.audit.Close()
 	}()
 }
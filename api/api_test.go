@@ -1,18 +1,40 @@
 package api
 
 import (
+	"bufio"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/pprof"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/debuglog"
+	"github.com/semihalev/sdns/internal/mock"
 	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/sdns/middleware/blocklist"
+	"github.com/semihalev/sdns/middleware/cache"
+	"github.com/semihalev/sdns/middleware/querylog"
+	"github.com/semihalev/sdns/middleware/resolver"
+	"github.com/semihalev/sdns/middleware/stats"
 	"github.com/semihalev/zlog/v2"
 )
 
@@ -31,7 +53,19 @@ func Test_Authorization(t *testing.T) {
 		{"GET", "/api/v1/block/get/test.com", http.StatusUnauthorized},
 		{"GET", "/api/v1/block/exists/test.com", http.StatusUnauthorized},
 		{"GET", "/api/v1/block/remove/test.com", http.StatusUnauthorized},
+		{"GET", "/api/v1/block/search?q=test", http.StatusUnauthorized},
+		{"GET", "/api/v1/block/sources", http.StatusUnauthorized},
 		{"GET", "/api/v1/purge/test.com/A", http.StatusUnauthorized},
+		{"GET", "/api/v1/trustanchors", http.StatusUnauthorized},
+		{"POST", "/api/v1/trustanchors/import", http.StatusUnauthorized},
+		{"POST", "/api/v1/trustanchors/state/20326/VALID", http.StatusUnauthorized},
+		{"GET", "/api/v1/authorities", http.StatusUnauthorized},
+		{"GET", "/api/v1/querylog", http.StatusUnauthorized},
+		{"GET", "/api/v1/querylog/stream", http.StatusUnauthorized},
+		{"GET", "/api/v1/stats/summary", http.StatusUnauthorized},
+		{"GET", "/api/v1/stats/series", http.StatusUnauthorized},
+		{"GET", "/api/v1/stats/clients/192.0.2.1", http.StatusUnauthorized},
+		{"GET", "/api/v1/upstreams", http.StatusUnauthorized},
 		{"GET", "/metrics", http.StatusUnauthorized},
 	}
 
@@ -47,8 +81,24 @@ func Test_Authorization(t *testing.T) {
 		block.GET("/remove/:key", a.removeBlock)
 		block.GET("/set/:key", a.setBlock)
 		block.POST("/set/:key", a.setBlock)
+		block.GET("/search", a.searchBlock)
+		block.GET("/sources", a.blockSources)
 	}
 
+	ta := a.router.Group("/api/v1/trustanchors")
+	{
+		ta.GET("", a.trustAnchors)
+		ta.POST("/import", a.importTrustAnchors)
+		ta.POST("/state/:keytag/:state", a.forceTrustAnchorState)
+	}
+
+	a.router.GET("/api/v1/authorities", a.authorities)
+	a.router.GET("/api/v1/querylog", a.queryLog)
+	a.router.GET("/api/v1/querylog/stream", a.queryLogStream)
+	a.router.GET("/api/v1/stats/summary", a.statsSummary)
+	a.router.GET("/api/v1/stats/series", a.statsSeries)
+	a.router.GET("/api/v1/stats/clients/:ip", a.statsClient)
+	a.router.GET("/api/v1/upstreams", a.upstreams)
 	a.router.GET("/api/v1/purge/:qname/:qtype", a.purge)
 	a.router.GET("/metrics", a.metrics)
 
@@ -223,3 +273,791 @@ func Test_AllAPICalls(t *testing.T) {
 		}
 	}
 }
+
+func Test_Authorities(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.RootServers = []string{"192.0.2.1:53", "192.0.2.2:53"}
+
+	a := New(&config.Config{})
+	a.resolver = resolver.New(cfg)
+	a.router.GET("/api/v1/authorities", a.authorities)
+
+	get := func(url string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.router.ServeHTTP(w, request)
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+		return w.Code, body
+	}
+
+	code, body := get("/api/v1/authorities")
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	delegations, _ := body["delegations"].([]any)
+	if len(delegations) != 1 || body["total"] != float64(1) {
+		t.Fatalf("body %v, want the root delegation alone", body)
+	}
+	root := delegations[0].(map[string]any)
+	if root["zone"] != "." || len(root["servers"].([]any)) != 2 {
+		t.Fatalf("root delegation %v, want both configured root servers", root)
+	}
+	server := root["servers"].([]any)[0].(map[string]any)
+	if server["health"] != "UNKNOWN" || server["circuit"] != "closed" {
+		t.Fatalf("unqueried root server %v, want UNKNOWN and closed", server)
+	}
+
+	if code, body = get("/api/v1/authorities?zone=example.com"); code != http.StatusOK || body["total"] != float64(0) {
+		t.Fatalf("zone filter: %d %v, want nothing cached under example.com", code, body)
+	}
+	if code, _ = get("/api/v1/authorities?zone=bad..zone"); code != http.StatusBadRequest {
+		t.Fatalf("invalid zone: status %d, want 400", code)
+	}
+}
+
+func Test_QueryLog(t *testing.T) {
+	ql := querylog.New(&config.Config{QueryLog: config.QueryLogConfig{
+		Enabled: true,
+		File:    filepath.Join(t.TempDir(), "query.log"),
+		History: 64,
+	}})
+	t.Cleanup(func() { _ = ql.Close() })
+
+	answer := middleware.HandlerFunc(func(ctx context.Context, ch *middleware.Chain) {
+		resp := new(dns.Msg)
+		resp.SetRcode(ch.Request.Msg(), dns.RcodeNameError)
+		_ = ch.Writer.WriteMsg(resp)
+		ch.Cancel()
+	})
+	query := func(client, name string) {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		ch := middleware.NewChain([]middleware.Handler{ql, answer})
+		ch.Reset(mock.NewWriter("udp", client), req)
+		ch.Next(context.Background())
+	}
+
+	a := New(&config.Config{})
+	a.history = ql.History()
+	a.router.GET("/api/v1/querylog", a.queryLog)
+	a.router.GET("/api/v1/querylog/stream", a.queryLogStream)
+
+	get := func(url string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.router.ServeHTTP(w, request)
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+		return w.Code, body
+	}
+
+	query("192.0.2.7:5353", "a.example.com.")
+	query("192.0.2.7:5353", "b.example.com.")
+	query("198.51.100.1:5353", "c.example.com.")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if entries, _ := a.history.Search(querylog.Filter{}, 0, 10); len(entries) == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("queries did not reach the history")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	code, body := get("/api/v1/querylog?client=192.0.2.7&rcode=NXDOMAIN&since=10m&limit=1")
+	entries, _ := body["entries"].([]any)
+	if code != http.StatusOK || len(entries) != 1 || body["next"] == nil {
+		t.Fatalf("first page: %d %v", code, body)
+	}
+	if e := entries[0].(map[string]any); e["qname"] != "b.example.com." {
+		t.Fatalf("first entry %v, want the newest query from the client", e)
+	}
+	code, body = get(fmt.Sprintf("/api/v1/querylog?client=192.0.2.0/24&limit=1&before=%v", body["next"]))
+	entries, _ = body["entries"].([]any)
+	if code != http.StatusOK || len(entries) != 1 || body["next"] != nil ||
+		entries[0].(map[string]any)["qname"] != "a.example.com." {
+		t.Fatalf("second page: %d %v", code, body)
+	}
+	if _, body = get("/api/v1/querylog?blocked=true"); len(body["entries"].([]any)) != 0 {
+		t.Fatalf("blocked filter: %v, want nothing blocked", body)
+	}
+	for _, bad := range []string{"client=nope", "qname=bad..name", "rcode=LOUD", "blocked=maybe", "since=yesterday", "limit=0", "before=x"} {
+		if code, _ := get("/api/v1/querylog?" + bad); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", bad, code)
+		}
+	}
+
+	srv := httptest.NewServer(a.router)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/api/v1/querylog/stream?qname=example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("stream: %d %s", resp.StatusCode, ct)
+	}
+
+	query("192.0.2.7:5353", "skipped.example.com.")
+	query("192.0.2.7:5353", "www.example.org.")
+
+	events := make(chan string, 1)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+				events <- data
+				return
+			}
+		}
+	}()
+	select {
+	case data := <-events:
+		var e querylog.Entry
+		if err := json.Unmarshal([]byte(data), &e); err != nil || e.Qname != "www.example.org." || e.Rcode != "NXDOMAIN" {
+			t.Fatalf("streamed %s (%v), want the example.org query", data, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event streamed")
+	}
+}
+
+func Test_Stats(t *testing.T) {
+	st := stats.New(&config.Config{Stats: config.StatsConfig{Enabled: true}})
+	t.Cleanup(func() { _ = st.Close() })
+
+	answer := middleware.HandlerFunc(func(ctx context.Context, ch *middleware.Chain) {
+		if strings.HasPrefix(ch.Request.Msg().Question[0].Name, "ads.") {
+			middleware.NoteBlocked(ctx, ch.Request, "blocklist")
+		}
+		resp := new(dns.Msg)
+		resp.SetRcode(ch.Request.Msg(), dns.RcodeNameError)
+		_ = ch.Writer.WriteMsg(resp)
+		ch.Cancel()
+	})
+	query := func(client, name string) {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		ch := middleware.NewChain([]middleware.Handler{st, answer})
+		ch.Reset(mock.NewWriter("udp", client), req)
+		ch.Next(context.Background())
+	}
+
+	a := New(&config.Config{})
+	a.stats = st
+	a.router.GET("/api/v1/stats/summary", a.statsSummary)
+	a.router.GET("/api/v1/stats/series", a.statsSeries)
+	a.router.GET("/api/v1/stats/domains", a.statsDomains)
+	a.router.GET("/api/v1/stats/blocked", a.statsBlocked)
+	a.router.GET("/api/v1/stats/clients", a.statsClients)
+	a.router.GET("/api/v1/stats/clients/:ip", a.statsClient)
+
+	get := func(url string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.router.ServeHTTP(w, request)
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+		return w.Code, body
+	}
+
+	query("192.0.2.7:5353", "www.example.com.")
+	query("192.0.2.7:5353", "www.example.com.")
+	query("192.0.2.7:5353", "ads.example.com.")
+	query("198.51.100.1:5353", "www.example.com.")
+	deadline := time.Now().Add(5 * time.Second)
+	for st.Summary(stats.Day).Queries != 4 {
+		if time.Now().After(deadline) {
+			t.Fatal("queries were not counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code, body := get("/api/v1/stats/summary?window=1h"); code != http.StatusOK ||
+		body["queries"] != float64(4) || body["blocked"] != float64(1) || body["nxdomain"] != float64(4) {
+		t.Fatalf("summary: %d %v", code, body)
+	}
+	_, body := get("/api/v1/stats/series?window=1h")
+	if series, _ := body["series"].([]any); len(series) != 12 || series[11].(map[string]any)["queries"] != float64(4) {
+		t.Fatalf("series: %v", body)
+	}
+	_, body = get("/api/v1/stats/domains?limit=1")
+	domains, _ := body["domains"].([]any)
+	if len(domains) != 1 || domains[0].(map[string]any)["name"] != "www.example.com." || body["window"] != "24h" {
+		t.Fatalf("domains: %v", body)
+	}
+	_, body = get("/api/v1/stats/blocked")
+	if domains, _ = body["domains"].([]any); len(domains) != 1 || domains[0].(map[string]any)["name"] != "ads.example.com." {
+		t.Fatalf("blocked: %v", body)
+	}
+	_, body = get("/api/v1/stats/clients")
+	clients, _ := body["clients"].([]any)
+	if len(clients) != 2 || clients[0].(map[string]any)["client"] != "192.0.2.7" {
+		t.Fatalf("clients: %v", body)
+	}
+	_, body = get("/api/v1/stats/clients/192.0.2.7")
+	if c, _ := body["client"].(map[string]any); body["tracked"] != true || c["queries"] != float64(3) || c["blocked"] != float64(1) {
+		t.Fatalf("client: %v", body)
+	}
+	if _, body = get("/api/v1/stats/clients/2001:db8::1"); body["tracked"] != false {
+		t.Fatalf("unseen client: %v", body)
+	}
+	for _, bad := range []string{"summary?window=7d", "domains?limit=0", "clients?limit=x", "clients/nope"} {
+		if code, _ := get("/api/v1/stats/" + bad); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", bad, code)
+		}
+	}
+}
+
+func Test_Dashboard(t *testing.T) {
+	a := New(&config.Config{API: "127.0.0.1:0", Dashboard: true})
+	a.router.GET("/dashboard", a.dashboardRedirect)
+	a.router.GET("/dashboard/", a.dashboardFiles)
+	a.router.GET("/dashboard/*", a.dashboardFiles)
+
+	for _, r := range []struct {
+		url     string
+		code    int
+		content string
+	}{
+		{"/dashboard", http.StatusMovedPermanently, ""},
+		{"/dashboard/", http.StatusOK, "text/html"},
+		{"/dashboard/app.js", http.StatusOK, "text/javascript"},
+		{"/dashboard/app.css", http.StatusOK, "text/css"},
+		{"/dashboard/missing.js", http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		request, err := http.NewRequest(http.MethodGet, r.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.router.ServeHTTP(w, request)
+		if w.Code != r.code {
+			t.Fatalf("%s: status %d, want %d", r.url, w.Code, r.code)
+		}
+		if r.content != "" && !strings.HasPrefix(w.Header().Get("Content-Type"), r.content) {
+			t.Errorf("%s: content type %q", r.url, w.Header().Get("Content-Type"))
+		}
+		if r.code == http.StatusOK && !strings.Contains(w.Header().Get("Content-Security-Policy"), "default-src 'self'") {
+			t.Errorf("%s: no content security policy", r.url)
+		}
+	}
+}
+
+func Test_BlockSearch(t *testing.T) {
+	cfg := &config.Config{
+		Nullroute:    "0.0.0.0",
+		Nullroutev6:  "::0",
+		BlockListDir: t.TempDir(),
+		Blocklist:    []string{"ads.example.com", "*.tracker.example.net"},
+	}
+	bl := blocklist.New(cfg)
+
+	a := New(&config.Config{})
+	a.blocklist = bl
+	a.router.GET("/api/v1/block/search", a.searchBlock)
+	a.router.GET("/api/v1/block/sources", a.blockSources)
+
+	get := func(url string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.router.ServeHTTP(w, request)
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+		return w.Code, body
+	}
+
+	_, body := get("/api/v1/block/search?q=example")
+	if entries, _ := body["entries"].([]any); len(entries) != 2 || body["total"] != float64(2) {
+		t.Fatalf("search: %v", body)
+	}
+	_, body = get("/api/v1/block/search?q=tracker&limit=1")
+	if entries, _ := body["entries"].([]any); len(entries) != 1 || entries[0] != "*.tracker.example.net." {
+		t.Fatalf("wildcard search: %v", body)
+	}
+	if code, _ := get("/api/v1/block/search?limit=5000"); code != http.StatusBadRequest {
+		t.Fatalf("oversized limit: status %d, want 400", code)
+	}
+	_, body = get("/api/v1/block/sources")
+	if sources, _ := body["sources"].([]any); len(sources) != 1 || body["total"] != float64(2) {
+		t.Fatalf("sources: %v", body)
+	}
+}
+
+func Test_Scopes(t *testing.T) {
+	opsDigest := sha256.Sum256([]byte("ops-secret"))
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	cfg := &config.Config{
+		API:         "127.0.0.1:0",
+		BearerToken: "root-secret",
+		APITokens: []config.APITokenConfig{
+			{Name: "noc", Token: "noc-secret", Scopes: []string{"read-metrics", "read-stats"}},
+			{Name: "ops", TokenSHA256: hex.EncodeToString(opsDigest[:]), Scopes: []string{"manage-blocklist"}},
+		},
+		APIAuditLog: auditFile,
+	}
+
+	a := New(cfg)
+	a.blocklist = blocklist.New(&config.Config{Nullroute: "0.0.0.0", Nullroutev6: "::0", BlockListDir: t.TempDir()})
+	a.router.GET("/api/v1/block/exists/:key", a.existsBlock)
+	a.router.GET("/api/v1/block/set/:key", a.audited(a.setBlock))
+	a.router.POST("/api/v1/block/remove/batch", a.audited(a.removeBlockBatch))
+	a.router.GET("/api/v1/purge/:qname/:qtype", a.audited(a.purge))
+	a.router.GET("/metrics", a.metrics)
+	a.router.GET("/debug/pprof/cmdline", a.admin(pprof.Cmdline))
+
+	for _, r := range []struct {
+		method, url, body, token string
+		want                     int
+	}{
+		{"GET", "/metrics", "", "", http.StatusUnauthorized},
+		{"GET", "/metrics", "", "wrong", http.StatusUnauthorized},
+		{"GET", "/metrics", "", "noc-secret", http.StatusOK},
+		{"GET", "/api/v1/block/exists/test.com", "", "noc-secret", http.StatusOK},
+		{"GET", "/api/v1/block/set/test.com", "", "noc-secret", http.StatusForbidden},
+		{"GET", "/api/v1/purge/test.com/A", "", "noc-secret", http.StatusForbidden},
+		{"GET", "/debug/pprof/cmdline", "", "noc-secret", http.StatusForbidden},
+		{"GET", "/metrics", "", "ops-secret", http.StatusForbidden},
+		{"GET", "/api/v1/block/exists/test.com", "", "ops-secret", http.StatusOK},
+		{"GET", "/api/v1/block/set/test.com", "", "ops-secret", http.StatusOK},
+		{"POST", "/api/v1/block/remove/batch", `{"keys":["test.com"]}`, "ops-secret", http.StatusOK},
+		{"GET", "/api/v1/purge/test.com/A", "", "ops-secret", http.StatusForbidden},
+		{"GET", "/api/v1/purge/test.com/A", "", "root-secret", http.StatusOK},
+		{"GET", "/debug/pprof/cmdline", "", "root-secret", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		request := httptest.NewRequest(r.method, r.url, strings.NewReader(r.body))
+		request.RemoteAddr = "192.0.2.9:4711"
+		if r.token != "" {
+			request.Header.Set("Authorization", "Bearer "+r.token)
+		}
+		a.router.ServeHTTP(w, request)
+		if w.Code != r.want {
+			t.Fatalf("%s %s with %q: status %d, want %d", r.method, r.url, r.token, w.Code, r.want)
+		}
+	}
+	_ = a.audit.Close()
+
+	data, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []auditRecord
+	for line := range strings.Lines(string(data)) {
+		var rec auditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("audit line %q: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	// The mutating calls only, refused ones included.
+	if len(records) != 6 {
+		t.Fatalf("%d audit records, want 6:\n%s", len(records), data)
+	}
+	if r := records[0]; r.Token != "noc" || r.Status != http.StatusForbidden || r.Path != "/api/v1/block/set/test.com" || r.Client != "192.0.2.9" {
+		t.Fatalf("first record %+v", r)
+	}
+	if r := records[3]; r.Token != "ops" || r.Method != "POST" || len(r.Keys) != 1 || r.Keys[0] != "test.com" || r.Status != http.StatusOK {
+		t.Fatalf("batch record %+v", r)
+	}
+	if r := records[5]; r.Token != "bearertoken" || r.Status != http.StatusOK {
+		t.Fatalf("purge record %+v", r)
+	}
+}
+
+// issue writes a certificate for cn signed by parent (self-signed when
+// nil) to dir, returning it, its key and the path of its PEM file.
+func issue(t *testing.T, dir, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, usage x509.ExtKeyUsage) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Example"}},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent, parentKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, cn+".crt"), filepath.Join(dir, cn+".key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, certPath, keyPath
+}
+
+func Test_TLSAndSocket(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, caPath, _ := issue(t, dir, "ca", nil, nil, x509.ExtKeyUsageAny)
+	_, _, srvCert, srvKey := issue(t, dir, "server", ca, caKey, x509.ExtKeyUsageServerAuth)
+	_, _, nocCert, nocKey := issue(t, dir, "noc", ca, caKey, x509.ExtKeyUsageClientAuth)
+
+	// A unix socket path must stay short; the test's temp dir may not.
+	sockDir, err := os.MkdirTemp("", "sdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(sockDir) })
+	socket := filepath.Join(sockDir, "api.sock")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	a := New(&config.Config{
+		API:               addr,
+		BearerToken:       "root-secret",
+		APITLSCertificate: srvCert,
+		APITLSKey:         srvKey,
+		APIClientCA:       caPath,
+		APIClientCerts:    []config.APIClientCertConfig{{Name: "noc", Subject: "CN=noc,O=Example", Scopes: []string{"read-metrics"}}},
+		APISocket:         socket,
+		APISocketMode:     "0600",
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	a.Run(ctx)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	client := func(withCert bool) *http.Client {
+		conf := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+		if withCert {
+			pair, err := tls.LoadX509KeyPair(nocCert, nocKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf.Certificates = []tls.Certificate{pair}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: conf}, Timeout: 5 * time.Second}
+	}
+	get := func(c *http.Client, url, token string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		var resp *http.Response
+		for range 50 {
+			if resp, err = c.Do(req); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	base := "https://" + addr
+	if code := get(client(true), base+"/metrics", ""); code != http.StatusOK {
+		t.Fatalf("client certificate for metrics: status %d", code)
+	}
+	if code := get(client(true), base+"/api/v1/purge/example.com/A", ""); code != http.StatusForbidden {
+		t.Fatalf("client certificate for purge: status %d, want 403", code)
+	}
+	if code := get(client(false), base+"/metrics", ""); code != http.StatusUnauthorized {
+		t.Fatalf("no certificate, no token: status %d, want 401", code)
+	}
+	if code := get(client(false), base+"/api/v1/purge/example.com/A", "root-secret"); code != http.StatusOK {
+		t.Fatalf("token over TLS: status %d", code)
+	}
+	if code := get(&http.Client{Timeout: 5 * time.Second}, "http://"+addr+"/metrics", "root-secret"); code != http.StatusBadRequest {
+		t.Fatalf("plain HTTP to the TLS listener: status %d, want 400", code)
+	}
+
+	fi, err := os.Stat(socket)
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("socket %v, %v", fi, err)
+	}
+	local := &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", socket)
+		},
+	}}
+	if code := get(local, "http://sdns/api/v1/purge/example.com/A", ""); code != http.StatusOK {
+		t.Fatalf("over the socket: status %d", code)
+	}
+}
+
+func Test_Cache(t *testing.T) {
+	middleware.Reset()
+	t.Cleanup(middleware.Reset)
+
+	c := cache.New(&config.Config{CacheSize: 1024, Expire: 60})
+	t.Cleanup(c.Stop)
+	middleware.Register("cache", func(*config.Config) middleware.Handler { return c })
+	middleware.Setup(&config.Config{})
+
+	for _, name := range []string{"example.com.", "www.example.com.", "example.org."} {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(192, 0, 2, 1),
+		}}
+		c.Store().SetFromResponse(resp, false, time.Time{})
+	}
+
+	a := New(&config.Config{})
+	if a.cache != c {
+		t.Fatal("API did not pick up the cache middleware")
+	}
+	a.router.GET("/api/v1/cache", a.cacheEntries)
+	a.router.GET("/api/v1/purge/:qname/:qtype", a.purge)
+
+	get := func(url string) (int, map[string]any) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.router.ServeHTTP(w, request)
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+		return w.Code, body
+	}
+
+	code, body := get("/api/v1/cache?name=example.com&type=a")
+	if code != http.StatusOK || body["total"] != float64(2) {
+		t.Fatalf("listing: %d %v, want example.com and www.example.com", code, body)
+	}
+	entry := body["entries"].([]any)[0].(map[string]any)
+	if entry["name"] != "example.com." || entry["rcode"] != "NOERROR" || entry["dnssec"] != "insecure" || entry["ttl"].(float64) <= 0 {
+		t.Fatalf("entry %v", entry)
+	}
+	if _, body = get("/api/v1/cache?name=example.com&subtree=false"); body["total"] != float64(1) {
+		t.Fatalf("exact listing: %v", body)
+	}
+	for _, url := range []string{"/api/v1/cache?type=BOGUS", "/api/v1/cache?limit=0", "/api/v1/cache?name=bad..name", "/api/v1/cache?subtree=maybe"} {
+		if code, _ = get(url); code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d, want 400", url, code)
+		}
+	}
+
+	if code, body = get("/api/v1/purge/example.com/A"); code != http.StatusOK || body["removed"] != float64(1) {
+		t.Fatalf("exact purge: %d %v", code, body)
+	}
+	if code, body = get("/api/v1/purge/example.com/ALL?subtree=true"); code != http.StatusOK || body["removed"] != float64(1) {
+		t.Fatalf("subtree purge: %d %v", code, body)
+	}
+	if _, body = get("/api/v1/cache"); body["total"] != float64(1) {
+		t.Fatalf("after purges: %v, want example.org alone", body)
+	}
+	if code, _ = get("/api/v1/purge/example.com/BOGUS"); code != http.StatusBadRequest {
+		t.Fatalf("unknown qtype: status %d, want 400", code)
+	}
+}
+
+type fakeDNSServer struct {
+	ready   bool
+	drained int
+}
+
+func (s *fakeDNSServer) Ready() bool { return s.ready }
+
+func (s *fakeDNSServer) Drain(context.Context) error {
+	s.ready = false
+	s.drained++
+	return nil
+}
+
+type slowHandler struct{ ready bool }
+
+func (h *slowHandler) ServeDNS(ctx context.Context, ch *middleware.Chain) { ch.Next(ctx) }
+func (h *slowHandler) Name() string                                       { return "slow" }
+func (h *slowHandler) Ready() bool                                        { return h.ready }
+
+func Test_Health(t *testing.T) {
+	middleware.Reset()
+	t.Cleanup(middleware.Reset)
+
+	a := New(&config.Config{BearerToken: "secret"})
+	a.router.GET("/healthz", a.healthz)
+	a.router.GET("/readyz", a.readyz)
+
+	do := func(method, url, token string) (int, map[string]any) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		request, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		a.router.ServeHTTP(w, request)
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+		return w.Code, body
+	}
+
+	if code, body := do(http.MethodGet, "/healthz", ""); code != http.StatusOK || body["status"] != "ok" {
+		t.Fatalf("healthz: %d %v", code, body)
+	}
+
+	code, body := do(http.MethodGet, "/readyz", "")
+	if code != http.StatusServiceUnavailable || fmt.Sprint(body["waiting"]) != "[listeners pipeline]" {
+		t.Fatalf("readyz before startup: %d %v", code, body)
+	}
+
+	h := &slowHandler{}
+	middleware.Register("slow", func(*config.Config) middleware.Handler { return h })
+	middleware.Setup(&config.Config{})
+	srv := &fakeDNSServer{ready: true}
+	a.SetServer(srv)
+	a.router.POST("/api/v1/drain", a.drain)
+
+	if code, body = do(http.MethodGet, "/readyz", ""); code != http.StatusServiceUnavailable || fmt.Sprint(body["waiting"]) != "[slow]" {
+		t.Fatalf("readyz with a handler starting: %d %v", code, body)
+	}
+	h.ready = true
+	if code, body = do(http.MethodGet, "/readyz", ""); code != http.StatusOK || body["ready"] != true {
+		t.Fatalf("readyz: %d %v", code, body)
+	}
+
+	if code, _ = do(http.MethodPost, "/api/v1/drain", ""); code != http.StatusUnauthorized {
+		t.Fatalf("drain without a token: %d", code)
+	}
+	if code, body = do(http.MethodPost, "/api/v1/drain", "secret"); code != http.StatusOK || srv.drained != 1 {
+		t.Fatalf("drain: %d %v", code, body)
+	}
+	if code, body = do(http.MethodGet, "/readyz", ""); code != http.StatusServiceUnavailable || fmt.Sprint(body["waiting"]) != "[listeners]" {
+		t.Fatalf("readyz after drain: %d %v", code, body)
+	}
+}
+
+func Test_LogLevel(t *testing.T) {
+	old := zlog.Default().GetLevel()
+	t.Cleanup(func() {
+		debuglog.Revert()
+		zlog.SetLevel(old)
+	})
+	debuglog.Init(zlog.LevelInfo, zlog.DiscardWriter())
+	zlog.SetLevel(zlog.LevelInfo)
+
+	a := New(&config.Config{})
+	a.router.GET("/api/v1/loglevel", a.logLevel)
+	a.router.Handle(http.MethodPut, "/api/v1/loglevel", a.setLogLevel)
+	a.router.Handle(http.MethodDelete, "/api/v1/loglevel", a.resetLogLevel)
+
+	do := func(method, body string) (int, map[string]any) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		request, err := http.NewRequest(method, "/api/v1/loglevel", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.router.ServeHTTP(w, request)
+		var out map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s %s: %v", method, body, err)
+		}
+		return w.Code, out
+	}
+
+	code, body := do(http.MethodGet, "")
+	if code != http.StatusOK || body["level"] != "info" || body["until"] != nil {
+		t.Fatalf("initial: %d %v", code, body)
+	}
+
+	code, body = do(http.MethodPut, `{"debug":["resolver","dnssec"],"client":"192.0.2.7","duration":"5m"}`)
+	if code != http.StatusOK || body["level"] != "info" || fmt.Sprint(body["debug"]) != "[resolver dnssec]" ||
+		body["client"] != "192.0.2.7/32" || body["until"] == nil {
+		t.Fatalf("subsystems: %d %v", code, body)
+	}
+	if !debuglog.Enabled(debuglog.Resolver) || !debuglog.Filtering() {
+		t.Fatal("settings not applied")
+	}
+
+	code, body = do(http.MethodPut, `{"level":"debug"}`)
+	if code != http.StatusOK || body["level"] != "debug" || fmt.Sprint(body["debug"]) != "[]" || body["client"] != "" {
+		t.Fatalf("level: %d %v", code, body)
+	}
+	if zlog.Default().GetLevel() != zlog.LevelDebug {
+		t.Fatal("level not applied")
+	}
+
+	for _, bad := range []string{
+		`{"level":"loud"}`,
+		`{"debug":["everything"]}`,
+		`{"client":"not-an-ip"}`,
+		`{"duration":"48h"}`,
+		`{"duration":"-1m"}`,
+		`{"verbose":true}`,
+	} {
+		if code, _ = do(http.MethodPut, bad); code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d, want 400", bad, code)
+		}
+	}
+
+	code, body = do(http.MethodDelete, "")
+	if code != http.StatusOK || body["level"] != "info" || body["until"] != nil {
+		t.Fatalf("reset: %d %v", code, body)
+	}
+	if zlog.Default().GetLevel() != zlog.LevelInfo {
+		t.Fatal("reset did not restore the configured level")
+	}
+}
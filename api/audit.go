@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/semihalev/zlog/v2"
+)
+
+// auditRecord is one mutating API call, as written to the audit log.
+type auditRecord struct {
+	Time   time.Time `json:"time"`
+	Token  string    `json:"token,omitempty"`
+	Client string    `json:"client"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Query  string    `json:"query,omitempty"`
+	Keys   []string  `json:"keys,omitempty"`
+	Status int       `json:"status"`
+}
+
+// auditLog records mutating API calls to a file, or to the server log
+// when no file is configured.
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newAuditLog(path string) (*auditLog, error) {
+	l := new(auditLog)
+	if path == "" {
+		return l, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600) //nolint:gosec // G304 - path from config, admin controlled
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	return l, nil
+}
+
+func (l *auditLog) write(rec *auditRecord) {
+	if l.file == nil {
+		zlog.Info("API audit", "token", rec.Token, "client", rec.Client, "method", rec.Method,
+			"path", rec.Path, "query", rec.Query, "keys", len(rec.Keys), "status", rec.Status)
+		return
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(line); err != nil {
+		zlog.Error("API audit write failed", "file", l.file.Name(), "error", err.Error())
+	}
+}
+
+func (l *auditLog) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// statusWriter remembers the status a handler answered with.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// audited records every call of h, refused ones included, once h has
+// answered it.
+func (a *API) audited(h Handler) Handler {
+	return func(ctx *Context) {
+		sw := &statusWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = sw
+		h(ctx)
+
+		client, _, err := net.SplitHostPort(ctx.Request.RemoteAddr)
+		if err != nil {
+			client = ctx.Request.RemoteAddr
+		}
+		if viaSocket(ctx.Request) {
+			client = socketName
+		}
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		a.audit.write(&auditRecord{
+			Time:   time.Now().UTC(),
+			Token:  ctx.token,
+			Client: client,
+			Method: ctx.Request.Method,
+			Path:   ctx.Request.URL.Path,
+			Query:  ctx.Request.URL.RawQuery,
+			Keys:   ctx.auditKeys,
+			Status: sw.status,
+		})
+	}
+}
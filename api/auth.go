@@ -0,0 +1,175 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/zlog/v2"
+)
+
+// scope is a set of API areas a token may use.
+type scope uint8
+
+const (
+	scopeReadMetrics scope = 1 << iota
+	scopeReadStats
+	scopeManageBlocklist
+	scopePurgeCache
+	scopeAdmin
+)
+
+var scopeNames = map[string]scope{
+	"read-metrics":     scopeReadMetrics,
+	"read-stats":       scopeReadStats,
+	"manage-blocklist": scopeManageBlocklist,
+	"purge-cache":      scopePurgeCache,
+	"admin":            scopeAdmin,
+}
+
+// bearerTokenName names the bearertoken setting's token in the audit log,
+// and socketName a request over the API socket.
+const (
+	bearerTokenName = "bearertoken"
+	socketName      = "unix"
+)
+
+// apiToken is a configured token, kept only as its digest.
+type apiToken struct {
+	name   string
+	digest [sha256.Size]byte
+	scopes scope
+}
+
+// newTokens builds the token table: the bearer token with every scope,
+// then the named tokens. Config loading has validated the named tokens;
+// one that is still malformed is left out rather than trusted.
+func newTokens(cfg *config.Config) []apiToken {
+	var tokens []apiToken
+	if cfg.BearerToken != "" {
+		tokens = append(tokens, apiToken{
+			name:   bearerTokenName,
+			digest: sha256.Sum256([]byte(cfg.BearerToken)),
+			scopes: scopeAdmin,
+		})
+	}
+	for _, tc := range cfg.APITokens {
+		digest, err := tc.Digest()
+		if err != nil {
+			zlog.Error("API token ignored", "error", err.Error())
+			continue
+		}
+		t := apiToken{name: tc.Name, digest: digest}
+		for _, name := range tc.Scopes {
+			t.scopes |= scopeNames[name]
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens
+}
+
+// clientCert is a client certificate subject granted scopes.
+type clientCert struct {
+	name    string
+	subject string
+	scopes  scope
+}
+
+func newClientCerts(cfg *config.Config) []clientCert {
+	certs := make([]clientCert, 0, len(cfg.APIClientCerts))
+	for _, cc := range cfg.APIClientCerts {
+		c := clientCert{name: cc.Name, subject: cc.Subject}
+		for _, name := range cc.Scopes {
+			c.scopes |= scopeNames[name]
+		}
+		certs = append(certs, c)
+	}
+	return certs
+}
+
+// socketKey marks the context of a request that came in over the API
+// socket.
+type socketKey struct{}
+
+func viaSocket(r *http.Request) bool {
+	v, _ := r.Context().Value(socketKey{}).(bool)
+	return v
+}
+
+// identify returns who made the request and the scopes they hold: over
+// the socket, everything; then a verified client certificate named in
+// the config; then a bearer token.
+func (a *API) identify(r *http.Request) (string, scope, bool) {
+	if viaSocket(r) {
+		return socketName, scopeAdmin, true
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(a.certs) > 0 {
+		subject := r.TLS.VerifiedChains[0][0].Subject
+		dn := subject.String()
+		for _, c := range a.certs {
+			if c.subject == dn || c.subject == subject.CommonName {
+				return c.name, c.scopes, true
+			}
+		}
+	}
+	if t := a.lookup(r); t != nil {
+		return t.name, t.scopes, true
+	}
+	return "", 0, false
+}
+
+// lookup returns the token presented in the Authorization header, or nil.
+// Every token is compared, in constant time, whichever one matches.
+func (a *API) lookup(r *http.Request) *apiToken {
+	kind, secret, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || kind != "Bearer" || secret == "" || strings.Contains(secret, " ") {
+		return nil
+	}
+	digest := sha256.Sum256([]byte(secret))
+
+	var found *apiToken
+	for i := range a.tokens {
+		if subtle.ConstantTimeCompare(digest[:], a.tokens[i].digest[:]) == 1 {
+			found = &a.tokens[i]
+		}
+	}
+	return found
+}
+
+// checkToken admits the request if whoever made it holds any of scopes,
+// or is an admin, writing 401 for a request from no one known and 403 for
+// one without the scope. With no tokens or client certificates configured
+// every request is admitted.
+func (a *API) checkToken(ctx *Context, scopes ...scope) bool {
+	name, held, ok := a.identify(ctx.Request)
+	if !ok {
+		if len(a.tokens) == 0 && len(a.certs) == 0 {
+			return true
+		}
+		ctx.JSON(http.StatusUnauthorized, Json{"error": "unauthorized"})
+		return false
+	}
+	ctx.token = name
+
+	want := scopeAdmin
+	for _, s := range scopes {
+		want |= s
+	}
+	if held&want == 0 {
+		ctx.JSON(http.StatusForbidden, Json{"error": "forbidden"})
+		return false
+	}
+	return true
+}
+
+// admin puts an http.HandlerFunc behind an admin token.
+func (a *API) admin(h http.HandlerFunc) Handler {
+	return func(ctx *Context) {
+		if !a.checkToken(ctx, scopeAdmin) {
+			return
+		}
+		h(ctx.Writer, ctx.Request)
+	}
+}
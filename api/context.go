@@ -11,6 +11,12 @@ type (
 		Writer  http.ResponseWriter
 		Handler Handler
 		Params  *Params
+
+		// token names who the request was admitted as — a token, a
+		// client certificate or the socket — and auditKeys the keys of a
+		// batch call, for the audit log.
+		token     string
+		auditKeys []string
 	}
 
 	Handler func(ctx *Context)
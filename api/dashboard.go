@@ -0,0 +1,37 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// dashboardFS is the web dashboard: a static page that reads
+// everything it shows from the API, with the bearer token the user gives
+// it, so the page itself carries nothing that needs protecting.
+//
+//go:embed dashboard
+var dashboardFS embed.FS
+
+var dashboardHandler = func() http.Handler {
+	sub, err := fs.Sub(dashboardFS, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/dashboard/", http.FileServerFS(sub))
+}()
+
+func (a *API) dashboardRedirect(ctx *Context) {
+	http.Redirect(ctx.Writer, ctx.Request, "/dashboard/", http.StatusMovedPermanently)
+}
+
+// dashboardFiles serves the dashboard's files. They are not behind the
+// token, which the page asks for instead: a browser does not send a
+// bearer token on navigation.
+func (a *API) dashboardFiles(ctx *Context) {
+	h := ctx.Writer.Header()
+	h.Set("Content-Security-Policy", "default-src 'self'; img-src 'self' data:; frame-ancestors 'none'")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Referrer-Policy", "no-referrer")
+	dashboardHandler.ServeHTTP(ctx.Writer, ctx.Request)
+}
@@ -0,0 +1,373 @@
+"use strict";
+
+// The dashboard talks to the same API as any other client, sending the
+// bearer token kept in local storage when the server asks for one.
+
+const $ = (id) => document.getElementById(id);
+const tokenKey = "sdns.token";
+const tailMax = 200;
+const refreshEvery = 10000;
+
+let win = "24h";
+let timer = null;
+let tail = null;
+
+class HTTPError extends Error {
+  constructor(status, message) {
+    super(message);
+    this.status = status;
+  }
+}
+
+async function api(path, opts = {}) {
+  const headers = new Headers(opts.headers);
+  const token = localStorage.getItem(tokenKey);
+  if (token) headers.set("Authorization", "Bearer " + token);
+  const resp = await fetch(path, { ...opts, headers });
+  if (resp.status === 401) {
+    signedOut();
+    throw new HTTPError(401, "unauthorized");
+  }
+  if (!resp.ok) {
+    let msg = resp.statusText;
+    try { msg = (await resp.json()).error || msg; } catch (_) {}
+    throw new HTTPError(resp.status, msg);
+  }
+  return opts.raw ? resp : resp.json();
+}
+
+// section runs load for a panel, greying the panel out when the server
+// has the feature turned off or the token may not use it.
+async function section(id, load) {
+  const el = $(id);
+  try {
+    await load();
+    el.classList.remove("disabled", "forbidden");
+  } catch (err) {
+    if (err.status === 404 || err.status === 403) {
+      el.classList.add(err.status === 404 ? "disabled" : "forbidden");
+      return;
+    }
+    if (err.status !== 401) status(err.message);
+  }
+}
+
+function status(msg) {
+  $("status").textContent = msg;
+}
+
+function el(tag, text, cls) {
+  const e = document.createElement(tag);
+  if (text !== undefined) e.textContent = text;
+  if (cls) e.className = cls;
+  return e;
+}
+
+function row(cells, cls) {
+  const tr = el("tr", undefined, cls);
+  for (const c of cells) {
+    if (c instanceof Node) {
+      const td = el("td");
+      td.append(c);
+      tr.append(td);
+    } else if (typeof c === "number") {
+      tr.append(el("td", fmt(c), "num"));
+    } else {
+      const td = el("td", c ?? "");
+      td.title = c ?? "";
+      tr.append(td);
+    }
+  }
+  return tr;
+}
+
+function fmt(n) {
+  return Number.isInteger(n) ? n.toLocaleString() : n.toFixed(1);
+}
+
+function fill(id, rows) {
+  $(id).replaceChildren(...rows);
+}
+
+function when(t) {
+  return t ? new Date(t).toLocaleString() : "";
+}
+
+// Overview, graph and top lists.
+
+async function loadStats() {
+  const q = "window=" + win;
+  const [sum, series, domains, blocked, clients] = await Promise.all([
+    api("/api/v1/stats/summary?" + q),
+    api("/api/v1/stats/series?" + q),
+    api("/api/v1/stats/domains?" + q),
+    api("/api/v1/stats/blocked?" + q),
+    api("/api/v1/stats/clients?" + q),
+  ]);
+
+  $("queries").textContent = fmt(sum.queries);
+  $("blocked").textContent = fmt(sum.blocked);
+  $("nxdomain").textContent = fmt(sum.nxdomain);
+  $("cache-ratio").textContent = sum.queries ? (100 * sum.cache_hits / sum.queries).toFixed(1) + "%" : "–";
+
+  graph(series.series);
+  fill("top-domains", domains.domains.map((d) => row([d.name, d.queries])));
+  fill("top-blocked", blocked.domains.map((d) => row([d.name, d.blocked])));
+  fill("top-clients", clients.clients.map((c) => row([c.client, c.queries, c.blocked, c.nxdomain])));
+}
+
+const svgNS = "http://www.w3.org/2000/svg";
+
+function svg(tag, attrs) {
+  const e = document.createElementNS(svgNS, tag);
+  for (const [k, v] of Object.entries(attrs)) e.setAttribute(k, v);
+  return e;
+}
+
+// graph draws one bar per bucket, the blocked share overlaid in red and
+// the cache hits as a line.
+function graph(points) {
+  const w = 600, h = 160, top = 12, bottom = 14;
+  const max = Math.max(1, ...points.map((p) => p.queries));
+  const bw = w / points.length;
+  const y = (v) => h - bottom - (h - top - bottom) * v / max;
+  const nodes = [];
+  const line = [];
+
+  points.forEach((p, i) => {
+    const x = i * bw;
+    nodes.push(svg("rect", { class: "queries", x: x + 1, y: y(p.queries), width: Math.max(1, bw - 2), height: h - bottom - y(p.queries) }));
+    if (p.blocked) {
+      nodes.push(svg("rect", { class: "blocked", x: x + 1, y: y(p.blocked), width: Math.max(1, bw - 2), height: h - bottom - y(p.blocked) }));
+    }
+    line.push((x + bw / 2).toFixed(1) + "," + y(p.cache_hits).toFixed(1));
+  });
+  nodes.push(svg("polyline", { class: "hits", points: line.join(" ") }));
+
+  const peak = svg("text", { x: 2, y: 10 });
+  peak.textContent = fmt(max) + " per bucket";
+  nodes.push(peak);
+  if (points.length) {
+    const first = svg("text", { x: 2, y: h - 2 });
+    first.textContent = new Date(points[0].start).toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" });
+    const last = svg("text", { x: w - 2, y: h - 2, "text-anchor": "end" });
+    last.textContent = "now";
+    nodes.push(first, last);
+  }
+  $("rate").replaceChildren(...nodes);
+}
+
+// Live query tail, read from the query log's event stream. fetch is used
+// rather than EventSource so the bearer token can be sent.
+
+function tailQuery() {
+  const f = new FormData($("tail-form"));
+  const q = new URLSearchParams();
+  for (const [k, v] of f) if (v) q.set(k, v);
+  return q.toString();
+}
+
+function stopTail() {
+  if (tail) tail.abort();
+  tail = null;
+  $("tail-pause").textContent = "Resume";
+}
+
+async function startTail() {
+  stopTail();
+  const ctl = new AbortController();
+  tail = ctl;
+  $("tail-pause").textContent = "Pause";
+  $("tail").classList.remove("disabled", "forbidden");
+
+  let resp;
+  try {
+    resp = await api("/api/v1/querylog/stream?" + tailQuery(), { signal: ctl.signal, raw: true });
+  } catch (err) {
+    if (err.status === 404) $("tail").classList.add("disabled");
+    else if (err.status === 403) $("tail").classList.add("forbidden");
+    else if (err.name !== "AbortError" && err.status !== 401) status(err.message);
+    if (tail === ctl) stopTail();
+    return;
+  }
+
+  const reader = resp.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buf = "";
+  try {
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) break;
+      buf += value;
+      let i;
+      while ((i = buf.indexOf("\n\n")) >= 0) {
+        event(buf.slice(0, i));
+        buf = buf.slice(i + 2);
+      }
+    }
+  } catch (err) {
+    if (err.name !== "AbortError") status("live queries: " + err.message);
+  }
+  if (tail === ctl) stopTail();
+}
+
+function event(block) {
+  for (const line of block.split("\n")) {
+    if (!line.startsWith("data: ")) continue;
+    const e = JSON.parse(line.slice(6));
+    const via = e.blocked ? "blocked" : e.cache_hit ? "cache" : e.upstream || "";
+    const tbody = $("tail-rows");
+    tbody.prepend(row([
+      new Date(e.time).toLocaleTimeString(),
+      e.client_ip,
+      e.qname,
+      e.qtype,
+      e.rcode,
+      e.latency_ms,
+      via,
+      (e.answer || []).join(" "),
+    ], e.blocked ? "blocked" : undefined));
+    while (tbody.rows.length > tailMax) tbody.deleteRow(-1);
+  }
+}
+
+// Blocklist.
+
+async function loadSources() {
+  const res = await api("/api/v1/block/sources");
+  $("block-total").textContent = "(" + fmt(res.total) + " entries)";
+  fill("block-sources", res.sources.map((s) => row([s.name, s.kind, s.entries, when(s.updated), s.error])));
+}
+
+async function searchBlock() {
+  const q = new FormData($("block-search")).get("q");
+  const res = await api("/api/v1/block/search?q=" + encodeURIComponent(q) + "&limit=100");
+  $("block-found").textContent = res.total > res.entries.length
+    ? "showing " + res.entries.length + " of " + fmt(res.total)
+    : fmt(res.total) + " found";
+  fill("block-rows", res.entries.map((key) => {
+    const rm = el("button", "remove", "link");
+    rm.type = "button";
+    rm.addEventListener("click", () => removeBlock(key));
+    return row([key, rm]);
+  }));
+}
+
+async function removeBlock(key) {
+  try {
+    await api("/api/v1/block/remove/" + encodeURIComponent(key));
+    await Promise.all([searchBlock(), loadSources()]);
+  } catch (err) {
+    status(err.message);
+  }
+}
+
+// Upstreams: the forwarder's servers when it forwards, otherwise the
+// root servers the resolver is using.
+
+async function loadUpstreams() {
+  let rows = [];
+  let found = false;
+  try {
+    const res = await api("/api/v1/upstreams");
+    found = true;
+    rows = res.upstreams.map((u) => row([u.server + " (" + u.proto + ")", "forward", health(u.health), u.rtt_ms, u.last_error]));
+  } catch (err) {
+    if (err.status !== 404) throw err;
+  }
+  if (!found) {
+    const res = await api("/api/v1/authorities?zone=.");
+    const root = res.delegations.find((d) => d.zone === ".");
+    rows = (root ? root.servers : []).map((s) => row([s.addr, ".", health(s.health), s.rtt_ms, s.last_error]));
+  }
+  fill("upstream-rows", rows);
+}
+
+function health(h) {
+  return el("span", h, "health-" + h);
+}
+
+// Wiring.
+
+function refresh() {
+  section("overview", loadStats).then(() => {
+    for (const id of ["graph", "tops"]) {
+      for (const cls of ["disabled", "forbidden"]) $(id).classList.toggle(cls, $("overview").classList.contains(cls));
+    }
+  });
+  section("blocklist", loadSources);
+  section("upstreams", loadUpstreams);
+  status("updated " + new Date().toLocaleTimeString());
+}
+
+function signedIn() {
+  $("login").hidden = true;
+  $("main").hidden = false;
+  $("logout").hidden = !localStorage.getItem(tokenKey);
+  refresh();
+  clearInterval(timer);
+  timer = setInterval(refresh, refreshEvery);
+  startTail();
+}
+
+function signedOut() {
+  clearInterval(timer);
+  stopTail();
+  $("main").hidden = true;
+  $("login").hidden = false;
+  $("logout").hidden = true;
+  if (localStorage.getItem(tokenKey)) $("login-error").textContent = "The token was not accepted.";
+}
+
+function on(id, type, fn) {
+  $(id).addEventListener(type, (ev) => {
+    if (type === "submit") ev.preventDefault();
+    Promise.resolve(fn(ev)).catch((err) => { if (err.status !== 401) status(err.message); });
+  });
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  on("login", "submit", () => {
+    localStorage.setItem(tokenKey, new FormData($("login")).get("token"));
+    $("login-error").textContent = "";
+    signedIn();
+  });
+  on("logout", "click", () => {
+    localStorage.removeItem(tokenKey);
+    signedOut();
+    $("login-error").textContent = "";
+  });
+
+  for (const b of document.querySelectorAll("#window button")) {
+    b.addEventListener("click", () => {
+      win = b.dataset.window;
+      for (const o of document.querySelectorAll("#window button")) o.classList.toggle("active", o === b);
+      section("overview", loadStats);
+    });
+  }
+
+  on("tail-form", "submit", () => {
+    $("tail-rows").replaceChildren();
+    startTail();
+  });
+  on("tail-pause", "click", () => (tail ? stopTail() : startTail()));
+
+  on("block-add", "submit", async () => {
+    const form = $("block-add");
+    const key = new FormData(form).get("key").trim();
+    await api("/api/v1/block/set/" + encodeURIComponent(key));
+    form.reset();
+    status("blocked " + key);
+    await loadSources();
+  });
+  on("block-search", "submit", searchBlock);
+
+  on("purge-form", "submit", async () => {
+    const f = new FormData($("purge-form"));
+    const qname = f.get("qname").trim();
+    const subtree = f.get("subtree") ? "?subtree=true" : "";
+    const res = await api("/api/v1/purge/" + encodeURIComponent(qname) + "/" + f.get("qtype") + subtree);
+    $("purge-result").textContent = "purged " + fmt(res.removed) + " entries for " + qname + (subtree ? " and below" : "");
+  });
+
+  signedIn();
+});
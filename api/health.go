@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/semihalev/sdns/middleware"
+)
+
+// DNSServer is the part of the DNS server the health endpoints need: main
+// hands it over with SetServer once the listeners are bound.
+type DNSServer interface {
+	// Ready reports whether every critical listener is serving and the
+	// server is not draining.
+	Ready() bool
+	// Drain takes the server out of service: readiness turns off, the
+	// connection-oriented listeners stop accepting and finish what they
+	// have in hand.
+	Drain(ctx context.Context) error
+}
+
+// SetServer gives the API the DNS server to report on and drain. Without
+// one, /readyz waits on "listeners" and there is no drain endpoint.
+func (a *API) SetServer(s DNSServer) {
+	a.server = s
+}
+
+// healthz reports that the process is alive and serving HTTP. It carries
+// no token: orchestrators probe it without one, and it tells nothing.
+func (a *API) healthz(ctx *Context) {
+	ctx.JSON(http.StatusOK, Json{"status": "ok"})
+}
+
+// readyz reports whether the server should be sent traffic: the listeners
+// are bound, the pipeline is set up and every handler with startup work —
+// root priming or the forwarders answering, the blocklists, the
+// kubernetes informers — has finished it. What it is still waiting on is
+// listed, so a pod stuck out of service says why.
+func (a *API) readyz(ctx *Context) {
+	var waiting []string
+	if a.server == nil || !a.server.Ready() {
+		waiting = append(waiting, "listeners")
+	}
+	if !middleware.Ready() {
+		waiting = append(waiting, "pipeline")
+	}
+	waiting = append(waiting, middleware.GlobalPipeline().NotReady()...)
+
+	if len(waiting) > 0 {
+		ctx.JSON(http.StatusServiceUnavailable, Json{"ready": false, "waiting": waiting})
+		return
+	}
+	ctx.JSON(http.StatusOK, Json{"ready": true})
+}
+
+// drain takes the server out of service ahead of a stop, returning once
+// the in-flight TCP, DoT, DoH and DoQ queries have finished.
+func (a *API) drain(ctx *Context) {
+	if !a.checkToken(ctx, scopeAdmin) {
+		return
+	}
+
+	if err := a.server.Drain(ctx.Request.Context()); err != nil {
+		ctx.JSON(http.StatusInternalServerError, Json{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, Json{"success": true})
+}
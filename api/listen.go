@@ -0,0 +1,76 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/semihalev/sdns/server"
+)
+
+// serverTLS returns the API listener's TLS config, or nil to serve plain
+// HTTP. The certificate is the CertManager's, so it follows rotation; the
+// manager must be stopped with the listener.
+func (a *API) serverTLS() (*tls.Config, *server.CertManager, error) {
+	if a.tlsCert == "" {
+		return nil, nil, nil
+	}
+	cm, err := server.NewCertManager(a.tlsCert, a.tlsKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	conf := cm.GetTLSConfig()
+	if a.clientCA == "" {
+		return conf, cm, nil
+	}
+
+	pem, err := os.ReadFile(a.clientCA)
+	if err != nil {
+		cm.Stop()
+		return nil, nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		cm.Stop()
+		return nil, nil, fmt.Errorf("no certificates in %s", a.clientCA)
+	}
+	conf.ClientCAs = pool
+	conf.ClientAuth = tls.VerifyClientCertIfGiven
+	if a.certRequired {
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, cm, nil
+}
+
+// listenAPI listens on the API's address, or takes the socket passed to
+// the process for it.
+func listenAPI(addr string) (net.Listener, error) {
+	if ln := server.InheritedListener(apiSocketName); ln != nil {
+		return ln, nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenSocket listens on the API's unix socket with the given mode. A
+// socket file left by a run that did not shut down is replaced; any other
+// file in the way is an error. A socket passed to the process is taken
+// as it is.
+func listenSocket(path string, mode os.FileMode) (net.Listener, error) {
+	if ln := server.InheritedListener(apiUnixSocketName); ln != nil {
+		return ln, nil
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
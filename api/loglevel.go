@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/semihalev/sdns/internal/debuglog"
+)
+
+const (
+	// maxLogLevelBody caps a log settings change; it is a few fields.
+	maxLogLevelBody = 4 << 10 // 4 KiB
+
+	// defaultLogLevelDuration is how long a change lasts when the request
+	// does not say.
+	defaultLogLevelDuration = 15 * time.Minute
+
+	// maxLogLevelDuration bounds how long a change can last: debug output
+	// left on by a forgotten session must end on its own.
+	maxLogLevelDuration = 24 * time.Hour
+)
+
+// logLevelRequest is the wire format for PUT /api/v1/loglevel.
+type logLevelRequest struct {
+	Level    string   `json:"level"`
+	Debug    []string `json:"debug"`
+	Client   string   `json:"client"`
+	DebugNS  bool     `json:"debugns"`
+	Duration string   `json:"duration"`
+}
+
+// logLevel reports the log level in force, the subsystems with debug
+// output switched on, the client filter, and when a change reverts.
+func (a *API) logLevel(ctx *Context) {
+	if !a.checkToken(ctx, scopeReadStats) {
+		return
+	}
+
+	writeLogLevel(ctx)
+}
+
+// setLogLevel changes the log settings until the duration runs out, when
+// the configured level returns and the switches clear. Every field is
+// optional: the level defaults to the configured one, so a request naming
+// only subsystems leaves the rest of the server at its usual verbosity.
+func (a *API) setLogLevel(ctx *Context) {
+	if !a.checkToken(ctx, scopeAdmin) {
+		return
+	}
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxLogLevelBody)
+	dec := json.NewDecoder(ctx.Request.Body)
+	dec.DisallowUnknownFields()
+
+	var req logLevelRequest
+	if err := dec.Decode(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, Json{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	s := debuglog.Settings{Level: debuglog.Configured(), DebugNS: req.DebugNS}
+	if req.Level != "" {
+		lvl, ok := debuglog.ParseLevel(req.Level)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, Json{"error": "invalid level: " + req.Level})
+			return
+		}
+		s.Level = lvl
+	}
+	for _, name := range req.Debug {
+		sub, ok := debuglog.ParseSubsystem(name)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, Json{"error": "invalid subsystem: " + name})
+			return
+		}
+		s.Subsystems |= sub
+	}
+	if req.Client != "" {
+		p, ok := parseClient(req.Client)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, Json{"error": "invalid client: " + req.Client})
+			return
+		}
+		s.Client = p
+	}
+	d := defaultLogLevelDuration
+	if req.Duration != "" {
+		var err error
+		d, err = time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 || d > maxLogLevelDuration {
+			ctx.JSON(http.StatusBadRequest, Json{"error": "invalid duration: " + req.Duration + " (up to " + maxLogLevelDuration.String() + ")"})
+			return
+		}
+	}
+
+	ctx.auditKeys = []string{
+		"level=" + debuglog.LevelName(s.Level),
+		"debug=" + s.Subsystems.String(),
+		"client=" + clientFilter(s.Client),
+		"debugns=" + strconv.FormatBool(s.DebugNS),
+		"duration=" + d.String(),
+	}
+	debuglog.Apply(s, d)
+	writeLogLevel(ctx)
+}
+
+// resetLogLevel ends a change now.
+func (a *API) resetLogLevel(ctx *Context) {
+	if !a.checkToken(ctx, scopeAdmin) {
+		return
+	}
+
+	debuglog.Revert()
+	writeLogLevel(ctx)
+}
+
+func writeLogLevel(ctx *Context) {
+	s, until := debuglog.Current()
+	out := Json{
+		"level":      debuglog.LevelName(s.Level),
+		"configured": debuglog.LevelName(debuglog.Configured()),
+		"debug":      s.Subsystems.Names(),
+		"client":     clientFilter(s.Client),
+		"debugns":    s.DebugNS,
+	}
+	if !until.IsZero() {
+		out["until"] = until.UTC()
+	}
+	ctx.JSON(http.StatusOK, out)
+}
+
+// parseClient reads a client filter: an address, or a prefix for a
+// network of clients.
+func parseClient(v string) (netip.Prefix, bool) {
+	if strings.Contains(v, "/") {
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return netip.Prefix{}, false
+		}
+		p = p.Masked()
+		if p.Addr().Is4In6() {
+			return netip.Prefix{}, false
+		}
+		return p, true
+	}
+	addr, err := netip.ParseAddr(v)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), true
+}
+
+func clientFilter(p netip.Prefix) string {
+	if !p.IsValid() {
+		return ""
+	}
+	return p.String()
+}
@@ -28,7 +28,7 @@ type Router struct {
 var extraHeaders = map[string]string{
 	"Server":                       "sdns",
 	"Access-Control-Allow-Origin":  "*",
-	"Access-Control-Allow-Methods": "GET,POST",
+	"Access-Control-Allow-Methods": "GET,POST,PUT,DELETE",
 	"Cache-Control":                "no-cache, no-store, no-transform, must-revalidate, private, max-age=0",
 	"Pragma":                       "no-cache",
 }
@@ -112,6 +112,8 @@ func (rt *Router) getContext(w http.ResponseWriter, r *http.Request) *Context {
 	ctx.Request = r
 	ctx.Writer = w
 	ctx.Handler = nil
+	ctx.token = ""
+	ctx.auditKeys = nil
 	(*ctx.Params) = (*ctx.Params)[:0]
 
 	return ctx
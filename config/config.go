@@ -3,18 +3,22 @@ package config
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/miekg/dns"
 	"github.com/semihalev/sdns/internal/dnsclient"
+	"github.com/semihalev/sdns/internal/dnssecalg"
 	"github.com/semihalev/zlog/v2"
 )
 
@@ -31,10 +35,17 @@ type Config struct {
 	DNSSEC           string
 	RFC8198          *bool `toml:"rfc8198"` // nil is default-on for backward compatibility
 	RFC9520          *bool `toml:"rfc9520"` // nil is default-on; false is an emergency kill switch
+	RFC8145          *bool `toml:"rfc8145"` // nil is default-on; root key-tag signalling
+	RFC8509          *bool `toml:"rfc8509"` // nil is default-on; root key sentinel answers
 	RootKeys         []string
 	FallbackServers  []string
 	ForwarderServers []string
 	AccessList       []string
+	// AccessIdentities are the client certificate identities allowed to
+	// query. A client with a verified certificate is checked against
+	// them instead of AccessList, when there are any. A device ID,
+	// listed as "device:<id>", is checked as well as AccessList.
+	AccessIdentities []string `toml:"accessidentities"`
 	LogLevel         string
 	AccessLog        string
 	Bind             string
@@ -43,8 +54,11 @@ type Config struct {
 	BindDOQ          string
 	TLSCertificate   string
 	TLSPrivateKey    string
+	DDR              *bool    `toml:"ddr"`       // nil is default-on; RFC 9462 designated resolver answers
+	DDRNames         []string `toml:"ddr_names"` // resolver names advertised; default the certificate's
 	API              string
 	BearerToken      string //nolint:gosec // G117 - not a hardcoded credential, loaded from config file
+	Dashboard        bool
 	Nullroute        string
 	Nullroutev6      string
 	HostsFile        string
@@ -62,9 +76,72 @@ type Config struct {
 	Blocklist        []string
 	Whitelist        []string
 	Chaos            bool
-	QnameMinLevel    int `toml:"qname_min_level"`
+	QnameMinLevel    int  `toml:"qname_min_level"`
+	QnameCase0x20    bool `toml:"qname_0x20"`
 	EmptyZones       []string
 
+	// APITokens are named API tokens, each limited to its scopes. The
+	// bearer token, when set, is one more token with every scope.
+	APITokens []APITokenConfig `toml:"api_tokens"`
+
+	// APIAuditLog is the file every mutating API call is recorded in,
+	// one JSON line per call. Empty records them in the server log.
+	APIAuditLog string `toml:"api_audit_log"`
+
+	// TLSClientCA verifies the client certificates DoT, DoH and DoQ
+	// clients present; their identity then keys the access list, rate
+	// limit, views and query log. TLSClientCertRequired refuses a client
+	// without one.
+	TLSClientCA           string `toml:"tlsclientca"`
+	TLSClientCertRequired bool   `toml:"tlsclientcertrequired"`
+
+	// DeviceIDs name the devices that identify themselves without a
+	// certificate: by a DoH path, /dns-query/<id>, or by the TLS server
+	// name, <id>.<DeviceDomain>. The ID stands in for a certificate
+	// identity; one not listed is refused.
+	DeviceIDs    []string `toml:"deviceids"`
+	DeviceDomain string   `toml:"devicedomain"`
+
+	// APITLSCertificate and APITLSKey serve the API over HTTPS, reloaded
+	// as the files change like the DNS listeners' certificate. With
+	// APIClientCA, client certificates it signed are verified, and those
+	// named in APIClientCerts are granted their scopes;
+	// APIClientCertRequired refuses a connection without one.
+	APITLSCertificate     string                `toml:"api_tls_certificate"`
+	APITLSKey             string                `toml:"api_tls_key"`
+	APIClientCA           string                `toml:"api_client_ca"`
+	APIClientCertRequired bool                  `toml:"api_client_cert_required"`
+	APIClientCerts        []APIClientCertConfig `toml:"api_client_certs"`
+
+	// APISocket is a unix socket the API also listens on, created with
+	// APISocketMode (octal, default 0660). Its permissions are its access
+	// control: a request over it may do everything.
+	APISocket     string `toml:"api_socket"`
+	APISocketMode string `toml:"api_socket_mode"`
+
+	// BindUnix is a unix stream socket serving DNS, framed as over TCP,
+	// to local clients, created with BindUnixMode (octal, default 0660).
+	BindUnix     string `toml:"bindunix"`
+	BindUnixMode string `toml:"bindunixmode"`
+
+	// BindDNSCrypt serves DNSCrypt v2 over UDP and TCP as the provider
+	// DNSCryptProvider (default 2.dnscrypt-cert.sdns), whose Ed25519
+	// signing key is kept in Directory.
+	BindDNSCrypt     string `toml:"binddnscrypt"`
+	DNSCryptProvider string `toml:"dnscryptprovider"`
+
+	// ODoH serves as an Oblivious DoH target (RFC 9230) on the DoH
+	// listeners, whose X25519 key is kept in Directory. ODoHRelayTargets
+	// are the target hosts the DoH listeners relay queries to as an
+	// Oblivious DoH relay; none leaves the relay off.
+	ODoH             bool     `toml:"odoh"`
+	ODoHRelayTargets []string `toml:"odohrelaytargets"`
+
+	// Listeners are DNS endpoints served besides the bind addresses,
+	// each with its own overrides of the access list, client rate
+	// limit, view and recursion, and its own TLS certificate.
+	Listeners []ListenerConfig `toml:"listeners"`
+
 	// Views are per-client static answers, evaluated in order. A
 	// query whose source IP falls in a view's Sources gets that
 	// view's Records as the response; non-matching queries fall
@@ -84,6 +161,10 @@ type Config struct {
 	DomainMetrics      bool
 	DomainMetricsLimit int
 
+	// ACME obtains and renews the DNS listeners' certificate from an
+	// ACME CA in place of TLSCertificate and TLSPrivateKey.
+	ACME ACMEConfig `toml:"acme"`
+
 	// Kubernetes middleware configuration as a section
 	Kubernetes KubernetesConfig `toml:"kubernetes"`
 
@@ -101,11 +182,27 @@ type Config struct {
 	// so the on-disk schema only bumps once.
 	ECS ECSConfig `toml:"ecs"`
 
+	// DNSSECPolicy is the validator's RFC 8624 algorithm table: which
+	// DNSKEY algorithms and DS digest types count as supported.
+	DNSSECPolicy DNSSECPolicyConfig `toml:"dnssec_policy"`
+
 	// RecursionFirewall bounds aggregate work across one recursive
 	// request tree. Shadow mode records limit crossings without
 	// changing responses; enforce mode terminates over-budget work.
 	RecursionFirewall RecursionFirewallConfig `toml:"recursion_firewall"`
 
+	// QueryLog is the structured query log: one JSON or logfmt record
+	// per client query, to rotated files, syslog or stdout.
+	QueryLog QueryLogConfig `toml:"querylog"`
+
+	// Stats keeps rolling per-domain and per-client query statistics
+	// for the stats API.
+	Stats StatsConfig `toml:"stats"`
+
+	// Tracing exports OpenTelemetry spans for client requests, the
+	// middleware that served them and the resolver's upstream work.
+	Tracing TracingConfig `toml:"tracing"`
+
 	Plugins map[string]Plugin
 
 	CookieSecret string
@@ -162,6 +259,29 @@ func (c *Config) RFC9520Enabled() bool {
 	return c == nil || c.RFC9520 == nil || *c.RFC9520
 }
 
+// RFC8145Enabled reports whether the resolver signals the key tags of its
+// root trust anchors with a periodic _ta-XXXX query (RFC 8145 §5). Omission
+// is default-on, as the root operators rely on the signal to judge rollover
+// readiness.
+func (c *Config) RFC8145Enabled() bool {
+	return c == nil || c.RFC8145 == nil || *c.RFC8145
+}
+
+// RFC8509Enabled reports whether root-key-sentinel-is-ta / -not-ta query
+// names are answered from the trust anchor store (RFC 8509). Omission is
+// default-on so public sentinel test pages see this resolver's anchors.
+func (c *Config) RFC8509Enabled() bool {
+	return c == nil || c.RFC8509 == nil || *c.RFC8509
+}
+
+// DDREnabled reports whether _dns.resolver.arpa SVCB queries are answered
+// with the encrypted listeners (RFC 9462). Omission is default-on: the
+// answer advertises only what is already serving, and a resolver with no
+// DoT, DoH or DoQ listener answers with no records.
+func (c *Config) DDREnabled() bool {
+	return c == nil || c.DDR == nil || *c.DDR
+}
+
 // ViewConfig describes a single per-client static-answer view.
 // Zone is a free-form label that names the view in logs and
 // errors. Networks are CIDR strings; a query is dispatched to
@@ -172,7 +292,333 @@ func (c *Config) RFC9520Enabled() bool {
 type ViewConfig struct {
 	Zone     string
 	Networks []string
-	Answers  []string
+	// Identities match clients by their verified certificate identity,
+	// wherever they connect from, or by a device ID as "device:<id>".
+	Identities []string
+	Answers    []string
+}
+
+// ListenerConfig is one [[listeners]] entry: a DNS endpoint on Addr
+// speaking Proto — "dns" (UDP and TCP), "udp", "tcp", "tls", "doh"
+// (HTTP/2 and HTTP/3), "doq" or "dnscrypt" (UDP and TCP). The other
+// fields override the global settings for the clients of this endpoint
+// only:
+//
+//   - AccessList replaces accesslist, and AccessIdentities
+//     accessidentities.
+//   - RateLimit replaces clientratelimit; a negative value turns the
+//     client rate limit off.
+//   - View names the [[views]] entry that answers every client of the
+//     endpoint, whatever its networks say.
+//   - Recursion "refuse" answers REFUSED to anything the cache,
+//     resolver or forwarder would answer; "allow" or empty leaves
+//     recursion on.
+//   - TLSCertificate and TLSPrivateKey replace the global certificate.
+//   - TLSClientCA and TLSClientCertRequired replace the global client
+//     certificate settings.
+//
+// Name labels the endpoint in logs and metrics; it defaults to
+// Proto://Addr.
+type ListenerConfig struct {
+	Name           string   `toml:"name"`
+	Proto          string   `toml:"proto"`
+	Addr           string   `toml:"addr"`
+	AccessList     []string `toml:"accesslist"`
+	RateLimit      int      `toml:"ratelimit"`
+	View           string   `toml:"view"`
+	Recursion      string   `toml:"recursion"`
+	TLSCertificate string   `toml:"tlscertificate"`
+	TLSPrivateKey  string   `toml:"tlsprivatekey"`
+
+	AccessIdentities      []string `toml:"accessidentities"`
+	TLSClientCA           string   `toml:"tlsclientca"`
+	TLSClientCertRequired bool     `toml:"tlsclientcertrequired"`
+}
+
+// ListenerProtos are the protocols a [[listeners]] entry may speak.
+var ListenerProtos = []string{"dns", "udp", "tcp", "tls", "doh", "doq", "dnscrypt"}
+
+// Label returns the name the listener goes by in logs and metrics.
+func (l ListenerConfig) Label() string {
+	if l.Name != "" {
+		return l.Name
+	}
+	return l.Proto + "://" + l.Addr
+}
+
+// RefuseRecursion reports whether the listener refuses recursion.
+func (l ListenerConfig) RefuseRecursion() bool {
+	return l.Recursion == "refuse"
+}
+
+// ValidateListeners checks the [[listeners]] entries: a known protocol
+// and an address, a recursion policy there is, a certificate given with
+// its key, a client CA for a required client certificate, a view that
+// exists, and no label used twice.
+func (c *Config) ValidateListeners() error {
+	if c.TLSClientCertRequired && c.TLSClientCA == "" {
+		return fmt.Errorf("tlsclientcertrequired needs tlsclientca")
+	}
+	if _, err := c.UnixMode(); err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(c.Listeners))
+	for _, l := range c.Listeners {
+		label := l.Label()
+		if !slices.Contains(ListenerProtos, l.Proto) {
+			return fmt.Errorf("listener %q: unknown proto %q", label, l.Proto)
+		}
+		if l.Addr == "" {
+			return fmt.Errorf("listener %q has no addr", label)
+		}
+		if seen[label] {
+			return fmt.Errorf("listener %q is defined twice", label)
+		}
+		seen[label] = true
+		if l.Recursion != "" && l.Recursion != "allow" && l.Recursion != "refuse" {
+			return fmt.Errorf("listener %q: recursion must be allow or refuse, not %q", label, l.Recursion)
+		}
+		if (l.TLSCertificate == "") != (l.TLSPrivateKey == "") {
+			return fmt.Errorf("listener %q: tlscertificate and tlsprivatekey must be set together", label)
+		}
+		if l.TLSClientCertRequired && l.TLSClientCA == "" && c.TLSClientCA == "" {
+			return fmt.Errorf("listener %q: tlsclientcertrequired needs tlsclientca", label)
+		}
+		if l.View != "" && !slices.ContainsFunc(c.Views, func(v ViewConfig) bool { return v.Zone == l.View }) {
+			return fmt.Errorf("listener %q: no view named %q", label, l.View)
+		}
+	}
+	return nil
+}
+
+// ValidateDevices checks the device IDs: each a DNS label, so it can
+// stand in a server name as well as a path, or such a label's non-empty
+// prefix ending in "*" — a bare "*" would know every label as a device;
+// and a device domain only with IDs to find under it.
+func (c *Config) ValidateDevices() error {
+	if c.DeviceDomain != "" && len(c.DeviceIDs) == 0 {
+		return fmt.Errorf("devicedomain needs deviceids")
+	}
+	for _, id := range c.DeviceIDs {
+		label := strings.TrimSuffix(id, "*")
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("device id %q is not a DNS label", id)
+		}
+		for _, r := range label {
+			if r != '-' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+				return fmt.Errorf("device id %q is not a DNS label", id)
+			}
+		}
+	}
+	return nil
+}
+
+// APITokenConfig is one named API token. The secret is given either as
+// Token or, to keep it out of the config file, as TokenSHA256: the hex
+// SHA-256 digest of the token. Scopes are the API areas it may use:
+// read-metrics, read-stats, manage-blocklist, purge-cache and admin,
+// which grants all of them.
+type APITokenConfig struct {
+	Name        string   `toml:"name"`
+	Token       string   `toml:"token"` //nolint:gosec // G117 - not a hardcoded credential, loaded from config file
+	TokenSHA256 string   `toml:"token_sha256"`
+	Scopes      []string `toml:"scopes"`
+}
+
+// APIScopes are the scopes an API token may be given.
+var APIScopes = []string{"read-metrics", "read-stats", "manage-blocklist", "purge-cache", "admin"}
+
+// Digest returns the SHA-256 digest of the token.
+func (c APITokenConfig) Digest() ([sha256.Size]byte, error) {
+	var d [sha256.Size]byte
+	switch {
+	case c.Token != "" && c.TokenSHA256 != "":
+		return d, fmt.Errorf("token %q has both token and token_sha256", c.Name)
+	case c.Token != "":
+		return sha256.Sum256([]byte(c.Token)), nil
+	case c.TokenSHA256 == "":
+		return d, fmt.Errorf("token %q has neither token nor token_sha256", c.Name)
+	}
+	b, err := hex.DecodeString(c.TokenSHA256)
+	if err != nil || len(b) != sha256.Size {
+		return d, fmt.Errorf("token %q: token_sha256 is not a hex SHA-256 digest", c.Name)
+	}
+	copy(d[:], b)
+	return d, nil
+}
+
+// APIClientCertConfig grants Scopes to the API clients presenting a
+// verified certificate whose subject is Subject: either the whole
+// distinguished name, as in "CN=noc,O=Example", or its common name alone.
+type APIClientCertConfig struct {
+	Name    string   `toml:"name"`
+	Subject string   `toml:"subject"`
+	Scopes  []string `toml:"scopes"`
+}
+
+// ValidateAPI checks the API's tokens, TLS and socket settings.
+func (c *Config) ValidateAPI() error {
+	if err := ValidateAPITokens(c.APITokens); err != nil {
+		return err
+	}
+	if (c.APITLSCertificate == "") != (c.APITLSKey == "") {
+		return fmt.Errorf("api_tls_certificate and api_tls_key must be set together")
+	}
+	if c.APIClientCA != "" && c.APITLSCertificate == "" {
+		return fmt.Errorf("api_client_ca needs api_tls_certificate")
+	}
+	if (c.APIClientCertRequired || len(c.APIClientCerts) > 0) && c.APIClientCA == "" {
+		return fmt.Errorf("client certificates need api_client_ca")
+	}
+	seen := make(map[string]bool, len(c.APIClientCerts))
+	for _, cc := range c.APIClientCerts {
+		if cc.Name == "" || cc.Subject == "" {
+			return fmt.Errorf("api client certificate needs a name and a subject")
+		}
+		if seen[cc.Name] {
+			return fmt.Errorf("api client certificate %q is defined twice", cc.Name)
+		}
+		seen[cc.Name] = true
+		if err := validateScopes("api client certificate", cc.Name, cc.Scopes); err != nil {
+			return err
+		}
+	}
+	if c.APISocketMode != "" {
+		if _, err := c.SocketMode(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SocketMode returns the API socket's file mode.
+func (c *Config) SocketMode() (os.FileMode, error) {
+	return socketMode("api_socket_mode", c.APISocketMode)
+}
+
+// UnixMode returns the DNS unix socket's file mode.
+func (c *Config) UnixMode() (os.FileMode, error) {
+	return socketMode("bindunixmode", c.BindUnixMode)
+}
+
+func socketMode(key, v string) (os.FileMode, error) {
+	if v == "" {
+		return 0660, nil
+	}
+	m, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return os.FileMode(m), nil
+}
+
+func validateScopes(kind, name string, scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%s %q has no scopes", kind, name)
+	}
+	for _, s := range scopes {
+		if !slices.Contains(APIScopes, s) {
+			return fmt.Errorf("%s %q: unknown scope %q", kind, name, s)
+		}
+	}
+	return nil
+}
+
+// ValidateAPITokens rejects tokens that are unnamed or named twice, that
+// carry no usable secret, or that name a scope there is not, so a typo
+// fails the load instead of locking an operator out.
+func ValidateAPITokens(tokens []APITokenConfig) error {
+	seen := make(map[string]bool, len(tokens))
+	for _, t := range tokens {
+		if t.Name == "" {
+			return fmt.Errorf("api token without a name")
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("api token %q is defined twice", t.Name)
+		}
+		seen[t.Name] = true
+		if _, err := t.Digest(); err != nil {
+			return err
+		}
+		if err := validateScopes("api token", t.Name, t.Scopes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ACME challenge types.
+const (
+	ACMEChallengeTLSALPN = "tls-alpn-01"
+	ACMEChallengeDNS     = "dns-01"
+)
+
+// ACME defaults.
+const (
+	DefaultACMEDirectoryURL = "https://acme-v02.api.letsencrypt.org/directory"
+	DefaultACMERenewBefore  = 30 * 24 * time.Hour
+)
+
+// ACMEConfig obtains the certificate of the DoT, DoH and DoQ listeners
+// from an ACME CA (RFC 8555) and renews it ahead of expiry. The account
+// key and the certificate are kept under Directory/acme.
+//
+// Challenge tls-alpn-01 is answered by the encrypted listener the CA
+// reaches on port 443; dns-01 by the server itself, with the
+// _acme-challenge TXT records of the domains, which must be delegated
+// to it. Wildcard domains need dns-01. CACertificate is a PEM bundle
+// trusted for the directory's HTTPS, for a private CA.
+type ACMEConfig struct {
+	Enabled       bool     `toml:"enabled"`
+	Domains       []string `toml:"domains"`
+	Email         string   `toml:"email"`
+	DirectoryURL  string   `toml:"directory_url"`
+	Challenge     string   `toml:"challenge"`
+	RenewBefore   Duration `toml:"renew_before"`
+	CACertificate string   `toml:"ca_certificate"`
+}
+
+// Normalize applies the defaults of the omitted settings.
+func (c *ACMEConfig) Normalize() {
+	if c.DirectoryURL == "" {
+		c.DirectoryURL = DefaultACMEDirectoryURL
+	}
+	if c.Challenge == "" {
+		c.Challenge = ACMEChallengeTLSALPN
+	}
+	if c.RenewBefore.Duration == 0 {
+		c.RenewBefore.Duration = DefaultACMERenewBefore
+	}
+}
+
+// Validate checks a normalized, enabled configuration: at least one
+// domain, each a valid name, a known challenge, and dns-01 for
+// wildcards.
+func (c ACMEConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Domains) == 0 {
+		return fmt.Errorf("domains must not be empty")
+	}
+	switch c.Challenge {
+	case ACMEChallengeTLSALPN, ACMEChallengeDNS:
+	default:
+		return fmt.Errorf("challenge %q must be %q or %q", c.Challenge, ACMEChallengeTLSALPN, ACMEChallengeDNS)
+	}
+	for _, d := range c.Domains {
+		name := strings.TrimPrefix(d, "*.")
+		if _, ok := dns.IsDomainName(name); !ok || name == "" || strings.Contains(name, "*") {
+			return fmt.Errorf("domain %q is not a valid name", d)
+		}
+		if name != d && c.Challenge != ACMEChallengeDNS {
+			return fmt.Errorf("wildcard domain %q needs the %q challenge", d, ACMEChallengeDNS)
+		}
+	}
+	if c.RenewBefore.Duration < 0 {
+		return fmt.Errorf("renew_before must be positive")
+	}
+	return nil
 }
 
 // KubernetesConfig holds Kubernetes middleware configuration
@@ -250,6 +696,69 @@ type DNS64Config struct {
 	ExcludeAAAANetworks []string `toml:"exclude_aaaa_networks"`
 }
 
+// QueryLogConfig holds the structured query log configuration.
+//
+// Fields selects and orders the record's fields by name; empty means
+// all of them. Outputs names the sinks, any of "file", "syslog" and
+// "stdout". A file is rotated when it reaches MaxSize megabytes or has
+// been open for RotateEvery, whichever comes first; zero disables that
+// trigger. MaxBackups rotated files are kept, gzipped when Compress is
+// set. Syslog records are RFC 5424 over SyslogNetwork ("unix", "udp" or
+// "tcp"); an empty network means the local syslog socket.
+//
+// SampleRate is the percentage of queries logged, 0 and 100 both
+// meaning every query. SampleBy "client" makes the choice per client
+// address rather than per query, so a sampled client is logged whole.
+//
+// History keeps that many of the most recent records in memory for the
+// query history API and "sdns tail"; zero disables it. With HistoryDir
+// set, records leaving memory spill there in segments of History records,
+// HistorySegments of which are kept.
+type QueryLogConfig struct {
+	Enabled         bool     `toml:"enabled"`
+	Format          string   `toml:"format"`
+	Fields          []string `toml:"fields"`
+	Outputs         []string `toml:"outputs"`
+	File            string   `toml:"file"`
+	MaxSize         int      `toml:"max_size"`
+	RotateEvery     Duration `toml:"rotate_every"`
+	MaxBackups      int      `toml:"max_backups"`
+	Compress        bool     `toml:"compress"`
+	SyslogNetwork   string   `toml:"syslog_network"`
+	SyslogAddress   string   `toml:"syslog_address"`
+	SyslogFacility  string   `toml:"syslog_facility"`
+	SampleRate      float64  `toml:"sample_rate"`
+	SampleBy        string   `toml:"sample_by"`
+	History         int      `toml:"history"`
+	HistoryDir      string   `toml:"history_dir"`
+	HistorySegments int      `toml:"history_segments"`
+}
+
+// StatsConfig holds the query statistics configuration. The top domains,
+// blocked domains and clients are tracked approximately, keeping at most
+// TopSize of each per time bucket; a larger TopSize costs memory and makes
+// the lower ranks of a top list more exact. With Persist set, the
+// statistics are saved to that file periodically and at shutdown, and
+// loaded again at start.
+type StatsConfig struct {
+	Enabled bool   `toml:"enabled"`
+	TopSize int    `toml:"top_size"`
+	Persist string `toml:"persist"`
+}
+
+// TracingConfig holds the OpenTelemetry tracing configuration. Spans are
+// exported over OTLP/HTTP to Endpoint, a base URL to which the exporter
+// adds /v1/traces, with Headers sent on every export. SampleRatio is the
+// fraction of client requests traced; a DoH request that carries a W3C
+// traceparent follows the caller's sampling decision instead.
+type TracingConfig struct {
+	Enabled     bool              `toml:"enabled"`
+	Endpoint    string            `toml:"endpoint"`
+	ServiceName string            `toml:"service_name"`
+	SampleRatio float64           `toml:"sample_ratio"`
+	Headers     map[string]string `toml:"headers"`
+}
+
 // ECSConfig holds the EDNS Client Subnet middleware configuration
 // (RFC 7871). Strictly opt-in: when Enabled is false, the resolver
 // strips every client-supplied ECS option before forwarding upstream,
@@ -278,6 +787,48 @@ type ECSConfig struct {
 	MinScopeV6     uint8    `toml:"min_scope_v6"`
 }
 
+// DNSSECPolicyConfig adjusts which DNSSEC algorithms the validator
+// accepts (RFC 8624 §3.1, §3.3). Algorithms and Digests name IANA
+// mnemonics ("RSASHA256", "ED448", "SHA384"); an empty list keeps the
+// built-in default of everything implemented. A zone signed only with
+// algorithms outside the table validates as insecure, not bogus, per
+// RFC 6840 §5.2.
+//
+// AllowSHA1 false removes RSASHA1 (5) and RSASHA1-NSEC3-SHA1 (7) even
+// when Algorithms lists them, for hosts whose platform policy has
+// already retired SHA-1 signatures. It does not touch DS digest type 1,
+// which Digests governs. Omission keeps SHA-1 accepted, as RFC 8624
+// still requires of validators.
+type DNSSECPolicyConfig struct {
+	Algorithms []string `toml:"algorithms"`
+	Digests    []string `toml:"digests"`
+	AllowSHA1  *bool    `toml:"allow_sha1"`
+}
+
+// SHA1Allowed reports whether RSA/SHA-1 signatures are accepted. Omission
+// is default-on.
+func (c DNSSECPolicyConfig) SHA1Allowed() bool {
+	return c.AllowSHA1 == nil || *c.AllowSHA1
+}
+
+// Validate rejects mnemonics the validator cannot use: a typo, or an
+// algorithm or digest it does not implement (ECC-GOST, GOST94). The
+// check is the table the validator builds its policy from, so a config
+// that loads is one the resolver accepts.
+func (c DNSSECPolicyConfig) Validate() error {
+	for _, name := range c.Algorithms {
+		if _, err := dnssecalg.Algorithm(name); err != nil {
+			return err
+		}
+	}
+	for _, name := range c.Digests {
+		if _, err := dnssecalg.Digest(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // RecursionFirewallMode controls whether request-tree work limits are
 // disabled, observed, or enforced.
 type RecursionFirewallMode string
@@ -463,6 +1014,38 @@ bind = ":53"
 # Requires TLS certificate and key to be configured
 # binddoq = ":853"
 
+# Unix stream socket serving DNS to local clients (sidecars, stub
+# resolvers), each message with the two-byte length prefix of DNS over
+# TCP. Clients on it are seen as 127.0.0.1; the file mode (octal,
+# default 0660) is its access control.
+# bindunix = "/run/sdns/dns.sock"
+# bindunixmode = "0660"
+
+# DNSCrypt v2 server bind address and port, served over UDP and TCP.
+# Needs no TLS certificate: the provider's Ed25519 key is created in
+# the working directory (dnscrypt.key) and signs short-term resolver
+# certificates, rotated daily. The sdns:// stamp clients configure is
+# logged at startup.
+# binddnscrypt = ":5443"
+
+# DNSCrypt provider name, put under 2.dnscrypt-cert. when it is not
+# already.
+# dnscryptprovider = "2.dnscrypt-cert.sdns"
+
+# Oblivious DoH target (RFC 9230) on the DoH listeners: queries sealed
+# to the target's key, relayed by a third party, are answered without
+# either side seeing both the client and its question. The X25519 key
+# is created in the working directory (odoh.key); clients fetch it
+# from /.well-known/odohconfigs.
+# odoh = false
+
+# Oblivious DoH relay: the target hosts (with the port when not 443)
+# the DoH listeners forward sealed queries to, named by the targethost
+# and targetpath parameters of the client's request. The listener's
+# access list and rate limit apply to relayed requests. Empty disables
+# the relay.
+# odohrelaytargets = ["odoh.example.com"]
+
 # TLS certificate file path (PEM format)
 # Required for DoT, DoH, and DoQ servers
 # tlscertificate = "server.crt"
@@ -471,6 +1054,42 @@ bind = ":53"
 # Required for DoT, DoH, and DoQ servers
 # tlsprivatekey = "server.key"
 
+# CA bundle (PEM) that signs client certificates for DoT, DoH and DoQ.
+# A verified client is known by its certificate: the SPIFFE ID, else the
+# subject CN, else the first DNS name. That identity keys
+# accessidentities, the client rate limit, [[views]] identities and the
+# query log's client_id, so devices behind one NAT are told apart.
+# tlsclientca = "clients-ca.pem"
+
+# Refuse DoT, DoH and DoQ clients that present no certificate.
+tlsclientcertrequired = false
+
+# Devices that cannot hold a certificate may name themselves instead: by
+# the DoH URL https://dns.example.com/dns-query/kids-ipad, or by the DoT
+# or DoQ host name kids-ipad.dns.example.com when devicedomain is
+# "dns.example.com" (the certificate must cover *.dns.example.com). The
+# ID is matched case-insensitively, may end in "*" to cover a prefix,
+# and keys the same rules as a certificate identity, which wins when a
+# client has both. An ID not listed is refused: a 404 for the path, a
+# failed handshake for the name.
+# deviceids = ["kids-ipad", "tv-*"]
+# devicedomain = "dns.example.com"
+
+# More listeners, each with its own rules, are [[listeners]] entries
+# (see "Listeners" below). Set bind = "" to serve plain DNS on those
+# alone.
+
+# Answer _dns.resolver.arpa SVCB queries with the DoT, DoH and DoQ
+# listeners (Discovery of Designated Resolvers, RFC 9462), so clients
+# that support it upgrade to encrypted DNS on their own. Set false to
+# stop advertising them.
+ddr = true
+
+# Resolver names the records point clients at. Each must be covered by
+# the certificate of the listener it is advertised for; names that are
+# not are left out with a warning. Empty uses the certificate's DNS names.
+# ddr_names = ["dns.example.com"]
+
 # ============================
 # Network Configuration
 # ============================
@@ -548,6 +1167,16 @@ rfc8198 = true
 # active.
 rfc9520 = true
 
+# Report the key tags of the configured root trust anchors to the root
+# servers with a _ta-XXXX query after every trust anchor refresh (RFC 8145
+# §5). Root operators use the signal to measure readiness for a KSK rollover.
+rfc8145 = true
+
+# Answer root-key-sentinel-is-ta-<tag> and root-key-sentinel-not-ta-<tag>
+# names from this resolver's trust anchors (RFC 8509), so sentinel test pages
+# can tell which root keys it trusts.
+rfc8509 = true
+
 # DNSSEC root trust anchors
 # These are the public keys used to verify the DNS root zone
 rootkeys = [
@@ -598,14 +1227,17 @@ fallbackservers = [
 # DNS-over-HTTPS (https:// prefix, RFC 8484). DoH URLs accept either an
 # IP literal or a hostname — hostnames are resolved once at startup
 # through the system resolver and the resulting IPs are pinned for the
-# process lifetime (no per-query DNS dependency).
+# process lifetime (no per-query DNS dependency). Oblivious DoH (odoh://
+# prefix, RFC 9230) names the target, and the relay queries go through
+# in its relay parameter.
 forwarderservers = [
     # Examples:
     # "8.8.8.8:53",                          # Standard DNS
     # "[2001:4860:4860::8888]:53",           # Standard DNS IPv6
     # "tls://8.8.8.8:853",                   # DNS-over-TLS
     # "https://1.1.1.1/dns-query",           # DoH, IP literal
-    # "https://cloudflare-dns.com/dns-query" # DoH, hostname (system-resolver bootstrap)
+    # "https://cloudflare-dns.com/dns-query", # DoH, hostname (system-resolver bootstrap)
+    # "odoh://odoh.cloudflare-dns.com/dns-query?relay=https://relay.example.com/proxy" # ODoH
 ]
 
 # ============================
@@ -621,6 +1253,32 @@ api = "127.0.0.1:8080"
 # When set, requests must include: Authorization: Bearer <token>
 # bearertoken = ""
 
+# Web dashboard at http://<api>/dashboard/: query graphs, top lists, a
+# live query tail, blocklist management, cache purge and upstream health.
+# The page asks for the bearer token and sends it with each API call.
+dashboard = false
+
+# File each mutating API call is recorded in: token, client, request and
+# status, one JSON line per call. Empty records them in the server log.
+# api_audit_log = "api-audit.log"
+
+# Serve the API over HTTPS. The certificate is reloaded when the files
+# change, like the DNS listeners' certificate.
+# api_tls_certificate = "api.crt"
+# api_tls_key = "api.key"
+
+# Verify API client certificates against this CA bundle; the API Access
+# section below maps their subjects to scopes. Set
+# api_client_cert_required to refuse clients without a certificate;
+# otherwise they may still use a token.
+# api_client_ca = "api-clients.pem"
+# api_client_cert_required = false
+
+# Also listen on a unix socket for local tooling. Anyone who can open it
+# may do everything, so its file mode is its access control.
+# api_socket = "/run/sdns/api.sock"
+# api_socket_mode = "0660"
+
 # Log verbosity level
 # Options: crit, error, warn, info, debug
 loglevel = "info"
@@ -664,6 +1322,12 @@ accesslist = [
     "::0/0"         # Allow all IPv6
 ]
 
+# Client certificate identities and device IDs allowed to query (see
+# tlsclientca and deviceids). A client with a certificate is checked
+# against these instead of accesslist; a device ID, listed here as
+# "device:<id>", is checked against both. A trailing * matches a prefix.
+# accessidentities = ["spiffe://example.com/laptop/*"]
+
 # Local hosts file path
 # Serves entries from hosts file (RFC 952/1123 format)
 # Leave empty to disable
@@ -784,6 +1448,13 @@ chaos = true
 # 0 = disabled, 3 = recommended
 qname_min_level = 3
 
+# Randomize the letter case of query names sent to authoritative servers
+# (draft-vixie-dnsext-dns0x20) and require the reply to echo it exactly.
+# Adds entropy against off-path spoofing beyond query ID and source port.
+# A server that folds the case of three queries in a row is queried
+# without it for an hour, then tried again.
+qname_0x20 = false
+
 # Empty zones (AS112 - RFC 7534)
 # Prevents queries for private IP reverse zones from leaking
 # Default list used if empty
@@ -858,6 +1529,75 @@ reflexlearningmode = false
 # Dnstap buffer flush interval (seconds)
 # dnstapflushinterval = 5
 
+# ============================
+# API Access
+# ============================
+
+# Named API tokens, each limited to scopes: read-metrics, read-stats,
+# manage-blocklist, purge-cache and admin (everything). Give the secret as
+# token, or as token_sha256, its hex SHA-256 digest ("sdns token" makes
+# one). The bearertoken keeps every scope.
+#
+# [[api_tokens]]
+# name = "noc"
+# token_sha256 = "<sha256 of the token>"
+# scopes = ["read-metrics", "read-stats"]
+
+# Scopes for API clients with a certificate signed by api_client_ca,
+# matched on the subject: the full DN or just the CN.
+#
+# [[api_client_certs]]
+# name = "noc-scraper"
+# subject = "CN=noc-scraper,O=Example"
+# scopes = ["read-metrics"]
+
+# ============================
+# Listeners
+# ============================
+
+# DNS endpoints served besides the bind addresses above. proto is one of
+# dns (UDP and TCP), udp, tcp, tls, doh, doq or dnscrypt. The optional
+# settings override the global ones for this endpoint's clients only:
+#   accesslist       replaces accesslist
+#   accessidentities replaces accessidentities
+#   ratelimit        replaces clientratelimit; -1 turns it off
+#   view             a [[views]] zone that answers every client here
+#   recursion        "refuse" answers REFUSED instead of resolving
+#   tlscertificate   with tlsprivatekey, replaces the global certificate
+#   tlsclientca      with tlsclientcertrequired, replaces the global ones
+# name labels the endpoint in logs and metrics (default proto://addr).
+#
+# Examples:
+# [[listeners]]
+# name = "lan"
+# proto = "dns"
+# addr = "192.168.1.1:53"
+# accesslist = ["192.168.1.0/24"]
+#
+# [[listeners]]
+# name = "wireguard"
+# proto = "dns"
+# addr = "10.8.0.1:53"
+# accesslist = ["10.8.0.0/24"]
+# ratelimit = -1
+# view = "vpnnet"
+#
+# [[listeners]]
+# name = "public-dot"
+# proto = "tls"
+# addr = "203.0.113.10:853"
+# recursion = "refuse"
+# tlscertificate = "public.crt"
+# tlsprivatekey = "public.key"
+#
+# [[listeners]]
+# name = "roaming"
+# proto = "doh"
+# addr = ":443"
+# tlsclientca = "laptops-ca.pem"
+# tlsclientcertrequired = true
+# accessidentities = ["spiffe://example.com/laptop/*"]
+
 # ============================
 # Per-client Views
 # ============================
@@ -866,7 +1606,8 @@ reflexlearningmode = false
 # client's source IP. Each view lists CIDR networks and zone-file
 # answers; a query from a client whose IP is in one of the
 # networks gets the view's matching answer, and any non-matching
-# query falls through to normal resolution.
+# query falls through to normal resolution. identities match clients
+# by their client certificate (see tlsclientca) from any address.
 #
 # Wildcards (*.example.lan.) are supported. Exact owners override
 # a covering wildcard. Views are evaluated in declaration order.
@@ -883,6 +1624,7 @@ reflexlearningmode = false
 # [[views]]
 # zone = "vpnnet"
 # networks = ["100.64.0.0/24"]
+# identities = ["spiffe://example.com/laptop/*"]
 # answers = [
 #     "*.example.lan. 60 IN A 100.64.0.2",
 # ]
@@ -1090,6 +1832,150 @@ failure_cache_size = 4096
 failure_cache_min_ttl = "5s"
 failure_cache_max_ttl = "5m"
 
+# ============================
+# DNSSEC Algorithm Policy (RFC 8624)
+# ============================
+
+# Which DNSSEC algorithms the validator accepts. A zone signed only with
+# algorithms left out here validates as insecure rather than bogus
+# (RFC 6840 §5.2), so narrowing the table never makes a zone fail.
+[dnssec_policy]
+
+# DNSKEY algorithms counted as supported. Empty means every algorithm
+# this build implements: RSASHA1, RSASHA1-NSEC3-SHA1, RSASHA256,
+# RSASHA512, ECDSAP256SHA256, ECDSAP384SHA384, ED25519 and ED448.
+algorithms = []
+
+# DS digest types counted as supported. Empty means SHA1, SHA256 and
+# SHA384.
+digests = []
+
+# Accept RSA/SHA-1 signatures (algorithms 5 and 7). RFC 8624 still
+# requires validators to, but several distributions have retired SHA-1
+# signatures system-wide; false follows them. DS digest type 1 is
+# governed by the digests list above, not by this switch.
+allow_sha1 = true
+
+# ============================
+# Structured Query Log
+# ============================
+
+# One record per client query, with the answer, how it was produced
+# and how long it took. Unlike accesslog above it can be sampled, so a
+# busy resolver can keep it on.
+[querylog]
+enabled = false
+
+# Record format: "json" (one object per line) or "logfmt".
+format = "json"
+
+# Fields to write, in order. Empty means all of them:
+# time, client_ip, client_port, client_id, transport, qname, qtype,
+# rcode, ede, answer, latency, cache_hit, blocked, upstream, dnssec
+fields = []
+
+# Where records go: any of "file", "syslog" and "stdout".
+outputs = ["file"]
+
+# Query log file. Rotated when it reaches max_size megabytes or has been
+# open for rotate_every, whichever comes first; 0 disables a trigger.
+# max_backups rotated files are kept, gzipped when compress is true.
+file = "/var/log/sdns/query.log"
+max_size = 100
+rotate_every = "24h"
+max_backups = 7
+compress = true
+
+# RFC 5424 syslog. Network is "unix", "udp" or "tcp"; leave it empty to
+# use the local syslog socket.
+syslog_network = ""
+syslog_address = ""
+syslog_facility = "local0"
+
+# Percentage of queries logged (0 or 100 logs every query). sample_by
+# "query" samples each query independently; "client" samples client
+# addresses, so a client that is logged is logged completely.
+sample_rate = 100.0
+sample_by = "query"
+
+# Recent records kept in memory for GET /api/v1/querylog and "sdns tail";
+# 0 disables the history. Sampling applies to it as to the outputs.
+history = 10000
+
+# Directory older history spills to, in segments of "history" records;
+# history_segments of them are kept. Empty keeps the history in memory.
+history_dir = ""
+history_segments = 24
+
+# ============================
+# Statistics
+# ============================
+
+# Rolling 1h and 24h query statistics: top domains, top blocked domains
+# and top clients, served from /api/v1/stats.
+[stats]
+enabled = false
+
+# Entries kept per top list and time bucket. Counts below the top few
+# hundred are estimates; raise it for more exact lower ranks.
+top_size = 500
+
+# File the statistics are saved to every few minutes and at shutdown, so a
+# restart keeps them. Empty keeps them in memory only.
+persist = ""
+
+# ============================
+# Tracing
+# ============================
+
+# OpenTelemetry traces: a span per client request, per middleware that
+# served it, per upstream exchange, DNSSEC verification and prefetch.
+[tracing]
+enabled = false
+
+# OTLP/HTTP collector base URL; spans are posted to <endpoint>/v1/traces.
+endpoint = "http://localhost:4318"
+service_name = "sdns"
+
+# Fraction of client requests traced, 0.0 to 1.0. A DoH request carrying a
+# W3C traceparent header follows the caller's sampling decision instead.
+sample_ratio = 1.0
+
+# Headers sent with every export, e.g. { "Authorization" = "Bearer ..." }.
+headers = {}
+
+# ============================
+# Automatic Certificates (ACME)
+# ============================
+
+# Obtain the DoT/DoH/DoQ certificate from an ACME CA such as Let's
+# Encrypt and renew it ahead of expiry, in place of tlscertificate and
+# tlsprivatekey. The account key and certificate are kept in
+# <directory>/acme.
+[acme]
+enabled = false
+
+# Names on the certificate. Wildcards need the dns-01 challenge.
+domains = []
+
+# Contact address given to the CA for expiry and account notices.
+email = ""
+
+# The CA's directory. Let's Encrypt staging is
+# "https://acme-staging-v02.api.letsencrypt.org/directory".
+directory_url = "https://acme-v02.api.letsencrypt.org/directory"
+
+# "tls-alpn-01" is answered by the encrypted listener on port 443 (DoH);
+# "dns-01" by sdns itself, with the _acme-challenge TXT records of the
+# domains - delegate _acme-challenge.<domain> to this server.
+challenge = "tls-alpn-01"
+
+# Renew when the certificate has less than this left.
+renew_before = "720h"
+
+# PEM bundle trusted for the directory's HTTPS, for a private CA.
+# ca_certificate = "ca.pem"
+
 # ============================
 # Plugins
 # ============================
@@ -1148,6 +2034,27 @@ func Load(cfgfile, version string) (*Config, error) {
 		zlog.Warn("Config file is out of version, you can generate new one and check the changes.")
 	}
 
+	if err := config.DNSSECPolicy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid dnssec policy config: %w", err)
+	}
+
+	if err := config.ValidateAPI(); err != nil {
+		return nil, fmt.Errorf("invalid api config: %w", err)
+	}
+
+	if err := config.ValidateListeners(); err != nil {
+		return nil, fmt.Errorf("invalid listeners config: %w", err)
+	}
+
+	if err := config.ValidateDevices(); err != nil {
+		return nil, fmt.Errorf("invalid device config: %w", err)
+	}
+
+	config.ACME.Normalize()
+	if err := config.ACME.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid acme config: %w", err)
+	}
+
 	config.RecursionFirewall.Normalize()
 	if err := config.RecursionFirewall.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid recursion firewall config: %w", err)
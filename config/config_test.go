@@ -1,6 +1,7 @@
 package config
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -663,6 +665,24 @@ func TestRFC8198EnabledDefaultsAndOverrides(t *testing.T) {
 	}
 }
 
+func TestTrustAnchorSignallingDefaultsAndOverrides(t *testing.T) {
+	disabled := false
+
+	var nilCfg *Config
+	if !nilCfg.RFC8145Enabled() || !nilCfg.RFC8509Enabled() {
+		t.Fatal("nil config must default RFC 8145 and RFC 8509 on")
+	}
+	if cfg := (&Config{}); !cfg.RFC8145Enabled() || !cfg.RFC8509Enabled() {
+		t.Fatal("omitted settings must default RFC 8145 and RFC 8509 on")
+	}
+	if cfg := (&Config{RFC8145: &disabled}); cfg.RFC8145Enabled() || !cfg.RFC8509Enabled() {
+		t.Fatal("rfc8145 = false must disable only key-tag signalling")
+	}
+	if cfg := (&Config{RFC8509: &disabled}); !cfg.RFC8145Enabled() || cfg.RFC8509Enabled() {
+		t.Fatal("rfc8509 = false must disable only the root key sentinel")
+	}
+}
+
 func TestLoadRFC8198Policy(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -1126,3 +1146,340 @@ func TestLoadGeneratesAConfigAtACustomPath(t *testing.T) {
 		t.Fatalf("reload: %v", err)
 	}
 }
+
+func TestLoadDNSSECPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		section   string
+		wantErr   bool
+		wantSHA1  bool
+		wantAlgos []string
+	}{
+		{
+			name:     "omitted keeps defaults",
+			wantSHA1: true,
+		},
+		{
+			name: "explicit table",
+			section: `[dnssec_policy]
+algorithms = ["RSASHA256", "ecdsap256sha256", "16"]
+digests = ["SHA256"]
+allow_sha1 = false`,
+			wantSHA1:  false,
+			wantAlgos: []string{"RSASHA256", "ecdsap256sha256", "16"},
+		},
+		{
+			name: "unknown algorithm",
+			section: `[dnssec_policy]
+algorithms = ["RSASHA257"]`,
+			wantErr: true,
+		},
+		{
+			name: "unknown digest",
+			section: `[dnssec_policy]
+digests = ["SHA3"]`,
+			wantErr: true,
+		},
+		{
+			name: "unimplemented algorithm",
+			section: `[dnssec_policy]
+algorithms = ["ECC-GOST"]`,
+			wantErr: true,
+		},
+		{
+			name: "unimplemented digest",
+			section: `[dnssec_policy]
+digests = ["GOST94"]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			cfgFile := filepath.Join(tmpDir, "sdns.conf")
+			workDir := filepath.Join(tmpDir, "db")
+			content := fmt.Sprintf(`version = %q
+directory = %q
+ipv6access = true
+%s
+`, configver, workDir, tt.section)
+			if err := os.WriteFile(cfgFile, []byte(content), 0644); err != nil { //nolint:gosec // G306 - test file
+				t.Fatal(err)
+			}
+
+			cfg, err := Load(cfgFile, "test")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Load() succeeded, want dnssec policy error")
+				}
+				if !strings.Contains(err.Error(), "dnssec policy") {
+					t.Fatalf("Load() error = %v, want dnssec policy error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if got := cfg.DNSSECPolicy.SHA1Allowed(); got != tt.wantSHA1 {
+				t.Fatalf("SHA1Allowed() = %v, want %v", got, tt.wantSHA1)
+			}
+			if !slices.Equal(cfg.DNSSECPolicy.Algorithms, tt.wantAlgos) {
+				t.Fatalf("Algorithms = %v, want %v", cfg.DNSSECPolicy.Algorithms, tt.wantAlgos)
+			}
+		})
+	}
+}
+
+func TestValidateAPITokens(t *testing.T) {
+	digest := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" // "test"
+	tests := []struct {
+		name    string
+		tokens  []APITokenConfig
+		wantErr string
+	}{
+		{
+			name: "plain and hashed",
+			tokens: []APITokenConfig{
+				{Name: "noc", TokenSHA256: digest, Scopes: []string{"read-metrics"}},
+				{Name: "ops", Token: "secret", Scopes: []string{"manage-blocklist", "purge-cache"}},
+			},
+		},
+		{name: "unnamed", tokens: []APITokenConfig{{Token: "x", Scopes: []string{"admin"}}}, wantErr: "without a name"},
+		{
+			name: "duplicate",
+			tokens: []APITokenConfig{
+				{Name: "a", Token: "x", Scopes: []string{"admin"}},
+				{Name: "a", Token: "y", Scopes: []string{"admin"}},
+			},
+			wantErr: "defined twice",
+		},
+		{name: "both secrets", tokens: []APITokenConfig{{Name: "a", Token: "x", TokenSHA256: digest, Scopes: []string{"admin"}}}, wantErr: "both"},
+		{name: "no secret", tokens: []APITokenConfig{{Name: "a", Scopes: []string{"admin"}}}, wantErr: "neither"},
+		{name: "short digest", tokens: []APITokenConfig{{Name: "a", TokenSHA256: "abcd", Scopes: []string{"admin"}}}, wantErr: "not a hex"},
+		{name: "no scopes", tokens: []APITokenConfig{{Name: "a", Token: "x"}}, wantErr: "no scopes"},
+		{name: "unknown scope", tokens: []APITokenConfig{{Name: "a", Token: "x", Scopes: []string{"write-everything"}}}, wantErr: "unknown scope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAPITokens(tt.tokens)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateAPITokens() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateAPITokens() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	d, err := APITokenConfig{Name: "t", TokenSHA256: digest}.Digest()
+	if err != nil || d != sha256.Sum256([]byte("test")) {
+		t.Fatalf("Digest() = %x, %v", d, err)
+	}
+}
+
+func TestValidateAPI(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{name: "nothing set"},
+		{
+			name: "tls with client certificates",
+			cfg: Config{
+				APITLSCertificate: "api.crt", APITLSKey: "api.key", APIClientCA: "ca.pem",
+				APIClientCerts: []APIClientCertConfig{{Name: "noc", Subject: "CN=noc", Scopes: []string{"read-metrics"}}},
+				APISocket:      "/run/sdns/api.sock", APISocketMode: "0600",
+			},
+		},
+		{name: "certificate without key", cfg: Config{APITLSCertificate: "api.crt"}, wantErr: "set together"},
+		{name: "client ca without tls", cfg: Config{APIClientCA: "ca.pem"}, wantErr: "needs api_tls_certificate"},
+		{
+			name:    "client certificates without ca",
+			cfg:     Config{APITLSCertificate: "a", APITLSKey: "b", APIClientCertRequired: true},
+			wantErr: "need api_client_ca",
+		},
+		{
+			name: "client certificate without subject",
+			cfg: Config{APITLSCertificate: "a", APITLSKey: "b", APIClientCA: "c",
+				APIClientCerts: []APIClientCertConfig{{Name: "noc", Scopes: []string{"admin"}}}},
+			wantErr: "name and a subject",
+		},
+		{
+			name: "client certificate with unknown scope",
+			cfg: Config{APITLSCertificate: "a", APITLSKey: "b", APIClientCA: "c",
+				APIClientCerts: []APIClientCertConfig{{Name: "noc", Subject: "noc", Scopes: []string{"root"}}}},
+			wantErr: "unknown scope",
+		},
+		{name: "socket mode", cfg: Config{APISocketMode: "0999"}, wantErr: "api_socket_mode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.ValidateAPI()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateAPI() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateAPI() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if m, err := (&Config{}).SocketMode(); err != nil || m != 0660 {
+		t.Fatalf("default SocketMode() = %o, %v", m, err)
+	}
+}
+
+func TestValidateListeners(t *testing.T) {
+	views := []ViewConfig{{Zone: "vpnnet"}}
+	tests := []struct {
+		name      string
+		listeners []ListenerConfig
+		wantErr   string
+	}{
+		{name: "none"},
+		{
+			name: "lan and wireguard",
+			listeners: []ListenerConfig{
+				{Name: "lan", Proto: "dns", Addr: "192.168.1.1:53", AccessList: []string{"192.168.1.0/24"}},
+				{Name: "wg", Proto: "dns", Addr: "10.8.0.1:53", RateLimit: -1, View: "vpnnet", Recursion: "allow"},
+				{Proto: "tls", Addr: ":853", Recursion: "refuse", TLSCertificate: "a.crt", TLSPrivateKey: "a.key"},
+			},
+		},
+		{name: "unknown proto", listeners: []ListenerConfig{{Proto: "http", Addr: ":80"}}, wantErr: "unknown proto"},
+		{name: "no addr", listeners: []ListenerConfig{{Proto: "dns"}}, wantErr: "no addr"},
+		{
+			name:      "same label twice",
+			listeners: []ListenerConfig{{Proto: "udp", Addr: ":53"}, {Name: "udp://:53", Proto: "tcp", Addr: ":53"}},
+			wantErr:   "defined twice",
+		},
+		{name: "bad recursion", listeners: []ListenerConfig{{Proto: "dns", Addr: ":53", Recursion: "deny"}}, wantErr: "recursion"},
+		{
+			name:      "certificate without key",
+			listeners: []ListenerConfig{{Proto: "doh", Addr: ":443", TLSCertificate: "a.crt"}},
+			wantErr:   "set together",
+		},
+		{name: "unknown view", listeners: []ListenerConfig{{Proto: "dns", Addr: ":53", View: "lannet"}}, wantErr: "no view"},
+		{
+			name:      "client certificate without a CA",
+			listeners: []ListenerConfig{{Proto: "tls", Addr: ":853", TLSClientCertRequired: true}},
+			wantErr:   "needs tlsclientca",
+		},
+		{
+			name:      "client certificate with its own CA",
+			listeners: []ListenerConfig{{Proto: "tls", Addr: ":853", TLSClientCA: "ca.pem", TLSClientCertRequired: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Views: views, Listeners: tt.listeners}
+			err := cfg.ValidateListeners()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateListeners() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateListeners() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if m, err := (&Config{BindUnixMode: "0600"}).UnixMode(); err != nil || m != 0600 {
+		t.Fatalf("UnixMode() = %o, %v", m, err)
+	}
+	if err := (&Config{BindUnixMode: "rw"}).ValidateListeners(); err == nil || !strings.Contains(err.Error(), "bindunixmode") {
+		t.Fatalf("ValidateListeners() error = %v, want bindunixmode", err)
+	}
+}
+
+func TestValidateDevices(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "paths only", cfg: Config{DeviceIDs: []string{"kids-ipad", "TV-*"}}},
+		{name: "with a domain", cfg: Config{DeviceIDs: []string{"kids-ipad"}, DeviceDomain: "dns.example.com"}},
+		{name: "domain without ids", cfg: Config{DeviceDomain: "dns.example.com"}, wantErr: "needs deviceids"},
+		{name: "empty id", cfg: Config{DeviceIDs: []string{""}}, wantErr: "not a DNS label"},
+		{name: "bare wildcard", cfg: Config{DeviceIDs: []string{"kids-ipad", "*"}}, wantErr: "not a DNS label"},
+		{name: "dotted id", cfg: Config{DeviceIDs: []string{"kids.ipad"}}, wantErr: "not a DNS label"},
+		{name: "path id", cfg: Config{DeviceIDs: []string{"kids/ipad"}}, wantErr: "not a DNS label"},
+		{name: "long id", cfg: Config{DeviceIDs: []string{strings.Repeat("a", 64)}}, wantErr: "not a DNS label"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.ValidateDevices()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateDevices() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateDevices() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestACMEConfigNormalizeAndValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ACMEConfig
+		wantErr string
+	}{
+		{name: "disabled"},
+		{name: "tls-alpn-01", cfg: ACMEConfig{Enabled: true, Domains: []string{"dns.example.com"}}},
+		{
+			name: "wildcard over dns-01",
+			cfg:  ACMEConfig{Enabled: true, Domains: []string{"*.example.com", "example.com"}, Challenge: ACMEChallengeDNS},
+		},
+		{name: "no domains", cfg: ACMEConfig{Enabled: true}, wantErr: "domains"},
+		{name: "unknown challenge", cfg: ACMEConfig{Enabled: true, Domains: []string{"a.example"}, Challenge: "http-01"}, wantErr: "challenge"},
+		{name: "bad name", cfg: ACMEConfig{Enabled: true, Domains: []string{"a.*.example"}}, wantErr: "not a valid name"},
+		{name: "wildcard over tls-alpn-01", cfg: ACMEConfig{Enabled: true, Domains: []string{"*.example.com"}}, wantErr: "needs"},
+		{
+			name:    "negative renew_before",
+			cfg:     ACMEConfig{Enabled: true, Domains: []string{"a.example"}, RenewBefore: Duration{-time.Hour}},
+			wantErr: "renew_before",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Normalize()
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	var cfg ACMEConfig
+	cfg.Normalize()
+	if cfg.DirectoryURL != DefaultACMEDirectoryURL || cfg.Challenge != ACMEChallengeTLSALPN || cfg.RenewBefore.Duration != DefaultACMERenewBefore {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+}
@@ -50,16 +50,18 @@ The chain order is:
     and UDP buffer-size negotiation.
  8. accesslog  - Per-query logging.
  9. chaos      - CHAOS-class version and telemetry responses.
- 10. hostsfile - Answers served from a local hosts file.
- 11. views     - Per-client static answers selected by source-IP CIDR.
- 12. blocklist - Domain blocking with pattern matching.
- 13. as112     - RFC 7534 handling for private-use reverse zones.
- 14. kubernetes- Kubernetes service DNS (optional).
- 15. dns64     - RFC 6147 AAAA synthesis for IPv6-only clients.
- 16. cache     - Positive/negative caching with prefetch and ECS awareness.
- 17. failover  - Fallback when the primary resolution path fails.
- 18. resolver  - Iterative recursive resolution with DNSSEC validation.
- 19. forwarder - Forwarding to upstream resolvers (UDP/TCP/DoT/DoH).
+ 10. ddr       - RFC 9462 designated resolver (SVCB) answers.
+ 11. acmedns   - ACME dns-01 challenge answers for the server's certificate.
+ 12. hostsfile - Answers served from a local hosts file.
+ 13. views     - Per-client static answers selected by source-IP CIDR.
+ 14. blocklist - Domain blocking with pattern matching.
+ 15. as112     - RFC 7534 handling for private-use reverse zones.
+ 16. kubernetes- Kubernetes service DNS (optional).
+ 17. dns64     - RFC 6147 AAAA synthesis for IPv6-only clients.
+ 18. cache     - Positive/negative caching with prefetch and ECS awareness.
+ 19. failover  - Fallback when the primary resolution path fails.
+ 20. resolver  - Iterative recursive resolution with DNSSEC validation.
+ 21. forwarder - Forwarding to upstream resolvers (UDP/TCP/DoT/DoH).
 
 # Configuration
 
@@ -23,7 +23,11 @@ var middlewareList = []string{
 	"reflex",
 	"edns",
 	"accesslog",
+	"querylog",
+	"stats",
 	"chaos",
+	"ddr",
+	"acmedns",
 	"hostsfile",
 	"views",
 	"blocklist",
@@ -104,6 +104,20 @@ func (n *Cache) store(key uint64, dsSet []dns.RR, servers *Servers, expiresAt ti
 	})
 }
 
+// (*Cache).ForEach calls f for every delegation that has not expired,
+// until f returns false. Iteration is not atomic with concurrent updates:
+// a delegation stored or evicted meanwhile may or may not be seen.
+func (n *Cache) ForEach(f func(d *Delegation) bool) {
+	now := n.now()
+	n.cache.ForEach(func(_ uint64, value any) bool {
+		d, ok := value.(*Delegation)
+		if !ok || !now.Before(d.ExpiresAt) {
+			return true
+		}
+		return f(d)
+	})
+}
+
 // (*Cache).Remove remove remove a cache.
 func (n *Cache) Remove(key uint64) {
 	n.cache.Remove(key)
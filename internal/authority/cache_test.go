@@ -179,3 +179,41 @@ func Test_CacheSetUntil(t *testing.T) {
 		t.Fatalf("ExpiresAt = %v, want ceiling %v", d.ExpiresAt, want)
 	}
 }
+
+func TestCacheForEachSkipsExpired(t *testing.T) {
+	nscache := NewCache()
+	base := time.Now()
+	nscache.now = func() time.Time { return base }
+
+	for i, zone := range []string{"short.example.", "long.example."} {
+		q := dns.Question{Name: zone, Qtype: dns.TypeNS, Qclass: dns.ClassINET}
+		nscache.Set(cache.Key(q), nil, &Servers{Zone: zone}, time.Duration(i+1)*time.Hour)
+	}
+
+	zones := func() map[string]bool {
+		seen := make(map[string]bool)
+		nscache.ForEach(func(d *Delegation) bool {
+			seen[d.Servers.Zone] = true
+			return true
+		})
+		return seen
+	}
+	if got := zones(); len(got) != 2 {
+		t.Fatalf("ForEach saw %v, want both delegations", got)
+	}
+
+	nscache.now = func() time.Time { return base.Add(90 * time.Minute) }
+	if got := zones(); len(got) != 1 || !got["long.example."] {
+		t.Fatalf("ForEach saw %v, want only the unexpired delegation", got)
+	}
+
+	calls := 0
+	nscache.now = func() time.Time { return base }
+	nscache.ForEach(func(*Delegation) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Fatalf("ForEach went on for %d calls after f returned false", calls)
+	}
+}
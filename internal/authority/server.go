@@ -16,9 +16,9 @@ type Server struct {
 	// place atomic members at the start to fix alignment for ARM32
 	//
 	// state is what one exchange says about this server, in one word:
-	// [estimate ns : 62][answered : 1][measured : 1]. They are packed
-	// because they have to change together — a sample that lands between
-	// reading one and writing the other could otherwise replace a
+	// [estimate ns : 62][answered : 1][measured : 1]. They
+	// are packed because they have to change together — a sample that lands
+	// between reading one and writing the other could otherwise replace a
 	// measurement instead of folding into it.
 	state int64
 	// lastNs is when state was last refreshed, in Unix nanoseconds. It is
@@ -40,6 +40,11 @@ type Server struct {
 	// callers must normalize.
 	canonical bool
 
+	// caseState is what 0x20 randomization has learned of the server:
+	// [case-blind until : 28][strikes : 4]. It is a 32-bit word so that
+	// it, too, lands in the padding after IPVersion.
+	caseState atomic.Uint32
+
 	// UDPAddr is Addr pre-parsed as *net.UDPAddr so the upstream
 	// exchange path can use net.DialUDP directly instead of going
 	// through Dialer.DialContext's string-parsing + dialParallel
@@ -130,22 +135,6 @@ func (v IPVersion) String() string {
 func (a *Server) String() string {
 	measured := a.SmoothedRTT()
 
-	// FAILING comes before POOR because it says something the latency
-	// cannot: a server that does not reply is charged a timeout, and a
-	// timeout is also what a very slow server costs, so priced alone the
-	// two are indistinguishable to whoever is reading this.
-	var health string
-	switch {
-	case measured == 0:
-		health = "UNKNOWN"
-	case !a.Answering():
-		health = "FAILING"
-	case measured >= time.Second:
-		health = "POOR"
-	default:
-		health = "GOOD"
-	}
-
 	rtt := "unknown"
 	if measured != 0 {
 		rtt = measured.Round(time.Millisecond).String()
@@ -157,7 +146,36 @@ func (a *Server) String() string {
 	// order this reports explicable.
 	return a.IPVersion.String() + ":" + a.Addr + " rtt:" + rtt +
 		" rank:" + a.Score().Round(time.Millisecond).String() +
-		" health:[" + health + "]"
+		" health:[" + a.Health() + "]"
+}
+
+// Health sums up what the exchanges say about this server in one word:
+// UNKNOWN, FAILING, POOR or GOOD.
+//
+// FAILING comes before POOR because it says something the latency
+// cannot: a server that does not reply is charged a timeout, and a
+// timeout is also what a very slow server costs, so priced alone the
+// two are indistinguishable to whoever is reading this.
+func (a *Server) Health() string {
+	measured := a.SmoothedRTT()
+	switch {
+	case measured == 0:
+		return "UNKNOWN"
+	case !a.Answering():
+		return "FAILING"
+	case measured >= time.Second:
+		return "POOR"
+	default:
+		return "GOOD"
+	}
+}
+
+// Stale reports whether what is known about this server is out of date:
+// nothing has answered, or the last answer is old enough that the ranking
+// has started pricing it back toward a guess.
+func (a *Server) Stale() bool {
+	_, stale := a.score(time.Now().UnixNano())
+	return stale
 }
 
 // fpEntry caches a Fingerprint() result along with the generation
@@ -277,6 +295,81 @@ const (
 	stateRTTShift = 2
 )
 
+// The 0x20 word: [ case-blind until, caseClock seconds : 28 ][ strikes : 4 ].
+//
+// A reply that spells the question differently from how it was sent is a
+// strike. It is what a server that folds case does every time, and what
+// a spoofer who guessed the ID and the port does once — so one strike
+// proves nothing, and it is CaseBlindStrikes of them in a row, in
+// separate exchanges, that stop 0x20 against the server. A reply that
+// echoes the spelling wipes the strikes. The stop lasts CaseBlindFor;
+// after that the server is asked in a random spelling again, and has to
+// fold it as many times over to be let off again. A forger who lands one
+// reply therefore gets the reply dropped and nothing more, and one who
+// lands enough to switch the defence off gets it back within the hour.
+const (
+	CaseBlindStrikes = 3
+	CaseBlindFor     = time.Hour
+
+	caseStrikeBits = 4
+	caseStrikeMask = 1<<caseStrikeBits - 1
+	caseClockMax   = 1<<(32-caseStrikeBits) - 1
+)
+
+var caseEpoch = time.Now()
+
+// caseClock is the 0x20 word's clock: whole seconds since the package
+// loaded, from 1 so that 0 can mean "not blind". 28 bits of it is eight
+// years of uptime; past that it holds at the top, and a mark made there
+// lasts until restart. A variable so tests can move it.
+var caseClock = func() uint32 {
+	return uint32(min(time.Since(caseEpoch)/time.Second+1, caseClockMax)) //nolint:gosec // G115 - bounded above
+}
+
+// PreservesCase reports whether the server is to be asked with 0x20
+// randomization: it has not folded the spelling CaseBlindStrikes times
+// running, or it did so more than CaseBlindFor ago.
+func (s *Server) PreservesCase() bool {
+	until := s.caseState.Load() >> caseStrikeBits
+	return until == 0 || caseClock() >= until
+}
+
+// NoteCaseMismatch records a reply that did not echo the spelling of the
+// question it answered, and reports whether that makes the server case
+// blind. Call it once per exchange; the retry of that exchange, sent
+// without 0x20, is not another strike.
+func (s *Server) NoteCaseMismatch() bool {
+	for {
+		w := s.caseState.Load()
+		strikes, until := w&caseStrikeMask, w>>caseStrikeBits
+		now := caseClock()
+		if until != 0 {
+			if now < until {
+				return true
+			}
+			// The mark has run out and this is the re-probe failing: the
+			// server starts over, and earns the mark again.
+			strikes = 0
+		}
+		strikes++
+		next := strikes
+		if strikes >= CaseBlindStrikes {
+			next |= min(now+uint32(CaseBlindFor/time.Second), caseClockMax) << caseStrikeBits
+		}
+		if s.caseState.CompareAndSwap(w, next) {
+			return next>>caseStrikeBits != 0
+		}
+	}
+}
+
+// NoteCasePreserved records a reply that echoed a randomized spelling
+// exactly, which clears the strikes against the server.
+func (s *Server) NoteCasePreserved() {
+	if s.caseState.Load() != 0 {
+		s.caseState.Store(0)
+	}
+}
+
 // Observe records a completed exchange: how long this server took to
 // answer. The estimate is blended half and half with each new sample, so
 // one bad sample is visible in the ranking immediately — which is what a
@@ -204,3 +204,74 @@ func TestNewServerCanonicalizes(t *testing.T) {
 		t.Fatalf("IPv6 family derivation = %v", v6.IPVersion)
 	}
 }
+
+// One folded reply is a strike, not a verdict: it takes CaseBlindStrikes
+// in a row to stop 0x20, an echoed reply wipes them, and the mark runs
+// out after CaseBlindFor.
+func TestCaseBlindNeedsStrikesAndExpires(t *testing.T) {
+	now := uint32(1)
+	saved := caseClock
+	caseClock = func() uint32 { return now }
+	t.Cleanup(func() { caseClock = saved })
+
+	s := NewServer("192.0.2.1:53", IPv4)
+	if !s.PreservesCase() {
+		t.Fatal("a new server starts case-blind")
+	}
+	if s.NoteCaseMismatch() || !s.PreservesCase() {
+		t.Fatal("one mismatch marked the server case-blind")
+	}
+	s.NoteCasePreserved()
+	for i := 1; i < CaseBlindStrikes; i++ {
+		if s.NoteCaseMismatch() {
+			t.Fatalf("marked after %d strikes, want %d", i, CaseBlindStrikes)
+		}
+	}
+	if !s.NoteCaseMismatch() || s.PreservesCase() {
+		t.Fatalf("%d strikes in a row left 0x20 on", CaseBlindStrikes)
+	}
+
+	now += uint32(CaseBlindFor/time.Second) - 1
+	if s.PreservesCase() {
+		t.Fatal("the mark ran out early")
+	}
+	now++
+	if !s.PreservesCase() {
+		t.Fatal("the mark outlived CaseBlindFor")
+	}
+	// The re-probe folding again is a first strike, not a fresh mark.
+	if s.NoteCaseMismatch() || !s.PreservesCase() {
+		t.Fatal("one mismatch after expiry marked the server again")
+	}
+}
+
+// The 0x20 word sits apart from the state word, so neither may disturb
+// the other: a sample keeps the mark, and the mark moves no estimate.
+func TestCaseBlindSurvivesSamples(t *testing.T) {
+	s := NewServer("192.0.2.1:53", IPv4)
+	for range CaseBlindStrikes {
+		s.NoteCaseMismatch()
+	}
+	if s.SmoothedRTT() != 0 || !s.Answering() {
+		t.Fatal("marking an unmeasured server measured it")
+	}
+
+	s.Observe(40 * time.Millisecond)
+	s.ObserveNoAnswer(20 * time.Millisecond)
+	if s.PreservesCase() {
+		t.Fatal("a recorded sample cleared the case-blind mark")
+	}
+	if got := s.SmoothedRTT(); got != 30*time.Millisecond {
+		t.Fatalf("estimate = %v, want 30ms", got)
+	}
+	if s.Answering() {
+		t.Fatal("the answered bit did not follow the last sample")
+	}
+
+	if !s.NoteCaseMismatch() {
+		t.Fatal("a marked server reported unmarked")
+	}
+	if got := s.SmoothedRTT(); got != 30*time.Millisecond || s.PreservesCase() {
+		t.Fatalf("another mismatch changed the server: %v, preserves=%v", got, s.PreservesCase())
+	}
+}
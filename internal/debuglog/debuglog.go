@@ -0,0 +1,307 @@
+// Package debuglog is SDNS's runtime debug logging control: the log level
+// changed without a restart, debug output switched on for one subsystem
+// at a time, and a filter that narrows it to the queries of one client.
+//
+// A change made through Apply reverts on its own after the duration it was
+// given, so a debugging session left running does not flood the logs for
+// good. With nothing applied, On costs an atomic load and a level check.
+package debuglog
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/semihalev/sdns/internal/contextutil"
+	"github.com/semihalev/zlog/v2"
+)
+
+// Subsystem is a set of the parts of the server whose debug output can be
+// switched on on its own.
+type Subsystem uint8
+
+const (
+	Resolver Subsystem = 1 << iota
+	Cache
+	DNSSEC
+	Forwarder
+	Kubernetes
+)
+
+var subsystemNames = [...]struct {
+	sub  Subsystem
+	name string
+}{
+	{Resolver, "resolver"},
+	{Cache, "cache"},
+	{DNSSEC, "dnssec"},
+	{Forwarder, "forwarder"},
+	{Kubernetes, "kubernetes"},
+}
+
+// ParseSubsystem returns the subsystem called name.
+func ParseSubsystem(name string) (Subsystem, bool) {
+	for _, s := range subsystemNames {
+		if strings.EqualFold(name, s.name) {
+			return s.sub, true
+		}
+	}
+	return 0, false
+}
+
+// Names returns the names of the subsystems in s, in a fixed order.
+func (s Subsystem) Names() []string {
+	names := []string{}
+	for _, n := range subsystemNames {
+		if s&n.sub != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+// String returns the subsystem names in s joined with commas.
+func (s Subsystem) String() string {
+	return strings.Join(s.Names(), ",")
+}
+
+// ParseLevel returns the log level called name, as loglevel spells it.
+func ParseLevel(name string) (zlog.Level, bool) {
+	switch name {
+	case "debug":
+		return zlog.LevelDebug, true
+	case "info":
+		return zlog.LevelInfo, true
+	case "warn":
+		return zlog.LevelWarn, true
+	case "error":
+		return zlog.LevelError, true
+	}
+	return 0, false
+}
+
+// LevelName is the inverse of ParseLevel.
+func LevelName(l zlog.Level) string {
+	switch l {
+	case zlog.LevelDebug:
+		return "debug"
+	case zlog.LevelInfo:
+		return "info"
+	case zlog.LevelWarn:
+		return "warn"
+	case zlog.LevelError:
+		return "error"
+	}
+	return "fatal"
+}
+
+// Settings is a runtime logging change.
+type Settings struct {
+	// Level is the process log level while the change lasts.
+	Level zlog.Level
+	// Subsystems get debug output even when Level is above debug.
+	Subsystems Subsystem
+	// Client, when valid, limits subsystem debug output to the queries
+	// of clients inside it. Work that belongs to no client query — root
+	// priming, prefetches, connection pool upkeep — is left out.
+	Client netip.Prefix
+	// DebugNS answers CHAOS HINFO queries with the resolver's nameserver
+	// state, as SDNS_DEBUGNS does from startup.
+	DebugNS bool
+}
+
+// state is an applied change and when it reverts.
+type state struct {
+	Settings
+	until time.Time
+}
+
+type clientKeyType struct{}
+
+// clientKey marks a request tree whose client the filter selected.
+var clientKey = &clientKeyType{}
+
+var (
+	active atomic.Pointer[state]
+
+	mu    sync.Mutex
+	base  = zlog.LevelInfo
+	timer *time.Timer
+
+	// logger writes subsystem debug output. It stays at debug level:
+	// whether a line is wanted is decided by On, not by the level of the
+	// process logger, which a subsystem switch deliberately leaves alone.
+	logger = func() *zlog.StructuredLogger {
+		l := zlog.NewStructured()
+		l.SetLevel(zlog.LevelDebug)
+		return l
+	}()
+)
+
+// Init records level as the configured log level that changes revert to,
+// and sends subsystem debug output to w, the process logger's writer.
+func Init(level zlog.Level, w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	base = level
+	logger.SetWriter(w)
+}
+
+// Apply makes s the logging settings for d, after which the configured
+// level returns and the subsystem switches and client filter clear. It
+// replaces a change still in force, timer and all, and returns when the
+// new one reverts.
+func Apply(s Settings, d time.Duration) time.Time {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if timer != nil {
+		timer.Stop()
+	}
+	st := &state{Settings: s, until: time.Now().Add(d)}
+	active.Store(st)
+	zlog.Default().SetLevel(s.Level)
+	timer = time.AfterFunc(d, func() { revert(st) })
+
+	zlog.Info("Log settings changed", "level", LevelName(s.Level), "debug", s.Subsystems.String(),
+		"client", clientString(s.Client), "debugns", s.DebugNS, "until", st.until.Format(time.RFC3339))
+	return st.until
+}
+
+// Revert ends a change made with Apply now.
+func Revert() {
+	if st := active.Load(); st != nil {
+		revert(st)
+	}
+}
+
+// revert undoes st unless another change has replaced it since.
+func revert(st *state) {
+	mu.Lock()
+	defer mu.Unlock()
+	if active.Load() != st {
+		return
+	}
+	if timer != nil {
+		timer.Stop()
+		timer = nil
+	}
+	active.Store(nil)
+	zlog.Default().SetLevel(base)
+	zlog.Info("Log settings reverted", "level", LevelName(base))
+}
+
+// Current returns the settings in force and when they revert; the time is
+// zero when nothing has been applied.
+func Current() (Settings, time.Time) {
+	if st := active.Load(); st != nil {
+		return st.Settings, st.until
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return Settings{Level: base}, time.Time{}
+}
+
+// Configured returns the log level changes revert to.
+func Configured() zlog.Level {
+	mu.Lock()
+	defer mu.Unlock()
+	return base
+}
+
+// DebugNS reports whether CHAOS HINFO nameserver debugging was switched on
+// at runtime.
+func DebugNS() bool {
+	st := active.Load()
+	return st != nil && st.DebugNS
+}
+
+// On reports whether debug output of sub is wanted for the query ctx
+// belongs to. Call sites that format their arguments check it first.
+func On(ctx context.Context, sub Subsystem) bool {
+	st := active.Load()
+	if st == nil {
+		return zlog.Default().GetLevel() <= zlog.LevelDebug
+	}
+	if st.Subsystems&sub == 0 && zlog.Default().GetLevel() > zlog.LevelDebug {
+		return false
+	}
+	return !st.Client.IsValid() || selected(ctx)
+}
+
+// Enabled reports whether debug output of any subsystem in sub may be
+// wanted, leaving the client filter aside: a guard cheaper than On for a
+// call site that has no context to hand it.
+func Enabled(sub Subsystem) bool {
+	if st := active.Load(); st != nil && st.Subsystems&sub != 0 {
+		return true
+	}
+	return zlog.Default().GetLevel() <= zlog.LevelDebug
+}
+
+// Log writes a debug line for sub when On says it is wanted.
+func Log(ctx context.Context, sub Subsystem, msg string, keysAndValues ...any) {
+	if !On(ctx, sub) {
+		return
+	}
+	logger.DebugKV(msg, append(keysAndValues, "subsystem", sub.String())...)
+}
+
+// Filtering reports whether a client filter is in force.
+func Filtering() bool {
+	st := active.Load()
+	return st != nil && st.Client.IsValid()
+}
+
+// MarkClient returns ctx marked for debug output when a client filter is
+// in force and ip is inside it. The chain calls it once per client query,
+// behind Filtering.
+func MarkClient(ctx context.Context, ip net.IP) context.Context {
+	st := active.Load()
+	if st == nil || !st.Client.IsValid() {
+		return ctx
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok || !st.Client.Contains(addr.Unmap()) {
+		return ctx
+	}
+	return mark(ctx)
+}
+
+// Carry copies the client mark from onto to, for work that continues a
+// query on a context of its own.
+func Carry(from, to context.Context) context.Context {
+	if !selected(from) {
+		return to
+	}
+	return mark(to)
+}
+
+func mark(ctx context.Context) context.Context {
+	if contextutil.TryPinValue(ctx, clientKey, true) {
+		return ctx
+	}
+	return context.WithValue(ctx, clientKey, struct{}{})
+}
+
+func selected(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	if _, ok := contextutil.PinnedValue(ctx, clientKey); ok {
+		return true
+	}
+	return ctx.Value(clientKey) != nil
+}
+
+func clientString(p netip.Prefix) string {
+	if !p.IsValid() {
+		return "any"
+	}
+	return p.String()
+}
@@ -0,0 +1,128 @@
+package debuglog
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/semihalev/zlog/v2"
+)
+
+func setup(t *testing.T) {
+	t.Helper()
+	old := zlog.Default().GetLevel()
+	Init(zlog.LevelInfo, zlog.DiscardWriter())
+	zlog.SetLevel(zlog.LevelInfo)
+	t.Cleanup(func() {
+		Revert()
+		zlog.SetLevel(old)
+	})
+}
+
+func TestSubsystemNames(t *testing.T) {
+	sub, ok := ParseSubsystem("DNSSEC")
+	if !ok || sub != DNSSEC {
+		t.Fatalf("ParseSubsystem(DNSSEC) = %v, %v", sub, ok)
+	}
+	if _, ok := ParseSubsystem("bogus"); ok {
+		t.Fatal("ParseSubsystem accepted an unknown name")
+	}
+	if got := (Resolver | Kubernetes).String(); got != "resolver,kubernetes" {
+		t.Fatalf("String = %q", got)
+	}
+	for _, name := range []string{"debug", "info", "warn", "error"} {
+		lvl, ok := ParseLevel(name)
+		if !ok || LevelName(lvl) != name {
+			t.Fatalf("level %q round trip: %v %v", name, lvl, ok)
+		}
+	}
+}
+
+func TestApplyAndRevert(t *testing.T) {
+	setup(t)
+
+	ctx := context.Background()
+	if On(ctx, Resolver) || Enabled(Resolver) {
+		t.Fatal("resolver debug on at info level")
+	}
+
+	until := Apply(Settings{Level: zlog.LevelWarn, Subsystems: Resolver, DebugNS: true}, time.Hour)
+	if time.Until(until) < 59*time.Minute {
+		t.Fatalf("reverts at %v", until)
+	}
+	if zlog.Default().GetLevel() != zlog.LevelWarn {
+		t.Fatal("level not applied")
+	}
+	if !On(ctx, Resolver) || !Enabled(Resolver|DNSSEC) {
+		t.Fatal("resolver debug off with the subsystem switched on")
+	}
+	if On(ctx, Cache) {
+		t.Fatal("cache debug on with only the resolver switched on")
+	}
+	if !DebugNS() {
+		t.Fatal("DebugNS off")
+	}
+
+	Revert()
+	if zlog.Default().GetLevel() != zlog.LevelInfo || On(ctx, Resolver) || DebugNS() {
+		t.Fatal("Revert left settings in force")
+	}
+	if s, until := Current(); s.Level != zlog.LevelInfo || s.Subsystems != 0 || !until.IsZero() {
+		t.Fatalf("Current after Revert = %+v %v", s, until)
+	}
+}
+
+func TestRevertTimer(t *testing.T) {
+	setup(t)
+
+	Apply(Settings{Level: zlog.LevelDebug}, 20*time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for zlog.Default().GetLevel() != zlog.LevelInfo {
+		if time.Now().After(deadline) {
+			t.Fatal("change did not revert")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A replaced change's timer must not revert its successor.
+	Apply(Settings{Level: zlog.LevelDebug}, 20*time.Millisecond)
+	Apply(Settings{Level: zlog.LevelWarn}, time.Hour)
+	time.Sleep(50 * time.Millisecond)
+	if zlog.Default().GetLevel() != zlog.LevelWarn {
+		t.Fatal("an earlier change's timer reverted a later one")
+	}
+}
+
+func TestClientFilter(t *testing.T) {
+	setup(t)
+
+	Apply(Settings{Level: zlog.LevelInfo, Subsystems: Forwarder, Client: netip.MustParsePrefix("192.0.2.0/24")}, time.Hour)
+	if !Filtering() {
+		t.Fatal("Filtering is false")
+	}
+
+	other := MarkClient(context.Background(), net.ParseIP("198.51.100.1"))
+	if On(other, Forwarder) {
+		t.Fatal("debug on for a client outside the filter")
+	}
+	if On(context.Background(), Forwarder) {
+		t.Fatal("debug on for work with no client")
+	}
+
+	// net.ParseIP gives the 16-byte form; the filter must see through it.
+	ctx := MarkClient(context.Background(), net.ParseIP("192.0.2.7"))
+	if !On(ctx, Forwarder) {
+		t.Fatal("debug off for the filtered client")
+	}
+	if On(ctx, Resolver) {
+		t.Fatal("debug on for a subsystem that is off")
+	}
+	if !On(Carry(ctx, context.Background()), Forwarder) {
+		t.Fatal("Carry lost the client mark")
+	}
+	if On(Carry(other, context.Background()), Forwarder) {
+		t.Fatal("Carry marked an unselected context")
+	}
+}
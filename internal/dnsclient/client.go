@@ -21,11 +21,12 @@ import (
 // section guard is on by default; the response transaction ID is always
 // validated.
 type Client struct {
-	Proto     string        // "udp" | "tcp" | "tcp-tls" | "doh"; empty means "udp"
+	Proto     string        // "udp" | "tcp" | "tcp-tls" | "doh" | "odoh"; empty means "udp"
 	Timeout   time.Duration // per-exchange dial+read+write budget; 0 means none
 	TLSConfig *tls.Config   // DoT (tcp-tls) server config
 	DoHURL    string        // DoH endpoint URL
 	DoHClient *http.Client  // DoH HTTP client (reused transport / HTTP2 pool)
+	ODoH      *ODoH         // Oblivious DoH target and relay
 
 	// BeforeAttempt runs immediately before each wire transport attempt.
 	// It is inherited by the transparent UDP-to-TCP fallback, allowing
@@ -55,9 +56,15 @@ func (c *Client) Exchange(ctx context.Context, req *dns.Msg, addr string) (*dns.
 		}
 	}
 
-	if proto == "doh" {
+	if proto == "doh" || proto == "odoh" {
 		t := time.Now()
-		resp, err := dohExchange(ctx, req, c.DoHURL, c.DoHClient)
+		var resp *dns.Msg
+		var err error
+		if proto == "odoh" {
+			resp, err = c.ODoH.exchange(ctx, req)
+		} else {
+			resp, err = dohExchange(ctx, req, c.DoHURL, c.DoHClient)
+		}
 		rtt := time.Since(t)
 		if err != nil {
 			return nil, rtt, err
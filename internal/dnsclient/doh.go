@@ -35,12 +35,35 @@ func dohExchange(ctx context.Context, req *dns.Msg, url string, client *http.Cli
 		return nil, fmt.Errorf("pack: %w", err)
 	}
 
+	respBody, err := httpExchange(ctx, client, url, contentTypeDNS, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := new(dns.Msg)
+	if err := resp.Unpack(respBody); err != nil {
+		return nil, fmt.Errorf("unpack: %w", err)
+	}
+	if err := checkDoHID(req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// statusError is a non-200 answer to a DoH (or ODoH) POST.
+type statusError int
+
+func (e statusError) Error() string { return fmt.Sprintf("doh status %d", int(e)) }
+
+// httpExchange POSTs body as contentType to url and returns the body of
+// the response, which must be of the same type and fit a DNS message.
+func httpExchange(ctx context.Context, client *http.Client, url, contentType string, body []byte) ([]byte, error) {
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("build request: %w", err)
 	}
-	httpReq.Header.Set("Content-Type", contentTypeDNS)
-	httpReq.Header.Set("Accept", contentTypeDNS)
+	httpReq.Header.Set("Content-Type", contentType)
+	httpReq.Header.Set("Accept", contentType)
 
 	httpResp, err := client.Do(httpReq)
 	if err != nil {
@@ -53,7 +76,7 @@ func dohExchange(ctx context.Context, req *dns.Msg, url string, client *http.Cli
 		// reused (Go's http.Transport requires the body be read before
 		// the conn returns to the pool).
 		_, _ = io.Copy(io.Discard, io.LimitReader(httpResp.Body, 1024))
-		return nil, fmt.Errorf("doh status %d", httpResp.StatusCode)
+		return nil, statusError(httpResp.StatusCode)
 	}
 
 	// RFC 7231 §3.1.1.1: media types are case-insensitive and may carry
@@ -61,7 +84,7 @@ func dohExchange(ctx context.Context, req *dns.Msg, url string, client *http.Cli
 	// parameters and lowercases the type for us.
 	rawCT := httpResp.Header.Get("Content-Type")
 	mediaType, _, err := mime.ParseMediaType(rawCT)
-	if err != nil || !strings.EqualFold(mediaType, contentTypeDNS) {
+	if err != nil || !strings.EqualFold(mediaType, contentType) {
 		_, _ = io.Copy(io.Discard, io.LimitReader(httpResp.Body, 1024))
 		return nil, fmt.Errorf("doh unexpected content-type %q", rawCT)
 	}
@@ -77,19 +100,17 @@ func dohExchange(ctx context.Context, req *dns.Msg, url string, client *http.Cli
 	if len(respBody) > dohMaxResponseSize {
 		return nil, fmt.Errorf("doh response exceeds %d bytes", dohMaxResponseSize)
 	}
+	return respBody, nil
+}
 
-	resp := new(dns.Msg)
-	if err := resp.Unpack(respBody); err != nil {
-		return nil, fmt.Errorf("unpack: %w", err)
-	}
-
-	// Validate the response transaction ID. RFC 8484 §4.1 says DoH
-	// clients SHOULD use DNS ID 0 for cache friendliness, and several
-	// compliant servers normalise the response ID to 0 regardless of
-	// what the request carried — so accept either an exact echo or a
-	// zero. Anything else is a buggy or hostile upstream.
+// checkDoHID validates the response transaction ID. RFC 8484 §4.1 says
+// DoH clients SHOULD use DNS ID 0 for cache friendliness, and several
+// compliant servers normalise the response ID to 0 regardless of what
+// the request carried — so accept either an exact echo or a zero.
+// Anything else is a buggy or hostile upstream.
+func checkDoHID(req, resp *dns.Msg) error {
 	if resp.Id != req.Id && resp.Id != 0 {
-		return nil, fmt.Errorf("doh response ID mismatch: got %d, want %d or 0", resp.Id, req.Id)
+		return fmt.Errorf("doh response ID mismatch: got %d, want %d or 0", resp.Id, req.Id)
 	}
-	return resp, nil
+	return nil
 }
@@ -0,0 +1,134 @@
+package dnsclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/odoh"
+)
+
+// ODoH is an Oblivious DoH upstream (RFC 9230): the target that answers
+// queries sealed to its key, and the relay they reach it through. The
+// relay sees the client but not the query, the target the query but not
+// the client. Without a relay the target is queried directly, which
+// keeps the query from the network but not the client from the target.
+//
+// The target's configs are fetched on first use, from the target itself,
+// and again when it stops recognising the key they carry.
+type ODoH struct {
+	// Target is the target's endpoint, https://host/path.
+	Target *url.URL
+	// TargetClient reaches the target: for its configs, and for the
+	// queries when there is no relay.
+	TargetClient *http.Client
+	// Relay is the relay's endpoint, nil for none; RelayClient reaches it.
+	Relay       *url.URL
+	RelayClient *http.Client
+
+	mu     sync.Mutex
+	config *odoh.Config
+}
+
+// exchange seals req to the target, sends it through the relay and
+// opens the answer, fetching the configs again once if the target no
+// longer holds their key.
+func (o *ODoH) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	buf, err := req.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("pack: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		config, err := o.targetConfig(ctx, attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+		sealed, qc, err := odoh.EncryptQuery(*config, buf)
+		if err != nil {
+			return nil, fmt.Errorf("odoh seal: %w", err)
+		}
+		body, err := httpExchange(ctx, o.client(), o.queryURL(), odoh.ContentType, sealed)
+		var status statusError
+		if errors.As(err, &status) && status == http.StatusUnauthorized && attempt == 0 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		opened, err := qc.DecryptResponse(body)
+		if err != nil {
+			return nil, fmt.Errorf("odoh open: %w", err)
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(opened); err != nil {
+			return nil, fmt.Errorf("unpack: %w", err)
+		}
+		if err := checkDoHID(req, resp); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// client returns the client the queries go out on.
+func (o *ODoH) client() *http.Client {
+	if o.Relay != nil {
+		return o.RelayClient
+	}
+	return o.TargetClient
+}
+
+// queryURL returns where the queries are POSTed: the target, or the
+// relay naming it.
+func (o *ODoH) queryURL() string {
+	if o.Relay == nil {
+		return o.Target.String()
+	}
+	u := *o.Relay
+	q := u.Query()
+	q.Set("targethost", o.Target.Host)
+	q.Set("targetpath", o.Target.EscapedPath())
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// targetConfig returns the target's preferred config, fetching the
+// configs when none is held or refresh is set.
+func (o *ODoH) targetConfig(ctx context.Context, refresh bool) (*odoh.Config, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.config != nil && !refresh {
+		return o.config, nil
+	}
+
+	u := url.URL{Scheme: "https", Host: o.Target.Host, Path: odoh.ConfigsPath}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	httpResp, err := o.TargetClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("odoh configs: %w", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, io.LimitReader(httpResp.Body, 1024))
+		return nil, fmt.Errorf("odoh configs status %d", httpResp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, dohMaxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("read configs: %w", err)
+	}
+	configs, err := odoh.ParseConfigs(body)
+	if err != nil {
+		return nil, err
+	}
+	o.config = &configs[0]
+	return o.config, nil
+}
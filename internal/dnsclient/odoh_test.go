@@ -0,0 +1,133 @@
+package dnsclient
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/odoh"
+)
+
+// odohTarget is a minimal ODoH target whose key the test can replace.
+type odohTarget struct {
+	t        *testing.T
+	mu       sync.Mutex
+	key      *odoh.KeyPair
+	fetches  int
+	relayed  int
+	lastHost string
+}
+
+func (tg *odohTarget) rotate() {
+	secret, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		tg.t.Fatal(err)
+	}
+	key, err := odoh.NewKeyPair(secret)
+	if err != nil {
+		tg.t.Fatal(err)
+	}
+	tg.mu.Lock()
+	tg.key = key
+	tg.mu.Unlock()
+}
+
+func (tg *odohTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tg.mu.Lock()
+	key := tg.key
+	if r.URL.Query().Has("targethost") {
+		tg.relayed++
+		tg.lastHost = r.URL.Query().Get("targethost")
+	}
+	if r.URL.Path == odoh.ConfigsPath {
+		tg.fetches++
+	}
+	tg.mu.Unlock()
+
+	if r.URL.Path == odoh.ConfigsPath {
+		_, _ = w.Write(odoh.MarshalConfigs(key.Config()))
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	buf, rc, err := key.DecryptQuery(body)
+	if errors.Is(err, odoh.ErrKeyID) {
+		http.Error(w, "unknown key", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in := new(dns.Msg)
+	if err := in.Unpack(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sealed, err := rc.EncryptResponse(dohResponseFor(tg.t, in, ""))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", odoh.ContentType)
+	_, _ = w.Write(sealed)
+}
+
+func startODoHTestServer(t *testing.T) (*Client, *odohTarget) {
+	t.Helper()
+	tg := &odohTarget{t: t}
+	tg.rotate()
+	srv := httptest.NewTLSServer(tg)
+	t.Cleanup(srv.Close)
+	target, err := url.Parse(srv.URL + "/dns-query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{Proto: "odoh", ODoH: &ODoH{Target: target, TargetClient: srv.Client()}}
+	return c, tg
+}
+
+func TestClientExchange_ODoH(t *testing.T) {
+	c, tg := startODoHTestServer(t)
+	req := newReq()
+	resp, _, err := c.Exchange(context.Background(), req, "")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if len(resp.Answer) != 1 {
+		t.Fatalf("answer = %v", resp.Answer)
+	}
+
+	// A target that changed its key answers 401, and the client fetches
+	// the configs again and retries.
+	tg.rotate()
+	if _, _, err := c.Exchange(context.Background(), newReq(), ""); err != nil {
+		t.Fatalf("Exchange after key change: %v", err)
+	}
+	if tg.fetches != 2 {
+		t.Errorf("configs fetched %d times, want 2", tg.fetches)
+	}
+}
+
+func TestClientExchange_ODoHRelay(t *testing.T) {
+	c, tg := startODoHTestServer(t)
+	// The test server is target and relay both: what matters here is
+	// that the query goes out naming its target.
+	relay := *c.ODoH.Target
+	relay.Path = "/proxy"
+	c.ODoH.Relay, c.ODoH.RelayClient = &relay, c.ODoH.TargetClient
+
+	if _, _, err := c.Exchange(context.Background(), newReq(), ""); err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if tg.relayed != 1 || tg.lastHost != c.ODoH.Target.Host {
+		t.Errorf("relayed %d queries, last to %q", tg.relayed, tg.lastHost)
+	}
+}
@@ -0,0 +1,73 @@
+// Package dnssecalg names the DNSKEY algorithms and DS digest types the
+// validator implements, so a DNSSEC policy can be checked by the config
+// loader without pulling in the validator itself.
+package dnssecalg
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Algorithms are the DNSKEY algorithms the validator can verify, in IANA
+// order. RSAMD5 and DSA are absent because RFC 8624 §3.1 says MUST NOT
+// validate; ECC-GOST because nothing implements it. The validator's tests
+// hold its verifiers to this list.
+var Algorithms = []uint8{
+	dns.RSASHA1,
+	dns.RSASHA1NSEC3SHA1,
+	dns.RSASHA256,
+	dns.RSASHA512,
+	dns.ECDSAP256SHA256,
+	dns.ECDSAP384SHA384,
+	dns.ED25519,
+	dns.ED448,
+}
+
+// Digests are the DS digest types the validator computes. GOST R
+// 34.11-94 (3) is MAY in RFC 8624 §3.3 and unimplemented.
+var Digests = []uint8{dns.SHA1, dns.SHA256, dns.SHA384}
+
+// Algorithm parses a DNSKEY algorithm as IANA spells it ("RSASHA256",
+// "ED448") or by number. A name that is unknown, or known but not in
+// Algorithms, is an error.
+func Algorithm(name string) (uint8, error) {
+	alg, ok := parse(name, dns.StringToAlgorithm)
+	if !ok {
+		return 0, fmt.Errorf("unknown DNSSEC algorithm %q", name)
+	}
+	if !slices.Contains(Algorithms, alg) {
+		return 0, fmt.Errorf("DNSSEC algorithm %q is not implemented", name)
+	}
+	return alg, nil
+}
+
+// Digest parses a DS digest type ("SHA384") or its number the same way,
+// against Digests.
+func Digest(name string) (uint8, error) {
+	digest, ok := parse(name, dns.StringToHash)
+	if !ok {
+		return 0, fmt.Errorf("unknown DS digest type %q", name)
+	}
+	if !slices.Contains(Digests, digest) {
+		return 0, fmt.Errorf("DS digest type %q is not implemented", name)
+	}
+	return digest, nil
+}
+
+// parse accepts either an IANA mnemonic or the registry number, so an
+// operator can name an algorithm the library has no constant for and be
+// told it is unimplemented rather than unknown.
+func parse(name string, table map[string]uint8) (uint8, bool) {
+	if v, ok := table[strings.ToUpper(name)]; ok {
+		return v, true
+	}
+	n, err := strconv.ParseUint(name, 10, 8)
+	if err != nil {
+		return 0, false
+	}
+	return uint8(n), true
+}
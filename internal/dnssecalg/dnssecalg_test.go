@@ -0,0 +1,51 @@
+package dnssecalg
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestAlgorithm(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    uint8
+		wantErr bool
+	}{
+		{name: "RSASHA256", want: dns.RSASHA256},
+		{name: "ecdsap384sha384", want: dns.ECDSAP384SHA384},
+		{name: "RSASHA1-NSEC3-SHA1", want: dns.RSASHA1NSEC3SHA1},
+		{name: "16", want: dns.ED448},
+		{name: "RSASHA257", wantErr: true},
+		{name: "RSAMD5", wantErr: true},
+		{name: "ECC-GOST", wantErr: true},
+		{name: "200", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Algorithm(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Algorithm(%q) = %d, %v; want %d, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    uint8
+		wantErr bool
+	}{
+		{name: "SHA1", want: dns.SHA1},
+		{name: "sha384", want: dns.SHA384},
+		{name: "2", want: dns.SHA256},
+		{name: "GOST94", wantErr: true},
+		{name: "SHA512", wantErr: true},
+		{name: "MD5", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Digest(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Digest(%q) = %d, %v; want %d, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package dnsutil
+
+import "github.com/miekg/dns"
+
+// Block lengths of the RFC 8467 block-length padding policy: a query is
+// padded to a multiple of 128 octets, and a response to a padded query to
+// a multiple of 468.
+const (
+	QueryPaddingBlock    = 128
+	ResponsePaddingBlock = 468
+)
+
+// PaddingLen returns the length of the Padding option payload (RFC 7830)
+// that brings a message of n octets, before the option's own 4-octet
+// header is added, to a multiple of block.
+func PaddingLen(n, block int) int {
+	return (block - (n+4)%block) % block
+}
+
+// Pad sets the Padding option on m's OPT record so m packs to a multiple
+// of block octets, replacing any padding it carried. A message without
+// an OPT record is left as it is; padding is an EDNS option. m must be
+// packed with the compression setting it has now.
+func Pad(m *dns.Msg, block int) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
+	opt.Option = StripPadding(opt.Option)
+	n := PaddingLen(m.Len(), block)
+	opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, n)})
+}
+
+// StripPadding returns opts without their Padding options, in place.
+func StripPadding(opts []dns.EDNS0) []dns.EDNS0 {
+	keep := opts[:0]
+	for _, o := range opts {
+		if _, isPadding := o.(*dns.EDNS0_PADDING); isPadding {
+			continue
+		}
+		keep = append(keep, o)
+	}
+	return keep
+}
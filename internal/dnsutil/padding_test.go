@@ -0,0 +1,59 @@
+package dnsutil
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPad(t *testing.T) {
+	for _, block := range []int{QueryPaddingBlock, ResponsePaddingBlock} {
+		for _, name := range []string{"a.", "example.com.", "a-rather-longer-label.subdomain.example.org."} {
+			m := new(dns.Msg)
+			m.SetQuestion(name, dns.TypeAAAA)
+			m.SetEdns0(1232, true)
+			m.Compress = true
+			Pad(m, block)
+			// Padding twice replaces the first, it does not add to it.
+			Pad(m, block)
+
+			packed, err := m.Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(packed)%block != 0 {
+				t.Errorf("%s padded to %d octets, not a multiple of %d", name, len(packed), block)
+			}
+			var options int
+			for _, o := range m.IsEdns0().Option {
+				if _, ok := o.(*dns.EDNS0_PADDING); ok {
+					options++
+				}
+			}
+			if options != 1 {
+				t.Errorf("%s carries %d padding options", name, options)
+			}
+		}
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	Pad(m, QueryPaddingBlock)
+	if len(m.Extra) != 0 {
+		t.Error("padding added an OPT record")
+	}
+}
+
+func TestPaddingLen(t *testing.T) {
+	for _, tc := range []struct{ n, block, want int }{
+		{124, 128, 0},
+		{125, 128, 127},
+		{100, 128, 24},
+		{464, 468, 0},
+		{465, 468, 467},
+	} {
+		if got := PaddingLen(tc.n, tc.block); got != tc.want {
+			t.Errorf("PaddingLen(%d, %d) = %d, want %d", tc.n, tc.block, got, tc.want)
+		}
+	}
+}
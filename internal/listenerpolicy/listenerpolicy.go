@@ -0,0 +1,22 @@
+// Package listenerpolicy holds the per-listener policy a transport
+// stamps on its queries. It is middleware.ListenerPolicy, kept in a leaf
+// package so the test writer in internal/mock can carry one without
+// importing middleware, whose own tests import the mock.
+package listenerpolicy
+
+// Policy identifies the [[listeners]] entry a client query arrived on.
+// Handlers with a per-listener override — the access list, the rate
+// limit, views — compile it at setup into a table indexed by Index, so
+// the query side is one slice index. Queries on the bind addresses and
+// internal sub-queries carry no policy, and the global settings apply to
+// them.
+type Policy struct {
+	// Index is the entry's position in cfg.Listeners.
+	Index int
+	// Name is the entry's label in logs and metrics.
+	Name string
+	// RefuseRecursion makes the cache, resolver and forwarder answer
+	// REFUSED instead; each checks it, so a chain without the cache, or
+	// a query that does not stop there, is refused all the same.
+	RefuseRecursion bool
+}
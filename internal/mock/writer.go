@@ -4,6 +4,7 @@ import (
 	"net"
 
 	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/listenerpolicy"
 )
 
 // internalIP is the sentinel loopback address used to tag synthesised
@@ -13,6 +14,12 @@ var internalIP = net.IPv4(127, 0, 0, 255)
 
 // Writer type.
 type Writer struct {
+	// Policy is the listener policy the writer reports, the type
+	// middleware.ListenerPolicy aliases; nil for the bind addresses.
+	Policy *listenerpolicy.Policy
+	// Identity is the client identity the writer reports, "" for none.
+	Identity string
+
 	msg  *dns.Msg
 	size int
 
@@ -124,3 +131,9 @@ func (w *Writer) RemoteAddr() net.Addr { return w.remoteAddr }
 
 // (*Writer).Internal internal func.
 func (w *Writer) Internal() bool { return w.internal }
+
+// (*Writer).ListenerPolicy listenerPolicy func.
+func (w *Writer) ListenerPolicy() *listenerpolicy.Policy { return w.Policy }
+
+// (*Writer).ClientIdentity clientIdentity func.
+func (w *Writer) ClientIdentity() string { return w.Identity }
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/listenerpolicy"
 )
 
 func Test_Writer(t *testing.T) {
@@ -73,4 +74,13 @@ func Test_Writer(t *testing.T) {
 	if !(mw.Internal()) {
 		t.Errorf("mw.Internal() is false")
 	}
+
+	if mw.ListenerPolicy() != nil || mw.ClientIdentity() != "" {
+		t.Errorf("new writer reports policy %v, identity %q; want none", mw.ListenerPolicy(), mw.ClientIdentity())
+	}
+	mw.Policy = &listenerpolicy.Policy{Name: "lan"}
+	mw.Identity = "device:kids-ipad"
+	if mw.ListenerPolicy().Name != "lan" || mw.ClientIdentity() != "device:kids-ipad" {
+		t.Errorf("writer reports policy %v, identity %q", mw.ListenerPolicy(), mw.ClientIdentity())
+	}
 }
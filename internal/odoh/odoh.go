@@ -0,0 +1,453 @@
+// Package odoh implements the messages of Oblivious DNS over HTTPS (RFC
+// 9230): the target's HPKE configuration, queries sealed to it, and the
+// responses sealed back under a key only the querying client can derive.
+// The HTTP side — target, relay and client — lives with the DoH code.
+package odoh
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hpke"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/semihalev/sdns/internal/dnsutil"
+)
+
+const (
+	// ContentType is the media type of ODoH queries and responses.
+	ContentType = "application/oblivious-dns-message"
+	// ConfigsPath is where a target publishes its ObliviousDoHConfigs.
+	ConfigsPath = "/.well-known/odohconfigs"
+
+	// configVersion is the ObliviousDoHConfig version of RFC 9230.
+	configVersion = 0x0001
+
+	msgQuery    = 0x01
+	msgResponse = 0x02
+
+	// The suite a target serves: DHKEM(X25519, HKDF-SHA256),
+	// HKDF-SHA256, AES-128-GCM — the one every ODoH client speaks.
+	kemX25519  = 0x0020
+	kdfSHA256  = 0x0001
+	kdfSHA384  = 0x0002
+	kdfSHA512  = 0x0003
+	aeadAES128 = 0x0001
+	aeadAES256 = 0x0002
+	aeadChaCha = 0x0003
+
+	// x25519EncSize is the size of a DHKEM(X25519) encapsulated key.
+	x25519EncSize = 32
+
+	labelQuery    = "odoh query"
+	labelResponse = "odoh response"
+)
+
+var (
+	// ErrKeyID is a query for a key the target does not hold; the
+	// client should fetch the configuration again.
+	ErrKeyID = errors.New("odoh: unknown key id")
+	// ErrMalformed is a message that does not parse.
+	ErrMalformed = errors.New("odoh: malformed message")
+	// ErrDecrypt is a message that does not open.
+	ErrDecrypt = errors.New("odoh: decryption failed")
+	// ErrNoConfig is a configuration list with no suite this package
+	// supports.
+	ErrNoConfig = errors.New("odoh: no supported config")
+)
+
+// Config is one ObliviousDoHConfigContents: an HPKE suite and the
+// target's public key in it.
+type Config struct {
+	KEM       uint16
+	KDF       uint16
+	AEAD      uint16
+	PublicKey []byte
+}
+
+// contents serializes c as ObliviousDoHConfigContents.
+func (c Config) contents() []byte {
+	b := make([]byte, 0, 8+len(c.PublicKey))
+	b = binary.BigEndian.AppendUint16(b, c.KEM)
+	b = binary.BigEndian.AppendUint16(b, c.KDF)
+	b = binary.BigEndian.AppendUint16(b, c.AEAD)
+	return appendVector(b, c.PublicKey)
+}
+
+// KeyID returns the key identifier queries for c carry.
+func (c Config) KeyID() ([]byte, error) {
+	s, err := c.suite()
+	if err != nil {
+		return nil, err
+	}
+	prk, err := hkdf.Extract(s.hash, c.contents(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return hkdf.Expand(s.hash, prk, "odoh key id", s.hash().Size())
+}
+
+// MarshalConfigs serializes configs as ObliviousDoHConfigs, what a
+// target publishes at ConfigsPath.
+func MarshalConfigs(configs ...Config) []byte {
+	var list []byte
+	for _, c := range configs {
+		contents := c.contents()
+		list = binary.BigEndian.AppendUint16(list, configVersion)
+		list = appendVector(list, contents)
+	}
+	return appendVector(nil, list)
+}
+
+// ParseConfigs returns the configurations in b this package can use, in
+// the target's order of preference.
+func ParseConfigs(b []byte) ([]Config, error) {
+	list, rest, ok := readVector(b)
+	if !ok || len(rest) != 0 {
+		return nil, ErrMalformed
+	}
+	var configs []Config
+	for len(list) > 0 {
+		if len(list) < 2 {
+			return nil, ErrMalformed
+		}
+		version := binary.BigEndian.Uint16(list)
+		var contents []byte
+		contents, list, ok = readVector(list[2:])
+		if !ok {
+			return nil, ErrMalformed
+		}
+		if version != configVersion || len(contents) < 6 {
+			continue
+		}
+		c := Config{
+			KEM:  binary.BigEndian.Uint16(contents),
+			KDF:  binary.BigEndian.Uint16(contents[2:]),
+			AEAD: binary.BigEndian.Uint16(contents[4:]),
+		}
+		pk, tail, ok := readVector(contents[6:])
+		if !ok || len(tail) != 0 {
+			continue
+		}
+		c.PublicKey = pk
+		if _, err := c.suite(); err == nil {
+			configs = append(configs, c)
+		}
+	}
+	if len(configs) == 0 {
+		return nil, ErrNoConfig
+	}
+	return configs, nil
+}
+
+// suite is a Config's HPKE components, and the hash and AEAD its
+// responses are sealed with outside HPKE.
+type suite struct {
+	kem  hpke.KEM
+	kdf  hpke.KDF
+	aead hpke.AEAD
+	hash func() hash.Hash
+	// keySize is the AEAD's Nk; its nonce size Nn is 12 for all three.
+	keySize int
+}
+
+const nonceSize = 12
+
+func (c Config) suite() (suite, error) {
+	var s suite
+	var err error
+	if s.kem, err = hpke.NewKEM(c.KEM); err != nil {
+		return s, err
+	}
+	switch c.KDF {
+	case kdfSHA256:
+		s.hash = sha256.New
+	case kdfSHA384:
+		s.hash = sha512.New384
+	case kdfSHA512:
+		s.hash = sha512.New
+	default:
+		return s, fmt.Errorf("odoh: unsupported kdf %#04x", c.KDF)
+	}
+	switch c.AEAD {
+	case aeadAES128:
+		s.keySize = 16
+	case aeadAES256, aeadChaCha:
+		s.keySize = 32
+	default:
+		return s, fmt.Errorf("odoh: unsupported aead %#04x", c.AEAD)
+	}
+	if s.kdf, err = hpke.NewKDF(c.KDF); err != nil {
+		return s, err
+	}
+	if s.aead, err = hpke.NewAEAD(c.AEAD); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// responseAEAD returns the AEAD responses of c are sealed with.
+func (c Config) responseAEAD(key []byte) (cipher.AEAD, error) {
+	if c.AEAD == aeadChaCha {
+		return chacha20poly1305.New(key)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// KeyPair is a target's HPKE key and the configuration it publishes.
+type KeyPair struct {
+	config Config
+	key    hpke.PrivateKey
+	keyID  []byte
+}
+
+// NewKeyPair returns the target key pair of an X25519 secret key.
+func NewKeyPair(secret *ecdh.PrivateKey) (*KeyPair, error) {
+	if secret.Curve() != ecdh.X25519() {
+		return nil, errors.New("odoh: target key must be X25519")
+	}
+	key, err := hpke.NewDHKEMPrivateKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	k := &KeyPair{
+		config: Config{KEM: kemX25519, KDF: kdfSHA256, AEAD: aeadAES128, PublicKey: secret.PublicKey().Bytes()},
+		key:    key,
+	}
+	if k.keyID, err = k.config.KeyID(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Config returns the configuration clients seal queries to.
+func (k *KeyPair) Config() Config { return k.config }
+
+// DecryptQuery opens an ObliviousDoHMessage query, returning the DNS
+// message in it and the context to seal the response with.
+func (k *KeyPair) DecryptQuery(msg []byte) ([]byte, *ResponseContext, error) {
+	keyID, sealed, err := parseMessage(msg, msgQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare(keyID, k.keyID) != 1 {
+		return nil, nil, ErrKeyID
+	}
+	if len(sealed) < x25519EncSize {
+		return nil, nil, ErrMalformed
+	}
+	s, err := k.config.suite()
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := hpke.NewRecipient(sealed[:x25519EncSize], k.key, s.kdf, s.aead, []byte(labelQuery))
+	if err != nil {
+		return nil, nil, ErrDecrypt
+	}
+	plain, err := r.Open(queryAAD(keyID), sealed[x25519EncSize:])
+	if err != nil {
+		return nil, nil, ErrDecrypt
+	}
+	dnsMsg, err := parsePlaintext(plain)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := r.Export(labelResponse, s.keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dnsMsg, &ResponseContext{config: k.config, suite: s, secret: secret, query: plain}, nil
+}
+
+// ResponseContext seals the response to one query.
+type ResponseContext struct {
+	config Config
+	suite  suite
+	secret []byte
+	query  []byte
+}
+
+// EncryptResponse seals the DNS message answering the query, padded as
+// RFC 8467 pads responses.
+func (rc *ResponseContext) EncryptResponse(dnsMsg []byte) ([]byte, error) {
+	nonce := make([]byte, max(nonceSize, rc.suite.keySize))
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	aead, iv, err := responseKey(rc.config, rc.suite, rc.secret, rc.query, nonce)
+	if err != nil {
+		return nil, err
+	}
+	plain := plaintext(dnsMsg, dnsutil.ResponsePaddingBlock)
+	return appendMessage(msgResponse, nonce, aead.Seal(nil, iv, plain, responseAAD(nonce))), nil
+}
+
+// QueryContext opens the response to one query a client sealed.
+type QueryContext struct {
+	config Config
+	suite  suite
+	secret []byte
+	query  []byte
+}
+
+// EncryptQuery seals dnsMsg to the target of config, padded as RFC 8467
+// pads queries.
+func EncryptQuery(config Config, dnsMsg []byte) ([]byte, *QueryContext, error) {
+	s, err := config.suite()
+	if err != nil {
+		return nil, nil, err
+	}
+	keyID, err := config.KeyID()
+	if err != nil {
+		return nil, nil, err
+	}
+	pk, err := s.kem.NewPublicKey(config.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc, sender, err := hpke.NewSender(pk, s.kdf, s.aead, []byte(labelQuery))
+	if err != nil {
+		return nil, nil, err
+	}
+	plain := plaintext(dnsMsg, dnsutil.QueryPaddingBlock)
+	ct, err := sender.Seal(queryAAD(keyID), plain)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := sender.Export(labelResponse, s.keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg := appendMessage(msgQuery, keyID, append(enc, ct...))
+	return msg, &QueryContext{config: config, suite: s, secret: secret, query: plain}, nil
+}
+
+// DecryptResponse opens the target's response, returning the DNS
+// message in it.
+func (qc *QueryContext) DecryptResponse(msg []byte) ([]byte, error) {
+	nonce, sealed, err := parseMessage(msg, msgResponse)
+	if err != nil {
+		return nil, err
+	}
+	aead, iv, err := responseKey(qc.config, qc.suite, qc.secret, qc.query, nonce)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, iv, sealed, responseAAD(nonce))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return parsePlaintext(plain)
+}
+
+// responseKey derives the key and nonce of a response from the secret
+// exported from the query's context.
+func responseKey(c Config, s suite, secret, query, nonce []byte) (cipher.AEAD, []byte, error) {
+	salt := appendVector(append([]byte(nil), query...), nonce)
+	prk, err := hkdf.Extract(s.hash, secret, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := hkdf.Expand(s.hash, prk, "odoh key", s.keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	iv, err := hkdf.Expand(s.hash, prk, "odoh nonce", nonceSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := c.responseAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, iv, nil
+}
+
+func queryAAD(keyID []byte) []byte    { return appendVector([]byte{msgQuery}, keyID) }
+func responseAAD(nonce []byte) []byte { return appendVector([]byte{msgResponse}, nonce) }
+
+// plaintext serializes ObliviousDoHMessagePlaintext: the DNS message
+// and zero padding bringing the whole to a multiple of block.
+func plaintext(dnsMsg []byte, block int) []byte {
+	size := 4 + len(dnsMsg)
+	padding := (block - size%block) % block
+	b := make([]byte, 0, size+padding)
+	b = appendVector(b, dnsMsg)
+	return appendVector(b, make([]byte, padding))
+}
+
+// parsePlaintext returns the DNS message of an ObliviousDoHMessagePlaintext,
+// whose padding must be zeros.
+func parsePlaintext(b []byte) ([]byte, error) {
+	dnsMsg, rest, ok := readVector(b)
+	if !ok || len(dnsMsg) == 0 {
+		return nil, ErrMalformed
+	}
+	padding, rest, ok := readVector(rest)
+	if !ok || len(rest) != 0 {
+		return nil, ErrMalformed
+	}
+	for _, p := range padding {
+		if p != 0 {
+			return nil, ErrMalformed
+		}
+	}
+	return dnsMsg, nil
+}
+
+// appendMessage serializes an ObliviousDoHMessage.
+func appendMessage(typ byte, keyID, sealed []byte) []byte {
+	b := make([]byte, 0, 5+len(keyID)+len(sealed))
+	b = append(b, typ)
+	b = appendVector(b, keyID)
+	return appendVector(b, sealed)
+}
+
+// parseMessage returns the key ID and sealed body of an ObliviousDoHMessage
+// of type typ.
+func parseMessage(b []byte, typ byte) (keyID, sealed []byte, err error) {
+	if len(b) < 1 || b[0] != typ {
+		return nil, nil, ErrMalformed
+	}
+	keyID, rest, ok := readVector(b[1:])
+	if !ok {
+		return nil, nil, ErrMalformed
+	}
+	sealed, rest, ok = readVector(rest)
+	if !ok || len(rest) != 0 || len(sealed) == 0 {
+		return nil, nil, ErrMalformed
+	}
+	return keyID, sealed, nil
+}
+
+// appendVector appends v with its two-byte length.
+func appendVector(b, v []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(v))) //nolint:gosec // G115 - callers bound v by a DNS message
+	return append(b, v...)
+}
+
+// readVector reads a vector with a two-byte length off b.
+func readVector(b []byte) (v, rest []byte, ok bool) {
+	if len(b) < 2 {
+		return nil, nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return nil, nil, false
+	}
+	return b[2 : 2+n], b[2+n:], true
+}
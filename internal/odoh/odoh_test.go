@@ -0,0 +1,142 @@
+package odoh
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/dnsutil"
+)
+
+func newKeyPair(t *testing.T) *KeyPair {
+	t.Helper()
+	secret, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := NewKeyPair(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func packQuery(t *testing.T) []byte {
+	t.Helper()
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	b, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	k := newKeyPair(t)
+	configs, err := ParseConfigs(MarshalConfigs(k.Config()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || !bytes.Equal(configs[0].PublicKey, k.Config().PublicKey) {
+		t.Fatalf("ParseConfigs() = %v", configs)
+	}
+
+	query := packQuery(t)
+	msg, qc, err := EncryptQuery(configs[0], query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, rc, err := k.DecryptQuery(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, query) {
+		t.Fatal("query changed in transit")
+	}
+	if len(qc.query)%dnsutil.QueryPaddingBlock != 0 {
+		t.Errorf("query plaintext is %d bytes, want a multiple of %d", len(qc.query), dnsutil.QueryPaddingBlock)
+	}
+
+	answer := append([]byte(nil), query...)
+	answer[2] |= 0x80
+	resp, err := rc.EncryptResponse(answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := qc.DecryptResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, answer) {
+		t.Fatal("response changed in transit")
+	}
+
+	// The response opens under its own query's context only.
+	_, other, err := EncryptQuery(configs[0], query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.DecryptResponse(resp); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("DecryptResponse under another query: %v, want ErrDecrypt", err)
+	}
+}
+
+func TestDecryptQueryErrors(t *testing.T) {
+	k := newKeyPair(t)
+	msg, _, err := EncryptQuery(newKeyPair(t).Config(), packQuery(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := k.DecryptQuery(msg); !errors.Is(err, ErrKeyID) {
+		t.Errorf("query for another key: %v, want ErrKeyID", err)
+	}
+
+	msg, _, err = EncryptQuery(k.Config(), packQuery(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), msg...)
+	tampered[len(tampered)-1] ^= 1
+	if _, _, err := k.DecryptQuery(tampered); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("tampered query: %v, want ErrDecrypt", err)
+	}
+	for _, b := range [][]byte{nil, {msgResponse}, msg[:len(msg)-1], append(msg, 0)} {
+		if _, _, err := k.DecryptQuery(b); !errors.Is(err, ErrMalformed) {
+			t.Errorf("DecryptQuery(%d bytes): %v, want ErrMalformed", len(b), err)
+		}
+	}
+}
+
+func TestParseConfigs(t *testing.T) {
+	k := newKeyPair(t)
+	unsupported := Config{KEM: kemX25519, KDF: kdfSHA256, AEAD: 0xffff, PublicKey: k.Config().PublicKey}
+	configs, err := ParseConfigs(MarshalConfigs(unsupported, k.Config()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || configs[0].AEAD != aeadAES128 {
+		t.Errorf("ParseConfigs() = %v, want the supported config only", configs)
+	}
+
+	if _, err := ParseConfigs(MarshalConfigs(unsupported)); !errors.Is(err, ErrNoConfig) {
+		t.Errorf("no supported config: %v, want ErrNoConfig", err)
+	}
+	if _, err := ParseConfigs([]byte{0, 5, 0}); !errors.Is(err, ErrMalformed) {
+		t.Errorf("truncated configs: %v, want ErrMalformed", err)
+	}
+}
+
+func TestPlaintextPadding(t *testing.T) {
+	p := plaintext([]byte{1, 2, 3}, dnsutil.ResponsePaddingBlock)
+	if len(p) != dnsutil.ResponsePaddingBlock {
+		t.Errorf("len = %d, want %d", len(p), dnsutil.ResponsePaddingBlock)
+	}
+	p[len(p)-1] = 1
+	if _, err := parsePlaintext(p); !errors.Is(err, ErrMalformed) {
+		t.Errorf("nonzero padding: %v, want ErrMalformed", err)
+	}
+}
@@ -0,0 +1,161 @@
+// Package tracing is SDNS's OpenTelemetry tracing: the process-wide tracer
+// the middleware chain and resolver start spans from, and the OTLP/HTTP
+// exporter behind it.
+//
+// Tracing is off until Setup enables it, and off it costs an atomic load
+// per call site: Start hands back the context it was given and a span that
+// records nothing, without allocating.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/semihalev/sdns/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the tracer in exported spans.
+const instrumentation = "github.com/semihalev/sdns"
+
+// state is an enabled tracer and the provider that exports its spans.
+type state struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+var (
+	active atomic.Pointer[state]
+
+	propagator = propagation.TraceContext{}
+)
+
+// Setup starts exporting spans as cfg describes. It does nothing when
+// tracing is disabled, and replaces the exporter of an earlier call.
+func Setup(cfg *config.Config, version string) error {
+	tc := cfg.Tracing
+	if !tc.Enabled {
+		return nil
+	}
+
+	if err := Validate(cfg); err != nil {
+		return err
+	}
+	endpoint, _ := tracesURL(tc.Endpoint)
+	name := tc.ServiceName
+	if name == "" {
+		name = "sdns"
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(endpoint),
+		otlptracehttp.WithHeaders(tc.Headers),
+	)
+	if err != nil {
+		return fmt.Errorf("tracing exporter: %w", err)
+	}
+	res := resource.NewSchemaless(
+		attribute.String("service.name", name),
+		attribute.String("service.version", version),
+	)
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tc.SampleRatio))),
+	)
+
+	if old := active.Swap(&state{provider: tp, tracer: tp.Tracer(instrumentation)}); old != nil {
+		_ = old.provider.Shutdown(context.Background())
+	}
+	return nil
+}
+
+// Validate reports whether the tracing section of cfg can be set up.
+func Validate(cfg *config.Config) error {
+	tc := cfg.Tracing
+	if !tc.Enabled {
+		return nil
+	}
+	if _, err := tracesURL(tc.Endpoint); err != nil {
+		return err
+	}
+	if tc.SampleRatio < 0 || tc.SampleRatio > 1 {
+		return fmt.Errorf("tracing sample_ratio %v is outside 0.0 to 1.0", tc.SampleRatio)
+	}
+	return nil
+}
+
+// tracesURL resolves the configured collector base URL to the OTLP/HTTP
+// traces endpoint. A URL that already names the traces path is kept.
+func tracesURL(endpoint string) (string, error) {
+	if endpoint == "" {
+		endpoint = "http://localhost:4318"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("tracing endpoint: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("tracing endpoint must be an http or https URL: " + endpoint)
+	}
+	if !strings.HasSuffix(u.Path, "/v1/traces") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// Shutdown exports the spans still buffered and stops tracing.
+func Shutdown(ctx context.Context) error {
+	st := active.Swap(nil)
+	if st == nil {
+		return nil
+	}
+	return st.provider.Shutdown(ctx)
+}
+
+// Enabled reports whether spans are being recorded. Call sites that would
+// build attributes check it first, so a disabled tracer costs nothing.
+func Enabled() bool { return active.Load() != nil }
+
+// Start starts a span named name as a child of the span in ctx, or as the
+// root of a new trace when ctx carries none. With tracing disabled it
+// returns ctx unchanged and a span that records nothing.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	st := active.Load()
+	if st == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return st.tracer.Start(ctx, name, opts...)
+}
+
+// Extract returns ctx carrying the remote span context of a W3C
+// traceparent in header, so the request's spans join the caller's trace.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	if active.Load() == nil || header.Get("traceparent") == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Carry returns to carrying the span of from, for contexts that are
+// derived afresh rather than from the one a span was started on.
+func Carry(from, to context.Context) context.Context {
+	if active.Load() == nil {
+		return to
+	}
+	sc := trace.SpanContextFromContext(from)
+	if !sc.IsValid() {
+		return to
+	}
+	return trace.ContextWithSpan(to, trace.SpanFromContext(from))
+}
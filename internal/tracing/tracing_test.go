@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/semihalev/sdns/config"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracesURL(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                                "http://localhost:4318/v1/traces",
+		"http://collector:4318":           "http://collector:4318/v1/traces",
+		"https://otel.example/":           "https://otel.example/v1/traces",
+		"https://otel.example/otlp":       "https://otel.example/otlp/v1/traces",
+		"http://collector:4318/v1/traces": "http://collector:4318/v1/traces",
+	} {
+		got, err := tracesURL(in)
+		if err != nil || got != want {
+			t.Errorf("tracesURL(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"collector:4318", "grpc://collector:4317", "http://"} {
+		if _, err := tracesURL(in); err == nil {
+			t.Errorf("tracesURL(%q) accepted", in)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := &config.Config{Tracing: config.TracingConfig{SampleRatio: 2}}
+	if err := Validate(cfg); err != nil {
+		t.Fatalf("disabled tracing was checked: %v", err)
+	}
+	cfg.Tracing.Enabled = true
+	if err := Validate(cfg); err == nil {
+		t.Fatal("a sample ratio above 1 was accepted")
+	}
+	cfg.Tracing.SampleRatio = 0.5
+	if err := Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	if Enabled() {
+		t.Fatal("tracing enabled without Setup")
+	}
+	ctx := context.Background()
+	got, span := Start(ctx, "noop")
+	if got != ctx || span.IsRecording() {
+		t.Fatal("disabled Start derived a context or a recording span")
+	}
+	span.End()
+
+	h := http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+	if Extract(ctx, h) != ctx {
+		t.Fatal("disabled Extract derived a context")
+	}
+	if n := testing.AllocsPerRun(100, func() {
+		_, span := Start(ctx, "noop")
+		span.End()
+	}); n != 0 {
+		t.Fatalf("disabled Start allocates %v times", n)
+	}
+}
+
+func TestCarry(t *testing.T) {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	})
+	from := trace.ContextWithSpanContext(context.Background(), sc)
+	to := context.Background()
+
+	if Carry(from, to) != to {
+		t.Fatal("disabled Carry derived a context")
+	}
+
+	active.Store(&state{})
+	defer active.Store(nil)
+	if got := trace.SpanContextFromContext(Carry(from, to)); !got.Equal(sc) {
+		t.Fatalf("carried %v, want %v", got, sc)
+	}
+	if Carry(to, to) != to {
+		t.Fatal("Carry derived a context without a span to carry")
+	}
+}
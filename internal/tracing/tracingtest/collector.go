@@ -0,0 +1,153 @@
+// Package tracingtest is an in-process stand-in for an OTLP/HTTP trace
+// collector, for tests that check the spans SDNS exports.
+package tracingtest
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/tracing"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Span is an exported span, reduced to what tests assert on.
+type Span struct {
+	Name     string
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Attrs    map[string]string
+	Error    bool
+}
+
+// Collector receives spans over OTLP/HTTP.
+type Collector struct {
+	URL string
+
+	srv     *httptest.Server
+	mu      sync.Mutex
+	spans   []Span
+	headers http.Header
+}
+
+// Start starts a collector and points tracing at it, sampling everything,
+// for the rest of the test. Call Flush before reading the spans.
+func Start(t testing.TB) *Collector {
+	t.Helper()
+	c := &Collector{}
+	c.srv = httptest.NewServer(http.HandlerFunc(c.serve))
+	c.URL = c.srv.URL
+	t.Cleanup(c.srv.Close)
+
+	cfg := &config.Config{Tracing: config.TracingConfig{
+		Enabled:     true,
+		Endpoint:    c.URL,
+		SampleRatio: 1,
+		Headers:     map[string]string{"X-Test": "tracingtest"},
+	}}
+	if err := tracing.Setup(cfg, "test"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tracing.Shutdown(context.Background()) })
+	return c
+}
+
+func (c *Collector) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req collectorpb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	c.headers = r.Header.Clone()
+	for _, rs := range req.GetResourceSpans() {
+		for _, ss := range rs.GetScopeSpans() {
+			for _, s := range ss.GetSpans() {
+				span := Span{
+					Name:     s.GetName(),
+					TraceID:  hex.EncodeToString(s.GetTraceId()),
+					SpanID:   hex.EncodeToString(s.GetSpanId()),
+					ParentID: hex.EncodeToString(s.GetParentSpanId()),
+					Attrs:    make(map[string]string),
+					Error:    s.GetStatus().GetCode() == 2,
+				}
+				for _, kv := range s.GetAttributes() {
+					span.Attrs[kv.GetKey()] = valueString(kv.GetValue())
+				}
+				c.spans = append(c.spans, span)
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	out, _ := proto.Marshal(&collectorpb.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(out)
+}
+
+func valueString(v *commonpb.AnyValue) string {
+	switch x := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return x.StringValue
+	case *commonpb.AnyValue_IntValue:
+		return fmt.Sprint(x.IntValue)
+	case *commonpb.AnyValue_BoolValue:
+		return fmt.Sprint(x.BoolValue)
+	case *commonpb.AnyValue_DoubleValue:
+		return fmt.Sprint(x.DoubleValue)
+	}
+	return v.String()
+}
+
+// Flush stops tracing, which exports every span still buffered to the
+// collector. Spans started afterwards are not recorded.
+func (c *Collector) Flush(t testing.TB) {
+	t.Helper()
+	if err := tracing.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Spans returns the spans received, in the order they arrived.
+func (c *Collector) Spans() []Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Span(nil), c.spans...)
+}
+
+// Named returns the spans received with the given name.
+func (c *Collector) Named(name string) []Span {
+	var out []Span
+	for _, s := range c.Spans() {
+		if s.Name == name {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Header returns a header of the last export request.
+func (c *Collector) Header(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.headers.Get(key)
+}
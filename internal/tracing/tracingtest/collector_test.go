@@ -0,0 +1,32 @@
+package tracingtest_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/semihalev/sdns/internal/tracing"
+	"github.com/semihalev/sdns/internal/tracing/tracingtest"
+)
+
+func TestExtractJoinsCallerTrace(t *testing.T) {
+	c := tracingtest.Start(t)
+
+	h := http.Header{}
+	h.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := tracing.Extract(context.Background(), h)
+	_, span := tracing.Start(ctx, "child")
+	span.End()
+	c.Flush(t)
+
+	spans := c.Named("child")
+	if len(spans) != 1 {
+		t.Fatalf("%d spans exported, want 1", len(spans))
+	}
+	if s := spans[0]; s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || s.ParentID != "00f067aa0ba902b7" {
+		t.Fatalf("span in trace %s under %s, want the caller's", s.TraceID, s.ParentID)
+	}
+	if tracing.Enabled() {
+		t.Fatal("tracing still enabled after Flush")
+	}
+}
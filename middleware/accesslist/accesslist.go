@@ -2,6 +2,7 @@ package accesslist
 
 import (
 	"context"
+	"net"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/semihalev/sdns/config"
@@ -22,6 +23,16 @@ var accessDenied = metric.NewCounter(nil, prometheus.CounterOpts{
 // List type.
 type List struct {
 	allowed *ipset.Set
+
+	// identities are the client certificate identities allowed; a
+	// client that has one is checked against them instead, when set.
+	identities *middleware.IdentitySet
+
+	// listeners holds the access list of each [[listeners]] entry that
+	// overrides the global one, by ListenerPolicy.Index; nil elsewhere.
+	// listenerIdentities does the same for the identities.
+	listeners          []*ipset.Set
+	listenerIdentities []*middleware.IdentitySet
 }
 
 // New return accesslist.
@@ -37,6 +48,21 @@ func New(cfg *config.Config) *List {
 		zlog.Error("Access list parse cidr failed", "cidr", entry.CIDR, "error", entry.Err.Error())
 	}
 	a.allowed = set
+	a.identities = middleware.NewIdentitySet(cfg.AccessIdentities)
+
+	a.listeners = make([]*ipset.Set, len(cfg.Listeners))
+	a.listenerIdentities = make([]*middleware.IdentitySet, len(cfg.Listeners))
+	for i, l := range cfg.Listeners {
+		a.listenerIdentities[i] = middleware.NewIdentitySet(l.AccessIdentities)
+		if len(l.AccessList) == 0 {
+			continue
+		}
+		set, bad := ipset.New(l.AccessList)
+		for _, entry := range bad {
+			zlog.Error("Access list parse cidr failed", "listener", l.Label(), "cidr", entry.CIDR, "error", entry.Err.Error())
+		}
+		a.listeners[i] = set
+	}
 
 	return a
 }
@@ -61,7 +87,7 @@ func (a *List) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 	// answers: the lookup is a binary search over compiled ranges and
 	// allocates nothing, which is why the open default no longer needs a
 	// flag to skip it.
-	if !a.allowed.ContainsIP(ch.Writer.RemoteIP()) {
+	if !a.admits(ch.Writer.RemoteIP(), ch.ClientIdentity(), ch.ListenerPolicy()) {
 		accessDenied.Inc()
 		// no reply to client
 		ch.Cancel()
@@ -71,4 +97,42 @@ func (a *List) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 	ch.Next(ctx)
 }
 
+// (*List).AdmitClient implements middleware.ClientGate.
+func (a *List) AdmitClient(ip net.IP, identity string, policy *middleware.ListenerPolicy) bool {
+	if !a.admits(ip, identity, policy) {
+		accessDenied.Inc()
+		return false
+	}
+	return true
+}
+
+// admits reports whether the client at ip, known by identity when that
+// is set, may use the listener of policy.
+func (a *List) admits(ip net.IP, identity string, policy *middleware.ListenerPolicy) bool {
+	allowed, identities := a.allowed, a.identities
+	if policy != nil && policy.Index < len(a.listeners) {
+		if a.listeners[policy.Index] != nil {
+			allowed = a.listeners[policy.Index]
+		}
+		if a.listenerIdentities[policy.Index] != nil {
+			identities = a.listenerIdentities[policy.Index]
+		}
+	}
+
+	// A client known by its certificate is allowed by who it is, not by
+	// the address it happens to connect from. A device ID is only a
+	// claim, so it earns nothing on its own: the address is checked, and
+	// the ID as well when identities are listed.
+	if identity != "" && identities != nil && !middleware.IsDeviceIdentity(identity) {
+		return identities.Contains(identity)
+	}
+	if !allowed.ContainsIP(ip) {
+		return false
+	}
+	if identity != "" && identities != nil {
+		return identities.Contains(identity)
+	}
+	return true
+}
+
 const name = "accesslist"
@@ -2,9 +2,11 @@ package accesslist
 
 import (
 	"context"
+	"net"
 	"reflect"
 	"testing"
 
+	"github.com/miekg/dns"
 	"github.com/semihalev/sdns/config"
 	"github.com/semihalev/sdns/internal/mock"
 	"github.com/semihalev/sdns/middleware"
@@ -79,3 +81,112 @@ func Test_AccesslistServesWithoutAllocating(t *testing.T) {
 		t.Fatalf("the access list allocated %.2f objects per query", allocs)
 	}
 }
+
+func Test_AccesslistListenerOverride(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.AccessList = []string{"192.168.1.0/24"}
+	cfg.Listeners = []config.ListenerConfig{
+		{Name: "lan", Proto: "dns", Addr: "192.168.1.1:53"},
+		{Name: "wireguard", Proto: "dns", Addr: "10.8.0.1:53", AccessList: []string{"10.8.0.0/24"}},
+	}
+	a := New(cfg)
+	policies := middleware.NewListenerPolicies(cfg)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+
+	for _, tc := range []struct {
+		client string
+		policy *middleware.ListenerPolicy
+		allow  bool
+	}{
+		{"192.168.1.7:5353", nil, true},
+		{"10.8.0.7:5353", nil, false},
+		{"192.168.1.7:5353", policies[0], true},
+		{"10.8.0.7:5353", policies[1], true},
+		{"192.168.1.7:5353", policies[1], false},
+	} {
+		var tail bool
+		ch := middleware.NewChain([]middleware.Handler{a, middleware.HandlerFunc(func(context.Context, *middleware.Chain) { tail = true })})
+		mw := mock.NewWriter("udp", tc.client)
+		mw.Policy = tc.policy
+		ch.Reset(mw, req)
+		ch.Next(context.Background())
+		if tail != tc.allow {
+			t.Errorf("client %s on %v: allowed = %v, want %v", tc.client, tc.policy, tail, tc.allow)
+		}
+	}
+}
+
+func Test_AccesslistIdentities(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.AccessList = []string{"192.168.1.0/24"}
+	cfg.AccessIdentities = []string{"spiffe://example.com/laptop/*"}
+	cfg.Listeners = []config.ListenerConfig{
+		{Name: "lan", Proto: "dns", Addr: "192.168.1.1:53"},
+		{Name: "ops", Proto: "tls", Addr: ":853", AccessIdentities: []string{"noc-1"}},
+	}
+	a := New(cfg)
+	policies := middleware.NewListenerPolicies(cfg)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+
+	for _, tc := range []struct {
+		client   string
+		identity string
+		policy   *middleware.ListenerPolicy
+		allow    bool
+	}{
+		// Identity decides, wherever the client connects from.
+		{"203.0.113.9:5353", "spiffe://example.com/laptop/7", nil, true},
+		{"192.168.1.7:5353", "spiffe://example.com/phone/2", nil, false},
+		// No certificate: the address list.
+		{"192.168.1.7:5353", "", nil, true},
+		{"203.0.113.9:5353", "", nil, false},
+		// A listener's identities replace the global ones.
+		{"203.0.113.9:5353", "noc-1", policies[1], true},
+		{"203.0.113.9:5353", "spiffe://example.com/laptop/7", policies[1], false},
+		{"203.0.113.9:5353", "spiffe://example.com/laptop/7", policies[0], true},
+	} {
+		var tail bool
+		ch := middleware.NewChain([]middleware.Handler{a, middleware.HandlerFunc(func(context.Context, *middleware.Chain) { tail = true })})
+		mw := mock.NewWriter("tcp", tc.client)
+		mw.Policy, mw.Identity = tc.policy, tc.identity
+		ch.Reset(mw, req)
+		ch.Next(context.Background())
+		if tail != tc.allow {
+			t.Errorf("client %s %q on %v: allowed = %v, want %v", tc.client, tc.identity, tc.policy, tail, tc.allow)
+		}
+	}
+}
+
+// A verified certificate identity is allowed by who it is; a device ID
+// is a claim the client made, and has to come from an allowed address
+// as well as be listed.
+func Test_AccesslistDeviceIDsCheckTheAddress(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.AccessList = []string{"10.0.0.0/8"}
+	cfg.AccessIdentities = []string{"laptop*", middleware.DeviceIdentity("kids-ipad")}
+	a := New(cfg)
+
+	inside, outside := net.ParseIP("10.1.2.3"), net.ParseIP("198.51.100.7")
+	for _, tc := range []struct {
+		name     string
+		ip       net.IP
+		identity string
+		want     bool
+	}{
+		{"certificate from anywhere", outside, "laptop1", true},
+		{"unlisted certificate", inside, "phone-2", false},
+		{"device from outside", outside, middleware.DeviceIdentity("kids-ipad"), false},
+		{"device from inside", inside, middleware.DeviceIdentity("kids-ipad"), true},
+		{"unlisted device from inside", inside, middleware.DeviceIdentity("tv-lounge"), false},
+		// A device pattern and a certificate CN never meet.
+		{"device named like a certificate", outside, middleware.DeviceIdentity("laptop1"), false},
+	} {
+		if got := a.admits(tc.ip, tc.identity, nil); got != tc.want {
+			t.Errorf("%s: admits = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package acmedns answers the _acme-challenge TXT records of the ACME
+// dns-01 challenge (RFC 8555 §8.4) for the server's own certificate.
+// While an order is pending the server adds each challenge's record with
+// Add and removes it with Remove once the CA has looked; the rest of the
+// time the handler answers nothing and every query passes it on one
+// counter load.
+package acmedns
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/middleware"
+)
+
+const name = "acmedns"
+
+var (
+	mu      sync.RWMutex
+	records = make(map[string][]string)
+	// pending is the number of names in records, read on every query.
+	pending atomic.Int32
+)
+
+// Add serves value as a TXT record of name until it is removed. A name
+// holds several values while a domain and its wildcard are validated
+// together.
+func Add(name, value string) {
+	name = dns.CanonicalName(name)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(records[name]) == 0 {
+		pending.Add(1)
+	}
+	records[name] = append(records[name], value)
+}
+
+// Remove stops serving value at name.
+func Remove(name, value string) {
+	name = dns.CanonicalName(name)
+	mu.Lock()
+	defer mu.Unlock()
+	values := records[name]
+	for i, v := range values {
+		if v == value {
+			values = append(values[:i:i], values[i+1:]...)
+			break
+		}
+	}
+	if len(values) == 0 {
+		if _, ok := records[name]; ok {
+			delete(records, name)
+			pending.Add(-1)
+		}
+		return
+	}
+	records[name] = values
+}
+
+func lookup(name string) []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return records[dns.CanonicalName(name)]
+}
+
+// ACMEDNS type.
+type ACMEDNS struct{}
+
+// New return a new middleware, nil unless ACME uses the dns-01 challenge.
+func New(cfg *config.Config) *ACMEDNS {
+	if !cfg.ACME.Enabled || cfg.ACME.Challenge != config.ACMEChallengeDNS {
+		return nil
+	}
+	return &ACMEDNS{}
+}
+
+// (*ACMEDNS).Name name return middleware name.
+func (a *ACMEDNS) Name() string { return name }
+
+// ClientOnly keeps the handler out of internal sub-queries.
+func (a *ACMEDNS) ClientOnly() bool { return true }
+
+// (*ACMEDNS).ServeDNS serveDNS implements the Handle interface.
+func (a *ACMEDNS) ServeDNS(ctx context.Context, ch *middleware.Chain) {
+	if pending.Load() == 0 || ch.Request.Qtype() != dns.TypeTXT || ch.Request.Qclass() != dns.ClassINET {
+		ch.Next(ctx)
+		return
+	}
+
+	ctx, req := ch.Materialize(ctx)
+	if req == nil {
+		return
+	}
+
+	q := req.Question[0]
+	values := lookup(q.Name)
+	if len(values) == 0 {
+		ch.Next(ctx)
+		return
+	}
+
+	msg := new(dns.Msg)
+	msg.SetReply(req)
+	msg.Authoritative = true
+	for _, v := range values {
+		msg.Answer = append(msg.Answer, &dns.TXT{
+			// No TTL: the value changes with every order, and a CA
+			// retrying a failed validation must not see the old one.
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+			Txt: []string{v},
+		})
+	}
+	_ = ch.Writer.WriteMsg(msg)
+	ch.Cancel()
+}
@@ -0,0 +1,88 @@
+package acmedns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/mock"
+	"github.com/semihalev/sdns/middleware"
+)
+
+func serve(t *testing.T, a *ACMEDNS, qname string, qtype uint16) (*mock.Writer, bool) {
+	t.Helper()
+	req := new(dns.Msg)
+	req.SetQuestion(qname, qtype)
+
+	var passed bool
+	next := middleware.HandlerFunc(func(context.Context, *middleware.Chain) { passed = true })
+	ch := middleware.NewChain([]middleware.Handler{a, next})
+	mw := mock.NewWriter("udp", "127.0.0.1:0")
+	ch.Reset(mw, req)
+	ch.Next(context.Background())
+	return mw, passed
+}
+
+func TestNew(t *testing.T) {
+	cfg := new(config.Config)
+	if New(cfg) != nil {
+		t.Error("enabled without ACME")
+	}
+	cfg.ACME = config.ACMEConfig{Enabled: true, Challenge: config.ACMEChallengeTLSALPN}
+	if New(cfg) != nil {
+		t.Error("enabled for tls-alpn-01")
+	}
+	cfg.ACME.Challenge = config.ACMEChallengeDNS
+	if New(cfg) == nil {
+		t.Error("disabled for dns-01")
+	}
+}
+
+func TestChallengeRecords(t *testing.T) {
+	a := &ACMEDNS{}
+
+	if _, passed := serve(t, a, "_acme-challenge.example.com.", dns.TypeTXT); !passed {
+		t.Error("query answered with nothing pending")
+	}
+
+	// A domain and its wildcard share the name.
+	Add("_acme-challenge.Example.com.", "one")
+	Add("_acme-challenge.example.com.", "two")
+
+	mw, passed := serve(t, a, "_ACME-challenge.example.com.", dns.TypeTXT)
+	if passed || !mw.Written() {
+		t.Fatal("challenge not answered")
+	}
+	msg := mw.Msg()
+	if !msg.Authoritative || len(msg.Answer) != 2 {
+		t.Fatalf("unexpected answer %v", msg)
+	}
+	for i, want := range []string{"one", "two"} {
+		txt := msg.Answer[i].(*dns.TXT)
+		if txt.Txt[0] != want || txt.Hdr.Ttl != 0 || txt.Hdr.Name != "_ACME-challenge.example.com." {
+			t.Errorf("answer %d = %v", i, txt)
+		}
+	}
+
+	if _, passed := serve(t, a, "_acme-challenge.example.com.", dns.TypeA); !passed {
+		t.Error("A query answered")
+	}
+	if _, passed := serve(t, a, "_acme-challenge.example.net.", dns.TypeTXT); !passed {
+		t.Error("other name answered")
+	}
+
+	Remove("_acme-challenge.example.com.", "one")
+	mw, _ = serve(t, a, "_acme-challenge.example.com.", dns.TypeTXT)
+	if len(mw.Msg().Answer) != 1 {
+		t.Errorf("removed value still served: %v", mw.Msg().Answer)
+	}
+	Remove("_acme-challenge.example.com.", "two")
+	Remove("_acme-challenge.example.com.", "two")
+	if pending.Load() != 0 {
+		t.Errorf("pending = %d after removing everything", pending.Load())
+	}
+	if _, passed := serve(t, a, "_acme-challenge.example.com.", dns.TypeTXT); !passed {
+		t.Error("query answered after removal")
+	}
+}
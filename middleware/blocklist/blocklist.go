@@ -94,6 +94,17 @@ type BlockList struct {
 	wild map[string]bool // wildcard domains suffix -> true (e.g., "example.com." from "*.example.com."); subdomains only
 	w    map[string]bool // whitelist
 
+	// sources records where the entries came from, for the API. It has
+	// a lock of its own: it is written while lists load and read by the
+	// API, never on the query path.
+	srcMu     sync.Mutex
+	sources   map[string]*Source
+	downloads map[string]string // downloaded file name -> source URL
+
+	// loaded is set once the first remote refresh has finished, whether
+	// or not every list could be fetched.
+	loaded atomic.Bool
+
 	cfg *config.Config
 }
 
@@ -122,6 +133,9 @@ func New(cfg *config.Config) *BlockList {
 		wild: make(map[string]bool),
 		w:    make(map[string]bool),
 
+		sources:   make(map[string]*Source),
+		downloads: make(map[string]string),
+
 		cfg: cfg,
 	}
 
@@ -137,6 +151,10 @@ func New(cfg *config.Config) *BlockList {
 	return b
 }
 
+// (*BlockList).Ready reports whether the remote blocklists have been
+// fetched and loaded once. Implements middleware.Readier.
+func (b *BlockList) Ready() bool { return b.loaded.Load() }
+
 // (*BlockList).Name name return middleware name.
 func (b *BlockList) Name() string { return name }
 
@@ -166,6 +184,7 @@ func (b *BlockList) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 	}
 
 	blocklistHits.Inc()
+	middleware.NoteBlocked(ctx, ch.Request, name)
 
 	msg := new(dns.Msg)
 	msg.SetReply(req)
@@ -0,0 +1,110 @@
+package blocklist
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Source kinds.
+const (
+	sourceConfig = "config"
+	sourceRemote = "remote"
+	sourceFile   = "file"
+)
+
+// Source is one origin of blocklist entries: the blocklist setting, a
+// remote list, or a file in the blocklist directory.
+type Source struct {
+	Name    string    `json:"name"`
+	Kind    string    `json:"kind"`
+	Entries int       `json:"entries"`
+	Updated time.Time `json:"updated,omitzero"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// noteSource records that name listed entries names, or failed to load
+// with err. A failure keeps the count of the last load that worked, since
+// those entries are still in effect.
+func (b *BlockList) noteSource(name, kind string, entries int, err error) {
+	b.srcMu.Lock()
+	defer b.srcMu.Unlock()
+
+	src, ok := b.sources[name]
+	if !ok {
+		src = &Source{Name: name, Kind: kind}
+		b.sources[name] = src
+	}
+	if err != nil {
+		src.Error = err.Error()
+		return
+	}
+	src.Entries = entries
+	src.Updated = time.Now().UTC()
+	src.Error = ""
+}
+
+// noteDownload remembers which remote list a downloaded file holds, for
+// when the directory is read.
+func (b *BlockList) noteDownload(file, uri string) {
+	b.srcMu.Lock()
+	b.downloads[file] = uri
+	b.srcMu.Unlock()
+}
+
+// noteFile records a file read from the blocklist directory, under its
+// remote list's URL when it is a download.
+func (b *BlockList) noteFile(file string, entries int) {
+	b.srcMu.Lock()
+	uri, downloaded := b.downloads[file]
+	delete(b.downloads, file)
+	b.srcMu.Unlock()
+
+	if downloaded {
+		b.noteSource(uri, sourceRemote, entries, nil)
+		return
+	}
+	b.noteSource(file, sourceFile, entries, nil)
+}
+
+// (*BlockList).Sources returns where the entries came from, by name. An
+// entry listed by several sources is counted in each.
+func (b *BlockList) Sources() []Source {
+	b.srcMu.Lock()
+	out := make([]Source, 0, len(b.sources))
+	for _, src := range b.sources {
+		out = append(out, *src)
+	}
+	b.srcMu.Unlock()
+
+	slices.SortFunc(out, func(x, y Source) int { return strings.Compare(x.Name, y.Name) })
+	return out
+}
+
+// (*BlockList).Search returns the entries containing substr, sorted, at
+// most limit of them, and how many matched in all. Wildcard entries are
+// spelled "*.example.com.".
+func (b *BlockList) Search(substr string, limit int) ([]string, int) {
+	substr = strings.ToLower(substr)
+
+	b.mu.RLock()
+	var matches []string
+	for d := range b.m {
+		if strings.Contains(d, substr) {
+			matches = append(matches, d)
+		}
+	}
+	for suffix := range b.wild {
+		if d := "*." + suffix; strings.Contains(d, substr) {
+			matches = append(matches, d)
+		}
+	}
+	b.mu.RUnlock()
+
+	slices.Sort(matches)
+	total := len(matches)
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, total
+}
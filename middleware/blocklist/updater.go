@@ -87,6 +87,9 @@ func (b *BlockList) loadInitial() {
 	for _, entry := range b.cfg.Blocklist {
 		b.set(entry)
 	}
+	if len(b.cfg.Blocklist) > 0 {
+		b.noteSource("config", sourceConfig, len(b.cfg.Blocklist), nil)
+	}
 
 	if _, err := os.Stat(b.cfg.BlockListDir); err == nil {
 		if err := b.readBlocklists(); err != nil {
@@ -99,6 +102,8 @@ func (b *BlockList) loadInitial() {
 // directory to merge the refreshed entries. Runs as a goroutine
 // so New can return once local state is loaded.
 func (b *BlockList) refreshRemote() {
+	defer b.loaded.Store(true)
+
 	<-time.After(time.Second)
 
 	if _, err := os.Stat(b.cfg.BlockListDir); os.IsNotExist(err) {
@@ -230,7 +235,10 @@ func (b *BlockList) fetchBlocklist() {
 			zlog.Info("Fetching blacklist", "uri", uri)
 			if err := b.downloadBlocklist(uri, name); err != nil {
 				zlog.Error("Fetching blacklist", "uri", uri, "error", err.Error())
+				b.noteSource(uri, sourceRemote, 0, err)
+				return
 			}
+			b.noteDownload(name, uri)
 		}(uri, fileName)
 	}
 
@@ -263,13 +271,16 @@ func (b *BlockList) readBlocklists() error {
 				return fmt.Errorf("error opening file: %w", err)
 			}
 
-			if err = b.parseHostFile(file); err != nil {
+			entries, err := b.parseHostFile(file)
+			if err != nil {
 				_ = file.Close()
 				return fmt.Errorf("error parsing hostfile: %w", err)
 			}
 
 			_ = file.Close()
 
+			b.noteFile(filepath.Base(path), entries)
+
 			if filepath.Ext(path) == ".tmp" {
 				_ = os.Remove(path) //nolint:gosec // G122 - trusted local temp files, not user-controlled symlinks
 			}
@@ -287,7 +298,10 @@ func (b *BlockList) readBlocklists() error {
 	return nil
 }
 
-func (b *BlockList) parseHostFile(file *os.File) error {
+// parseHostFile adds the names a hosts-style or plain domain list names,
+// and returns how many it named.
+func (b *BlockList) parseHostFile(file *os.File) (int, error) {
+	entries := 0
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -323,6 +337,7 @@ func (b *BlockList) parseHostFile(file *os.File) error {
 				break
 			}
 			canonical := dns.CanonicalName(n)
+			entries++
 			if !b.Exists(canonical) {
 				b.set(canonical)
 			}
@@ -330,8 +345,8 @@ func (b *BlockList) parseHostFile(file *os.File) error {
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error scanning hostfile: %w", err)
+		return entries, fmt.Errorf("error scanning hostfile: %w", err)
 	}
 
-	return nil
+	return entries, nil
 }
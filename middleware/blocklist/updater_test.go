@@ -73,6 +73,25 @@ func Test_UpdateBlocklists(t *testing.T) {
 			t.Errorf("%s was served by the working list but is not blocked", blocked)
 		}
 	}
+
+	// Each source is reported with what it listed, or why it failed.
+	got := make(map[string]Source)
+	for _, src := range b.Sources() {
+		got[src.Name] = src
+	}
+	if src := got["config"]; src.Kind != sourceConfig || src.Entries != 1 {
+		t.Errorf("config source %+v", src)
+	}
+	if src := got[list.URL]; src.Kind != sourceRemote || src.Entries != 2 || src.Error != "" || src.Updated.IsZero() {
+		t.Errorf("working list source %+v", src)
+	}
+	if src := got[broken.URL]; src.Kind != sourceRemote || src.Error == "" {
+		t.Errorf("failing list source %+v", src)
+	}
+
+	if entries, total := b.Search("EXAMPLE", 1); total != 2 || len(entries) != 1 || entries[0] != "ads.example." {
+		t.Errorf("search found %v of %d", entries, total)
+	}
 }
 
 // TestFileNameForHost pins that a host is turned into something every
@@ -14,6 +14,7 @@ import (
 	"github.com/semihalev/sdns/config"
 	internalcache "github.com/semihalev/sdns/internal/cache"
 	"github.com/semihalev/sdns/internal/contextutil"
+	"github.com/semihalev/sdns/internal/debuglog"
 	"github.com/semihalev/sdns/internal/dnsutil"
 	"github.com/semihalev/sdns/internal/ecs"
 	"github.com/semihalev/sdns/internal/metric"
@@ -24,6 +25,8 @@ import (
 	"golang.org/x/time/rate"
 )
 
+// debugns is set from the environment at startup; the API can switch it
+// on at runtime as well (debuglog.DebugNS).
 var debugns bool
 
 func init() {
@@ -306,6 +309,18 @@ func (c *Cache) Purge(q dns.Question) {
 	c.store.Purge(q)
 }
 
+// (*Cache).PurgeMatching removes the answers, failure states, NXDOMAIN
+// cuts and denial proofs the scope covers. Implements middleware.Purger.
+func (c *Cache) PurgeMatching(scope middleware.PurgeScope) int {
+	return c.store.PurgeMatching(scope)
+}
+
+// (*Cache).Entries lists the cached answers the scope matches; see
+// Store.Entries.
+func (c *Cache) Entries(scope middleware.PurgeScope, limit int) ([]EntryInfo, int) {
+	return c.store.Entries(scope, limit)
+}
+
 // (*Cache).SetQueryer installs the Queryer used for internal
 // client-shaped work (CNAME chase on cache writeback, future DNAME
 // target lookup from the resolver). Called once from sdns.go
@@ -401,6 +416,14 @@ func (c *Cache) prefetchExchange(ctx context.Context, req *dns.Msg) (*dns.Msg, e
 // falls through to materialization and the ordinary body with every gate
 // and lookup it has today.
 func (c *Cache) ServeDNS(ctx context.Context, ch *middleware.Chain) {
+	// A listener that refuses recursion is answered here: the cache is
+	// where the chain turns from local data to recursion, and a cached
+	// answer is recursion all the same.
+	if ch.RecursionRefused() {
+		ch.CancelWithRcode(dns.RcodeRefused, false)
+		return
+	}
+
 	// spent carries the rate-limit permit this query already paid on the
 	// byte path, if any: the wire path and the Msg body below are the
 	// same call, so a plain local is enough to keep one question from
@@ -510,7 +533,7 @@ func (c *Cache) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 		if entry, scopedKey, scope := c.scopedLookup(q, req.CheckingDisabled, clientScope); entry != nil {
 			ecsLookupHitScoped.Inc()
 			if c.handleCacheHit(ctx, ch, entry, scopedKey, scope, spent) {
-				c.metrics.Hit()
+				c.hit(ctx, ch)
 				return
 			}
 		}
@@ -520,13 +543,13 @@ func (c *Cache) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 			ecsLookupHitShared.Inc()
 		}
 		if c.handleCacheHit(ctx, ch, entry, cacheKey, netip.Prefix{}, spent) {
-			c.metrics.Hit()
+			c.hit(ctx, ch)
 			return
 		}
 	}
 	if cut := c.lookupNXDomainCut(ctx, req, clientScope); cut != nil {
 		if c.handleNXDomainCutHit(ctx, ch, cut) {
-			c.metrics.Hit()
+			c.hit(ctx, ch)
 			nxDomainCutHits.Inc()
 			return
 		}
@@ -541,12 +564,12 @@ func (c *Cache) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 	}
 	if proof, kind, zone := c.lookupDenialProof(ctx, req, clientScope); proof != nil {
 		if c.handleDenialProofHit(ctx, ch, proof, kind, zone) {
-			c.metrics.Hit()
+			c.hit(ctx, ch)
 			return
 		}
 	}
 	if hit, ok := c.store.LookupFailure(req, clientScope); ok {
-		c.metrics.Hit()
+		c.hit(ctx, ch)
 		failureCacheHits.Inc()
 		c.handleFailureHit(ctx, ch, hit)
 		return
@@ -579,6 +602,9 @@ func (c *Cache) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 	if clientScope.IsValid() {
 		ecsLookupMiss.Inc()
 	}
+	if debuglog.On(ctx, debuglog.Cache) {
+		debuglog.Log(ctx, debuglog.Cache, "Cache miss", "query", dnsutil.FormatQuestion(q), "cd", requestCD, "failure_probe", failureProbe)
+	}
 
 	// Miss. Dedup upstream work: followers wait for the leader
 	// to finish, then re-check the cache — the leader may have
@@ -653,32 +679,32 @@ func (c *Cache) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 			if clientScope.IsValid() {
 				if entry, scopedKey, scope := c.scopedLookup(q, req.CheckingDisabled, clientScope); entry != nil {
 					if c.handleCacheHit(ctx, ch, entry, scopedKey, scope, spent) {
-						c.metrics.Hit()
+						c.hit(ctx, ch)
 						return
 					}
 				}
 			}
 			if entry := c.checkCache(cacheKey); entry != nil {
 				if c.handleCacheHit(ctx, ch, entry, cacheKey, netip.Prefix{}, spent) {
-					c.metrics.Hit()
+					c.hit(ctx, ch)
 					return
 				}
 			}
 			if cut := c.lookupNXDomainCut(ctx, req, clientScope); cut != nil {
 				if c.handleNXDomainCutHit(ctx, ch, cut) {
-					c.metrics.Hit()
+					c.hit(ctx, ch)
 					nxDomainCutHits.Inc()
 					return
 				}
 			}
 			if proof, kind, zone := c.lookupDenialProof(ctx, req, clientScope); proof != nil {
 				if c.handleDenialProofHit(ctx, ch, proof, kind, zone) {
-					c.metrics.Hit()
+					c.hit(ctx, ch)
 					return
 				}
 			}
 			if hit, ok := c.store.LookupFailure(req, clientScope); ok {
-				c.metrics.Hit()
+				c.hit(ctx, ch)
 				failureCacheHits.Inc()
 				c.handleFailureHit(ctx, ch, hit)
 				return
@@ -1063,6 +1089,12 @@ func (c *Cache) handleDenialProofHit(
 	return true
 }
 
+// hit counts a served hit and notes it for the query log.
+func (c *Cache) hit(ctx context.Context, ch *middleware.Chain) {
+	c.metrics.Hit()
+	middleware.NoteCacheHit(ctx, ch.Request)
+}
+
 func (c *Cache) handleFailureHit(ctx context.Context, ch *middleware.Chain, hit FailureHit) {
 	resp := hit.Response(ch.Request.Msg())
 	if meta := middleware.ResponseMetaFrom(ctx); meta != nil {
@@ -1134,7 +1166,7 @@ func (c *Cache) serveCompositeFromWire(ctx context.Context, ch *middleware.Chain
 		// shared denial on the Msg path too, so it serves directly.
 		if cd || ((hit.Kind == FailureKindQuestion || c.store.sharedDenialImpossible()) &&
 			c.store.DenialMissHoldsWire(req.WireName(), req.Qclass(), hit)) {
-			return c.serveFailureFromWire(ch)
+			return c.serveFailureFromWire(ctx, ch)
 		}
 	}
 	return false
@@ -1253,7 +1285,7 @@ func (c *Cache) serveHitFromWire(
 	switch err := leaser.CommitWire(body, info); {
 	case err == nil:
 		boundRequestToEntryLifetime(ctx, entry)
-		c.metrics.Hit()
+		c.hit(ctx, ch)
 		wireFastServed.Inc()
 		ch.Cancel()
 		return true
@@ -1264,7 +1296,7 @@ func (c *Cache) serveHitFromWire(
 		// Transport-level failure after commit: the bytes left the
 		// process; the response counts as written (Msg-path parity).
 		boundRequestToEntryLifetime(ctx, entry)
-		c.metrics.Hit()
+		c.hit(ctx, ch)
 		ch.Cancel()
 		return true
 	}
@@ -1487,7 +1519,7 @@ func (c *Cache) isValidQuery(q dns.Question) bool {
 // directly via middleware.Pipeline.Purgers(). Only the debug-ns
 // HINFO pass-through remains.
 func (c *Cache) handleSpecialQuery(ctx context.Context, ch *middleware.Chain, q dns.Question) bool {
-	if debugns && q.Qclass == dns.ClassCHAOS && q.Qtype == dns.TypeHINFO {
+	if q.Qclass == dns.ClassCHAOS && q.Qtype == dns.TypeHINFO && (debugns || debuglog.DebugNS()) {
 		ch.Next(ctx)
 		return true
 	}
@@ -1616,8 +1648,8 @@ func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
 	q := res.Question[0]
 
 	// Skip special queries
-	if (debugns && q.Qclass == dns.ClassCHAOS && q.Qtype == dns.TypeHINFO) ||
-		(q.Qclass == dns.ClassCHAOS && q.Qtype == dns.TypeNULL) {
+	if q.Qclass == dns.ClassCHAOS && (q.Qtype == dns.TypeNULL ||
+		q.Qtype == dns.TypeHINFO && (debugns || debuglog.DebugNS())) {
 		return w.ResponseWriter.WriteMsg(res)
 	}
 
@@ -514,3 +514,41 @@ func TestCacheEDNS(t *testing.T) {
 		t.Errorf("resp.Answer[0].Header().Name = %v, want %v", resp.Answer[0].Header().Name, "edns.com.")
 	}
 }
+
+func TestCacheRefusesRecursionPerListener(t *testing.T) {
+	cfg := makeTestConfig()
+	defer os.RemoveAll(cfg.Directory)
+
+	c := New(cfg)
+	defer c.Stop()
+
+	var resolved bool
+	next := middleware.HandlerFunc(func(_ context.Context, ch *middleware.Chain) {
+		resolved = true
+		ch.Cancel()
+	})
+
+	req := new(dns.Msg)
+	req.SetQuestion("refused.example.", dns.TypeA)
+
+	mw := mock.NewWriter("udp", "203.0.113.7:5353")
+	ch := middleware.NewChain([]middleware.Handler{c, next})
+	mw.Policy = &middleware.ListenerPolicy{Name: "public", RefuseRecursion: true}
+	ch.Reset(mw, req)
+	ch.Next(context.Background())
+	if resolved {
+		t.Fatal("a listener refusing recursion reached the resolver")
+	}
+	if !mw.Written() || mw.Rcode() != dns.RcodeRefused {
+		t.Fatalf("rcode = %d, want REFUSED", mw.Rcode())
+	}
+
+	ch = middleware.NewChain([]middleware.Handler{c, next})
+	mw = mock.NewWriter("udp", "203.0.113.7:5353")
+	mw.Policy = &middleware.ListenerPolicy{Name: "lan"}
+	ch.Reset(mw, req)
+	ch.Next(context.Background())
+	if !resolved {
+		t.Fatal("a listener allowing recursion did not reach the resolver")
+	}
+}
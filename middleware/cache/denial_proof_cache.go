@@ -13,6 +13,7 @@ import (
 
 	"github.com/miekg/dns"
 	"github.com/semihalev/sdns/internal/dnsname"
+	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/sdns/middleware/resolver/dnssec"
 )
 
@@ -1695,27 +1696,74 @@ func (c *denialProofCache) purge(q dns.Question) {
 	// keyed tombstones below are removed only for the requested ancestors.
 	c.nsec3ConflictOverflowUntil = time.Time{}
 	for _, zone := range denialProofAncestors(q.Name, nil) {
-		key := denialProofZoneKey{zone: zone, qclass: q.Qclass}
-		for conflict := range c.nsec3Conflicts {
-			if conflict.zone == key {
-				delete(c.nsec3Conflicts, conflict)
-			}
-		}
-		snapshot := c.zoneIndex[key]
-		if snapshot == nil {
+		c.purgeZoneLocked(denialProofZoneKey{zone: zone, qclass: q.Qclass})
+	}
+}
+
+// purgeMatching is purge for a middleware.PurgeScope: it clears the proofs
+// of every zone that is an ancestor of the scope's name, as purge does, and
+// for a subtree scope every zone below it too. It returns how many entries
+// were removed.
+func (c *denialProofCache) purgeMatching(scope middleware.PurgeScope) int {
+	if c == nil {
+		return 0
+	}
+	name := dns.CanonicalName(scope.Name)
+	if _, valid := dns.IsDomainName(name); !valid {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return 0
+	}
+	c.nsec3ConflictOverflowUntil = time.Time{}
+	var keys []denialProofZoneKey
+	for key := range c.zoneEntries {
+		if scope.Qclass != 0 && key.qclass != scope.Qclass {
 			continue
 		}
-		zoneEntries := c.zoneEntries[key]
-		entries := make([]*denialProofEntry, 0, len(zoneEntries))
-		for _, entry := range zoneEntries {
-			if entry.id.kind != denialProofSOA {
-				entries = append(entries, entry)
-			}
+		if dns.IsSubDomain(key.zone, name) || scope.Covers(key.zone) {
+			keys = append(keys, key)
 		}
-		for _, entry := range entries {
-			c.removeEntryLocked(entry)
+	}
+	for conflict := range c.nsec3Conflicts {
+		key := conflict.zone
+		if (scope.Qclass == 0 || key.qclass == scope.Qclass) &&
+			(dns.IsSubDomain(key.zone, name) || scope.Covers(key.zone)) {
+			delete(c.nsec3Conflicts, conflict)
 		}
 	}
+	removed := 0
+	for _, key := range keys {
+		removed += c.purgeZoneLocked(key)
+	}
+	return removed
+}
+
+// purgeZoneLocked drops a zone's NSEC3 conflict tombstones and every proof
+// it holds except the SOA, returning how many proofs went.
+func (c *denialProofCache) purgeZoneLocked(key denialProofZoneKey) int {
+	for conflict := range c.nsec3Conflicts {
+		if conflict.zone == key {
+			delete(c.nsec3Conflicts, conflict)
+		}
+	}
+	if c.zoneIndex[key] == nil {
+		return 0
+	}
+	zoneEntries := c.zoneEntries[key]
+	entries := make([]*denialProofEntry, 0, len(zoneEntries))
+	for _, entry := range zoneEntries {
+		if entry.id.kind != denialProofSOA {
+			entries = append(entries, entry)
+		}
+	}
+	for _, entry := range entries {
+		c.removeEntryLocked(entry)
+	}
+	return len(entries)
 }
 
 func (c *denialProofCache) len() int {
@@ -112,7 +112,7 @@ func (c *Cache) serveChaseHit(
 		for i := range n {
 			boundRequestToEntryLifetime(ctx, segs[i].entry)
 		}
-		c.metrics.Hit()
+		c.hit(ctx, ch)
 		wireChaseServed.Inc()
 		ch.Cancel()
 		return true
@@ -123,7 +123,7 @@ func (c *Cache) serveChaseHit(
 		for i := range n {
 			boundRequestToEntryLifetime(ctx, segs[i].entry)
 		}
-		c.metrics.Hit()
+		c.hit(ctx, ch)
 		ch.Cancel()
 		return true
 	}
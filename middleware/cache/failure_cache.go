@@ -7,6 +7,7 @@ import (
 
 	"github.com/miekg/dns"
 	internalcache "github.com/semihalev/sdns/internal/cache"
+	"github.com/semihalev/sdns/middleware"
 )
 
 const (
@@ -456,6 +457,41 @@ func (c *FailureCache) PurgeQuestion(q dns.Question) int {
 	return removed
 }
 
+// PurgeMatching removes the question states the scope matches and the zone
+// states of every zone it covers, returning how many went.
+func (c *FailureCache) PurgeMatching(scope middleware.PurgeScope) int {
+	type located struct {
+		hash  uint64
+		entry *failureEntry
+	}
+	var matches []located
+	c.entries.ForEach(func(hash uint64, value any) bool {
+		entry, ok := value.(*failureEntry)
+		if !ok || entry == nil {
+			return true
+		}
+		switch entry.kind {
+		case FailureKindQuestion:
+			if scope.Match(entry.question.Question) {
+				matches = append(matches, located{hash: hash, entry: entry})
+			}
+		case FailureKindZone:
+			if (scope.Qclass == 0 || entry.zone.Qclass == scope.Qclass) && scope.Covers(entry.zone.Zone) {
+				matches = append(matches, located{hash: hash, entry: entry})
+			}
+		}
+		return true
+	})
+
+	removed := 0
+	for _, match := range matches {
+		if c.entries.CompareAndDelete(match.hash, match.entry) {
+			removed++
+		}
+	}
+	return removed
+}
+
 // Len returns the number of retained active and expired states.
 func (c *FailureCache) Len() int {
 	return c.entries.Len()
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/middleware"
+)
+
+// EntryInfo describes one cached answer for the API's cache listing.
+type EntryInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Negative is set for an answer without records: NXDOMAIN, no data
+	// or a failure.
+	Negative bool `json:"negative"`
+	// CD is set for the entry kept for clients asking with checking
+	// disabled; it is cached apart from the validated one.
+	CD    bool   `json:"cd"`
+	Rcode string `json:"rcode"`
+	// TTL is the seconds the entry has left, zero once it has expired.
+	TTL     int `json:"ttl"`
+	OrigTTL int `json:"orig_ttl"`
+	// DNSSEC is "secure" for a validated answer, "bogus" for a validation
+	// failure, "unchecked" for a CD entry and "insecure" otherwise.
+	DNSSEC string `json:"dnssec"`
+	// ECSScope is the client subnet the answer was scoped to, empty for an
+	// answer shared by every client.
+	ECSScope string `json:"ecs_scope,omitempty"`
+	// Prefetch is "off" when prefetch is disabled, "ineligible" for a
+	// scoped answer, "pending" while a refresh is queued or running, "due"
+	// once the next hit will queue one and "idle" before that.
+	Prefetch string `json:"prefetch"`
+}
+
+// Entries returns the cached answers the scope matches, ordered by name
+// and type, at most limit of them when limit is positive, and how many
+// matched before that cap. Failure states and the RFC 8020/8198 indexes
+// hold no answers of their own and are not listed.
+func (s *Store) Entries(scope middleware.PurgeScope, limit int) ([]EntryInfo, int) {
+	now := time.Now()
+	var out []EntryInfo
+	s.ForEach(func(positive bool, _ uint64, e *CacheEntry) bool {
+		if e.question.Name != "" && scope.Match(e.question) {
+			out = append(out, s.describe(e, positive, now))
+		}
+		return true
+	})
+
+	slices.SortStableFunc(out, func(a, b EntryInfo) int {
+		return cmp.Or(
+			cmp.Compare(dns.CanonicalName(a.Name), dns.CanonicalName(b.Name)),
+			cmp.Compare(a.Type, b.Type),
+			cmp.Compare(a.ECSScope, b.ECSScope),
+		)
+	})
+	total := len(out)
+	if limit > 0 && total > limit {
+		out = out[:limit]
+	}
+	return out, total
+}
+
+func (s *Store) describe(e *CacheEntry, positive bool, now time.Time) EntryInfo {
+	info := EntryInfo{
+		Name:     e.question.Name,
+		Type:     dns.TypeToString[e.question.Qtype],
+		Negative: !positive,
+		CD:       e.cd,
+		OrigTTL:  int(e.origTTL),
+		DNSSEC:   "insecure",
+		Prefetch: "idle",
+	}
+	if info.Type == "" {
+		info.Type = dns.Type(e.question.Qtype).String()
+	}
+	if rem := e.remaining(now); rem > 0 {
+		info.TTL = int(rem.Seconds())
+	}
+
+	// The header's flags and rcode are read straight from the stored wire;
+	// the extended rcode bits went with the OPT record at admission.
+	if len(e.wire) >= 8 {
+		info.Rcode = dns.RcodeToString[int(e.wire[3]&0x0f)]
+		if e.wire[3]&0x20 != 0 {
+			info.DNSSEC = "secure"
+		}
+		if e.wire[6] == 0 && e.wire[7] == 0 {
+			info.Negative = true
+		}
+	}
+	switch {
+	case e.ede != nil && e.ede.InfoCode == dns.ExtendedErrorCodeDNSBogus:
+		info.DNSSEC = "bogus"
+	case e.cd:
+		info.DNSSEC = "unchecked"
+	}
+
+	if e.scoped() {
+		info.ECSScope = e.scope.String()
+	}
+	switch {
+	case s.cfg.Prefetch <= 0:
+		info.Prefetch = "off"
+	case !e.PrefetchEligible():
+		info.Prefetch = "ineligible"
+	case e.prefetch.Load():
+		info.Prefetch = "pending"
+	case e.ShouldPrefetch(s.cfg.Prefetch):
+		info.Prefetch = "due"
+	}
+	return info
+}
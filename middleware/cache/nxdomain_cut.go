@@ -541,6 +541,31 @@ func (c *nxDomainCutCache) purge(q dns.Question) {
 	}
 }
 
+// purgeMatching removes the cuts covering the scope's name, as purge does,
+// and for a subtree scope every cut below it too. It returns how many cuts
+// were removed.
+func (c *nxDomainCutCache) purgeMatching(scope middleware.PurgeScope) int {
+	if c == nil {
+		return 0
+	}
+	name := dns.CanonicalName(scope.Name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var matches []*nxDomainCutEntry
+	for id, entry := range c.entries {
+		if scope.Qclass != 0 && id.qclass != scope.Qclass {
+			continue
+		}
+		if dns.IsSubDomain(id.deniedName, name) || scope.Covers(id.deniedName) {
+			matches = append(matches, entry)
+		}
+	}
+	for _, entry := range matches {
+		c.removeEntryLocked(entry)
+	}
+	return len(matches)
+}
+
 func (c *nxDomainCutCache) removeEntryLocked(entry *nxDomainCutEntry) {
 	if entry == nil || c.entries[entry.id] != entry {
 		return
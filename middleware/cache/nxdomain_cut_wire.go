@@ -244,7 +244,7 @@ func (c *Cache) serveCutHitFromWire(
 	switch err := leaser.CommitWire(body, info); {
 	case err == nil:
 		boundRequestTo(ctx, cut.expires)
-		c.metrics.Hit()
+		c.hit(ctx, ch)
 		nxDomainCutHits.Inc()
 		wireCutServed.Inc()
 		ch.Cancel()
@@ -254,7 +254,7 @@ func (c *Cache) serveCutHitFromWire(
 		return false
 	default:
 		boundRequestTo(ctx, cut.expires)
-		c.metrics.Hit()
+		c.hit(ctx, ch)
 		ch.Cancel()
 		return true
 	}
@@ -263,7 +263,7 @@ func (c *Cache) serveCutHitFromWire(
 // serveFailureFromWire synthesizes the RFC 9520 cached-failure SERVFAIL
 // straight into the writer's lease: a bare header, the client's question,
 // and the EDE the edns layer appends for EDNS clients.
-func (c *Cache) serveFailureFromWire(ch *middleware.Chain) bool {
+func (c *Cache) serveFailureFromWire(ctx context.Context, ch *middleware.Chain) bool {
 	w := ch.Writer
 	if w.Internal() {
 		return false
@@ -324,7 +324,7 @@ func (c *Cache) serveFailureFromWire(ch *middleware.Chain) bool {
 	}
 	switch err := leaser.CommitWire(body, info); {
 	case err == nil:
-		c.metrics.Hit()
+		c.hit(ctx, ch)
 		failureCacheHits.Inc()
 		wireFailureServed.Inc()
 		ch.Cancel()
@@ -333,7 +333,7 @@ func (c *Cache) serveFailureFromWire(ch *middleware.Chain) bool {
 		wireFastFallback.Inc()
 		return false
 	default:
-		c.metrics.Hit()
+		c.hit(ctx, ch)
 		ch.Cancel()
 		return true
 	}
@@ -7,10 +7,13 @@ import (
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/debuglog"
 	"github.com/semihalev/sdns/internal/dnsutil"
+	"github.com/semihalev/sdns/internal/tracing"
 	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/sdns/middleware/resolver/dnssec"
-	"github.com/semihalev/zlog/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // PrefetchRequest represents a DNS query to be prefetched.
@@ -82,7 +85,7 @@ func (pq *PrefetchQueue) Add(req PrefetchRequest) bool {
 		return true
 	default:
 		// Queue is full, drop the request
-		zlog.Debug("Prefetch queue full, dropping request", "query", dnsutil.FormatQuestion(req.Request.Question[0]))
+		debuglog.Log(context.Background(), debuglog.Cache, "Prefetch queue full, dropping request", "query", dnsutil.FormatQuestion(req.Request.Question[0]))
 		return false
 	}
 }
@@ -130,7 +133,17 @@ func (pq *PrefetchQueue) processPrefetch(req PrefetchRequest) {
 		ctx = middleware.MarkClientECS(ctx)
 	}
 
-	zlog.Debug("Processing prefetch", "query", dnsutil.FormatQuestion(req.Request.Question[0]))
+	debuglog.Log(context.Background(), debuglog.Cache, "Processing prefetch", "query", dnsutil.FormatQuestion(req.Request.Question[0]))
+
+	if tracing.Enabled() {
+		q := req.Request.Question[0]
+		var span trace.Span
+		ctx, span = tracing.Start(ctx, "cache.prefetch", trace.WithAttributes(
+			attribute.String("dns.question.name", q.Name),
+			attribute.String("dns.question.type", dns.Type(q.Qtype).String()),
+		))
+		defer span.End()
+	}
 
 	// Copy the original client request so upstream mutations
 	// (CD bit, EDNS options) don't bleed into the shared Request
@@ -166,7 +179,7 @@ func (pq *PrefetchQueue) processPrefetch(req PrefetchRequest) {
 	// apply; metrics/dnstap/accesslog do not.
 	resp, err := req.Cache.prefetchExchange(ctx, prefetchReq)
 	if err != nil {
-		zlog.Debug("Prefetch failed", "query", dnsutil.FormatQuestion(req.Request.Question[0]), "error", err.Error())
+		debuglog.Log(context.Background(), debuglog.Cache, "Prefetch failed", "query", dnsutil.FormatQuestion(req.Request.Question[0]), "error", err.Error())
 		return
 	}
 	if resp == nil {
@@ -187,7 +200,7 @@ func (pq *PrefetchQueue) processPrefetch(req PrefetchRequest) {
 	// prefetch may be replaced.
 	cutUntil, cutKey := meta.Cut()
 	if !req.Cache.store.ReplaceIfCurrent(req.Key, req.Entry, resp, cutUntil, cutKey) {
-		zlog.Debug("Prefetch dropped, entry superseded", "query", dnsutil.FormatQuestion(req.Request.Question[0]))
+		debuglog.Log(context.Background(), debuglog.Cache, "Prefetch dropped, entry superseded", "query", dnsutil.FormatQuestion(req.Request.Question[0]))
 		return
 	}
 	// The cache-less prefetch pipeline bypasses ResponseWriter.WriteMsg, so a
@@ -230,9 +243,9 @@ func (pq *PrefetchQueue) processPrefetch(req PrefetchRequest) {
 				minTTL = rr.Header().Ttl
 			}
 		}
-		zlog.Debug("Prefetch stored in cache", "query", dnsutil.FormatQuestion(req.Request.Question[0]), "answers", len(resp.Answer), "minTTL", minTTL)
+		debuglog.Log(context.Background(), debuglog.Cache, "Prefetch stored in cache", "query", dnsutil.FormatQuestion(req.Request.Question[0]), "answers", len(resp.Answer), "minTTL", minTTL)
 	} else {
-		zlog.Debug("Prefetch completed", "query", dnsutil.FormatQuestion(req.Request.Question[0]), "rcode", dns.RcodeToString[resp.Rcode])
+		debuglog.Log(context.Background(), debuglog.Cache, "Prefetch completed", "query", dnsutil.FormatQuestion(req.Request.Question[0]), "rcode", dns.RcodeToString[resp.Rcode])
 	}
 }
 
@@ -8,6 +8,7 @@ import (
 
 	"github.com/miekg/dns"
 	"github.com/semihalev/sdns/internal/cache"
+	"github.com/semihalev/sdns/internal/debuglog"
 	"github.com/semihalev/sdns/internal/dnsutil"
 	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/sdns/middleware/resolver/dnssec"
@@ -528,8 +529,8 @@ func (s *Store) SetFromResponseWithCut(resp *dns.Msg, keyCD bool, cutUntil time.
 		return
 	}
 	q := resp.Question[0]
-	if (debugns && q.Qclass == dns.ClassCHAOS && q.Qtype == dns.TypeHINFO) ||
-		(q.Qclass == dns.ClassCHAOS && q.Qtype == dns.TypeNULL) {
+	if q.Qclass == dns.ClassCHAOS && (q.Qtype == dns.TypeNULL ||
+		q.Qtype == dns.TypeHINFO && (debugns || debuglog.DebugNS())) {
 		return
 	}
 	s.setFromResponseWithKey(
@@ -768,6 +769,40 @@ func (s *Store) Purge(q dns.Question) {
 	}
 }
 
+// PurgeMatching is Purge for a scope that may span every type and a whole
+// subtree. Beyond the answers it matches it clears the failure states,
+// RFC 8020 cuts and RFC 8198 proofs the scope covers, so nothing cached
+// about those names can answer for them again. It returns how many entries
+// were removed across all of them; like Purge it is a linear sweep.
+func (s *Store) PurgeMatching(scope middleware.PurgeScope) int {
+	removed := 0
+	if !s.failureCacheDisabled && s.failure != nil {
+		removed += s.failure.PurgeMatching(scope)
+	}
+	removed += s.nxDomainCuts.purgeMatching(scope)
+	removed += s.denialProofs.purgeMatching(scope)
+
+	type located struct {
+		positive bool
+		key      uint64
+	}
+	var hits []located
+	s.ForEach(func(positive bool, key uint64, e *CacheEntry) bool {
+		if e.question.Name != "" && scope.Match(e.question) {
+			hits = append(hits, located{positive: positive, key: key})
+		}
+		return true
+	})
+	for _, h := range hits {
+		if h.positive {
+			s.positive.Remove(h.key)
+		} else {
+			s.negative.Remove(h.key)
+		}
+	}
+	return removed + len(hits)
+}
+
 // PositiveLen returns the number of entries in the positive cache.
 func (s *Store) PositiveLen() int { return s.positive.Len() }
 
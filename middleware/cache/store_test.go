@@ -8,6 +8,7 @@ import (
 
 	"github.com/miekg/dns"
 	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/middleware"
 )
 
 func newTestStore(t *testing.T) *Store {
@@ -276,6 +277,102 @@ func TestCachePurgePublicAPI(t *testing.T) {
 	}
 }
 
+// TestStorePurgeMatching pins the subtree and all-types purge: every
+// answer at and below the name goes, negative ones and failure states
+// included, and names merely sharing a suffix stay.
+func TestStorePurgeMatching(t *testing.T) {
+	s := newTestStore(t)
+
+	s.SetFromResponse(newTestSuccessResp("example.com."), false, time.Time{})
+	s.SetFromResponse(newTestSuccessResp("www.example.com."), false, time.Time{})
+	s.SetFromResponse(newTestSuccessResp("badexample.com."), false, time.Time{})
+	s.SetFromResponse(newTestSuccessResp("example.org."), false, time.Time{})
+
+	nx := new(dns.Msg)
+	nx.SetQuestion("missing.example.com.", dns.TypeAAAA)
+	nx.Rcode = dns.RcodeNameError
+	nx.Response = true
+	nx.Ns = []dns.RR{&dns.SOA{
+		Hdr:     dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+		Ns:      "ns.example.com.",
+		Mbox:    "admin.example.com.",
+		Serial:  1,
+		Minttl:  300,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+	}}
+	s.SetFromResponse(nx, false, time.Time{})
+	if s.PositiveLen() != 5 {
+		t.Fatalf("PositiveLen = %d, want the NXDOMAIN cached with the answers", s.PositiveLen())
+	}
+
+	s.failure.RecordQuestion(FailureQuestionKey{
+		Question: dns.Question{Name: "deep.www.example.com.", Qtype: dns.TypeMX, Qclass: dns.ClassINET},
+	}, FailureProvenance("transport"), nil)
+	s.failure.RecordZone(FailureZoneKey{Zone: "sub.example.com.", Qclass: dns.ClassINET}, FailureProvenance("transport"), nil)
+
+	// One name and one type leaves the rest of the subtree.
+	exact := middleware.PurgeScope{Name: "www.example.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}
+	if n := s.PurgeMatching(exact); n != 0 {
+		t.Fatalf("exact AAAA purge removed %d, want 0", n)
+	}
+
+	tree := middleware.PurgeScope{Name: "Example.COM.", Qclass: dns.ClassINET, Subtree: true}
+	if n := s.PurgeMatching(tree); n != 5 {
+		t.Fatalf("subtree purge removed %d, want 5", n)
+	}
+	if s.FailureLen() != 0 || s.NegativeLen() != 0 || s.PositiveLen() != 2 {
+		t.Fatalf("after purge: positive %d negative %d failure %d, want 2 0 0",
+			s.PositiveLen(), s.NegativeLen(), s.FailureLen())
+	}
+	for _, name := range []string{"badexample.com.", "example.org."} {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		if _, ok := s.Lookup(req); !ok {
+			t.Fatalf("%s should survive a purge of example.com's subtree", name)
+		}
+	}
+}
+
+// TestStoreEntries checks the cache listing: matching entries sorted by
+// name with what is left of their TTL, and the cap reported separately
+// from the total.
+func TestStoreEntries(t *testing.T) {
+	s := newTestStore(t)
+
+	s.SetFromResponse(newTestSuccessResp("www.example.com."), false, time.Time{})
+	s.SetFromResponse(newTestSuccessResp("example.com."), false, time.Time{})
+	cd := newTestSuccessResp("example.com.")
+	cd.CheckingDisabled = true
+	s.SetFromResponse(cd, true, time.Time{})
+	s.SetFromResponse(newTestSuccessResp("example.org."), false, time.Time{})
+
+	entries, total := s.Entries(middleware.PurgeScope{Name: "example.com.", Subtree: true}, 0)
+	if total != 3 || len(entries) != 3 {
+		t.Fatalf("Entries = %d of %d, want 3", len(entries), total)
+	}
+	if entries[0].Name != "example.com." || entries[2].Name != "www.example.com." {
+		t.Fatalf("entries not in name order: %+v", entries)
+	}
+	for _, e := range entries {
+		if e.Type != "A" || e.Rcode != "NOERROR" || e.Negative || e.TTL <= 0 || e.TTL > 60 || e.Prefetch != "off" {
+			t.Fatalf("entry %+v", e)
+		}
+		if e.CD != (e.DNSSEC == "unchecked") {
+			t.Fatalf("entry %+v: a CD entry is unchecked, any other insecure", e)
+		}
+	}
+
+	entries, total = s.Entries(middleware.PurgeScope{Name: ".", Subtree: true}, 2)
+	if total != 4 || len(entries) != 2 {
+		t.Fatalf("capped Entries = %d of %d, want 2 of 4", len(entries), total)
+	}
+	if entries, _ = s.Entries(middleware.PurgeScope{Name: "example.com.", Qtype: dns.TypeAAAA}, 0); len(entries) != 0 {
+		t.Fatalf("AAAA filter: %+v", entries)
+	}
+}
+
 // TestEqualNameASCIIFold verifies the cache-key verification folds ASCII
 // case only — matching internal/cache.Key — and does NOT do Unicode folding
 // (which strings.EqualFold would), so it can't accept names the key hash
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/wire"
+)
+
+// TestRecomposedOwnerEchoesClientCase pins the recomposer's 0x20 echo: an
+// owner that folds equal to the question is written in the client's
+// spelling whatever spelling it was stored in, and any other owner keeps
+// its own. A resolver sending randomized case upstream relies on this, so
+// a stored spelling never reaches a client that asked in another.
+func TestRecomposedOwnerEchoesClientCase(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.example.com.", dns.TypeA)
+	msg.Response = true
+	msg.Answer = []dns.RR{
+		makeRR("wWw.ExAMpLe.cOm. 300 IN A 192.0.2.1"),
+		makeRR("Other.Example.Com. 300 IN A 192.0.2.2"),
+	}
+	src, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientName := make([]byte, 256)
+	n, err := dns.PackDomainName("WWW.example.COM.", clientName, 0, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientName = clientName[:n]
+
+	q, ok := wire.ParseQuestion(src, wire.HeaderLen)
+	if !ok {
+		t.Fatal("question did not parse")
+	}
+	want := []string{"WWW.example.COM.", "Other.Example.Com."}
+	off := q.End
+	for i := range msg.Answer {
+		rr, ok := wire.ParseRR(src, off)
+		if !ok {
+			t.Fatalf("answer %d did not parse", i)
+		}
+		dst, ok := appendRecomposedRR(make([]byte, 0, 512), src, rr, 60, clientName)
+		if !ok {
+			t.Fatalf("answer %d did not recompose", i)
+		}
+		owner, _, err := dns.UnpackDomainName(dst, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owner != want[i] {
+			t.Errorf("answer %d owner = %q, want %q", i, owner, want[i])
+		}
+		off = rr.End
+	}
+}
@@ -9,7 +9,10 @@ import (
 
 	"github.com/miekg/dns"
 	"github.com/semihalev/sdns/internal/contextutil"
+	"github.com/semihalev/sdns/internal/debuglog"
+	"github.com/semihalev/sdns/internal/tracing"
 	"github.com/semihalev/sdns/internal/wire"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ResponseMeta carries resolver-produced metadata about a response
@@ -138,6 +141,10 @@ type requestLedgers struct {
 	// validatedNegativeProofs is immutable, exact-response NXDOMAIN or NODATA
 	// provenance produced by this resolver.
 	validatedNegativeProofs atomic.Pointer[validatedNegativeProofResponseSet]
+
+	// notes is the query log's record of how the client query was
+	// answered, present only while an observer is tracking it.
+	notes atomic.Pointer[QueryNotes]
 }
 
 type ResponseMeta struct {
@@ -900,6 +907,14 @@ func (ch *Chain) Next(ctx context.Context) {
 				}
 			}
 		}
+		if ownsMeta && tracing.Enabled() {
+			var span trace.Span
+			ctx, span = ch.startRequestSpan(ctx)
+			defer ch.endRequestSpan(span)
+		}
+		if ownsMeta && debuglog.Filtering() && !ch.Writer.Internal() {
+			ctx = debuglog.MarkClient(ctx, ch.Writer.RemoteIP())
+		}
 	}
 
 	h := ch.handlers[ch.pos]
@@ -917,6 +932,10 @@ func (ch *Chain) Next(ctx context.Context) {
 		ctx, ch.detachCleanup = ch.detachStrictContext(ctx)
 	}
 
+	if tracing.Enabled() {
+		ch.serveTraced(ctx, h)
+		return
+	}
 	h.ServeDNS(ctx, ch)
 }
 
@@ -1119,7 +1138,7 @@ func (ch *Chain) detachStrictContext(ctx context.Context) (context.Context, func
 	// underneath ctx is recycled the moment the job completes — so
 	// custom context VALUES do not cross this boundary by design; the
 	// request-scoped semantics the server owns (deadline, ECS,
-	// ResponseMeta) are carried explicitly. Cancellation does cross: a
+	// ResponseMeta, the trace span) are carried explicitly. Cancellation does cross: a
 	// middleware that wrapped the strict context with its own cancel
 	// must still be able to stop the slow work it now waits on. The
 	// carrier itself has no Done channel, so the hook is free there.
@@ -1136,6 +1155,8 @@ func (ch *Chain) detachStrictContext(ctx context.Context) (context.Context, func
 	if HasClientECS(ctx) {
 		detached = MarkClientECS(detached)
 	}
+	detached = tracing.Carry(ctx, detached)
+	detached = debuglog.Carry(ctx, detached)
 
 	cleanup := func() { stopCancel(); real.Cancel() }
 	if meta := ResponseMetaFrom(ctx).detachedCopy(); meta != nil {
@@ -9,6 +9,7 @@ import (
 	"github.com/semihalev/sdns/middleware/accesslog"
 	"github.com/semihalev/sdns/middleware/dnstap"
 	"github.com/semihalev/sdns/middleware/metrics"
+	"github.com/semihalev/sdns/middleware/querylog"
 	"github.com/semihalev/sdns/middleware/ratelimit"
 	"github.com/semihalev/sdns/middleware/reflex"
 )
@@ -28,6 +29,7 @@ func TestClientOnlyMarkers(t *testing.T) {
 		{"metrics", metrics.New(cfg)},
 		{"dnstap", dnstap.New(cfg)},
 		{"accesslog", accesslog.New(cfg)},
+		{"querylog", querylog.New(cfg)},
 		{"ratelimit", ratelimit.New(cfg)},
 		{"accesslist", accesslist.New(cfg)},
 		{"reflex", reflex.New(cfg)},
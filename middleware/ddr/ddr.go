@@ -0,0 +1,248 @@
+// Package ddr answers the queries of Discovery of Designated Resolvers
+// (RFC 9462). A client that knows only this resolver's address asks for
+// _dns.resolver.arpa SVCB and learns the DoT, DoH and DoQ endpoints of the
+// same service, then moves its queries over to one of them. A client that
+// knows the resolver by name asks for _dns.<name> SVCB instead (RFC 9461)
+// and gets the records of that name only.
+//
+// The server knows which encrypted listeners are serving; it hands them
+// over with Publish once they are bound. The records are built per query
+// from what was published and the certificate each endpoint holds at that
+// moment, so a rotated certificate changes the advertised names with it.
+package ddr
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/middleware"
+)
+
+const (
+	name = "ddr"
+
+	// resolverName is the special-use name of RFC 9462 §4.
+	resolverName = "_dns.resolver.arpa."
+
+	// ttl bounds how long a client keeps an upgrade it learned. Short:
+	// a listener that is switched off should stop being advertised soon.
+	ttl = 300
+
+	// DoHPath is the URI template advertised for DoH endpoints. The DoH
+	// handler serves any path; this is the one clients are documented to
+	// use.
+	DoHPath = "/dns-query{?dns}"
+)
+
+// Endpoint is one encrypted service the resolver offers.
+type Endpoint struct {
+	// ALPN lists the protocol IDs the endpoint speaks: "dot", "doq", or
+	// "h2" and "h3" for DoH.
+	ALPN []string
+	// Port is the endpoint's port.
+	Port uint16
+	// DoHPath is the URI template of a DoH endpoint, empty for the others.
+	DoHPath string
+	// IPv4 and IPv6 are the address hints.
+	IPv4, IPv6 []net.IP
+	// Certificate returns the leaf certificate the endpoint serves right
+	// now, nil when it has none. An endpoint without a certificate is not
+	// advertised.
+	Certificate func() *x509.Certificate
+}
+
+var published atomic.Pointer[[]Endpoint]
+
+// Publish replaces the advertised endpoints. The server calls it once its
+// listeners are bound; nil advertises none.
+func Publish(endpoints []Endpoint) {
+	published.Store(&endpoints)
+}
+
+// Published returns the advertised endpoints.
+func Published() []Endpoint {
+	if p := published.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// DDR type.
+type DDR struct {
+	// names are the configured resolver names, canonical; empty uses
+	// each certificate's own DNS names.
+	names []string
+}
+
+// New return a new middleware, nil when DDR is off.
+func New(cfg *config.Config) *DDR {
+	if !cfg.DDREnabled() {
+		return nil
+	}
+	d := &DDR{}
+	for _, n := range cfg.DDRNames {
+		d.names = append(d.names, dns.CanonicalName(n))
+	}
+	return d
+}
+
+// (*DDR).Name name return middleware name.
+func (d *DDR) Name() string { return name }
+
+// ClientOnly keeps the handler out of internal sub-queries: they never ask
+// for a designated resolver.
+func (d *DDR) ClientOnly() bool { return true }
+
+// (*DDR).ServeDNS serveDNS implements the Handle interface. Every name it
+// answers starts with the _dns label, so anything else passes on one
+// compare of the question name, without decoding a wire-born request.
+func (d *DDR) ServeDNS(ctx context.Context, ch *middleware.Chain) {
+	if !hasDNSLabel(ch.Request) || ch.Request.Qclass() != dns.ClassINET {
+		ch.Next(ctx)
+		return
+	}
+
+	ctx, req := ch.Materialize(ctx)
+	if req == nil {
+		return
+	}
+
+	q := req.Question[0]
+	qname := dns.CanonicalName(q.Name)
+
+	if qname == resolverName {
+		msg := d.reply(req, "")
+		if q.Qtype != dns.TypeSVCB {
+			msg.Answer = nil
+			msg.Ns = []dns.RR{soa()}
+		}
+		_ = ch.Writer.WriteMsg(msg)
+		ch.Cancel()
+		return
+	}
+
+	// _dns.<name> is ours only for the names we advertise; any other is
+	// somebody's public record and goes to recursion like any name.
+	if q.Qtype != dns.TypeSVCB {
+		ch.Next(ctx)
+		return
+	}
+	msg := d.reply(req, strings.TrimPrefix(qname, "_dns."))
+	if len(msg.Answer) == 0 {
+		ch.Next(ctx)
+		return
+	}
+	_ = ch.Writer.WriteMsg(msg)
+	ch.Cancel()
+}
+
+// reply builds the SVCB answer: one record per resolver name and endpoint,
+// in publication order. A non-empty target keeps that name's records only.
+func (d *DDR) reply(req *dns.Msg, target string) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetReply(req)
+	msg.Authoritative, msg.RecursionAvailable = true, true
+
+	owner := req.Question[0].Name
+	var priority uint16
+	for _, ep := range Published() {
+		for _, n := range d.namesOf(ep) {
+			if target != "" && n != target {
+				continue
+			}
+			priority++
+			msg.Answer = append(msg.Answer, record(owner, n, priority, ep))
+		}
+	}
+	return msg
+}
+
+// namesOf returns the resolver names ep can be advertised under: those its
+// certificate is valid for. A client verifies the name it was pointed at
+// against the certificate, so advertising any other would only send it to
+// a failed handshake.
+func (d *DDR) namesOf(ep Endpoint) []string {
+	if ep.Certificate == nil {
+		return nil
+	}
+	leaf := ep.Certificate()
+	if leaf == nil {
+		return nil
+	}
+	candidates := d.names
+	if len(candidates) == 0 {
+		for _, n := range leaf.DNSNames {
+			// A wildcard is no name a client can connect to.
+			if !strings.HasPrefix(n, "*.") {
+				candidates = append(candidates, dns.CanonicalName(n))
+			}
+		}
+	}
+	var names []string
+	for _, n := range candidates {
+		if Covers(leaf, n) {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// Covers reports whether leaf is valid for the resolver name n.
+func Covers(leaf *x509.Certificate, n string) bool {
+	return leaf.VerifyHostname(strings.TrimSuffix(n, ".")) == nil
+}
+
+func record(owner, target string, priority uint16, ep Endpoint) *dns.SVCB {
+	rr := &dns.SVCB{
+		Hdr:      dns.RR_Header{Name: owner, Rrtype: dns.TypeSVCB, Class: dns.ClassINET, Ttl: ttl},
+		Priority: priority,
+		Target:   target,
+	}
+	// Keys in ascending order, as the wire format requires.
+	rr.Value = append(rr.Value, &dns.SVCBAlpn{Alpn: ep.ALPN}, &dns.SVCBPort{Port: ep.Port})
+	if len(ep.IPv4) > 0 {
+		rr.Value = append(rr.Value, &dns.SVCBIPv4Hint{Hint: ep.IPv4})
+	}
+	if len(ep.IPv6) > 0 {
+		rr.Value = append(rr.Value, &dns.SVCBIPv6Hint{Hint: ep.IPv6})
+	}
+	if ep.DoHPath != "" {
+		rr.Value = append(rr.Value, &dns.SVCBDoHPath{Template: ep.DoHPath})
+	}
+	return rr
+}
+
+// soa is the negative-answer SOA of the locally served resolver.arpa zone.
+func soa() *dns.SOA {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: "resolver.arpa.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+		Ns:      "resolver.arpa.",
+		Mbox:    ".",
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   1200,
+		Expire:  604800,
+		Minttl:  ttl,
+	}
+}
+
+// hasDNSLabel reports whether the question name's first label is _dns,
+// case-insensitively.
+func hasDNSLabel(r *middleware.Request) bool {
+	if r.Undecoded() {
+		w := r.WireName()
+		return len(w) > 5 && w[0] == 4 && w[1] == '_' &&
+			w[2]|0x20 == 'd' && w[3]|0x20 == 'n' && w[4]|0x20 == 's'
+	}
+	m := r.Msg()
+	if m == nil || len(m.Question) == 0 {
+		return false
+	}
+	n := m.Question[0].Name
+	return len(n) > 5 && strings.EqualFold(n[:5], "_dns.")
+}
@@ -0,0 +1,204 @@
+package ddr
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/mock"
+	"github.com/semihalev/sdns/middleware"
+)
+
+func publishTest(t *testing.T) {
+	t.Helper()
+	leaf := &x509.Certificate{DNSNames: []string{"dns.example.com", "*.example.net"}}
+	other := &x509.Certificate{DNSNames: []string{"other.example.org"}}
+	Publish([]Endpoint{
+		{
+			ALPN: []string{"h2", "h3"}, Port: 443, DoHPath: DoHPath,
+			IPv4: []net.IP{net.IPv4(192, 0, 2, 1).To4()}, IPv6: []net.IP{net.ParseIP("2001:db8::1")},
+			Certificate: func() *x509.Certificate { return leaf },
+		},
+		{ALPN: []string{"dot"}, Port: 853, Certificate: func() *x509.Certificate { return leaf }},
+		{ALPN: []string{"doq"}, Port: 853, Certificate: func() *x509.Certificate { return other }},
+		// No certificate loaded: never advertised.
+		{ALPN: []string{"dot"}, Port: 8853, Certificate: func() *x509.Certificate { return nil }},
+	})
+	t.Cleanup(func() { Publish(nil) })
+}
+
+func serve(t *testing.T, d *DDR, qname string, qtype uint16) (*mock.Writer, bool) {
+	t.Helper()
+	req := new(dns.Msg)
+	req.SetQuestion(qname, qtype)
+
+	var passed bool
+	next := middleware.HandlerFunc(func(context.Context, *middleware.Chain) { passed = true })
+	ch := middleware.NewChain([]middleware.Handler{d, next})
+	mw := mock.NewWriter("udp", "127.0.0.1:0")
+	ch.Reset(mw, req)
+	ch.Next(context.Background())
+	return mw, passed
+}
+
+func svcbs(t *testing.T, msg *dns.Msg) []*dns.SVCB {
+	t.Helper()
+	var out []*dns.SVCB
+	for _, rr := range msg.Answer {
+		svcb, ok := rr.(*dns.SVCB)
+		if !ok {
+			t.Fatalf("unexpected answer %v", rr)
+		}
+		out = append(out, svcb)
+	}
+	return out
+}
+
+func TestDDRResolverArpa(t *testing.T) {
+	publishTest(t)
+	d := New(new(config.Config))
+
+	mw, passed := serve(t, d, "_DNS.Resolver.Arpa.", dns.TypeSVCB)
+	if passed || !mw.Written() {
+		t.Fatal("_dns.resolver.arpa not answered")
+	}
+	got := svcbs(t, mw.Msg())
+	want := []struct {
+		target string
+		alpn   string
+		port   uint16
+	}{
+		{"dns.example.com.", "h2,h3", 443},
+		{"dns.example.com.", "dot", 853},
+		{"other.example.org.", "doq", 853},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%d records, want %d: %v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		rr := got[i]
+		if rr.Hdr.Name != "_DNS.Resolver.Arpa." || rr.Priority != uint16(i+1) || rr.Target != w.target {
+			t.Errorf("record %d = %v", i, rr)
+		}
+		var alpn string
+		var port uint16
+		for _, kv := range rr.Value {
+			switch v := kv.(type) {
+			case *dns.SVCBAlpn:
+				alpn = v.String()
+			case *dns.SVCBPort:
+				port = v.Port
+			}
+		}
+		if alpn != w.alpn || port != w.port {
+			t.Errorf("record %d alpn=%q port=%d, want %q %d", i, alpn, port, w.alpn, w.port)
+		}
+	}
+
+	// The DoH record carries its hints and path, and the whole answer
+	// survives the wire.
+	if s := got[0].String(); s != `_DNS.Resolver.Arpa.	300	IN	SVCB	1 dns.example.com. alpn="h2,h3" port="443" ipv4hint="192.0.2.1" ipv6hint="2001:db8::1" dohpath="/dns-query{?dns}"` {
+		t.Errorf("DoH record = %s", s)
+	}
+	if _, err := mw.Msg().Pack(); err != nil {
+		t.Errorf("pack: %v", err)
+	}
+
+	// Other types at the name: NODATA with the zone's SOA.
+	mw, passed = serve(t, d, "_dns.resolver.arpa.", dns.TypeA)
+	if passed || mw.Rcode() != dns.RcodeSuccess || len(mw.Msg().Answer) != 0 || len(mw.Msg().Ns) != 1 {
+		t.Fatalf("A at _dns.resolver.arpa = passed %v, %v", passed, mw.Msg())
+	}
+}
+
+func TestDDRNamedResolver(t *testing.T) {
+	publishTest(t)
+	d := New(new(config.Config))
+
+	mw, passed := serve(t, d, "_dns.other.example.org.", dns.TypeSVCB)
+	if passed {
+		t.Fatal("_dns.other.example.org passed on")
+	}
+	if got := svcbs(t, mw.Msg()); len(got) != 1 || got[0].Target != "other.example.org." || got[0].Priority != 1 {
+		t.Fatalf("answer = %v", got)
+	}
+
+	// Not one of our names, or not SVCB: somebody else's record.
+	for _, q := range []struct {
+		name  string
+		qtype uint16
+	}{
+		{"_dns.example.net.", dns.TypeSVCB},
+		{"_dns.dns.example.com.", dns.TypeTXT},
+		{"dns.example.com.", dns.TypeSVCB},
+	} {
+		if _, passed := serve(t, d, q.name, q.qtype); !passed {
+			t.Errorf("%s %s answered locally", q.name, dns.TypeToString[q.qtype])
+		}
+	}
+}
+
+func TestDDRConfiguredNames(t *testing.T) {
+	publishTest(t)
+	// a.example.net is covered by the wildcard, b.example.com by nothing.
+	d := New(&config.Config{DDRNames: []string{"A.example.net", "b.example.com"}})
+
+	mw, _ := serve(t, d, "_dns.resolver.arpa.", dns.TypeSVCB)
+	got := svcbs(t, mw.Msg())
+	if len(got) != 2 {
+		t.Fatalf("%d records, want 2: %v", len(got), got)
+	}
+	for _, rr := range got {
+		if rr.Target != "a.example.net." {
+			t.Errorf("target %q, want a.example.net.", rr.Target)
+		}
+	}
+}
+
+func TestDDRNothingPublished(t *testing.T) {
+	Publish(nil)
+	d := New(new(config.Config))
+	mw, passed := serve(t, d, "_dns.resolver.arpa.", dns.TypeSVCB)
+	if passed || mw.Rcode() != dns.RcodeSuccess || len(mw.Msg().Answer) != 0 {
+		t.Fatalf("passed %v, %v", passed, mw.Msg())
+	}
+}
+
+func TestDDRDisabled(t *testing.T) {
+	off := false
+	if d := New(&config.Config{DDR: &off}); d != nil {
+		t.Fatal("New returned a handler with ddr = false")
+	}
+}
+
+// TestDDRWirePassesUndecoded pins the fast path: the _dns check reads the
+// wire name, so a wire-born query for any other name is never decoded.
+func TestDDRWirePassesUndecoded(t *testing.T) {
+	for _, qname := range []string{"example.com.", "_dnsx.example.com.", "_dn.example.com."} {
+		q := new(dns.Msg)
+		q.SetQuestion(qname, dns.TypeSVCB)
+		raw, err := q.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := new(middleware.Request)
+		if !req.ParseWire(raw, time.Now(), nil) {
+			t.Fatal("eligible query refused by ParseWire")
+		}
+		if hasDNSLabel(req) {
+			t.Errorf("%s taken for a _dns name", qname)
+		}
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("_DNS.resolver.arpa.", dns.TypeSVCB)
+	raw, _ := q.Pack()
+	req := new(middleware.Request)
+	if !req.ParseWire(raw, time.Now(), nil) || !hasDNSLabel(req) {
+		t.Error("wire _DNS.resolver.arpa not recognized")
+	}
+}
@@ -17,10 +17,12 @@ import (
 
 	"github.com/semihalev/sdns/middleware/accesslist"
 	"github.com/semihalev/sdns/middleware/accesslog"
+	"github.com/semihalev/sdns/middleware/acmedns"
 	"github.com/semihalev/sdns/middleware/as112"
 	"github.com/semihalev/sdns/middleware/blocklist"
 	"github.com/semihalev/sdns/middleware/cache"
 	"github.com/semihalev/sdns/middleware/chaos"
+	"github.com/semihalev/sdns/middleware/ddr"
 	"github.com/semihalev/sdns/middleware/dns64"
 	"github.com/semihalev/sdns/middleware/dnstap"
 	"github.com/semihalev/sdns/middleware/edns"
@@ -29,10 +31,12 @@ import (
 	"github.com/semihalev/sdns/middleware/hostsfile"
 	"github.com/semihalev/sdns/middleware/kubernetes"
 	"github.com/semihalev/sdns/middleware/metrics"
+	"github.com/semihalev/sdns/middleware/querylog"
 	"github.com/semihalev/sdns/middleware/ratelimit"
 	"github.com/semihalev/sdns/middleware/recovery"
 	"github.com/semihalev/sdns/middleware/reflex"
 	"github.com/semihalev/sdns/middleware/resolver"
+	"github.com/semihalev/sdns/middleware/stats"
 	"github.com/semihalev/sdns/middleware/views"
 )
 
@@ -50,7 +54,11 @@ var chain = []entry{
 	{"reflex", func(cfg *config.Config) middleware.Handler { return reflex.New(cfg) }},
 	{"edns", func(cfg *config.Config) middleware.Handler { return edns.New(cfg) }},
 	{"accesslog", func(cfg *config.Config) middleware.Handler { return accesslog.New(cfg) }},
+	{"querylog", func(cfg *config.Config) middleware.Handler { return querylog.New(cfg) }},
+	{"stats", func(cfg *config.Config) middleware.Handler { return stats.New(cfg) }},
 	{"chaos", func(cfg *config.Config) middleware.Handler { return chaos.New(cfg) }},
+	{"ddr", func(cfg *config.Config) middleware.Handler { return ddr.New(cfg) }},
+	{"acmedns", func(cfg *config.Config) middleware.Handler { return acmedns.New(cfg) }},
 	{"hostsfile", func(cfg *config.Config) middleware.Handler { return hostsfile.New(cfg) }},
 	{"views", func(cfg *config.Config) middleware.Handler { return views.New(cfg) }},
 	{"blocklist", func(cfg *config.Config) middleware.Handler { return blocklist.New(cfg) }},
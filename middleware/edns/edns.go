@@ -106,7 +106,11 @@ type ResponseWriter struct {
 	// is forbidden over UDP in both directions, and DoQ forbids it
 	// entirely (RFC 9250 §5.5.2).
 	keepalive bool
-	pooled    bool
+	// pad marks an encrypted client that padded its query; the response
+	// is padded to the RFC 8467 block length in return. Plain UDP and
+	// TCP are never padded — the length hides nothing there.
+	pad    bool
+	pooled bool
 }
 
 // (*EDNS).ServeDNS serveDNS implements the Handle interface. A wire-born
@@ -136,6 +140,8 @@ func (e *EDNS) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 
 	noedns := req.IsEdns0() == nil
 	keepalive := hasClientKeepalive(req)
+	// Read before SetEdns0 drops every client option.
+	pad := ch.Encrypted() && ch.Request.HasPadding()
 	if hasClientECS(req) {
 		// Preserve the ingress fact before SetEdns0 applies the forwarding
 		// policy. A disabled policy or an allow-list miss strips ECS from the
@@ -182,6 +188,7 @@ func (e *EDNS) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 	rw.noedns = noedns
 	rw.nsid = nsid
 	rw.keepalive = keepalive && w.Proto() == "tcp"
+	rw.pad = pad
 	rw.respUDPSize = opt.UDPSize()
 	// Clear AD unless the client signalled it wants validation state (DO
 	// or AD bit set) AND did not set CD. RFC 4035 §3.2.3 / RFC 6840 §5.7:
@@ -245,6 +252,7 @@ func (e *EDNS) serveWire(ctx context.Context, ch *middleware.Chain) {
 	rw.noedns = noedns
 	rw.nsid = req.HasNSID()
 	rw.keepalive = req.HasTCPKeepalive() && w.Proto() == "tcp"
+	rw.pad = ch.Encrypted() && req.HasPadding()
 	rw.respUDPSize = dnsutil.DefaultMsgSize
 	if cookie := req.ClientCookie(); len(cookie) >= 8 {
 		copy(rw.cookieRaw[:], cookie[:8])
@@ -363,6 +371,10 @@ func (w *ResponseWriter) WriteMsg(m *dns.Msg) error {
 				Timeout: tcpKeepaliveUnits,
 			})
 		}
+
+		// Padding is hop-by-hop as well: an upstream's padding sized
+		// its own message, not this one. Ours goes on last, below.
+		opt.Option = dnsutil.StripPadding(opt.Option)
 	} else {
 		// EDNS disabled, remove all OPT records
 		m = dnsutil.ClearOPT(m)
@@ -388,6 +400,11 @@ func (w *ResponseWriter) WriteMsg(m *dns.Msg) error {
 		m.AuthenticatedData = false
 	}
 
+	if w.pad && !w.noedns {
+		// Last, so the block covers the message exactly as it is sent.
+		dnsutil.Pad(m, dnsutil.ResponsePaddingBlock)
+	}
+
 	return w.ResponseWriter.WriteMsg(m)
 }
 
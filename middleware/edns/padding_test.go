@@ -0,0 +1,159 @@
+package edns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/dnsutil"
+	"github.com/semihalev/sdns/internal/mock"
+	"github.com/semihalev/sdns/internal/wire"
+	"github.com/semihalev/sdns/middleware"
+)
+
+// paddedUpstream answers with a padding option of its own, as a padding
+// upstream's reply forwarded as it came would carry.
+type paddedUpstream struct{}
+
+func (paddedUpstream) Name() string { return "padded-upstream" }
+
+func (paddedUpstream) ServeDNS(ctx context.Context, ch *middleware.Chain) {
+	req := ch.Request.Msg()
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Answer = append(m.Answer, &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300},
+		Target: "target." + req.Question[0].Name,
+	})
+	m.SetEdns0(1232, false)
+	m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_PADDING{Padding: make([]byte, 37)})
+	_ = ch.Writer.WriteMsg(m)
+}
+
+func paddingOptions(m *dns.Msg) int {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return 0
+	}
+	var n int
+	for _, o := range opt.Option {
+		if _, ok := o.(*dns.EDNS0_PADDING); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPaddingOnEncryptedTransports(t *testing.T) {
+	ch := middleware.NewChain([]middleware.Handler{New(new(config.Config)), paddedUpstream{}})
+
+	for _, tc := range []struct {
+		proto     string
+		clientPad bool
+		wantPad   bool
+	}{
+		{"doh", true, true},
+		{"doq", true, true},
+		{"doh", false, false},
+		{"tcp", true, false},
+		{"udp", true, false},
+	} {
+		req := new(dns.Msg)
+		req.SetQuestion("www.example.com.", dns.TypeA)
+		req.SetEdns0(1232, true)
+		if tc.clientPad {
+			req.IsEdns0().Option = append(req.IsEdns0().Option, &dns.EDNS0_PADDING{Padding: make([]byte, 64)})
+		}
+
+		mw := mock.NewWriter(tc.proto, "192.0.2.1:853")
+		ch.Reset(mw, req)
+		ch.Next(context.Background())
+
+		resp := mw.Msg()
+		if resp == nil {
+			t.Fatalf("%s: no response", tc.proto)
+		}
+		packed, err := resp.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch pads := paddingOptions(resp); {
+		case tc.wantPad && (pads != 1 || len(packed)%dnsutil.ResponsePaddingBlock != 0):
+			t.Errorf("%s, client padded: %d octets with %d padding options", tc.proto, len(packed), pads)
+		case !tc.wantPad && pads != 0:
+			t.Errorf("%s, client padded %v: response padded", tc.proto, tc.clientPad)
+		}
+	}
+}
+
+// wireCapture is a stream writer that keeps what it was sent.
+type wireCapture struct {
+	wireCountingWriter
+	proto string
+	body  []byte
+}
+
+func (w *wireCapture) Proto() string { return w.proto }
+
+func (w *wireCapture) WriteWire(body []byte, _ middleware.WireInfo) error {
+	w.body = append(w.body[:0], body...)
+	return nil
+}
+
+func TestWirePadding(t *testing.T) {
+	reply := new(dns.Msg)
+	reply.SetQuestion("www.example.com.", dns.TypeA)
+	reply.Response = true
+
+	for _, size := range []int{0, 40, 300} {
+		reply.Answer = nil
+		for range size / 16 {
+			reply.Answer = append(reply.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   remoteIP,
+			})
+		}
+		body, err := reply.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		next := &wireCapture{proto: "doh"}
+		w := &ResponseWriter{
+			ResponseWriter: next,
+			EDNS:           &EDNS{cookiesecret: "abcdef0123456789"},
+			do:             true,
+			cookie:         "0123456789abcdef",
+			pad:            true,
+			respUDPSize:    dnsutil.DefaultMsgSize,
+		}
+		reserve, ok := w.wireOPTLen()
+		if !ok {
+			t.Fatal("reservation refused")
+		}
+		if err := w.WriteWire(append(make([]byte, 0, len(body)+reserve), body...), middleware.WireInfo{}); err != nil {
+			t.Fatalf("WriteWire: %v", err)
+		}
+		if len(next.body)%dnsutil.ResponsePaddingBlock != 0 {
+			t.Errorf("%d answers: %d octets sent", len(reply.Answer), len(next.body))
+		}
+		if len(next.body)-len(body) > reserve {
+			t.Errorf("%d answers: OPT took %d octets, %d reserved", len(reply.Answer), len(next.body)-len(body), reserve)
+		}
+
+		sent := new(dns.Msg)
+		if err := sent.Unpack(next.body); err != nil {
+			t.Fatalf("padded reply does not parse: %v", err)
+		}
+		if paddingOptions(sent) != 1 {
+			t.Errorf("%d answers: reply carries %d padding options", len(reply.Answer), paddingOptions(sent))
+		}
+	}
+
+	// Without padding the reservation is still exact.
+	w := &ResponseWriter{ResponseWriter: &wireCapture{proto: "doh"}, EDNS: &EDNS{}}
+	if reserve, _ := w.wireOPTLen(); reserve != wire.OPTFixedLen {
+		t.Errorf("unpadded reserve = %d, want %d", reserve, wire.OPTFixedLen)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/dnsutil"
 	"github.com/semihalev/sdns/internal/wire"
 	"github.com/semihalev/sdns/middleware"
 )
@@ -80,7 +81,8 @@ func (w *ResponseWriter) WireReady() (middleware.WireCapability, bool) {
 	return capability, true
 }
 
-// wireOPTLen is the exact encoded length of the OPT this layer appends.
+// wireOPTLen is the exact encoded length of the OPT this layer appends,
+// or with padding its upper bound.
 // On the Msg path SetEdns0 has already stripped every client option except
 // a possibly forwarded ECS, which a reply never carries; any other
 // leftover option is one this layer has no encoder for, so it declines
@@ -115,6 +117,11 @@ func (w *ResponseWriter) wireOPTLen() (int, bool) {
 	if w.keepalive {
 		length += wire.OPTOptionHdrLen + 2
 	}
+	if w.pad {
+		// The padding depends on the finished body; reserve the most it
+		// can be.
+		length += wire.OPTOptionHdrLen + dnsutil.ResponsePaddingBlock - 1
+	}
 	return length, true
 }
 
@@ -167,6 +174,12 @@ func (w *ResponseWriter) appendWireOPT(body []byte, info middleware.WireInfo) ([
 	if info.HasEDE {
 		body = wire.AppendOptionEDE(body, info.EDECode, info.EDEText)
 	}
+	if w.pad {
+		// body is the whole message by now, so the padding option,
+		// appended last, brings it to the block exactly.
+		n := dnsutil.PaddingLen(len(body), dnsutil.ResponsePaddingBlock)
+		body = wire.AppendOption(body, dns.EDNS0PADDING, zeroPadding[:n])
+	}
 
 	return wire.FinishOPT(body, rdlenOff), true
 }
@@ -222,6 +235,10 @@ func (w *ResponseWriter) serverCookie(dst []byte) bool {
 	return true
 }
 
+// zeroPadding is the Padding option payload, sliced to length: RFC 7830
+// asks for zero octets.
+var zeroPadding [dnsutil.ResponsePaddingBlock]byte
+
 func hexNibble(c byte) (byte, bool) {
 	switch {
 	case c >= '0' && c <= '9':
@@ -38,26 +38,8 @@ type ipResolver interface {
 // DNS suffixes on Windows).
 var resolver ipResolver = net.DefaultResolver
 
-// newDoHServer parses a DoH upstream URL, resolves the hostname (if
-// any) via the system resolver, and returns a server entry ready to
-// be added to Forwarder.servers. Hostname resolution happens once at
-// boot — there is no per-query DNS dependency. The resolved IPs are
-// pinned into a custom DialContext on the returned http.Client so
-// Go's transport never re-resolves at connect time.
-//
-// dialTimeout bounds a single per-IP TCP dial — sourced from
-// cfg.Timeout so operators tune all upstream timeouts through one
-// knob. A blackholed pinned IP gets bypassed in dialTimeout
-// instead of consuming the entire requestTimeout, which keeps the
-// rotation effective even when a hostname resolves to mixed A/AAAA
-// on a host with broken v6.
-//
-// requestTimeout caps the full POST round-trip — sourced from
-// cfg.QueryTimeout. Zero on either disables that ceiling; production
-// callers should always pass non-zero values.
-//
-// TLS ServerName is set to the original hostname even when we dial
-// an IP literal — this preserves SNI and cert-chain validation.
+// newDoHServer parses a DoH upstream URL and returns a server entry
+// ready to be added to Forwarder.servers, reached over pinnedClient.
 //
 // Returns an error if the URL is malformed, the scheme is not https,
 // the URL has no host, or the hostname fails to resolve at boot
@@ -72,6 +54,38 @@ func newDoHServer(rawURL string, dialTimeout, requestTimeout time.Duration) (*se
 	if u.Scheme != "https" {
 		return nil, fmt.Errorf("scheme must be https, got %q", u.Scheme)
 	}
+	client, err := pinnedClient(u, dialTimeout, requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &server{
+		Addr:      rawURL,
+		Proto:     "doh",
+		DoHURL:    rawURL,
+		DoHClient: client,
+	}, nil
+}
+
+// pinnedClient returns the http.Client reaching the host of u. The
+// hostname (if any) is resolved via the system resolver once at boot
+// — there is no per-query DNS dependency. The resolved IPs are pinned
+// into a custom DialContext so Go's transport never re-resolves at
+// connect time.
+//
+// dialTimeout bounds a single per-IP TCP dial — sourced from
+// cfg.Timeout so operators tune all upstream timeouts through one
+// knob. A blackholed pinned IP gets bypassed in dialTimeout
+// instead of consuming the entire requestTimeout, which keeps the
+// rotation effective even when a hostname resolves to mixed A/AAAA
+// on a host with broken v6.
+//
+// requestTimeout caps the full round-trip — sourced from
+// cfg.QueryTimeout. Zero on either disables that ceiling; production
+// callers should always pass non-zero values.
+//
+// TLS ServerName is set to the original hostname even when we dial
+// an IP literal — this preserves SNI and cert-chain validation.
+func pinnedClient(u *url.URL, dialTimeout, requestTimeout time.Duration) (*http.Client, error) {
 	if u.Host == "" {
 		return nil, errors.New("missing host")
 	}
@@ -83,6 +97,7 @@ func newDoHServer(rawURL string, dialTimeout, requestTimeout time.Duration) (*se
 	}
 
 	var ips []net.IP
+	var err error
 	if ip := net.ParseIP(host); ip != nil {
 		// IP literal — no bootstrap needed.
 		ips = []net.IP{ip}
@@ -157,10 +172,5 @@ func newDoHServer(rawURL string, dialTimeout, requestTimeout time.Duration) (*se
 		},
 	}
 
-	return &server{
-		Addr:      rawURL,
-		Proto:     "doh",
-		DoHURL:    rawURL,
-		DoHClient: &http.Client{Transport: tr, Timeout: requestTimeout},
-	}, nil
+	return &http.Client{Transport: tr, Timeout: requestTimeout}, nil
 }
@@ -17,6 +17,7 @@ import (
 
 	"github.com/miekg/dns"
 	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/dnsutil"
 	"github.com/semihalev/sdns/internal/mock"
 	"github.com/semihalev/sdns/middleware"
 )
@@ -625,3 +626,44 @@ func stubConfig(forwarders []string) *config.Config {
 }
 
 var _ = strings.ToLower // satisfy import in test refactors
+
+// TestDoHQueryIsPadded checks the query an encrypted upstream sees is
+// padded to the RFC 8467 block, while the chain's request keeps its
+// shape.
+func TestDoHQueryIsPadded(t *testing.T) {
+	var (
+		sawLen  int
+		sawPads int
+	)
+	dohURL, stop := startDoHServer(t, "/dns-query", func(r *http.Request) (int, []byte, string) {
+		req := readDoHRequest(t, r)
+		packed, _ := req.Pack()
+		sawLen = len(packed)
+		for _, o := range req.IsEdns0().Option {
+			if _, ok := o.(*dns.EDNS0_PADDING); ok {
+				sawPads++
+			}
+		}
+		return http.StatusOK, dohAnswerFor(t, req, "203.0.113.5", ""), ""
+	})
+	defer stop()
+
+	f := &Forwarder{servers: []*server{dohServerWithSkipVerify(t, dohURL)}, dnssec: false}
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	req.SetEdns0(dnsutil.DefaultMsgSize, true)
+	mw := mock.NewWriter("udp", "127.0.0.1:0")
+	ch := middleware.NewChain([]middleware.Handler{f})
+	ch.Reset(mw, req)
+	f.ServeDNS(context.Background(), ch)
+
+	if mw.Msg() == nil || mw.Msg().Rcode != dns.RcodeSuccess {
+		t.Fatalf("unexpected response: %+v", mw.Msg())
+	}
+	if sawPads != 1 || sawLen%dnsutil.QueryPaddingBlock != 0 {
+		t.Errorf("upstream saw %d octets with %d padding options", sawLen, sawPads)
+	}
+	if len(req.IsEdns0().Option) != 0 {
+		t.Errorf("the chain's request was padded: %v", req.IsEdns0().Option)
+	}
+}
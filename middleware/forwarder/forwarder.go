@@ -6,13 +6,16 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/semihalev/sdns/config"
 	"github.com/semihalev/sdns/internal/contextutil"
+	"github.com/semihalev/sdns/internal/debuglog"
 	"github.com/semihalev/sdns/internal/dnsclient"
 	"github.com/semihalev/sdns/internal/dnsutil"
 	"github.com/semihalev/sdns/internal/metric"
@@ -45,12 +48,18 @@ var (
 
 type server struct {
 	Addr  string
-	Proto string // "udp" | "tcp-tls" | "doh"
+	Proto string // "udp" | "tcp-tls" | "doh" | "odoh"
 
 	// DoH-only fields. Populated by newDoHServer when Proto=="doh";
 	// nil for plain UDP and DoT entries.
 	DoHURL    string
 	DoHClient *http.Client
+
+	// ODoH is the Oblivious DoH target and relay, populated by
+	// newODoHServer when Proto=="odoh".
+	ODoH *dnsclient.ODoH
+
+	health health
 }
 
 // Forwarder type.
@@ -73,6 +82,11 @@ type Forwarder struct {
 	// one slow upstream cannot eat the whole queryTimeout budget. DoH
 	// upstreams carry their own timeout on the reused http.Client.
 	dialTimeout time.Duration
+
+	// lastProbe is when Ready last asked the upstreams itself, in unix
+	// nanoseconds; probing is set while those queries are out.
+	lastProbe atomic.Int64
+	probing   atomic.Bool
 }
 
 // New return forwarder.
@@ -84,8 +98,11 @@ type Forwarder struct {
 //   - "https://dns.example.com/dns-query"  — DoH with hostname (bootstrapped
 //     via the system resolver once at startup; resolved IPs are pinned for
 //     the process lifetime, no per-query DNS dependency)
+//   - "odoh://odoh.example.com/dns-query?relay=https://relay.example.com/proxy"
+//     — Oblivious DoH (RFC 9230): sealed to the target, sent through the
+//     relay; both hosts bootstrapped and pinned like a DoH upstream
 //
-// DoH servers honour cfg.Timeout (per-IP dial budget) and
+// DoH and ODoH servers honour cfg.Timeout (per-IP dial budget) and
 // cfg.QueryTimeout (full request budget) — operators tune both via
 // the existing top-level config keys, no DoH-specific knob.
 //
@@ -126,6 +143,14 @@ func New(cfg *config.Config) *Forwarder {
 			}
 			appendServer(srv)
 
+		case strings.HasPrefix(s, "odoh://"):
+			srv, err := newODoHServer(s, dialTimeout, requestTimeout)
+			if err != nil {
+				zlog.Error("Forwarder ODoH server not usable", "server", s, "error", err.Error())
+				continue
+			}
+			appendServer(srv)
+
 		case strings.HasPrefix(s, "tls://"):
 			addr := strings.TrimPrefix(s, "tls://")
 			if !validForwarderAddr(addr) {
@@ -162,11 +187,36 @@ func validForwarderAddr(addr string) bool {
 	return net.ParseIP(host) != nil
 }
 
+// client returns a client for one upstream, over its transport.
+func (f *Forwarder) client(server *server) dnsclient.Client {
+	client := dnsclient.Client{
+		Proto:   server.Proto,
+		Timeout: f.dialTimeout,
+	}
+	switch server.Proto {
+	case "doh":
+		client.DoHURL = server.DoHURL
+		client.DoHClient = server.DoHClient
+	case "odoh":
+		client.ODoH = server.ODoH
+	case "tcp-tls":
+		client.TLSConfig = f.tlsConfig
+	}
+	return client
+}
+
 // (*Forwarder).Name name return middleware name.
 func (f *Forwarder) Name() string { return name }
 
 // (*Forwarder).ServeDNS serveDNS implements the Handle interface.
 func (f *Forwarder) ServeDNS(ctx context.Context, ch *middleware.Chain) {
+	// A forwarded query is recursion by proxy: a listener that refuses
+	// recursion refuses it here too, cache or no cache ahead of it.
+	if ch.RecursionRefused() {
+		ch.CancelWithRcode(dns.RcodeRefused, false)
+		return
+	}
+
 	ctx, req := ch.Materialize(ctx)
 	if req == nil {
 		return
@@ -211,6 +261,9 @@ func (f *Forwarder) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 	var (
 		failureResponse *dns.Msg
 		requestLocalErr error
+		// paddedReq is req padded for the encrypted upstreams, built
+		// on the first one tried.
+		paddedReq *dns.Msg
 	)
 	for _, server := range f.servers {
 		// Build a lightweight client per upstream. For DoH this
@@ -222,25 +275,24 @@ func (f *Forwarder) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 		if server.Proto == "doh" {
 			endpoint = server.DoHURL
 		}
-		client := dnsclient.Client{
-			Proto:   server.Proto,
-			Timeout: f.dialTimeout,
-			BeforeAttempt: func(proto string) error {
-				if err := middleware.BeginResolutionAttempt(ctx, req.Question[0], endpoint, proto); err != nil {
-					return err
-				}
-				return middleware.DebitRecursionWork(ctx, middleware.RecursionWorkOutboundQuery)
-			},
+		client := f.client(server)
+		client.BeforeAttempt = func(proto string) error {
+			if err := middleware.BeginResolutionAttempt(ctx, req.Question[0], endpoint, proto); err != nil {
+				return err
+			}
+			return middleware.DebitRecursionWork(ctx, middleware.RecursionWorkOutboundQuery)
 		}
-		switch server.Proto {
-		case "doh":
-			client.DoHURL = server.DoHURL
-			client.DoHClient = server.DoHClient
-		case "tcp-tls":
-			client.TLSConfig = f.tlsConfig
+
+		// ODoH pads the sealed message itself.
+		query := req
+		if server.Proto == "doh" || server.Proto == "tcp-tls" {
+			if paddedReq == nil {
+				paddedReq = padded(req)
+			}
+			query = paddedReq
 		}
 
-		resp, _, err := client.Exchange(ctx, req, server.Addr)
+		resp, rtt, err := client.Exchange(ctx, query, server.Addr)
 		if err != nil {
 			if errors.Is(err, middleware.ErrResolutionAttemptLimit) {
 				// The request-local guard says nothing about this upstream's
@@ -293,13 +345,20 @@ func (f *Forwarder) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 				forwarderFailures.Inc()
 				zlog.Info("forwarder query failed", "query", dnsutil.FormatQuestion(req.Question[0]), "error", err.Error())
 			}
+			server.health.fail(err.Error())
 			continue
 		}
 
 		resp.Id = req.Id
 		resp.CheckingDisabled = clientCD
+		middleware.NoteUpstream(ctx, req.Question[0], endpoint)
 		responseType, _ := dnsutil.ClassifyResponse(resp, time.Now())
+		if debuglog.On(ctx, debuglog.Forwarder) {
+			debuglog.Log(ctx, debuglog.Forwarder, "Upstream answered", "query", dnsutil.FormatQuestion(req.Question[0]),
+				"upstream", endpoint, "proto", server.Proto, "rcode", dns.RcodeToString[resp.Rcode], "rtt", rtt.String())
+		}
 		if responseType == dnsutil.TypeServerFailure {
+			server.health.fail(dns.RcodeToString[resp.Rcode])
 			// A DNS response is not necessarily a useful response. RFC 9520
 			// requires a resolution failure only after the available servers
 			// have failed, so keep the first diagnostic reply and continue to
@@ -310,6 +369,7 @@ func (f *Forwarder) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 			continue
 		}
 
+		server.health.answer(rtt)
 		_ = w.WriteMsg(resp)
 		return
 	}
@@ -354,4 +414,27 @@ func (f *Forwarder) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 	ch.CancelWithRcode(dns.RcodeServerFailure, true)
 }
 
+// padded returns a copy of req carrying RFC 7830 padding to the RFC 8467
+// query block, so an encrypted upstream's observer learns nothing from
+// its length. req is the chain's and stays untouched; a request without
+// an OPT record goes as it is.
+func padded(req *dns.Msg) *dns.Msg {
+	opt := req.IsEdns0()
+	if opt == nil {
+		return req
+	}
+	out := *req
+	paddedOpt := *opt
+	paddedOpt.Option = slices.Clone(opt.Option)
+	out.Extra = make([]dns.RR, len(req.Extra))
+	for i, rr := range req.Extra {
+		if rr == opt {
+			rr = &paddedOpt
+		}
+		out.Extra[i] = rr
+	}
+	dnsutil.Pad(&out, dnsutil.QueryPaddingBlock)
+	return &out
+}
+
 const name = "forwarder"
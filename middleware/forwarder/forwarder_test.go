@@ -289,6 +289,14 @@ func TestForwarderTriesNextServerAfterUnusableDNSResponse(t *testing.T) {
 	if badCalls.Load() != 1 || goodCalls.Load() != 1 {
 		t.Fatalf("server calls = bad:%d good:%d, want 1/1", badCalls.Load(), goodCalls.Load())
 	}
+
+	ups := f.Upstreams()
+	if bad := ups[0]; bad.Health != "FAILING" || bad.Failed != 1 || bad.LastError != "SERVFAIL" || bad.Answered != 0 {
+		t.Fatalf("failing upstream %+v", bad)
+	}
+	if good := ups[1]; good.Health != "GOOD" || good.Answered != 1 || good.LastAnswer.IsZero() {
+		t.Fatalf("answering upstream %+v", good)
+	}
 }
 
 func TestForwarderResolutionAttemptProvenanceMarksSelectedTerminalFailure(t *testing.T) {
@@ -739,3 +747,60 @@ func vacantLoopbackAddr(t *testing.T) string {
 
 	return pc.LocalAddr().String()
 }
+
+func TestForwarderReadyProbesUpstreams(t *testing.T) {
+	if !New(new(config.Config)).Ready() {
+		t.Fatal("a forwarder without upstreams has nothing to wait for")
+	}
+
+	addr, stop := startTestDNSServer(t, "udp")
+	defer stop()
+
+	cfg := new(config.Config)
+	cfg.ForwarderServers = []string{vacantLoopbackAddr(t), addr}
+	f := New(cfg)
+
+	// No client has been forwarded yet; the first call starts a probe.
+	if f.Ready() {
+		t.Fatal("ready before any upstream answered")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !f.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatalf("probe never found the answering upstream: %+v", f.Upstreams())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if up := f.Upstreams(); up[0].Health != "FAILING" || up[1].Health != "GOOD" {
+		t.Fatalf("upstreams after probe: %+v", up)
+	}
+}
+
+// A listener that refuses recursion is refused by the forwarder itself,
+// whether or not a cache stands in front of it.
+func TestForwarderRefusesRecursionPerListener(t *testing.T) {
+	addr, stop := startTestDNSServer(t, "udp")
+	defer stop()
+	f := &Forwarder{servers: []*server{{Addr: addr, Proto: "udp"}}}
+
+	req := new(dns.Msg)
+	req.SetQuestion("refused.example.", dns.TypeA)
+
+	mw := mock.NewWriter("udp", "203.0.113.7:5353")
+	ch := middleware.NewChain([]middleware.Handler{f})
+	mw.Policy = &middleware.ListenerPolicy{Name: "public", RefuseRecursion: true}
+	ch.Reset(mw, req)
+	ch.Next(context.Background())
+	if !mw.Written() || mw.Rcode() != dns.RcodeRefused {
+		t.Fatalf("rcode = %d, want REFUSED", mw.Rcode())
+	}
+
+	mw = mock.NewWriter("udp", "203.0.113.7:5353")
+	ch = middleware.NewChain([]middleware.Handler{f})
+	mw.Policy = &middleware.ListenerPolicy{Name: "lan"}
+	ch.Reset(mw, req)
+	ch.Next(context.Background())
+	if !mw.Written() || mw.Rcode() != dns.RcodeSuccess {
+		t.Fatalf("a listener allowing recursion got rcode %d", mw.Rcode())
+	}
+}
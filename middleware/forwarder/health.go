@@ -0,0 +1,130 @@
+package forwarder
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// probeEvery is how often Ready asks the upstreams itself while none of
+// them has answered.
+const probeEvery = 5 * time.Second
+
+// health is what the forwarder has seen of one upstream, for the API.
+type health struct {
+	answered    atomic.Uint64
+	failed      atomic.Uint64
+	rtt         atomic.Int64 // nanoseconds, of the last answer
+	lastAnswer  atomic.Int64 // unix nanoseconds
+	lastFailure atomic.Int64 // unix nanoseconds
+	lastErr     atomic.Pointer[string]
+}
+
+func (h *health) answer(rtt time.Duration) {
+	h.answered.Add(1)
+	h.rtt.Store(int64(rtt))
+	h.lastAnswer.Store(time.Now().UnixNano())
+}
+
+func (h *health) fail(reason string) {
+	h.failed.Add(1)
+	h.lastErr.Store(&reason)
+	h.lastFailure.Store(time.Now().UnixNano())
+}
+
+// Upstream is a forwarder upstream and how it has been doing. Health is
+// GOOD when it answered last, FAILING when it failed last, and UNKNOWN
+// before it was first asked.
+type Upstream struct {
+	Server      string    `json:"server"`
+	Proto       string    `json:"proto"`
+	Health      string    `json:"health"`
+	Answered    uint64    `json:"answered"`
+	Failed      uint64    `json:"failed"`
+	RTTMs       float64   `json:"rtt_ms,omitempty"`
+	LastAnswer  time.Time `json:"last_answer,omitzero"`
+	LastFailure time.Time `json:"last_failure,omitzero"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// (*Forwarder).Upstreams returns the configured upstreams in the order
+// they are tried.
+func (f *Forwarder) Upstreams() []Upstream {
+	out := make([]Upstream, len(f.servers))
+	for i, s := range f.servers {
+		u := Upstream{
+			Server:   s.Addr,
+			Proto:    s.Proto,
+			Health:   "UNKNOWN",
+			Answered: s.health.answered.Load(),
+			Failed:   s.health.failed.Load(),
+		}
+		if s.Proto == "doh" {
+			u.Server = s.DoHURL
+		}
+		answered, failed := s.health.lastAnswer.Load(), s.health.lastFailure.Load()
+		if answered != 0 {
+			u.LastAnswer = time.Unix(0, answered).UTC()
+			u.RTTMs = float64(s.health.rtt.Load()) / float64(time.Millisecond)
+			u.Health = "GOOD"
+		}
+		if failed != 0 {
+			u.LastFailure = time.Unix(0, failed).UTC()
+			if failed > answered {
+				u.Health = "FAILING"
+			}
+		}
+		if reason := s.health.lastErr.Load(); reason != nil {
+			u.LastError = *reason
+		}
+		out[i] = u
+	}
+	return out
+}
+
+// (*Forwarder).Ready reports whether an upstream answered last time it was
+// asked; with no upstreams configured the forwarder is idle and ready.
+// While none has, Ready queries every upstream for the root NS set in the
+// background, at most once per probeEvery, since a server that is not
+// ready gets no client traffic to find out from.
+func (f *Forwarder) Ready() bool {
+	if len(f.servers) == 0 {
+		return true
+	}
+	for _, s := range f.servers {
+		if answered := s.health.lastAnswer.Load(); answered != 0 && answered >= s.health.lastFailure.Load() {
+			return true
+		}
+	}
+
+	now := time.Now().UnixNano()
+	if last := f.lastProbe.Load(); now-last >= int64(probeEvery) && f.probing.CompareAndSwap(false, true) {
+		f.lastProbe.Store(now)
+		go f.probe()
+	}
+	return false
+}
+
+func (f *Forwarder) probe() {
+	defer f.probing.Store(false)
+
+	req := new(dns.Msg)
+	req.SetQuestion(".", dns.TypeNS)
+	req.CheckingDisabled = !f.dnssec
+	for _, s := range f.servers {
+		ctx, cancel := context.WithTimeout(context.Background(), f.dialTimeout)
+		client := f.client(s)
+		resp, rtt, err := client.Exchange(ctx, req, s.Addr)
+		cancel()
+		switch {
+		case err != nil:
+			s.health.fail(err.Error())
+		case resp.Rcode == dns.RcodeServerFailure || resp.Rcode == dns.RcodeRefused:
+			s.health.fail(dns.RcodeToString[resp.Rcode])
+		default:
+			s.health.answer(rtt)
+		}
+	}
+}
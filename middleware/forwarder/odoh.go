@@ -0,0 +1,50 @@
+package forwarder
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/semihalev/sdns/internal/dnsclient"
+)
+
+// newODoHServer parses an Oblivious DoH upstream,
+// odoh://target/path?relay=https://relay/path, and returns a server
+// entry whose queries are sealed to the target and sent through the
+// relay. The path defaults to /dns-query; without a relay the target
+// is queried directly. Target and relay are each reached over a
+// pinnedClient, bootstrapped once at boot like a DoH upstream.
+func newODoHServer(rawURL string, dialTimeout, requestTimeout time.Duration) (*server, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	if u.Scheme != "odoh" {
+		return nil, fmt.Errorf("scheme must be odoh, got %q", u.Scheme)
+	}
+
+	target := &url.URL{Scheme: "https", Host: u.Host, Path: u.Path}
+	if target.Path == "" || target.Path == "/" {
+		target.Path = "/dns-query"
+	}
+	o := &dnsclient.ODoH{Target: target}
+	if o.TargetClient, err = pinnedClient(target, dialTimeout, requestTimeout); err != nil {
+		return nil, fmt.Errorf("target: %w", err)
+	}
+
+	if raw := u.Query().Get("relay"); raw != "" {
+		relay, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parse relay url: %w", err)
+		}
+		if relay.Scheme != "https" {
+			return nil, fmt.Errorf("relay scheme must be https, got %q", relay.Scheme)
+		}
+		if o.RelayClient, err = pinnedClient(relay, dialTimeout, requestTimeout); err != nil {
+			return nil, fmt.Errorf("relay: %w", err)
+		}
+		o.Relay = relay
+	}
+
+	return &server{Addr: rawURL, Proto: "odoh", ODoH: o}, nil
+}
@@ -0,0 +1,100 @@
+package forwarder
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/odoh"
+)
+
+func TestNewODoHServer(t *testing.T) {
+	srv, err := newODoHServer("odoh://192.0.2.1?relay=https://192.0.2.2:8443/proxy", time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Proto != "odoh" || srv.ODoH == nil {
+		t.Fatalf("server = %+v", srv)
+	}
+	if got := srv.ODoH.Target.String(); got != "https://192.0.2.1/dns-query" {
+		t.Errorf("target = %q, want the default path", got)
+	}
+	if srv.ODoH.Relay == nil || srv.ODoH.Relay.Host != "192.0.2.2:8443" || srv.ODoH.RelayClient == nil {
+		t.Errorf("relay = %v", srv.ODoH.Relay)
+	}
+
+	direct, err := newODoHServer("odoh://192.0.2.1/odoh", time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if direct.ODoH.Relay != nil || direct.ODoH.Target.Path != "/odoh" {
+		t.Errorf("direct = %+v", direct.ODoH)
+	}
+
+	for _, bad := range []string{
+		"odoh:///dns-query",
+		"odoh://192.0.2.1?relay=http://192.0.2.2/proxy",
+		"odoh://192.0.2.1?relay=https:///proxy",
+	} {
+		if _, err := newODoHServer(bad, time.Second, time.Second); err == nil {
+			t.Errorf("newODoHServer(%q) accepted", bad)
+		}
+	}
+}
+
+func TestForwarder_ODoH_Success(t *testing.T) {
+	secret, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := odoh.NewKeyPair(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(odoh.ConfigsPath, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(odoh.MarshalConfigs(key.Config()))
+	})
+	mux.HandleFunc("/dns-query", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		buf, rc, err := key.DecryptQuery(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req := new(dns.Msg)
+		if err := req.Unpack(buf); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sealed, err := rc.EncryptResponse(dohAnswerFor(t, req, "203.0.113.9", ""))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", odoh.ContentType)
+		_, _ = w.Write(sealed)
+	})
+	ts := httptest.NewTLSServer(mux)
+	defer ts.Close()
+
+	srv, err := newODoHServer("odoh://"+ts.Listener.Addr().String(), time.Second, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.ODoH.TargetClient = dohServerWithSkipVerify(t, ts.URL).DoHClient
+
+	f := &Forwarder{servers: []*server{srv}}
+	resp := runForwarderQuery(t, f, "example.com.", dns.TypeA)
+	if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if a, ok := resp.Answer[0].(*dns.A); !ok || a.A.String() != "203.0.113.9" {
+		t.Errorf("wrong A record: %v", resp.Answer[0])
+	}
+}
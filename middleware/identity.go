@@ -0,0 +1,77 @@
+package middleware
+
+import "strings"
+
+// ClientIdentity returns the identity of the client's verified TLS
+// certificate — its SPIFFE ID, subject CN or DNS name — else the device
+// ID it named in its DoH path or TLS server name, as DeviceIdentity
+// spells it, or "" for a client that did neither, and for every UDP and
+// plain TCP query. Handlers that key on the client use it ahead of the
+// address, which behind NAT names a network rather than a device.
+//
+// The two kinds are told apart by the prefix: a certificate identity was
+// verified, a device ID is only what the client claimed.
+//
+// A transport offers the identity with a ClientIdentity method; the
+// chain reads it once per query, like ListenerPolicy.
+func (ch *Chain) ClientIdentity() string {
+	return ch.base.identity
+}
+
+// DeviceIDPrefix starts the identity of a client known by a device ID,
+// so that no device ID reads as a certificate's CN or SPIFFE ID, and a
+// pattern for one cannot match the other.
+const DeviceIDPrefix = "device:"
+
+// DeviceIdentity returns the client identity of device ID id.
+func DeviceIdentity(id string) string {
+	return DeviceIDPrefix + id
+}
+
+// IsDeviceIdentity reports whether identity names a device ID rather
+// than a verified certificate.
+func IsDeviceIdentity(identity string) bool {
+	return strings.HasPrefix(identity, DeviceIDPrefix)
+}
+
+// IdentitySet is a compiled list of client identities. An entry ending
+// in "*" matches every identity with the prefix before it, so
+// "spiffe://example.com/laptop/*" covers a fleet.
+type IdentitySet struct {
+	exact    map[string]struct{}
+	prefixes []string
+}
+
+// NewIdentitySet compiles patterns; it returns nil for an empty list,
+// which contains nothing.
+func NewIdentitySet(patterns []string) *IdentitySet {
+	if len(patterns) == 0 {
+		return nil
+	}
+	s := &IdentitySet{exact: make(map[string]struct{}, len(patterns))}
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			s.prefixes = append(s.prefixes, prefix)
+			continue
+		}
+		s.exact[p] = struct{}{}
+	}
+	return s
+}
+
+// Contains reports whether id matches an entry. The empty identity
+// matches nothing.
+func (s *IdentitySet) Contains(id string) bool {
+	if s == nil || id == "" {
+		return false
+	}
+	if _, ok := s.exact[id]; ok {
+		return true
+	}
+	for _, p := range s.prefixes {
+		if strings.HasPrefix(id, p) {
+			return true
+		}
+	}
+	return false
+}
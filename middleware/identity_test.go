@@ -0,0 +1,23 @@
+package middleware
+
+import "testing"
+
+func TestIdentitySet(t *testing.T) {
+	s := NewIdentitySet([]string{"noc-1", "spiffe://example.com/laptop/*"})
+	for id, want := range map[string]bool{
+		"noc-1":                          true,
+		"noc-10":                         false,
+		"spiffe://example.com/laptop/7":  true,
+		"spiffe://example.com/phone/2":   false,
+		"spiffe://example.com/laptop":    false,
+		"":                               false,
+		"spiffe://example.com/laptop/7/": true,
+	} {
+		if got := s.Contains(id); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", id, got, want)
+		}
+	}
+	if NewIdentitySet(nil).Contains("noc-1") {
+		t.Error("empty set contains an identity")
+	}
+}
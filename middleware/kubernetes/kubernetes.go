@@ -13,6 +13,8 @@ import (
 
 	"github.com/miekg/dns"
 	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/debuglog"
+	"github.com/semihalev/sdns/internal/dnsutil"
 	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/zlog/v2"
 )
@@ -106,6 +108,11 @@ func New(cfg *config.Config) *Kubernetes {
 	return k
 }
 
+// Ready reports whether the middleware can answer for the cluster: at
+// once when the integration is off, otherwise after the informers have
+// synced or the demo data is loaded. Implements middleware.Readier.
+func (k *Kubernetes) Ready() bool { return k.registry == nil || k.ready() }
+
 // Name returns the middleware name.
 func (k *Kubernetes) Name() string { return "kubernetes" }
 
@@ -174,6 +181,9 @@ func (k *Kubernetes) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 	}
 
 	answers, extra, found := k.registry.ResolveQuery(qname, q.Qtype)
+	if debuglog.On(ctx, debuglog.Kubernetes) {
+		debuglog.Log(ctx, debuglog.Kubernetes, "Cluster query", "query", dnsutil.FormatQuestion(q), "found", found, "answers", len(answers))
+	}
 	if !found {
 		// Cluster-domain misses are authoritative NXDOMAIN;
 		// reverse-zone misses fall through (we can't tell a
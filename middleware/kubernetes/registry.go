@@ -1,6 +1,7 @@
 package kubernetes
 
 import (
+	"context"
 	"net"
 	"strconv"
 	"strings"
@@ -8,6 +9,7 @@ import (
 	"sync/atomic"
 
 	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/debuglog"
 	"github.com/semihalev/zlog/v2"
 )
 
@@ -494,9 +496,8 @@ func (r *Registry) AddPod(pod *Pod) {
 		return
 	}
 	if len(pod.IPs) == 0 {
-		zlog.Debug("Pod has no IPs",
-			zlog.String("pod", pod.Name),
-			zlog.String("namespace", pod.Namespace))
+		debuglog.Log(context.Background(), debuglog.Kubernetes, "Pod has no IPs",
+			"pod", pod.Name, "namespace", pod.Namespace)
 		return
 	}
 
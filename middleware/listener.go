@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/listenerpolicy"
+)
+
+// ListenerPolicy identifies the [[listeners]] entry a client query
+// arrived on; see listenerpolicy.Policy, which it aliases.
+//
+// A transport offers its policy with a ListenerPolicy method; the chain
+// reads it once per query, like Proto and Internal.
+type ListenerPolicy = listenerpolicy.Policy
+
+// NewListenerPolicies returns the policy of every cfg.Listeners entry, in
+// order.
+func NewListenerPolicies(cfg *config.Config) []*ListenerPolicy {
+	policies := make([]*ListenerPolicy, len(cfg.Listeners))
+	for i, l := range cfg.Listeners {
+		policies[i] = &ListenerPolicy{Index: i, Name: l.Label(), RefuseRecursion: l.RefuseRecursion()}
+	}
+	return policies
+}
+
+// ListenerPolicy returns the policy of the listener the query arrived on,
+// nil for the bind addresses and internal sub-queries.
+func (ch *Chain) ListenerPolicy() *ListenerPolicy {
+	return ch.base.listener
+}
+
+// RecursionRefused reports whether the query arrived on a listener that
+// refuses recursion.
+func (ch *Chain) RecursionRefused() bool {
+	p := ch.base.listener
+	return p != nil && p.RefuseRecursion
+}
+
+// Encrypted reports whether the query arrived over DoT, DoH or DoQ, where
+// a response's length is all an observer sees of it. A DoT transport
+// says so with an Encrypted method; DoH and DoQ are known by Proto.
+func (ch *Chain) Encrypted() bool {
+	return ch.base.encrypted
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/mock"
+)
+
+func Test_ChainListenerPolicy(t *testing.T) {
+	cfg := &config.Config{Listeners: []config.ListenerConfig{
+		{Name: "lan", Proto: "dns", Addr: "192.0.2.1:53"},
+		{Proto: "tls", Addr: "192.0.2.1:853", Recursion: "refuse"},
+	}}
+	policies := NewListenerPolicies(cfg)
+	if len(policies) != 2 || policies[0].Name != "lan" || policies[1].Name != "tls://192.0.2.1:853" {
+		t.Fatalf("policies = %+v %+v", policies[0], policies[1])
+	}
+	if policies[0].RefuseRecursion || !policies[1].RefuseRecursion || policies[1].Index != 1 {
+		t.Fatalf("policies = %+v %+v", policies[0], policies[1])
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	ch := NewChain(nil)
+
+	mw := mock.NewWriter("tcp", "192.0.2.9:5353")
+	mw.Policy = policies[1]
+	ch.Reset(mw, req)
+	if ch.ListenerPolicy() != policies[1] || !ch.RecursionRefused() {
+		t.Fatal("chain lost the transport's listener policy")
+	}
+	if ch.Writer.Proto() != "tcp" {
+		t.Fatalf("Proto = %q through the policy writer", ch.Writer.Proto())
+	}
+
+	// A rebind to a writer without a policy must not inherit the last one.
+	ch.Reset(mock.NewWriter("udp", "192.0.2.9:5353"), req)
+	if ch.ListenerPolicy() != nil || ch.RecursionRefused() {
+		t.Fatal("pooled chain kept the previous query's listener policy")
+	}
+}
+
+type encryptedWriter struct{ *mock.Writer }
+
+func (encryptedWriter) Encrypted() bool { return true }
+
+func Test_ChainEncrypted(t *testing.T) {
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	ch := NewChain(nil)
+
+	for _, tc := range []struct {
+		w    Transport
+		want bool
+	}{
+		{mock.NewWriter("doh", "192.0.2.9:443"), true},
+		{mock.NewWriter("doq", "192.0.2.9:853"), true},
+		{encryptedWriter{mock.NewWriter("tcp", "192.0.2.9:853")}, true},
+		{mock.NewWriter("tcp", "192.0.2.9:53"), false},
+		{mock.NewWriter("udp", "192.0.2.9:53"), false},
+	} {
+		ch.Reset(tc.w, req)
+		if ch.Encrypted() != tc.want {
+			t.Errorf("%s: Encrypted = %v", ch.Writer.Proto(), ch.Encrypted())
+		}
+	}
+}
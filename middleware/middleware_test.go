@@ -197,6 +197,20 @@ type purgerHandler struct {
 func (h *purgerHandler) ServeDNS(ctx context.Context, ch *Chain) { ch.Next(ctx) }
 func (h *purgerHandler) Name() string                            { return h.n }
 func (h *purgerHandler) Purge(q dns.Question)                    { h.purgedQ = append(h.purgedQ, q.Name) }
+func (h *purgerHandler) PurgeMatching(s PurgeScope) int {
+	h.purgedQ = append(h.purgedQ, s.Name)
+	return 1
+}
+
+// readierHandler implements both Handler and Readier.
+type readierHandler struct {
+	n     string
+	ready bool
+}
+
+func (h *readierHandler) ServeDNS(ctx context.Context, ch *Chain) { ch.Next(ctx) }
+func (h *readierHandler) Name() string                            { return h.n }
+func (h *readierHandler) Ready() bool                             { return h.ready }
 
 func Test_Pipeline_SubPipeline_FiltersByName(t *testing.T) {
 	Reset()
@@ -278,3 +292,56 @@ func Test_Pipeline_Purgers_EnumeratesOnlyPurgerHandlers(t *testing.T) {
 		t.Errorf("p2.purgedQ = %v, want %v", p2.purgedQ, []string{"example.com."})
 	}
 }
+
+func Test_PurgeScope_Match(t *testing.T) {
+	q := func(name string, qtype uint16) dns.Question {
+		return dns.Question{Name: name, Qtype: qtype, Qclass: dns.ClassINET}
+	}
+	tests := []struct {
+		name  string
+		scope PurgeScope
+		q     dns.Question
+		want  bool
+	}{
+		{"exact", PurgeScope{Name: "example.com.", Qtype: dns.TypeA}, q("example.com.", dns.TypeA), true},
+		{"exact case", PurgeScope{Name: "Example.COM.", Qtype: dns.TypeA}, q("example.com.", dns.TypeA), true},
+		{"other type", PurgeScope{Name: "example.com.", Qtype: dns.TypeA}, q("example.com.", dns.TypeAAAA), false},
+		{"all types", PurgeScope{Name: "example.com."}, q("example.com.", dns.TypeAAAA), true},
+		{"child without subtree", PurgeScope{Name: "example.com."}, q("www.example.com.", dns.TypeA), false},
+		{"child in subtree", PurgeScope{Name: "example.com.", Subtree: true}, q("www.example.com.", dns.TypeA), true},
+		{"apex in subtree", PurgeScope{Name: "example.com.", Subtree: true}, q("example.com.", dns.TypeMX), true},
+		{"sibling suffix", PurgeScope{Name: "example.com.", Subtree: true}, q("badexample.com.", dns.TypeA), false},
+		{"root subtree", PurgeScope{Name: ".", Subtree: true}, q("example.org.", dns.TypeA), true},
+		{"other class", PurgeScope{Name: "example.com.", Qclass: dns.ClassCHAOS}, q("example.com.", dns.TypeA), false},
+	}
+	for _, tt := range tests {
+		if got := tt.scope.Match(tt.q); got != tt.want {
+			t.Errorf("%s: Match(%v) = %v, want %v", tt.name, tt.q, got, tt.want)
+		}
+	}
+}
+
+func Test_Pipeline_NotReady(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+
+	if got := GlobalPipeline().NotReady(); got != nil {
+		t.Errorf("NotReady before Setup = %v, want nil", got)
+	}
+
+	r1 := &readierHandler{n: "resolver"}
+	r2 := &readierHandler{n: "blocklist", ready: true}
+	Register("resolver", func(*config.Config) Handler { return r1 })
+	Register("plain", func(*config.Config) Handler { return &namedHandler{n: "plain"} })
+	Register("blocklist", func(*config.Config) Handler { return r2 })
+
+	Setup(&config.Config{})
+
+	if got := GlobalPipeline().NotReady(); !reflect.DeepEqual(got, []string{"resolver"}) {
+		t.Errorf("NotReady = %v, want [resolver]", got)
+	}
+	r1.ready = true
+	if got := GlobalPipeline().NotReady(); got != nil {
+		t.Errorf("NotReady = %v, want nil", got)
+	}
+}
@@ -151,6 +151,21 @@ func (p *Pipeline) Purgers() []Purger {
 	return out
 }
 
+// NotReady returns the names of the enabled handlers implementing
+// Readier that are not ready yet, in pipeline order.
+func (p *Pipeline) NotReady() []string {
+	if p == nil {
+		return nil
+	}
+	var out []string
+	for _, h := range p.handlers {
+		if r, ok := h.(Readier); ok && !r.Ready() {
+			out = append(out, h.Name())
+		}
+	}
+	return out
+}
+
 // globalPipeline holds the active Pipeline. Reads on the hot path are
 // atomic and lock-free; writes happen only once, from Setup.
 var (
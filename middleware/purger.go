@@ -1,6 +1,10 @@
 package middleware
 
-import "github.com/miekg/dns"
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
 
 // Purger is implemented by handlers that maintain cacheable state
 // which can be invalidated by question. The cache middleware and the
@@ -14,4 +18,36 @@ import "github.com/miekg/dns"
 // through.
 type Purger interface {
 	Purge(q dns.Question)
+	// PurgeMatching removes every piece of state the scope covers and
+	// returns how many entries went.
+	PurgeMatching(scope PurgeScope) int
+}
+
+// PurgeScope selects what a PurgeMatching call removes: one name or a
+// whole subtree, one type or all of them.
+type PurgeScope struct {
+	// Name is the fully qualified owner name.
+	Name string
+	// Qtype is the type to purge; zero purges every type.
+	Qtype uint16
+	// Qclass is the class to purge; zero purges every class.
+	Qclass uint16
+	// Subtree extends the scope to every name below Name.
+	Subtree bool
+}
+
+// Covers reports whether name falls within the scope, whatever its
+// type. Names compare case-insensitively.
+func (s PurgeScope) Covers(name string) bool {
+	if s.Subtree {
+		return dns.IsSubDomain(s.Name, name)
+	}
+	return strings.EqualFold(dns.Fqdn(s.Name), dns.Fqdn(name))
+}
+
+// Match reports whether q falls within the scope.
+func (s PurgeScope) Match(q dns.Question) bool {
+	return (s.Qtype == 0 || s.Qtype == q.Qtype) &&
+		(s.Qclass == 0 || s.Qclass == q.Qclass) &&
+		s.Covers(q.Name)
 }
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// QueryNotes is what the handlers behind an observer report about how a
+// client query was answered: facts the response itself does not carry,
+// such as whether it came from the cache or which upstream supplied it.
+//
+// Nothing is recorded unless an observer asked for it with
+// TrackQueryNotes before calling Next. The Note helpers are a ledger
+// lookup and a nil check otherwise, so the handlers that report can call
+// them unconditionally on the hot path.
+type QueryNotes struct {
+	// req is the client's request. Cache hits and blocks are only
+	// recorded for it: internal sub-queries share the request tree but
+	// run their own chains, and a cached NS address is not how the client
+	// was answered.
+	req *Request
+
+	// qname and qtype are the client's question. An upstream is only
+	// recorded against it: the resolver asks many questions to answer
+	// one, and the NS address and DS lookups on the way are not what an
+	// operator means by the upstream used.
+	qname string
+	qtype uint16
+
+	cacheHit atomic.Bool
+	blocked  atomic.Pointer[string]
+	upstream atomic.Pointer[string]
+}
+
+// TrackQueryNotes starts recording notes for the request tree ctx belongs
+// to, for the client request req with question q, and returns the notes to read once the
+// chain has run. It returns nil when ctx carries no request tree.
+//
+// A later call replaces the notes rather than joining them: the worker
+// pass after an inline handoff serves the same query again, and it is the
+// pass that writes.
+func TrackQueryNotes(ctx context.Context, req *Request, q dns.Question) *QueryNotes {
+	meta := ResponseMetaFrom(ctx)
+	if meta == nil {
+		return nil
+	}
+	n := &QueryNotes{req: req, qname: q.Name, qtype: q.Qtype}
+	meta.ensureLedgerHost().notes.Store(n)
+	return n
+}
+
+// JoinQueryNotes returns the notes an outer observer is already keeping
+// for req, or starts them as TrackQueryNotes does when none is. It is for
+// an observer that runs inside another: tracking afresh would replace the
+// outer observer's notes, and the handlers behind write to one set.
+func JoinQueryNotes(ctx context.Context, req *Request, q dns.Question) *QueryNotes {
+	if n := queryNotesFrom(ctx); n != nil && n.req == req {
+		return n
+	}
+	return TrackQueryNotes(ctx, req, q)
+}
+
+func queryNotesFrom(ctx context.Context) *QueryNotes {
+	host := ResponseMetaFrom(ctx).ledgerHost()
+	if host == nil {
+		return nil
+	}
+	return host.notes.Load()
+}
+
+// NoteCacheHit records that req was served from the cache.
+func NoteCacheHit(ctx context.Context, req *Request) {
+	if n := queryNotesFrom(ctx); n != nil && n.req == req {
+		n.cacheHit.Store(true)
+	}
+}
+
+// NoteBlocked records that req was refused by policy, and by what.
+func NoteBlocked(ctx context.Context, req *Request, by string) {
+	if n := queryNotesFrom(ctx); n != nil && n.req == req {
+		n.blocked.Store(&by)
+	}
+}
+
+// NoteUpstream records addr as the server that answered q. It is ignored
+// unless q is the client's own question; the last answer recorded wins,
+// which for an iterative lookup is the authority that ended the walk.
+func NoteUpstream(ctx context.Context, q dns.Question, addr string) {
+	n := queryNotesFrom(ctx)
+	if n == nil || q.Qtype != n.qtype || !strings.EqualFold(q.Name, n.qname) {
+		return
+	}
+	n.upstream.Store(&addr)
+}
+
+// CacheHit reports whether the response was served from the cache.
+func (n *QueryNotes) CacheHit() bool { return n != nil && n.cacheHit.Load() }
+
+// Blocked returns what refused the query, or "" if nothing did.
+func (n *QueryNotes) Blocked() string {
+	if n == nil {
+		return ""
+	}
+	if by := n.blocked.Load(); by != nil {
+		return *by
+	}
+	return ""
+}
+
+// Upstream returns the server that answered the client's question, or ""
+// when the answer did not come from the network.
+func (n *QueryNotes) Upstream() string {
+	if n == nil {
+		return ""
+	}
+	if addr := n.upstream.Load(); addr != nil {
+		return *addr
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryNotes(t *testing.T) {
+	q := dns.Question{Name: "www.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	// Untracked, the notes go nowhere and cost nothing.
+	req, sub := new(Request), new(Request)
+	meta := new(ResponseMeta)
+	ctx := WithResponseMeta(context.Background(), meta)
+	NoteCacheHit(ctx, req)
+	NoteUpstream(ctx, q, "192.0.2.1:53")
+	if meta.ledgerHost() != nil {
+		t.Fatal("an untracked note established request-tree state")
+	}
+	if TrackQueryNotes(context.Background(), req, q) != nil {
+		t.Fatal("notes tracked without a request tree")
+	}
+
+	notes := TrackQueryNotes(ctx, req, q)
+
+	// A sub-query's fork shares the tree, so its notes reach the client's.
+	// A sub-query's own hits and blocks are not the client's.
+	fork, _ := WithForkedCut(ctx)
+	NoteUpstream(fork, dns.Question{Name: "ns.example.", Qtype: dns.TypeA}, "192.0.2.2:53")
+	NoteUpstream(fork, dns.Question{Name: "WWW.Example.", Qtype: dns.TypeA}, "192.0.2.3:53")
+	NoteUpstream(fork, dns.Question{Name: "www.example.", Qtype: dns.TypeAAAA}, "192.0.2.4:53")
+	NoteCacheHit(fork, sub)
+	NoteBlocked(fork, sub, "blocklist")
+	if notes.CacheHit() || notes.Blocked() != "" {
+		t.Fatal("a sub-query's cache hit or block was noted for the client")
+	}
+	NoteBlocked(fork, req, "blocklist")
+	NoteCacheHit(fork, req)
+
+	if got := notes.Upstream(); got != "192.0.2.3:53" {
+		t.Fatalf("upstream = %q, want the server that answered the client's question", got)
+	}
+	if !notes.CacheHit() || notes.Blocked() != "blocklist" {
+		t.Fatalf("cache hit %v, blocked %q", notes.CacheHit(), notes.Blocked())
+	}
+
+	// An inner observer shares the notes rather than replacing them.
+	if JoinQueryNotes(ctx, req, q) != notes {
+		t.Fatal("joining the client's request started new notes")
+	}
+	if JoinQueryNotes(ctx, sub, q) == notes {
+		t.Fatal("another request joined the client's notes")
+	}
+
+	// Reset hands the meta to the next request, which starts clean.
+	meta.Reset()
+	NoteCacheHit(ctx, req)
+	var none *QueryNotes
+	if none.CacheHit() || none.Blocked() != "" || none.Upstream() != "" {
+		t.Fatal("nil notes reported something")
+	}
+}
@@ -0,0 +1,275 @@
+package querylog
+
+import (
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Entry is one query in the history, as the API returns it. ID increases
+// with every entry and is the pagination cursor.
+type Entry struct {
+	ID         uint64     `json:"id"`
+	Time       time.Time  `json:"time"`
+	ClientIP   netip.Addr `json:"client_ip"`
+	ClientPort uint16     `json:"client_port"`
+	ClientID   string     `json:"client_id,omitempty"`
+	Transport  string     `json:"transport"`
+	Qname      string     `json:"qname"`
+	Qtype      string     `json:"qtype"`
+	Rcode      string     `json:"rcode"`
+	EDE        *uint16    `json:"ede,omitempty"`
+	EDEText    string     `json:"ede_text,omitempty"`
+	Answer     []string   `json:"answer"`
+	LatencyMS  float64    `json:"latency_ms"`
+	CacheHit   bool       `json:"cache_hit"`
+	Blocked    string     `json:"blocked,omitempty"`
+	Upstream   string     `json:"upstream,omitempty"`
+	DNSSEC     string     `json:"dnssec"`
+}
+
+func newEntry(id uint64, r *record) Entry {
+	e := Entry{
+		ID:         id,
+		Time:       r.time,
+		ClientIP:   r.client.Addr(),
+		ClientPort: r.client.Port(),
+		ClientID:   r.clientID,
+		Transport:  r.transport,
+		Qname:      r.qname,
+		Qtype:      dns.Type(r.qtype).String(),
+		Rcode:      dns.RcodeToString[r.rcode],
+		Answer:     r.answer,
+		LatencyMS:  float64(r.latency) / float64(time.Millisecond),
+		CacheHit:   r.cacheHit,
+		Blocked:    r.blocked,
+		Upstream:   r.upstream,
+		DNSSEC:     r.dnssec,
+	}
+	if r.ede != nil {
+		code := r.ede.InfoCode
+		e.EDE, e.EDEText = &code, r.ede.ExtraText
+	}
+	if e.Answer == nil {
+		e.Answer = []string{}
+	}
+	return e
+}
+
+// Filter selects history entries. The zero Filter matches everything.
+type Filter struct {
+	// Client matches client addresses inside the prefix.
+	Client netip.Prefix
+	// ClientID matches the client certificate identity exactly.
+	ClientID string
+	// Qname matches the name and every name below it.
+	Qname string
+	// Rcode matches the response code by name, as Entry.Rcode has it.
+	Rcode string
+	// Blocked, when set, matches blocked queries if true and answered
+	// ones if false.
+	Blocked *bool
+	// Since matches queries received at or after it.
+	Since time.Time
+}
+
+// Match reports whether e passes the filter.
+func (f *Filter) Match(e *Entry) bool {
+	if f.Client.IsValid() && !f.Client.Contains(e.ClientIP) {
+		return false
+	}
+	if f.ClientID != "" && f.ClientID != e.ClientID {
+		return false
+	}
+	if f.Qname != "" && !inZone(e.Qname, f.Qname) {
+		return false
+	}
+	if f.Rcode != "" && !strings.EqualFold(f.Rcode, e.Rcode) {
+		return false
+	}
+	if f.Blocked != nil && *f.Blocked != (e.Blocked != "") {
+		return false
+	}
+	return f.Since.IsZero() || !e.Time.Before(f.Since)
+}
+
+// inZone reports whether name is zone or below it, ignoring case.
+func inZone(name, zone string) bool {
+	name, zone = dns.Fqdn(name), dns.Fqdn(zone)
+	if zone == "." {
+		return true
+	}
+	if len(name) < len(zone) || !strings.EqualFold(name[len(name)-len(zone):], zone) {
+		return false
+	}
+	return len(name) == len(zone) || name[len(name)-len(zone)-1] == '.'
+}
+
+// subscriberBuffer is how many entries a live subscriber may fall behind
+// by before it starts missing them.
+const subscriberBuffer = 256
+
+// MaxPage caps how many entries one search returns.
+const MaxPage = 1000
+
+// History is the bounded record of recent queries: a ring in memory,
+// optionally spilled to segments on disk as entries leave it, and a feed
+// of new entries for live subscribers.
+type History struct {
+	mu     sync.RWMutex
+	ring   []Entry
+	next   int
+	full   bool
+	nextID uint64
+
+	spill *segmentStore
+
+	subMu sync.Mutex
+	subs  map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	filter Filter
+	ch     chan Entry
+}
+
+// newHistory returns a history of size entries, spilling to dir when it is
+// not empty. The IDs carry on from the newest spilled entry, so cursors
+// stay valid across a restart.
+func newHistory(size int, dir string, segments int) (*History, error) {
+	h := &History{
+		ring:   make([]Entry, size),
+		nextID: 1,
+		subs:   make(map[*subscriber]struct{}),
+	}
+	if dir != "" {
+		s, err := openSegmentStore(dir, size, segments)
+		if err != nil {
+			return nil, err
+		}
+		h.spill = s
+		h.nextID = s.lastID + 1
+	}
+	return h, nil
+}
+
+// add appends r as the newest entry and hands it to the subscribers it
+// matches. It is called only from the log's writer goroutine.
+func (h *History) add(r *record) {
+	h.mu.Lock()
+	e := newEntry(h.nextID, r)
+	h.nextID++
+	if h.full && h.spill != nil {
+		h.spill.append(&h.ring[h.next])
+	}
+	h.ring[h.next] = e
+	h.next++
+	if h.next == len(h.ring) {
+		h.next, h.full = 0, true
+	}
+	h.mu.Unlock()
+
+	h.subMu.Lock()
+	for s := range h.subs {
+		if s.filter.Match(&e) {
+			select {
+			case s.ch <- e:
+			default:
+			}
+		}
+	}
+	h.subMu.Unlock()
+}
+
+// Search returns up to limit entries matching f, newest first, with IDs
+// below before (0 for the newest). next is the cursor for the following
+// page, 0 when there is none.
+func (h *History) Search(f Filter, before uint64, limit int) (entries []Entry, next uint64) {
+	if limit <= 0 || limit > MaxPage {
+		limit = MaxPage
+	}
+	// One more than the page, to tell whether another page follows.
+	want := limit + 1
+
+	h.mu.RLock()
+	n := h.next
+	if h.full {
+		n = len(h.ring)
+	}
+	var oldest uint64
+	for i := range n {
+		e := &h.ring[(h.next-1-i+len(h.ring))%len(h.ring)]
+		oldest = e.ID
+		if before != 0 && e.ID >= before {
+			continue
+		}
+		if f.Match(e) {
+			entries = append(entries, *e)
+			if len(entries) == want {
+				break
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(entries) < want && h.spill != nil {
+		below := before
+		if n > 0 && (below == 0 || oldest < below) {
+			below = oldest
+		}
+		entries = h.spill.search(f, below, want-len(entries), entries)
+	}
+
+	if len(entries) == want {
+		entries = entries[:limit]
+		next = entries[limit-1].ID
+	}
+	return entries, next
+}
+
+// Subscribe returns a channel of new entries matching f and the function
+// that ends the subscription. A subscriber that falls more than
+// subscriberBuffer entries behind misses the ones in between.
+func (h *History) Subscribe(f Filter) (<-chan Entry, func()) {
+	s := &subscriber{filter: f, ch: make(chan Entry, subscriberBuffer)}
+	h.subMu.Lock()
+	h.subs[s] = struct{}{}
+	h.subMu.Unlock()
+
+	var once sync.Once
+	return s.ch, func() {
+		once.Do(func() {
+			h.subMu.Lock()
+			delete(h.subs, s)
+			h.subMu.Unlock()
+		})
+	}
+}
+
+// flush writes buffered spilled entries out.
+func (h *History) flush() {
+	if h.spill != nil {
+		h.spill.flush()
+	}
+}
+
+// close spills what is still in memory, so a restart finds it on disk,
+// and closes the segment store.
+func (h *History) close() {
+	if h.spill == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	start, n := 0, h.next
+	if h.full {
+		start, n = h.next, len(h.ring)
+	}
+	for i := range n {
+		h.spill.append(&h.ring[(start+i)%len(h.ring)])
+	}
+	h.spill.close()
+}
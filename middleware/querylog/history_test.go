@@ -0,0 +1,160 @@
+package querylog
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func historyRecord(i int, client string, qname string, rcode int) *record {
+	return &record{
+		time:      time.Date(2026, 10, 18, 12, 0, i, 0, time.UTC),
+		client:    netip.MustParseAddrPort(client),
+		transport: "udp",
+		qname:     qname,
+		qtype:     dns.TypeA,
+		rcode:     rcode,
+		latency:   1500 * time.Microsecond,
+		dnssec:    dnssecInsecure,
+	}
+}
+
+func ids(entries []Entry) []uint64 {
+	out := make([]uint64, len(entries))
+	for i, e := range entries {
+		out[i] = e.ID
+	}
+	return out
+}
+
+func TestHistorySearch(t *testing.T) {
+	h, err := newHistory(4, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 6 {
+		r := historyRecord(i, "192.0.2.1:5353", "www.example.com.", dns.RcodeSuccess)
+		if i%2 == 1 {
+			r.client = netip.MustParseAddrPort("198.51.100.9:5353")
+			r.qname = "ads.Tracker.example."
+			r.rcode = dns.RcodeNameError
+			r.blocked = "blocklist"
+		}
+		h.add(r)
+	}
+
+	// Only the newest four are kept, newest first.
+	all, next := h.Search(Filter{}, 0, 10)
+	if got := ids(all); len(got) != 4 || got[0] != 6 || got[3] != 3 || next != 0 {
+		t.Fatalf("ids %v next %d, want 6..3 and no next page", got, next)
+	}
+	if e := all[0]; e.Qtype != "A" || e.Rcode != "NXDOMAIN" || e.LatencyMS != 1.5 || e.Answer == nil {
+		t.Fatalf("entry %+v", e)
+	}
+
+	page, next := h.Search(Filter{}, 0, 3)
+	if len(page) != 3 || next != 4 {
+		t.Fatalf("first page %v next %d, want three and a cursor at 4", ids(page), next)
+	}
+	page, next = h.Search(Filter{}, next, 3)
+	if got := ids(page); len(got) != 1 || got[0] != 3 || next != 0 {
+		t.Fatalf("second page %v next %d, want 3 alone", got, next)
+	}
+
+	blocked := true
+	for _, tc := range []struct {
+		name string
+		f    Filter
+		want int
+	}{
+		{"client address", Filter{Client: netip.MustParsePrefix("192.0.2.1/32")}, 2},
+		{"client prefix", Filter{Client: netip.MustParsePrefix("198.51.100.0/24")}, 2},
+		{"qname and below", Filter{Qname: "tracker.example."}, 2},
+		{"qname is not a suffix match", Filter{Qname: "racker.example."}, 0},
+		{"rcode", Filter{Rcode: "nxdomain"}, 2},
+		{"blocked", Filter{Blocked: &blocked}, 2},
+		{"since", Filter{Since: time.Date(2026, 10, 18, 12, 0, 4, 0, time.UTC)}, 2},
+	} {
+		if got, _ := h.Search(tc.f, 0, 10); len(got) != tc.want {
+			t.Errorf("%s: %d entries, want %d", tc.name, len(got), tc.want)
+		}
+	}
+}
+
+func TestHistorySpill(t *testing.T) {
+	dir := t.TempDir()
+	h, err := newHistory(2, dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 7 {
+		h.add(historyRecord(i, "192.0.2.1:5353", "www.example.com.", dns.RcodeSuccess))
+	}
+
+	// 6 and 7 in memory, 3 to 5 spilled; 1 and 2 were pruned with the
+	// oldest segment.
+	got, next := h.Search(Filter{}, 0, 10)
+	if ids := ids(got); len(ids) != 5 || ids[0] != 7 || ids[4] != 3 || next != 0 {
+		t.Fatalf("ids %v next %d, want 7..3", ids, next)
+	}
+	got, next = h.Search(Filter{}, 6, 2)
+	if ids := ids(got); len(ids) != 2 || ids[0] != 5 || ids[1] != 4 || next != 4 {
+		t.Fatalf("page below 6: %v next %d, want 5, 4 and a cursor", ids, next)
+	}
+
+	// Closing spills memory, and a restart carries the IDs on.
+	h.close()
+	h, err = newHistory(2, dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.add(historyRecord(8, "192.0.2.1:5353", "www.example.com.", dns.RcodeSuccess))
+	got, _ = h.Search(Filter{}, 0, 3)
+	if ids := ids(got); len(ids) != 3 || ids[0] != 8 || ids[1] != 7 || ids[2] != 6 {
+		t.Fatalf("after restart %v, want 8, 7, 6", ids)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, segmentPrefix+"*"))
+	if len(segments) != 2 {
+		t.Fatalf("segments %v, want the newest two", segments)
+	}
+	// A torn last line, as a crash leaves it, is skipped.
+	f, err := os.OpenFile(segments[1], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"id":99,"qna`)
+	_ = f.Close()
+	if _, err := newHistory(2, dir, 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistorySubscribe(t *testing.T) {
+	h, _ := newHistory(8, "", 0)
+	entries, cancel := h.Subscribe(Filter{Qname: "example.org."})
+
+	h.add(historyRecord(0, "192.0.2.1:5353", "www.example.com.", dns.RcodeSuccess))
+	h.add(historyRecord(1, "192.0.2.1:5353", "www.example.org.", dns.RcodeSuccess))
+	select {
+	case e := <-entries:
+		if e.Qname != "www.example.org." || e.ID != 2 {
+			t.Fatalf("streamed %+v, want the example.org query", e)
+		}
+	default:
+		t.Fatal("a matching entry was not streamed")
+	}
+
+	cancel()
+	cancel()
+	h.add(historyRecord(2, "192.0.2.1:5353", "www.example.org.", dns.RcodeSuccess))
+	select {
+	case e := <-entries:
+		t.Fatalf("streamed %+v after cancel", e)
+	default:
+	}
+}
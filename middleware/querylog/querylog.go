@@ -0,0 +1,525 @@
+// Package querylog writes a structured record of every client query — or
+// a sample of them — as JSON or logfmt, to rotated files, syslog or
+// stdout, and keeps a searchable history of the recent ones.
+package querylog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"hash/maphash"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/metric"
+	"github.com/semihalev/sdns/middleware"
+	"github.com/semihalev/zlog/v2"
+)
+
+var (
+	recordsDropped = metric.NewCounter(nil, prometheus.CounterOpts{
+		Name: "dns_querylog_dropped_total",
+		Help: "Query log records dropped because the writer fell behind",
+	})
+
+	writeErrors = metric.NewCounterVec(nil, prometheus.CounterOpts{
+		Name: "dns_querylog_write_errors_total",
+		Help: "Query log records an output failed to write, by output",
+	}, []string{"output"})
+)
+
+// queueSize is how many records may wait for the writer. A full queue
+// drops the record and counts it: the log must never slow an answer.
+const queueSize = 4096
+
+// flushInterval bounds how long a buffered record waits to reach its file
+// while the queue is never quite empty.
+const flushInterval = time.Second
+
+// output is one configured destination for records.
+type output interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+type sink struct {
+	failures
+	out     output
+	newline bool
+}
+
+// QueryLog is the structured query log middleware.
+type QueryLog struct {
+	enc        encoder
+	wantAnswer bool
+	sinks      []*sink
+	history    *History
+
+	// rate is the sampled fraction of queries, 1 for all of them.
+	// byClient samples client addresses instead of queries.
+	rate     float64
+	byClient bool
+	seed     maphash.Seed
+
+	queue     chan record
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a new query log middleware. A disabled log, or one with
+// neither an output that opened nor a history, passes every query straight
+// through.
+func New(cfg *config.Config) *QueryLog {
+	q := &QueryLog{}
+	c := cfg.QueryLog
+	if !c.Enabled {
+		return q
+	}
+
+	fields, err := parseFields(c.Fields)
+	if err != nil {
+		zlog.Error("Query log fields invalid, logging all fields", "error", err.Error())
+		fields, _ = parseFields(nil)
+	}
+	switch strings.ToLower(c.Format) {
+	case "", "json":
+		q.enc.json = true
+	case "logfmt":
+	default:
+		zlog.Error("Query log format unknown, using json", "format", c.Format)
+		q.enc.json = true
+	}
+	q.enc.fields = fields
+	for _, f := range fields {
+		q.wantAnswer = q.wantAnswer || f == fieldAnswer
+	}
+
+	outputs := c.Outputs
+	if len(outputs) == 0 {
+		outputs = []string{"file"}
+	}
+	for _, name := range outputs {
+		s, err := openSink(strings.ToLower(name), &c)
+		if err != nil {
+			zlog.Error("Query log output failed to open", "output", name, "error", err.Error())
+			continue
+		}
+		q.sinks = append(q.sinks, s)
+	}
+	if c.History > 0 {
+		h, err := newHistory(c.History, c.HistoryDir, c.HistorySegments)
+		if err != nil {
+			zlog.Error("Query history spill failed to open, keeping it in memory", "dir", c.HistoryDir, "error", err.Error())
+			h, _ = newHistory(c.History, "", 0)
+		}
+		q.history = h
+		// The history is what a helpdesk reads to see what a client
+		// resolved; it carries the answer whatever the outputs write.
+		q.wantAnswer = true
+	}
+	if len(q.sinks) == 0 && q.history == nil {
+		return q
+	}
+
+	q.rate = 1
+	if c.SampleRate > 0 && c.SampleRate < 100 {
+		q.rate = c.SampleRate / 100
+	}
+	switch strings.ToLower(c.SampleBy) {
+	case "", "query":
+	case "client":
+		q.byClient = true
+		q.seed = maphash.MakeSeed()
+	default:
+		zlog.Error("Query log sample_by unknown, sampling per query", "sample_by", c.SampleBy)
+	}
+
+	q.queue = make(chan record, queueSize)
+	q.done = make(chan struct{})
+	q.stopped = make(chan struct{})
+	go q.run()
+
+	return q
+}
+
+func openSink(name string, c *config.QueryLogConfig) (*sink, error) {
+	switch name {
+	case "file":
+		if c.File == "" {
+			return nil, errors.New("no file configured")
+		}
+		f, err := openRotatingFile(c.File, int64(c.MaxSize)<<20, c.RotateEvery.Duration, c.MaxBackups, c.Compress)
+		if err != nil {
+			return nil, err
+		}
+		return &sink{failures: failures{name: name}, out: f, newline: true}, nil
+	case "stdout":
+		return &sink{failures: failures{name: name}, out: stdoutOutput{bufio.NewWriter(os.Stdout)}, newline: true}, nil
+	case "syslog":
+		s, err := newSyslogWriter(strings.ToLower(c.SyslogNetwork), c.SyslogAddress, strings.ToLower(c.SyslogFacility))
+		if err != nil {
+			return nil, err
+		}
+		return &sink{failures: failures{name: name}, out: s}, nil
+	}
+	return nil, errors.New("unknown output")
+}
+
+// stdoutOutput buffers records for stdout, which is not closed with the
+// log.
+type stdoutOutput struct{ *bufio.Writer }
+
+func (s stdoutOutput) Close() error { return s.Flush() }
+
+// (*QueryLog).History returns the query history, or nil when it is
+// disabled.
+func (q *QueryLog) History() *History { return q.history }
+
+// (*QueryLog).Name returns the middleware name.
+func (q *QueryLog) Name() string { return name }
+
+// (*QueryLog).ClientOnly marks the query log as a client-traffic observer;
+// middleware.Setup excludes it from internal sub-pipelines so it records
+// the queries clients asked, not the resolver's own.
+func (q *QueryLog) ClientOnly() bool { return true }
+
+// (*QueryLog).ServeDNS records the query once the chain has answered it.
+// A query left out by sampling costs one random draw or hash and passes
+// on untouched. A sampled one is tracked: the writer is wrapped to see the
+// response before the edns layer reshapes it for the client — AD in
+// particular, which a client that did not ask for DNSSEC never sees — and
+// the handlers behind note what the response cannot say. A wire-born
+// request stays on the byte path; only the sampled response is decoded,
+// and only for the answer summary.
+func (q *QueryLog) ServeDNS(ctx context.Context, ch *middleware.Chain) {
+	w := ch.Writer
+	if q.queue == nil || w.Internal() || !q.sampled(w.RemoteIP(), ch.ClientIdentity()) {
+		ch.Next(ctx)
+		return
+	}
+	question, ok := clientQuestion(ch.Request)
+	if !ok {
+		ch.Next(ctx)
+		return
+	}
+
+	start := ch.Request.ReadTime()
+	if start.IsZero() {
+		start = time.Now()
+	}
+
+	notes := middleware.TrackQueryNotes(ctx, ch.Request, question)
+	rw := &responseWriter{ResponseWriter: w, wantAnswer: q.wantAnswer}
+	ch.Writer = rw
+	defer func() { ch.Writer = w }()
+
+	ch.Next(ctx)
+
+	// An inline pass that handed off has written nothing; the replay
+	// that answers logs the query.
+	if !rw.captured {
+		return
+	}
+
+	r := record{
+		time:      start,
+		client:    clientAddr(w),
+		clientID:  ch.ClientIdentity(),
+		transport: w.Proto(),
+		qname:     question.Name,
+		qtype:     question.Qtype,
+		rcode:     rw.rcode,
+		ede:       rw.ede,
+		answer:    rw.answer,
+		latency:   rw.at.Sub(start),
+		cacheHit:  notes.CacheHit(),
+		blocked:   notes.Blocked(),
+		upstream:  notes.Upstream(),
+		dnssec:    dnssecStatus(rw.ad, ch.Request.CD(), rw.rcode, rw.ede),
+	}
+	select {
+	case q.queue <- r:
+	default:
+		recordsDropped.Inc()
+	}
+}
+
+func (q *QueryLog) sampled(ip net.IP, id string) bool {
+	if q.rate >= 1 {
+		return true
+	}
+	if q.byClient && id != "" {
+		// A device with a certificate is the same client from every
+		// network it roams to.
+		return float64(maphash.String(q.seed, id)>>11)/(1<<53) < q.rate
+	}
+	if q.byClient {
+		// The same client over IPv4 arrives as 4 or 16 bytes depending on
+		// the transport; it must hash the same either way.
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
+		return float64(maphash.Bytes(q.seed, ip)>>11)/(1<<53) < q.rate
+	}
+	return rand.Float64() < q.rate //nolint:gosec // G404 - sampling, not security
+}
+
+// clientQuestion reads the question without decoding a wire-born request.
+func clientQuestion(req *middleware.Request) (dns.Question, bool) {
+	if req.Undecoded() && req.Raw() != nil {
+		name, _, err := dns.UnpackDomainName(req.WireName(), 0)
+		if err != nil {
+			return dns.Question{}, false
+		}
+		return dns.Question{Name: name, Qtype: req.Qtype(), Qclass: req.Qclass()}, true
+	}
+	msg := req.Msg()
+	if msg == nil || len(msg.Question) == 0 {
+		return dns.Question{}, false
+	}
+	return msg.Question[0], true
+}
+
+// clientAddr copies the client's address out of the writer. An owned
+// transport keeps it in job storage that the next packet rewrites, and the
+// record outlives this request.
+func clientAddr(w middleware.ResponseWriter) netip.AddrPort {
+	var ap netip.AddrPort
+	switch a := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		ap = a.AddrPort()
+	case *net.TCPAddr:
+		ap = a.AddrPort()
+	default:
+		ip, _ := netip.AddrFromSlice(w.RemoteIP())
+		ap = netip.AddrPortFrom(ip, 0)
+		if a != nil {
+			if parsed, err := netip.ParseAddrPort(a.String()); err == nil {
+				ap = parsed
+			}
+		}
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+func (q *QueryLog) run() {
+	defer close(q.stopped)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var buf []byte
+	for {
+		select {
+		case r := <-q.queue:
+			buf = q.write(buf, &r)
+			if len(q.queue) == 0 {
+				q.flush()
+			}
+		case <-ticker.C:
+			q.flush()
+		case <-q.done:
+			for {
+				select {
+				case r := <-q.queue:
+					buf = q.write(buf, &r)
+				default:
+					for _, s := range q.sinks {
+						if err := s.out.Close(); err != nil {
+							zlog.Error("Query log output close failed", "output", s.name, "error", err.Error())
+						}
+					}
+					if q.history != nil {
+						q.history.close()
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (q *QueryLog) write(buf []byte, r *record) []byte {
+	if q.history != nil {
+		q.history.add(r)
+	}
+	if len(q.sinks) == 0 {
+		return buf
+	}
+	buf = q.enc.appendRecord(buf[:0], r)
+	buf = append(buf, '\n')
+	for _, s := range q.sinks {
+		line := buf
+		if !s.newline {
+			line = buf[:len(buf)-1]
+		}
+		_, err := s.out.Write(line)
+		s.observe(err)
+	}
+	return buf
+}
+
+func (q *QueryLog) flush() {
+	for _, s := range q.sinks {
+		s.observe(s.out.Flush())
+	}
+	if q.history != nil {
+		q.history.flush()
+	}
+}
+
+// failures tracks an output's run of failed writes.
+type failures struct {
+	name    string
+	failing bool
+}
+
+// observe counts a failed write and logs the first of a run of them, so an
+// output that goes away is reported once rather than once per query.
+func (f *failures) observe(err error) {
+	switch {
+	case err != nil:
+		writeErrors.WithLabelValues(f.name).Inc()
+		if !f.failing {
+			f.failing = true
+			zlog.Error("Query log write failed", "output", f.name, "error", err.Error())
+		}
+	case f.failing:
+		f.failing = false
+		zlog.Info("Query log output recovered", "output", f.name)
+	}
+}
+
+// (*QueryLog).Close writes out the records still queued, closes the
+// outputs and spills the in-memory history.
+func (q *QueryLog) Close() error {
+	if q.queue == nil {
+		return nil
+	}
+	q.closeOnce.Do(func() {
+		close(q.done)
+		<-q.stopped
+	})
+	return nil
+}
+
+// responseWriter captures the facts of the response as the chain writes
+// it, beneath the edns layer's reshaping.
+type responseWriter struct {
+	middleware.ResponseWriter
+	wantAnswer bool
+
+	captured bool
+	at       time.Time
+	rcode    int
+	ad       bool
+	ede      *dns.EDNS0_EDE
+	answer   []string
+}
+
+// Size forwards the response's wire length from the writer beneath. The
+// embedded interface deliberately does not carry Size, so a wrapper has to
+// pass it along or observers above fall back to decoding the response.
+func (rw *responseWriter) Size() int {
+	return middleware.ResponseSize(rw.ResponseWriter)
+}
+
+// WriteMsg reads the response before forwarding it: the edns layer beneath
+// clears AD and rebuilds the OPT record on the message in place.
+func (rw *responseWriter) WriteMsg(res *dns.Msg) error {
+	if res == nil || rw.ResponseWriter.Written() {
+		return rw.ResponseWriter.WriteMsg(res)
+	}
+	rcode, ad := res.Rcode, res.AuthenticatedData
+	var ede *dns.EDNS0_EDE
+	if e := findEDE(res); e != nil {
+		c := *e
+		ede = &c
+	}
+	var answer []string
+	if rw.wantAnswer {
+		answer = summarizeAnswer(res.Answer)
+	}
+
+	err := rw.ResponseWriter.WriteMsg(res)
+	if rw.ResponseWriter.Written() {
+		rw.captured = true
+		rw.at = time.Now()
+		rw.rcode, rw.ad, rw.ede, rw.answer = rcode, ad, ede, answer
+	}
+	return err
+}
+
+// WireReady passes the byte path through: this layer only observes
+// responses, so its presence must not push a cache hit back onto the Msg
+// path. BeginWire/CommitWire/AbortWire pass the body lease through; the
+// commit flows through WriteWire so the record still sees the response.
+func (rw *responseWriter) BeginWire(size, reserve int) []byte {
+	if leaser, ok := rw.ResponseWriter.(middleware.WireBodyLeaser); ok {
+		return leaser.BeginWire(size, reserve)
+	}
+	return nil
+}
+
+func (rw *responseWriter) CommitWire(body []byte, info middleware.WireInfo) error {
+	return rw.WriteWire(body, info)
+}
+
+func (rw *responseWriter) AbortWire() {
+	if leaser, ok := rw.ResponseWriter.(middleware.WireBodyLeaser); ok {
+		leaser.AbortWire()
+	}
+}
+
+func (rw *responseWriter) WireReady() (middleware.WireCapability, bool) {
+	next, ok := rw.ResponseWriter.(middleware.WireWriter)
+	if !ok {
+		return middleware.WireCapability{}, false
+	}
+	return next.WireReady()
+}
+
+// WriteWire reads the response facts from info and forwards the bytes.
+// The answer summary is decoded before the downstream write, because the
+// body is borrowed and the layers beneath shape it in place; it is kept
+// only if the chain did not decline, since on ErrWireFallback the cache
+// retakes the Msg path and WriteMsg records that serve instead.
+func (rw *responseWriter) WriteWire(body []byte, info middleware.WireInfo) error {
+	next, ok := rw.ResponseWriter.(middleware.WireWriter)
+	if !ok {
+		return middleware.ErrWireFallback
+	}
+	var answer []string
+	if rw.wantAnswer {
+		msg := new(dns.Msg)
+		if msg.Unpack(body) == nil {
+			answer = summarizeAnswer(msg.Answer)
+		}
+	}
+	err := next.WriteWire(body, info)
+	if !errors.Is(err, middleware.ErrWireFallback) {
+		rw.captured = true
+		rw.at = time.Now()
+		rw.rcode = info.Rcode
+		rw.ad = info.AuthenticatedData
+		rw.ede = nil
+		if info.HasEDE {
+			rw.ede = &dns.EDNS0_EDE{InfoCode: info.EDECode, ExtraText: info.EDEText}
+		}
+		rw.answer = answer
+	}
+	return err
+}
+
+const name = "querylog"
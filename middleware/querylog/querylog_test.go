@@ -0,0 +1,287 @@
+package querylog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"hash/maphash"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/mock"
+	"github.com/semihalev/sdns/middleware"
+)
+
+// answerer stands in for the handlers behind the log: it notes what a
+// cache, resolver or blocklist would and answers with resp.
+type answerer struct {
+	resp    func(req *dns.Msg) *dns.Msg
+	noteFor func(ctx context.Context, ch *middleware.Chain, q dns.Question)
+}
+
+func (a *answerer) Name() string { return "answerer" }
+
+func (a *answerer) ServeDNS(ctx context.Context, ch *middleware.Chain) {
+	req := ch.Request.Msg()
+	if a.noteFor != nil {
+		a.noteFor(ctx, ch, req.Question[0])
+	}
+	_ = ch.Writer.WriteMsg(a.resp(req))
+	ch.Cancel()
+}
+
+func newTestLog(t *testing.T, qc config.QueryLogConfig) (*QueryLog, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "query.log")
+	qc.Enabled = true
+	qc.File = path
+	q := New(&config.Config{QueryLog: qc})
+	if q.queue == nil {
+		t.Fatal("query log did not start")
+	}
+	t.Cleanup(func() { _ = q.Close() })
+	return q, path
+}
+
+func serve(t *testing.T, q *QueryLog, a *answerer, proto, client string, req *dns.Msg) {
+	t.Helper()
+	ch := middleware.NewChain([]middleware.Handler{q, a})
+	ch.Reset(mock.NewWriter(proto, client), req)
+	ch.Next(context.Background())
+}
+
+func readLines(t *testing.T, q *QueryLog, path string) []string {
+	t.Helper()
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines
+}
+
+func TestQueryLogJSONRecord(t *testing.T) {
+	q, path := newTestLog(t, config.QueryLogConfig{})
+
+	a := &answerer{
+		resp: func(req *dns.Msg) *dns.Msg {
+			m := new(dns.Msg)
+			m.SetReply(req)
+			m.AuthenticatedData = true
+			m.Answer = []dns.RR{
+				&dns.CNAME{Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60}, Target: "host.example.org."},
+				&dns.A{Hdr: dns.RR_Header{Name: "host.example.org.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IPv4(192, 0, 2, 1)},
+				&dns.RRSIG{Hdr: dns.RR_Header{Name: "host.example.org.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 60}},
+			}
+			return m
+		},
+		noteFor: func(ctx context.Context, ch *middleware.Chain, q dns.Question) {
+			middleware.NoteUpstream(ctx, dns.Question{Name: "ns1.example.org.", Qtype: dns.TypeA}, "192.0.2.200:53")
+			middleware.NoteUpstream(ctx, q, "192.0.2.53:53")
+			middleware.NoteCacheHit(ctx, ch.Request)
+		},
+	}
+	req := new(dns.Msg)
+	req.SetQuestion("WWW.example.org.", dns.TypeA)
+	serve(t, q, a, "udp", "198.51.100.7:5353", req)
+
+	lines := readLines(t, q, path)
+	if len(lines) != 1 {
+		t.Fatalf("lines = %q, want one record", lines)
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatalf("record %q is not JSON: %v", lines[0], err)
+	}
+
+	want := map[string]any{
+		"client_ip":   "198.51.100.7",
+		"client_port": 5353.0,
+		"transport":   "udp",
+		"qname":       "WWW.example.org.",
+		"qtype":       "A",
+		"rcode":       "NOERROR",
+		"cache_hit":   true,
+		"upstream":    "192.0.2.53:53",
+		"dnssec":      "secure",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+	answer, _ := got["answer"].([]any)
+	if len(answer) != 2 || answer[0] != "CNAME host.example.org." || answer[1] != "A 192.0.2.1" {
+		t.Errorf("answer = %v, want the CNAME and the A without the signature", got["answer"])
+	}
+	for _, k := range []string{"time", "latency_ms"} {
+		if _, ok := got[k]; !ok {
+			t.Errorf("record has no %s", k)
+		}
+	}
+	for _, k := range []string{"ede", "blocked"} {
+		if _, ok := got[k]; ok {
+			t.Errorf("record carries %s for a query that had none", k)
+		}
+	}
+}
+
+func TestQueryLogLogfmtFields(t *testing.T) {
+	q, path := newTestLog(t, config.QueryLogConfig{
+		Format: "logfmt",
+		Fields: []string{"qname", "rcode", "ede", "blocked", "dnssec"},
+	})
+
+	a := &answerer{
+		resp: func(req *dns.Msg) *dns.Msg {
+			m := new(dns.Msg)
+			m.SetRcode(req, dns.RcodeServerFailure)
+			m.SetEdns0(1232, true)
+			m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_EDE{
+				InfoCode:  dns.ExtendedErrorCodeSignatureExpired,
+				ExtraText: "expired at 2026-10-01",
+			})
+			return m
+		},
+		noteFor: func(ctx context.Context, ch *middleware.Chain, _ dns.Question) {
+			middleware.NoteBlocked(ctx, ch.Request, "blocklist")
+		},
+	}
+	req := new(dns.Msg)
+	req.SetQuestion("bogus.example.", dns.TypeAAAA)
+	serve(t, q, a, "tcp", "[2001:db8::1]:40000", req)
+
+	lines := readLines(t, q, path)
+	want := `qname=bogus.example. rcode=SERVFAIL ede=7 ede_text="expired at 2026-10-01" blocked=blocklist dnssec=bogus`
+	if len(lines) != 1 || lines[0] != want {
+		t.Fatalf("lines = %q, want [%q]", lines, want)
+	}
+}
+
+func TestQueryLogSkipsUnwrittenAndInternal(t *testing.T) {
+	q, path := newTestLog(t, config.QueryLogConfig{})
+	req := new(dns.Msg)
+	req.SetQuestion("example.", dns.TypeA)
+
+	// A pass that writes nothing, like an inline pass handing off.
+	ch := middleware.NewChain([]middleware.Handler{q, middleware.HandlerFunc(func(context.Context, *middleware.Chain) {})})
+	ch.Reset(mock.NewWriter("udp", "192.0.2.1:53"), req)
+	ch.Next(context.Background())
+
+	// The resolver's own query, on the internal sentinel address.
+	internal := &answerer{resp: func(req *dns.Msg) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		return m
+	}}
+	serve(t, q, internal, "udp", "127.0.0.255:0", req)
+
+	if lines := readLines(t, q, path); len(lines) != 0 {
+		t.Fatalf("lines = %q, want nothing logged", lines)
+	}
+}
+
+func TestQueryLogSampling(t *testing.T) {
+	q := &QueryLog{rate: 0.25, byClient: true, seed: maphash.MakeSeed()}
+
+	logged := 0
+	for i := range 4000 {
+		ip := net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))
+		first := q.sampled(ip, "")
+		if q.sampled(ip.To16(), "") != first || q.sampled(ip.To4(), "") != first {
+			t.Fatalf("client %s sampled inconsistently", ip)
+		}
+		if first {
+			logged++
+		}
+	}
+	if logged < 800 || logged > 1200 {
+		t.Fatalf("%d of 4000 clients sampled at 25 percent", logged)
+	}
+
+	q.byClient = false
+	logged = 0
+	for range 4000 {
+		if q.sampled(nil, "") {
+			logged++
+		}
+	}
+	if logged < 800 || logged > 1200 {
+		t.Fatalf("%d of 4000 queries sampled at 25 percent", logged)
+	}
+
+	if !(&QueryLog{rate: 1}).sampled(nil, "") {
+		t.Fatal("a full rate dropped a query")
+	}
+}
+
+func TestQueryLogInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	q := New(&config.Config{QueryLog: config.QueryLogConfig{
+		Enabled: true,
+		Outputs: []string{"file", "carrier-pigeon"},
+		File:    filepath.Join(dir, "q.log"),
+		Fields:  []string{"qname", "nonsense"},
+		Format:  "xml",
+	}})
+	defer q.Close()
+
+	if len(q.sinks) != 1 || q.sinks[0].name != "file" {
+		t.Fatalf("sinks = %+v, want only the file", q.sinks)
+	}
+	if len(q.enc.fields) != int(numFields) || !q.enc.json {
+		t.Fatal("invalid fields and format did not fall back to every field as JSON")
+	}
+
+	if off := New(&config.Config{QueryLog: config.QueryLogConfig{Enabled: true, Outputs: []string{"file"}}}); off.queue != nil {
+		t.Fatal("a log with no usable output started")
+	}
+}
+
+func TestQueryLogHistory(t *testing.T) {
+	q, _ := newTestLog(t, config.QueryLogConfig{Fields: []string{"qname"}, History: 16})
+	h := q.History()
+	if h == nil {
+		t.Fatal("history not enabled")
+	}
+
+	a := &answerer{
+		resp: func(req *dns.Msg) *dns.Msg {
+			m := new(dns.Msg)
+			m.SetReply(req)
+			m.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IPv4(192, 0, 2, 1)}}
+			return m
+		},
+		noteFor: func(ctx context.Context, ch *middleware.Chain, _ dns.Question) {
+			middleware.NoteCacheHit(ctx, ch.Request)
+		},
+	}
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.org.", dns.TypeA)
+	serve(t, q, a, "udp", "192.0.2.7:5353", req)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The history keeps the answer though the outputs write only qname.
+	got, _ := h.Search(Filter{Client: netip.MustParsePrefix("192.0.2.0/24")}, 0, 10)
+	if len(got) != 1 {
+		t.Fatalf("history %+v, want the one query", got)
+	}
+	if e := got[0]; e.Qname != "www.example.org." || !e.CacheHit || len(e.Answer) != 1 || e.Answer[0] != "A 192.0.2.1" {
+		t.Fatalf("entry %+v", e)
+	}
+}
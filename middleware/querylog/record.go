@@ -0,0 +1,357 @@
+package querylog
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/miekg/dns"
+)
+
+// field is one column of a query log record.
+type field uint8
+
+const (
+	fieldTime field = iota
+	fieldClientIP
+	fieldClientPort
+	fieldClientID
+	fieldTransport
+	fieldQname
+	fieldQtype
+	fieldRcode
+	fieldEDE
+	fieldAnswer
+	fieldLatency
+	fieldCacheHit
+	fieldBlocked
+	fieldUpstream
+	fieldDNSSEC
+	numFields
+)
+
+// fieldNames are the names the fields option takes, and the order a
+// record is written in when it names none.
+var fieldNames = [numFields]string{
+	"time",
+	"client_ip",
+	"client_port",
+	"client_id",
+	"transport",
+	"qname",
+	"qtype",
+	"rcode",
+	"ede",
+	"answer",
+	"latency",
+	"cache_hit",
+	"blocked",
+	"upstream",
+	"dnssec",
+}
+
+// maxAnswerSummary caps the answer field. A summary is for reading, and a
+// response with hundreds of records would otherwise make one line of the
+// log larger than the rest of it.
+const maxAnswerSummary = 16
+
+// DNSSEC statuses, as the dnssec field writes them.
+const (
+	dnssecSecure    = "secure"
+	dnssecInsecure  = "insecure"
+	dnssecBogus     = "bogus"
+	dnssecUnchecked = "unchecked"
+)
+
+// parseFields resolves the configured field names. An empty list selects
+// every field; an unknown name is an error rather than a silently missing
+// column.
+func parseFields(names []string) ([]field, error) {
+	if len(names) == 0 {
+		all := make([]field, numFields)
+		for i := range all {
+			all[i] = field(i)
+		}
+		return all, nil
+	}
+
+	out := make([]field, 0, len(names))
+	for _, name := range names {
+		i := 0
+		for i < int(numFields) && fieldNames[i] != strings.ToLower(strings.TrimSpace(name)) {
+			i++
+		}
+		if i == int(numFields) {
+			return nil, fmt.Errorf("unknown query log field %q", name)
+		}
+		out = append(out, field(i))
+	}
+	return out, nil
+}
+
+// record is one logged query. It owns everything it holds: it is built on
+// the serving goroutine and written out on the log's own.
+type record struct {
+	time      time.Time
+	client    netip.AddrPort
+	clientID  string
+	transport string
+	qname     string
+	qtype     uint16
+	rcode     int
+	ede       *dns.EDNS0_EDE
+	answer    []string
+	latency   time.Duration
+	cacheHit  bool
+	blocked   string
+	upstream  string
+	dnssec    string
+}
+
+// dnssecStatus classifies a response the way a validating resolver's
+// client sees it. AD is the resolver's claim the answer validated; a
+// SERVFAIL carrying one of the RFC 8914 DNSSEC codes (6 to 12) is a
+// validation failure; CD asked for no validation at all.
+func dnssecStatus(ad, cd bool, rcode int, ede *dns.EDNS0_EDE) string {
+	switch {
+	case ad:
+		return dnssecSecure
+	case rcode == dns.RcodeServerFailure && ede != nil &&
+		ede.InfoCode >= dns.ExtendedErrorCodeDNSBogus &&
+		ede.InfoCode <= dns.ExtendedErrorCodeNSECMissing:
+		return dnssecBogus
+	case cd:
+		return dnssecUnchecked
+	default:
+		return dnssecInsecure
+	}
+}
+
+// summarizeAnswer renders an answer section as "TYPE rdata" strings,
+// leaving out signatures, at most maxAnswerSummary of them.
+func summarizeAnswer(rrs []dns.RR) []string {
+	var out []string
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		if len(out) == maxAnswerSummary {
+			break
+		}
+		rdata := strings.TrimPrefix(rr.String(), hdr.String())
+		out = append(out, dns.TypeToString[hdr.Rrtype]+" "+rdata)
+	}
+	return out
+}
+
+// findEDE returns the first Extended DNS Error in msg's OPT record.
+func findEDE(msg *dns.Msg) *dns.EDNS0_EDE {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if ede, ok := o.(*dns.EDNS0_EDE); ok {
+			return ede
+		}
+	}
+	return nil
+}
+
+// encoder appends one record to a line in its format. The zero value
+// writes logfmt.
+type encoder struct {
+	json   bool
+	fields []field
+}
+
+// appendRecord appends r to dst as one line, without the newline.
+func (e encoder) appendRecord(dst []byte, r *record) []byte {
+	l := line{buf: dst, json: e.json}
+	l.begin()
+	for _, f := range e.fields {
+		switch f {
+		case fieldTime:
+			l.key("time")
+			l.quoted(r.time.Format(time.RFC3339Nano))
+		case fieldClientIP:
+			l.key("client_ip")
+			l.quoted(r.client.Addr().String())
+		case fieldClientPort:
+			l.key("client_port")
+			l.buf = strconv.AppendUint(l.buf, uint64(r.client.Port()), 10)
+		case fieldClientID:
+			if r.clientID == "" {
+				continue
+			}
+			l.key("client_id")
+			l.quoted(r.clientID)
+		case fieldTransport:
+			l.key("transport")
+			l.quoted(r.transport)
+		case fieldQname:
+			l.key("qname")
+			l.quoted(r.qname)
+		case fieldQtype:
+			l.key("qtype")
+			l.quoted(dns.Type(r.qtype).String())
+		case fieldRcode:
+			l.key("rcode")
+			l.quoted(dns.RcodeToString[r.rcode])
+		case fieldEDE:
+			if r.ede == nil {
+				continue
+			}
+			l.key("ede")
+			l.buf = strconv.AppendUint(l.buf, uint64(r.ede.InfoCode), 10)
+			if r.ede.ExtraText != "" {
+				l.key("ede_text")
+				l.quoted(r.ede.ExtraText)
+			}
+		case fieldAnswer:
+			l.key("answer")
+			l.list(r.answer)
+		case fieldLatency:
+			l.key("latency_ms")
+			l.buf = strconv.AppendFloat(l.buf, float64(r.latency)/float64(time.Millisecond), 'f', 3, 64)
+		case fieldCacheHit:
+			l.key("cache_hit")
+			l.buf = strconv.AppendBool(l.buf, r.cacheHit)
+		case fieldBlocked:
+			if r.blocked == "" {
+				continue
+			}
+			l.key("blocked")
+			l.quoted(r.blocked)
+		case fieldUpstream:
+			if r.upstream == "" {
+				continue
+			}
+			l.key("upstream")
+			l.quoted(r.upstream)
+		case fieldDNSSEC:
+			l.key("dnssec")
+			l.quoted(r.dnssec)
+		}
+	}
+	l.end()
+	return l.buf
+}
+
+// line is the format-specific half of the encoder.
+type line struct {
+	buf  []byte
+	json bool
+	n    int
+}
+
+func (l *line) begin() {
+	if l.json {
+		l.buf = append(l.buf, '{')
+	}
+}
+
+func (l *line) end() {
+	if l.json {
+		l.buf = append(l.buf, '}')
+	}
+}
+
+func (l *line) key(k string) {
+	if l.n > 0 {
+		if l.json {
+			l.buf = append(l.buf, ',')
+		} else {
+			l.buf = append(l.buf, ' ')
+		}
+	}
+	l.n++
+	if l.json {
+		l.buf = append(l.buf, '"')
+		l.buf = append(l.buf, k...)
+		l.buf = append(l.buf, '"', ':')
+		return
+	}
+	l.buf = append(l.buf, k...)
+	l.buf = append(l.buf, '=')
+}
+
+// quoted appends a string value: always quoted in JSON, and in logfmt only
+// when it would not otherwise read back as one value.
+func (l *line) quoted(s string) {
+	if l.json {
+		l.buf = appendJSONString(l.buf, s)
+		return
+	}
+	if needsLogfmtQuote(s) {
+		l.buf = strconv.AppendQuote(l.buf, s)
+		return
+	}
+	l.buf = append(l.buf, s...)
+}
+
+// list appends a string list: a JSON array, or in logfmt one value joined
+// by commas.
+func (l *line) list(items []string) {
+	if !l.json {
+		l.quoted(strings.Join(items, ","))
+		return
+	}
+	l.buf = append(l.buf, '[')
+	for i, s := range items {
+		if i > 0 {
+			l.buf = append(l.buf, ',')
+		}
+		l.buf = appendJSONString(l.buf, s)
+	}
+	l.buf = append(l.buf, ']')
+}
+
+func needsLogfmtQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c == '=' || c == '"' || c == '\\' || c >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s as a JSON string. Names and record data are
+// already in presentation format, but an EDE text is whatever bytes an
+// upstream sent, so invalid UTF-8 is replaced rather than copied through.
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				dst = append(dst, '\\', c)
+			case c < ' ':
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			default:
+				dst = append(dst, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, "\ufffd"...)
+		} else {
+			dst = append(dst, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(dst, '"')
+}
@@ -0,0 +1,105 @@
+package querylog
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDNSSECStatus(t *testing.T) {
+	expired := &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeSignatureExpired}
+	blocked := &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeBlocked}
+
+	for _, tc := range []struct {
+		name  string
+		ad    bool
+		cd    bool
+		rcode int
+		ede   *dns.EDNS0_EDE
+		want  string
+	}{
+		{"validated", true, false, dns.RcodeSuccess, nil, dnssecSecure},
+		{"validated with cd", true, true, dns.RcodeSuccess, nil, dnssecSecure},
+		{"validation failed", false, false, dns.RcodeServerFailure, expired, dnssecBogus},
+		{"servfail for another reason", false, false, dns.RcodeServerFailure, blocked, dnssecInsecure},
+		{"dnssec ede without servfail", false, false, dns.RcodeSuccess, expired, dnssecInsecure},
+		{"checking disabled", false, true, dns.RcodeSuccess, nil, dnssecUnchecked},
+		{"unsigned", false, false, dns.RcodeNameError, nil, dnssecInsecure},
+	} {
+		if got := dnssecStatus(tc.ad, tc.cd, tc.rcode, tc.ede); got != tc.want {
+			t.Errorf("%s: dnssecStatus = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	all, err := parseFields(nil)
+	if err != nil || len(all) != int(numFields) {
+		t.Fatalf("parseFields(nil) = %v, %v; want every field", all, err)
+	}
+	got, err := parseFields([]string{"Rcode", " qname "})
+	if err != nil || len(got) != 2 || got[0] != fieldRcode || got[1] != fieldQname {
+		t.Fatalf("parseFields = %v, %v; want rcode then qname", got, err)
+	}
+	if _, err := parseFields([]string{"qname", "colour"}); err == nil {
+		t.Fatal("an unknown field was accepted")
+	}
+}
+
+func TestEncoderEscaping(t *testing.T) {
+	r := &record{
+		qname: `we"ird\name.`,
+		ede:   &dns.EDNS0_EDE{InfoCode: 18, ExtraText: "tab\there \x01 bad\xff"},
+	}
+	fields := []field{fieldQname, fieldEDE, fieldAnswer}
+
+	js := encoder{json: true, fields: fields}.appendRecord(nil, r)
+	var got map[string]any
+	if err := json.Unmarshal(js, &got); err != nil {
+		t.Fatalf("%s is not JSON: %v", js, err)
+	}
+	if got["qname"] != r.qname || got["ede_text"] != "tab\there \x01 bad�" || got["ede"] != 18.0 {
+		t.Fatalf("decoded %v from %s", got, js)
+	}
+	if a, ok := got["answer"].([]any); !ok || len(a) != 0 {
+		t.Fatalf("an empty answer encoded as %v, want []", got["answer"])
+	}
+
+	lf := string(encoder{fields: fields}.appendRecord(nil, r))
+	want := `qname="we\"ird\\name." ede=18 ede_text="tab\there \x01 bad\xff" answer=""`
+	if lf != want {
+		t.Fatalf("logfmt = %s, want %s", lf, want)
+	}
+}
+
+func TestSummarizeAnswerCaps(t *testing.T) {
+	var rrs []dns.RR
+	for range maxAnswerSummary + 4 {
+		rr, err := dns.NewRR("example. 60 IN TXT \"a b\"")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	got := summarizeAnswer(rrs)
+	if len(got) != maxAnswerSummary || got[0] != `TXT "a b"` {
+		t.Fatalf("summary = %q", got)
+	}
+}
+
+func TestEncoderClientID(t *testing.T) {
+	fields := []field{fieldClientID, fieldQname}
+	r := &record{qname: "example.com."}
+	if got := string(encoder{fields: fields}.appendRecord(nil, r)); got != "qname=example.com." {
+		t.Errorf("no identity: %s", got)
+	}
+	r.clientID = "spiffe://example.com/laptop/7"
+	if got := string(encoder{json: true, fields: fields}.appendRecord(nil, r)); got != `{"client_id":"spiffe://example.com/laptop/7","qname":"example.com."}` {
+		t.Errorf("identity: %s", got)
+	}
+	e := newEntry(1, r)
+	if e.ClientID != r.clientID || !(&Filter{ClientID: r.clientID}).Match(&e) || (&Filter{ClientID: "laptop-8"}).Match(&e) {
+		t.Errorf("entry %+v does not carry or filter on the identity", e)
+	}
+}
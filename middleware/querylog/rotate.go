@@ -0,0 +1,233 @@
+package querylog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/semihalev/zlog/v2"
+)
+
+// backupLayout names a rotated file: the live file's name, then the time
+// it was rotated out. Millisecond precision, because size rotation on a
+// busy server can turn a file over more than once a second, and a layout
+// that sorts as text, because pruning keeps the newest by name.
+const backupLayout = "2006-01-02T15-04-05.000"
+
+// rotatingFile is a buffered log file that rotates itself out when it
+// grows past maxSize or has been open for every, whichever comes first.
+// A rotated file is renamed beside the live one, gzipped if compress is
+// set, and only the newest maxBackups of them are kept.
+//
+// It is written from one goroutine; only the compress-and-prune work that
+// follows a rotation runs on another, so a slow gzip never stalls the log.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	every      time.Duration
+	maxBackups int
+	compress   bool
+	now        func() time.Time
+
+	f      *os.File
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+
+	// post serializes the background work after each rotation, so two
+	// quick rotations never prune around each other's half-written gzip.
+	post sync.Mutex
+	wg   sync.WaitGroup
+}
+
+func openRotatingFile(path string, maxSize int64, every time.Duration, maxBackups int, compress bool) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		every:      every,
+		maxBackups: maxBackups,
+		compress:   compress,
+		now:        time.Now,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600) //nolint:gosec // G304 - path from config, admin controlled
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	r.opened = r.now()
+	if r.w == nil {
+		r.w = bufio.NewWriterSize(f, 64<<10)
+	} else {
+		r.w.Reset(f)
+	}
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past its size
+// or the file has been open its full interval. A record larger than the
+// size limit still goes in whole, into a file of its own.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.due(len(p)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.w.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) due(next int) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.maxSize > 0 && r.size+int64(next) > r.maxSize {
+		return true
+	}
+	return r.every > 0 && r.now().Sub(r.opened) >= r.every
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	backup := r.path + "." + r.now().Format(backupLayout)
+	if err := os.Rename(r.path, backup); err != nil {
+		// Keep logging into the file we have rather than losing records
+		// over a rename the next rotation may well manage.
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	r.wg.Go(func() {
+		r.post.Lock()
+		defer r.post.Unlock()
+		if r.compress {
+			if err := gzipFile(backup); err != nil {
+				zlog.Error("Query log backup compression failed", "file", backup, "error", err.Error())
+			}
+		}
+		r.prune()
+	})
+	return nil
+}
+
+// Flush writes out what is buffered.
+func (r *rotatingFile) Flush() error { return r.w.Flush() }
+
+// Close flushes and closes the live file, after any compression a
+// rotation left running has finished.
+func (r *rotatingFile) Close() error {
+	err := r.w.Flush()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	r.wg.Wait()
+	return err
+}
+
+// prune removes all but the newest maxBackups rotated files.
+func (r *rotatingFile) prune() {
+	if r.maxBackups <= 0 {
+		return
+	}
+	backups := r.backups()
+	if len(backups) <= r.maxBackups {
+		return
+	}
+	for _, name := range backups[:len(backups)-r.maxBackups] {
+		if err := os.Remove(name); err != nil {
+			zlog.Error("Query log backup removal failed", "file", name, "error", err.Error())
+		}
+	}
+}
+
+// backups lists the rotated files, oldest first. Only names that carry a
+// rotation time count, so nothing else that happens to share the log's
+// prefix is ever removed.
+func (r *rotatingFile) backups() []string {
+	dir, base := filepath.Split(r.path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), base+".")
+		if !ok || e.IsDir() {
+			continue
+		}
+		stamp = strings.TrimSuffix(stamp, ".gz")
+		if _, err := time.Parse(backupLayout, stamp); err != nil {
+			continue
+		}
+		out = append(out, filepath.Join(dir, e.Name()))
+	}
+	slices.Sort(out)
+	return out
+}
+
+// gzipFile replaces name with name.gz.
+func gzipFile(name string) (err error) {
+	in, err := os.Open(name) //nolint:gosec // G304 - a backup this log rotated out
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600) //nolint:gosec // G304 - as above
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = out.Close()
+			_ = os.Remove(name + ".gz")
+		}
+	}()
+
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
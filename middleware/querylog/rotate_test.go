@@ -0,0 +1,106 @@
+package querylog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "query.log")
+	if err := os.WriteFile(filepath.Join(dir, "query.log.keep"), []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openRotatingFile(path, 100, 0, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	line := strings.Repeat("q", 59) + "\n"
+	for range 5 {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	live, err := os.ReadFile(path)
+	if err != nil || string(live) != line {
+		t.Fatalf("live file = %q, %v; want the last record alone", live, err)
+	}
+
+	backups := r.backups()
+	if len(backups) != 2 {
+		t.Fatalf("backups = %v, want the two newest of four", backups)
+	}
+	for _, name := range backups {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Fatalf("backup %s was not compressed", name)
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(zr)
+		_ = f.Close()
+		if err != nil || string(body) != line {
+			t.Fatalf("%s holds %q, %v", name, body, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "query.log.keep")); err != nil {
+		t.Fatal("pruning removed a file that was not a backup")
+	}
+}
+
+func TestRotatingFileByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "query.log")
+	r, err := openRotatingFile(path, 0, time.Hour, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	r.opened = now
+
+	write := func(s string) {
+		t.Helper()
+		if _, err := r.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("one\n")
+	now = now.Add(59 * time.Minute)
+	write("two\n")
+	now = now.Add(time.Minute)
+	write("three\n")
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups := r.backups()
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".2026-10-18T13-00-00.000") {
+		t.Fatalf("backups = %v, want one rotated at 13:00", backups)
+	}
+	old, _ := os.ReadFile(backups[0])
+	live, _ := os.ReadFile(path)
+	if string(old) != "one\ntwo\n" || string(live) != "three\n" {
+		t.Fatalf("rotated %q, live %q", old, live)
+	}
+}
@@ -0,0 +1,239 @@
+package querylog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// segmentPrefix and segmentSuffix frame a segment's name around the ID of
+// its first entry, zero-padded so the names sort in ID order.
+const (
+	segmentPrefix = "history-"
+	segmentSuffix = ".jsonl"
+)
+
+// segment is one spilled file of history entries, oldest first.
+type segment struct {
+	path  string
+	first uint64
+}
+
+// segmentStore keeps the history that left memory as JSON lines, in
+// segments of perSegment entries, the newest maxSegments of them. It is
+// appended to from the log's writer goroutine and searched from API
+// requests.
+type segmentStore struct {
+	dir         string
+	perSegment  int
+	maxSegments int
+
+	mu       sync.Mutex
+	segments []segment
+	f        *os.File
+	w        *bufio.Writer
+	count    int
+	size     int64
+	lastID   uint64
+	buf      []byte
+	failures failures
+}
+
+func openSegmentStore(dir string, perSegment, maxSegments int) (*segmentStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	if maxSegments <= 0 {
+		maxSegments = 1
+	}
+	s := &segmentStore{
+		dir:         dir,
+		perSegment:  perSegment,
+		maxSegments: maxSegments,
+		failures:    failures{name: "history"},
+	}
+
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, ent := range ents {
+		name := ent.Name()
+		if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, segment{path: filepath.Join(dir, name), first: first})
+	}
+	slices.SortFunc(s.segments, func(a, b segment) int {
+		switch {
+		case a.first < b.first:
+			return -1
+		case a.first > b.first:
+			return 1
+		}
+		return 0
+	})
+
+	// IDs continue from the newest entry on disk. A segment that ends in a
+	// torn line still names its first ID.
+	if n := len(s.segments); n > 0 {
+		s.lastID = s.segments[n-1].first
+		s.scan(s.segments[n-1], -1, func(e *Entry) {
+			s.lastID = max(s.lastID, e.ID)
+		})
+	}
+	return s, nil
+}
+
+// append writes e to the current segment, starting a new one when it is
+// full. A failure is counted and logged once per run, like an output's.
+func (s *segmentStore) append(e *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil || s.count >= s.perSegment {
+		if err := s.rotate(e.ID); err != nil {
+			s.failures.observe(err)
+			return
+		}
+	}
+	var err error
+	s.buf, err = json.Marshal(e)
+	if err == nil {
+		s.buf = append(s.buf, '\n')
+		var n int
+		n, err = s.w.Write(s.buf)
+		s.size += int64(n)
+	}
+	s.count++
+	s.lastID = e.ID
+	s.failures.observe(err)
+}
+
+func (s *segmentStore) rotate(first uint64) error {
+	s.closeCurrent()
+	path := filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", segmentPrefix, first, segmentSuffix))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600) //nolint:gosec // G304 - dir from config, admin controlled
+	if err != nil {
+		return err
+	}
+	s.f, s.count, s.size = f, 0, 0
+	if s.w == nil {
+		s.w = bufio.NewWriterSize(f, 64<<10)
+	} else {
+		s.w.Reset(f)
+	}
+	s.segments = append(s.segments, segment{path: path, first: first})
+	for len(s.segments) > s.maxSegments {
+		if err := os.Remove(s.segments[0].path); err != nil && !os.IsNotExist(err) {
+			s.failures.observe(err)
+		}
+		s.segments = s.segments[1:]
+	}
+	return nil
+}
+
+func (s *segmentStore) closeCurrent() {
+	if s.f == nil {
+		return
+	}
+	s.failures.observe(s.w.Flush())
+	s.failures.observe(s.f.Close())
+	s.f = nil
+}
+
+func (s *segmentStore) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f != nil {
+		s.failures.observe(s.w.Flush())
+	}
+}
+
+func (s *segmentStore) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeCurrent()
+}
+
+// search appends to out up to want entries matching f with IDs below
+// below (0 for any), newest first. The file being written is read only as
+// far as it had been flushed when the search began, so a line the writer
+// is halfway through is never read.
+func (s *segmentStore) search(f Filter, below uint64, want int, out []Entry) []Entry {
+	s.mu.Lock()
+	if s.f != nil {
+		s.failures.observe(s.w.Flush())
+	}
+	segments := slices.Clone(s.segments)
+	current := int64(-1)
+	if s.f != nil {
+		current = s.size
+	}
+	s.mu.Unlock()
+
+	found := 0
+	for i := len(segments) - 1; i >= 0 && found < want; i-- {
+		seg := segments[i]
+		if below != 0 && seg.first >= below {
+			continue
+		}
+		limit := int64(-1)
+		if i == len(segments)-1 {
+			limit = current
+		}
+		var matched []Entry
+		s.scan(seg, limit, func(e *Entry) {
+			if (below == 0 || e.ID < below) && f.Match(e) {
+				matched = append(matched, *e)
+			}
+		})
+		for j := len(matched) - 1; j >= 0 && found < want; j-- {
+			out = append(out, matched[j])
+			found++
+		}
+	}
+	return out
+}
+
+// scan calls fn with each entry of seg, oldest first, reading at most
+// limit bytes when limit is not negative. A line that does not decode is
+// skipped: a crash can leave the last one torn.
+func (s *segmentStore) scan(seg segment, limit int64, fn func(*Entry)) {
+	f, err := os.Open(seg.path) //nolint:gosec // G304 - path under the configured dir
+	if err != nil {
+		// Pruned since the search began.
+		return
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if limit >= 0 {
+		r = io.LimitReader(f, limit)
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e Entry
+		if json.Unmarshal(line, &e) != nil {
+			continue
+		}
+		fn(&e)
+	}
+}
@@ -0,0 +1,168 @@
+package querylog
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// syslogFacilities are the RFC 5424 §6.2.1 facility codes by name.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3,
+	"auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// severityInfo is the severity every query record is sent at.
+const severityInfo = 6
+
+// localSyslogSockets are where a local syslog daemon listens, in the order
+// they are tried when no address is configured.
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogWriter sends each record as one RFC 5424 message. Datagram
+// transports carry a message per packet; TCP uses RFC 6587 octet counting,
+// and a unix stream socket newline framing, which is what local daemons
+// accept there.
+type syslogWriter struct {
+	network  string
+	address  string
+	priority int
+	hostname string
+	pid      string
+
+	conn   net.Conn
+	stream bool
+	buf    []byte
+}
+
+func newSyslogWriter(network, address, facility string) (*syslogWriter, error) {
+	if facility == "" {
+		facility = "local0"
+	}
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	switch network {
+	case "", "unix", "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("unsupported syslog network %q", network)
+	}
+	if (network == "udp" || network == "tcp") && address == "" {
+		return nil, fmt.Errorf("syslog over %s needs an address", network)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	s := &syslogWriter{
+		network:  network,
+		address:  address,
+		priority: code*8 + severityInfo,
+		hostname: hostname,
+		pid:      strconv.Itoa(os.Getpid()),
+	}
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *syslogWriter) dial() error {
+	switch s.network {
+	case "udp", "tcp":
+		conn, err := net.DialTimeout(s.network, s.address, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn, s.stream = conn, s.network == "tcp"
+		return nil
+	}
+
+	// Local: a datagram socket first, as syslog daemons normally bind
+	// one, then a stream socket at the same path.
+	paths := localSyslogSockets
+	if s.address != "" {
+		paths = []string{s.address}
+	}
+	var errs []error
+	for _, path := range paths {
+		for _, network := range []string{"unixgram", "unix"} {
+			conn, err := net.Dial(network, path)
+			if err == nil {
+				s.conn, s.stream = conn, network == "unix"
+				return nil
+			}
+			errs = append(errs, err)
+		}
+	}
+	return fmt.Errorf("no local syslog socket: %w", errors.Join(errs...))
+}
+
+// Write sends msg as one syslog message, redialing once if the connection
+// has gone away.
+func (s *syslogWriter) Write(msg []byte) (int, error) {
+	s.buf = s.format(s.buf[:0], time.Now(), msg)
+	if s.conn == nil {
+		if err := s.dial(); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := s.conn.Write(s.buf); err != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+		if err := s.dial(); err != nil {
+			return 0, err
+		}
+		if _, err := s.conn.Write(s.buf); err != nil {
+			return 0, err
+		}
+	}
+	return len(msg), nil
+}
+
+// format appends the framed RFC 5424 message for msg to dst:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (s *syslogWriter) format(dst []byte, now time.Time, msg []byte) []byte {
+	start := len(dst)
+	dst = append(dst, '<')
+	dst = strconv.AppendInt(dst, int64(s.priority), 10)
+	dst = append(dst, ">1 "...)
+	dst = now.AppendFormat(dst, "2006-01-02T15:04:05.000000Z07:00")
+	dst = append(dst, ' ')
+	dst = append(dst, s.hostname...)
+	dst = append(dst, " sdns "...)
+	dst = append(dst, s.pid...)
+	dst = append(dst, " query - "...)
+	dst = append(dst, msg...)
+
+	switch {
+	case s.stream && s.network == "tcp":
+		frame := strconv.Itoa(len(dst)-start) + " "
+		dst = append(dst, frame...)
+		copy(dst[start+len(frame):], dst[start:len(dst)-len(frame)])
+		copy(dst[start:], frame)
+	case s.stream:
+		dst = append(dst, '\n')
+	}
+	return dst
+}
+
+// Flush is a no-op: every record is sent as it is written.
+func (s *syslogWriter) Flush() error { return nil }
+
+// Close closes the connection.
+func (s *syslogWriter) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,102 @@
+package querylog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc5424 matches a query record's header: PRI and version, a timestamp,
+// the host, sdns as the app, a pid, and "query" as the message ID with no
+// structured data.
+var rfc5424 = regexp.MustCompile(`^<134>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}(Z|[+-]\d\d:\d\d) \S+ sdns \d+ query - `)
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	s, err := newSyslogWriter("udp", pc.LocalAddr().String(), "local0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.Write([]byte(`{"qname":"example."}`)); err != nil {
+		t.Fatal(err)
+	}
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	if !rfc5424.MatchString(got) || !strings.HasSuffix(got, ` - {"qname":"example."}`) {
+		t.Fatalf("datagram %q is not an RFC 5424 query record", got)
+	}
+}
+
+func TestSyslogTCPOctetCounting(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	s, err := newSyslogWriter("tcp", ln.Addr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, msg := range []string{"first record", "second"} {
+		if _, err := s.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	br := bufio.NewReader(conn)
+	for _, want := range []string{"first record", "second"} {
+		length, err := br.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil {
+			t.Fatalf("frame length %q: %v", length, err)
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(br, frame); err != nil {
+			t.Fatal(err)
+		}
+		if !rfc5424.Match(frame) || !strings.HasSuffix(string(frame), " - "+want) {
+			t.Fatalf("frame %q, want an RFC 5424 record of %q", frame, want)
+		}
+	}
+}
+
+func TestSyslogConfigErrors(t *testing.T) {
+	for _, tc := range []struct{ network, address, facility string }{
+		{"udp", "127.0.0.1:514", "local9"},
+		{"sctp", "127.0.0.1:514", "local0"},
+		{"tcp", "", "local0"},
+	} {
+		if _, err := newSyslogWriter(tc.network, tc.address, tc.facility); err == nil {
+			t.Errorf("newSyslogWriter(%q, %q, %q) accepted", tc.network, tc.address, tc.facility)
+		}
+	}
+}
@@ -37,6 +37,18 @@ type RateLimit struct {
 
 	store *LimiterStore
 	rate  int
+
+	// listeners holds the limit of each [[listeners]] entry, by
+	// ListenerPolicy.Index.
+	listeners []listenerLimit
+}
+
+// listenerLimit is a [[listeners]] entry's client rate limit. An entry
+// that does not override the global limit has override false; one that
+// turns limiting off has a nil store.
+type listenerLimit struct {
+	override bool
+	store    *LimiterStore
 }
 
 // New return accesslist.
@@ -45,6 +57,16 @@ func New(cfg *config.Config) *RateLimit {
 		store:        NewLimiterStore(cacheSize, cfg.ClientRateLimit),
 		cookiesecret: cfg.CookieSecret,
 		rate:         cfg.ClientRateLimit,
+		listeners:    make([]listenerLimit, len(cfg.Listeners)),
+	}
+
+	for i, l := range cfg.Listeners {
+		switch {
+		case l.RateLimit > 0:
+			r.listeners[i] = listenerLimit{override: true, store: NewLimiterStore(cacheSize, l.RateLimit)}
+		case l.RateLimit < 0:
+			r.listeners[i] = listenerLimit{override: true}
+		}
 	}
 
 	// Periodic cleanup of old limiters (every 5 minutes)
@@ -53,6 +75,11 @@ func New(cfg *config.Config) *RateLimit {
 		defer ticker.Stop()
 		for range ticker.C {
 			r.store.Cleanup(10 * time.Minute)
+			for _, l := range r.listeners {
+				if l.store != nil {
+					l.store.Cleanup(10 * time.Minute)
+				}
+			}
 		}
 	}()
 
@@ -87,7 +114,8 @@ func (r *RateLimit) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 		return
 	}
 
-	if r.rate == 0 {
+	store := r.storeFor(ch)
+	if store == nil {
 		ch.Next(ctx)
 		return
 	}
@@ -104,7 +132,7 @@ func (r *RateLimit) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 	// limiter runs without decoding; only the BADCOOKIE reply needs the
 	// message. Everything else takes the decoded body below.
 	if ch.Request.Undecoded() {
-		r.serveWire(ctx, ch)
+		r.serveWire(ctx, ch, store)
 		return
 	}
 
@@ -116,7 +144,7 @@ func (r *RateLimit) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 
 	var cachedcookie, clientcookie, servercookie string
 
-	l := r.getLimiter(w.RemoteIP())
+	l := clientLimiter(store, ch)
 	cachedcookie = l.cookie.Load().(string)
 
 	if opt := req.IsEdns0(); opt != nil {
@@ -172,10 +200,10 @@ func (r *RateLimit) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 // continues down the chain undecoded. The one branch that must write a
 // cookie back to the client, UDP BADCOOKIE, materializes; it is the
 // stale-cookie retry path, not the steady state.
-func (r *RateLimit) serveWire(ctx context.Context, ch *middleware.Chain) {
+func (r *RateLimit) serveWire(ctx context.Context, ch *middleware.Chain, store *LimiterStore) {
 	w := ch.Writer
 
-	l := r.getLimiter(w.RemoteIP())
+	l := clientLimiter(store, ch)
 	cachedcookie := l.cookie.Load().(string)
 
 	if echo := ch.Request.CookieEcho(); echo != nil {
@@ -260,12 +288,65 @@ func (r *RateLimit) serveWire(ctx context.Context, ch *middleware.Chain) {
 	ch.Next(ctx)
 }
 
+// (*RateLimit).AdmitClient implements middleware.ClientGate: it charges
+// the client one token, as a query would be.
+func (r *RateLimit) AdmitClient(ip net.IP, identity string, policy *middleware.ListenerPolicy) bool {
+	store := r.storeForPolicy(policy)
+	if store == nil || ip == nil || ip.IsLoopback() {
+		return true
+	}
+	if !limiterFor(store, ip, identity).rl.Allow() {
+		rateLimitExceeded.Inc()
+		return false
+	}
+	return true
+}
+
+// storeFor returns the limiters the query is charged to: those of the
+// listener it arrived on when that overrides the global limit, the
+// global ones otherwise. Nil means no limit applies.
+func (r *RateLimit) storeFor(ch *middleware.Chain) *LimiterStore {
+	return r.storeForPolicy(ch.ListenerPolicy())
+}
+
+func (r *RateLimit) storeForPolicy(p *middleware.ListenerPolicy) *LimiterStore {
+	if p != nil && p.Index < len(r.listeners) && r.listeners[p.Index].override {
+		return r.listeners[p.Index].store
+	}
+	if r.rate == 0 {
+		return nil
+	}
+	return r.store
+}
+
 func (r *RateLimit) getLimiter(remoteip net.IP) *limiter {
+	return limiterIn(r.store, remoteip)
+}
+
+// clientLimiter returns the limiter of the query's client: by the
+// identity of its certificate when it presented one, so devices behind
+// one NAT address each get their own, and by address otherwise.
+func clientLimiter(store *LimiterStore, ch *middleware.Chain) *limiter {
+	return limiterFor(store, ch.Writer.RemoteIP(), ch.ClientIdentity())
+}
+
+func limiterFor(store *LimiterStore, remoteip net.IP, id string) *limiter {
+	if id == "" {
+		return limiterIn(store, remoteip)
+	}
+	xxhash := xxhash.New()
+	// The tag keeps an identity from hashing like an address.
+	_, _ = xxhash.WriteString("id\x00")
+	_, _ = xxhash.WriteString(id)
+	return store.Get(xxhash.Sum64())
+}
+
+func limiterIn(store *LimiterStore, remoteip net.IP) *limiter {
 	xxhash := xxhash.New()
 	_, _ = xxhash.Write(remoteip)
 	key := xxhash.Sum64()
 
-	return r.store.Get(key)
+	return store.Get(key)
 }
 
 const (
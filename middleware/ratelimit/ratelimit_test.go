@@ -2,6 +2,7 @@ package ratelimit
 
 import (
 	"context"
+	"net"
 	"reflect"
 	"testing"
 
@@ -96,3 +97,102 @@ func Test_RateLimit(t *testing.T) {
 
 	r.ServeDNS(context.Background(), ch)
 }
+
+func Test_RateLimitListenerOverride(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.ClientRateLimit = 1
+	cfg.Listeners = []config.ListenerConfig{
+		{Name: "wireguard", Proto: "dns", Addr: "10.8.0.1:53", RateLimit: -1},
+		{Name: "public", Proto: "dns", Addr: "203.0.113.1:53", RateLimit: 2},
+		{Name: "lan", Proto: "dns", Addr: "192.168.1.1:53"},
+	}
+	r := New(cfg)
+	policies := middleware.NewListenerPolicies(cfg)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+
+	// answered counts the queries of 20 sent back to back by one client.
+	answered := func(client string, p *middleware.ListenerPolicy) int {
+		n := 0
+		for range 20 {
+			pass := false
+			ch := middleware.NewChain([]middleware.Handler{r, middleware.HandlerFunc(func(context.Context, *middleware.Chain) { pass = true })})
+			mw := mock.NewWriter("tcp", client)
+			mw.Policy = p
+			ch.Reset(mw, req)
+			ch.Next(context.Background())
+			if pass {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := answered("10.8.0.7:5353", policies[0]); n != 20 {
+		t.Errorf("unlimited listener answered %d of 20", n)
+	}
+	if n := answered("203.0.113.7:5353", policies[1]); n != 2 {
+		t.Errorf("listener limited to 2/s answered %d of 20", n)
+	}
+	if n := answered("192.168.1.7:5353", policies[2]); n != 1 {
+		t.Errorf("listener on the global limit answered %d of 20", n)
+	}
+}
+
+func Test_RateLimitClientIdentity(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.ClientRateLimit = 1
+	r := New(cfg)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+
+	answered := func(identity string) bool {
+		pass := false
+		ch := middleware.NewChain([]middleware.Handler{r, middleware.HandlerFunc(func(context.Context, *middleware.Chain) { pass = true })})
+		mw := mock.NewWriter("tcp", "203.0.113.9:5353")
+		mw.Identity = identity
+		ch.Reset(mw, req)
+		ch.Next(context.Background())
+		return pass
+	}
+
+	// Two laptops behind one NAT address have a limit each.
+	if !answered("laptop-1") || !answered("laptop-2") {
+		t.Fatal("second device behind the address limited by the first")
+	}
+	if answered("laptop-1") {
+		t.Error("device over its limit answered")
+	}
+	// The address itself is a client of its own.
+	if !answered("") {
+		t.Error("address limited by the identities behind it")
+	}
+}
+
+// A relayed request draws on the same limiter as the client's queries on
+// that listener.
+func Test_RateLimitAdmitClient(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.ClientRateLimit = 1
+	cfg.Listeners = []config.ListenerConfig{
+		{Name: "open", Proto: "doh", Addr: "10.8.0.1:443", RateLimit: -1},
+	}
+	r := New(cfg)
+	policies := middleware.NewListenerPolicies(cfg)
+
+	client := net.ParseIP("203.0.113.7")
+	if !r.AdmitClient(client, "", nil) {
+		t.Fatal("first request refused")
+	}
+	if r.AdmitClient(client, "", nil) {
+		t.Error("client over its limit admitted")
+	}
+	if !r.AdmitClient(client, "", policies[0]) {
+		t.Error("unlimited listener refused the client")
+	}
+	if !r.AdmitClient(net.ParseIP("127.0.0.1"), "", nil) || !r.AdmitClient(net.ParseIP("127.0.0.1"), "", nil) {
+		t.Error("loopback limited")
+	}
+}
@@ -0,0 +1,13 @@
+package middleware
+
+// Readier is implemented by handlers that have startup work to finish
+// before they answer the way they are configured to — root priming, a
+// first blocklist download, an informer sync. The api readiness probe
+// reports the server ready only once every Readier in the pipeline is.
+//
+// Ready is polled by the probe, so it must be cheap; a handler that has
+// to go and find out (the forwarder asking its upstreams) does that in
+// the background and answers from what it knows.
+type Readier interface {
+	Ready() bool
+}
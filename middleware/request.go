@@ -48,6 +48,7 @@ type Request struct {
 	hasECS       bool
 	hasNSID      bool
 	hasKeepalive bool
+	hasPadding   bool
 	cookieOff    int // raw client cookie bytes within raw; 0 when absent
 	cookieLen    int
 
@@ -264,6 +265,15 @@ func (r *Request) HasTCPKeepalive() bool {
 	return r.msgHasOption(dns.EDNS0TCPKEEPALIVE)
 }
 
+// HasPadding reports whether the request carried the RFC 7830 Padding
+// option, which entitles an encrypted client to a padded response.
+func (r *Request) HasPadding() bool {
+	if r.wireBorn() {
+		return r.hasPadding
+	}
+	return r.msgHasOption(dns.EDNS0PADDING)
+}
+
 // HasNSID reports whether the request asked for NSID.
 func (r *Request) HasNSID() bool {
 	if r.wireBorn() {
@@ -506,6 +516,7 @@ func (r *Request) parseWireOPT(off int) bool {
 			r.hasECS = true
 		case dns.EDNS0PADDING:
 			// Any payload; the library validates nothing either.
+			r.hasPadding = true
 		case dns.EDNS0TCPKEEPALIVE:
 			// RFC 7828: a query carries either no timeout or one word.
 			if optLen != 0 && optLen != 2 {
@@ -346,3 +346,29 @@ func TestCancelWithRcodeWireBorn(t *testing.T) {
 		t.Fatal("a terminal reply must not establish a detach lifecycle")
 	}
 }
+
+func TestRequestHasPadding(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	msg.SetEdns0(1232, true)
+	msg.IsEdns0().Option = append(msg.IsEdns0().Option, &dns.EDNS0_PADDING{Padding: make([]byte, 20)})
+	raw, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := new(Request)
+	if !r.ParseWire(raw, time.Now(), nil) {
+		t.Fatal("padded query refused")
+	}
+	if !r.HasPadding() {
+		t.Error("wire-born request lost its padding")
+	}
+	r.SetMsg(msg)
+	if !r.HasPadding() {
+		t.Error("message-born request lost its padding")
+	}
+	if r.SetMsg(new(dns.Msg)); r.HasPadding() {
+		t.Error("request without an OPT has padding")
+	}
+}
@@ -0,0 +1,172 @@
+package resolver
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/authority"
+)
+
+const (
+	// maxDelegationStatus caps one authorities listing. The delegation
+	// cache holds up to a quarter million zones, and a listing that large
+	// is a denial of service on the API, not a diagnosis; the zone filter
+	// is how an operator narrows it.
+	maxDelegationStatus = 1000
+
+	// slowestAuthoritiesExported is how many authoritative servers the
+	// dns_authority_slowest_rtt_seconds summary carries. The label set is
+	// bounded by this, not by the namespace the resolver has walked.
+	slowestAuthoritiesExported = 10
+)
+
+// AuthorityStatus is one authoritative server address as the resolver
+// sees it: what the ranking has measured, and what the circuit breaker
+// has recorded against it.
+type AuthorityStatus struct {
+	Addr      string `json:"addr"`
+	IPVersion string `json:"ip_version"`
+	// RTTMs is the smoothed round trip, zero until the server has answered.
+	RTTMs float64 `json:"rtt_ms"`
+	// ScoreMs is what the ranking sorts on: the RTT, or the seed for an
+	// unmeasured server, drifting back toward the seed as it goes stale.
+	ScoreMs float64 `json:"score_ms"`
+	Health  string  `json:"health"`
+	Stale   bool    `json:"stale"`
+	// PreservesCase is false while the server is marked as folding 0x20
+	// questions.
+	PreservesCase bool      `json:"preserves_case"`
+	Circuit       string    `json:"circuit"`
+	Failures      int32     `json:"failures,omitempty"`
+	LastFailure   time.Time `json:"last_failure,omitzero"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// DelegationStatus is one cached delegation and its servers, fastest
+// first, in the order the ranking would try them now.
+type DelegationStatus struct {
+	Zone      string            `json:"zone"`
+	Hosts     []string          `json:"hosts,omitempty"`
+	Signed    bool              `json:"signed"`
+	ExpiresAt time.Time         `json:"expires_at,omitzero"`
+	Servers   []AuthorityStatus `json:"servers"`
+}
+
+// Authorities reports the root servers and every cached delegation at or
+// below zone, ordered by zone name, at most maxDelegationStatus of them.
+// An empty zone matches everything. total is how many matched before the
+// cap.
+func (h *DNSHandler) Authorities(zone string) (delegations []DelegationStatus, total int) {
+	return h.resolver.delegationStatus(zone)
+}
+
+func (r *Resolver) delegationStatus(zone string) ([]DelegationStatus, int) {
+	zone = dns.CanonicalName(dns.Fqdn(zone))
+
+	var out []DelegationStatus
+	if dns.IsSubDomain(zone, rootzone) {
+		out = append(out, r.describeDelegation(r.rootServers, nil, time.Time{}))
+	}
+	r.delegations.ForEach(func(d *authority.Delegation) bool {
+		if dns.IsSubDomain(zone, d.Servers.Zone) {
+			out = append(out, r.describeDelegation(d.Servers, d.DSSet, d.ExpiresAt))
+		}
+		return true
+	})
+
+	slices.SortStableFunc(out, func(a, b DelegationStatus) int {
+		return cmp.Compare(a.Zone, b.Zone)
+	})
+	total := len(out)
+	if total > maxDelegationStatus {
+		out = out[:maxDelegationStatus]
+	}
+	return out, total
+}
+
+func (r *Resolver) describeDelegation(servers *authority.Servers, dsSet []dns.RR, expiresAt time.Time) DelegationStatus {
+	servers.RLock()
+	list := slices.Clone(servers.List)
+	hosts := slices.Clone(servers.Hosts)
+	servers.RUnlock()
+
+	authority.Sort(list)
+
+	d := DelegationStatus{
+		Zone:      servers.Zone,
+		Hosts:     hosts,
+		Signed:    len(dsSet) > 0,
+		ExpiresAt: expiresAt,
+		Servers:   make([]AuthorityStatus, 0, len(list)),
+	}
+	for _, s := range list {
+		cb := r.circuitBreaker.status(s.Addr)
+		d.Servers = append(d.Servers, AuthorityStatus{
+			Addr:          s.Addr,
+			IPVersion:     s.IPVersion.String(),
+			RTTMs:         durationMs(s.SmoothedRTT()),
+			ScoreMs:       durationMs(s.Score()),
+			Health:        s.Health(),
+			Stale:         s.Stale(),
+			PreservesCase: s.PreservesCase(),
+			Circuit:       cb.state,
+			Failures:      cb.failures,
+			LastFailure:   cb.lastFailure,
+			LastError:     cb.lastError,
+		})
+	}
+	return d
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// slowAuthority is one entry of the slowest-servers summary.
+type slowAuthority struct {
+	zone string
+	addr string
+	rtt  time.Duration
+}
+
+// slowestAuthorities returns the n measured servers with the highest
+// smoothed RTT across the roots and every cached delegation, slowest
+// first. A server that has stopped answering is charged a timeout and so
+// heads the list, which is where an operator wants it. A server listed
+// under several zones takes one entry, under the zone it is slowest in:
+// the summary samples it by address.
+func (r *Resolver) slowestAuthorities(n int) []slowAuthority {
+	top := make([]slowAuthority, 0, n+1)
+	consider := func(servers *authority.Servers) {
+		servers.RLock()
+		defer servers.RUnlock()
+		for _, s := range servers.List {
+			rtt := s.SmoothedRTT()
+			if rtt == 0 || (len(top) == n && rtt <= top[n-1].rtt) {
+				continue
+			}
+			if j := slices.IndexFunc(top, func(e slowAuthority) bool { return e.addr == s.Addr }); j >= 0 {
+				if rtt <= top[j].rtt {
+					continue
+				}
+				top = slices.Delete(top, j, j+1)
+			}
+			i, _ := slices.BinarySearchFunc(top, rtt, func(e slowAuthority, rtt time.Duration) int {
+				return cmp.Compare(rtt, e.rtt)
+			})
+			top = slices.Insert(top, i, slowAuthority{zone: servers.Zone, addr: s.Addr, rtt: rtt})
+			if len(top) > n {
+				top = top[:n]
+			}
+		}
+	}
+
+	consider(r.rootServers)
+	r.delegations.ForEach(func(d *authority.Delegation) bool {
+		consider(d.Servers)
+		return true
+	})
+	return top
+}
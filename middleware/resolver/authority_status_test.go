@@ -0,0 +1,198 @@
+package resolver
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/authority"
+	"github.com/semihalev/sdns/internal/cache"
+)
+
+// statusTestResolver has one root server and three cached delegations,
+// each server measured at the RTT its last octet names in milliseconds.
+func statusTestResolver(t *testing.T) *Resolver {
+	t.Helper()
+	cfg := new(config.Config)
+	cfg.RootServers = []string{"192.0.2.1:53"}
+	r := NewResolver(cfg)
+	r.rootServers.List[0].Observe(time.Millisecond)
+
+	for _, d := range []struct {
+		zone  string
+		addrs []string
+	}{
+		{"example.", []string{"192.0.2.20:53", "192.0.2.90:53"}},
+		{"sub.example.", []string{"192.0.2.40:53"}},
+		{"example.net.", []string{"192.0.2.60:53", "192.0.2.5:53"}},
+	} {
+		servers := &authority.Servers{Zone: d.zone, Hosts: []string{"ns." + d.zone}}
+		for _, addr := range d.addrs {
+			s := authority.NewServer(addr, authority.IPv4)
+			ms := addr[strings.LastIndexByte(addr, '.')+1 : strings.IndexByte(addr, ':')]
+			rtt, err := time.ParseDuration(ms + "ms")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Observe(rtt)
+			servers.List = append(servers.List, s)
+		}
+		q := dns.Question{Name: d.zone, Qtype: dns.TypeNS, Qclass: dns.ClassINET}
+		r.delegations.Set(cache.Key(q, false), nil, servers, time.Hour)
+	}
+	return r
+}
+
+func TestDelegationStatus(t *testing.T) {
+	r := statusTestResolver(t)
+	r.circuitBreaker.recordError("192.0.2.90:53", errors.New("i/o timeout"))
+
+	all, total := r.delegationStatus("")
+	if total != 4 || len(all) != 4 {
+		t.Fatalf("listing = %d of %d, want the root and three delegations", len(all), total)
+	}
+	var zones []string
+	for _, d := range all {
+		zones = append(zones, d.Zone)
+	}
+	if got := strings.Join(zones, " "); got != ". example. example.net. sub.example." {
+		t.Fatalf("zones in order %q", got)
+	}
+
+	sub, total := r.delegationStatus("Example")
+	if total != 2 || sub[0].Zone != "example." || sub[1].Zone != "sub.example." {
+		t.Fatalf("example filter = %+v, want example. and sub.example.", sub)
+	}
+
+	d := sub[0]
+	if len(d.Servers) != 2 || d.Servers[0].Addr != "192.0.2.20:53" {
+		t.Fatalf("servers %+v, want the 20ms server ranked first", d.Servers)
+	}
+	fast, slow := d.Servers[0], d.Servers[1]
+	if fast.RTTMs != 20 || fast.Health != "GOOD" || fast.Circuit != circuitClosed || !fast.PreservesCase {
+		t.Fatalf("fast server %+v", fast)
+	}
+	if slow.Failures != 1 || slow.LastError != "i/o timeout" || slow.LastFailure.IsZero() || slow.Circuit != circuitClosed {
+		t.Fatalf("failing server %+v, want one recorded failure and its error", slow)
+	}
+
+	for range 4 {
+		r.circuitBreaker.recordFailure("192.0.2.90:53")
+	}
+	sub, _ = r.delegationStatus("example.")
+	if got := sub[0].Servers[1]; got.Circuit != circuitOpen || got.Failures != 5 || got.LastError != "i/o timeout" {
+		t.Fatalf("tripped server %+v, want an open circuit keeping the last error", got)
+	}
+}
+
+func TestSlowestAuthorities(t *testing.T) {
+	r := statusTestResolver(t)
+
+	top := r.slowestAuthorities(3)
+	want := []string{"192.0.2.90:53", "192.0.2.60:53", "192.0.2.40:53"}
+	if len(top) != len(want) {
+		t.Fatalf("top = %+v, want %d entries", top, len(want))
+	}
+	for i, addr := range want {
+		if top[i].addr != addr {
+			t.Fatalf("top[%d] = %+v, want %s", i, top[i], addr)
+		}
+	}
+	if top[0].zone != "example." || top[0].rtt != 90*time.Millisecond {
+		t.Fatalf("slowest = %+v, want example.'s 90ms server", top[0])
+	}
+
+	if got := len(r.slowestAuthorities(100)); got != 6 {
+		t.Fatalf("uncapped top = %d entries, want every measured server", got)
+	}
+
+	// The same server, slower under another zone, is listed once, there.
+	shared := authority.NewServer("192.0.2.90:53", authority.IPv4)
+	shared.Observe(150 * time.Millisecond)
+	servers := &authority.Servers{Zone: "example.org.", Hosts: []string{"ns.example.org."}, List: []*authority.Server{shared}}
+	q := dns.Question{Name: "example.org.", Qtype: dns.TypeNS, Qclass: dns.ClassINET}
+	r.delegations.Set(cache.Key(q, false), nil, servers, time.Hour)
+	top = r.slowestAuthorities(3)
+	if top[0].zone != "example.org." || top[0].rtt != 150*time.Millisecond || top[1].addr != "192.0.2.60:53" {
+		t.Fatalf("top = %+v, want 192.0.2.90:53 once, under example.org.", top)
+	}
+	if got := len(r.slowestAuthorities(100)); got != 6 {
+		t.Fatalf("uncapped top = %d entries, want each server once", got)
+	}
+
+	slowestAuthoritySource.Store(r)
+	t.Cleanup(func() {
+		slowestAuthoritySource.Store(nil)
+		slowestAuthorityRTT.tracked.Store(nil)
+	})
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(slowestAuthorityRTT)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || len(families[0].GetMetric()) != 6 {
+		t.Fatalf("collector exported %v, want 6 series", families)
+	}
+}
+
+// The summary samples only the servers the last scrape picked, and
+// reports their count, sum and quantiles.
+func TestSlowestAuthoritySummary(t *testing.T) {
+	r := statusTestResolver(t)
+	slowestAuthoritySource.Store(r)
+	t.Cleanup(func() {
+		slowestAuthoritySource.Store(nil)
+		slowestAuthorityRTT.tracked.Store(nil)
+	})
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(slowestAuthorityRTT)
+	summaryOf := func(addr string) *dto.Summary {
+		t.Helper()
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range families {
+			for _, m := range f.GetMetric() {
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "server" && l.GetValue() == addr {
+						return m.GetSummary()
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	// Nothing is sampled until a scrape has picked the servers.
+	slowestAuthorityRTT.observe("192.0.2.90:53", time.Second)
+	if s := summaryOf("192.0.2.90:53"); s == nil || s.GetSampleCount() != 0 {
+		t.Fatalf("first scrape = %v, want an empty summary", s)
+	}
+
+	for i := 1; i <= 10; i++ {
+		slowestAuthorityRTT.observe("192.0.2.90:53", time.Duration(i)*10*time.Millisecond)
+	}
+	slowestAuthorityRTT.observe("198.51.100.1:53", time.Second)
+
+	s := summaryOf("192.0.2.90:53")
+	if s.GetSampleCount() != 10 || math.Abs(s.GetSampleSum()-0.55) > 1e-9 {
+		t.Fatalf("count %d sum %v, want 10 and 0.55", s.GetSampleCount(), s.GetSampleSum())
+	}
+	for _, q := range s.GetQuantile() {
+		if q.GetQuantile() == 0.5 && q.GetValue() != 0.05 {
+			t.Errorf("median = %v, want 0.05", q.GetValue())
+		}
+	}
+	if tracked := *slowestAuthorityRTT.tracked.Load(); tracked["198.51.100.1:53"] != nil {
+		t.Error("a server outside the top N is sampled")
+	}
+}
@@ -48,11 +48,22 @@ const (
 	tombstoneFile = "trust-anchor-tombstones.db"
 )
 
+// RFC 5011 §2.4.1 add hold-down and §2.4.2 remove hold-down.
+const (
+	addHoldDown    = 30 * 24 * time.Hour
+	removeHoldDown = 90 * 24 * time.Hour
+)
+
 // TrustAnchor holds a DNSSEC trust anchor with its state and metadata.
+// FirstSeen is when the key entered its current state, so the hold-down
+// timers run from it. LastSeen is the last refresh whose authenticated
+// root DNSKEY RRset carried the key; state files written before it
+// existed decode with it zero.
 type TrustAnchor struct {
 	DNSKey    *dns.DNSKEY
 	State     State
 	FirstSeen time.Time
+	LastSeen  time.Time
 }
 
 // TrustAnchors maps key tags to their trust anchor data.
@@ -102,6 +113,11 @@ func (s State) String() string {
 }
 
 func (r *Resolver) AutoTA() {
+	// The state files are read, mutated and rewritten as a whole; the
+	// management API edits the same files and must not interleave.
+	r.taMu.Lock()
+	defer r.taMu.Unlock()
+
 	refreshResult := taRefreshValidationError
 	defer func() {
 		refreshResult.Inc()
@@ -453,6 +469,7 @@ func (r *Resolver) AutoTA() {
 		zlog.Warn("New trust anchor found! Pending for hold-down", "keytag", tag, "hold-down", "30d")
 		ta.State = StateAddPend
 		ta.FirstSeen = time.Now()
+		ta.LastSeen = ta.FirstSeen
 		kskCurrent[tag] = ta
 	}
 
@@ -496,15 +513,16 @@ func (r *Resolver) AutoTA() {
 				// disappeared from the root may legitimately be
 				// reintroduced and should be allowed back through
 				// AddPend if the root republishes it.
-				if ta.State == StateMissing && time.Since(ta.FirstSeen) > 2160*time.Hour { // hold-down 90 days
+				if ta.State == StateMissing && time.Since(ta.FirstSeen) > removeHoldDown {
 					taDeleted.Inc()
 					zlog.Warn("Trust anchor deleted after hold-down", "keytag", tag)
 					delete(kskCurrent, tag)
 				}
 				continue
 			}
+			ta.LastSeen = time.Now()
 
-			if ta.State == StateAddPend && time.Since(ta.FirstSeen) > 720*time.Hour { // hold-down 30days
+			if ta.State == StateAddPend && time.Since(ta.FirstSeen) > addHoldDown {
 				// now valid
 				taBecameValid.Inc()
 				zlog.Warn("Trust anchor now valid!", "keytag", tag)
@@ -586,6 +604,7 @@ func (r *Resolver) AutoTA() {
 	r.Lock()
 	r.rootKeys = finalRootKeys
 	r.Unlock()
+	observeTrustAnchorStates(kskCurrent, tombstones)
 
 	for tag, ta := range kskCurrent {
 		zlog.Info("Trust anchor status", "keytag", tag, "state", ta.State.String(), "firstseen", ta.FirstSeen.UTC().Format(time.UnixDate))
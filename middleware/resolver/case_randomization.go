@@ -0,0 +1,58 @@
+package resolver
+
+import (
+	"errors"
+	"math/rand/v2"
+
+	"github.com/miekg/dns"
+)
+
+// errCaseMismatch marks a UDP reply that matched the query ID and the
+// question, but not the 0x20 spelling it was sent with. It never leaves
+// exchange: the attempt is repeated in the resolver's own spelling.
+var errCaseMismatch = errors.New("dns: response question did not echo the randomized case")
+
+// randomizeCase returns name with the case of every ASCII letter chosen at
+// random (draft-vixie-dnsext-dns0x20). Each letter is one more bit an
+// off-path attacker has to guess, on top of the query ID and source port.
+// Flipping rather than setting keeps the result uniform whatever spelling
+// the name arrived in; digits, hyphens and escapes are left as they are.
+func randomizeCase(name string) string {
+	b := []byte(name)
+	var bits uint64
+	var left int
+	for i, c := range b {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			continue
+		}
+		if left == 0 {
+			bits, left = rand.Uint64(), 64 //nolint:gosec // G404 - entropy against blind spoofing, not a secret
+		}
+		if bits&1 != 0 {
+			b[i] = c ^ 0x20
+		}
+		bits >>= 1
+		left--
+	}
+	return string(b)
+}
+
+// restoreQueryCase puts the resolver's own spelling back on a reply to a
+// randomized question. The authority echoes the spelling it was sent into
+// the question and, through name compression, into every record it owns,
+// and none of that is worth caching: the client's case is echoed on the
+// way out regardless, and a cached owner in a random spelling would leak
+// the entropy of a past query to anyone who reads the cache. Owners the
+// authority spelled its own way are its data and are left alone.
+func restoreQueryCase(resp *dns.Msg, sent, name string) {
+	if len(resp.Question) > 0 {
+		resp.Question[0].Name = name
+	}
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			if h := rr.Header(); h.Name == sent {
+				h.Name = name
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/authority"
+)
+
+func TestRandomizeCase(t *testing.T) {
+	const name = "abcdefghijklmnopqrstuvwxyz-0123456789.example.\\046."
+	varied := false
+	for range 8 {
+		got := randomizeCase(name)
+		if !strings.EqualFold(got, name) {
+			t.Fatalf("randomizeCase(%q) = %q, not the same name", name, got)
+		}
+		if got[26:37] != name[26:37] || !strings.HasSuffix(got, "\\046.") {
+			t.Fatalf("randomizeCase(%q) = %q, touched a non-letter", name, got)
+		}
+		varied = varied || got != name
+	}
+	if !varied {
+		t.Fatal("eight randomizations of 33 letters all came back unchanged")
+	}
+	if got := randomizeCase("."); got != "." {
+		t.Fatalf("randomizeCase(root) = %q", got)
+	}
+}
+
+func TestRestoreQueryCase(t *testing.T) {
+	const sent, name = "wWw.ExamPLE.", "www.example."
+	resp := new(dns.Msg)
+	resp.SetQuestion(sent, dns.TypeA)
+	resp.Answer = []dns.RR{
+		mustRR(t, sent+" 300 IN CNAME host.example."),
+		mustRR(t, "host.example. 300 IN A 192.0.2.1"),
+	}
+	resp.Ns = []dns.RR{mustRR(t, "EXAMPLE. 300 IN NS ns.example.")}
+
+	restoreQueryCase(resp, sent, name)
+
+	if got := resp.Question[0].Name; got != name {
+		t.Fatalf("question = %q, want %q", got, name)
+	}
+	if got := resp.Answer[0].Header().Name; got != name {
+		t.Fatalf("owner of the question's record = %q, want %q", got, name)
+	}
+	if got := resp.Ns[0].Header().Name; got != "EXAMPLE." {
+		t.Fatalf("the authority's own spelling was rewritten to %q", got)
+	}
+}
+
+// caseUpstream starts a UDP authority that answers every A query with one
+// record owned by the question name. fold makes it answer in lower case,
+// the way a server that does not preserve case does. Every question it
+// receives is passed on seen.
+func caseUpstream(t *testing.T, fold bool, seen chan<- string) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		select {
+		case seen <- req.Question[0].Name:
+		default:
+		}
+		m := new(dns.Msg)
+		m.SetReply(req)
+		if fold {
+			m.Question[0].Name = strings.ToLower(m.Question[0].Name)
+		}
+		m.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(192, 0, 2, 1),
+		}}
+		_ = w.WriteMsg(m)
+	})}
+	go func() { _ = s.ActivateAndServe() }()
+	t.Cleanup(func() { _ = s.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestExchangeCaseRandomization(t *testing.T) {
+	const qname = "case-randomization.example.org."
+	seen := make(chan string, 4)
+	addr := caseUpstream(t, false, seen)
+
+	r := &Resolver{cfg: &config.Config{QnameCase0x20: true}, netTimeout: time.Second}
+	server := authority.NewServer(addr, authority.IPv4)
+	before := qnameCaseMismatches.Value()
+
+	varied := false
+	for range 4 {
+		req := new(dns.Msg)
+		req.SetQuestion(qname, dns.TypeA)
+		resp, err := r.exchange(context.Background(), &resolveState{}, nil, "udp", req, server, 0)
+		if err != nil {
+			t.Fatalf("exchange: %v", err)
+		}
+		if got := resp.Question[0].Name; got != qname {
+			t.Fatalf("response question = %q, want the resolver's %q", got, qname)
+		}
+		if got := resp.Answer[0].Header().Name; got != qname {
+			t.Fatalf("answer owner = %q, want %q", got, qname)
+		}
+		if req.Question[0].Name != qname {
+			t.Fatalf("request left with %q", req.Question[0].Name)
+		}
+		sent := <-seen
+		if !strings.EqualFold(sent, qname) {
+			t.Fatalf("upstream saw %q", sent)
+		}
+		varied = varied || sent != qname
+	}
+	if !varied {
+		t.Fatal("the upstream never saw a randomized spelling")
+	}
+	if !server.PreservesCase() || qnameCaseMismatches.Value() != before {
+		t.Fatal("a server that echoed the case exactly was marked case-blind")
+	}
+}
+
+// A server that folds case is learned on the first reply: the reply is
+// counted and dropped, the query goes again in the resolver's spelling,
+// and the server is not asked in a random one again.
+func TestExchangeCaseMismatchLearnsServer(t *testing.T) {
+	const qname = "Case-Folding.Example.Org."
+	seen := make(chan string, 8)
+	addr := caseUpstream(t, true, seen)
+
+	r := &Resolver{cfg: &config.Config{QnameCase0x20: true}, netTimeout: time.Second}
+	server := authority.NewServer(addr, authority.IPv4)
+	before := qnameCaseMismatches.Value()
+
+	req := new(dns.Msg)
+	req.SetQuestion(qname, dns.TypeA)
+	for i := 1; i <= authority.CaseBlindStrikes; i++ {
+		resp, err := r.exchange(context.Background(), &resolveState{}, nil, "udp", req, server, 0)
+		if err != nil {
+			t.Fatalf("exchange %d: %v", i, err)
+		}
+		if len(resp.Answer) != 1 {
+			t.Fatalf("answers = %d, want the retry's 1", len(resp.Answer))
+		}
+		if got := <-seen; got == qname {
+			t.Fatalf("exchange %d went out without 0x20", i)
+		}
+		if got := <-seen; got != qname {
+			t.Fatalf("retry went out as %q, want %q", got, qname)
+		}
+	}
+	if server.PreservesCase() {
+		t.Fatal("a server that folded the case every time is still asked with 0x20")
+	}
+	if got := qnameCaseMismatches.Value() - before; got != authority.CaseBlindStrikes {
+		t.Fatalf("mismatch delta = %d, want %d", got, authority.CaseBlindStrikes)
+	}
+
+	// Off by default: the name goes out as given.
+	r.cfg.QnameCase0x20 = false
+	plain := authority.NewServer(addr, authority.IPv4)
+	if _, err := r.exchange(context.Background(), &resolveState{}, nil, "udp", req, plain, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-seen; got != qname {
+		t.Fatalf("with 0x20 off the upstream saw %q", got)
+	}
+}
+
+// A single folded reply — one forged answer that got the ID and port
+// right — is dropped and retried, and costs the server nothing: the next
+// query goes out randomized again.
+func TestExchangeCaseMismatchOnceKeeps0x20(t *testing.T) {
+	const qname = "Case-Folding.Example.Org."
+	seen := make(chan string, 4)
+	addr := caseUpstream(t, true, seen)
+
+	r := &Resolver{cfg: &config.Config{QnameCase0x20: true}, netTimeout: time.Second}
+	server := authority.NewServer(addr, authority.IPv4)
+
+	req := new(dns.Msg)
+	req.SetQuestion(qname, dns.TypeA)
+	if _, err := r.exchange(context.Background(), &resolveState{}, nil, "udp", req, server, 0); err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	<-seen
+	<-seen
+	if !server.PreservesCase() {
+		t.Fatal("one mismatch turned 0x20 off for the server")
+	}
+	if _, err := r.exchange(context.Background(), &resolveState{}, nil, "udp", req, server, 0); err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if got := <-seen; got == qname {
+		t.Fatal("the next query went out without 0x20")
+	}
+	<-seen
+}
@@ -18,6 +18,24 @@ type serverFailure struct {
 	count       atomic.Int32
 	lastFailure atomic.Int64 // Unix timestamp
 	disabled    atomic.Bool
+	lastError   atomic.Pointer[string]
+}
+
+// Circuit states as the management API reports them. Half-open is an open
+// circuit whose cool-down has run out: the next query goes through and
+// either closes it or trips it again.
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// breakerStatus is what the circuit breaker knows about one server.
+type breakerStatus struct {
+	state       string
+	failures    int32
+	lastFailure time.Time
+	lastError   string
 }
 
 func newCircuitBreaker() *circuitBreaker {
@@ -60,6 +78,12 @@ func (cb *circuitBreaker) canQuery(server string) bool {
 
 // recordFailure records a server failure
 func (cb *circuitBreaker) recordFailure(server string) {
+	cb.recordError(server, nil)
+}
+
+// recordError records a server failure and, when err is not nil, keeps it
+// as the server's last error for the management API.
+func (cb *circuitBreaker) recordError(server string, err error) {
 	cb.mu.Lock()
 	sf, exists := cb.failures[server]
 	if !exists {
@@ -70,6 +94,10 @@ func (cb *circuitBreaker) recordFailure(server string) {
 
 	count := sf.count.Add(1)
 	sf.lastFailure.Store(time.Now().Unix())
+	if err != nil {
+		msg := err.Error()
+		sf.lastError.Store(&msg)
+	}
 
 	// Disable server after 5 consecutive failures
 	if count >= 5 && sf.disabled.CompareAndSwap(false, true) {
@@ -95,6 +123,33 @@ func (cb *circuitBreaker) recordSuccess(server string) {
 	}
 }
 
+// status reports the circuit for server. A server with no failure on
+// record in the last five minutes is closed with nothing to report.
+func (cb *circuitBreaker) status(server string) breakerStatus {
+	cb.mu.RLock()
+	sf, exists := cb.failures[server]
+	cb.mu.RUnlock()
+
+	st := breakerStatus{state: circuitClosed}
+	if !exists {
+		return st
+	}
+	st.failures = sf.count.Load()
+	if last := sf.lastFailure.Load(); last != 0 {
+		st.lastFailure = time.Unix(last, 0)
+	}
+	if msg := sf.lastError.Load(); msg != nil {
+		st.lastError = *msg
+	}
+	if sf.disabled.Load() {
+		st.state = circuitOpen
+		if time.Since(st.lastFailure) > 30*time.Second {
+			st.state = circuitHalfOpen
+		}
+	}
+	return st
+}
+
 // cleanup removes old failure records periodically.
 func (cb *circuitBreaker) cleanup() {
 	ticker := time.NewTicker(5 * time.Minute)
@@ -0,0 +1,157 @@
+package dnssec
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// RFC 8032 §7.4 test vectors for pure Ed448.
+var ed448Vectors = []struct {
+	name      string
+	public    string
+	message   string
+	signature string
+}{
+	{
+		name:    "blank",
+		public:  "5fd7449b59b461fd2ce787ec616ad46a1da1342485a70e1f8a0ea75d80e96778edf124769b46c7061bd6783df1e50f6cd1fa1abeafe8256180",
+		message: "",
+		signature: "533a37f6bbe457251f023c0d88f976ae2dfb504a843e34d2074fd823d41a591f" +
+			"2b233f034f628281f2fd7a22ddd47d7828c59bd0a21bfd3980ff0d2028d4b18a" +
+			"9df63e006c5d1c2d345b925d8dc00b4104852db99ac5c7cdda8530a113a0f4db" +
+			"b61149f05a7363268c71d95808ff2e652600",
+	},
+	{
+		name:    "1 octet",
+		public:  "43ba28f430cdff456ae531545f7ecd0ac834a55d9358c0372bfa0c6c6798c0866aea01eb00742802b8438ea4cb82169c235160627b4c3a9480",
+		message: "03",
+		signature: "26b8f91727bd62897af15e41eb43c377efb9c610d48f2335cb0bd0087810f435" +
+			"2541b143c4b981b7e18f62de8ccdf633fc1bf037ab7cd779805e0dbcc0aae1cb" +
+			"cee1afb2e027df36bc04dcecbf154336c19f0af7e0a6472905e799f1953d2a0f" +
+			"f3348ab21aa4adafd1d234441cf807c03a00",
+	},
+}
+
+func mustHex(tb testing.TB, s string) []byte {
+	tb.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return b
+}
+
+func TestEd448RFC8032Vectors(t *testing.T) {
+	for _, v := range ed448Vectors {
+		t.Run(v.name, func(t *testing.T) {
+			public := mustHex(t, v.public)
+			message := mustHex(t, v.message)
+			signature := mustHex(t, v.signature)
+
+			if !ed448Verify(public, message, signature) {
+				t.Fatal("RFC 8032 vector rejected")
+			}
+
+			tampered := append([]byte(nil), message...)
+			tampered = append(tampered, 0)
+			if ed448Verify(public, tampered, signature) {
+				t.Fatal("signature accepted for a different message")
+			}
+
+			for _, i := range []int{0, 56, 57, 112} {
+				bad := append([]byte(nil), signature...)
+				bad[i] ^= 0x01
+				if ed448Verify(public, message, bad) {
+					t.Fatalf("signature accepted with octet %d flipped", i)
+				}
+			}
+		})
+	}
+}
+
+func TestEd448RejectsNonCanonicalEncodings(t *testing.T) {
+	v := ed448Vectors[0]
+	public := mustHex(t, v.public)
+	signature := mustHex(t, v.signature)
+
+	// RFC 8032 §5.2.7: S must be below the group order. S + L verifies
+	// the same group equation and must still be refused.
+	s := littleEndianInt(signature[57:113])
+	s.Add(s, ed448L)
+	be := s.FillBytes(make([]byte, 56))
+	malleable := append([]byte(nil), signature[:57]...)
+	for i := range be {
+		malleable = append(malleable, be[55-i])
+	}
+	malleable = append(malleable, 0)
+	if ed448Verify(public, nil, malleable) {
+		t.Fatal("signature with S >= L accepted")
+	}
+
+	// The final octet of a point carries only the sign of x.
+	badPoint := append([]byte(nil), public...)
+	badPoint[56] |= 0x01
+	if _, ok := ed448Decode(badPoint); ok {
+		t.Fatal("point with stray bits in its final octet decoded")
+	}
+
+	// y >= p is not a canonical encoding.
+	overflow := make([]byte, ed448PublicKeySize)
+	for i := range 56 {
+		overflow[i] = 0xff
+	}
+	if _, ok := ed448Decode(overflow); ok {
+		t.Fatal("point with y >= p decoded")
+	}
+
+	if ed448Verify(public[:56], nil, signature) {
+		t.Fatal("short public key accepted")
+	}
+	if ed448Verify(public, nil, signature[:113]) {
+		t.Fatal("short signature accepted")
+	}
+}
+
+// ed448Fixture is an algorithm-16 zone key and a signature over an A RRset,
+// produced by an independent Ed448 implementation so the DNSSEC path is
+// checked against someone else's signer rather than its own inverse.
+func ed448Fixture(tb testing.TB) (*dns.DNSKEY, *dns.RRSIG, []dns.RR) {
+	tb.Helper()
+	key := mustSignatureRR(tb, "example.com. 3600 IN DNSKEY 257 3 16 "+
+		"OKICXyb/p2fWLrcmckympCy++KWU8Vz5Hl+1MqYbkyPoa3PIdnO2favmT2COaz/sfNW8/Ak2rqGA").(*dns.DNSKEY)
+	sig := mustSignatureRR(tb, "www.example.com. 300 IN RRSIG A 16 3 300 20450101000000 20250101000000 40014 example.com. "+
+		"kYkKlF/fMFbFb/PoJ27y3yFEX5Gk3dOFQzZv0/nxgjBcowrcHRJz7WG6jMvd6YVEx6ankwrAyqwAy89JjOShWJJv94iySBKhQdNUpu8J94oeeQZ69Ly7ACBllslyTTmyWeCLApi0F0jIv1mmFg45UzAA").(*dns.RRSIG)
+	rrset := []dns.RR{
+		mustSignatureRR(tb, "www.example.com. 300 IN A 192.0.2.10"),
+		mustSignatureRR(tb, "www.example.com. 300 IN A 192.0.2.11"),
+	}
+	return key, sig, rrset
+}
+
+func TestVerifySignatureEd448(t *testing.T) {
+	key, sig, rrset := ed448Fixture(t)
+	if KeyTag(key) != sig.KeyTag {
+		t.Fatalf("fixture key tag %d, signature names %d", KeyTag(key), sig.KeyTag)
+	}
+
+	if err := verifySignature(key, sig, rrset); err != nil {
+		t.Fatalf("verifySignature: %v", err)
+	}
+	if err := cryptoVerify(key, sig, rrset); err != nil {
+		t.Fatalf("cryptoVerify: %v", err)
+	}
+
+	changed := []dns.RR{rrset[0], mustSignatureRR(t, "www.example.com. 300 IN A 192.0.2.12")}
+	if err := verifySignature(key, sig, changed); err != dns.ErrSig {
+		t.Fatalf("verifySignature over altered RRset = %v, want ErrSig", err)
+	}
+
+	msg := new(dns.Msg)
+	msg.Answer = append(append([]dns.RR{}, rrset...), sig)
+	ok, err := VerifyRRSIG("example.com.", map[uint16][]*dns.DNSKEY{key.KeyTag(): {key}}, msg)
+	if !ok || err != nil {
+		t.Fatalf("VerifyRRSIG = %v, %v; want true, nil", ok, err)
+	}
+}
@@ -0,0 +1,61 @@
+package dnssec
+
+import (
+	"strconv"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/semihalev/sdns/internal/metric"
+)
+
+// Signature checks by algorithm and outcome. The label set is closed: an
+// algorithm is one octet with three outcomes, and the implemented
+// algorithms are resolved once into a table at init so the observation is
+// an index and an add.
+var (
+	validations = metric.NewCounterVec(nil, prometheus.CounterOpts{
+		Name: "dns_dnssec_validations_total",
+		Help: "RRSIG verifications by DNSKEY algorithm and result",
+	}, []string{"algorithm", "result"})
+
+	validationCounters [256][validationResults]*metric.Counter
+)
+
+// Validation outcomes, in label order.
+const (
+	validationValid = iota
+	validationInvalid
+	validationUnsupported
+	validationResults
+)
+
+var validationResultNames = [validationResults]string{"valid", "invalid", "unsupported"}
+
+func init() {
+	for _, alg := range implementedAlgorithms {
+		for result := range validationResults {
+			validationCounters[alg][result] = validations.WithLabelValues(
+				algorithmLabel(alg), validationResultNames[result])
+		}
+	}
+}
+
+// observeValidation counts one RRSIG check. A cryptographic check counts
+// valid or invalid; a signature whose algorithm the Policy does not accept
+// counts unsupported, since it never reaches the cryptography.
+func observeValidation(alg uint8, result int) {
+	if c := validationCounters[alg][result]; c != nil {
+		c.Inc()
+		return
+	}
+	// An algorithm outside the implemented set: only ever unsupported, and
+	// rare enough that the vec lookup is no cost worth a table write.
+	validations.WithLabelValues(algorithmLabel(alg), validationResultNames[result]).Inc()
+}
+
+func algorithmLabel(alg uint8) string {
+	if name, ok := dns.AlgorithmToString[alg]; ok {
+		return name
+	}
+	return strconv.Itoa(int(alg))
+}
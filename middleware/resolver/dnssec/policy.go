@@ -0,0 +1,128 @@
+package dnssec
+
+import (
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/dnssecalg"
+)
+
+// Policy is the validator's algorithm table: which DNSKEY algorithms and DS
+// digest types count as supported. Anything outside it is treated the way
+// RFC 6840 §5.2 and RFC 8624 §3 treat an algorithm a validator does not
+// implement — a zone signed only with it is insecure, not bogus — so
+// disabling an algorithm is a downgrade the operator chose, never an outage.
+//
+// A Policy is immutable once built; the validator reads the installed one
+// through an atomic pointer on every check.
+type Policy struct {
+	algorithms [256]bool
+	digests    [256]bool
+}
+
+// implementedAlgorithms are the DNSKEY algorithms this package can verify
+// and implementedDigests the DS digest types dsDigestHash computes, as
+// dnssecalg lists them for the config loader; see dsDigestHash for why 5
+// is not SHA-512.
+var (
+	implementedAlgorithms = dnssecalg.Algorithms
+	implementedDigests    = dnssecalg.Digests
+)
+
+// DefaultPolicy returns the RFC 8624 validation table: every algorithm and
+// digest marked MUST, RECOMMENDED or MAY that this package implements,
+// SHA-1 RSA included. RFC 8624 §3.1 still lists RSASHA1 and
+// RSASHA1-NSEC3-SHA1 as MUST for validation, so turning them off is left
+// to the operator.
+func DefaultPolicy() *Policy {
+	p := &Policy{}
+	for _, alg := range implementedAlgorithms {
+		p.algorithms[alg] = true
+	}
+	for _, digest := range implementedDigests {
+		p.digests[digest] = true
+	}
+	return p
+}
+
+// NewPolicy builds a Policy from algorithm and digest mnemonics as IANA
+// spells them ("RSASHA256", "ED448", "SHA384"). An empty list keeps the
+// default for that half of the table. allowSHA1 false removes RSASHA1 and
+// RSASHA1-NSEC3-SHA1 whatever the list says; DS digest type 1 is governed
+// by the digest list alone, since a SHA-1 DS is still the only link some
+// delegations have.
+//
+// A name that is unknown, or known but not implemented here, is an error:
+// a policy that silently drops a typo'd algorithm would quietly turn every
+// zone signed with it insecure.
+func NewPolicy(algorithms, digests []string, allowSHA1 bool) (*Policy, error) {
+	p := DefaultPolicy()
+
+	if len(algorithms) > 0 {
+		p.algorithms = [256]bool{}
+		for _, name := range algorithms {
+			alg, err := dnssecalg.Algorithm(name)
+			if err != nil {
+				return nil, err
+			}
+			p.algorithms[alg] = true
+		}
+	}
+
+	if len(digests) > 0 {
+		p.digests = [256]bool{}
+		for _, name := range digests {
+			digest, err := dnssecalg.Digest(name)
+			if err != nil {
+				return nil, err
+			}
+			p.digests[digest] = true
+		}
+	}
+
+	if !allowSHA1 {
+		p.algorithms[dns.RSASHA1] = false
+		p.algorithms[dns.RSASHA1NSEC3SHA1] = false
+	}
+
+	return p, nil
+}
+
+// Algorithm reports whether DNSKEY algorithm alg is supported.
+func (p *Policy) Algorithm(alg uint8) bool { return p.algorithms[alg] }
+
+// Digest reports whether DS digest type t is supported.
+func (p *Policy) Digest(t uint8) bool { return p.digests[t] }
+
+// Algorithms returns the supported algorithms in IANA order.
+func (p *Policy) Algorithms() []uint8 { return p.enabled(p.algorithms[:]) }
+
+// Digests returns the supported digest types in IANA order.
+func (p *Policy) Digests() []uint8 { return p.enabled(p.digests[:]) }
+
+func (p *Policy) enabled(table []bool) []uint8 {
+	var out []uint8
+	for v, on := range table {
+		if on {
+			out = append(out, uint8(v)) //nolint:gosec // index of a [256]bool
+		}
+	}
+	return out
+}
+
+var policy atomic.Pointer[Policy]
+
+func init() { policy.Store(DefaultPolicy()) }
+
+// SetPolicy installs p as the validator's algorithm table. A nil p restores
+// DefaultPolicy. The resolver calls it once at construction; it is safe to
+// call concurrently with validation, which sees either table whole.
+func SetPolicy(p *Policy) {
+	if p == nil {
+		p = DefaultPolicy()
+	}
+	policy.Store(p)
+}
+
+// CurrentPolicy returns the installed algorithm table.
+func CurrentPolicy() *Policy { return policy.Load() }
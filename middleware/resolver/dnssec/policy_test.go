@@ -0,0 +1,211 @@
+package dnssec
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// withPolicy installs p for the rest of the test and restores the default
+// afterwards. The policy is process-wide, so these tests do not run in
+// parallel.
+func withPolicy(t *testing.T, p *Policy) {
+	t.Helper()
+	SetPolicy(p)
+	t.Cleanup(func() { SetPolicy(nil) })
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	p := DefaultPolicy()
+
+	if got := p.Algorithms(); !slices.Equal(got, implementedAlgorithms) {
+		t.Fatalf("Algorithms() = %v, want %v", got, implementedAlgorithms)
+	}
+	if got := p.Digests(); !slices.Equal(got, implementedDigests) {
+		t.Fatalf("Digests() = %v, want %v", got, implementedDigests)
+	}
+	for _, alg := range []uint8{dns.RSAMD5, dns.DSA, dns.DSANSEC3SHA1, dns.ECCGOST, 0, 255} {
+		if p.Algorithm(alg) {
+			t.Errorf("default policy accepts algorithm %d", alg)
+		}
+	}
+	for _, digest := range []uint8{dns.GOST94, dns.SHA512, 0} {
+		if p.Digest(digest) {
+			t.Errorf("default policy accepts digest %d", digest)
+		}
+	}
+	for alg := range 256 {
+		if got, want := verifySignatureSupported(uint8(alg)), slices.Contains(implementedAlgorithms, uint8(alg)); got != want {
+			t.Errorf("algorithm %d: verifySignature implements it %v, the policy table lists it %v", alg, got, want)
+		}
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		algorithms  []string
+		digests     []string
+		allowSHA1   bool
+		wantAlgs    []uint8
+		wantDigests []uint8
+		wantErr     bool
+	}{
+		{
+			name:        "empty lists keep defaults",
+			allowSHA1:   true,
+			wantAlgs:    implementedAlgorithms,
+			wantDigests: implementedDigests,
+		},
+		{
+			name:      "sha1 off",
+			allowSHA1: false,
+			wantAlgs: []uint8{dns.RSASHA256, dns.RSASHA512, dns.ECDSAP256SHA256,
+				dns.ECDSAP384SHA384, dns.ED25519, dns.ED448},
+			wantDigests: implementedDigests,
+		},
+		{
+			name:        "sha1 off overrides an explicit list",
+			algorithms:  []string{"RSASHA1", "RSASHA256"},
+			allowSHA1:   false,
+			wantAlgs:    []uint8{dns.RSASHA256},
+			wantDigests: implementedDigests,
+		},
+		{
+			name:        "mnemonics, case and numbers",
+			algorithms:  []string{"ecdsap256sha256", "RSASHA1-NSEC3-SHA1", "16"},
+			digests:     []string{"sha256", "4"},
+			allowSHA1:   true,
+			wantAlgs:    []uint8{dns.RSASHA1NSEC3SHA1, dns.ECDSAP256SHA256, dns.ED448},
+			wantDigests: []uint8{dns.SHA256, dns.SHA384},
+		},
+		{
+			name:       "unknown algorithm",
+			algorithms: []string{"RSASHA257"},
+			wantErr:    true,
+		},
+		{
+			name:       "known but unimplemented algorithm",
+			algorithms: []string{"RSAMD5"},
+			wantErr:    true,
+		},
+		{
+			name:    "GOST digest is unimplemented",
+			digests: []string{"GOST94"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPolicy(tt.algorithms, tt.digests, tt.allowSHA1)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("NewPolicy succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewPolicy: %v", err)
+			}
+			if got := p.Algorithms(); !slices.Equal(got, tt.wantAlgs) {
+				t.Fatalf("Algorithms() = %v, want %v", got, tt.wantAlgs)
+			}
+			if got := p.Digests(); !slices.Equal(got, tt.wantDigests) {
+				t.Fatalf("Digests() = %v, want %v", got, tt.wantDigests)
+			}
+		})
+	}
+}
+
+// A disabled algorithm must downgrade its zone to insecure, never bogus: the
+// DS set becomes unsupported-only, and the signature check answers ErrAlg
+// before any cryptography runs.
+func TestPolicyDisabledAlgorithmIsUnsupported(t *testing.T) {
+	var fixture signatureFixture
+	for _, f := range signatureFixtures(t) {
+		if f.name == "RSASHA1" {
+			fixture = f
+		}
+	}
+	ds := fixture.key.ToDS(dns.SHA256)
+	keyMap := map[uint16][]*dns.DNSKEY{fixture.key.KeyTag(): {fixture.key}}
+
+	if unsupported, err := VerifyDS(keyMap, []dns.RR{ds}); unsupported || err != nil {
+		t.Fatalf("default policy: VerifyDS = %v, %v; want false, nil", unsupported, err)
+	}
+
+	p, err := NewPolicy(nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withPolicy(t, p)
+
+	if IsSupportedDS(ds) {
+		t.Fatal("SHA-1 RSA DS still supported with allow_sha1 off")
+	}
+	if unsupported, _ := VerifyDS(keyMap, []dns.RR{ds}); !unsupported {
+		t.Fatal("VerifyDS did not report an unsupported-only DS set")
+	}
+	if err := verifyOneSig(keyMap, fixture.rrset, fixture.sig); err != dns.ErrAlg {
+		t.Fatalf("verifyOneSig = %v, want ErrAlg", err)
+	}
+}
+
+func TestPolicyDisabledDigest(t *testing.T) {
+	fixture := signatureFixtures(t)[1]
+	ds := fixture.key.ToDS(dns.SHA1)
+	keyMap := map[uint16][]*dns.DNSKEY{fixture.key.KeyTag(): {fixture.key}}
+
+	p, err := NewPolicy(nil, []string{"SHA256", "SHA384"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withPolicy(t, p)
+
+	if unsupported, _ := VerifyDS(keyMap, []dns.RR{ds}); !unsupported {
+		t.Fatal("SHA-1 DS still used with digest type 1 out of the table")
+	}
+	if unsupported, err := VerifyDS(keyMap, []dns.RR{ds, fixture.key.ToDS(dns.SHA256)}); unsupported || err != nil {
+		t.Fatalf("VerifyDS with a SHA-256 sibling = %v, %v; want false, nil", unsupported, err)
+	}
+}
+
+func TestValidationMetricsByAlgorithm(t *testing.T) {
+	key, sig, rrset := ed448Fixture(t)
+	keyMap := map[uint16][]*dns.DNSKEY{key.KeyTag(): {key}}
+
+	valid := validationCounters[dns.ED448][validationValid]
+	invalid := validationCounters[dns.ED448][validationInvalid]
+	unsupported := validationCounters[dns.ED448][validationUnsupported]
+	beforeValid, beforeInvalid, beforeUnsupported := valid.Value(), invalid.Value(), unsupported.Value()
+
+	if err := verifyOneSig(keyMap, rrset, sig); err != nil {
+		t.Fatalf("verifyOneSig: %v", err)
+	}
+	bad := dns.Copy(sig).(*dns.RRSIG)
+	bad.OrigTtl++
+	if err := verifyOneSig(keyMap, rrset, bad); err == nil {
+		t.Fatal("altered signature verified")
+	}
+
+	p, err := NewPolicy([]string{"ED25519"}, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withPolicy(t, p)
+	if err := verifyOneSig(keyMap, rrset, sig); err != dns.ErrAlg {
+		t.Fatalf("verifyOneSig with ED448 disabled = %v, want ErrAlg", err)
+	}
+
+	if got := valid.Value() - beforeValid; got != 1 {
+		t.Errorf("valid delta = %d, want 1", got)
+	}
+	if got := invalid.Value() - beforeInvalid; got != 1 {
+		t.Errorf("invalid delta = %d, want 1", got)
+	}
+	if got := unsupported.Value() - beforeUnsupported; got != 1 {
+		t.Errorf("unsupported delta = %d, want 1", got)
+	}
+}
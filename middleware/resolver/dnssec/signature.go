@@ -101,27 +101,38 @@ func verifySignature(k *dns.DNSKEY, sig *dns.RRSIG, rrset []dns.RR) error {
 		return dns.ErrSig
 	}
 
-	switch sig.Algorithm {
+	verify := signatureVerifier(sig.Algorithm)
+	if verify == nil {
+		return ErrMissingDNSKEY
+	}
+	return verify(k, sig.Algorithm, signed, signature)
+}
+
+// signatureVerifier returns verifySignature's verifier for a DNSKEY
+// algorithm, nil for one it does not implement. TestDefaultPolicy holds
+// it to dnssecalg.Algorithms, the list the policy is built from.
+func signatureVerifier(algorithm uint8) func(k *dns.DNSKEY, algorithm uint8, signed, signature []byte) error {
+	switch algorithm {
 	case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512:
-		return verifyRSASignature(k, sig.Algorithm, signed, signature)
+		return verifyRSASignature
 	case dns.ECDSAP256SHA256, dns.ECDSAP384SHA384:
-		return verifyECDSASignature(k, sig.Algorithm, signed, signature)
+		return verifyECDSASignature
 	case dns.ED25519:
-		return verifyEd25519Signature(k, signed, signature)
-	default:
-		return ErrMissingDNSKEY
+		return func(k *dns.DNSKEY, _ uint8, signed, signature []byte) error {
+			return verifyEd25519Signature(k, signed, signature)
+		}
+	case dns.ED448:
+		return func(k *dns.DNSKEY, _ uint8, signed, signature []byte) error {
+			return verifyEd448Signature(k, signed, signature)
+		}
 	}
+	return nil
 }
 
 // verifySignatureSupported reports whether verifySignature implements the
 // algorithm, so the dispatcher can leave anything else where it was.
 func verifySignatureSupported(algorithm uint8) bool {
-	switch algorithm {
-	case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512,
-		dns.ECDSAP256SHA256, dns.ECDSAP384SHA384, dns.ED25519:
-		return true
-	}
-	return false
+	return signatureVerifier(algorithm) != nil
 }
 
 func verifyRSASignature(k *dns.DNSKEY, algorithm uint8, signed, signature []byte) error {
@@ -20,43 +20,29 @@ import (
 )
 
 // IsSupportedDSDigest reports whether the given DS digest type is
-// implemented locally. RFC 6840 §5.2 requires validators to ignore DS
-// records that use unknown or unimplemented digest algorithms. Only the
-// three digest types miekg/dns' DNSKEY.ToDS actually computes are
-// treated as supported here — anything else (GOST94, future digest
-// types, unknown values) is skipped.
+// implemented locally and enabled by the installed Policy. RFC 6840 §5.2
+// requires validators to ignore DS records that use unknown or
+// unimplemented digest algorithms, and RFC 8624 §3.3 extends the same
+// treatment to digests a validator has chosen not to accept. Only SHA-1,
+// SHA-256 and SHA-384 are ever implemented — anything else (GOST94,
+// future digest types, unknown values) is skipped.
 func IsSupportedDSDigest(t uint8) bool {
-	switch t {
-	case dns.SHA1, dns.SHA256, dns.SHA384:
-		return true
-	}
-	return false
+	return CurrentPolicy().Digest(t)
 }
 
-// IsSupportedDNSKEYAlgorithm reports whether miekg/dns' RRSIG.Verify can
-// process signatures of the given algorithm without returning ErrAlg.
+// IsSupportedDNSKEYAlgorithm reports whether signatures of the given
+// algorithm can be verified here and the installed Policy accepts them.
 // DS records advertising unsupported DNSKEY algorithms are unusable —
 // DNSKEY.ToDS will still hash them, but later RRSIG verification would
 // fail. Per RFC 6840 §5.2 such DS entries must be disregarded so an
 // unsupported-only DS RRset is treated as insecure rather than bogus.
 //
-// The list intentionally matches miekg/dns' switch in RRSIG.Verify
-// exactly. RSAMD5 (deprecated by RFC 8624) is *not* accepted there, so
-// classifying it as supported would let an RSAMD5 DS RRset appear
-// usable and then bogus out on verification instead of downgrading to
-// insecure.
+// The implemented set is verifySignatureSupported's. RSAMD5 (deprecated
+// by RFC 8624) is *not* in it, so classifying it as supported would let
+// an RSAMD5 DS RRset appear usable and then bogus out on verification
+// instead of downgrading to insecure; a Policy cannot enable it.
 func IsSupportedDNSKEYAlgorithm(alg uint8) bool {
-	switch alg {
-	case dns.RSASHA1,
-		dns.RSASHA1NSEC3SHA1,
-		dns.RSASHA256,
-		dns.RSASHA512,
-		dns.ECDSAP256SHA256,
-		dns.ECDSAP384SHA384,
-		dns.ED25519:
-		return true
-	}
-	return false
+	return CurrentPolicy().Algorithm(alg)
 }
 
 // IsSupportedDS reports whether a DS record is usable for validation:
@@ -669,6 +655,7 @@ func verifyOneSigWithWork(
 	}
 
 	if !IsSupportedDNSKEYAlgorithm(sig.Algorithm) {
+		observeValidation(sig.Algorithm, validationUnsupported)
 		return dns.ErrAlg
 	}
 	if !signatureMatchesRRset(sig, set) {
@@ -769,7 +756,13 @@ func runSignatureVerification(
 	if release != nil {
 		defer release()
 	}
-	return cryptoVerify(key, sig, set)
+	err = cryptoVerify(key, sig, set)
+	if err != nil {
+		observeValidation(key.Algorithm, validationInvalid)
+	} else {
+		observeValidation(key.Algorithm, validationValid)
+	}
+	return err
 }
 
 // cryptoVerify runs the cryptographic RRSIG check for a single candidate key,
@@ -6,6 +6,8 @@ import (
 	"testing"
 
 	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/middleware/resolver/dnssec"
 )
 
 // TestADBitWithCDFlag verifies that AD bit is not set when CD flag is set.
@@ -356,3 +358,19 @@ func Test_isZoneSecureIntegration(t *testing.T) {
 		})
 	}
 }
+
+func TestInstallDNSSECPolicyRejectsUnimplemented(t *testing.T) {
+	t.Cleanup(func() { dnssec.SetPolicy(nil) })
+
+	if err := installDNSSECPolicy(config.DNSSECPolicyConfig{Algorithms: []string{"RSASHA256"}}); err != nil {
+		t.Fatal(err)
+	}
+	// A table the validator cannot honour is an error for the caller to
+	// report, not an exit, and leaves the installed table as it was.
+	if err := installDNSSECPolicy(config.DNSSECPolicyConfig{Digests: []string{"GOST94"}}); err == nil {
+		t.Fatal("installDNSSECPolicy accepted GOST94")
+	}
+	if got := dnssec.CurrentPolicy().Algorithms(); len(got) != 1 || got[0] != dns.RSASHA256 {
+		t.Errorf("policy algorithms = %v, want the one installed before", got)
+	}
+}
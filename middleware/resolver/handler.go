@@ -10,6 +10,7 @@ import (
 	"github.com/semihalev/sdns/internal/authority"
 	"github.com/semihalev/sdns/internal/cache"
 	"github.com/semihalev/sdns/internal/contextutil"
+	"github.com/semihalev/sdns/internal/debuglog"
 	"github.com/semihalev/sdns/internal/dnsutil"
 	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/zlog/v2"
@@ -49,7 +50,8 @@ const contextKeyNSList contextKey = 1 << 16
 // (legitimate DNAME chains are nearly always length 1).
 const maxDnameDepth = 10
 
-// debugns is initialized once at startup.
+// debugns is initialized once at startup; the API can switch it on at
+// runtime as well (debuglog.DebugNS).
 var debugns = func() bool {
 	_, ok := os.LookupEnv("SDNS_DEBUGNS")
 	return ok
@@ -65,10 +67,13 @@ func New(cfg *config.Config) *DNSHandler {
 		cfg.QueryTimeout.Duration = 10 * time.Second
 	}
 
-	return &DNSHandler{
+	h := &DNSHandler{
 		resolver: NewResolver(cfg),
 		cfg:      cfg,
 	}
+	slowestAuthoritySource.Store(h.resolver)
+
+	return h
 }
 
 // (*DNSHandler).Name name return middleware name.
@@ -76,6 +81,13 @@ func (h *DNSHandler) Name() string { return name }
 
 // (*DNSHandler).ServeDNS serveDNS implements the Handle interface.
 func (h *DNSHandler) ServeDNS(ctx context.Context, ch *middleware.Chain) {
+	// The cache refuses these first in the standard chain; this holds
+	// the line for a chain without one, or a query that got past it.
+	if ch.RecursionRefused() {
+		ch.CancelWithRcode(dns.RcodeRefused, false)
+		return
+	}
+
 	// Skip resolver if forwarders are configured
 	if len(h.cfg.ForwarderServers) > 0 {
 		ch.Next(ctx)
@@ -127,7 +139,7 @@ func (h *DNSHandler) handle(ctx context.Context, req *dns.Msg) *dns.Msg {
 	}
 
 	// CHAOS queries: debug nameserver stats (HINFO) or cache purge (NULL)
-	if debugns && q.Qclass == dns.ClassCHAOS && q.Qtype == dns.TypeHINFO {
+	if q.Qclass == dns.ClassCHAOS && q.Qtype == dns.TypeHINFO && (debugns || debuglog.DebugNS()) {
 		return h.nsStats(req)
 	}
 
@@ -195,6 +207,15 @@ func (h *DNSHandler) handle(ctx context.Context, req *dns.Msg) *dns.Msg {
 			dns.ExtendedErrorCodeNotAuthoritative, "Upstream server is not authoritative for zone")
 	}
 
+	// RFC 8509 root key sentinel: a validated answer under an is-ta /
+	// not-ta label becomes SERVFAIL when the named key tag contradicts the
+	// trust anchor store. CD=1 clients asked for no validation, so they get
+	// the plain answer.
+	if h.resolver.dnssec && !originalCD && h.cfg.RFC8509Enabled() &&
+		h.resolver.rootKeySentinelFails(q, resp) {
+		return dnsutil.SetRcode(req, dns.RcodeServerFailure, do)
+	}
+
 	return resp
 }
 
@@ -292,6 +313,38 @@ func (h *DNSHandler) Purge(q dns.Question) {
 	h.resolver.delegations.Remove(cache.Key(nsQuestion, true))
 }
 
+// (*DNSHandler).PurgeMatching removes the cached delegations of every zone
+// the scope covers. A delegation is an NS set, so a scope of any other
+// single type leaves them alone. Implements middleware.Purger.
+func (h *DNSHandler) PurgeMatching(scope middleware.PurgeScope) int {
+	if scope.Qtype != 0 && scope.Qtype != dns.TypeNS {
+		return 0
+	}
+	if scope.Qclass != 0 && scope.Qclass != dns.ClassINET {
+		return 0
+	}
+	var zones []string
+	h.resolver.delegations.ForEach(func(d *authority.Delegation) bool {
+		if scope.Covers(d.Servers.Zone) {
+			zones = append(zones, d.Servers.Zone)
+		}
+		return true
+	})
+	for _, zone := range zones {
+		nsQuestion := dns.Question{Name: zone, Qtype: dns.TypeNS, Qclass: dns.ClassINET}
+		h.resolver.delegations.Remove(cache.Key(nsQuestion, false))
+		h.resolver.delegations.Remove(cache.Key(nsQuestion, true))
+	}
+	return len(zones)
+}
+
+// (*DNSHandler).Ready reports whether root priming has succeeded. With
+// forwarders configured the resolver passes every query on, and readiness
+// is the forwarder's to report. Implements middleware.Readier.
+func (h *DNSHandler) Ready() bool {
+	return len(h.cfg.ForwarderServers) > 0 || h.resolver.primed.Load()
+}
+
 // (*DNSHandler).SetStore installs the cache store used by subQuery
 // for internal DNSSEC record lookups. Auto-wired during
 // middleware.Setup via middleware.StoreSetter.
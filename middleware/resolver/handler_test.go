@@ -329,3 +329,30 @@ func Test_withQueryDeadline_LazyParentAllocsNothing(t *testing.T) {
 		t.Errorf("%s: allocs = %v, want 0", "deriving the query deadline under a server-bounded parent must not allocate", allocs)
 	}
 }
+
+// A listener that refuses recursion is refused by the resolver itself, in
+// a chain with no cache ahead of it.
+func Test_HandlerRefusesRecursionPerListener(t *testing.T) {
+	h := newHermeticNet(t).Handler()
+
+	req := new(dns.Msg)
+	req.SetQuestion(".", dns.TypeNS)
+
+	mw := mock.NewWriter("udp", "203.0.113.7:5353")
+	ch := middleware.NewChain([]middleware.Handler{h})
+	mw.Policy = &middleware.ListenerPolicy{Name: "public", RefuseRecursion: true}
+	ch.Reset(mw, req)
+	ch.Next(context.Background())
+	if !mw.Written() || mw.Rcode() != dns.RcodeRefused {
+		t.Fatalf("rcode = %d, want REFUSED", mw.Rcode())
+	}
+
+	mw = mock.NewWriter("udp", "203.0.113.7:5353")
+	ch = middleware.NewChain([]middleware.Handler{h})
+	mw.Policy = &middleware.ListenerPolicy{Name: "lan"}
+	ch.Reset(mw, req)
+	ch.Next(context.Background())
+	if !mw.Written() || mw.Rcode() == dns.RcodeRefused {
+		t.Fatalf("a listener allowing recursion got rcode %d", mw.Rcode())
+	}
+}
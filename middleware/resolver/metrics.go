@@ -3,6 +3,11 @@ package resolver
 import (
 	"context"
 	"errors"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
@@ -98,8 +103,196 @@ var (
 	taRefreshQueryError       = trustAnchorRefresh.Register("query_error")
 	taRefreshValidationError  = trustAnchorRefresh.Register("validation_error")
 	taRefreshPersistenceError = trustAnchorRefresh.Register("persistence_error")
+
+	// Root trust anchors by RFC 5011 state, set after every refresh and
+	// every management change. A rollover in flight shows as pending > 0
+	// for the 30-day add hold-down; valid dropping to zero, or missing
+	// rising, is the alert. revoked counts tombstones, which are permanent.
+	trustAnchorStates = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dns_trust_anchors",
+		Help: "Root trust anchors by RFC 5011 state",
+	}, []string{"state"})
+
+	// RFC 8145 key-tag signals. The root operators count the queries, not
+	// the answers, so "sent" means an authority was reached at all; the
+	// NXDOMAIN it answers with is expected and not inspected.
+	trustAnchorSignals = metric.NewCounterVec(nil, prometheus.CounterOpts{
+		Name: "dns_trust_anchor_signal_total",
+		Help: "RFC 8145 key-tag queries to the root by result",
+	}, []string{"result"})
+
+	taSignalSent   = trustAnchorSignals.Register("sent")
+	taSignalFailed = trustAnchorSignals.Register("failed")
+
+	// RFC 8509 sentinel answers. "servfail" is the sentinel speaking, not
+	// a resolution failure: those never reach the sentinel check.
+	rootKeySentinels = metric.NewCounterVec(nil, prometheus.CounterOpts{
+		Name: "dns_root_key_sentinel_total",
+		Help: "RFC 8509 root key sentinel queries by label and result",
+	}, []string{"label", "result"})
+
+	sentinelIsTAPass      = rootKeySentinels.Register("is_ta", "pass")
+	sentinelIsTAServfail  = rootKeySentinels.Register("is_ta", "servfail")
+	sentinelNotTAPass     = rootKeySentinels.Register("not_ta", "pass")
+	sentinelNotTAServfail = rootKeySentinels.Register("not_ta", "servfail")
+
+	// 0x20 echo failures: a reply that matched ID, port and question but
+	// not the randomized spelling. A steady trickle is case-folding
+	// authorities being learned; a burst is someone guessing the rest.
+	qnameCaseMismatches = metric.NewCounter(nil, prometheus.CounterOpts{
+		Name: "dns_qname_case_mismatch_total",
+		Help: "Upstream replies whose question did not echo the 0x20-randomized name",
+	})
 )
 
+// slowestAuthoritySource is the resolver the slowest-servers summary
+// reads at scrape time: the one the running middleware chain was built
+// with.
+var slowestAuthoritySource atomic.Pointer[Resolver]
+
+// slowestAuthorityCollector exports a summary of the exchange round trips
+// of the slowest authoritative servers: the quantiles of their recent
+// samples, and the count and sum of every sample since they were picked.
+// Which servers those are is decided on each scrape, by smoothed RTT, so
+// a server that drops out of the top N stops being reported instead of
+// lingering at its last value, and the series count stays at N however
+// many zones the resolver has walked.
+//
+// Samples are kept only for the servers the last scrape picked — the
+// exchange path does one map read for the rest — so a server's count and
+// sum start when it enters the top N, and start over if it leaves and
+// comes back, which rate() takes as a counter reset.
+type slowestAuthorityCollector struct {
+	desc *prometheus.Desc
+
+	mu      sync.Mutex // serializes scrapes
+	tracked atomic.Pointer[map[string]*rttWindow]
+}
+
+var slowestAuthorityRTT = &slowestAuthorityCollector{
+	desc: prometheus.NewDesc("dns_authority_slowest_rtt_seconds",
+		"Exchange round trips of the slowest measured authoritative servers, by zone and address",
+		[]string{"zone", "server"}, nil),
+}
+
+// slowestAuthorityQuantiles are the quantiles each summary reports.
+var slowestAuthorityQuantiles = []float64{0.5, 0.9, 0.99}
+
+func (c *slowestAuthorityCollector) Describe(ch chan<- *prometheus.Desc) { ch <- c.desc }
+
+func (c *slowestAuthorityCollector) Collect(ch chan<- prometheus.Metric) {
+	r := slowestAuthoritySource.Load()
+	if r == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	top := r.slowestAuthorities(slowestAuthoritiesExported)
+	prev := c.tracked.Load()
+	next := make(map[string]*rttWindow, len(top))
+	for _, s := range top {
+		w := next[s.addr]
+		if w == nil && prev != nil {
+			w = (*prev)[s.addr]
+		}
+		if w == nil {
+			w = new(rttWindow)
+		}
+		next[s.addr] = w
+
+		count, sum, quantiles := w.summary(slowestAuthorityQuantiles)
+		ch <- prometheus.MustNewConstSummary(c.desc, count, sum, quantiles, s.zone, s.addr)
+	}
+	c.tracked.Store(&next)
+}
+
+// observe records the round trip an exchange with addr was charged, if
+// addr is one of the servers being summarized.
+func (c *slowestAuthorityCollector) observe(addr string, rtt time.Duration) {
+	tracked := c.tracked.Load()
+	if tracked == nil {
+		return
+	}
+	if w := (*tracked)[addr]; w != nil {
+		w.observe(rtt)
+	}
+}
+
+// rttWindowSize is how many recent samples a summary's quantiles are
+// taken over.
+const rttWindowSize = 64
+
+// rttWindow is one server's samples: the most recent rttWindowSize in a
+// ring, and the count and sum of all of them.
+type rttWindow struct {
+	mu      sync.Mutex
+	samples [rttWindowSize]float64
+	count   uint64
+	sum     float64
+}
+
+func (w *rttWindow) observe(rtt time.Duration) {
+	v := rtt.Seconds()
+	w.mu.Lock()
+	w.samples[w.count%rttWindowSize] = v
+	w.count++
+	w.sum += v
+	w.mu.Unlock()
+}
+
+// summary returns the count, the sum and the given quantiles of the
+// window's samples; with none, the quantiles are NaN, as a summary with
+// no observations reports them.
+func (w *rttWindow) summary(qs []float64) (uint64, float64, map[float64]float64) {
+	w.mu.Lock()
+	count, sum := w.count, w.sum
+	recent := slices.Clone(w.samples[:min(count, rttWindowSize)])
+	w.mu.Unlock()
+
+	slices.Sort(recent)
+	quantiles := make(map[float64]float64, len(qs))
+	for _, q := range qs {
+		if len(recent) == 0 {
+			quantiles[q] = math.NaN()
+			continue
+		}
+		quantiles[q] = recent[int(q*float64(len(recent)-1))]
+	}
+	return count, sum, quantiles
+}
+
+func init() {
+	prometheus.MustRegister(trustAnchorStates)
+	prometheus.MustRegister(slowestAuthorityRTT)
+}
+
+// observeTrustAnchorStates publishes the per-state gauge from a state
+// table and its tombstones. Revoked markers still in the table are
+// tombstones whose write has not landed yet, so they count as revoked.
+func observeTrustAnchorStates(anchors TrustAnchors, tombstones Tombstones) {
+	var pending, valid, missing int
+	revoked := len(tombstones)
+	for _, ta := range anchors {
+		switch ta.State {
+		case StateAddPend:
+			pending++
+		case StateValid:
+			valid++
+		case StateMissing:
+			missing++
+		case StateRevoked:
+			if _, ok := tombstones[dnskeyMaterialFP(ta.DNSKey)]; !ok {
+				revoked++
+			}
+		}
+	}
+	trustAnchorStates.WithLabelValues("pending").Set(float64(pending))
+	trustAnchorStates.WithLabelValues("valid").Set(float64(valid))
+	trustAnchorStates.WithLabelValues("missing").Set(float64(missing))
+	trustAnchorStates.WithLabelValues("revoked").Set(float64(revoked))
+}
+
 // classifyResolverErr increments the appropriate counter for a non-
 // nil resolver error. Timeout is checked first since context errors
 // don't carry an EDE code by themselves. DNSSEC-specific EDE codes
@@ -19,8 +19,10 @@ import (
 	"github.com/semihalev/sdns/internal/authority"
 	"github.com/semihalev/sdns/internal/cache"
 	"github.com/semihalev/sdns/internal/contextutil"
+	"github.com/semihalev/sdns/internal/debuglog"
 	"github.com/semihalev/sdns/internal/dnsname"
 	"github.com/semihalev/sdns/internal/dnsutil"
+	"github.com/semihalev/sdns/internal/tracing"
 	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/sdns/middleware/resolver/dnssec"
 	"github.com/semihalev/zlog/v2"
@@ -68,6 +70,9 @@ type Resolver struct {
 	// key which has aged out of the RFC 5011 lifecycle can't be
 	// resurrected from the mutable copy on the next refresh.
 	configuredRootKeys []dns.RR
+	// taMu serialises the RFC 5011 state files: AutoTA and the trust
+	// anchor management calls each read, change and rewrite them whole.
+	taMu sync.Mutex
 
 	qnameMinLevel int
 	netTimeout    time.Duration
@@ -107,6 +112,10 @@ type Resolver struct {
 	// apply. Auto-wired via QueryerSetter. Same atomic.Pointer
 	// reasoning as store.
 	queryer atomic.Pointer[middleware.Queryer]
+
+	// primed is set once a root priming query has succeeded; until then
+	// the resolver works from the configured hints.
+	primed atomic.Bool
 }
 
 // resolveState holds the state for a DNS resolution operation.
@@ -214,6 +223,10 @@ const (
 	defaultCacheSize = 1024 * 256
 	defaultTimeout   = 2 * time.Second
 
+	// primingRetry is how soon a failed first priming is tried again;
+	// once primed, the root list is refreshed every 12 hours.
+	primingRetry = 30 * time.Second
+
 	// maxInflightProbes caps the exploration probes allowed to outlive the
 	// lookup that started them.
 	//
@@ -234,6 +247,20 @@ const (
 	probeGrace = 250 * time.Millisecond
 )
 
+// installDNSSECPolicy builds the validator's algorithm table from c and
+// installs it. A loaded config has passed the same check in Validate, so
+// an error here means a config built in code; the table installed before
+// stays — the default on a fresh process, which validates more zones,
+// not fewer.
+func installDNSSECPolicy(c config.DNSSECPolicyConfig) error {
+	policy, err := dnssec.NewPolicy(c.Algorithms, c.Digests, c.SHA1Allowed())
+	if err != nil {
+		return err
+	}
+	dnssec.SetPolicy(policy)
+	return nil
+}
+
 // NewResolver return a resolver.
 func NewResolver(cfg *config.Config) *Resolver {
 	workPolicy := middleware.MustRecursionWorkPolicyFromConfig(cfg.RecursionFirewall)
@@ -288,6 +315,10 @@ func NewResolver(cfg *config.Config) *Resolver {
 	r.parseRootServers(cfg)
 	r.parseOutBoundAddrs(cfg)
 
+	if err := installDNSSECPolicy(cfg.DNSSECPolicy); err != nil {
+		zlog.Error("DNSSEC policy invalid, keeping the current one", zlog.String("error", err.Error()))
+	}
+
 	r.rootKeys = []dns.RR{}
 	for _, k := range cfg.RootKeys {
 		rr, err := dns.NewRR(k)
@@ -440,6 +471,9 @@ func (r *Resolver) resolve(ctx context.Context, rs *resolveState) (*dns.Msg, err
 		// delegation established below it inherits this bound.
 		rs.cutDeadline, rs.cutKey = minCut(rs.cutDeadline, rs.cutKey, m.deadline, m.key)
 		noteCut(ctx, rs.cutDeadline, rs.cutKey)
+		if m.key != 0 && debugLogEnabled() {
+			debuglog.Log(ctx, debuglog.Resolver, "Nameserver cache hit", "key", m.key, "zone", m.servers.Zone, "cd", rs.req.CheckingDisabled)
+		}
 	}
 
 	// RFC 7816 query minimization. There are some concerns in RFC.
@@ -447,7 +481,7 @@ func (r *Resolver) resolve(ctx context.Context, rs *resolveState) (*dns.Msg, err
 	minReq, minimized := r.minimize(rs.req, rs.level, rs.nomin)
 
 	if debugLogEnabled() {
-		zlog.Debug("Query inserted", "reqid", minReq.Id, "zone", rs.servers.Zone, "query", dnsutil.FormatQuestion(minReq.Question[0]), "cd", rs.req.CheckingDisabled, "qname-minimize", minimized)
+		debuglog.Log(ctx, debuglog.Resolver, "Query inserted", "reqid", minReq.Id, "zone", rs.servers.Zone, "query", dnsutil.FormatQuestion(minReq.Question[0]), "cd", rs.req.CheckingDisabled, "qname-minimize", minimized)
 	}
 
 	resp, err := r.groupLookup(ctx, rs, minReq, rs.servers, minimized)
@@ -1328,7 +1362,7 @@ func (r *Resolver) authority(ctx context.Context, req, resp *dns.Msg, parentDS [
 								// authoritative. RFC 8198 reuse is stricter,
 								// so any evaluator miss only withholds shared
 								// provenance.
-								zlog.Debug("NSEC3 proof not eligible for aggressive reuse",
+								debuglog.Log(ctx, debuglog.DNSSEC, "NSEC3 proof not eligible for aggressive reuse",
 									"query", dnsutil.FormatQuestion(q), "error", err, "classified_rcode", result.Rcode)
 							}
 						}
@@ -1350,7 +1384,7 @@ func (r *Resolver) authority(ctx context.Context, req, resp *dns.Msg, parentDS [
 						if err == nil && result.Rcode == resp.Rcode {
 							aggressiveEligible = true
 						} else {
-							zlog.Debug("NSEC proof not eligible for aggressive reuse",
+							debuglog.Log(ctx, debuglog.DNSSEC, "NSEC proof not eligible for aggressive reuse",
 								"query", dnsutil.FormatQuestion(q), "error", err, "classified_rcode", result.Rcode)
 						}
 					default:
@@ -1496,6 +1530,7 @@ mainloop:
 				}
 
 				resp = res.resp
+				middleware.NoteUpstream(ctx, req.Question[0], res.server.Addr)
 
 				if resp.Rcode != dns.RcodeSuccess {
 					responseErrors = append(responseErrors, resp)
@@ -1652,6 +1687,22 @@ func isProbe(ctx context.Context) bool {
 	return on
 }
 
+// plainCase marks the retry of an exchange whose reply folded a 0x20
+// spelling. The strike has been counted against the server once; the
+// retry, and whatever fallbacks follow it, go out in the resolver's own
+// spelling so that one query can neither strike the server twice nor
+// lose its answer to a server that folds case.
+type plainCase struct{}
+
+func withPlainCase(ctx context.Context) context.Context {
+	return context.WithValue(ctx, plainCase{}, true)
+}
+
+func isPlainCase(ctx context.Context) bool {
+	on, _ := ctx.Value(plainCase{}).(bool)
+	return on
+}
+
 // retainForProbe holds the request tree's work ledger open across an
 // attempt that outlives the lookup, the same way the detached IPv6
 // enrichment holds it. Detaching the context carries the ledger through
@@ -1782,7 +1833,7 @@ func (r *Resolver) queryServer(ctx context.Context, rs *resolveState, interrupts
 			// request context itself is still live, and that is genuine
 			// upstream health evidence.
 		case err != nil:
-			r.circuitBreaker.recordFailure(server.Addr)
+			r.circuitBreaker.recordError(server.Addr, err)
 		case resp != nil:
 			// Any response from the authority — NOERROR,
 			// NXDOMAIN, NODATA — proves the server is
@@ -1880,6 +1931,13 @@ func pickFallbackResponse(responseErrors, configErrors []*dns.Msg, fatalErrors [
 }
 
 func (r *Resolver) exchange(ctx context.Context, rs *resolveState, interrupts *InterruptGroup, proto string, req *dns.Msg, server *authority.Server, retried int) (*dns.Msg, error) {
+	if tracing.Enabled() {
+		return r.exchangeTraced(ctx, rs, interrupts, proto, req, server, retried)
+	}
+	return r.exchangeOnce(ctx, rs, interrupts, proto, req, server, retried)
+}
+
+func (r *Resolver) exchangeOnce(ctx context.Context, rs *resolveState, interrupts *InterruptGroup, proto string, req *dns.Msg, server *authority.Server, retried int) (*dns.Msg, error) {
 	if ctxErr := contextutil.EffectiveError(ctx); ctxErr != nil {
 		return nil, ctxErr
 	}
@@ -1937,6 +1995,9 @@ func (r *Resolver) exchange(ctx context.Context, rs *resolveState, interrupts *I
 			return
 		}
 		observed = true
+		// charged is what the ranking was told the exchange cost, and
+		// what the slowest-servers summary samples.
+		charged := rtt
 		switch {
 		case err != nil || resp == nil:
 			// Nothing came back. Only an answer is worth what it took, and
@@ -1946,7 +2007,8 @@ func (r *Resolver) exchange(ctx context.Context, rs *resolveState, interrupts *I
 			// whatever the outcome, so scoring these by the clock made the
 			// one address in a delegation that serves nothing into its
 			// permanent leader. Not answering is worth a timeout.
-			server.ObserveNoAnswer(r.netTimeout)
+			charged = r.netTimeout
+			server.ObserveNoAnswer(charged)
 
 		case answeredTheQuestion(resp.Rcode):
 			server.Observe(rtt)
@@ -1964,8 +2026,10 @@ func (r *Resolver) exchange(ctx context.Context, rs *resolveState, interrupts *I
 			server.Observe(rtt)
 
 		default:
-			server.ObserveNoAnswer(r.netTimeout)
+			charged = r.netTimeout
+			server.ObserveNoAnswer(charged)
 		}
+		slowestAuthorityRTT.observe(server.Addr, charged)
 	}
 	defer record()
 
@@ -1984,7 +2048,7 @@ func (r *Resolver) exchange(ctx context.Context, rs *resolveState, interrupts *I
 		if isRoot || isTLD {
 			pooledConn = r.tcpPool.Get(server.Addr, isRoot, isTLD)
 			if pooledConn != nil {
-				zlog.Debug("Using pooled TCP connection", "server", server.Addr, "isRoot", isRoot, "isTLD", isTLD)
+				debuglog.Log(ctx, debuglog.Resolver, "Using pooled TCP connection", "server", server.Addr, "isRoot", isRoot, "isTLD", isTLD)
 			}
 		}
 	}
@@ -2012,7 +2076,7 @@ func (r *Resolver) exchange(ctx context.Context, rs *resolveState, interrupts *I
 		// the allocation noise on every upstream query.
 		co.Conn, err = r.dialUDP(server)
 		if err != nil {
-			zlog.Debug("Dial failed to upstream server", "query", dnsutil.FormatQuestion(q), "upstream", server.Addr,
+			debuglog.Log(ctx, debuglog.Resolver, "Dial failed to upstream server", "query", dnsutil.FormatQuestion(q), "upstream", server.Addr,
 				"net", proto, "error", err.Error(), "retried", retried)
 			ReleaseConn(co)
 			return nil, err
@@ -2023,7 +2087,7 @@ func (r *Resolver) exchange(ctx context.Context, rs *resolveState, interrupts *I
 		co.Conn, err = d.DialContext(ctx, proto, dialAddr)
 		releaseDialer(d)
 		if err != nil {
-			zlog.Debug("Dial failed to upstream server", "query", dnsutil.FormatQuestion(q), "upstream", server.Addr,
+			debuglog.Log(ctx, debuglog.Resolver, "Dial failed to upstream server", "query", dnsutil.FormatQuestion(q), "upstream", server.Addr,
 				"net", proto, "error", err.Error(), "retried", retried)
 			ReleaseConn(co)
 			return nil, err
@@ -2046,13 +2110,55 @@ func (r *Resolver) exchange(ctx context.Context, rs *resolveState, interrupts *I
 	}
 	_ = co.SetDeadline(deadline)
 
+	// 0x20 is a UDP measure: it adds entropy against a blind off-path
+	// reply, and a TCP stream already has the handshake for that. The
+	// spelling lives only for this one exchange — q keeps the resolver's
+	// own, and every retry and fallback below draws a new one.
+	var sent string
+	if proto == "udp" && r.cfg.QnameCase0x20 && server.PreservesCase() && !isPlainCase(ctx) {
+		sent = randomizeCase(q.Name)
+		req.Question[0].Name = sent
+	}
+
 	resp, rtt, err = co.ExchangeInterruptible(ctx, interrupts, req)
+	if sent != "" {
+		req.Question[0].Name = q.Name
+		if err == nil && resp != nil {
+			if resp.Question[0].Name != sent {
+				err = errCaseMismatch
+			} else {
+				restoreQueryCase(resp, sent, q.Name)
+				server.NoteCasePreserved()
+			}
+		}
+	}
 	if ctxErr := contextutil.EffectiveError(ctx); ctxErr != nil {
 		resp = nil
 		err = ctxErr
 	}
+	if errors.Is(err, errCaseMismatch) {
+		// The reply got the ID, the port and the question right and only
+		// the spelling wrong. That is what a server that folds case does
+		// every time, and what a spoofer that guessed everything else does
+		// once. Both are answered the same way: the reply is dropped and
+		// the server is asked again without 0x20. Only the first costs the
+		// server its 0x20, and only after it has folded several queries in
+		// a row and for a while; one forged reply is a strike and nothing
+		// more. The counter is what tells the two apart — a burst is an
+		// attack, a trickle is the resolver learning its authorities. Like
+		// the FORMERR fallback, the attempt is replaced rather than scored.
+		debuglog.Log(ctx, debuglog.Resolver, "Upstream reply did not echo the query name case", "query", dnsutil.FormatQuestion(q),
+			"upstream", server.Addr, "sent", sent, "received", resp.Question[0].Name)
+		qnameCaseMismatches.Inc()
+		if server.NoteCaseMismatch() {
+			debuglog.Log(ctx, debuglog.Resolver, "Upstream marked case blind", "upstream", server.Addr)
+		}
+		ReleaseConn(co)
+		observed = true
+		return r.exchange(withPlainCase(ctx), rs, interrupts, proto, req, server, retried)
+	}
 	if err != nil {
-		zlog.Debug("Exchange failed for upstream server", "query", dnsutil.FormatQuestion(q), "upstream", server.Addr,
+		debuglog.Log(ctx, debuglog.Resolver, "Exchange failed for upstream server", "query", dnsutil.FormatQuestion(q), "upstream", server.Addr,
 			"net", proto, "rtt", rtt.Round(time.Millisecond).String(), "error", err.Error(), "retried", retried)
 
 		// Don't return connection to pool on error
@@ -2108,7 +2214,7 @@ func (r *Resolver) exchange(ctx context.Context, rs *resolveState, interrupts *I
 
 	if resp != nil && !resp.Truncated && proto == "udp" && resp.Len() > dnsutil.DefaultMsgSize && !isProbe(ctx) {
 		// If response is too large, switch to TCP
-		zlog.Debug("Response too large, switching to TCP", "query", dnsutil.FormatQuestion(q), "upstream", server.Addr,
+		debuglog.Log(ctx, debuglog.Resolver, "Response too large, switching to TCP", "query", dnsutil.FormatQuestion(q), "upstream", server.Addr,
 			"size", resp.Len(), "maxSize", dnsutil.DefaultMsgSize, "retried", retried)
 		record()
 		return r.exchange(ctx, rs, interrupts, "tcp", req, server, retried)
@@ -2289,9 +2395,6 @@ func (r *Resolver) searchCache(q dns.Question, cd bool, origin string) delegatio
 			q.Name = origin
 			return r.searchCache(q, cd, origin)
 		}
-		if debugLogEnabled() {
-			zlog.Debug("Nameserver cache hit", "key", key, "query", dnsutil.FormatQuestion(q), "cd", cd)
-		}
 		return delegationMatch{
 			servers:  ns.Servers,
 			parentDS: ns.DSSet,
@@ -2503,7 +2606,7 @@ func (r *Resolver) isZoneSecure(ctx context.Context, qname string, parentDS []dn
 	parentDS, err := r.findDS(ctx, "", probeName, parentDS, false)
 	if err != nil {
 		// On lookup error, fail closed (assume signed) for safety.
-		zlog.Debug("DS lookup failed during isZoneSecure, failing closed", "qname", qname, "error", err.Error())
+		debuglog.Log(ctx, debuglog.DNSSEC, "DS lookup failed during isZoneSecure, failing closed", "qname", qname, "error", err.Error())
 		return true
 	}
 
@@ -2640,7 +2743,7 @@ func hasSupportedDS(dsset []dns.RR) bool {
 }
 
 func (r *Resolver) lookupDS(ctx context.Context, qname string, cd bool) (msg *dns.Msg, err error) {
-	zlog.Debug("Lookup DS record", "qname", qname)
+	debuglog.Log(ctx, debuglog.DNSSEC, "Lookup DS record", "qname", qname)
 
 	dsReq := new(dns.Msg)
 	dsReq.SetQuestion(qname, dns.TypeDS)
@@ -2805,7 +2908,7 @@ func (r *Resolver) subQuery(ctx context.Context, req *dns.Msg) (*dns.Msg, error)
 }
 
 func (r *Resolver) lookupNSAddrV4(ctx context.Context, qname string, cd bool) (addrs []netip.Addr, err error) {
-	zlog.Debug("Lookup NS ipv4 address", "qname", qname)
+	debuglog.Log(ctx, debuglog.Resolver, "Lookup NS ipv4 address", "qname", qname)
 
 	if addrs, ok := r.getIPv4Cache(qname); ok {
 		return addrs, nil
@@ -2836,7 +2939,7 @@ func (r *Resolver) lookupNSAddrV4(ctx context.Context, qname string, cd bool) (a
 }
 
 func (r *Resolver) lookupNSAddrV6(ctx context.Context, qname string, cd bool) (addrs []netip.Addr, err error) {
-	zlog.Debug("Lookup NS ipv6 address", "qname", qname)
+	debuglog.Log(ctx, debuglog.Resolver, "Lookup NS ipv6 address", "qname", qname)
 
 	if addrs, ok := r.getIPv6Cache(qname); ok {
 		return addrs, nil
@@ -2887,7 +2990,7 @@ func (r *Resolver) lookupV4Nss(ctx context.Context, q dns.Question, authservers
 		ctx, loop := r.checkLoop(ctx, name, dns.TypeA)
 		if loop {
 			if _, ok := r.getIPv4Cache(name); !ok {
-				zlog.Debug("Looping during ns ipv4 lookup", "query", dnsutil.FormatQuestion(q), "ns", name)
+				debuglog.Log(ctx, debuglog.Resolver, "Looping during ns ipv4 lookup", "query", dnsutil.FormatQuestion(q), "ns", name)
 				continue
 			}
 		}
@@ -2926,10 +3029,10 @@ func (r *Resolver) lookupV4Nss(ctx context.Context, q dns.Question, authservers
 				// hostname must not prevent trying the delegation's other
 				// hostnames.
 				lastAttemptLimit = err
-				zlog.Debug("Lookup NS ipv4 address reached attempt limit", "query", dnsutil.FormatQuestion(q), "ns", name)
+				debuglog.Log(ctx, debuglog.Resolver, "Lookup NS ipv4 address reached attempt limit", "query", dnsutil.FormatQuestion(q), "ns", name)
 				continue
 			}
-			zlog.Debug("Lookup NS ipv4 address failed", "query", dnsutil.FormatQuestion(q), "ns", name, "error", err.Error())
+			debuglog.Log(ctx, debuglog.Resolver, "Lookup NS ipv4 address failed", "query", dnsutil.FormatQuestion(q), "ns", name, "error", err.Error())
 			continue
 		}
 
@@ -3002,7 +3105,7 @@ func (r *Resolver) lookupV6Nss(ctx context.Context, q dns.Question, authservers
 		ctx, loop := r.checkLoop(ctx, name, dns.TypeAAAA)
 		if loop {
 			if _, ok := r.getIPv6Cache(name); !ok {
-				zlog.Debug("Looping during ns ipv6 lookup", "query", dnsutil.FormatQuestion(q), "ns", name)
+				debuglog.Log(ctx, debuglog.Resolver, "Looping during ns ipv6 lookup", "query", dnsutil.FormatQuestion(q), "ns", name)
 				continue
 			}
 		}
@@ -3019,7 +3122,7 @@ func (r *Resolver) lookupV6Nss(ctx context.Context, q dns.Question, authservers
 			// limited) must not prevent subsequent NSs in the
 			// delegation from contributing IPv6 addresses. The IPv4
 			// path in lookupV4Nss uses the same continue semantic.
-			zlog.Debug("Lookup NS ipv6 address failed", "query", dnsutil.FormatQuestion(q), "ns", name, "error", err.Error())
+			debuglog.Log(ctx, debuglog.Resolver, "Lookup NS ipv6 address failed", "query", dnsutil.FormatQuestion(q), "ns", name, "error", err.Error())
 			continue
 		}
 
@@ -3121,7 +3224,14 @@ func (r *Resolver) verifyRootKeys(ctx context.Context, msg *dns.Msg) (bool, erro
 	return true, nil
 }
 
-func (r *Resolver) verifyDNSSEC(ctx context.Context, signer, signed string, resp *dns.Msg, parentdsRR []dns.RR) (ok bool, err error) {
+func (r *Resolver) verifyDNSSEC(ctx context.Context, signer, signed string, resp *dns.Msg, parentdsRR []dns.RR) (bool, error) {
+	if tracing.Enabled() {
+		return r.verifyDNSSECTraced(ctx, signer, signed, resp, parentdsRR)
+	}
+	return r.verifyDNSSECOnce(ctx, signer, signed, resp, parentdsRR)
+}
+
+func (r *Resolver) verifyDNSSECOnce(ctx context.Context, signer, signed string, resp *dns.Msg, parentdsRR []dns.RR) (ok bool, err error) {
 	keyReq := new(dns.Msg)
 	keyReq.SetQuestion(signer, dns.TypeDNSKEY)
 	keyReq.SetEdns0(dnsutil.DefaultMsgSize, true)
@@ -3185,7 +3295,7 @@ func (r *Resolver) verifyDNSSEC(ctx context.Context, signer, signed string, resp
 
 	unsupportedOnly, err := dnssec.VerifyDSWithWork(keys, parentdsRR, r.dnssecWork(ctx))
 	if err != nil {
-		zlog.Debug("DNSSEC DS verify failed", "signer", signer, "signed", signed, "error", err.Error(), "unsupported only", unsupportedOnly)
+		debuglog.Log(ctx, debuglog.DNSSEC, "DNSSEC DS verify failed", "signer", signer, "signed", signed, "error", err.Error(), "unsupported only", unsupportedOnly)
 		if unsupportedOnly {
 			// Every DS digest was unsupported — RFC 6840 §5.2
 			// requires treating the zone as if DNSSEC were absent
@@ -3211,7 +3321,7 @@ func (r *Resolver) verifyDNSSEC(ctx context.Context, signer, signed string, resp
 	}
 
 	if debugLogEnabled() {
-		zlog.Debug("DNSSEC verified", "signer", signer, "signed", signed, "query", dnsutil.FormatQuestion(resp.Question[0]))
+		debuglog.Log(ctx, debuglog.DNSSEC, "DNSSEC verified", "signer", signer, "signed", signed, "query", dnsutil.FormatQuestion(resp.Question[0]))
 	}
 
 	return true, nil
@@ -3245,7 +3355,7 @@ func (r *Resolver) equalServers(s1, s2 *authority.Servers) bool {
 	return s1.Fingerprint() == s2.Fingerprint()
 }
 
-func (r *Resolver) checkPriming() {
+func (r *Resolver) checkPriming() bool {
 	req := new(dns.Msg)
 	req.SetQuestion(rootzone, dns.TypeNS)
 	req.SetEdns0(dnsutil.DefaultMsgSize, true)
@@ -3261,12 +3371,12 @@ func (r *Resolver) checkPriming() {
 	resp, err := r.Resolve(ctx, req, r.rootServers, true, 5, 0, false, nil, true)
 	if err != nil {
 		zlog.Error("Root servers update failed", "error", err.Error())
-		return
+		return false
 	}
 
 	if r.dnssec && !resp.AuthenticatedData {
 		zlog.Error("Root servers update failed", "error", "not authenticated")
-		return
+		return false
 	}
 
 	// Count NS records and build a map of root server names
@@ -3279,10 +3389,10 @@ func (r *Resolver) checkPriming() {
 
 	if len(nsServers) == 0 {
 		zlog.Error("Root servers update failed", "error", "no NS records in response")
-		return
+		return false
 	}
 
-	zlog.Debug("Root priming response", "ns_count", len(nsServers), "answer", len(resp.Answer), "extra", len(resp.Extra))
+	debuglog.Log(context.Background(), debuglog.Resolver, "Root priming response", "ns_count", len(nsServers), "answer", len(resp.Answer), "extra", len(resp.Extra))
 
 	var tmpservers authority.Servers
 	foundServers := make(map[string]bool)
@@ -3327,12 +3437,12 @@ func (r *Resolver) checkPriming() {
 	// Verify we got addresses for at least some nameservers
 	if len(foundServers) == 0 {
 		zlog.Error("Root servers update failed", "error", "no A/AAAA records found for NS records")
-		return
+		return false
 	}
 
 	// Log a warning if we didn't get addresses for all nameservers (but continue)
 	if len(foundServers) < len(nsServers) {
-		zlog.Debug("Some root servers missing addresses", "ns_count", len(nsServers), "found", len(foundServers))
+		debuglog.Log(context.Background(), debuglog.Resolver, "Some root servers missing addresses", "ns_count", len(nsServers), "found", len(foundServers))
 	}
 
 	if len(tmpservers.List) >= len(r.rootServers.List) {
@@ -3341,10 +3451,11 @@ func (r *Resolver) checkPriming() {
 		r.rootServers.Checked = true
 		r.rootServers.InvalidateFingerprint()
 		r.rootServers.Unlock()
-		return
+		return true
 	}
 
 	zlog.Error("Root servers update failed", "error", "missing A/AAAA records")
+	return false
 }
 
 func (r *Resolver) run() {
@@ -3353,18 +3464,31 @@ func (r *Resolver) run() {
 		time.Sleep(50 * time.Millisecond)
 	}
 
-	r.checkPriming() // update root server list from priming query
-	if r.dnssec {
-		r.AutoTA() // RFC 5011 automated trust anchor updates
+	r.primed.Store(r.checkPriming()) // update root server list from priming query
+	r.maintainTrustAnchors()
+
+	for !r.primed.Load() {
+		time.Sleep(primingRetry)
+		r.primed.Store(r.checkPriming())
 	}
 
 	ticker := time.NewTicker(12 * time.Hour)
 
 	for range ticker.C {
 		r.checkPriming()
-		if r.dnssec {
-			r.AutoTA()
-		}
+		r.maintainTrustAnchors()
+	}
+}
+
+// maintainTrustAnchors runs the RFC 5011 refresh and then reports the
+// resulting trust set to the root with an RFC 8145 key-tag query.
+func (r *Resolver) maintainTrustAnchors() {
+	if !r.dnssec {
+		return
+	}
+	r.AutoTA() // RFC 5011 automated trust anchor updates
+	if r.cfg.RFC8145Enabled() {
+		r.signalTrustAnchors()
 	}
 }
 
@@ -3391,7 +3515,7 @@ func (r *Resolver) handleLookupError(ctx context.Context, err error, rs *resolve
 			return nil, err
 		}
 
-		zlog.Debug("Received network error from all servers", "query", dnsutil.FormatQuestion(minReq.Question[0]))
+		debuglog.Log(ctx, debuglog.Resolver, "Received network error from all servers", "query", dnsutil.FormatQuestion(minReq.Question[0]))
 
 		if atomic.AddUint32(&rs.servers.ErrorCount, 1) == 5 {
 			if ok := r.checkHosts(ctx, rs.servers); ok {
@@ -3631,7 +3755,7 @@ func (r *Resolver) processDelegation(ctx context.Context, rs *resolveState, resp
 	// must be a strict descendant of the queried zone AND an ancestor of the
 	// name we are resolving.
 	if !validReferral(nsInfo, rs.servers.Zone, rs.req.Question[0]) {
-		zlog.Debug("Rejecting non-progressing delegation", "zone", rs.servers.Zone, "referral", q.Name, "qname", rs.req.Question[0].Name)
+		debuglog.Log(ctx, debuglog.Resolver, "Rejecting non-progressing delegation", "zone", rs.servers.Zone, "referral", q.Name, "qname", rs.req.Question[0].Name)
 		return nil, errParentDetection
 	}
 
@@ -3715,7 +3839,7 @@ func (r *Resolver) processDelegation(ctx context.Context, rs *resolveState, resp
 	}
 
 	if debugLogEnabled() {
-		zlog.Debug("Nameserver cache not found", "key", key, "query", dnsutil.FormatQuestion(q), "cd", cd)
+		debuglog.Log(ctx, debuglog.Resolver, "Nameserver cache not found", "key", key, "query", dnsutil.FormatQuestion(q), "cd", cd)
 	}
 
 	// Check glue records and perform lookups
@@ -3745,7 +3869,7 @@ func (r *Resolver) processDelegation(ctx context.Context, rs *resolveState, resp
 		// is not cached (SetUntil skips it).
 		r.delegations.SetUntil(key, rs.parentDS, authservers, childDeadline)
 		if debugLogEnabled() {
-			zlog.Debug("Nameserver cache insert", "key", key, "query", dnsutil.FormatQuestion(q), "cd", cd)
+			debuglog.Log(ctx, debuglog.Resolver, "Nameserver cache insert", "key", key, "query", dnsutil.FormatQuestion(q), "cd", cd)
 		}
 	}
 
@@ -4011,7 +4135,7 @@ func (r *Resolver) validateDelegation(ctx context.Context, req, resp *dns.Msg, q
 // resolveWithCachedNameservers handles resolution with cached nameservers.
 func (r *Resolver) resolveWithCachedNameservers(ctx context.Context, rs *resolveState, cached *authority.Delegation, key uint64, q dns.Question, cd bool) (*dns.Msg, error) {
 	if debugLogEnabled() {
-		zlog.Debug("Nameserver cache hit", "key", key, "query", dnsutil.FormatQuestion(q), "cd", cd)
+		debuglog.Log(ctx, debuglog.Resolver, "Nameserver cache hit", "key", key, "query", dnsutil.FormatQuestion(q), "cd", cd)
 	}
 
 	if r.equalServers(cached.Servers, rs.servers) {
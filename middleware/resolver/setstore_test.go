@@ -64,3 +64,36 @@ func TestDNSHandlerPurge(t *testing.T) {
 		t.Fatal("Purge on TypeNS must evict CD=true entry")
 	}
 }
+
+// TestDNSHandlerPurgeMatching pins the subtree purge of the delegation
+// cache: zones at and below the name go, whatever the scope's type as
+// long as it could be NS, and unrelated zones stay.
+func TestDNSHandlerPurgeMatching(t *testing.T) {
+	h := &DNSHandler{resolver: &Resolver{delegations: authority.NewCache()}}
+
+	set := func(zone string) uint64 {
+		key := cache.Key(dns.Question{Name: zone, Qtype: dns.TypeNS, Qclass: dns.ClassINET}, false)
+		servers := new(authority.Servers)
+		servers.Zone = zone
+		h.resolver.delegations.Set(key, nil, servers, 60*time.Second)
+		return key
+	}
+	apex := set("example.com.")
+	child := set("sub.example.com.")
+	other := set("badexample.com.")
+
+	if n := h.PurgeMatching(middleware.PurgeScope{Name: "example.com.", Qtype: dns.TypeA, Subtree: true}); n != 0 {
+		t.Fatalf("A-only purge removed %d delegations, want 0", n)
+	}
+	if n := h.PurgeMatching(middleware.PurgeScope{Name: "example.com.", Subtree: true}); n != 2 {
+		t.Fatalf("subtree purge removed %d delegations, want 2", n)
+	}
+	for _, key := range []uint64{apex, child} {
+		if _, err := h.resolver.delegations.Get(key); err == nil {
+			t.Fatal("delegation under example.com survived the subtree purge")
+		}
+	}
+	if _, err := h.resolver.delegations.Get(other); err != nil {
+		t.Fatal("badexample.com delegation must survive")
+	}
+}
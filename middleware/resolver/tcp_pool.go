@@ -1,12 +1,13 @@
 package resolver
 
 import (
+	"context"
 	"net"
 	"sync"
 	"time"
 
 	"github.com/miekg/dns"
-	"github.com/semihalev/zlog/v2"
+	"github.com/semihalev/sdns/internal/debuglog"
 )
 
 // TCPConnPool manages persistent TCP connections to DNS servers.
@@ -154,7 +155,7 @@ func (p *TCPConnPool) Put(conn *dns.Conn, server string, isRoot, isTLD bool, msg
 				// connection and a retry.
 				if ka.Timeout == 0 {
 					conn.Close() //nolint:gosec // G104 - server asked for the close
-					zlog.Debug("TCP connection not pooled, server sent keepalive timeout 0",
+					debuglog.Log(context.Background(), debuglog.Resolver, "TCP connection not pooled, server sent keepalive timeout 0",
 						"server", server)
 					return
 				}
@@ -179,13 +180,13 @@ func (p *TCPConnPool) Put(conn *dns.Conn, server string, isRoot, isTLD bool, msg
 	if existing, ok := poolMap[server]; ok {
 		existing.lastUsed = time.Now()
 		conn.Close() //nolint:gosec // G104 - connection cleanup
-		zlog.Debug("TCP connection pool already warm for server, closing duplicate", "server", server)
+		debuglog.Log(context.Background(), debuglog.Resolver, "TCP connection pool already warm for server, closing duplicate", "server", server)
 		return
 	}
 	poolMap[server] = pooled
 	p.active++
 
-	zlog.Debug("TCP connection pooled", "server", server, "idle_timeout", pooled.idleTime,
+	debuglog.Log(context.Background(), debuglog.Resolver, "TCP connection pooled", "server", server, "idle_timeout", pooled.idleTime,
 		"supports_keepalive", pooled.supportsKA, "active_conns", p.active)
 }
 
@@ -223,7 +224,7 @@ func (p *TCPConnPool) cleanup() {
 			conn.Close() //nolint:gosec // G104 - connection cleanup
 			delete(p.rootConns, server)
 			p.active--
-			zlog.Debug("Cleaned up idle root connection", "server", server)
+			debuglog.Log(context.Background(), debuglog.Resolver, "Cleaned up idle root connection", "server", server)
 		}
 	}
 
@@ -233,7 +234,7 @@ func (p *TCPConnPool) cleanup() {
 			conn.Close() //nolint:gosec // G104 - connection cleanup
 			delete(p.tldConns, server)
 			p.active--
-			zlog.Debug("Cleaned up idle TLD connection", "server", server)
+			debuglog.Log(context.Background(), debuglog.Resolver, "Cleaned up idle TLD connection", "server", server)
 		}
 	}
 }
@@ -0,0 +1,57 @@
+package resolver
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/authority"
+	"github.com/semihalev/sdns/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// exchangeTraced is exchange under a span per attempt. A retry or a fall
+// back to TCP calls exchange again, so it shows as a child of the attempt
+// it replaced.
+func (r *Resolver) exchangeTraced(ctx context.Context, rs *resolveState, interrupts *InterruptGroup, proto string, req *dns.Msg, server *authority.Server, retried int) (*dns.Msg, error) {
+	q := req.Question[0]
+	ctx, span := tracing.Start(ctx, "resolver.exchange",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("server.address", server.Addr),
+			attribute.String("network.transport", proto),
+			attribute.String("dns.question.name", q.Name),
+			attribute.String("dns.question.type", dns.Type(q.Qtype).String()),
+			attribute.Int("sdns.retried", retried),
+		),
+	)
+	defer span.End()
+
+	resp, err := r.exchangeOnce(ctx, rs, interrupts, proto, req, server, retried)
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case resp != nil:
+		span.SetAttributes(attribute.String("dns.response.code", dns.RcodeToString[resp.Rcode]))
+	}
+	return resp, err
+}
+
+// verifyDNSSECTraced is verifyDNSSEC under a span, one per signer checked.
+func (r *Resolver) verifyDNSSECTraced(ctx context.Context, signer, signed string, resp *dns.Msg, parentdsRR []dns.RR) (bool, error) {
+	ctx, span := tracing.Start(ctx, "dnssec.verify", trace.WithAttributes(
+		attribute.String("dnssec.signer", signer),
+		attribute.String("dnssec.zone", signed),
+	))
+	defer span.End()
+
+	ok, err := r.verifyDNSSECOnce(ctx, signer, signed, resp, parentdsRR)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(attribute.Bool("dnssec.verified", ok))
+	return ok, err
+}
@@ -0,0 +1,474 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/dnsutil"
+	"github.com/semihalev/sdns/middleware"
+	"github.com/semihalev/sdns/middleware/resolver/dnssec"
+	"github.com/semihalev/zlog/v2"
+)
+
+// Trust anchor management errors. The API maps them to status codes, so
+// callers match them with errors.Is.
+var (
+	ErrTrustAnchorNotFound    = errors.New("trust anchor not found")
+	ErrTrustAnchorTombstoned  = errors.New("trust anchor is revoked")
+	ErrTrustAnchorInvalid     = errors.New("invalid trust anchor")
+	ErrTrustAnchorFetch       = errors.New("root DNSKEY fetch failed")
+	ErrTrustAnchorPersistence = errors.New("trust anchor state write failed")
+	ErrDNSSECDisabled         = errors.New("dnssec validation is off")
+)
+
+// TrustAnchorStatus is one root trust anchor as the management API
+// reports it. Revoked keys come from the tombstone store and are never
+// trusted again.
+type TrustAnchorStatus struct {
+	KeyTag    uint16 `json:"keytag"`
+	Algorithm string `json:"algorithm"`
+	State     string `json:"state"`
+	// Trusted reports whether the key is in the set validation uses now.
+	Trusted   bool      `json:"trusted"`
+	FirstSeen time.Time `json:"first_seen,omitzero"`
+	LastSeen  time.Time `json:"last_seen,omitzero"`
+	// HoldDownEnds is when a PENDING key becomes VALID, or a MISSING key
+	// is dropped, if nothing else happens first.
+	HoldDownEnds time.Time `json:"hold_down_ends,omitzero"`
+	DS           string    `json:"ds,omitempty"`
+}
+
+// TrustAnchors reports the RFC 5011 state of every root trust anchor,
+// ordered by key tag, followed by the revoked keys.
+func (h *DNSHandler) TrustAnchors() ([]TrustAnchorStatus, error) {
+	return h.resolver.trustAnchorStatus()
+}
+
+// ImportTrustAnchors adds root trust anchors from an IANA root-anchors.xml
+// document or from DS / DNSKEY records in zone-file format, and returns
+// the key tags it installed. See (*Resolver).importTrustAnchors.
+func (h *DNSHandler) ImportTrustAnchors(ctx context.Context, data []byte) ([]uint16, error) {
+	return h.resolver.importTrustAnchors(ctx, data)
+}
+
+// ForceTrustAnchorState moves a trust anchor into state by hand, skipping
+// the RFC 5011 timers. It is an emergency lever; see
+// (*Resolver).forceTrustAnchorState.
+func (h *DNSHandler) ForceTrustAnchorState(tag uint16, state string) error {
+	return h.resolver.forceTrustAnchorState(tag, state)
+}
+
+func (r *Resolver) trustAnchorStatus() ([]TrustAnchorStatus, error) {
+	r.taMu.Lock()
+	defer r.taMu.Unlock()
+
+	anchors, tombstones, err := r.loadTrustAnchorState()
+	if err != nil {
+		return nil, err
+	}
+	trusted := r.trustAnchorTags()
+
+	tags := make([]uint16, 0, len(anchors))
+	for tag := range anchors {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+
+	out := make([]TrustAnchorStatus, 0, len(anchors)+len(tombstones))
+	for _, tag := range tags {
+		ta := anchors[tag]
+		st := TrustAnchorStatus{
+			KeyTag:    tag,
+			Algorithm: dns.AlgorithmToString[ta.DNSKey.Algorithm],
+			State:     ta.State.String(),
+			Trusted:   (ta.State == StateValid || ta.State == StateMissing) && slices.Contains(trusted, tag),
+			FirstSeen: ta.FirstSeen,
+			LastSeen:  ta.LastSeen,
+		}
+		switch ta.State {
+		case StateAddPend:
+			st.HoldDownEnds = ta.FirstSeen.Add(addHoldDown)
+		case StateMissing:
+			st.HoldDownEnds = ta.FirstSeen.Add(removeHoldDown)
+		}
+		if ds := ta.DNSKey.ToDS(dns.SHA256); ds != nil {
+			st.DS = ds.String()
+		}
+		out = append(out, st)
+	}
+
+	revoked := make([]TrustAnchorStatus, 0, len(tombstones))
+	for _, tb := range tombstones {
+		revoked = append(revoked, TrustAnchorStatus{
+			KeyTag:    dnssec.KeyTag(tb.DNSKey),
+			Algorithm: dns.AlgorithmToString[tb.DNSKey.Algorithm],
+			State:     StateRevoked.String(),
+			FirstSeen: tb.FirstSeen,
+		})
+	}
+	slices.SortFunc(revoked, func(a, b TrustAnchorStatus) int { return int(a.KeyTag) - int(b.KeyTag) })
+
+	return append(out, revoked...), nil
+}
+
+// loadTrustAnchorState reads the RFC 5011 state and tombstone files. Before
+// the first refresh has written a state file, the configured anchors stand
+// in for it as VALID, which is what that refresh will record. Any other
+// read failure is returned, so a corrupt or unreadable file is never
+// overwritten with the configured anchors. The caller holds taMu.
+func (r *Resolver) loadTrustAnchorState() (TrustAnchors, Tombstones, error) {
+	tombstones, err := readTombstones(filepath.Join(r.cfg.Directory, tombstoneFile))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	anchors, err := readFromTAFile(filepath.Join(r.cfg.Directory, stateFile))
+	if err == nil {
+		return anchors, tombstones, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	anchors = make(TrustAnchors)
+	r.RLock()
+	rootKeys := append([]dns.RR(nil), r.rootKeys...)
+	r.RUnlock()
+	for _, rr := range rootKeys {
+		dnskey, ok := rr.(*dns.DNSKEY)
+		if !ok || dnskey.Flags&DNSKEYFlagKSK == 0 || dnskey.Flags&DNSKEYFlagRevoke != 0 {
+			continue
+		}
+		if _, tombstoned := tombstones[dnskeyMaterialFP(dnskey)]; tombstoned {
+			continue
+		}
+		anchors[dnssec.KeyTag(dnskey)] = &TrustAnchor{DNSKey: dnskey, State: StateValid}
+	}
+	return anchors, tombstones, nil
+}
+
+// saveTrustAnchorState writes tombstones before state, the order AutoTA
+// relies on, and publishes the VALID and MISSING keys as the live trust
+// set only once both are durable. The caller holds taMu.
+func (r *Resolver) saveTrustAnchorState(anchors TrustAnchors, tombstones Tombstones) error {
+	if err := writeTombstones(filepath.Join(r.cfg.Directory, tombstoneFile), tombstones); err != nil {
+		return fmt.Errorf("%w: %v", ErrTrustAnchorPersistence, err)
+	}
+	if err := writeToTAFile(filepath.Join(r.cfg.Directory, stateFile), anchors); err != nil {
+		return fmt.Errorf("%w: %v", ErrTrustAnchorPersistence, err)
+	}
+
+	rootKeys := []dns.RR{}
+	for _, ta := range anchors {
+		if ta.State == StateValid || ta.State == StateMissing {
+			rootKeys = append(rootKeys, ta.DNSKey)
+		}
+	}
+	if len(rootKeys) == 0 {
+		zlog.Warn("Trust anchor change left no trusted root key — DNSSEC validation will fail closed")
+	}
+	r.Lock()
+	r.rootKeys = rootKeys
+	r.Unlock()
+	observeTrustAnchorStates(anchors, tombstones)
+	return nil
+}
+
+// importTrustAnchors installs root KSKs as VALID, skipping the add
+// hold-down: the operator vouches for the file the way they vouch for
+// rootkeys in the config. Keys given in full (DNSKEY records, or a
+// root-anchors.xml entry carrying its PublicKey) must match any digest
+// given alongside them. A bare DS is resolved against the live root
+// DNSKEY RRset, and accepted only if the key it names signs that RRset.
+//
+// A tombstoned key is refused: RFC 5011 §2.1 makes revocation permanent,
+// and an import is not the place to undo it. Nothing is written unless
+// every anchor in data is acceptable.
+func (r *Resolver) importTrustAnchors(ctx context.Context, data []byte) ([]uint16, error) {
+	if !r.dnssec {
+		return nil, ErrDNSSECDisabled
+	}
+
+	digests, keys, err := parseTrustAnchorImport(data, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if len(digests) > 0 {
+		fetched, err := r.fetchKeysForDS(ctx, digests)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fetched...)
+	}
+
+	r.taMu.Lock()
+	defer r.taMu.Unlock()
+
+	anchors, tombstones, err := r.loadTrustAnchorState()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tags := make([]uint16, 0, len(keys))
+	for _, key := range keys {
+		tag := dnssec.KeyTag(key)
+		if _, tombstoned := tombstones[dnskeyMaterialFP(key)]; tombstoned {
+			return nil, fmt.Errorf("%w: key tag %d", ErrTrustAnchorTombstoned, tag)
+		}
+		if existing := anchors[tag]; existing != nil {
+			if dnskeyMaterialFP(existing.DNSKey) != dnskeyMaterialFP(key) {
+				return nil, fmt.Errorf("%w: key tag %d collides with a different anchor", ErrTrustAnchorInvalid, tag)
+			}
+			if existing.State != StateValid {
+				existing.State = StateValid
+				existing.FirstSeen = now
+			}
+		} else {
+			anchors[tag] = &TrustAnchor{DNSKey: key, State: StateValid, FirstSeen: now}
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := r.saveTrustAnchorState(anchors, tombstones); err != nil {
+		return nil, err
+	}
+	slices.Sort(tags)
+	tags = slices.Compact(tags)
+	zlog.Warn("Trust anchors imported", "keytags", fmt.Sprint(tags))
+	return tags, nil
+}
+
+// fetchKeysForDS finds the root KSK each DS names in the live root DNSKEY
+// RRset. The RRset is fetched without validation — the trust set may be
+// the very thing being repaired — so each match must prove itself by
+// signing the RRset it came in.
+func (r *Resolver) fetchKeysForDS(ctx context.Context, digests []*dns.DS) ([]*dns.DNSKEY, error) {
+	req := new(dns.Msg)
+	req.SetQuestion(rootzone, dns.TypeDNSKEY)
+	req.SetEdns0(dnsutil.DefaultMsgSize, true)
+	req.CheckingDisabled = true
+
+	ctx, cancel := context.WithTimeout(ctx, r.netTimeout)
+	defer cancel()
+	ctx, _ = middleware.EnsureRecursionWork(ctx, r.workPolicy)
+	defer middleware.FinishRecursionWork(ctx)
+
+	resp, err := r.Resolve(ctx, req, r.rootServers, true, 5, 0, false, nil, true)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTrustAnchorFetch, err)
+	}
+
+	keys := make([]*dns.DNSKEY, 0, len(digests))
+	for _, ds := range digests {
+		var match *dns.DNSKEY
+		for _, rr := range resp.Answer {
+			dnskey, ok := rr.(*dns.DNSKEY)
+			if !ok || dnskey.Flags&DNSKEYFlagKSK == 0 || dnskey.Flags&DNSKEYFlagRevoke != 0 {
+				continue
+			}
+			if dsMatches(dnskey, ds) {
+				match = dnskey
+				break
+			}
+		}
+		if match == nil {
+			return nil, fmt.Errorf("%w: no root DNSKEY matches DS %d", ErrTrustAnchorInvalid, ds.KeyTag)
+		}
+		ok, revocationOnly, err := verifyFetchedKeysWithWork([]dns.RR{match}, resp.Answer, r.dnssecWork(ctx))
+		if !ok || revocationOnly {
+			if err == nil {
+				err = dnssec.ErrMissingKSK
+			}
+			return nil, fmt.Errorf("%w: root DNSKEY RRset not signed by key %d: %v", ErrTrustAnchorInvalid, ds.KeyTag, err)
+		}
+		keys = append(keys, match)
+	}
+	return keys, nil
+}
+
+// forceTrustAnchorState sets a trust anchor's state by hand. VALID,
+// PENDING and MISSING restart the key's clock, so a forced PENDING serves
+// a fresh add hold-down. REVOKED tombstones the key for good. REMOVED
+// forgets it without a tombstone; a key still listed in rootkeys is merged
+// back in by the next refresh, so removing one for good means taking it
+// out of the config too.
+func (r *Resolver) forceTrustAnchorState(tag uint16, name string) error {
+	if !r.dnssec {
+		return ErrDNSSECDisabled
+	}
+
+	var state State
+	switch strings.ToUpper(name) {
+	case "VALID":
+		state = StateValid
+	case "PENDING":
+		state = StateAddPend
+	case "MISSING":
+		state = StateMissing
+	case "REVOKED":
+		state = StateRevoked
+	case "REMOVED":
+		state = StateRemoved
+	default:
+		return fmt.Errorf("%w: unknown state %q", ErrTrustAnchorInvalid, name)
+	}
+
+	r.taMu.Lock()
+	defer r.taMu.Unlock()
+
+	anchors, tombstones, err := r.loadTrustAnchorState()
+	if err != nil {
+		return err
+	}
+	ta := anchors[tag]
+	if ta == nil {
+		return fmt.Errorf("%w: key tag %d", ErrTrustAnchorNotFound, tag)
+	}
+
+	switch state {
+	case StateRevoked:
+		revoked := dns.Copy(ta.DNSKey).(*dns.DNSKEY)
+		revoked.Flags |= DNSKEYFlagRevoke
+		tombstones[dnskeyMaterialFP(revoked)] = &Tombstone{DNSKey: revoked, FirstSeen: time.Now()}
+		delete(anchors, tag)
+	case StateRemoved:
+		delete(anchors, tag)
+	default:
+		ta.State = state
+		ta.FirstSeen = time.Now()
+	}
+
+	if err := r.saveTrustAnchorState(anchors, tombstones); err != nil {
+		return err
+	}
+	zlog.Warn("Trust anchor state forced", "keytag", tag, "state", state.String())
+	return nil
+}
+
+// rootAnchorsXML is the IANA root-anchors.xml document (RFC 9718 §2).
+type rootAnchorsXML struct {
+	XMLName    xml.Name `xml:"TrustAnchor"`
+	Zone       string   `xml:"Zone"`
+	KeyDigests []struct {
+		ValidFrom  string `xml:"validFrom,attr"`
+		ValidUntil string `xml:"validUntil,attr"`
+		KeyTag     uint16 `xml:"KeyTag"`
+		Algorithm  uint8  `xml:"Algorithm"`
+		DigestType uint8  `xml:"DigestType"`
+		Digest     string `xml:"Digest"`
+		Flags      uint16 `xml:"Flags"`
+		PublicKey  string `xml:"PublicKey"`
+	} `xml:"KeyDigest"`
+}
+
+// parseTrustAnchorImport reads an import body. A document starting with
+// '<' is root-anchors.xml, whose KeyDigest entries outside their validFrom
+// / validUntil window at now are skipped; anything else is zone-file text
+// holding root DS and DNSKEY records. Keys come back whole; digests that
+// arrived without their key come back for the caller to resolve.
+func parseTrustAnchorImport(data []byte, now time.Time) ([]*dns.DS, []*dns.DNSKEY, error) {
+	var (
+		digests []*dns.DS
+		keys    []*dns.DNSKEY
+	)
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '<' {
+		var doc rootAnchorsXML
+		if err := xml.Unmarshal(trimmed, &doc); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrTrustAnchorInvalid, err)
+		}
+		if doc.Zone != rootzone {
+			return nil, nil, fmt.Errorf("%w: zone %q is not the root", ErrTrustAnchorInvalid, doc.Zone)
+		}
+		for _, kd := range doc.KeyDigests {
+			if !withinValidity(kd.ValidFrom, kd.ValidUntil, now) {
+				continue
+			}
+			ds := &dns.DS{
+				Hdr:        dns.RR_Header{Name: rootzone, Rrtype: dns.TypeDS, Class: dns.ClassINET},
+				KeyTag:     kd.KeyTag,
+				Algorithm:  kd.Algorithm,
+				DigestType: kd.DigestType,
+				Digest:     strings.ToUpper(strings.TrimSpace(kd.Digest)),
+			}
+			if kd.PublicKey == "" {
+				digests = append(digests, ds)
+				continue
+			}
+			key := &dns.DNSKEY{
+				Hdr:       dns.RR_Header{Name: rootzone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 172800},
+				Flags:     kd.Flags,
+				Protocol:  3,
+				Algorithm: kd.Algorithm,
+				PublicKey: strings.Join(strings.Fields(kd.PublicKey), ""),
+			}
+			if !dsMatches(key, ds) {
+				return nil, nil, fmt.Errorf("%w: PublicKey of key tag %d does not match its digest", ErrTrustAnchorInvalid, kd.KeyTag)
+			}
+			keys = append(keys, key)
+		}
+	} else {
+		zp := dns.NewZoneParser(bytes.NewReader(data), rootzone, "")
+		for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+			if rr.Header().Name != rootzone {
+				return nil, nil, fmt.Errorf("%w: %s is not a root record", ErrTrustAnchorInvalid, rr.Header().Name)
+			}
+			switch rr := rr.(type) {
+			case *dns.DS:
+				digests = append(digests, rr)
+			case *dns.DNSKEY:
+				keys = append(keys, rr)
+			default:
+				return nil, nil, fmt.Errorf("%w: unexpected %s record", ErrTrustAnchorInvalid, dns.TypeToString[rr.Header().Rrtype])
+			}
+		}
+		if err := zp.Err(); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrTrustAnchorInvalid, err)
+		}
+	}
+
+	// A digest that names a key given in full is settled already.
+	digests = slices.DeleteFunc(digests, func(ds *dns.DS) bool {
+		return slices.ContainsFunc(keys, func(k *dns.DNSKEY) bool { return dsMatches(k, ds) })
+	})
+	for _, key := range keys {
+		if key.Flags&DNSKEYFlagKSK == 0 || key.Flags&DNSKEYFlagRevoke != 0 || key.Protocol != 3 {
+			return nil, nil, fmt.Errorf("%w: key tag %d is not an unrevoked KSK", ErrTrustAnchorInvalid, dnssec.KeyTag(key))
+		}
+	}
+	if len(digests) == 0 && len(keys) == 0 {
+		return nil, nil, fmt.Errorf("%w: no current root trust anchor in the input", ErrTrustAnchorInvalid)
+	}
+	return digests, keys, nil
+}
+
+// dsMatches reports whether ds is a digest of key.
+func dsMatches(key *dns.DNSKEY, ds *dns.DS) bool {
+	if key.Algorithm != ds.Algorithm || dnssec.KeyTag(key) != ds.KeyTag {
+		return false
+	}
+	computed := key.ToDS(ds.DigestType)
+	return computed != nil && strings.EqualFold(computed.Digest, ds.Digest)
+}
+
+// withinValidity applies a root-anchors.xml validity window. Timestamps
+// that fail to parse leave that side of the window open.
+func withinValidity(from, until string, now time.Time) bool {
+	if t, err := time.Parse(time.RFC3339, from); err == nil && now.Before(t) {
+		return false
+	}
+	if t, err := time.Parse(time.RFC3339, until); err == nil && !now.Before(t) {
+		return false
+	}
+	return true
+}
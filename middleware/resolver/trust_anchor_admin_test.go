@@ -0,0 +1,235 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/semihalev/sdns/config"
+)
+
+// rootAnchorsFixture follows IANA's published root-anchors.xml: the
+// retired 2010 KSK with its validUntil, then the 2017 and 2024 KSKs with
+// their public keys.
+var rootAnchorsFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<TrustAnchor id="E9724F53-1851-4F86-85E5-F1392102940B" source="http://data.iana.org/root-anchors/root-anchors.xml">
+<Zone>.</Zone>
+<KeyDigest id="Kjqmt7v" validFrom="2010-07-15T00:00:00+00:00" validUntil="2019-01-11T00:00:00+00:00">
+<KeyTag>19036</KeyTag>
+<Algorithm>8</Algorithm>
+<DigestType>2</DigestType>
+<Digest>49AAC11D7B6F6446702E54A1607371607A1A41855200FD2CE1CDDE32F24E8FB5</Digest>
+</KeyDigest>
+<KeyDigest id="Klajeyz" validFrom="2017-02-02T00:00:00+00:00">
+<KeyTag>20326</KeyTag>
+<Algorithm>8</Algorithm>
+<DigestType>2</DigestType>
+<Digest>E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D</Digest>
+<PublicKey>` + signalRootKeys[0][strings.LastIndexByte(signalRootKeys[0], ' ')+1:] + `</PublicKey>
+<Flags>257</Flags>
+</KeyDigest>
+<KeyDigest id="Kmyv6jo" validFrom="2024-07-18T00:00:00+00:00">
+<KeyTag>38696</KeyTag>
+<Algorithm>8</Algorithm>
+<DigestType>2</DigestType>
+<Digest>683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16</Digest>
+<PublicKey>` + signalRootKeys[1][strings.LastIndexByte(signalRootKeys[1], ' ')+1:] + `</PublicKey>
+<Flags>257</Flags>
+</KeyDigest>
+</TrustAnchor>`
+
+// adminTestResolver is a resolver with a state directory and no
+// background refresh, so the files change only when the test says so.
+func adminTestResolver(t *testing.T, rootKeys ...string) *Resolver {
+	t.Helper()
+	r := &Resolver{cfg: &config.Config{Directory: t.TempDir()}, dnssec: true}
+	for _, k := range rootKeys {
+		rr, err := dns.NewRR(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.rootKeys = append(r.rootKeys, rr)
+	}
+	return r
+}
+
+func TestParseTrustAnchorImport(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	digests, keys, err := parseTrustAnchorImport([]byte(rootAnchorsFixture), now)
+	if err != nil {
+		t.Fatalf("root-anchors.xml: %v", err)
+	}
+	if len(digests) != 0 || len(keys) != 2 || keys[0].KeyTag() != 20326 || keys[1].KeyTag() != 38696 {
+		t.Fatalf("root-anchors.xml = %d digests, %d keys; want the 20326 and 38696 keys", len(digests), len(keys))
+	}
+
+	// Before 2024-07-18 the 38696 entry is not valid yet; in 2018 the
+	// retired key is still inside its window, and has no key to go with it.
+	digests, keys, err = parseTrustAnchorImport([]byte(rootAnchorsFixture), time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || len(digests) != 1 || digests[0].KeyTag != 19036 {
+		t.Fatalf("2018 window = %d digests, %d keys; want DS 19036 and key 20326", len(digests), len(keys))
+	}
+
+	ds := ". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16\n"
+	digests, keys, err = parseTrustAnchorImport([]byte(ds+signalRootKeys[0]+"\n"), now)
+	if err != nil {
+		t.Fatalf("DS file: %v", err)
+	}
+	if len(digests) != 1 || digests[0].KeyTag != 38696 || len(keys) != 1 {
+		t.Fatalf("DS file = %d digests, %d keys; want one of each", len(digests), len(keys))
+	}
+
+	// A DS for a key given in full needs no lookup.
+	digests, _, err = parseTrustAnchorImport([]byte(ds+signalRootKeys[1]+"\n"), now)
+	if err != nil || len(digests) != 0 {
+		t.Fatalf("DS beside its own key = %d digests, %v; want 0, nil", len(digests), err)
+	}
+
+	bad := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"not the root", "example. IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16"},
+		{"other type", ". IN NS a.root-servers.net."},
+		{"zsk", strings.Replace(signalRootKeys[0], " 257 ", " 256 ", 1)},
+		{"public key does not match digest", strings.Replace(rootAnchorsFixture, "E06D44B8", "E06D44B9", 1)},
+		{"xml for another zone", strings.Replace(rootAnchorsFixture, "<Zone>.</Zone>", "<Zone>example.</Zone>", 1)},
+	}
+	for _, tt := range bad {
+		if _, _, err := parseTrustAnchorImport([]byte(tt.data), now); !errors.Is(err, ErrTrustAnchorInvalid) {
+			t.Errorf("%s: err = %v, want ErrTrustAnchorInvalid", tt.name, err)
+		}
+	}
+}
+
+func TestImportTrustAnchors(t *testing.T) {
+	r := adminTestResolver(t, signalRootKeys[0])
+
+	tags, err := r.importTrustAnchors(context.Background(), []byte(rootAnchorsFixture))
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if len(tags) != 2 || tags[0] != 20326 || tags[1] != 38696 {
+		t.Fatalf("imported %v, want [20326 38696]", tags)
+	}
+	if got := r.trustAnchorTags(); len(got) != 2 {
+		t.Fatalf("live trust set %v, want both keys", got)
+	}
+
+	status, err := r.trustAnchorStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range status {
+		if st.State != "VALID" || !st.Trusted || st.DS == "" {
+			t.Fatalf("after import: %+v, want a trusted VALID anchor", st)
+		}
+	}
+
+	if err := r.forceTrustAnchorState(38696, "revoked"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.importTrustAnchors(context.Background(), []byte(signalRootKeys[1])); !errors.Is(err, ErrTrustAnchorTombstoned) {
+		t.Fatalf("re-import of a revoked key: err = %v, want ErrTrustAnchorTombstoned", err)
+	}
+
+	r.dnssec = false
+	if _, err := r.importTrustAnchors(context.Background(), []byte(signalRootKeys[1])); !errors.Is(err, ErrDNSSECDisabled) {
+		t.Fatalf("import with dnssec off: err = %v, want ErrDNSSECDisabled", err)
+	}
+}
+
+func TestForceTrustAnchorState(t *testing.T) {
+	r := adminTestResolver(t, signalRootKeys...)
+
+	if err := r.forceTrustAnchorState(20326, "PENDING"); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.trustAnchorTags(); len(got) != 1 || got[0] != 38696 {
+		t.Fatalf("live trust set %v, want only 38696 while 20326 is pending", got)
+	}
+
+	status, err := r.trustAnchorStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := status[0]
+	if pending.KeyTag != 20326 || pending.State != "PENDING" || pending.Trusted {
+		t.Fatalf("forced anchor %+v, want untrusted PENDING 20326", pending)
+	}
+	if got := pending.HoldDownEnds.Sub(pending.FirstSeen); got != addHoldDown {
+		t.Fatalf("hold-down %v, want %v", got, addHoldDown)
+	}
+
+	if err := r.forceTrustAnchorState(38696, "missing"); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.trustAnchorTags(); len(got) != 1 || got[0] != 38696 {
+		t.Fatalf("live trust set %v, want 38696 still trusted while missing", got)
+	}
+
+	if err := r.forceTrustAnchorState(20326, "REVOKED"); err != nil {
+		t.Fatal(err)
+	}
+	status, err = r.trustAnchorStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 2 || status[1].State != "REVOKED" || status[1].KeyTag != 20326+DNSKEYFlagRevoke {
+		t.Fatalf("status after revocation %+v, want 38696 then the revoked 20326", status)
+	}
+	var m dto.Metric
+	if err := trustAnchorStates.WithLabelValues("revoked").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetGauge().GetValue(); got != 1 {
+		t.Fatalf("revoked gauge = %v, want 1", got)
+	}
+
+	if err := r.forceTrustAnchorState(20326, "VALID"); !errors.Is(err, ErrTrustAnchorNotFound) {
+		t.Fatalf("force on a revoked key: err = %v, want ErrTrustAnchorNotFound", err)
+	}
+	if err := r.forceTrustAnchorState(38696, "bogus"); !errors.Is(err, ErrTrustAnchorInvalid) {
+		t.Fatalf("unknown state: err = %v, want ErrTrustAnchorInvalid", err)
+	}
+
+	if err := r.forceTrustAnchorState(38696, "REMOVED"); err != nil {
+		t.Fatal(err)
+	}
+	if r.hasTrustAnchors() {
+		t.Fatal("trust set not empty after removing the last anchor")
+	}
+}
+
+func TestTrustAnchorStateUnreadable(t *testing.T) {
+	r := adminTestResolver(t, signalRootKeys...)
+	path := filepath.Join(r.cfg.Directory, stateFile)
+	if err := os.WriteFile(path, []byte("not a gob stream"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.trustAnchorStatus(); err == nil {
+		t.Fatal("status over a corrupt state file succeeded, want an error")
+	}
+	if err := r.forceTrustAnchorState(20326, "PENDING"); err == nil {
+		t.Fatal("force over a corrupt state file succeeded, want an error")
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "not a gob stream" {
+		t.Fatal("corrupt state file was overwritten")
+	}
+}
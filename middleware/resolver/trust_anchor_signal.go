@@ -0,0 +1,151 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/debuglog"
+	"github.com/semihalev/sdns/internal/dnsutil"
+	"github.com/semihalev/sdns/middleware"
+	"github.com/semihalev/sdns/middleware/resolver/dnssec"
+)
+
+// RFC 8509 §2 sentinel label prefixes. The key tag follows as exactly five
+// decimal digits.
+const (
+	sentinelIsTA  = "root-key-sentinel-is-ta-"
+	sentinelNotTA = "root-key-sentinel-not-ta-"
+)
+
+// trustAnchorTags returns the key tags of the root KSKs currently trusted
+// for validation, sorted and without duplicates. r.rootKeys carries Valid
+// and Missing anchors only; AddPend keys are not trusted yet and revoked
+// keys have left the set, so neither is reported or recognised.
+func (r *Resolver) trustAnchorTags() []uint16 {
+	r.RLock()
+	rootKeys := append([]dns.RR(nil), r.rootKeys...)
+	r.RUnlock()
+
+	tags := make([]uint16, 0, len(rootKeys))
+	for _, rr := range rootKeys {
+		dnskey, ok := rr.(*dns.DNSKEY)
+		if !ok || dnskey.Flags&DNSKEYFlagKSK == 0 || dnskey.Flags&DNSKEYFlagRevoke != 0 {
+			continue
+		}
+		tags = append(tags, dnssec.KeyTag(dnskey))
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// keyTagQName is the RFC 8145 §5.1 signal name for the root: "_ta-" and
+// each key tag as four lower-case hex digits, ascending, joined by hyphens.
+func keyTagQName(tags []uint16) string {
+	var b strings.Builder
+	b.WriteString("_ta")
+	for _, tag := range tags {
+		fmt.Fprintf(&b, "-%04x", tag)
+	}
+	b.WriteString(rootzone)
+	return b.String()
+}
+
+// signalTrustAnchors sends the RFC 8145 §5 key-tag query, telling the root
+// operators which root KSKs this resolver trusts. The answer is a
+// NXDOMAIN nobody reads; the query itself is the signal, so it goes to the
+// root servers directly rather than through the cache, where an RFC 8198
+// synthesis from the root's NSEC chain would swallow it.
+func (r *Resolver) signalTrustAnchors() {
+	tags := r.trustAnchorTags()
+	if len(tags) == 0 {
+		return
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion(keyTagQName(tags), dns.TypeNULL)
+	req.SetEdns0(dnsutil.DefaultMsgSize, true)
+	// The answer carries no information, so there is nothing to validate;
+	// CD keeps a broken trust set from suppressing the one query that
+	// reports it.
+	req.CheckingDisabled = true
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(r.netTimeout))
+	defer cancel()
+	ctx, _ = middleware.EnsureRecursionWork(ctx, r.workPolicy)
+	defer middleware.FinishRecursionWork(ctx)
+
+	if _, err := r.Resolve(ctx, req, r.rootServers, true, 5, 0, false, nil, true); err != nil {
+		debuglog.Log(ctx, debuglog.DNSSEC, "Trust anchor key-tag signal failed", "query", req.Question[0].Name, "error", err.Error())
+		taSignalFailed.Inc()
+		return
+	}
+	debuglog.Log(ctx, debuglog.DNSSEC, "Trust anchor key-tag signal sent", "query", req.Question[0].Name)
+	taSignalSent.Inc()
+}
+
+// parseSentinelLabel reports whether the leftmost label of name is an RFC
+// 8509 sentinel label, which kind, and the key tag it names. Label matching
+// is case-insensitive like every DNS label comparison.
+func parseSentinelLabel(name string) (isTA bool, tag uint16, ok bool) {
+	label := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		label = name[:i]
+	}
+	var digits string
+	switch {
+	case len(label) == len(sentinelIsTA)+5 && strings.EqualFold(label[:len(sentinelIsTA)], sentinelIsTA):
+		isTA, digits = true, label[len(sentinelIsTA):]
+	case len(label) == len(sentinelNotTA)+5 && strings.EqualFold(label[:len(sentinelNotTA)], sentinelNotTA):
+		digits = label[len(sentinelNotTA):]
+	default:
+		return false, 0, false
+	}
+	for i := range len(digits) {
+		if digits[i] < '0' || digits[i] > '9' {
+			return false, 0, false
+		}
+	}
+	v, err := strconv.ParseUint(digits, 10, 16)
+	if err != nil {
+		return false, 0, false
+	}
+	return isTA, uint16(v), true
+}
+
+// rootKeySentinelFails applies RFC 8509 §3.2 to a validated answer and
+// reports whether it must be replaced with SERVFAIL: an is-ta name whose
+// key tag is not a trusted root anchor, or a not-ta name whose key tag is.
+// Anything else — another qtype, an unvalidated answer, a label that is
+// not a sentinel — keeps its ordinary response.
+func (r *Resolver) rootKeySentinelFails(q dns.Question, resp *dns.Msg) bool {
+	if q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA {
+		return false
+	}
+	if resp == nil || resp.Rcode != dns.RcodeSuccess || !resp.AuthenticatedData {
+		return false
+	}
+	isTA, tag, ok := parseSentinelLabel(q.Name)
+	if !ok {
+		return false
+	}
+
+	_, trusted := slices.BinarySearch(r.trustAnchorTags(), tag)
+	switch {
+	case isTA && !trusted:
+		sentinelIsTAServfail.Inc()
+		return true
+	case isTA:
+		sentinelIsTAPass.Inc()
+	case trusted:
+		sentinelNotTAServfail.Inc()
+		return true
+	default:
+		sentinelNotTAPass.Inc()
+	}
+	return false
+}
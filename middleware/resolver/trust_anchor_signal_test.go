@@ -0,0 +1,161 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+)
+
+// The two root KSKs of the 2024 trust set: 20326 (0x4f66) and 38696
+// (0x9728).
+var signalRootKeys = []string{
+	". 172800 IN DNSKEY 257 3 8 AwEAAaz/tAm8yTn4Mfeh5eyI96WSVexTBAvkMgJzkKTOiW1vkIbzxeF3+/4RgWOq7HrxRixHlFlExOLAJr5emLvN7SWXgnLh4+B5xQlNVz8Og8kvArMtNROxVQuCaSnIDdD5LKyWbRd2n9WGe2R8PzgCmr3EgVLrjyBxWezF0jLHwVN8efS3rCj/EWgvIWgb9tarpVUDK/b58Da+sqqls3eNbuv7pr+eoZG+SrDK6nWeL3c6H5Apxz7LjVc1uTIdsIXxuOLYA4/ilBmSVIzuDWfdRUfhHdY6+cn8HFRm+2hM8AnXGXws9555KrUB5qihylGa8subX2Nn6UwNR1AkUTV74bU=",
+	". 172800 IN DNSKEY 257 3 8 AwEAAa96jeuknZlaeSrvyAJj6ZHv28hhOKkx3rLGXVaC6rXTsDc449/cidltpkyGwCJNnOAlFNKF2jBosZBU5eeHspaQWOmOElZsjICMQMC3aeHbGiShvZsx4wMYSjH8e7Vrhbu6irwCzVBApESjbUdpWWmEnhathWu1jo+siFUiRAAxm9qyJNg/wOZqqzL/dL/q8PkcRU5oUKEpUge71M3ej2/7CPqpdVwuMoTvoB+ZOT4YeGyxMvHmbrxlFzGOHOijtzN+u1TQNatX2XBuzZNQ1K+s2CXkPIZo7s6JgZyvaBevYtxPvYLw4z9mR7K2vaF18UYH9Z9GNUUeayffKC73PYc=",
+}
+
+func signalTestResolver(t *testing.T, rootServer string) *Resolver {
+	t.Helper()
+	cfg := new(config.Config)
+	cfg.RootServers = []string{rootServer}
+	cfg.RootKeys = signalRootKeys
+	cfg.Timeout.Duration = 2 * time.Second
+	return NewResolver(cfg)
+}
+
+func TestKeyTagQName(t *testing.T) {
+	tests := []struct {
+		tags []uint16
+		want string
+	}{
+		{[]uint16{20326}, "_ta-4f66."},
+		{[]uint16{20326, 38696}, "_ta-4f66-9728."},
+		{[]uint16{10, 0xabcd}, "_ta-000a-abcd."},
+	}
+	for _, tt := range tests {
+		if got := keyTagQName(tt.tags); got != tt.want {
+			t.Errorf("keyTagQName(%v) = %q, want %q", tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestTrustAnchorTags(t *testing.T) {
+	r := signalTestResolver(t, "127.0.0.1:53")
+
+	revoked := dns.Copy(r.rootKeys[0]).(*dns.DNSKEY)
+	revoked.Flags |= DNSKEYFlagRevoke
+	zsk := dns.Copy(r.rootKeys[1]).(*dns.DNSKEY)
+	zsk.Flags = 256
+
+	r.Lock()
+	r.rootKeys = append(r.rootKeys, r.rootKeys[0], revoked, zsk)
+	r.Unlock()
+
+	got := r.trustAnchorTags()
+	if len(got) != 2 || got[0] != 20326 || got[1] != 38696 {
+		t.Fatalf("trustAnchorTags() = %v, want [20326 38696]", got)
+	}
+}
+
+func TestSignalTrustAnchors(t *testing.T) {
+	queries := make(chan dns.Question, 8)
+	mux := dns.NewServeMux()
+	mux.HandleFunc(".", func(w dns.ResponseWriter, req *dns.Msg) {
+		select {
+		case queries <- req.Question[0]:
+		default:
+		}
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeNameError)
+		m.Authoritative = true
+		_ = w.WriteMsg(m)
+	})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	s := &dns.Server{Net: "udp", Handler: mux, PacketConn: pc}
+	go func() { _ = s.ActivateAndServe() }()
+	defer func() { _ = s.Shutdown() }()
+
+	r := signalTestResolver(t, pc.LocalAddr().String())
+
+	before := taSignalSent.Value()
+	r.signalTrustAnchors()
+	if got := taSignalSent.Value() - before; got != 1 {
+		t.Fatalf("sent delta = %d, want 1", got)
+	}
+
+	for {
+		select {
+		case q := <-queries:
+			if q.Qtype == dns.TypeNULL {
+				if q.Name != "_ta-4f66-9728." {
+					t.Fatalf("signal name = %q, want _ta-4f66-9728.", q.Name)
+				}
+				return
+			}
+		default:
+			t.Fatal("no NULL key-tag query reached the root server")
+		}
+	}
+}
+
+func TestParseSentinelLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		isTA   bool
+		tag    uint16
+		wantOK bool
+	}{
+		{"root-key-sentinel-is-ta-20326.example.com.", true, 20326, true},
+		{"root-key-sentinel-not-ta-38696.example.com.", false, 38696, true},
+		{"ROOT-KEY-SENTINEL-IS-TA-00042.example.", true, 42, true},
+		{"root-key-sentinel-is-ta-2032.example.com.", false, 0, false},
+		{"root-key-sentinel-is-ta-203260.example.com.", false, 0, false},
+		{"root-key-sentinel-is-ta-7000a.example.com.", false, 0, false},
+		{"root-key-sentinel-not-ta-99999.example.com.", false, 0, false},
+		{"www.root-key-sentinel-is-ta-20326.example.com.", false, 0, false},
+		{"example.com.", false, 0, false},
+	}
+	for _, tt := range tests {
+		isTA, tag, ok := parseSentinelLabel(tt.name)
+		if ok != tt.wantOK || isTA != tt.isTA || tag != tt.tag {
+			t.Errorf("parseSentinelLabel(%q) = %v, %d, %v; want %v, %d, %v",
+				tt.name, isTA, tag, ok, tt.isTA, tt.tag, tt.wantOK)
+		}
+	}
+}
+
+func TestRootKeySentinel(t *testing.T) {
+	r := signalTestResolver(t, "127.0.0.1:53")
+
+	validated := &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeSuccess, AuthenticatedData: true}}
+	insecure := &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeSuccess}}
+
+	tests := []struct {
+		name  string
+		qname string
+		qtype uint16
+		resp  *dns.Msg
+		fail  bool
+	}{
+		{"is-ta trusted", "root-key-sentinel-is-ta-20326.example.", dns.TypeA, validated, false},
+		{"is-ta untrusted", "root-key-sentinel-is-ta-19036.example.", dns.TypeA, validated, true},
+		{"not-ta trusted", "root-key-sentinel-not-ta-38696.example.", dns.TypeAAAA, validated, true},
+		{"not-ta untrusted", "root-key-sentinel-not-ta-19036.example.", dns.TypeAAAA, validated, false},
+		{"insecure answer untouched", "root-key-sentinel-is-ta-19036.example.", dns.TypeA, insecure, false},
+		{"other qtype untouched", "root-key-sentinel-is-ta-19036.example.", dns.TypeTXT, validated, false},
+		{"ordinary name", "www.example.", dns.TypeA, validated, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := dns.Question{Name: tt.qname, Qtype: tt.qtype, Qclass: dns.ClassINET}
+			if got := r.rootKeySentinelFails(q, tt.resp); got != tt.fail {
+				t.Fatalf("rootKeySentinelFails = %v, want %v", got, tt.fail)
+			}
+		})
+	}
+}
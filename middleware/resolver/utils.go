@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/debuglog"
 	"github.com/semihalev/sdns/internal/dnsname"
 	"github.com/semihalev/zlog/v2"
 )
@@ -23,14 +24,16 @@ func init() {
 	}
 }
 
-// debugLogEnabled reports whether debug logging is on. zlog.Debug drops
-// disabled records, but only after the caller has evaluated its arguments —
-// per-query call sites format the question and box it into ...any on every
-// query regardless, which showed up at ~2% of all allocated objects in
-// production with debug off. Sites on the normal query path check this
-// first; rare paths (dial failures, loop detection) are not worth the noise.
+// debugLogEnabled reports whether resolver or DNSSEC debug output may be
+// wanted: the process is at debug level, or either subsystem was switched
+// on at runtime. debuglog.Log drops unwanted records, but only after the
+// caller has evaluated its arguments — per-query call sites format the
+// question and box it into ...any on every query regardless, which showed
+// up at ~2% of all allocated objects in production with debug off. Sites
+// on the normal query path check this first; rare paths (dial failures,
+// loop detection) are not worth the noise.
 func debugLogEnabled() bool {
-	return zlog.Default().GetLevel() <= zlog.LevelDebug
+	return debuglog.Enabled(debuglog.Resolver | debuglog.DNSSEC)
 }
 
 // searchAddrs collects usable NS addresses from an answer as heap-free
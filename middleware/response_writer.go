@@ -41,6 +41,10 @@ type responseWriter struct {
 	proto    string
 	remoteip net.IP
 	internal bool
+	listener *ListenerPolicy
+	identity string
+	// encrypted marks a DoT, DoH or DoQ query; see Chain.Encrypted.
+	encrypted bool
 
 	// directPack records that the transport beneath this writer is an
 	// SDNS-owned UDP, TCP or DoT sink whose Write sends raw wire bytes
@@ -73,6 +77,9 @@ func (w *responseWriter) Reset(rw Transport) {
 	w.proto = ""
 	w.remoteip = nil
 	w.internal = false
+	w.listener = nil
+	w.identity = ""
+	w.encrypted = false
 	w.directPack = false
 
 	switch a := rw.RemoteAddr().(type) {
@@ -94,6 +101,28 @@ func (w *responseWriter) Reset(rw Transport) {
 		}
 	}
 
+	// DoH and DoQ are encrypted by definition; DoT arrives on a TCP
+	// address and says so itself. See Encrypted.
+	switch w.proto {
+	case "doh", "doq":
+		w.encrypted = true
+	}
+	if e, ok := rw.(interface{ Encrypted() bool }); ok && e.Encrypted() {
+		w.encrypted = true
+	}
+
+	// A transport serving a [[listeners]] entry says which; see
+	// ListenerPolicy.
+	if l, ok := rw.(interface{ ListenerPolicy() *ListenerPolicy }); ok {
+		w.listener = l.ListenerPolicy()
+	}
+
+	// A transport whose client presented a verified certificate names
+	// it; see ClientIdentity.
+	if c, ok := rw.(interface{ ClientIdentity() string }); ok {
+		w.identity = c.ClientIdentity()
+	}
+
 	// Propagate an Internal() signal from any writer that exposes it.
 	// Today that's the mock.Writer-with-sentinel path plus the
 	// queryer.BufferWriter used by the internal sub-pipeline. The
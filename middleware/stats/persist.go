@@ -0,0 +1,173 @@
+package stats
+
+import (
+	"cmp"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/semihalev/zlog/v2"
+)
+
+// snapshotVersion is bumped when the file format changes; a file of
+// another version is ignored rather than misread.
+const snapshotVersion = 1
+
+type snapshot struct {
+	Version int                         `json:"version"`
+	Windows map[string][]bucketSnapshot `json:"windows"`
+}
+
+type bucketSnapshot struct {
+	Start     int64           `json:"start"`
+	Total     Counts          `json:"total"`
+	CacheHits uint64          `json:"cache_hits"`
+	Domains   []entrySnapshot `json:"domains"`
+	Blocked   []entrySnapshot `json:"blocked"`
+	Clients   []entrySnapshot `json:"clients"`
+}
+
+type entrySnapshot struct {
+	Key string `json:"key"`
+	Counts
+	Err uint64 `json:"err,omitempty"`
+}
+
+func entriesOf[K comparable](sm *summary[K], key func(K) string) []entrySnapshot {
+	out := make([]entrySnapshot, len(sm.entries))
+	for i, e := range sm.entries {
+		out[i] = entrySnapshot{Key: key(e.key), Counts: e.n, Err: e.err}
+	}
+	return out
+}
+
+// restore refills sm from saved entries. A file written with a larger
+// size keeps only the most counted entries that fit.
+func restore[K comparable](sm *summary[K], entries []entrySnapshot, parse func(string) (K, bool)) {
+	entries = slices.Clone(entries)
+	slices.SortFunc(entries, func(a, b entrySnapshot) int { return cmp.Compare(b.Queries, a.Queries) })
+	for _, es := range entries {
+		if len(sm.entries) == sm.size {
+			break
+		}
+		k, ok := parse(es.Key)
+		if !ok {
+			continue
+		}
+		if _, dup := sm.index[k]; dup {
+			continue
+		}
+		e := &entry[K]{key: k, n: es.Counts, err: es.Err}
+		sm.index[k] = e
+		heap.Push(&sm.entries, e)
+	}
+}
+
+func domainKey(s string) string { return s }
+
+func parseDomain(s string) (string, bool) { return s, s != "" }
+
+func parseClient(s string) (netip.Addr, bool) {
+	a, err := netip.ParseAddr(s)
+	return a, err == nil
+}
+
+// save writes the statistics to the persist file, replacing it whole so a
+// crash mid-write leaves the previous file.
+func (s *Stats) save() {
+	snap := snapshot{Version: snapshotVersion, Windows: make(map[string][]bucketSnapshot)}
+	s.mu.Lock()
+	now := s.now()
+	for w, win := range s.windows {
+		var buckets []bucketSnapshot
+		for _, b := range win.live(now) {
+			buckets = append(buckets, bucketSnapshot{
+				Start:     b.start,
+				Total:     b.total,
+				CacheHits: b.cacheHits,
+				Domains:   entriesOf(b.domains, domainKey),
+				Blocked:   entriesOf(b.blocked, domainKey),
+				Clients:   entriesOf(b.clients, netip.Addr.String),
+			})
+		}
+		snap.Windows[Window(w).String()] = buckets
+	}
+	s.mu.Unlock()
+
+	if err := writeSnapshot(s.persist, &snap); err != nil {
+		zlog.Warn("Statistics save failed", "file", s.persist, "error", err.Error())
+	}
+}
+
+func writeSnapshot(path string, snap *snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp.*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// load reads the persist file. Buckets that have left their window since
+// it was saved are loaded too, and never reported. A missing file is a
+// first start, not an error.
+func (s *Stats) load() error {
+	data, err := os.ReadFile(s.persist)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("file version %d, want %d", snap.Version, snapshotVersion)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for w, win := range s.windows {
+		for _, bs := range snap.Windows[Window(w).String()] {
+			if bs.Start%win.width != 0 {
+				continue
+			}
+			b := win.current(time.Unix(bs.Start, 0), s.size)
+			b.total = bs.Total
+			b.cacheHits = bs.CacheHits
+			restore(b.domains, bs.Domains, parseDomain)
+			restore(b.blocked, bs.Blocked, parseDomain)
+			restore(b.clients, bs.Clients, parseClient)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,376 @@
+// Package stats keeps rolling query statistics for the API: the most
+// queried and most blocked domains, the busiest clients, and per-client
+// query, blocked and NXDOMAIN counts over the last hour and day.
+//
+// Top lists are heavy-hitter summaries rather than exact tables, so the
+// memory they take is fixed by the configured size whatever the traffic:
+// the leading entries are exact or nearly so, and each reports a bound on
+// how far its count may be overstated.
+package stats
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/dnsname"
+	"github.com/semihalev/sdns/internal/metric"
+	"github.com/semihalev/sdns/middleware"
+	"github.com/semihalev/zlog/v2"
+)
+
+var eventsDropped = metric.NewCounter(nil, prometheus.CounterOpts{
+	Name: "dns_stats_dropped_total",
+	Help: "Queries left out of the statistics because they fell behind",
+})
+
+// queueSize is how many queries may wait to be counted. A full queue
+// drops the query from the statistics rather than delay its answer.
+const queueSize = 4096
+
+// persistInterval is how often the statistics are saved when Persist is
+// set, bounding what a crash loses.
+const persistInterval = 5 * time.Minute
+
+// defaultTopSize is the per-bucket summary size when none is configured.
+const defaultTopSize = 500
+
+// MaxTop is the most entries a top list returns.
+const MaxTop = 1000
+
+// event is one answered query, as counted.
+type event struct {
+	at       time.Time
+	qname    string
+	client   netip.Addr
+	rcode    int
+	blocked  bool
+	cacheHit bool
+}
+
+// Stats is the statistics middleware.
+type Stats struct {
+	size    int
+	persist string
+	now     func() time.Time
+
+	mu      sync.Mutex
+	windows [len(windowSpecs)]*window
+
+	queue     chan event
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a new statistics middleware. Disabled, it passes every query
+// straight through.
+func New(cfg *config.Config) *Stats {
+	s := &Stats{now: time.Now}
+	c := cfg.Stats
+	if !c.Enabled {
+		return s
+	}
+
+	s.size = c.TopSize
+	if s.size <= 0 {
+		s.size = defaultTopSize
+	}
+	s.persist = c.Persist
+	for w := range s.windows {
+		s.windows[w] = newWindow(Window(w))
+	}
+	if s.persist != "" {
+		if err := s.load(); err != nil {
+			zlog.Error("Statistics failed to load, starting empty", "file", s.persist, "error", err.Error())
+		}
+	}
+
+	s.queue = make(chan event, queueSize)
+	s.done = make(chan struct{})
+	s.stopped = make(chan struct{})
+	go s.run()
+
+	return s
+}
+
+// (*Stats).Enabled reports whether statistics are being kept.
+func (s *Stats) Enabled() bool { return s.queue != nil }
+
+// (*Stats).Name returns the middleware name.
+func (s *Stats) Name() string { return name }
+
+// (*Stats).ClientOnly marks the statistics as a client-traffic observer,
+// so the resolver's own sub-queries are not counted as a client's.
+func (s *Stats) ClientOnly() bool { return true }
+
+// (*Stats).ServeDNS counts the query once the chain has answered it. It
+// shares the query log's notes when that runs too, to learn whether the
+// query was blocked or served from the cache. A wire-born request stays on
+// the byte path: the name is read from the wire.
+func (s *Stats) ServeDNS(ctx context.Context, ch *middleware.Chain) {
+	w := ch.Writer
+	if s.queue == nil || w.Internal() {
+		ch.Next(ctx)
+		return
+	}
+	qname, qtype, ok := queryName(ch.Request)
+	if !ok {
+		ch.Next(ctx)
+		return
+	}
+
+	notes := middleware.JoinQueryNotes(ctx, ch.Request, dns.Question{Name: qname, Qtype: qtype, Qclass: dns.ClassINET})
+
+	ch.Next(ctx)
+
+	if !w.Written() {
+		return
+	}
+
+	client, _ := netip.AddrFromSlice(w.RemoteIP())
+	ev := event{
+		at:       s.now(),
+		qname:    qname,
+		client:   client.Unmap(),
+		rcode:    w.Rcode(),
+		blocked:  notes.Blocked() != "",
+		cacheHit: notes.CacheHit(),
+	}
+	select {
+	case s.queue <- ev:
+	default:
+		eventsDropped.Inc()
+	}
+}
+
+// queryName returns the question's name, lowercased and fully qualified,
+// so the spellings of one name are counted together.
+func queryName(req *middleware.Request) (string, uint16, bool) {
+	if req.Undecoded() && req.Raw() != nil {
+		var buf [dnsname.MaxPresentationLength + 1]byte
+		key, ok := dnsname.AppendFoldedKey(buf[:0], req.WireName())
+		if !ok {
+			return "", 0, false
+		}
+		return string(append(key, '.')), req.Qtype(), true
+	}
+	msg := req.Msg()
+	if msg == nil || len(msg.Question) == 0 {
+		return "", 0, false
+	}
+	return strings.ToLower(dns.Fqdn(msg.Question[0].Name)), msg.Question[0].Qtype, true
+}
+
+func (s *Stats) run() {
+	defer close(s.stopped)
+
+	var tick <-chan time.Time
+	if s.persist != "" {
+		ticker := time.NewTicker(persistInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case ev := <-s.queue:
+			s.mu.Lock()
+			s.record(&ev)
+			// Count what else is waiting under the same lock.
+			for n := len(s.queue); n > 0; n-- {
+				ev = <-s.queue
+				s.record(&ev)
+			}
+			s.mu.Unlock()
+		case <-tick:
+			s.save()
+		case <-s.done:
+			s.mu.Lock()
+			for n := len(s.queue); n > 0; n-- {
+				ev := <-s.queue
+				s.record(&ev)
+			}
+			s.mu.Unlock()
+			if s.persist != "" {
+				s.save()
+			}
+			return
+		}
+	}
+}
+
+func (s *Stats) record(ev *event) {
+	c := Counts{Queries: 1}
+	if ev.blocked {
+		c.Blocked = 1
+	}
+	if ev.rcode == dns.RcodeNameError {
+		c.NXDomain = 1
+	}
+	for _, w := range s.windows {
+		b := w.current(ev.at, s.size)
+		b.total.add(c)
+		if ev.cacheHit {
+			b.cacheHits++
+		}
+		b.domains.add(ev.qname, c)
+		if ev.blocked {
+			b.blocked.add(ev.qname, c)
+		}
+		if ev.client.IsValid() {
+			b.clients.add(ev.client, c)
+		}
+	}
+}
+
+// (*Stats).Close counts the queries still queued and saves the statistics.
+func (s *Stats) Close() error {
+	if s.queue == nil {
+		return nil
+	}
+	s.closeOnce.Do(func() {
+		close(s.done)
+		<-s.stopped
+	})
+	return nil
+}
+
+// Summary is a window's totals.
+type Summary struct {
+	Window string    `json:"window"`
+	Since  time.Time `json:"since"`
+	Counts
+	CacheHits uint64 `json:"cache_hits"`
+}
+
+// DomainStat is a domain's counts over a window. Error bounds how far
+// Queries may overstate the true count.
+type DomainStat struct {
+	Name string `json:"name"`
+	Counts
+	Error uint64 `json:"error,omitempty"`
+}
+
+// ClientStat is a client's counts over a window. Error bounds how far
+// Queries may overstate the true count.
+type ClientStat struct {
+	Client netip.Addr `json:"client"`
+	Counts
+	Error uint64 `json:"error,omitempty"`
+}
+
+// (*Stats).Summary returns the totals over w.
+func (s *Stats) Summary(w Window) Summary {
+	now := s.now()
+	sum := Summary{
+		Window: w.String(),
+		Since:  now.Add(-windowSpecs[w].width * time.Duration(windowSpecs[w].buckets)).UTC(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.windows[w].live(now) {
+		sum.add(b.total)
+		sum.CacheHits += b.cacheHits
+	}
+	return sum
+}
+
+// Point is one bucket of a window's time series.
+type Point struct {
+	Start time.Time `json:"start"`
+	Counts
+	CacheHits uint64 `json:"cache_hits"`
+}
+
+// (*Stats).Series returns w bucket by bucket, oldest first, the current
+// bucket last. Buckets nothing was counted in are present with zero
+// counts, so the series can be graphed as it is.
+func (s *Stats) Series(w Window) []Point {
+	now := s.now()
+	win := s.windows[w]
+	cur := now.Unix() - now.Unix()%win.width
+	out := make([]Point, len(win.buckets))
+	for i := range out {
+		out[i].Start = time.Unix(cur-int64(len(out)-1-i)*win.width, 0).UTC()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range win.live(now) {
+		i := len(out) - 1 - int((cur-b.start)/win.width)
+		if i < 0 || i >= len(out) {
+			continue
+		}
+		out[i].Counts = b.total
+		out[i].CacheHits = b.cacheHits
+	}
+	return out
+}
+
+// (*Stats).TopDomains returns the n most queried domains over w.
+func (s *Stats) TopDomains(w Window, n int) []DomainStat {
+	return s.topDomains(w, n, func(b *bucket) *summary[string] { return b.domains })
+}
+
+// (*Stats).TopBlocked returns the n most blocked domains over w.
+func (s *Stats) TopBlocked(w Window, n int) []DomainStat {
+	return s.topDomains(w, n, func(b *bucket) *summary[string] { return b.blocked })
+}
+
+func (s *Stats) topDomains(w Window, n int, of func(*bucket) *summary[string]) []DomainStat {
+	m := make(merged[string])
+	s.mu.Lock()
+	for _, b := range s.windows[w].live(s.now()) {
+		m.add(of(b))
+	}
+	s.mu.Unlock()
+
+	top := m.top(n, strings.Compare)
+	out := make([]DomainStat, len(top))
+	for i, it := range top {
+		out[i] = DomainStat{Name: it.key, Counts: it.n, Error: it.err}
+	}
+	return out
+}
+
+// (*Stats).TopClients returns the n clients that sent the most queries
+// over w.
+func (s *Stats) TopClients(w Window, n int) []ClientStat {
+	m := s.clients(w)
+	top := m.top(n, func(a, b netip.Addr) int { return a.Compare(b) })
+	out := make([]ClientStat, len(top))
+	for i, it := range top {
+		out[i] = ClientStat{Client: it.key, Counts: it.n, Error: it.err}
+	}
+	return out
+}
+
+// (*Stats).Client returns addr's counts over w, and whether the client is
+// tracked at all. A client too quiet to be kept in every bucket of the
+// window is undercounted.
+func (s *Stats) Client(w Window, addr netip.Addr) (ClientStat, bool) {
+	addr = addr.Unmap()
+	it, ok := s.clients(w)[addr]
+	if !ok {
+		return ClientStat{Client: addr}, false
+	}
+	return ClientStat{Client: addr, Counts: it.n, Error: it.err}, true
+}
+
+func (s *Stats) clients(w Window) merged[netip.Addr] {
+	m := make(merged[netip.Addr])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.windows[w].live(s.now()) {
+		m.add(b.clients)
+	}
+	return m
+}
+
+const name = "stats"
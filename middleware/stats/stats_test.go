@@ -0,0 +1,136 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/mock"
+	"github.com/semihalev/sdns/middleware"
+)
+
+func TestSummaryHeavyHitters(t *testing.T) {
+	s := newSummary[string](5)
+	for i := range 100 {
+		s.add("hot.", Counts{Queries: 2})
+		s.add("warm.", Counts{Queries: 1, NXDomain: uint64(i % 2)})
+		// A stream of names each seen once competes for the other slots.
+		s.add(fmt.Sprintf("rare%d.", i), Counts{Queries: 1})
+	}
+
+	m := make(merged[string])
+	m.add(s)
+	top := m.top(2, func(a, b string) int { return 0 })
+	if len(top) != 2 || top[0].key != "hot." || top[1].key != "warm." {
+		t.Fatalf("top %v, want hot. then warm.", top)
+	}
+	if top[0].n.Queries != 200 || top[0].err != 0 {
+		t.Fatalf("hot. counted %d with error %d, want exactly 200", top[0].n.Queries, top[0].err)
+	}
+	if top[1].n.NXDomain != 50 {
+		t.Fatalf("warm. NXDOMAIN %d, want 50", top[1].n.NXDomain)
+	}
+	if len(s.entries) != 5 || len(s.index) != 5 {
+		t.Fatalf("summary holds %d entries, index %d, want 5", len(s.entries), len(s.index))
+	}
+}
+
+func TestWindows(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	s := New(&config.Config{Stats: config.StatsConfig{Enabled: true}})
+	s.now = func() time.Time { return now }
+	t.Cleanup(func() { _ = s.Close() })
+
+	client := netip.MustParseAddr("192.0.2.7")
+	s.mu.Lock()
+	s.record(&event{at: now.Add(-2 * time.Hour), qname: "old.example.", client: client})
+	s.record(&event{at: now.Add(-30 * time.Minute), qname: "www.example.", client: client, rcode: dns.RcodeNameError})
+	s.record(&event{at: now, qname: "ads.example.", client: client, blocked: true, cacheHit: true})
+	s.mu.Unlock()
+
+	if sum := s.Summary(Hour); sum.Queries != 2 || sum.Blocked != 1 || sum.NXDomain != 1 || sum.CacheHits != 1 {
+		t.Fatalf("hour summary %+v", sum)
+	}
+	if sum := s.Summary(Day); sum.Queries != 3 {
+		t.Fatalf("day summary %+v, want 3 queries", sum)
+	}
+	if top := s.TopDomains(Hour, 10); len(top) != 2 {
+		t.Fatalf("hour domains %v, want the two from the last hour", top)
+	}
+	if top := s.TopBlocked(Day, 10); len(top) != 1 || top[0].Name != "ads.example." {
+		t.Fatalf("blocked %v", top)
+	}
+	if c, ok := s.Client(Day, netip.MustParseAddr("::ffff:192.0.2.7")); !ok || c.Queries != 3 || c.Blocked != 1 || c.NXDomain != 1 {
+		t.Fatalf("client %+v tracked %v", c, ok)
+	}
+	if _, ok := s.Client(Day, netip.MustParseAddr("192.0.2.8")); ok {
+		t.Fatal("an unseen client is tracked")
+	}
+
+	series := s.Series(Hour)
+	if len(series) != 12 || !series[11].Start.Equal(now) || series[11].Blocked != 1 || series[5].Queries != 1 || series[4].Queries != 0 {
+		t.Fatalf("hour series %+v", series)
+	}
+
+	// A day on, everything has left both windows.
+	now = now.Add(25 * time.Hour)
+	if sum := s.Summary(Day); sum.Queries != 0 {
+		t.Fatalf("day summary a day later %+v", sum)
+	}
+}
+
+func TestPersist(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	cfg := &config.Config{Stats: config.StatsConfig{
+		Enabled: true,
+		Persist: filepath.Join(t.TempDir(), "stats.json"),
+	}}
+	s := New(cfg)
+	s.now = func() time.Time { return now }
+	s.mu.Lock()
+	for range 3 {
+		s.record(&event{at: now, qname: "www.example.", client: netip.MustParseAddr("2001:db8::1")})
+	}
+	s.mu.Unlock()
+	_ = s.Close()
+
+	s = New(cfg)
+	s.now = func() time.Time { return now.Add(time.Minute) }
+	t.Cleanup(func() { _ = s.Close() })
+	if top := s.TopClients(Hour, 1); len(top) != 1 || top[0].Client.String() != "2001:db8::1" || top[0].Queries != 3 {
+		t.Fatalf("clients after restart %v", top)
+	}
+	if top := s.TopDomains(Day, 1); len(top) != 1 || top[0].Queries != 3 {
+		t.Fatalf("domains after restart %v", top)
+	}
+}
+
+func TestServeDNS(t *testing.T) {
+	s := New(&config.Config{Stats: config.StatsConfig{Enabled: true}})
+
+	block := middleware.HandlerFunc(func(ctx context.Context, ch *middleware.Chain) {
+		middleware.NoteBlocked(ctx, ch.Request, "blocklist")
+		ch.CancelWithRcode(dns.RcodeNameError, false)
+	})
+	for _, name := range []string{"Ads.Example.", "ads.example."} {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		ch := middleware.NewChain([]middleware.Handler{s, block})
+		ch.Reset(mock.NewWriter("udp", "192.0.2.7:5353"), req)
+		ch.Next(context.Background())
+	}
+	_ = s.Close()
+
+	top := s.TopBlocked(Hour, 10)
+	if len(top) != 1 || top[0].Name != "ads.example." || top[0].Queries != 2 || top[0].NXDomain != 2 {
+		t.Fatalf("blocked %+v, want both spellings counted as ads.example.", top)
+	}
+	if c, _ := s.Client(Hour, netip.MustParseAddr("192.0.2.7")); c.Blocked != 2 {
+		t.Fatalf("client %+v", c)
+	}
+}
@@ -0,0 +1,127 @@
+package stats
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+)
+
+// Counts is what is counted against a domain, a client or a whole bucket.
+type Counts struct {
+	Queries  uint64 `json:"queries"`
+	Blocked  uint64 `json:"blocked"`
+	NXDomain uint64 `json:"nxdomain"`
+}
+
+func (c *Counts) add(o Counts) {
+	c.Queries += o.Queries
+	c.Blocked += o.Blocked
+	c.NXDomain += o.NXDomain
+}
+
+// summary is a Space-Saving heavy-hitter tracker: it keeps at most size
+// keys, and a new key takes over the least counted one, inheriting its
+// query count as an overestimate recorded in err. Any key queried more
+// than 1/size of the time is guaranteed to be kept, with its count off by
+// at most err.
+type summary[K comparable] struct {
+	size    int
+	index   map[K]*entry[K]
+	entries minHeap[K]
+}
+
+type entry[K comparable] struct {
+	key K
+	n   Counts
+	err uint64
+	pos int
+}
+
+func newSummary[K comparable](size int) *summary[K] {
+	return &summary[K]{size: size, index: make(map[K]*entry[K])}
+}
+
+func (s *summary[K]) add(key K, c Counts) {
+	if e, ok := s.index[key]; ok {
+		e.n.add(c)
+		heap.Fix(&s.entries, e.pos)
+		return
+	}
+	if len(s.entries) < s.size {
+		e := &entry[K]{key: key, n: c}
+		s.index[key] = e
+		heap.Push(&s.entries, e)
+		return
+	}
+	// The replaced key's queries carry over, as Space-Saving requires to
+	// bound the error; its blocked and NXDOMAIN counts do not, so those
+	// are never overestimated.
+	e := s.entries[0]
+	delete(s.index, e.key)
+	e.key, e.err = key, e.n.Queries
+	e.n = Counts{Queries: e.n.Queries + c.Queries, Blocked: c.Blocked, NXDomain: c.NXDomain}
+	s.index[key] = e
+	heap.Fix(&s.entries, 0)
+}
+
+type minHeap[K comparable] []*entry[K]
+
+func (h minHeap[K]) Len() int           { return len(h) }
+func (h minHeap[K]) Less(i, j int) bool { return h[i].n.Queries < h[j].n.Queries }
+func (h minHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos, h[j].pos = i, j
+}
+func (h *minHeap[K]) Push(x any) {
+	e := x.(*entry[K])
+	e.pos = len(*h)
+	*h = append(*h, e)
+}
+func (h *minHeap[K]) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// merged sums the summaries of a window's buckets by key.
+type merged[K comparable] map[K]*item[K]
+
+// item is one ranked key with its counts over a window. err bounds how far
+// the query count may overstate the true one.
+type item[K comparable] struct {
+	key K
+	n   Counts
+	err uint64
+}
+
+func (m merged[K]) add(s *summary[K]) {
+	for _, e := range s.entries {
+		it, ok := m[e.key]
+		if !ok {
+			it = &item[K]{key: e.key}
+			m[e.key] = it
+		}
+		it.n.add(e.n)
+		it.err += e.err
+	}
+}
+
+// top returns the n most queried keys, most first; ties go to the key that
+// sorts first, so the order is stable between calls.
+func (m merged[K]) top(n int, less func(a, b K) int) []item[K] {
+	out := make([]item[K], 0, len(m))
+	for _, it := range m {
+		out = append(out, *it)
+	}
+	slices.SortFunc(out, func(a, b item[K]) int {
+		if c := cmp.Compare(b.n.Queries, a.n.Queries); c != 0 {
+			return c
+		}
+		return less(a.key, b.key)
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package stats
+
+import (
+	"errors"
+	"net/netip"
+	"time"
+)
+
+// Window is a rolling period the statistics are reported over.
+type Window int
+
+const (
+	// Hour is the last hour, in five-minute buckets.
+	Hour Window = iota
+	// Day is the last 24 hours, in hourly buckets.
+	Day
+)
+
+var windowSpecs = [...]struct {
+	name    string
+	width   time.Duration
+	buckets int
+}{
+	Hour: {"1h", 5 * time.Minute, 12},
+	Day:  {"24h", time.Hour, 24},
+}
+
+// ParseWindow reads a window name, "1h" or "24h".
+func ParseWindow(s string) (Window, error) {
+	for w, spec := range windowSpecs {
+		if s == spec.name {
+			return Window(w), nil
+		}
+	}
+	return 0, errors.New("unknown window " + s + ", want 1h or 24h")
+}
+
+// String returns the window's name.
+func (w Window) String() string { return windowSpecs[w].name }
+
+// bucket holds one slice of time's statistics.
+type bucket struct {
+	start     int64 // unix seconds
+	total     Counts
+	cacheHits uint64
+	domains   *summary[string]
+	blocked   *summary[string]
+	clients   *summary[netip.Addr]
+}
+
+func newBucket(start int64, size int) *bucket {
+	return &bucket{
+		start:   start,
+		domains: newSummary[string](size),
+		blocked: newSummary[string](size),
+		clients: newSummary[netip.Addr](size),
+	}
+}
+
+// window is a ring of buckets; a bucket is reused once its slot comes
+// round again.
+type window struct {
+	width   int64
+	buckets []*bucket
+}
+
+func newWindow(w Window) *window {
+	spec := windowSpecs[w]
+	return &window{
+		width:   int64(spec.width / time.Second),
+		buckets: make([]*bucket, spec.buckets),
+	}
+}
+
+// current returns the bucket now falls in, started afresh if its slot
+// still holds an older one.
+func (w *window) current(now time.Time, size int) *bucket {
+	start := now.Unix() - now.Unix()%w.width
+	slot := (start / w.width) % int64(len(w.buckets))
+	b := w.buckets[slot]
+	if b == nil || b.start != start {
+		b = newBucket(start, size)
+		w.buckets[slot] = b
+	}
+	return b
+}
+
+// live returns the buckets that fall in the window ending now.
+func (w *window) live(now time.Time) []*bucket {
+	oldest := now.Unix() - w.width*int64(len(w.buckets))
+	out := make([]*bucket, 0, len(w.buckets))
+	for _, b := range w.buckets {
+		if b != nil && b.start > oldest && b.start <= now.Unix() {
+			out = append(out, b)
+		}
+	}
+	return out
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startRequestSpan opens the span that covers one client request, from
+// the first handler to the last. It is called only with tracing enabled,
+// by the chain that owns the request tree, so nested pipelines and the
+// worker pass of a handed-off query add handler spans beneath an existing
+// trace rather than starting their own.
+func (ch *Chain) startRequestSpan(ctx context.Context) (context.Context, trace.Span) {
+	w := ch.Writer
+	attrs := []attribute.KeyValue{
+		attribute.String("network.transport", w.Proto()),
+	}
+	if ip := w.RemoteIP(); ip != nil {
+		attrs = append(attrs, attribute.String("client.address", ip.String()))
+	}
+	if w.Internal() {
+		attrs = append(attrs, attribute.Bool("sdns.internal", true))
+	}
+	if ch.Request != nil {
+		if name, qtype, ok := requestQuestion(ch.Request); ok {
+			attrs = append(attrs,
+				attribute.String("dns.question.name", name),
+				attribute.String("dns.question.type", dns.Type(qtype).String()),
+			)
+		}
+	}
+	return tracing.Start(ctx, "dns.request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// endRequestSpan records the response code the request was answered with.
+func (ch *Chain) endRequestSpan(span trace.Span) {
+	if ch.Writer.Written() {
+		rcode := ch.Writer.Rcode()
+		span.SetAttributes(attribute.String("dns.response.code", dns.RcodeToString[rcode]))
+		if rcode == dns.RcodeServerFailure {
+			span.SetStatus(codes.Error, "SERVFAIL")
+		}
+	}
+	span.End()
+}
+
+// serveTraced runs h under a span of its own, so a trace shows where a
+// request spent its time handler by handler. Each span covers the handlers
+// after it too, as they run inside its ServeDNS.
+func (ch *Chain) serveTraced(ctx context.Context, h Handler) {
+	ctx, span := tracing.Start(ctx, "middleware."+h.Name())
+	defer span.End()
+	h.ServeDNS(ctx, ch)
+}
+
+// requestQuestion reads the question without decoding a wire-born
+// request: tracing must not move a request off the byte path.
+func requestQuestion(r *Request) (string, uint16, bool) {
+	if m := r.decoded(); m != nil {
+		if len(m.Question) == 0 {
+			return "", 0, false
+		}
+		return m.Question[0].Name, m.Question[0].Qtype, true
+	}
+	if r.wireBorn() {
+		name, _, err := dns.UnpackDomainName(r.WireName(), 0)
+		if err != nil {
+			return "", 0, false
+		}
+		return name, r.Qtype(), true
+	}
+	return "", 0, false
+}
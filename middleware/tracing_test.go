@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/mock"
+	"github.com/semihalev/sdns/internal/tracing/tracingtest"
+)
+
+type servfailer struct{}
+
+func (servfailer) Name() string { return "servfailer" }
+func (servfailer) ServeDNS(ctx context.Context, ch *Chain) {
+	ch.CancelWithRcode(dns.RcodeServerFailure, false)
+}
+
+func TestChainTracing(t *testing.T) {
+	c := tracingtest.Start(t)
+
+	ch := NewChain([]Handler{&dummy{}, servfailer{}})
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeAAAA)
+	ch.Reset(mock.NewWriter("udp", "192.0.2.7:5353"), req)
+	ch.Next(context.Background())
+	c.Flush(t)
+
+	roots := c.Named("dns.request")
+	if len(roots) != 1 {
+		t.Fatalf("%d request spans, want 1", len(roots))
+	}
+	root := roots[0]
+	for k, want := range map[string]string{
+		"network.transport": "udp",
+		"client.address":    "192.0.2.7",
+		"dns.question.name": "www.example.com.",
+		"dns.question.type": "AAAA",
+		"dns.response.code": "SERVFAIL",
+	} {
+		if got := root.Attrs[k]; got != want {
+			t.Errorf("request span %s = %q, want %q", k, got, want)
+		}
+	}
+	if !root.Error || root.ParentID != "" {
+		t.Errorf("request span error %v parent %q, want a failed root", root.Error, root.ParentID)
+	}
+
+	// Each handler's span sits under the one that called it.
+	first, last := c.Named("middleware.dummy"), c.Named("middleware.servfailer")
+	if len(first) != 1 || len(last) != 1 {
+		t.Fatalf("handler spans %d and %d, want one each", len(first), len(last))
+	}
+	if first[0].ParentID != root.SpanID || last[0].ParentID != first[0].SpanID {
+		t.Fatal("handler spans are not nested in chain order")
+	}
+	if first[0].TraceID != root.TraceID || last[0].TraceID != root.TraceID {
+		t.Fatal("handler spans are in another trace")
+	}
+	if c.Header("X-Test") != "tracingtest" {
+		t.Fatal("configured headers were not sent to the collector")
+	}
+}
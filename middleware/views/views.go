@@ -1,7 +1,8 @@
 // Package views serves per-client static answers for configured
 // zones. A query whose source IP falls inside one of a view's
-// CIDRs gets that view's records as the response; queries that
-// don't match any view (by source IP or by name) fall through the
+// CIDRs, or whose client certificate identity is one of its
+// identities, gets that view's records as the response; queries that
+// don't match any view (by client or by name) fall through the
 // chain to the regular resolution path.
 //
 // Views are intentionally evaluated before blocklist and resolver
@@ -25,12 +26,17 @@ import (
 // evaluated in the order they appeared in cfg.Views.
 type Views struct {
 	views []*compiledView
+
+	// listeners holds, by ListenerPolicy.Index, the view a [[listeners]]
+	// entry answers every client with, nil for entries without one.
+	listeners []*compiledView
 }
 
 type compiledView struct {
-	zone     string
-	networks *ipset.Set
-	answers  []dns.RR
+	zone       string
+	networks   *ipset.Set
+	identities *middleware.IdentitySet
+	answers    []dns.RR
 }
 
 // New parses cfg.Views into compiled in-memory tables. Malformed
@@ -46,8 +52,9 @@ func New(cfg *config.Config) *Views {
 			zlog.Error("View network CIDR parse failed", "view", vc.Zone, "cidr", entry.CIDR, "error", entry.Err.Error())
 		}
 		cv := &compiledView{
-			zone:     vc.Zone,
-			networks: networks,
+			zone:       vc.Zone,
+			networks:   networks,
+			identities: middleware.NewIdentitySet(vc.Identities),
 		}
 		for _, rr := range vc.Answers {
 			parsed, err := dns.NewRR(rr)
@@ -63,6 +70,22 @@ func New(cfg *config.Config) *Views {
 		}
 		v.views = append(v.views, cv)
 	}
+
+	v.listeners = make([]*compiledView, len(cfg.Listeners))
+	for i, l := range cfg.Listeners {
+		if l.View == "" {
+			continue
+		}
+		for _, cv := range v.views {
+			if cv.zone == l.View {
+				v.listeners[i] = cv
+				break
+			}
+		}
+		if v.listeners[i] == nil {
+			zlog.Error("Listener view not found", "listener", l.Label(), "view", l.View)
+		}
+	}
 	return v
 }
 
@@ -76,7 +99,9 @@ func (v *Views) Name() string { return name }
 func (v *Views) ClientOnly() bool { return true }
 
 // (*Views).ServeDNS dispatches a query to the first view whose
-// source CIDR contains the client IP. If a record matches the
+// source CIDR contains the client IP or whose identities name the
+// client's certificate, or, on a listener that names a
+// view, to that view alone. If a record matches the
 // query's name and type, the synthesised reply is written and the
 // chain is short-circuited; otherwise the request falls through.
 func (v *Views) ServeDNS(ctx context.Context, ch *middleware.Chain) {
@@ -99,8 +124,14 @@ func (v *Views) ServeDNS(ctx context.Context, ch *middleware.Chain) {
 	q := req.Question[0]
 	qname := dns.CanonicalName(q.Name)
 
-	for _, cv := range v.views {
-		if !cv.networks.ContainsIP(clientIP) {
+	views, pinned := v.views, false
+	if p := ch.ListenerPolicy(); p != nil && p.Index < len(v.listeners) && v.listeners[p.Index] != nil {
+		views, pinned = v.listeners[p.Index:p.Index+1], true
+	}
+
+	identity := ch.ClientIdentity()
+	for _, cv := range views {
+		if !pinned && !cv.networks.ContainsIP(clientIP) && !cv.identities.Contains(identity) {
 			continue
 		}
 
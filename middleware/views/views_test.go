@@ -263,3 +263,84 @@ func TestViews_WildcardBoundary(t *testing.T) {
 		t.Errorf("nameMatches('router.local.', 'sub.router.local.') is true")
 	}
 }
+
+func TestViews_ListenerPinsView(t *testing.T) {
+	cfg := &config.Config{
+		Views: []config.ViewConfig{
+			{Zone: "lannet", Networks: []string{"192.168.1.0/24"}, Answers: []string{"*.example.lan. 60 IN A 192.168.1.3"}},
+			{Zone: "vpnnet", Networks: []string{"100.64.0.0/24"}, Answers: []string{"*.example.lan. 60 IN A 100.64.0.2"}},
+		},
+		Listeners: []config.ListenerConfig{
+			{Name: "wireguard", Proto: "dns", Addr: "10.8.0.1:53", View: "vpnnet"},
+			{Name: "lan", Proto: "dns", Addr: "192.168.1.1:53"},
+		},
+	}
+	v := New(cfg)
+	policies := middleware.NewListenerPolicies(cfg)
+
+	serve := func(client string, p *middleware.ListenerPolicy) *dns.Msg {
+		ch := middleware.NewChain([]middleware.Handler{v})
+		req := new(dns.Msg)
+		req.SetQuestion("host.example.lan.", dns.TypeA)
+		mw := mock.NewWriter("udp", client)
+		mw.Policy = p
+		ch.Reset(mw, req)
+		v.ServeDNS(context.Background(), ch)
+		if !ch.Writer.Written() {
+			return nil
+		}
+		return ch.Writer.Msg()
+	}
+
+	// On the wireguard listener every client gets vpnnet, whatever its
+	// address — including one inside lannet's networks.
+	for _, client := range []string{"10.8.0.7:5353", "192.168.1.42:5353"} {
+		resp := serve(client, policies[0])
+		if resp == nil || len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "100.64.0.2" {
+			t.Fatalf("client %s on wireguard: %v", client, resp)
+		}
+	}
+
+	// A listener without a view keeps the network match.
+	if resp := serve("192.168.1.42:5353", policies[1]); resp == nil || resp.Answer[0].(*dns.A).A.String() != "192.168.1.3" {
+		t.Fatalf("lan client on lan: %v", resp)
+	}
+	if resp := serve("10.8.0.7:5353", policies[1]); resp != nil {
+		t.Fatalf("wireguard client on lan got a view answer: %v", resp)
+	}
+}
+
+func TestViews_ClientIdentity(t *testing.T) {
+	v := New(&config.Config{
+		Views: []config.ViewConfig{
+			{Zone: "lannet", Networks: []string{"192.168.1.0/24"}, Answers: []string{"*.example.lan. 60 IN A 192.168.1.3"}},
+			{Zone: "laptops", Identities: []string{"spiffe://example.com/laptop/*"}, Answers: []string{"*.example.lan. 60 IN A 100.64.0.2"}},
+		},
+	})
+
+	serve := func(client, identity string) *dns.Msg {
+		ch := middleware.NewChain([]middleware.Handler{v})
+		req := new(dns.Msg)
+		req.SetQuestion("host.example.lan.", dns.TypeA)
+		mw := mock.NewWriter("tcp", client)
+		mw.Identity = identity
+		ch.Reset(mw, req)
+		v.ServeDNS(context.Background(), ch)
+		if !ch.Writer.Written() {
+			return nil
+		}
+		return ch.Writer.Msg()
+	}
+
+	// A laptop gets its view from any network, a hotel's NAT included.
+	if resp := serve("203.0.113.9:5353", "spiffe://example.com/laptop/7"); resp == nil || resp.Answer[0].(*dns.A).A.String() != "100.64.0.2" {
+		t.Fatalf("laptop abroad: %v", resp)
+	}
+	// Views are still evaluated in order: on the LAN, lannet wins.
+	if resp := serve("192.168.1.42:5353", "spiffe://example.com/laptop/7"); resp == nil || resp.Answer[0].(*dns.A).A.String() != "192.168.1.3" {
+		t.Fatalf("laptop on lan: %v", resp)
+	}
+	if resp := serve("203.0.113.9:5353", "spiffe://example.com/phone/2"); resp != nil {
+		t.Fatalf("other identity got a view answer: %v", resp)
+	}
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"net"
 	"time"
 
 	"github.com/miekg/dns"
@@ -43,6 +44,17 @@ type InlineBarrier interface {
 	InlineBarrier() bool
 }
 
+// ClientGate is a ClientOnly handler whose verdict on a client can be
+// asked outside a query. The server asks every gate in the pipeline
+// before it relays an Oblivious DoH request: the request carries no
+// query the chain could read, and is still a client's use of the
+// listener, so the access list and the rate limit have to see it.
+// policy is that listener's, nil for the bind addresses; identity is
+// the client's certificate identity, empty for none.
+type ClientGate interface {
+	AdmitClient(ip net.IP, identity string, policy *ListenerPolicy) bool
+}
+
 // Store is the minimum cache facade a resolver sub-query needs.
 // Satisfied by cache.Store; declared here so middleware.Setup can
 // wire it from one handler into another without either importing
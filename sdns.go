@@ -5,6 +5,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"runtime/debug"
@@ -13,7 +14,9 @@ import (
 
 	"github.com/semihalev/sdns/api"
 	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/debuglog"
 	"github.com/semihalev/sdns/internal/metric"
+	"github.com/semihalev/sdns/internal/tracing"
 	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/sdns/middleware/defaults"
 	"github.com/semihalev/sdns/server"
@@ -64,27 +67,27 @@ func setup() error {
 	logger := zlog.NewStructured()
 
 	// Set log level based on config
-	var lvl zlog.Level
-	switch cfg.LogLevel {
-	case "debug":
-		lvl = zlog.LevelDebug
-	case "info":
-		lvl = zlog.LevelInfo
-	case "warn":
-		lvl = zlog.LevelWarn
-	case "error":
-		lvl = zlog.LevelError
-	default:
+	lvl, ok := debuglog.ParseLevel(cfg.LogLevel)
+	if !ok {
 		return fmt.Errorf("log verbosity level unknown: %s", cfg.LogLevel)
 	}
 
 	logger.SetLevel(lvl)
 
-	logger.SetWriter(zlog.StdoutTerminal())
+	w := zlog.StdoutTerminal()
+	logger.SetWriter(w)
 
 	// Set as default logger for global log calls
 	zlog.SetDefault(logger)
 
+	// Runtime level changes through the API revert to the configured
+	// level, and subsystem debug output goes to the same writer.
+	debuglog.Init(lvl, w)
+
+	if err := tracing.Setup(cfg, version); err != nil {
+		return err
+	}
+
 	defaults.Register()
 	middleware.Setup(cfg)
 
@@ -112,8 +115,18 @@ func runServer(cmd *cobra.Command, args []string) error {
 	}
 
 	api := api.New(cfg)
+	api.SetServer(srv)
 	api.Run(ctx)
 
+	// Every listener has taken its passed socket. In a process started
+	// for an upgrade they read it only once this one answers as
+	// configured, which is later: until the root is primed and the
+	// blocklists are in, its queries would fail. The process it replaces
+	// serves them until then, and stops after.
+	server.CloseUnclaimedSockets()
+	go notifyUpgradedWhenReady(ctx, srv)
+	watchUpgrade(ctx, srv, api, stop)
+
 	// Set up SIGHUP handler for certificate reload
 	sigHup := make(chan os.Signal, 1)
 	signal.Notify(sigHup, syscall.SIGHUP)
@@ -159,6 +172,19 @@ func runServer(cmd *cobra.Command, args []string) error {
 		zlog.Warn("Server shutdown timeout exceeded")
 	}
 
+	// Write out the query log records and statistics still queued; the
+	// server has stopped, so no more are coming.
+	for _, name := range []string{"querylog", "stats"} {
+		if c, ok := middleware.Get(name).(io.Closer); ok {
+			_ = c.Close()
+		}
+	}
+
+	// Export the spans still buffered, within the same grace period.
+	if err := tracing.Shutdown(shutdownCtx); err != nil {
+		zlog.Warn("Tracing shutdown failed", "error", err.Error())
+	}
+
 	// Drain the metric package's final flush so the last interval
 	// of counts reaches Prometheus before the process exits.
 	metric.Stop()
@@ -166,6 +192,39 @@ func runServer(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// notifyUpgradedWhenReady starts the listeners of a process started for
+// an upgrade once the pipeline is set up and every handler's startup work
+// is done, then tells the process it replaces that it may stop, once they
+// are serving: the same measure as the readiness probe. A process that
+// never gets there is stopped by the upgrade's own timeout, and the old
+// one serves on.
+func notifyUpgradedWhenReady(ctx context.Context, srv *server.Server) {
+	if !waitUntil(ctx, func() bool {
+		return middleware.Ready() && len(middleware.GlobalPipeline().NotReady()) == 0
+	}) {
+		return
+	}
+	srv.StartServing()
+	if !waitUntil(ctx, srv.Ready) {
+		return
+	}
+	server.NotifyUpgraded()
+}
+
+// waitUntil polls cond until it holds, reporting false if ctx ends first.
+func waitUntil(ctx context.Context, cond func() bool) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for !cond() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
 func validateConfiguration() error {
 	var err error
 
@@ -175,15 +234,17 @@ func validateConfiguration() error {
 	}
 
 	// Validate log level
-	switch cfg.LogLevel {
-	case "", "debug", "info", "warn", "error":
-		// Valid log levels
-	default:
+	if _, ok := debuglog.ParseLevel(cfg.LogLevel); !ok && cfg.LogLevel != "" {
 		err := fmt.Errorf("log verbosity level unknown: %s", cfg.LogLevel)
 		fmt.Fprintf(os.Stderr, "Configuration test failed: %v\n", err)
 		return err
 	}
 
+	if err := tracing.Validate(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration test failed: %v\n", err)
+		return err
+	}
+
 	fmt.Printf("Configuration file %s test successful\n", cfgPath)
 	return nil
 }
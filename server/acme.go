@@ -0,0 +1,385 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/middleware/acmedns"
+	"github.com/semihalev/zlog/v2"
+)
+
+const (
+	// acmeOrderTimeout bounds one order, from the first authorization
+	// to the downloaded chain.
+	acmeOrderTimeout = 5 * time.Minute
+
+	// acmeRetryMin and acmeRetryMax bound the backoff after a failed
+	// order. The CA rate-limits failed validations per hour, so the
+	// ceiling is an hour too.
+	acmeRetryMin = time.Minute
+	acmeRetryMax = time.Hour
+
+	// acmeCheckInterval is the longest the renewal loop sleeps at once:
+	// a timer set weeks ahead misses a clock step or a suspend.
+	acmeCheckInterval = 12 * time.Hour
+)
+
+// acmeIssuer orders certificates from an ACME CA (RFC 8555) for a
+// CertManager, answering the CA's challenges from this server.
+type acmeIssuer struct {
+	cfg    config.ACMEConfig
+	client *acme.Client
+
+	registered bool
+
+	// challenges are the tls-alpn-01 certificates of the authorizations
+	// in progress, by domain.
+	mu         sync.Mutex
+	challenges map[string]*tls.Certificate
+}
+
+// newACMEIssuer returns the issuer of cfg, keeping its account key in
+// dir. The key is created on first use and reused after, so renewals run
+// under the account that obtained the certificate.
+func newACMEIssuer(cfg config.ACMEConfig, dir string) (*acmeIssuer, error) {
+	key, err := loadOrCreateKey(filepath.Join(dir, "account.key"), func() (crypto.Signer, error) {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("account key: %w", err)
+	}
+
+	client := &acme.Client{Key: key, DirectoryURL: cfg.DirectoryURL, UserAgent: "sdns"}
+	if cfg.CACertificate != "" {
+		bundle, err := os.ReadFile(cfg.CACertificate)
+		if err != nil {
+			return nil, fmt.Errorf("ca certificate: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("ca certificate: no certificates in %s", cfg.CACertificate)
+		}
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}},
+			Timeout:   30 * time.Second,
+		}
+	}
+
+	return &acmeIssuer{cfg: cfg, client: client, challenges: make(map[string]*tls.Certificate)}, nil
+}
+
+// renewIn returns how long leaf may still be served: zero when there is
+// none, it does not name every configured domain, or it is within
+// renew_before of its expiry.
+func (i *acmeIssuer) renewIn(leaf *x509.Certificate) time.Duration {
+	if leaf == nil {
+		return 0
+	}
+	for _, d := range i.cfg.Domains {
+		if !slices.ContainsFunc(leaf.DNSNames, func(n string) bool { return strings.EqualFold(n, d) }) {
+			return 0
+		}
+	}
+	return max(time.Until(leaf.NotAfter.Add(-i.cfg.RenewBefore.Duration)), 0)
+}
+
+// obtain runs one order and returns the PEM chain and private key of the
+// issued certificate.
+func (i *acmeIssuer) obtain(ctx context.Context) (certPEM, keyPEM []byte, err error) {
+	ctx, cancel := context.WithTimeout(ctx, acmeOrderTimeout)
+	defer cancel()
+
+	if !i.registered {
+		var contact []string
+		if i.cfg.Email != "" {
+			contact = []string{"mailto:" + i.cfg.Email}
+		}
+		_, err := i.client.Register(ctx, &acme.Account{Contact: contact}, acme.AcceptTOS)
+		if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+			return nil, nil, fmt.Errorf("register account: %w", err)
+		}
+		i.registered = true
+	}
+
+	order, err := i.client.AuthorizeOrder(ctx, acme.DomainIDs(i.cfg.Domains...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("new order: %w", err)
+	}
+	for _, url := range order.AuthzURLs {
+		if err := i.authorize(ctx, url); err != nil {
+			return nil, nil, err
+		}
+	}
+	if _, err := i.client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, nil, fmt.Errorf("order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: strings.TrimPrefix(i.cfg.Domains[0], "*.")},
+		DNSNames: i.cfg.Domains,
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, _, err := i.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("finalize order: %w", err)
+	}
+
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyPEM, err = encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPEM, keyPEM, nil
+}
+
+// authorize satisfies one authorization of an order with the configured
+// challenge, serving the response only until the CA has decided.
+func (i *acmeIssuer) authorize(ctx context.Context, url string) error {
+	z, err := i.client.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("authorization: %w", err)
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+
+	domain := z.Identifier.Value
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == i.cfg.Challenge {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("%s: the CA offers no %s challenge", domain, i.cfg.Challenge)
+	}
+
+	switch chal.Type {
+	case config.ACMEChallengeTLSALPN:
+		cert, err := i.client.TLSALPN01ChallengeCert(chal.Token, domain)
+		if err != nil {
+			return err
+		}
+		i.setChallenge(domain, &cert)
+		defer i.setChallenge(domain, nil)
+	case config.ACMEChallengeDNS:
+		value, err := i.client.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			return err
+		}
+		name := "_acme-challenge." + domain + "."
+		acmedns.Add(name, value)
+		defer acmedns.Remove(name, value)
+	}
+
+	if _, err := i.client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("%s: accept %s challenge: %w", domain, chal.Type, err)
+	}
+	if _, err := i.client.WaitAuthorization(ctx, z.URI); err != nil {
+		return fmt.Errorf("%s: %s challenge: %w", domain, chal.Type, err)
+	}
+	return nil
+}
+
+func (i *acmeIssuer) setChallenge(domain string, cert *tls.Certificate) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	domain = strings.ToLower(domain)
+	if cert == nil {
+		delete(i.challenges, domain)
+		return
+	}
+	i.challenges[domain] = cert
+}
+
+// configForClient answers a tls-alpn-01 validation (RFC 8737): a
+// handshake offering the acme-tls/1 protocol gets the challenge
+// certificate of the name it asks for, and nothing else. Every other
+// handshake proceeds with the listener's own configuration.
+func (i *acmeIssuer) configForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if !slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+		return nil, nil
+	}
+	i.mu.Lock()
+	cert := i.challenges[strings.ToLower(hello.ServerName)]
+	i.mu.Unlock()
+	if cert == nil {
+		return nil, fmt.Errorf("no tls-alpn-01 challenge pending for %q", hello.ServerName)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		NextProtos:   []string{acme.ALPNProto},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// manageACME keeps the certificate issued: at once when there is none or
+// it is due, then again renew_before ahead of each expiry. A failed
+// order is retried with backoff.
+func (cm *CertManager) manageACME() {
+	defer close(cm.acmeDone)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-cm.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	retry := acmeRetryMin
+	for {
+		wait := cm.acme.renewIn(cm.leaf())
+		if wait == 0 {
+			if err := cm.renewACME(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				zlog.Error("ACME certificate order failed", "domains", cm.acme.cfg.Domains, "retry", retry.String(), "error", err.Error())
+				wait = retry
+				retry = min(retry*2, acmeRetryMax)
+			} else {
+				retry = acmeRetryMin
+				continue
+			}
+		}
+
+		t := time.NewTimer(min(wait, acmeCheckInterval))
+		select {
+		case <-cm.stopCh:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// renewACME orders a certificate, stores it next to the account key and
+// swaps it in.
+func (cm *CertManager) renewACME(ctx context.Context) error {
+	zlog.Info("Ordering ACME certificate", "domains", cm.acme.cfg.Domains, "challenge", cm.acme.cfg.Challenge)
+	certPEM, keyPEM, err := cm.acme.obtain(ctx)
+	if err != nil {
+		return err
+	}
+	// The key goes first: the watcher reloads on the certificate, and
+	// must not pair a new certificate with the old key.
+	if err := writeFileAtomic(cm.keyPath, keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(cm.certPath, certPEM, 0600); err != nil {
+		return err
+	}
+	return cm.Reload()
+}
+
+// leaf returns the certificate being served, nil when there is none.
+func (cm *CertManager) leaf() *x509.Certificate {
+	cm.mu.RLock()
+	cert := cm.certificate
+	cm.mu.RUnlock()
+	if cert == nil {
+		return nil
+	}
+	return cert.Leaf
+}
+
+// loadOrCreateKey reads the PEM private key at path, storing one from
+// generate there when the file does not exist. A stored key that is not
+// a K is an error.
+func loadOrCreateKey[K any](path string, generate func() (K, error)) (K, error) {
+	var zero K
+	data, err := os.ReadFile(path) //nolint:gosec // G304 - path under the configured working directory
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return zero, fmt.Errorf("%s: no PEM data", path)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return zero, fmt.Errorf("%s: %w", path, err)
+		}
+		key, ok := parsed.(K)
+		if !ok {
+			return zero, fmt.Errorf("%s: unsupported key type %T", path, parsed)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return zero, err
+	}
+
+	key, err := generate()
+	if err != nil {
+		return zero, err
+	}
+	data, err = encodeKey(key)
+	if err != nil {
+		return zero, err
+	}
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return zero, err
+	}
+	return key, nil
+}
+
+func encodeKey(key any) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// writeFileAtomic replaces path with data in one rename, so a reader —
+// the certificate watcher — never sees a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // gone after a successful rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint:gosec // G104 - the write error is the one reported
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close() //nolint:gosec // G104 - the chmod error is the one reported
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,594 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/acme"
+
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/middleware"
+	"github.com/semihalev/sdns/middleware/acmedns"
+)
+
+// acmeStandIn is a minimal RFC 8555 CA in the manner of Pebble: enough of
+// the protocol for an account, an order, its authorizations and the
+// certificate. Signatures on requests are not checked; challenges are,
+// against the server under test.
+type acmeStandIn struct {
+	t      *testing.T
+	srv    *httptest.Server
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+	// rootFile holds the stand-in's HTTPS certificate, for
+	// ca_certificate.
+	rootFile string
+
+	// alwaysValid skips validation, as if the account had proved its
+	// control of every name before.
+	alwaysValid bool
+	// tlsAddr and dnsAddr locate the server under test for tls-alpn-01
+	// and dns-01 validation.
+	tlsAddr func() string
+	dnsAddr func() string
+
+	mu       sync.Mutex
+	seq      int
+	accounts map[string]string // kid URL -> key thumbprint
+	orders   map[string]*standInOrder
+	authzs   map[string]*standInAuthz
+	chals    map[string]*standInAuthz // challenge URL -> its authorization
+	certs    map[string][]byte
+	issued   int
+}
+
+type standInOrder struct {
+	Status         string         `json:"status"`
+	Identifiers    []acme.AuthzID `json:"identifiers"`
+	Authorizations []string       `json:"authorizations"`
+	Finalize       string         `json:"finalize"`
+	Certificate    string         `json:"certificate,omitempty"`
+	url            string
+	authz          []*standInAuthz
+}
+
+type standInAuthz struct {
+	Identifier struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Status     string            `json:"status"`
+	Challenges []standInChalJSON `json:"challenges"`
+	thumbprint string
+}
+
+type standInChalJSON struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+func newACMEStandIn(t *testing.T) *acmeStandIn {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sdns ACME stand-in CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(der)
+
+	a := &acmeStandIn{
+		t: t, caKey: caKey, caCert: caCert,
+		accounts: make(map[string]string),
+		orders:   make(map[string]*standInOrder),
+		authzs:   make(map[string]*standInAuthz),
+		chals:    make(map[string]*standInAuthz),
+		certs:    make(map[string][]byte),
+	}
+	a.srv = httptest.NewTLSServer(http.HandlerFunc(a.serveHTTP))
+	t.Cleanup(a.srv.Close)
+
+	a.rootFile = filepath.Join(t.TempDir(), "acme-root.pem")
+	root := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.srv.Certificate().Raw})
+	if err := os.WriteFile(a.rootFile, root, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func (a *acmeStandIn) config(domains ...string) config.ACMEConfig {
+	cfg := config.ACMEConfig{
+		Enabled:       true,
+		Domains:       domains,
+		DirectoryURL:  a.srv.URL + "/dir",
+		CACertificate: a.rootFile,
+	}
+	cfg.Normalize()
+	return cfg
+}
+
+// roots trusts the certificates the stand-in issues.
+func (a *acmeStandIn) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(a.caCert)
+	return pool
+}
+
+func (a *acmeStandIn) orderCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.issued
+}
+
+func (a *acmeStandIn) nextURL(kind string) string {
+	a.seq++
+	return fmt.Sprintf("%s/%s/%d", a.srv.URL, kind, a.seq)
+}
+
+func (a *acmeStandIn) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", base64.RawURLEncoding.EncodeToString(big.NewInt(time.Now().UnixNano()).Bytes()))
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path == "/dir" {
+		base := a.srv.URL
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"newNonce": base + "/nonce", "newAccount": base + "/account",
+			"newOrder": base + "/order", "revokeCert": base + "/revoke", "keyChange": base + "/key-change",
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var jws struct{ Protected, Payload string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var protected struct {
+		JWK json.RawMessage `json:"jwk"`
+		KID string          `json:"kid"`
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err := json.Unmarshal(header, &protected); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	url := a.srv.URL + r.URL.Path
+
+	switch {
+	case r.URL.Path == "/account":
+		thumb := jwkThumbprint(a.t, protected.JWK)
+		for kid, tp := range a.accounts {
+			if tp == thumb {
+				w.Header().Set("Location", kid)
+				_, _ = w.Write([]byte(`{"status":"valid"}`))
+				return
+			}
+		}
+		kid := a.nextURL("acct")
+		a.accounts[kid] = thumb
+		w.Header().Set("Location", kid)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"status":"valid"}`))
+
+	case r.URL.Path == "/order":
+		var req struct{ Identifiers []acme.AuthzID }
+		_ = json.Unmarshal(payload, &req)
+		o := &standInOrder{Status: "pending", Identifiers: req.Identifiers, url: a.nextURL("order")}
+		o.Finalize = a.nextURL("finalize")
+		for _, id := range req.Identifiers {
+			z := &standInAuthz{Status: "pending", thumbprint: a.accounts[protected.KID]}
+			z.Identifier.Type, z.Identifier.Value = id.Type, id.Value
+			if a.alwaysValid {
+				z.Status = "valid"
+			}
+			for _, typ := range []string{config.ACMEChallengeTLSALPN, config.ACMEChallengeDNS} {
+				c := standInChalJSON{Type: typ, URL: a.nextURL("chal"), Token: fmt.Sprintf("token-%d", a.seq), Status: "pending"}
+				z.Challenges = append(z.Challenges, c)
+				a.chals[c.URL] = z
+			}
+			zurl := a.nextURL("authz")
+			a.authzs[zurl] = z
+			o.authz = append(o.authz, z)
+			o.Authorizations = append(o.Authorizations, zurl)
+		}
+		a.orders[o.url] = o
+		a.orders[o.Finalize] = o
+		a.refresh(o)
+		w.Header().Set("Location", o.url)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(o)
+
+	case a.authzs[url] != nil:
+		_ = json.NewEncoder(w).Encode(a.authzs[url])
+
+	case a.chals[url] != nil:
+		z := a.chals[url]
+		var chal *standInChalJSON
+		for i := range z.Challenges {
+			if z.Challenges[i].URL == url {
+				chal = &z.Challenges[i]
+			}
+		}
+		// Validation dials back into the server under test, which
+		// must not wait on this lock.
+		a.mu.Unlock()
+		err := a.validate(chal.Type, z.Identifier.Value, chal.Token+"."+z.thumbprint)
+		a.mu.Lock()
+		if err != nil {
+			a.t.Logf("stand-in: %s validation of %s failed: %v", chal.Type, z.Identifier.Value, err)
+			chal.Status, z.Status = "invalid", "invalid"
+		} else {
+			chal.Status, z.Status = "valid", "valid"
+		}
+		_ = json.NewEncoder(w).Encode(chal)
+
+	case a.orders[url] != nil && strings.Contains(url, "/finalize/"):
+		o := a.orders[url]
+		var req struct{ CSR string }
+		_ = json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || o.Status != "ready" {
+			http.Error(w, `{"type":"urn:ietf:params:acme:error:orderNotReady"}`, http.StatusForbidden)
+			return
+		}
+		o.Certificate = a.nextURL("cert")
+		a.certs[o.Certificate] = a.issue(csr)
+		a.issued++
+		o.Status = "valid"
+		w.Header().Set("Location", o.url)
+		_ = json.NewEncoder(w).Encode(o)
+
+	case a.orders[url] != nil:
+		o := a.orders[url]
+		a.refresh(o)
+		_ = json.NewEncoder(w).Encode(o)
+
+	case a.certs[url] != nil:
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(a.certs[url])
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// refresh moves a pending order to ready once every authorization is.
+func (a *acmeStandIn) refresh(o *standInOrder) {
+	if o.Status != "pending" {
+		return
+	}
+	for _, z := range o.authz {
+		if z.Status == "invalid" {
+			o.Status = "invalid"
+			return
+		}
+		if z.Status != "valid" {
+			return
+		}
+	}
+	o.Status = "ready"
+}
+
+func (a *acmeStandIn) issue(csr *x509.CertificateRequest) []byte {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.caCert, csr.PublicKey, a.caKey)
+	if err != nil {
+		a.t.Errorf("stand-in: issue: %v", err)
+		return nil
+	}
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.caCert.Raw})...)
+}
+
+// validate performs a challenge against the server under test, the way
+// a CA's validation authority does.
+func (a *acmeStandIn) validate(typ, domain, keyAuth string) error {
+	digest := sha256.Sum256([]byte(keyAuth))
+	switch typ {
+	case config.ACMEChallengeTLSALPN:
+		conn, err := tls.Dial("tcp", waitAddr(a.tlsAddr), &tls.Config{
+			ServerName:         domain,
+			NextProtos:         []string{acme.ALPNProto},
+			InsecureSkipVerify: true, //nolint:gosec // G402 - the challenge certificate is self-signed by design
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		state := conn.ConnectionState()
+		if state.NegotiatedProtocol != acme.ALPNProto {
+			return fmt.Errorf("negotiated %q", state.NegotiatedProtocol)
+		}
+		for _, ext := range state.PeerCertificates[0].Extensions {
+			if ext.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}) {
+				var got []byte
+				if _, err := asn1.Unmarshal(ext.Value, &got); err != nil {
+					return err
+				}
+				if string(got) != string(digest[:]) {
+					return fmt.Errorf("acmeIdentifier mismatch")
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("no acmeIdentifier extension")
+
+	case config.ACMEChallengeDNS:
+		req := new(dns.Msg)
+		req.SetQuestion("_acme-challenge."+domain+".", dns.TypeTXT)
+		resp, _, err := (&dns.Client{Timeout: 2 * time.Second}).Exchange(req, waitAddr(a.dnsAddr))
+		if err != nil {
+			return err
+		}
+		want := base64.RawURLEncoding.EncodeToString(digest[:])
+		for _, rr := range resp.Answer {
+			if txt, ok := rr.(*dns.TXT); ok && len(txt.Txt) == 1 && txt.Txt[0] == want {
+				return nil
+			}
+		}
+		return fmt.Errorf("TXT %q not found in %v", want, resp.Answer)
+	}
+	return fmt.Errorf("unknown challenge %q", typ)
+}
+
+// waitAddr waits for the server under test to have bound its listener.
+func waitAddr(addr func() string) string {
+	for range 100 {
+		if a := addr(); a != "" {
+			return a
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return ""
+}
+
+func jwkThumbprint(t *testing.T, raw json.RawMessage) string {
+	var jwk struct{ Crv, X, Y string }
+	if err := json.Unmarshal(raw, &jwk); err != nil || jwk.Crv != "P-256" {
+		t.Errorf("stand-in: unexpected account key %s", raw)
+		return ""
+	}
+	x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+	y, _ := base64.RawURLEncoding.DecodeString(jwk.Y)
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	thumb, err := acme.JWKThumbprint(pub)
+	if err != nil {
+		t.Error(err)
+	}
+	return thumb
+}
+
+// activeAddr returns the bound address of s's first active listener of
+// proto, empty before Run has bound it.
+func activeAddr(s *Server, proto string) string {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	for _, l := range s.active {
+		if l.Proto() != proto {
+			continue
+		}
+		switch v := l.(type) {
+		case *udpListener:
+			return v.pcs[0].LocalAddr().String()
+		case *tlsListener:
+			return v.ln.Addr().String()
+		}
+	}
+	return ""
+}
+
+// waitIssued dials addr until it presents a certificate the stand-in
+// issued for domain.
+func waitIssued(t *testing.T, a *acmeStandIn, addr, domain string) *x509.Certificate {
+	t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: domain, RootCAs: a.roots(), MinVersion: tls.VersionTLS12})
+		if err == nil {
+			leaf := conn.ConnectionState().PeerCertificates[0]
+			conn.Close()
+			return leaf
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no certificate for %s on %s: %v", domain, addr, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func runACMEServer(t *testing.T, cfg *config.Config) *Server {
+	t.Helper()
+	middleware.Reset()
+	t.Cleanup(middleware.Reset)
+	middleware.Register("acmedns", func(cfg *config.Config) middleware.Handler { return acmedns.New(cfg) })
+	middleware.Setup(cfg)
+
+	s := New(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Run(ctx); err != nil {
+		cancel()
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		deadline := time.Now().Add(5 * time.Second)
+		for !s.Stopped() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	return s
+}
+
+func TestACMETLSALPN01(t *testing.T) {
+	ca := newACMEStandIn(t)
+	cfg := &config.Config{
+		Directory:    t.TempDir(),
+		Bind:         "127.0.0.1:0",
+		BindTLS:      "127.0.0.1:0",
+		ACME:         ca.config("dns.example.test"),
+		QueryTimeout: config.Duration{Duration: 2 * time.Second},
+	}
+	var s *Server
+	ca.tlsAddr = func() string { return activeAddr(s, "tls") }
+	s = runACMEServer(t, cfg)
+
+	leaf := waitIssued(t, ca, activeAddr(s, "tls"), "dns.example.test")
+	if leaf.DNSNames[0] != "dns.example.test" {
+		t.Errorf("issued for %v", leaf.DNSNames)
+	}
+	for _, f := range []string{"account.key", "cert.pem", "key.pem"} {
+		if _, err := os.Stat(filepath.Join(cfg.Directory, "acme", f)); err != nil {
+			t.Errorf("%s not stored: %v", f, err)
+		}
+	}
+
+	// With the order done, acme-tls/1 gets no certificate.
+	_, err := tls.Dial("tcp", activeAddr(s, "tls"), &tls.Config{
+		ServerName: "dns.example.test", NextProtos: []string{acme.ALPNProto},
+		InsecureSkipVerify: true, //nolint:gosec // G402 - probing the challenge path
+	})
+	if err == nil {
+		t.Error("acme-tls/1 handshake succeeded with no challenge pending")
+	}
+}
+
+func TestACMEDNS01(t *testing.T) {
+	ca := newACMEStandIn(t)
+	cfg := &config.Config{
+		Directory:    t.TempDir(),
+		Bind:         "127.0.0.1:0",
+		BindTLS:      "127.0.0.1:0",
+		ACME:         ca.config("*.example.test", "example.test"),
+		QueryTimeout: config.Duration{Duration: 2 * time.Second},
+	}
+	cfg.ACME.Challenge = config.ACMEChallengeDNS
+	var s *Server
+	ca.dnsAddr = func() string { return activeAddr(s, "udp") }
+	s = runACMEServer(t, cfg)
+
+	leaf := waitIssued(t, ca, activeAddr(s, "tls"), "host.example.test")
+	if len(leaf.DNSNames) != 2 {
+		t.Errorf("issued for %v", leaf.DNSNames)
+	}
+
+	// The challenge records are gone with the order.
+	req := new(dns.Msg)
+	req.SetQuestion("_acme-challenge.example.test.", dns.TypeTXT)
+	if resp, _, err := new(dns.Client).Exchange(req, activeAddr(s, "udp")); err == nil && len(resp.Answer) > 0 {
+		t.Errorf("challenge record still served: %v", resp.Answer)
+	}
+}
+
+func TestACMERenewal(t *testing.T) {
+	ca := newACMEStandIn(t)
+	ca.alwaysValid = true
+	dir := t.TempDir()
+	cfg := ca.config("dns.example.test")
+
+	waitLeaf := func(cm *CertManager, not *big.Int) *x509.Certificate {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			if leaf := cm.leaf(); leaf != nil && (not == nil || leaf.SerialNumber.Cmp(not) != 0) {
+				return leaf
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("no certificate issued")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	cm, err := NewACMECertManager(cfg, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := waitLeaf(cm, nil)
+	cm.Stop()
+	if n := ca.orderCount(); n != 1 {
+		t.Fatalf("%d certificates issued, want 1", n)
+	}
+
+	// A restart serves the stored certificate and orders nothing.
+	cm, err = NewACMECertManager(cfg, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf := cm.leaf(); leaf == nil || leaf.SerialNumber.Cmp(first.SerialNumber) != 0 {
+		t.Fatal("stored certificate not loaded")
+	}
+	time.Sleep(200 * time.Millisecond)
+	cm.Stop()
+	if n := ca.orderCount(); n != 1 {
+		t.Fatalf("%d certificates issued after a restart, want 1", n)
+	}
+
+	// Due for renewal: a new one is ordered and swapped in.
+	cfg.RenewBefore.Duration = 100 * 24 * time.Hour
+	cm, err = NewACMECertManager(cfg, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm.Stop()
+	waitLeaf(cm, first.SerialNumber)
+	if n := ca.orderCount(); n < 2 {
+		t.Fatalf("%d certificates issued, want a renewal", n)
+	}
+
+	// A domain added to the configuration is a renewal too.
+	iss := &acmeIssuer{cfg: ca.config("dns.example.test", "other.example.test")}
+	if iss.renewIn(first) != 0 {
+		t.Error("certificate missing a configured domain not due")
+	}
+}
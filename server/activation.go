@@ -0,0 +1,234 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/semihalev/zlog/v2"
+)
+
+// listenFDsStart is the first descriptor systemd passes (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// The environment of a process started by an upgrade, besides
+// LISTEN_FDS and LISTEN_FDNAMES: the pid of the process it replaces,
+// and the descriptor it reports readiness on (upgrade.go).
+const (
+	envUpgrade      = "SDNS_UPGRADE"
+	envUpgradeReady = "SDNS_UPGRADE_READY"
+)
+
+// Activation names of the bind-address listeners' sockets: the
+// FileDescriptorName of the systemd .socket unit passing them, which is
+// one name for all of a unit's sockets. A [[listeners]] entry's sockets
+// are named after the entry. A name carries stream and datagram sockets
+// both, and the kind picks the listener: "dns" is UDP and TCP, "doh" is
+// DoH over TCP and DoH3 over UDP, "dnscrypt" DNSCrypt over both.
+const (
+	socketDNS      = "dns"
+	socketTLS      = "tls"
+	socketDoH      = "doh"
+	socketDoQ      = "doq"
+	socketUnix     = "unix"
+	socketDNSCrypt = "dnscrypt"
+)
+
+// inheritedSocket is one socket passed by systemd, converted once: a
+// stream socket to a listener, a datagram socket to a packet conn.
+type inheritedSocket struct {
+	name string
+	ln   net.Listener
+	pc   net.PacketConn
+}
+
+var inherited struct {
+	once    sync.Once
+	mu      sync.Mutex
+	sockets []inheritedSocket
+}
+
+// loadInherited reads the sockets of the LISTEN_FDS protocol once per
+// process and clears its variables, so nothing started from here takes
+// them for its own.
+func loadInherited() {
+	inherited.once.Do(func() {
+		from := "systemd"
+		if os.Getenv("LISTEN_PID") == "" {
+			from = "upgrade"
+		}
+		sockets, err := inheritSockets(os.Getenv, os.Getpid(), os.Getppid(), func(fd int, name string) *os.File {
+			return os.NewFile(uintptr(fd), name) //nolint:gosec // G115 - fd counted up from 3
+		})
+		for _, v := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", envUpgrade} {
+			_ = os.Unsetenv(v)
+		}
+		if err != nil {
+			zlog.Error("Inherited sockets unusable, binding configured addresses", "from", from, "error", err.Error())
+			return
+		}
+		for _, s := range sockets {
+			zlog.Info("Socket inherited", "from", from, "name", s.name, "addr", s.addr())
+		}
+		inherited.sockets = sockets
+	})
+}
+
+// inheritSockets converts the descriptors the environment names to
+// sockets. Descriptors meant for another process (LISTEN_PID) are left
+// alone; a name that is not given is "unknown", as systemd has it. A
+// process started by an upgrade cannot be named before it exists, so
+// its parent names itself instead (upgrade.go).
+func inheritSockets(getenv func(string) string, pid, ppid int, file func(fd int, name string) *os.File) ([]inheritedSocket, error) {
+	owner, want := getenv("LISTEN_PID"), pid
+	if owner == "" {
+		owner, want = getenv(envUpgrade), ppid
+	}
+	if owner == "" {
+		return nil, nil
+	}
+	if p, err := strconv.Atoi(owner); err != nil || p != want {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", getenv("LISTEN_FDS"))
+	}
+	var names []string
+	if v := getenv("LISTEN_FDNAMES"); v != "" {
+		names = strings.Split(v, ":")
+	}
+
+	sockets := make([]inheritedSocket, 0, n)
+	for i := range n {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := file(listenFDsStart+i, name)
+		s := inheritedSocket{name: name}
+		// A listening stream socket or a datagram socket; the net package
+		// duplicates the descriptor either way.
+		if s.ln, err = net.FileListener(f); err != nil {
+			s.pc, err = net.FilePacketConn(f)
+		}
+		_ = f.Close()
+		if err != nil {
+			for _, open := range sockets {
+				open.close()
+			}
+			return nil, fmt.Errorf("descriptor %d (%s): %w", listenFDsStart+i, name, err)
+		}
+		sockets = append(sockets, s)
+	}
+	return sockets, nil
+}
+
+func (s inheritedSocket) addr() string {
+	if s.ln != nil {
+		return s.ln.Addr().String()
+	}
+	return s.pc.LocalAddr().String()
+}
+
+func (s inheritedSocket) close() {
+	if s.ln != nil {
+		_ = s.ln.Close()
+	}
+	if s.pc != nil {
+		_ = s.pc.Close()
+	}
+}
+
+// inheritedListener hands over the stream socket systemd passed as name,
+// nil without one. Each socket is handed over once.
+func inheritedListener(name string) net.Listener {
+	if s := takeInherited(name, true, false); len(s) > 0 {
+		return s[0].ln
+	}
+	return nil
+}
+
+// InheritedListener hands over the stream socket passed to the process
+// as name, by systemd or by the process it was upgraded from; nil
+// without one. Each socket is handed over once.
+func InheritedListener(name string) net.Listener {
+	return inheritedListener(name)
+}
+
+// inheritedPacketConn hands over the datagram socket systemd passed as
+// name, nil without one.
+func inheritedPacketConn(name string) net.PacketConn {
+	if s := takeInherited(name, false, false); len(s) > 0 {
+		return s[0].pc
+	}
+	return nil
+}
+
+// inheritedPacketConns hands over every datagram socket systemd passed
+// as name: a unit may open one per address, or several on one port with
+// ReusePort= for the UDP readers.
+func inheritedPacketConns(name string) []net.PacketConn {
+	var pcs []net.PacketConn
+	for _, s := range takeInherited(name, false, true) {
+		pcs = append(pcs, s.pc)
+	}
+	return pcs
+}
+
+func takeInherited(name string, stream, all bool) []inheritedSocket {
+	if name == "" {
+		return nil
+	}
+	loadInherited()
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+	var taken []inheritedSocket
+	keep := inherited.sockets[:0]
+	for _, s := range inherited.sockets {
+		if s.name == name && (s.ln != nil) == stream && (all || len(taken) == 0) {
+			taken = append(taken, s)
+			continue
+		}
+		keep = append(keep, s)
+	}
+	inherited.sockets = keep
+	return taken
+}
+
+// CloseUnclaimedSockets closes the sockets passed to the process that no
+// listener took — a socket unit naming a listener the config does not
+// have — once every listener, the API's as well, has taken its own.
+func CloseUnclaimedSockets() {
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+	for _, s := range inherited.sockets {
+		zlog.Warn("Inherited socket matches no listener, closed", "name", s.name, "addr", s.addr())
+		s.close()
+	}
+	inherited.sockets = nil
+}
+
+// PassedSocket is a bound socket handed to the process an upgrade
+// starts, under the activation name its listener there claims.
+type PassedSocket struct {
+	Name string
+	Conn syscall.Conn
+}
+
+// socketPasser is a listener whose sockets an upgrade can hand over.
+type socketPasser interface {
+	passSockets() []PassedSocket
+}
+
+// passSocket names c for an upgrade, nothing when it has no descriptor.
+func passSocket(name string, c any) []PassedSocket {
+	if sc, ok := c.(syscall.Conn); ok && name != "" {
+		return []PassedSocket{{Name: name, Conn: sc}}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/middleware"
+)
+
+// activationFiles opens a TCP listener and a UDP socket on loopback and
+// returns their descriptors as systemd would pass them, in that order.
+func activationFiles(t *testing.T) []*os.File {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	lf, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := pc.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*os.File{lf, pf}
+}
+
+func TestInheritSockets(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string { return vars[k] }
+	}
+	pid := os.Getpid()
+
+	files := activationFiles(t)
+	file := func(fd int, _ string) *os.File { return files[fd-listenFDsStart] }
+	sockets, err := inheritSockets(env(map[string]string{
+		"LISTEN_PID":     strconv.Itoa(pid),
+		"LISTEN_FDS":     "2",
+		"LISTEN_FDNAMES": "dns:",
+	}), pid, 1, file)
+	if err != nil {
+		t.Fatalf("inheritSockets() error = %v", err)
+	}
+	if len(sockets) != 2 {
+		t.Fatalf("%d sockets, want 2", len(sockets))
+	}
+	if sockets[0].name != "dns" || sockets[0].ln == nil {
+		t.Errorf("first socket = %q, listener %v; want a dns stream socket", sockets[0].name, sockets[0].ln)
+	}
+	if sockets[1].name != "unknown" || sockets[1].pc == nil {
+		t.Errorf("second socket = %q, conn %v; want an unnamed datagram socket", sockets[1].name, sockets[1].pc)
+	}
+	for _, s := range sockets {
+		s.close()
+	}
+
+	// Meant for another process, or not passed at all.
+	for _, vars := range []map[string]string{
+		{"LISTEN_PID": strconv.Itoa(pid + 1), "LISTEN_FDS": "2"},
+		{envUpgrade: strconv.Itoa(pid), "LISTEN_FDS": "2"},
+		{"LISTEN_FDS": "2"},
+	} {
+		sockets, err := inheritSockets(env(vars), pid, 1, func(int, string) *os.File {
+			t.Fatal("descriptor taken")
+			return nil
+		})
+		if err != nil || sockets != nil {
+			t.Errorf("inheritSockets(%v) = %v, %v; want nothing", vars, sockets, err)
+		}
+	}
+
+	// Passed by the process upgraded from.
+	files = activationFiles(t)
+	sockets, err = inheritSockets(env(map[string]string{
+		envUpgrade:       "1",
+		"LISTEN_FDS":     "2",
+		"LISTEN_FDNAMES": "dns:dns",
+	}), pid, 1, file)
+	if err != nil || len(sockets) != 2 {
+		t.Fatalf("inheritSockets() upgrade = %d sockets, %v; want 2", len(sockets), err)
+	}
+	for _, s := range sockets {
+		s.close()
+	}
+
+	if _, err := inheritSockets(env(map[string]string{"LISTEN_PID": strconv.Itoa(pid), "LISTEN_FDS": "x"}), pid, 1, nil); err == nil {
+		t.Error("inheritSockets() accepted LISTEN_FDS=x")
+	}
+}
+
+// setInherited stands in for the LISTEN_FDS environment of a process
+// started by systemd.
+func setInherited(t *testing.T, sockets ...inheritedSocket) {
+	t.Helper()
+	loadInherited()
+	inherited.mu.Lock()
+	inherited.sockets = append(inherited.sockets, sockets...)
+	inherited.mu.Unlock()
+	t.Cleanup(CloseUnclaimedSockets)
+}
+
+func TestServerAdoptsActivatedSockets(t *testing.T) {
+	middleware.Reset()
+	t.Cleanup(middleware.Reset)
+	middleware.Register("listener-name-stub", func(*config.Config) middleware.Handler {
+		return listenerNameStub{}
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lanPC, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stray, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setInherited(t,
+		inheritedSocket{name: socketDNS, pc: pc},
+		inheritedSocket{name: socketDNS, ln: ln},
+		inheritedSocket{name: "lan", pc: lanPC},
+		inheritedSocket{name: "vpn", ln: stray},
+	)
+
+	// Addresses nothing here can bind: serving at all means the
+	// activated sockets were adopted.
+	cfg := &config.Config{
+		Bind:         "192.0.2.1:53",
+		Listeners:    []config.ListenerConfig{{Name: "lan", Proto: "udp", Addr: "192.0.2.1:53"}},
+		QueryTimeout: config.Duration{Duration: 2 * time.Second},
+	}
+	middleware.Setup(cfg)
+	s := New(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		deadline := time.Now().Add(5 * time.Second)
+		for !s.Stopped() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	for _, e := range []struct{ proto, addr, want string }{
+		{"udp", pc.LocalAddr().String(), defaultListener},
+		{"tcp", ln.Addr().String(), defaultListener},
+		{"udp", lanPC.LocalAddr().String(), "lan"},
+	} {
+		req := new(dns.Msg)
+		req.SetQuestion("listener.example.", dns.TypeTXT)
+		client := &dns.Client{Net: e.proto, Timeout: 3 * time.Second}
+		var resp *dns.Msg
+		for range 20 {
+			if resp, _, err = client.Exchange(req, e.addr); err == nil {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("exchange over %s %s: %v", e.proto, e.addr, err)
+		}
+		if got := answeredBy(t, resp); got != e.want {
+			t.Errorf("%s %s answered by %q, want %q", e.proto, e.addr, got, e.want)
+		}
+	}
+
+	// The socket no listener is named for is closed, not left to queue
+	// connections nobody accepts.
+	CloseUnclaimedSockets()
+	if _, err := stray.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("unclaimed socket Accept() error = %v, want closed", err)
+	}
+}
+
+func TestUnixListener(t *testing.T) {
+	middleware.Reset()
+	t.Cleanup(middleware.Reset)
+	middleware.Register("listener-name-stub", func(*config.Config) middleware.Handler {
+		return listenerNameStub{}
+	})
+
+	path := filepath.Join(t.TempDir(), "dns.sock")
+	// A socket file left by a run that did not shut down is replaced.
+	old, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = old.Close()
+
+	cfg := &config.Config{
+		Bind:         "127.0.0.1:0",
+		BindUnix:     path,
+		BindUnixMode: "0600",
+		QueryTimeout: config.Duration{Duration: 2 * time.Second},
+	}
+	middleware.Setup(cfg)
+	s := New(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		deadline := time.Now().Add(5 * time.Second)
+		for !s.Stopped() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %o, want 600", fi.Mode().Perm())
+	}
+
+	var conn *dns.Conn
+	for range 20 {
+		var c net.Conn
+		if c, err = net.Dial("unix", path); err == nil {
+			conn = &dns.Conn{Conn: c}
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	// Two queries on one connection: the stream is framed as over TCP.
+	for range 2 {
+		req := new(dns.Msg)
+		req.SetQuestion("listener.example.", dns.TypeTXT)
+		if err := conn.WriteMsg(req); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		resp, err := conn.ReadMsg()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if resp.Id != req.Id {
+			t.Fatalf("reply id %d, want %d", resp.Id, req.Id)
+		}
+		if got := answeredBy(t, resp); got != defaultListener {
+			t.Errorf("answered by %q, want %q", got, defaultListener)
+		}
+	}
+}
@@ -3,13 +3,16 @@ package server
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/semihalev/sdns/config"
 	"github.com/semihalev/zlog/v2"
 )
 
@@ -25,6 +28,11 @@ type CertManager struct {
 	watcher *fsnotify.Watcher
 	stopCh  chan struct{}
 	doneCh  chan struct{}
+
+	// acme, when set, issues the certificate at certPath itself and
+	// renews it; acmeDone closes when its renewal loop has exited.
+	acme     *acmeIssuer
+	acmeDone chan struct{}
 }
 
 // NewCertManager creates a new certificate manager
@@ -74,6 +82,52 @@ func NewCertManager(certPath, keyPath string) (*CertManager, error) {
 	return cm, nil
 }
 
+// NewACMECertManager creates a certificate manager whose certificate is
+// obtained from an ACME CA and renewed ahead of expiry. The certificate,
+// its key and the account key live in dir/acme. A certificate stored by
+// an earlier run is served at once; without one, handshakes fail until
+// the first order completes in the background.
+func NewACMECertManager(cfg config.ACMEConfig, dir string) (*CertManager, error) {
+	dir = filepath.Join(dir, "acme")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create ACME directory: %w", err)
+	}
+	issuer, err := newACMEIssuer(cfg, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	cm := &CertManager{
+		certPath: filepath.Join(dir, "cert.pem"),
+		keyPath:  filepath.Join(dir, "key.pem"),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+		acme:     issuer,
+		acmeDone: make(chan struct{}),
+	}
+
+	// Expired or missing is not an error here: it is what the renewal
+	// loop is for.
+	if err := cm.loadCertificate(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		zlog.Warn("Stored ACME certificate not usable, ordering a new one", "cert", cm.certPath, "error", err.Error())
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watcher: %w", err)
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close() //nolint:gosec // G104 - cleanup on error path
+		return nil, fmt.Errorf("failed to watch certificate directory: %w", err)
+	}
+	cm.watcher = watcher
+
+	go cm.watch()
+	go cm.manageACME()
+
+	return cm, nil
+}
+
 // loadCertificate loads the certificate from disk
 func (cm *CertManager) loadCertificate() error {
 	cert, err := tls.LoadX509KeyPair(cm.certPath, cm.keyPath)
@@ -102,16 +156,22 @@ func (cm *CertManager) loadCertificate() error {
 	return nil
 }
 
-// validateCertificate validates the certificate chain and expiration
+// validateCertificate validates the certificate chain and expiration,
+// and leaves the parsed leaf in cert.Leaf.
 func (cm *CertManager) validateCertificate(cert *tls.Certificate) error {
 	if cert == nil || len(cert.Certificate) == 0 {
 		return fmt.Errorf("empty certificate chain")
 	}
 
-	// Parse the leaf certificate
-	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
-	if err != nil {
-		return fmt.Errorf("failed to parse certificate: %w", err)
+	// Parse the leaf certificate, once: it is kept on cert for whoever
+	// reads the served certificate's names — the DDR records among them.
+	x509Cert := cert.Leaf
+	if x509Cert == nil {
+		var err error
+		if x509Cert, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		cert.Leaf = x509Cert
 	}
 
 	// Check if certificate is expired
@@ -147,10 +207,14 @@ func (cm *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certific
 // GetTLSConfig returns a TLS config that uses dynamic certificate loading
 // Each call returns a fresh config to avoid race conditions
 func (cm *CertManager) GetTLSConfig() *tls.Config {
-	return &tls.Config{
+	cfg := &tls.Config{
 		GetCertificate: cm.GetCertificate,
 		MinVersion:     tls.VersionTLS12,
 	}
+	if cm.acme != nil {
+		cfg.GetConfigForClient = cm.acme.configForClient
+	}
+	return cfg
 }
 
 // watch monitors for certificate changes
@@ -209,6 +273,10 @@ func (cm *CertManager) checkAndReload() {
 	// Check if certificate file has been modified
 	certInfo, err := os.Stat(cm.certPath)
 	if err != nil {
+		// An ACME manager has no file until its first order completes.
+		if cm.acme != nil && errors.Is(err, fs.ErrNotExist) {
+			return
+		}
 		zlog.Error("Failed to stat certificate file", "path", cm.certPath, "error", err.Error())
 		return
 	}
@@ -259,4 +327,7 @@ func (cm *CertManager) Stop() {
 	close(cm.stopCh)
 	// Wait for the watcher goroutine to finish
 	<-cm.doneCh
+	if cm.acmeDone != nil {
+		<-cm.acmeDone
+	}
 }
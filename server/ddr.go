@@ -0,0 +1,147 @@
+package server
+
+import (
+	"crypto/x509"
+	"net"
+	"strconv"
+
+	"github.com/semihalev/sdns/middleware/ddr"
+	"github.com/semihalev/zlog/v2"
+)
+
+// publishDDR hands the ddr middleware the encrypted listeners that bound,
+// so _dns.resolver.arpa advertises what is actually up. The certificate
+// checks here are for the operator: a configured name the certificate
+// does not cover, or a certificate a client cannot tie to this address,
+// is worth a line in the log at startup; the middleware itself re-checks
+// every name against the live certificate on each answer.
+func (s *Server) publishDDR(active []Listener) {
+	if !s.cfg.DDREnabled() {
+		return
+	}
+	endpoints := ddrEndpoints(active)
+	for _, ep := range endpoints {
+		leaf := ep.Certificate()
+		if leaf == nil {
+			zlog.Warn("DDR endpoint has no certificate yet, advertised once it has one", "alpn", ep.ALPN, "port", ep.Port)
+			continue
+		}
+		for _, n := range s.cfg.DDRNames {
+			if !ddr.Covers(leaf, n) {
+				zlog.Warn("DDR name not covered by the certificate, not advertised", "name", n, "alpn", ep.ALPN, "port", ep.Port)
+			}
+		}
+		// Verified discovery (RFC 9462 §4.2) needs the unencrypted
+		// resolver's address in the certificate; without one, clients
+		// that insist on it stay on plain DNS.
+		if len(leaf.IPAddresses) == 0 {
+			zlog.Info("DDR certificate has no IP address; clients can only upgrade opportunistically", "alpn", ep.ALPN, "port", ep.Port)
+		}
+	}
+	ddr.Publish(endpoints)
+}
+
+// ddrEndpoints returns the designated resolver endpoints of the encrypted
+// listeners in active, in order. A DoH listener and the DoH3 listener on
+// the same address are one endpoint speaking h2 and h3.
+func ddrEndpoints(active []Listener) []ddr.Endpoint {
+	var endpoints []ddr.Endpoint
+	doh := make(map[string]int)
+	for _, l := range active {
+		var (
+			alpn  string
+			certs certProvider
+		)
+		switch l := l.(type) {
+		case *tlsListener:
+			alpn, certs = "dot", l.certs
+		case *dohListener:
+			alpn, certs = "h2", l.certs
+		case *doh3Listener:
+			alpn, certs = "h3", l.certs
+		case *doqListener:
+			alpn, certs = "doq", l.certs
+		default:
+			continue
+		}
+		isDoH := alpn == "h2" || alpn == "h3"
+		if i, ok := doh[l.Addr()]; ok && isDoH {
+			endpoints[i].ALPN = append(endpoints[i].ALPN, alpn)
+			continue
+		}
+
+		host, portStr, err := net.SplitHostPort(l.Addr())
+		port, perr := strconv.ParseUint(portStr, 10, 16)
+		if err != nil || perr != nil || port == 0 {
+			zlog.Warn("DDR endpoint without a fixed port, not advertised", "proto", l.Proto(), "addr", l.Addr())
+			continue
+		}
+		ep := ddr.Endpoint{ALPN: []string{alpn}, Port: uint16(port), Certificate: leafOf(certs)}
+		ep.IPv4, ep.IPv6 = addrHints(host)
+		if isDoH {
+			ep.DoHPath = ddr.DoHPath
+			doh[l.Addr()] = len(endpoints)
+		}
+		endpoints = append(endpoints, ep)
+	}
+	return endpoints
+}
+
+// addrHints returns the address hints of a listener bound to host: the
+// address itself, or for a wildcard bind every global unicast address of
+// the machine in the family it covers. A host name gives no hints; the
+// client resolves the target name instead.
+func addrHints(host string) (v4, v6 []net.IP) {
+	ip := net.ParseIP(host)
+	if host != "" && ip == nil {
+		return nil, nil
+	}
+	if ip != nil && !ip.IsUnspecified() {
+		if ip4 := ip.To4(); ip4 != nil {
+			return []net.IP{ip4}, nil
+		}
+		return nil, []net.IP{ip}
+	}
+
+	onlyV4 := ip != nil && ip.To4() != nil
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, nil
+	}
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok || !n.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ip4 := n.IP.To4(); ip4 != nil {
+			v4 = append(v4, ip4)
+		} else if !onlyV4 {
+			v6 = append(v6, n.IP)
+		}
+	}
+	return v4, v6
+}
+
+// leafOf returns a function reading the leaf certificate certs serves at
+// the time of the call, so a reloaded certificate is seen at once. The
+// leaf is the one parsed when the certificate was loaded: the
+// CertManager keeps it in tls.Certificate.Leaf, and a reload or an ACME
+// renewal replaces it along with the certificate. Nothing is parsed per
+// query.
+func leafOf(certs certProvider) func() *x509.Certificate {
+	if certs == nil {
+		return func() *x509.Certificate { return nil }
+	}
+	tlsConfig := certs.GetTLSConfig()
+	if tlsConfig == nil || tlsConfig.GetCertificate == nil {
+		return func() *x509.Certificate { return nil }
+	}
+	getCertificate := tlsConfig.GetCertificate
+	return func() *x509.Certificate {
+		cert, err := getCertificate(nil)
+		if err != nil || cert == nil {
+			return nil
+		}
+		return cert.Leaf
+	}
+}
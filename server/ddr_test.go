@@ -0,0 +1,111 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/semihalev/sdns/middleware/ddr"
+)
+
+// staticCerts is a certProvider serving one fixed leaf.
+type staticCerts struct{ leaf *x509.Certificate }
+
+func (c staticCerts) GetTLSConfig() *tls.Config {
+	cert := &tls.Certificate{Certificate: [][]byte{{0}}, Leaf: c.leaf}
+	return &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return cert, nil }}
+}
+
+func TestDDREndpoints(t *testing.T) {
+	leaf := &x509.Certificate{DNSNames: []string{"dns.example.com"}}
+	certs := staticCerts{leaf}
+	active := []Listener{
+		newUDPListener("192.0.2.1:53", nil, time.Second, 0, 0, defaultResourcePlan(1)),
+		newTLSListener("192.0.2.1:853", nil, certs, time.Second, 0, defaultResourcePlan(1)),
+		newDOHListener("[2001:db8::1]:443", nil, certs, time.Second),
+		newDOH3Listener("[2001:db8::1]:443", nil, certs),
+		newDOQListener("192.0.2.1:0", nil, certs),
+		newDOQListener("dns.example.com:8853", nil, nil),
+	}
+
+	got := ddrEndpoints(active)
+	if len(got) != 3 {
+		t.Fatalf("%d endpoints, want 3: %+v", len(got), got)
+	}
+
+	want := []ddr.Endpoint{
+		{ALPN: []string{"dot"}, Port: 853, IPv4: []net.IP{net.IPv4(192, 0, 2, 1).To4()}},
+		{ALPN: []string{"h2", "h3"}, Port: 443, DoHPath: ddr.DoHPath, IPv6: []net.IP{net.ParseIP("2001:db8::1")}},
+		// A host name gives no hints, and no certificate no leaf.
+		{ALPN: []string{"doq"}, Port: 8853},
+	}
+	for i, w := range want {
+		g := got[i]
+		if !reflect.DeepEqual(g.ALPN, w.ALPN) || g.Port != w.Port || g.DoHPath != w.DoHPath ||
+			!reflect.DeepEqual(g.IPv4, w.IPv4) || !reflect.DeepEqual(g.IPv6, w.IPv6) {
+			t.Errorf("endpoint %d = %+v, want %+v", i, g, w)
+		}
+	}
+	if got[0].Certificate() != leaf || got[1].Certificate() != leaf {
+		t.Error("endpoint certificate is not the listener's")
+	}
+	if got[2].Certificate() != nil {
+		t.Error("endpoint without certificates has a leaf")
+	}
+}
+
+func TestDDRAddrHints(t *testing.T) {
+	v4, v6 := addrHints("0.0.0.0")
+	if len(v6) != 0 {
+		t.Errorf("0.0.0.0 hints IPv6 addresses %v", v6)
+	}
+	for _, ip := range append(v4, v6...) {
+		if !ip.IsGlobalUnicast() {
+			t.Errorf("wildcard hint %v is not global unicast", ip)
+		}
+	}
+	if v4, v6 := addrHints("resolver.example"); v4 != nil || v6 != nil {
+		t.Errorf("host name hints %v %v", v4, v6)
+	}
+}
+
+// The DDR leaf is the one parsed when the certificate was loaded: the
+// same certificate answers every query, and a reload replaces it.
+func TestDDRLeafFollowsReload(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "test.crt"), filepath.Join(dir, "test.key")
+	cert, key := generateTestCert(t, "leaf1.example.com")
+	writeCertAndKey(t, certPath, keyPath, cert, key)
+
+	cm, err := NewCertManager(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm.Stop()
+
+	leaf := leafOf(cm)
+	first := leaf()
+	if first == nil || first.Subject.CommonName != "leaf1.example.com" {
+		t.Fatalf("leaf = %v, want leaf1.example.com", first)
+	}
+	if leaf() != first {
+		t.Error("the leaf was parsed again for a second query")
+	}
+
+	cert, key = generateTestCert(t, "leaf2.example.com")
+	writeCertAndKey(t, certPath, keyPath, cert, key)
+	if err := cm.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := leaf(); got == nil || got.Subject.CommonName != "leaf2.example.com" {
+		t.Fatalf("leaf after reload = %v, want leaf2.example.com", got)
+	}
+
+	if leafOf(nil)() != nil {
+		t.Error("no certificate provider gave a leaf")
+	}
+}
@@ -0,0 +1,249 @@
+package dnscrypt
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// ProviderPrefix begins every DNSCrypt v2 provider name.
+	ProviderPrefix = "2.dnscrypt-cert."
+
+	// CertLifetime is how long a short-term certificate is valid. A new
+	// one is issued halfway through, so clients that cached the old one
+	// keep working while they pick the new one up.
+	CertLifetime = 24 * time.Hour
+
+	// certBackdate starts a certificate's validity this far in the past,
+	// for clients whose clocks run behind.
+	certBackdate = time.Hour
+
+	// certTTL is the TTL of the certificate TXT records.
+	certTTL = 600
+
+	certSize  = 124
+	magicSize = 8
+)
+
+var certMagic = [4]byte{'D', 'N', 'S', 'C'}
+
+// certificate is one short-term resolver key and the signed record
+// announcing it.
+type certificate struct {
+	es       ESVersion
+	serial   uint32
+	magic    [magicSize]byte
+	secret   *ecdh.PrivateKey
+	issued   time.Time
+	notAfter time.Time
+	raw      []byte
+}
+
+// certSet is the certificates valid at one time, newest first, and the
+// TXT records serving them. It is replaced, never changed.
+type certSet struct {
+	certs   []*certificate
+	records []dns.RR
+	// next is when the set must be rebuilt: the newest certificates are
+	// due to be replaced or an older one expires.
+	next time.Time
+}
+
+// Provider is a DNSCrypt provider: the long-term Ed25519 key clients pin
+// through the stamp, and the short-term certificates it signs. Those are
+// issued on first use and rotated as they age, one per encryption system.
+type Provider struct {
+	name string
+	key  ed25519.PrivateKey
+	now  func() time.Time
+
+	set atomic.Pointer[certSet]
+	mu  sync.Mutex
+}
+
+// NewProvider returns the provider name signing with key. A name not
+// already in the 2.dnscrypt-cert. namespace is put under it.
+func NewProvider(name string, key ed25519.PrivateKey) (*Provider, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("dnscrypt: bad provider key")
+	}
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == "" {
+		return nil, errors.New("dnscrypt: provider name is empty")
+	}
+	if !strings.HasPrefix(name, ProviderPrefix) {
+		name = ProviderPrefix + name
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		return nil, errors.New("dnscrypt: bad provider name " + name)
+	}
+	return &Provider{name: name, key: key, now: time.Now}, nil
+}
+
+// Name returns the provider name, without the trailing dot.
+func (p *Provider) Name() string { return p.name }
+
+// PublicKey returns the provider's long-term key.
+func (p *Provider) PublicKey() ed25519.PublicKey {
+	return p.key.Public().(ed25519.PublicKey)
+}
+
+// current returns the certificates valid now, issuing new ones first
+// when they are due.
+func (p *Provider) current() *certSet {
+	now := p.now()
+	if set := p.set.Load(); set != nil && now.Before(set.next) {
+		return set
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	set := p.set.Load()
+	if set != nil && now.Before(set.next) {
+		return set
+	}
+	next, err := p.rotate(set, now)
+	if err != nil {
+		// Keep what still works; the next query tries again.
+		return set
+	}
+	p.set.Store(next)
+	return next
+}
+
+// rotate builds the set following prev at now: prev's certificates that
+// have not expired, behind fresh ones when the newest are halfway
+// through their lifetime.
+func (p *Provider) rotate(prev *certSet, now time.Time) (*certSet, error) {
+	var certs []*certificate
+	var serial uint32
+	if prev != nil {
+		for _, c := range prev.certs {
+			if now.Before(c.notAfter) {
+				certs = append(certs, c)
+			}
+			serial = max(serial, c.serial)
+		}
+	}
+	if len(certs) == 0 || !now.Before(certs[0].issued.Add(CertLifetime/2)) {
+		// Serials are the issue time, so they keep increasing across
+		// restarts, which is how clients pick the newest.
+		serial = max(serial+1, uint32(now.Unix())) //nolint:gosec // G115 - a serial, wrapping in 2106
+		fresh := make([]*certificate, 0, 2)
+		for _, es := range []ESVersion{XChacha20Poly1305, XSalsa20Poly1305} {
+			c, err := p.issue(es, serial, now)
+			if err != nil {
+				return nil, err
+			}
+			fresh = append(fresh, c)
+		}
+		certs = append(fresh, certs...)
+	}
+
+	set := &certSet{certs: certs, next: certs[0].issued.Add(CertLifetime / 2)}
+	hdr := dns.RR_Header{Name: dns.Fqdn(p.name), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: certTTL}
+	for _, c := range certs {
+		if c.notAfter.Before(set.next) {
+			set.next = c.notAfter
+		}
+		set.records = append(set.records, &dns.TXT{Hdr: hdr, Txt: []string{txtString(c.raw)}})
+	}
+	return set, nil
+}
+
+// issue creates a resolver key for es and signs its certificate.
+func (p *Provider) issue(es ESVersion, serial uint32, now time.Time) (*certificate, error) {
+	secret, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	pk := secret.PublicKey().Bytes()
+	c := &certificate{
+		es:       es,
+		serial:   serial,
+		secret:   secret,
+		issued:   now,
+		notAfter: now.Add(CertLifetime),
+	}
+	// The client magic only has to tell the resolver's keys apart, and
+	// never read as a response.
+	copy(c.magic[:], pk)
+	if c.magic == resolverMagic {
+		c.magic[0] ^= 0xff
+	}
+
+	raw := make([]byte, 0, certSize)
+	raw = append(raw, certMagic[:]...)
+	raw = binary.BigEndian.AppendUint16(raw, uint16(es))
+	raw = binary.BigEndian.AppendUint16(raw, 0) // protocol minor version
+	signed := make([]byte, 0, keySize+magicSize+12)
+	signed = append(signed, pk...)
+	signed = append(signed, c.magic[:]...)
+	signed = binary.BigEndian.AppendUint32(signed, serial)
+	signed = binary.BigEndian.AppendUint32(signed, uint32(now.Add(-certBackdate).Unix())) //nolint:gosec // G115 - protocol field
+	signed = binary.BigEndian.AppendUint32(signed, uint32(c.notAfter.Unix()))             //nolint:gosec // G115 - protocol field
+	raw = append(raw, ed25519.Sign(p.key, signed)...)
+	c.raw = append(raw, signed...)
+	return c, nil
+}
+
+// lookup returns the valid certificate whose client magic opens query.
+func (p *Provider) lookup(query []byte) *certificate {
+	if len(query) < magicSize {
+		return nil
+	}
+	set := p.current()
+	if set == nil {
+		return nil
+	}
+	for _, c := range set.certs {
+		if bytes.Equal(c.magic[:], query[:magicSize]) {
+			return c
+		}
+	}
+	return nil
+}
+
+// certRecords returns the TXT records a client fetches the certificates
+// by, nil when q asks for something else.
+func (p *Provider) certRecords(q dns.Question) []dns.RR {
+	if q.Qtype != dns.TypeTXT || q.Qclass != dns.ClassINET ||
+		!strings.EqualFold(strings.TrimSuffix(q.Name, "."), p.name) {
+		return nil
+	}
+	set := p.current()
+	if set == nil {
+		return nil
+	}
+	return set.records
+}
+
+// txtString writes binary data as a TXT character-string in presentation
+// format, which is what the dns package packs from.
+func txtString(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < ' ' || c > '~':
+			sb.WriteByte('\\')
+			sb.WriteByte('0' + c/100)
+			sb.WriteByte('0' + c/10%10)
+			sb.WriteByte('0' + c%10)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
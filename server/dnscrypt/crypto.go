@@ -0,0 +1,159 @@
+package dnscrypt
+
+import (
+	"crypto/ecdh"
+	"errors"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/poly1305" //nolint:staticcheck // SA1019 - the one-time authenticator of secretbox_xchacha20poly1305, not the AEAD
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+// ESVersion is a certificate's encryption system: the construction its
+// queries and responses are boxed with.
+type ESVersion uint16
+
+const (
+	// XSalsa20Poly1305 is crypto_box_curve25519xsalsa20poly1305.
+	XSalsa20Poly1305 ESVersion = 1
+	// XChacha20Poly1305 is crypto_box_curve25519xchacha20poly1305.
+	XChacha20Poly1305 ESVersion = 2
+)
+
+func (es ESVersion) String() string {
+	switch es {
+	case XSalsa20Poly1305:
+		return "xsalsa20poly1305"
+	case XChacha20Poly1305:
+		return "xchacha20poly1305"
+	}
+	return "unknown"
+}
+
+const (
+	keySize       = 32
+	nonceSize     = 24
+	halfNonceSize = nonceSize / 2
+	tagSize       = poly1305.TagSize
+	// padBlock is the multiple a padded message's length is rounded to.
+	padBlock = 64
+)
+
+// resolverMagic opens every encrypted response.
+var resolverMagic = [8]byte{0x72, 0x36, 0x66, 0x6e, 0x76, 0x57, 0x6a, 0x38}
+
+var (
+	errOpen    = errors.New("dnscrypt: message authentication failed")
+	errPadding = errors.New("dnscrypt: bad padding")
+)
+
+// sharedKey derives the key a client and the resolver box with: X25519
+// of the two keys, hashed into a key by the construction's core —
+// HSalsa20 or HChaCha20 under a zero nonce, as crypto_box_beforenm does.
+// A client key of low order, which would fix the secret, is an error.
+func sharedKey(es ESVersion, secret *ecdh.PrivateKey, peer []byte) ([keySize]byte, error) {
+	var key [keySize]byte
+	pk, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return key, err
+	}
+	dh, err := secret.ECDH(pk)
+	if err != nil {
+		return key, err
+	}
+	switch es {
+	case XSalsa20Poly1305:
+		var in [16]byte
+		var k [keySize]byte
+		copy(k[:], dh)
+		salsa.HSalsa20(&key, &in, &k, &salsa.Sigma)
+	case XChacha20Poly1305:
+		out, err := chacha20.HChaCha20(dh, make([]byte, 16))
+		if err != nil {
+			return key, err
+		}
+		copy(key[:], out)
+	default:
+		return key, errors.New("dnscrypt: unknown encryption system")
+	}
+	return key, nil
+}
+
+// seal appends the box of msg to out: the Poly1305 tag, then the
+// ciphertext, as crypto_box_easy_afternm lays it out.
+func seal(es ESVersion, out, msg []byte, nonce *[nonceSize]byte, key *[keySize]byte) []byte {
+	if es == XSalsa20Poly1305 {
+		return secretbox.Seal(out, msg, nonce, key)
+	}
+	c, _ := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	// The first 32 bytes of keystream key the authenticator; the message
+	// is enciphered with the rest, from where they stop.
+	var polyKey [keySize]byte
+	c.XORKeyStream(polyKey[:], polyKey[:])
+
+	n := len(out)
+	out = append(out, make([]byte, tagSize+len(msg))...)
+	ct := out[n+tagSize:]
+	c.XORKeyStream(ct, msg)
+	var tag [tagSize]byte
+	poly1305.Sum(&tag, ct, &polyKey)
+	copy(out[n:], tag[:])
+	return out
+}
+
+// open appends the message boxed in box to out.
+func open(es ESVersion, out, box []byte, nonce *[nonceSize]byte, key *[keySize]byte) ([]byte, error) {
+	if len(box) < tagSize {
+		return nil, errOpen
+	}
+	if es == XSalsa20Poly1305 {
+		msg, ok := secretbox.Open(out, box, nonce, key)
+		if !ok {
+			return nil, errOpen
+		}
+		return msg, nil
+	}
+	c, _ := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	var polyKey [keySize]byte
+	c.XORKeyStream(polyKey[:], polyKey[:])
+
+	var tag [tagSize]byte
+	copy(tag[:], box[:tagSize])
+	ct := box[tagSize:]
+	if !poly1305.Verify(&tag, ct, &polyKey) {
+		return nil, errOpen
+	}
+	n := len(out)
+	out = append(out, make([]byte, len(ct))...)
+	c.XORKeyStream(out[n:], ct)
+	return out, nil
+}
+
+// pad appends ISO/IEC 7816-4 padding to msg: 0x80, then zeros up to a
+// multiple of padBlock, or to limit bytes in all when that is less. It
+// reports false when not even the 0x80 fits under limit.
+func pad(msg []byte, limit int) ([]byte, bool) {
+	n := len(msg)
+	size := (n + padBlock) / padBlock * padBlock
+	if limit > 0 && size > limit {
+		if n+1 > limit {
+			return msg, false
+		}
+		size = limit
+	}
+	msg = append(msg, 0x80)
+	return append(msg, make([]byte, size-n-1)...), true
+}
+
+// unpad strips the padding pad added.
+func unpad(msg []byte) ([]byte, error) {
+	i := len(msg) - 1
+	for i >= 0 && msg[i] == 0 {
+		i--
+	}
+	if i < 0 || msg[i] != 0x80 {
+		return nil, errPadding
+	}
+	return msg[:i], nil
+}
@@ -0,0 +1,300 @@
+// Package dnscrypt serves DNSCrypt v2 over UDP and TCP: queries boxed
+// with X25519-XSalsa20Poly1305 or X25519-XChacha20Poly1305 under
+// short-term resolver keys, which a Provider certifies and rotates.
+package dnscrypt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/middleware"
+	"github.com/semihalev/zlog/v2"
+)
+
+const (
+	// queryHeaderSize is a query's client magic, client public key and
+	// half nonce, ahead of its box.
+	queryHeaderSize = magicSize + keySize + halfNonceSize
+	// responseHeaderSize is a response's resolver magic and nonce.
+	responseHeaderSize = len(resolverMagic) + nonceSize
+	// minQuerySize is the smallest box a DNS header fits in, padded.
+	minQuerySize = queryHeaderSize + tagSize + 12 + 1
+
+	maxMsgSize = dns.MaxMsgSize
+	// maxInflight bounds the queries served at once on each socket;
+	// UDP queries over it are dropped and TCP connections closed.
+	maxInflight = 1024
+	// idleTimeout closes a TCP connection that sends no query for it.
+	idleTimeout = 10 * time.Second
+)
+
+// Handler serves one decrypted DNSCrypt query through the server's
+// message entry.
+type Handler interface {
+	ServeMsg(ctx context.Context, w middleware.Transport, m *dns.Msg)
+}
+
+// Server serves DNSCrypt on the sockets it is given. A plain TXT query
+// for the provider name is answered with the certificates; any other
+// plain query is dropped, as the protocol has no way to refuse it.
+type Server struct {
+	Provider *Provider
+	Handler  Handler
+
+	mu       sync.Mutex
+	pcs      []net.PacketConn
+	lns      []net.Listener
+	conns    map[net.Conn]struct{}
+	closing  bool
+	inflight sync.WaitGroup
+}
+
+// ServeUDP reads queries from pc until Shutdown.
+func (s *Server) ServeUDP(pc net.PacketConn) error {
+	if !s.track(func() { s.pcs = append(s.pcs, pc) }) {
+		return net.ErrClosed
+	}
+	tokens := make(chan struct{}, maxInflight)
+	buf := make([]byte, maxMsgSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if s.isClosing() {
+				return nil
+			}
+			return err
+		}
+		if n < 12 {
+			continue
+		}
+		select {
+		case tokens <- struct{}{}:
+		default:
+			continue
+		}
+		if !s.track(func() { s.inflight.Add(1) }) {
+			return nil
+		}
+		pkt := append([]byte(nil), buf[:n]...)
+		go func() {
+			defer func() { <-tokens; s.inflight.Done() }()
+			s.handle(pkt, &ResponseWriter{
+				local:  pc.LocalAddr(),
+				remote: addr,
+				limit:  len(pkt),
+				send: func(b []byte) error {
+					_, err := pc.WriteTo(b, addr)
+					return err
+				},
+			})
+		}()
+	}
+}
+
+// ServeTCP accepts connections from ln until Shutdown. Each carries
+// queries framed with a two-byte length, as DNS over TCP does.
+func (s *Server) ServeTCP(ln net.Listener) error {
+	if !s.track(func() { s.lns = append(s.lns, ln) }) {
+		return net.ErrClosed
+	}
+	tokens := make(chan struct{}, maxInflight)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.isClosing() {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		select {
+		case tokens <- struct{}{}:
+		default:
+			_ = conn.Close()
+			continue
+		}
+		if !s.track(func() { s.conns[conn] = struct{}{}; s.inflight.Add(1) }) {
+			_ = conn.Close()
+			return nil
+		}
+		go func() {
+			defer func() { <-tokens; s.inflight.Done() }()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+	var mu sync.Mutex
+	send := func(b []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		frame := make([]byte, 2, 2+len(b))
+		binary.BigEndian.PutUint16(frame, uint16(len(b))) //nolint:gosec // G115 - bounded by maxMsgSize
+		_, err := conn.Write(append(frame, b...))
+		return err
+	}
+	var size [2]byte
+	for {
+		if s.isClosing() {
+			return
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		pkt := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, pkt); err != nil || len(pkt) < 12 {
+			return
+		}
+		s.handle(pkt, &ResponseWriter{local: conn.LocalAddr(), remote: conn.RemoteAddr(), send: send})
+	}
+}
+
+// handle serves one query, encrypted or a plain certificate request.
+func (s *Server) handle(pkt []byte, w *ResponseWriter) {
+	if len(pkt) >= minQuerySize {
+		if c := s.Provider.lookup(pkt); c != nil {
+			s.serveEncrypted(c, pkt, w)
+			return
+		}
+	}
+	s.serveCerts(pkt, w)
+}
+
+func (s *Server) serveEncrypted(c *certificate, pkt []byte, w *ResponseWriter) {
+	key, err := sharedKey(c.es, c.secret, pkt[magicSize:magicSize+keySize])
+	if err != nil {
+		return
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], pkt[magicSize+keySize:queryHeaderSize])
+	msg, err := open(c.es, nil, pkt[queryHeaderSize:], &nonce, &key)
+	if err == nil {
+		msg, err = unpad(msg)
+	}
+	if err != nil {
+		zlog.Debug("DNSCrypt query rejected", "client", w.remote.String(), "error", err.Error())
+		return
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(msg); err != nil {
+		zlog.Debug("DNSCrypt query rejected", "client", w.remote.String(), "error", err.Error())
+		return
+	}
+	w.es, w.key, w.nonce = c.es, key, nonce
+	s.Handler.ServeMsg(context.Background(), w, req)
+}
+
+// serveCerts answers a plain query for the provider's certificates.
+func (s *Server) serveCerts(pkt []byte, w *ResponseWriter) {
+	req := new(dns.Msg)
+	if err := req.Unpack(pkt); err != nil || req.Response || req.Opcode != dns.OpcodeQuery || len(req.Question) != 1 {
+		return
+	}
+	records := s.Provider.certRecords(req.Question[0])
+	if records == nil {
+		return
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+	resp.Answer = records
+	if w.limit > 0 {
+		size := dns.MinMsgSize
+		if opt := req.IsEdns0(); opt != nil {
+			size = max(size, int(opt.UDPSize()))
+			resp.SetEdns0(opt.UDPSize(), false)
+		}
+		resp.Truncate(size)
+	}
+	b, err := resp.Pack()
+	if err != nil {
+		return
+	}
+	_ = w.send(b)
+}
+
+// track registers a socket, a connection or a query in flight under the
+// lock, unless the server is shutting down: Shutdown waits for all of
+// them, and nothing may join once it has begun.
+func (s *Server) track(add func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	add()
+	return true
+}
+
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// Shutdown stops reading and accepting, lets the queries in flight
+// answer until ctx is done, then closes the sockets.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	pcs, lns := s.pcs, s.lns
+	now := time.Now()
+	for _, pc := range pcs {
+		_ = pc.SetReadDeadline(now)
+	}
+	var err error
+	for _, ln := range lns {
+		if cerr := ln.Close(); cerr != nil && !errors.Is(cerr, net.ErrClosed) {
+			err = errors.Join(err, cerr)
+		}
+	}
+	// An idle connection stops waiting for its next query; one being
+	// answered writes its answer first.
+	for conn := range s.conns {
+		_ = conn.SetReadDeadline(now)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = errors.Join(err, ctx.Err())
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	for _, pc := range pcs {
+		if cerr := pc.Close(); cerr != nil && !errors.Is(cerr, net.ErrClosed) {
+			err = errors.Join(err, cerr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,429 @@
+package dnscrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/middleware"
+)
+
+// Boxes made by libsodium's crypto_secretbox_easy and
+// crypto_secretbox_xchacha20poly1305_easy.
+func TestSealKnownAnswer(t *testing.T) {
+	var key [keySize]byte
+	var nonce [nonceSize]byte
+	for i := range key {
+		key[i] = byte(i + 1)
+	}
+	for i := range nonce {
+		nonce[i] = byte(100 + i)
+	}
+	msg := []byte("the quick brown fox jumps over the lazy dog, then again and again and again!!")
+	want := map[ESVersion]string{
+		XSalsa20Poly1305:  "c6187f60f4f1f362b325ec4931f28bf1203e50a4a2b6356955aa403917534f8946ae86e802ab25c154dfb39eb351663e0e3d8eaf3799827b97cff577ad45e9a36c3888eb660590e9db30f69ae27ebb63f9033435c14aed66201ebf31fa",
+		XChacha20Poly1305: "14ae250bc46c7c1bd28e3390d9975137064987479910c5d110c17fa5761b506a8b4fd7ebc1441606b9fbf0119e8a33c9d5a1997eef21fbe8a069ce993e432f331a1947b53ebff733dba2fb9e122a1546058c84831c89c6235b7570b014",
+	}
+	for es, w := range want {
+		box := seal(es, nil, msg, &nonce, &key)
+		if got := hex.EncodeToString(box); got != w {
+			t.Errorf("%s seal = %s, want %s", es, got, w)
+		}
+		got, err := open(es, nil, box, &nonce, &key)
+		if err != nil || !bytes.Equal(got, msg) {
+			t.Errorf("%s open = %q, %v", es, got, err)
+		}
+		box[len(box)-1] ^= 1
+		if _, err := open(es, nil, box, &nonce, &key); err == nil {
+			t.Errorf("%s open of a tampered box succeeded", es)
+		}
+	}
+}
+
+// Shared keys made by libsodium's crypto_box_beforenm and
+// crypto_box_curve25519xchacha20poly1305_beforenm.
+func TestSharedKeyKnownAnswer(t *testing.T) {
+	skb := make([]byte, 32)
+	peerb := make([]byte, 32)
+	for i := range skb {
+		skb[i] = byte(200 - i)
+		peerb[i] = byte(7 * i)
+	}
+	sk, _ := ecdh.X25519().NewPrivateKey(skb)
+	peer, _ := ecdh.X25519().NewPrivateKey(peerb)
+	want := map[ESVersion]string{
+		XSalsa20Poly1305:  "c47e2250e1fa3d0b4bed96ebf784c0334b22d412d7e9230aa5d7e4518cd032ce",
+		XChacha20Poly1305: "1f2f10f1dc85723b7e10ae5572bf00c8d114d7c9e364a5fb5b4249682e9e0904",
+	}
+	for es, w := range want {
+		key, err := sharedKey(es, sk, peer.PublicKey().Bytes())
+		if err != nil || hex.EncodeToString(key[:]) != w {
+			t.Errorf("%s shared key = %x, %v; want %s", es, key, err, w)
+		}
+	}
+	if _, err := sharedKey(XSalsa20Poly1305, sk, make([]byte, 32)); err == nil {
+		t.Error("shared key with a low-order point succeeded")
+	}
+}
+
+func TestPad(t *testing.T) {
+	for _, n := range []int{0, 1, 63, 64, 100} {
+		msg, ok := pad(bytes.Repeat([]byte{1}, n), 0)
+		if !ok || len(msg)%padBlock != 0 || len(msg) <= n {
+			t.Fatalf("pad(%d) = %d bytes, %v", n, len(msg), ok)
+		}
+		got, err := unpad(msg)
+		if err != nil || len(got) != n {
+			t.Fatalf("unpad(pad(%d)) = %d bytes, %v", n, len(got), err)
+		}
+	}
+	if msg, ok := pad(make([]byte, 100), 110); !ok || len(msg) != 110 {
+		t.Fatalf("pad under a limit = %d bytes, %v; want 110", len(msg), ok)
+	}
+	if _, ok := pad(make([]byte, 100), 100); ok {
+		t.Fatal("pad past the limit succeeded")
+	}
+	if _, err := unpad([]byte{1, 2, 0}); err == nil {
+		t.Fatal("unpad without 0x80 succeeded")
+	}
+}
+
+func newTestProvider(t *testing.T) *Provider {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProvider("example.com", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// parsedCert is a certificate as a client reads it.
+type parsedCert struct {
+	es         ESVersion
+	pk         []byte
+	magic      []byte
+	serial     uint32
+	start, end uint32
+}
+
+func parseCert(t *testing.T, pub ed25519.PublicKey, raw []byte) parsedCert {
+	t.Helper()
+	if len(raw) != certSize || !bytes.Equal(raw[:4], certMagic[:]) {
+		t.Fatalf("certificate %x is malformed", raw)
+	}
+	if !ed25519.Verify(pub, raw[72:], raw[8:72]) {
+		t.Fatal("certificate signature does not verify")
+	}
+	return parsedCert{
+		es:     ESVersion(binary.BigEndian.Uint16(raw[4:6])),
+		pk:     raw[72:104],
+		magic:  raw[104:112],
+		serial: binary.BigEndian.Uint32(raw[112:116]),
+		start:  binary.BigEndian.Uint32(raw[116:120]),
+		end:    binary.BigEndian.Uint32(raw[120:124]),
+	}
+}
+
+// txtBytes packs and unpacks a TXT record, as a client receives it.
+func txtBytes(t *testing.T, rr dns.RR) []byte {
+	t.Helper()
+	buf := make([]byte, 512)
+	n, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, _, err = dns.UnpackRR(buf[:n], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw []byte
+	for _, s := range rr.(*dns.TXT).Txt {
+		for i := 0; i < len(s); i++ {
+			if s[i] == '\\' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9' {
+				raw = append(raw, (s[i+1]-'0')*100+(s[i+2]-'0')*10+(s[i+3]-'0'))
+				i += 3
+				continue
+			}
+			if s[i] == '\\' {
+				i++
+			}
+			raw = append(raw, s[i])
+		}
+	}
+	return raw
+}
+
+func TestProviderRotation(t *testing.T) {
+	p := newTestProvider(t)
+	if p.Name() != "2.dnscrypt-cert.example.com" {
+		t.Fatalf("Name() = %q", p.Name())
+	}
+	now := time.Unix(1_800_000_000, 0)
+	p.now = func() time.Time { return now }
+	q := dns.Question{Name: "2.DNSCrypt-Cert.example.com.", Qtype: dns.TypeTXT, Qclass: dns.ClassINET}
+
+	certs := func() []parsedCert {
+		var out []parsedCert
+		for _, rr := range p.certRecords(q) {
+			out = append(out, parseCert(t, p.PublicKey(), txtBytes(t, rr)))
+		}
+		return out
+	}
+
+	first := certs()
+	if len(first) != 2 || first[0].es == first[1].es {
+		t.Fatalf("issued %d certificates, want one per encryption system", len(first))
+	}
+	if first[0].start >= uint32(now.Unix()) || first[0].end != uint32(now.Add(CertLifetime).Unix()) {
+		t.Fatalf("validity %d-%d, issued at %d", first[0].start, first[0].end, now.Unix())
+	}
+
+	now = now.Add(CertLifetime/2 - time.Minute)
+	if got := certs(); len(got) != 2 || got[0].serial != first[0].serial {
+		t.Fatalf("rotated before half the lifetime: %d certificates", len(got))
+	}
+
+	// Halfway, new certificates lead and the old ones stay for the
+	// clients holding them.
+	now = now.Add(2 * time.Minute)
+	second := certs()
+	if len(second) != 4 || second[0].serial <= first[0].serial || second[2].serial != first[0].serial {
+		t.Fatalf("after half the lifetime: %+v", second)
+	}
+	if p.lookup(first[0].magic) == nil || p.lookup(second[0].magic) == nil {
+		t.Fatal("a valid certificate's client magic is not recognised")
+	}
+
+	// Once expired, the old ones are gone.
+	now = time.Unix(int64(first[0].end), 0).Add(time.Second)
+	if got := certs(); len(got) != 2 || got[0].serial != second[0].serial {
+		t.Fatalf("after expiry: %+v", got)
+	}
+	if p.lookup(first[0].magic) != nil {
+		t.Fatal("an expired certificate's client magic is still recognised")
+	}
+
+	if p.certRecords(dns.Question{Name: "example.com.", Qtype: dns.TypeTXT, Qclass: dns.ClassINET}) != nil {
+		t.Fatal("certificates served for another name")
+	}
+}
+
+func TestStamp(t *testing.T) {
+	p := newTestProvider(t)
+	for addr, host := range map[string]string{
+		"192.0.2.1:5443":    "192.0.2.1:5443",
+		"192.0.2.1:443":     "192.0.2.1",
+		"[2001:db8::1]:443": "[2001:db8::1]",
+	} {
+		stamp := p.Stamp(addr, StampDNSSEC|StampNoLog)
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(stamp, "sdns://"))
+		if err != nil || b[0] != stampDNSCrypt {
+			t.Fatalf("Stamp(%s) = %s, %v", addr, stamp, err)
+		}
+		if props := binary.LittleEndian.Uint64(b[1:9]); props != 3 {
+			t.Fatalf("props = %d, want 3", props)
+		}
+		rest := b[9:]
+		var fields [][]byte
+		for len(rest) > 0 {
+			n := int(rest[0])
+			fields = append(fields, rest[1:1+n])
+			rest = rest[1+n:]
+		}
+		if len(fields) != 3 || string(fields[0]) != host ||
+			!bytes.Equal(fields[1], p.PublicKey()) || string(fields[2]) != p.Name() {
+			t.Fatalf("Stamp(%s) fields = %q", addr, fields)
+		}
+	}
+}
+
+// answerHandler answers every query with an A record, or with n of them
+// when the query asks for "big.".
+type answerHandler struct{}
+
+func (answerHandler) ServeMsg(_ context.Context, w middleware.Transport, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	n := 1
+	if r.Question[0].Name == "big." {
+		n = 100
+	}
+	for i := range n {
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, byte(i)),
+		})
+	}
+	_ = w.WriteMsg(m)
+}
+
+// client is a DNSCrypt client using one certificate.
+type client struct {
+	t     *testing.T
+	cert  parsedCert
+	sk    *ecdh.PrivateKey
+	key   [keySize]byte
+	nonce [nonceSize]byte
+}
+
+func newClient(t *testing.T, cert parsedCert) *client {
+	sk, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &client{t: t, cert: cert, sk: sk}
+	if c.key, err = sharedKey(cert.es, sk, cert.pk); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func (c *client) query(name string, size int) []byte {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	packed, err := m.Pack()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	_, _ = rand.Read(c.nonce[:halfNonceSize])
+	msg, _ := pad(packed, size-queryHeaderSize-tagSize)
+	out := append([]byte(nil), c.cert.magic...)
+	out = append(out, c.sk.PublicKey().Bytes()...)
+	out = append(out, c.nonce[:halfNonceSize]...)
+	return seal(c.cert.es, out, msg, &c.nonce, &c.key)
+}
+
+func (c *client) answer(b []byte) *dns.Msg {
+	if len(b) < responseHeaderSize || !bytes.Equal(b[:8], resolverMagic[:]) ||
+		!bytes.Equal(b[8:8+halfNonceSize], c.nonce[:halfNonceSize]) {
+		c.t.Fatalf("response %x is malformed", b)
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], b[8:responseHeaderSize])
+	msg, err := open(c.cert.es, nil, b[responseHeaderSize:], &nonce, &c.key)
+	if err == nil {
+		msg, err = unpad(msg)
+	}
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return m
+}
+
+func TestServer(t *testing.T) {
+	p := newTestProvider(t)
+	s := &Server{Provider: p, Handler: answerHandler{}}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpDone := make(chan error, 1)
+	tcpDone := make(chan error, 1)
+	go func() { udpDone <- s.ServeUDP(pc) }()
+	go func() { tcpDone <- s.ServeTCP(ln) }()
+
+	// The certificates come in the clear.
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(p.Name()), dns.TypeTXT)
+	q.SetEdns0(4096, false)
+	resp, err := dns.Exchange(q, pc.LocalAddr().String())
+	if err != nil || len(resp.Answer) != 2 {
+		t.Fatalf("certificate query = %v, %v", resp, err)
+	}
+
+	udp, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	for _, rr := range resp.Answer {
+		c := newClient(t, parseCert(t, p.PublicKey(), txtBytes(t, rr)))
+		query := c.query("example.com.", 256)
+		_ = udp.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := udp.Write(query); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4096)
+		n, err := udp.Read(buf)
+		if err != nil {
+			t.Fatalf("%s: %v", c.cert.es, err)
+		}
+		if n > len(query) {
+			t.Fatalf("%s: %d byte response to a %d byte query", c.cert.es, n, len(query))
+		}
+		if m := c.answer(buf[:n]); len(m.Answer) != 1 || m.Truncated {
+			t.Fatalf("%s: answer %v", c.cert.es, m)
+		}
+
+		// An answer the query does not cover is truncated over UDP…
+		query = c.query("big.", 256)
+		if _, err := udp.Write(query); err != nil {
+			t.Fatal(err)
+		}
+		if n, err = udp.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+		if m := c.answer(buf[:n]); !m.Truncated || len(m.Answer) != 0 || n > len(query) {
+			t.Fatalf("%s: oversized UDP answer %v", c.cert.es, m)
+		}
+
+		// …and whole over TCP.
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		query = c.query("big.", 256)
+		frame := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+		if _, err := conn.Write(append(frame, query...)); err != nil {
+			t.Fatal(err)
+		}
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			t.Fatal(err)
+		}
+		if m := c.answer(body); m.Truncated || len(m.Answer) != 100 {
+			t.Fatalf("%s: TCP answer has %d records, truncated %v", c.cert.es, len(m.Answer), m.Truncated)
+		}
+		_ = conn.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if err := <-udpDone; err != nil {
+		t.Fatalf("ServeUDP() = %v", err)
+	}
+	if err := <-tcpDone; err != nil {
+		t.Fatalf("ServeTCP() = %v", err)
+	}
+}
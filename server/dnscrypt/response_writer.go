@@ -0,0 +1,102 @@
+package dnscrypt
+
+import (
+	"crypto/rand"
+	"errors"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// ResponseWriter is the middleware.Transport of a DNSCrypt query: it
+// boxes the answer for the client that asked. Its protocol is the one
+// its addresses name, so the chain sizes UDP answers as UDP ones.
+type ResponseWriter struct {
+	local, remote net.Addr
+	// limit bounds a UDP response packet by the query packet, which the
+	// protocol requires so it never amplifies; zero over TCP.
+	limit int
+	send  func([]byte) error
+
+	es    ESVersion
+	key   [keySize]byte
+	nonce [nonceSize]byte
+}
+
+func (w *ResponseWriter) LocalAddr() net.Addr  { return w.local }
+func (w *ResponseWriter) RemoteAddr() net.Addr { return w.remote }
+func (w *ResponseWriter) Close() error         { return nil }
+
+// Encrypted reports that the query came in encrypted.
+func (w *ResponseWriter) Encrypted() bool { return true }
+
+// Write boxes the packed message m and sends it.
+func (w *ResponseWriter) Write(m []byte) (int, error) {
+	if err := w.write(m, nil); err != nil {
+		return 0, err
+	}
+	return len(m), nil
+}
+
+func (w *ResponseWriter) WriteMsg(m *dns.Msg) error {
+	packed, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	return w.write(packed, m)
+}
+
+// write sends packed, the packing of m when m is not nil. An answer the
+// UDP limit does not fit goes out truncated, for the client to retry
+// over TCP.
+func (w *ResponseWriter) write(packed []byte, m *dns.Msg) error {
+	if b, ok := w.box(packed); ok {
+		return w.send(b)
+	}
+	if m == nil {
+		m = new(dns.Msg)
+		if err := m.Unpack(packed); err != nil {
+			return err
+		}
+	}
+	tc := new(dns.Msg)
+	tc.MsgHdr = m.MsgHdr
+	tc.Truncated = true
+	tc.Question = m.Question
+	if opt := m.IsEdns0(); opt != nil {
+		tc.Extra = []dns.RR{opt}
+	}
+	packed, err := tc.Pack()
+	if err != nil {
+		return err
+	}
+	b, ok := w.box(packed)
+	if !ok {
+		return errors.New("dnscrypt: query too short to answer")
+	}
+	return w.send(b)
+}
+
+// box encrypts and pads packed into a response packet, reporting false
+// when it would not fit the limit.
+func (w *ResponseWriter) box(packed []byte) ([]byte, bool) {
+	limit := 0
+	if w.limit > 0 {
+		limit = w.limit - responseHeaderSize - tagSize
+	}
+	msg := make([]byte, len(packed), len(packed)+padBlock)
+	copy(msg, packed)
+	msg, ok := pad(msg, limit)
+	if !ok {
+		return nil, false
+	}
+
+	nonce := w.nonce
+	if _, err := rand.Read(nonce[halfNonceSize:]); err != nil {
+		return nil, false
+	}
+	out := make([]byte, 0, responseHeaderSize+tagSize+len(msg))
+	out = append(out, resolverMagic[:]...)
+	out = append(out, nonce[:]...)
+	return seal(w.es, out, msg, &nonce, &w.key), true
+}
@@ -0,0 +1,47 @@
+package dnscrypt
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"strings"
+)
+
+// StampProps are the informal properties a stamp announces about the
+// resolver.
+type StampProps uint64
+
+const (
+	// StampDNSSEC says the resolver validates DNSSEC.
+	StampDNSSEC StampProps = 1 << 0
+	// StampNoLog says the resolver keeps no query logs.
+	StampNoLog StampProps = 1 << 1
+	// StampNoFilter says the resolver does not block names.
+	StampNoFilter StampProps = 1 << 2
+)
+
+// stampDNSCrypt is the stamp protocol identifier of DNSCrypt.
+const stampDNSCrypt = 0x01
+
+// Stamp returns the sdns:// stamp clients configure the provider with,
+// served at addr (host:port; a port of 443 is left out, as stamps do).
+func (p *Provider) Stamp(addr string, props StampProps) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil && port == "443" {
+		addr = host
+		if strings.Contains(host, ":") {
+			addr = "[" + host + "]"
+		}
+	}
+	b := []byte{stampDNSCrypt}
+	b = binary.LittleEndian.AppendUint64(b, uint64(props))
+	b = appendLP(b, []byte(addr))
+	b = appendLP(b, p.PublicKey())
+	b = appendLP(b, []byte(p.name))
+	return "sdns://" + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// appendLP appends v behind its one-byte length.
+func appendLP(b, v []byte) []byte {
+	b = append(b, byte(len(v))) //nolint:gosec // G115 - addresses, keys and names are under 256 bytes
+	return append(b, v...)
+}
@@ -14,9 +14,9 @@ import (
 )
 
 // httpErrors counts DoH responses sent with a 4xx or 5xx status.
-// Labelled by status code (closed set: 400/405/415/500) so operators
-// can distinguish bad-request floods from server-side decode
-// failures. The 200-path is not counted here — that's what
+// Labelled by status code (closed set: 400/401/403/405/415/500, and
+// 502/504 from an ODoH relay) so operators can distinguish bad-request
+// floods from server-side decode failures. The 200-path is not counted here — that's what
 // dns_queries_total already measures.
 var (
 	httpErrors = metric.NewCounterVec(nil, prometheus.CounterOpts{
@@ -25,9 +25,14 @@ var (
 	}, []string{"code"})
 
 	httpErr400 = httpErrors.Register("400")
+	httpErr401 = httpErrors.Register("401")
+	httpErr403 = httpErrors.Register("403")
 	httpErr405 = httpErrors.Register("405")
+	httpErr413 = httpErrors.Register("413")
 	httpErr415 = httpErrors.Register("415")
 	httpErr500 = httpErrors.Register("500")
+	httpErr502 = httpErrors.Register("502")
+	httpErr504 = httpErrors.Register("504")
 )
 
 // writeHTTPError replaces http.Error + manual metric increments so
@@ -39,18 +44,34 @@ func writeHTTPError(w http.ResponseWriter, code int) {
 	switch code {
 	case http.StatusBadRequest:
 		httpErr400.Inc()
+	case http.StatusUnauthorized:
+		httpErr401.Inc()
+	case http.StatusForbidden:
+		httpErr403.Inc()
 	case http.StatusMethodNotAllowed:
 		httpErr405.Inc()
+	case http.StatusRequestEntityTooLarge:
+		httpErr413.Inc()
 	case http.StatusUnsupportedMediaType:
 		httpErr415.Inc()
 	case http.StatusInternalServerError:
 		httpErr500.Inc()
+	case http.StatusBadGateway:
+		httpErr502.Inc()
+	case http.StatusGatewayTimeout:
+		httpErr504.Inc()
 	default:
 		httpErrors.WithLabelValues(strconv.Itoa(code)).Inc()
 	}
 	http.Error(w, http.StatusText(code), code)
 }
 
+// WriteError answers with code and counts it like the handlers' own
+// errors, for a caller that turns a request away before any handler.
+func WriteError(w http.ResponseWriter, code int) {
+	writeHTTPError(w, code)
+}
+
 const (
 	minMsgHeaderSize = 12
 	maxMsgSize       = 65535 // Maximum DNS message size
@@ -0,0 +1,232 @@
+package doh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/odoh"
+)
+
+// IsOblivious reports whether r carries an Oblivious DoH message (RFC
+// 9230), for a target or a relay to handle rather than HandleWireFormat.
+func IsOblivious(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && strings.EqualFold(mediaType, odoh.ContentType)
+}
+
+// IsRelayed reports whether r asks to be relayed to a target rather
+// than answered: a relay request names it in the targethost and
+// targetpath parameters.
+func IsRelayed(r *http.Request) bool {
+	return r.URL.Query().Has("targethost")
+}
+
+// Target serves as an Oblivious DoH target (RFC 9230) for one key: the
+// configs clients seal their queries with, and the queries sealed to
+// them. It is built once, with the configs serialized up front.
+type Target struct {
+	key     *odoh.KeyPair
+	configs []byte
+}
+
+// NewTarget returns the target serving key.
+func NewTarget(key *odoh.KeyPair) *Target {
+	return &Target{key: key, configs: odoh.MarshalConfigs(key.Config())}
+}
+
+// ServeConfigs serves the target's ObliviousDoHConfigs.
+func (t *Target) ServeConfigs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "max-age=86400")
+	_, _ = w.Write(t.configs)
+}
+
+// ServeQuery opens the query sealed to the target, answers it through
+// handle and seals the answer back to the client. The relay in between
+// sees neither.
+func (t *Target) ServeQuery(w http.ResponseWriter, r *http.Request, handle func(*dns.Msg) *dns.Msg) {
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if !IsOblivious(r) {
+		writeHTTPError(w, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	defer r.Body.Close()
+	sealed, err := io.ReadAll(io.LimitReader(r.Body, maxMsgSize+1))
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError)
+		return
+	}
+	if len(sealed) > maxMsgSize {
+		writeHTTPError(w, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// RFC 9230 §7: an unknown key sends the client back for the
+	// configs; anything else that does not open is a bad request.
+	buf, rc, err := t.key.DecryptQuery(sealed)
+	if errors.Is(err, odoh.ErrKeyID) {
+		writeHTTPError(w, http.StatusUnauthorized)
+		return
+	}
+	if err != nil || len(buf) < minMsgHeaderSize {
+		writeHTTPError(w, http.StatusBadRequest)
+		return
+	}
+
+	req := new(dns.Msg)
+	if err := req.Unpack(buf); err != nil {
+		writeHTTPError(w, http.StatusBadRequest)
+		return
+	}
+
+	msg := handle(req)
+	if msg == nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		writeHTTPError(w, http.StatusBadRequest)
+		return
+	}
+
+	packed, err := msg.Pack()
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError)
+		return
+	}
+	resp, err := rc.EncryptResponse(packed)
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", odoh.ContentType)
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+
+	_, _ = w.Write(resp)
+}
+
+// Relay serves as an Oblivious DoH relay: it passes each sealed query
+// to the target its request names and the sealed answer back, so the
+// target never learns the client's address and the relay never the
+// query. Only the targets listed are relayed to; a relay open to any
+// host would let anyone use it to reach any host.
+type Relay struct {
+	// Targets are the hosts, with the port when not 443, queries may be
+	// relayed to.
+	Targets []string
+	// Client reaches the targets.
+	Client *http.Client
+	// Timeout bounds one relayed exchange; 0 leaves it to the client.
+	Timeout time.Duration
+}
+
+// ServeHTTP implements http.Handler.
+func (rl *Relay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if !IsOblivious(r) {
+		writeHTTPError(w, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	query := r.URL.Query()
+	host, path := strings.ToLower(query.Get("targethost")), query.Get("targetpath")
+	if host == "" || !strings.HasPrefix(path, "/") {
+		writeHTTPError(w, http.StatusBadRequest)
+		return
+	}
+	if !rl.allowed(host) {
+		writeHTTPError(w, http.StatusForbidden)
+		return
+	}
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMsgSize+1))
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError)
+		return
+	}
+	// Cut short, the message would reach the target as garbage; it is
+	// refused here instead, where the client can be told why.
+	if len(body) > maxMsgSize {
+		writeHTTPError(w, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ctx := r.Context()
+	if rl.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rl.Timeout)
+		defer cancel()
+	}
+	target := &url.URL{Scheme: "https", Host: host, Path: path}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(body))
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest)
+		return
+	}
+	// Nothing of the client's own goes on: no address, no cookies, no
+	// user agent — only what the target needs to read the message.
+	req.Header.Set("Content-Type", odoh.ContentType)
+	req.Header.Set("Accept", odoh.ContentType)
+	req.Header.Set("User-Agent", "sdns")
+
+	resp, err := rl.Client.Do(req)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		var ne net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+			writeHTTPError(w, http.StatusGatewayTimeout)
+			return
+		}
+		writeHTTPError(w, http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	sealed, err := io.ReadAll(io.LimitReader(resp.Body, maxMsgSize+1))
+	if err != nil || len(sealed) > maxMsgSize {
+		writeHTTPError(w, http.StatusBadGateway)
+		return
+	}
+
+	// The target's status goes back as it is — a 401 is what sends the
+	// client for fresh configs — and so does its sealed answer.
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(sealed)
+}
+
+// allowed reports whether host is one of the relay's targets.
+func (rl *Relay) allowed(host string) bool {
+	for _, t := range rl.Targets {
+		if strings.EqualFold(t, host) || strings.EqualFold(t+":443", host) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package doh
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/odoh"
+)
+
+func newTarget(t *testing.T) *odoh.KeyPair {
+	t.Helper()
+	secret, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := odoh.NewKeyPair(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func answerTest(req *dns.Msg) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetReply(req)
+	rr, _ := dns.NewRR(req.Question[0].Name + " 300 IN A 192.0.2.1")
+	msg.Answer = append(msg.Answer, rr)
+	return msg
+}
+
+func sealQuery(t *testing.T, config odoh.Config) ([]byte, *odoh.QueryContext) {
+	t.Helper()
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	buf, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, qc, err := odoh.EncryptQuery(config, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed, qc
+}
+
+func Test_odohTarget(t *testing.T) {
+	t.Parallel()
+
+	target := newTarget(t)
+
+	w := httptest.NewRecorder()
+	NewTarget(target).ServeConfigs(w, httptest.NewRequest(http.MethodGet, odoh.ConfigsPath, nil))
+	configs, err := odoh.ParseConfigs(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("configs: %v", err)
+	}
+
+	sealed, qc := sealQuery(t, configs[0])
+	r := httptest.NewRequest(http.MethodPost, "/dns-query", bytes.NewReader(sealed))
+	r.Header.Set("Content-Type", odoh.ContentType)
+	w = httptest.NewRecorder()
+	NewTarget(target).ServeQuery(w, r, answerTest)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != odoh.ContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	buf, err := qc.DecryptResponse(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answer) != 1 {
+		t.Errorf("answer = %v", resp.Answer)
+	}
+}
+
+func Test_odohTargetErrors(t *testing.T) {
+	t.Parallel()
+
+	target := newTarget(t)
+	stale, _ := sealQuery(t, newTarget(t).Config())
+	for _, tc := range []struct {
+		name   string
+		method string
+		ct     string
+		body   []byte
+		want   int
+	}{
+		{"get", http.MethodGet, odoh.ContentType, nil, http.StatusMethodNotAllowed},
+		{"media type", http.MethodPost, contentTypeDNS, stale, http.StatusUnsupportedMediaType},
+		{"unknown key", http.MethodPost, odoh.ContentType, stale, http.StatusUnauthorized},
+		{"garbage", http.MethodPost, odoh.ContentType, []byte{1, 2, 3}, http.StatusBadRequest},
+		{"oversized", http.MethodPost, odoh.ContentType, make([]byte, maxMsgSize+1), http.StatusRequestEntityTooLarge},
+	} {
+		r := httptest.NewRequest(tc.method, "/dns-query", bytes.NewReader(tc.body))
+		r.Header.Set("Content-Type", tc.ct)
+		w := httptest.NewRecorder()
+		NewTarget(target).ServeQuery(w, r, answerTest)
+		if w.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
+
+func Test_odohRelay(t *testing.T) {
+	t.Parallel()
+
+	target := newTarget(t)
+	served := NewTarget(target)
+	var seen http.Header
+	var requests int
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		requests++
+		served.ServeQuery(w, r, answerTest)
+	}))
+	defer ts.Close()
+	host := ts.Listener.Addr().String()
+
+	relay := &Relay{Targets: []string{host}, Client: ts.Client(), Timeout: 5 * time.Second}
+	relayed := func(targetHost string, body []byte) *httptest.ResponseRecorder {
+		q := url.Values{"targethost": {targetHost}, "targetpath": {"/dns-query"}}
+		r := httptest.NewRequest(http.MethodPost, "/proxy?"+q.Encode(), bytes.NewReader(body))
+		r.Header.Set("Content-Type", odoh.ContentType)
+		r.Header.Set("Cookie", "session=client")
+		w := httptest.NewRecorder()
+		relay.ServeHTTP(w, r)
+		return w
+	}
+
+	sealed, qc := sealQuery(t, target.Config())
+	w := relayed(host, sealed)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if _, err := qc.DecryptResponse(w.Body.Bytes()); err != nil {
+		t.Fatalf("relayed response: %v", err)
+	}
+	if seen.Get("Cookie") != "" {
+		t.Error("relay passed the client's cookie on")
+	}
+
+	// The target's refusal reaches the client as it is.
+	stale, _ := sealQuery(t, newTarget(t).Config())
+	if w := relayed(host, stale); w.Code != http.StatusUnauthorized {
+		t.Errorf("stale key relayed as %d, want 401", w.Code)
+	}
+
+	if w := relayed("other.example", sealed); w.Code != http.StatusForbidden {
+		t.Errorf("unlisted target: status = %d, want 403", w.Code)
+	}
+
+	// An oversized query is refused, not cut short and sent on.
+	before := requests
+	if w := relayed(host, make([]byte, maxMsgSize+1)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized query: status = %d, want 413", w.Code)
+	}
+	if requests != before {
+		t.Error("the relay forwarded an oversized query")
+	}
+
+	down := &Relay{Targets: []string{"127.0.0.1:1"}, Client: ts.Client()}
+	q := url.Values{"targethost": {"127.0.0.1:1"}, "targetpath": {"/dns-query"}}
+	r := httptest.NewRequest(http.MethodPost, "/proxy?"+q.Encode(), bytes.NewReader(sealed))
+	r.Header.Set("Content-Type", odoh.ContentType)
+	w = httptest.NewRecorder()
+	down.ServeHTTP(w, r)
+	if w.Code != http.StatusBadGateway {
+		body, _ := io.ReadAll(w.Body)
+		t.Errorf("unreachable target: status = %d (%s), want 502", w.Code, body)
+	}
+}
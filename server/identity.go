@@ -0,0 +1,198 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/middleware"
+	"github.com/semihalev/zlog/v2"
+)
+
+// clientIdentity names the client of a TLS connection by its verified
+// certificate: the SPIFFE ID if it has one, else the subject CN, else
+// its first DNS name. A client that presented no certificate, or one
+// that was not verified, has none.
+func clientIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	leaf := state.VerifiedChains[0][0]
+	for _, u := range leaf.URIs {
+		if u.Scheme == "spiffe" {
+			return u.String()
+		}
+	}
+	if leaf.Subject.CommonName != "" {
+		return leaf.Subject.CommonName
+	}
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames[0]
+	}
+	return ""
+}
+
+// clientAuth is a certProvider that also verifies client certificates
+// against pool, demanding one when required. A nil pool — a CA bundle
+// that failed to load — gives no config at all, so the listener stays
+// down rather than serving clients it cannot verify.
+type clientAuth struct {
+	certProvider
+	pool     *x509.CertPool
+	required bool
+}
+
+func (c clientAuth) GetTLSConfig() *tls.Config {
+	if c.pool == nil || c.certProvider == nil {
+		return nil
+	}
+	conf := c.certProvider.GetTLSConfig()
+	if conf == nil {
+		return nil
+	}
+	conf.ClientCAs = c.pool
+	conf.ClientAuth = tls.VerifyClientCertIfGiven
+	if c.required {
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf
+}
+
+// withClientAuth wraps certs to verify client certificates signed by the
+// CA bundle at caFile; an empty caFile leaves certs as it is.
+func withClientAuth(certs certProvider, caFile string, required bool) certProvider {
+	if caFile == "" {
+		return certs
+	}
+	pool, err := loadClientCA(caFile)
+	if err != nil {
+		zlog.Error("client CA load failed, listener disabled", "file", caFile, "error", err.Error())
+	}
+	return clientAuth{certProvider: certs, pool: pool, required: required}
+}
+
+func loadClientCA(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path) //nolint:gosec // G304 - path from the operator's config
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", path)
+	}
+	return pool, nil
+}
+
+// devicePath is the DoH path a device ID follows.
+const devicePath = "/dns-query/"
+
+// devices recognises the device IDs clients name without a certificate:
+// a DoH path, /dns-query/<id>, or a TLS server name, <id>.<domain>.
+type devices struct {
+	known *middleware.IdentitySet
+	// domain is lowercase without the trailing dot; "" reads no IDs
+	// from server names.
+	domain string
+}
+
+// newDevices returns the device IDs of cfg, nil when there are none.
+func newDevices(cfg *config.Config) *devices {
+	if len(cfg.DeviceIDs) == 0 {
+		return nil
+	}
+	ids := make([]string, len(cfg.DeviceIDs))
+	for i, id := range cfg.DeviceIDs {
+		ids[i] = strings.ToLower(id)
+	}
+	return &devices{
+		known:  middleware.NewIdentitySet(ids),
+		domain: strings.ToLower(strings.TrimSuffix(cfg.DeviceDomain, ".")),
+	}
+}
+
+// fromPath returns the device ID a DoH path names. A path naming none
+// is fine; ok is false only for an ID that is not known.
+func (d *devices) fromPath(path string) (id string, ok bool) {
+	if d == nil {
+		return "", true
+	}
+	id, found := strings.CutPrefix(path, devicePath)
+	if !found || id == "" {
+		return "", true
+	}
+	id = strings.ToLower(id)
+	return id, d.known.Contains(id)
+}
+
+// fromServerName returns the device ID a TLS server name names, with ok
+// false for an ID that is not known. Only the single label right under
+// the domain is an ID; the domain itself names no device.
+func (d *devices) fromServerName(name string) (id string, ok bool) {
+	if d == nil || d.domain == "" {
+		return "", true
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	id, found := strings.CutSuffix(name, "."+d.domain)
+	if !found {
+		return "", true
+	}
+	return id, d.known.Contains(id)
+}
+
+// identify names the client of a TLS connection: by its verified
+// certificate, else by the device ID of the DoH path or the server name
+// it asked for, spelled as middleware.DeviceIdentity. ok is false when the path names an unknown device; an
+// unknown server name never got past the handshake.
+func (d *devices) identify(state *tls.ConnectionState, path string) (id string, ok bool) {
+	device, ok := d.fromPath(path)
+	if !ok {
+		return "", false
+	}
+	if id := clientIdentity(state); id != "" {
+		return id, true
+	}
+	if device == "" && state != nil {
+		device, _ = d.fromServerName(state.ServerName)
+	}
+	if device == "" {
+		return "", true
+	}
+	return middleware.DeviceIdentity(device), true
+}
+
+// deviceNames is a certProvider that refuses the handshake of a client
+// asking for the server name of a device that is not known.
+type deviceNames struct {
+	certProvider
+	devices *devices
+}
+
+func (c deviceNames) GetTLSConfig() *tls.Config {
+	conf := c.certProvider.GetTLSConfig()
+	if conf == nil {
+		return nil
+	}
+	next := conf.GetConfigForClient
+	conf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if _, ok := c.devices.fromServerName(hello.ServerName); !ok {
+			return nil, fmt.Errorf("unknown device %q", hello.ServerName)
+		}
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+	return conf
+}
+
+// withDevices wraps certs to refuse unknown device names; without a
+// device domain it leaves certs as it is.
+func withDevices(certs certProvider, d *devices) certProvider {
+	if d == nil || d.domain == "" || certs == nil {
+		return certs
+	}
+	return deviceNames{certProvider: certs, devices: d}
+}
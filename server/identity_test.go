@@ -0,0 +1,442 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/middleware"
+)
+
+// identityStub answers every query with a TXT record naming the
+// client's certificate identity.
+type identityStub struct{}
+
+func (identityStub) Name() string { return "identity-stub" }
+
+func (identityStub) ServeDNS(ctx context.Context, ch *middleware.Chain) {
+	ctx, req := ch.Materialize(ctx)
+	if req == nil {
+		return
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Answer = []dns.RR{&dns.TXT{
+		Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+		Txt: []string{"id=" + ch.ClientIdentity()},
+	}}
+	_ = ch.Writer.WriteMsg(resp)
+	ch.Cancel()
+}
+
+// testPKI is a CA issuing the server's and the clients' certificates.
+type testPKI struct {
+	t    *testing.T
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+	dir  string
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sdns test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testPKI{t: t, key: key, cert: cert, dir: t.TempDir()}
+}
+
+// issue signs tmpl and writes it and its key as name.crt and name.key.
+func (p *testPKI) issue(name string, tmpl *x509.Certificate) tls.Certificate {
+	p.t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.cert, &key.PublicKey, p.key)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	writeCertAndKey(p.t, p.path(name+".crt"), p.path(name+".key"), certPEM, keyPEM)
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return pair
+}
+
+func (p *testPKI) path(name string) string { return filepath.Join(p.dir, name) }
+
+func (p *testPKI) writeCA(name string) string {
+	p.t.Helper()
+	file := p.path(name)
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.cert.Raw}), 0600); err != nil {
+		p.t.Fatal(err)
+	}
+	return file
+}
+
+func TestClientIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.com/laptop/7")
+	web, _ := url.Parse("https://example.com/")
+	for _, tc := range []struct {
+		leaf *x509.Certificate
+		want string
+	}{
+		{&x509.Certificate{URIs: []*url.URL{web, spiffe}, Subject: pkix.Name{CommonName: "laptop-7"}}, "spiffe://example.com/laptop/7"},
+		{&x509.Certificate{URIs: []*url.URL{web}, Subject: pkix.Name{CommonName: "laptop-7"}, DNSNames: []string{"laptop-7.example.com"}}, "laptop-7"},
+		{&x509.Certificate{DNSNames: []string{"laptop-7.example.com"}}, "laptop-7.example.com"},
+		{&x509.Certificate{}, ""},
+	} {
+		state := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tc.leaf}}}
+		if got := clientIdentity(state); got != tc.want {
+			t.Errorf("identity = %q, want %q", got, tc.want)
+		}
+	}
+
+	// Presented but not verified is no identity.
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "laptop-7"}}}}
+	if got := clientIdentity(state); got != "" {
+		t.Errorf("unverified certificate gave identity %q", got)
+	}
+	if clientIdentity(nil) != "" {
+		t.Error("plain connection has an identity")
+	}
+}
+
+func TestMutualTLSClientIdentity(t *testing.T) {
+	pki := newTestPKI(t)
+	pki.issue("server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "dns.example.test"},
+		DNSNames:    []string{"dns.example.test"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	spiffe, _ := url.Parse("spiffe://example.com/laptop/7")
+	laptop := pki.issue("laptop", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "laptop-7"},
+		URIs:        []*url.URL{spiffe},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	phone := pki.issue("phone", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "phone-2"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	middleware.Reset()
+	t.Cleanup(middleware.Reset)
+	middleware.Register("identity-stub", func(*config.Config) middleware.Handler { return identityStub{} })
+	cfg := &config.Config{
+		Bind:           "127.0.0.1:0",
+		BindTLS:        "127.0.0.1:0",
+		BindDOH:        "127.0.0.1:0",
+		TLSCertificate: pki.path("server.crt"),
+		TLSPrivateKey:  pki.path("server.key"),
+		TLSClientCA:    pki.writeCA("clients.pem"),
+		Listeners: []config.ListenerConfig{
+			// The global CA, demanded here only.
+			{Name: "strict", Proto: "tls", Addr: "127.0.0.1:0", TLSClientCertRequired: true},
+		},
+		QueryTimeout: config.Duration{Duration: 2 * time.Second},
+	}
+	middleware.Setup(cfg)
+	s := New(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Run(ctx); err != nil {
+		cancel()
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		deadline := time.Now().Add(5 * time.Second)
+		for !s.Stopped() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	var dot, strict, doh string
+	s.listenersMu.Lock()
+	for _, l := range s.active {
+		switch {
+		case l.Proto() == "tls" && policyOf(l) == nil:
+			dot = boundAddr(t, l)
+		case l.Proto() == "tls":
+			strict = boundAddr(t, l)
+		case l.Proto() == "doh":
+			doh = boundAddr(t, l)
+		}
+	}
+	s.listenersMu.Unlock()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.cert)
+	clientTLS := func(certs ...tls.Certificate) *tls.Config {
+		return &tls.Config{RootCAs: roots, ServerName: "dns.example.test", Certificates: certs, MinVersion: tls.VersionTLS12}
+	}
+	req := new(dns.Msg)
+	req.SetQuestion("whoami.example.", dns.TypeTXT)
+
+	overDoT := func(addr string, conf *tls.Config) (string, error) {
+		client := &dns.Client{Net: "tcp-tls", TLSConfig: conf, Timeout: 3 * time.Second}
+		resp, _, err := client.Exchange(req, addr)
+		if err != nil {
+			return "", err
+		}
+		return answeredBy(t, resp), nil
+	}
+	overDoH := func(conf *tls.Config) string {
+		data, _ := req.Pack()
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: conf}, Timeout: 3 * time.Second}
+		defer client.CloseIdleConnections()
+		resp, err := client.Post("https://"+doh+"/dns-query", "application/dns-message", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("DoH: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		msg := new(dns.Msg)
+		if err := msg.Unpack(body); err != nil {
+			t.Fatalf("DoH response: %v", err)
+		}
+		return answeredBy(t, msg)
+	}
+
+	if got, err := overDoT(dot, clientTLS(laptop)); err != nil || got != "id=spiffe://example.com/laptop/7" {
+		t.Errorf("DoT laptop: %q, %v", got, err)
+	}
+	if got, err := overDoT(dot, clientTLS()); err != nil || got != "id=" {
+		t.Errorf("DoT without a certificate: %q, %v", got, err)
+	}
+	if got := overDoH(clientTLS(phone)); got != "id=phone-2" {
+		t.Errorf("DoH phone: %q", got)
+	}
+	if got := overDoH(clientTLS()); got != "id=" {
+		t.Errorf("DoH without a certificate: %q", got)
+	}
+
+	if got, err := overDoT(strict, clientTLS(laptop)); err != nil || got != "id=spiffe://example.com/laptop/7" {
+		t.Errorf("strict listener laptop: %q, %v", got, err)
+	}
+	if _, err := overDoT(strict, clientTLS()); err == nil {
+		t.Error("strict listener served a client without a certificate")
+	}
+
+	// A certificate from another CA is refused outright.
+	other := newTestPKI(t)
+	stranger := other.issue("stranger", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "laptop-7"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if _, err := overDoT(dot, clientTLS(stranger)); err == nil {
+		t.Error("DoT served a certificate from another CA")
+	}
+}
+
+func TestClientAuthUnreadableCA(t *testing.T) {
+	certs := withClientAuth(staticCerts{}, filepath.Join(t.TempDir(), "missing.pem"), false)
+	if certs.GetTLSConfig() != nil {
+		t.Error("listener served without its client CA")
+	}
+	if withClientAuth(staticCerts{}, "", true) != (staticCerts{}) {
+		t.Error("no client CA changed the provider")
+	}
+}
+
+func TestDevices(t *testing.T) {
+	d := newDevices(&config.Config{DeviceIDs: []string{"kids-ipad", "TV-*"}, DeviceDomain: "DNS.example.com."})
+	for _, tc := range []struct {
+		path   string
+		want   string
+		wantOK bool
+	}{
+		{"/dns-query", "", true},
+		{"/dns-query/", "", true},
+		{"/dns-query/kids-ipad", "kids-ipad", true},
+		{"/dns-query/Kids-iPad", "kids-ipad", true},
+		{"/dns-query/tv-lounge", "tv-lounge", true},
+		{"/dns-query/laptop", "laptop", false},
+		{"/other/kids-ipad", "", true},
+	} {
+		if id, ok := d.fromPath(tc.path); id != tc.want || ok != tc.wantOK {
+			t.Errorf("fromPath(%q) = %q, %v", tc.path, id, ok)
+		}
+	}
+	for _, tc := range []struct {
+		name   string
+		want   string
+		wantOK bool
+	}{
+		{"dns.example.com", "", true},
+		{"kids-ipad.dns.example.com", "kids-ipad", true},
+		{"KIDS-IPAD.dns.example.com.", "kids-ipad", true},
+		{"laptop.dns.example.com", "laptop", false},
+		{"a.kids-ipad.dns.example.com", "a.kids-ipad", false},
+		{"kids-ipad.example.org", "", true},
+		{"", "", true},
+	} {
+		if id, ok := d.fromServerName(tc.name); id != tc.want || ok != tc.wantOK {
+			t.Errorf("fromServerName(%q) = %q, %v", tc.name, id, ok)
+		}
+	}
+
+	var none *devices
+	if id, ok := none.fromPath("/dns-query/kids-ipad"); id != "" || !ok {
+		t.Errorf("no device IDs read %q, %v from a path", id, ok)
+	}
+	if newDevices(&config.Config{}) != nil {
+		t.Error("no device IDs configured gave a device set")
+	}
+}
+
+func TestDeviceIdentity(t *testing.T) {
+	pki := newTestPKI(t)
+	pki.issue("server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "dns.example.test"},
+		DNSNames:    []string{"dns.example.test", "*.dns.example.test"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	phone := pki.issue("phone", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "phone-2"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	middleware.Reset()
+	t.Cleanup(middleware.Reset)
+	middleware.Register("identity-stub", func(*config.Config) middleware.Handler { return identityStub{} })
+	cfg := &config.Config{
+		Bind:           "127.0.0.1:0",
+		BindTLS:        "127.0.0.1:0",
+		BindDOH:        "127.0.0.1:0",
+		TLSCertificate: pki.path("server.crt"),
+		TLSPrivateKey:  pki.path("server.key"),
+		TLSClientCA:    pki.writeCA("clients.pem"),
+		DeviceIDs:      []string{"kids-ipad", "tv-*"},
+		DeviceDomain:   "dns.example.test",
+		QueryTimeout:   config.Duration{Duration: 2 * time.Second},
+	}
+	middleware.Setup(cfg)
+	s := New(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Run(ctx); err != nil {
+		cancel()
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		deadline := time.Now().Add(5 * time.Second)
+		for !s.Stopped() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	var dot, doh string
+	s.listenersMu.Lock()
+	for _, l := range s.active {
+		switch l.Proto() {
+		case "tls":
+			dot = boundAddr(t, l)
+		case "doh":
+			doh = boundAddr(t, l)
+		}
+	}
+	s.listenersMu.Unlock()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.cert)
+	clientTLS := func(serverName string, certs ...tls.Certificate) *tls.Config {
+		return &tls.Config{RootCAs: roots, ServerName: serverName, Certificates: certs, MinVersion: tls.VersionTLS12}
+	}
+	req := new(dns.Msg)
+	req.SetQuestion("whoami.example.", dns.TypeTXT)
+
+	overDoT := func(serverName string) (string, error) {
+		client := &dns.Client{Net: "tcp-tls", TLSConfig: clientTLS(serverName), Timeout: 3 * time.Second}
+		resp, _, err := client.Exchange(req, dot)
+		if err != nil {
+			return "", err
+		}
+		return answeredBy(t, resp), nil
+	}
+	overDoH := func(path string, certs ...tls.Certificate) (int, string) {
+		data, _ := req.Pack()
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS("dns.example.test", certs...)}, Timeout: 3 * time.Second}
+		defer client.CloseIdleConnections()
+		resp, err := client.Post("https://"+doh+path, "application/dns-message", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("DoH: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, ""
+		}
+		msg := new(dns.Msg)
+		if err := msg.Unpack(body); err != nil {
+			t.Fatalf("DoH response: %v", err)
+		}
+		return resp.StatusCode, answeredBy(t, msg)
+	}
+
+	if got, err := overDoT("kids-ipad.dns.example.test"); err != nil || got != "id=device:kids-ipad" {
+		t.Errorf("DoT by server name: %q, %v", got, err)
+	}
+	if got, err := overDoT("dns.example.test"); err != nil || got != "id=" {
+		t.Errorf("DoT without a device: %q, %v", got, err)
+	}
+	if _, err := overDoT("laptop.dns.example.test"); err == nil {
+		t.Error("DoT served an unknown device")
+	}
+
+	if code, got := overDoH("/dns-query/tv-lounge"); code != http.StatusOK || got != "id=device:tv-lounge" {
+		t.Errorf("DoH by path: %d %q", code, got)
+	}
+	if code, got := overDoH("/dns-query"); code != http.StatusOK || got != "id=" {
+		t.Errorf("DoH without a device: %d %q", code, got)
+	}
+	if code, _ := overDoH("/dns-query/laptop"); code != http.StatusNotFound {
+		t.Errorf("DoH unknown device: %d, want 404", code)
+	}
+	// A verified certificate outranks the device the path names.
+	if code, got := overDoH("/dns-query/kids-ipad", phone); code != http.StatusOK || got != "id=phone-2" {
+		t.Errorf("DoH certificate and path: %d %q", code, got)
+	}
+}
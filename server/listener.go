@@ -14,7 +14,7 @@ func zlogListenerDisabled(l Listener, err error) {
 }
 
 // Listener is the lifecycle contract for a single DNS service endpoint
-// (UDP, TCP, DoT, DoH, DoH3, DoQ). It separates bind from serve so that
+// (UDP, TCP, DoT, DoH, DoH3, DoQ, unix, DNSCrypt). It separates bind from serve so that
 // the Server can fail fast on port-in-use, missing cert, etc. instead of
 // swallowing the error inside a background goroutine.
 //
@@ -23,10 +23,10 @@ func zlogListenerDisabled(l Listener, err error) {
 // Shutdown is idempotent.
 type Listener interface {
 	// Proto returns the transport tag — "udp", "tcp", "tls", "doh",
-	// "doh3", "doq" — used for logging and metrics.
+	// "doh3", "doq", "unix", "dnscrypt" — used for logging and metrics.
 	Proto() string
 
-	// Addr returns the configured bind address.
+	// Addr returns the configured bind address, or socket path.
 	Addr() string
 
 	// Bind acquires the underlying socket (and any TLS material it
@@ -44,7 +44,7 @@ type Listener interface {
 
 	// Critical reports whether a Bind failure on this listener should
 	// abort server startup. Plain DNS (UDP+TCP on cfg.Bind) is
-	// critical; optional services (TLS, DoH, DoH3, DoQ) are not —
+	// critical; optional services (TLS, DoH, DoH3, DoQ, unix) are not —
 	// a missing cert or misconfigured addr only disables that service.
 	Critical() bool
 
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/semihalev/sdns/middleware"
+	"github.com/semihalev/sdns/server/dnscrypt"
+	"github.com/semihalev/zlog/v2"
+)
+
+// dnscryptListener serves DNSCrypt v2 over UDP and TCP on one address,
+// as the server's provider. Non-critical.
+type dnscryptListener struct {
+	addr     string
+	handler  dnscrypt.Handler
+	provider func() (*dnscrypt.Provider, error)
+	props    dnscrypt.StampProps
+	// policy is the [[listeners]] entry served, nil on a bind address.
+	policy *middleware.ListenerPolicy
+	// socket names the systemd-activated sockets served instead of
+	// binding addr (activation.go).
+	socket string
+
+	mu      sync.Mutex
+	srv     *dnscrypt.Server
+	pc      net.PacketConn
+	ln      net.Listener
+	serving atomic.Bool
+}
+
+func newDNSCryptListener(addr string, h dnscrypt.Handler, provider func() (*dnscrypt.Provider, error), props dnscrypt.StampProps) *dnscryptListener {
+	return &dnscryptListener{addr: addr, handler: h, provider: provider, props: props}
+}
+
+func (d *dnscryptListener) Proto() string  { return "dnscrypt" }
+func (d *dnscryptListener) Addr() string   { return d.addr }
+func (d *dnscryptListener) Critical() bool { return false }
+func (d *dnscryptListener) Serving() bool  { return d.serving.Load() }
+
+// Policy returns the [[listeners]] entry served, nil on a bind address.
+func (d *dnscryptListener) Policy() *middleware.ListenerPolicy { return d.policy }
+
+func (d *dnscryptListener) Bind(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.srv != nil {
+		return errors.New("dnscrypt listener: Bind called twice")
+	}
+	provider, err := d.provider()
+	if err != nil {
+		return err
+	}
+
+	pc := inheritedPacketConn(d.socket)
+	ln := inheritedListener(d.socket)
+	var lc net.ListenConfig
+	if pc == nil {
+		if pc, err = lc.ListenPacket(ctx, "udp", d.addr); err != nil {
+			if ln != nil {
+				_ = ln.Close()
+			}
+			return err
+		}
+	}
+	if ln == nil {
+		if ln, err = lc.Listen(ctx, "tcp", d.addr); err != nil {
+			_ = pc.Close()
+			return err
+		}
+	}
+	d.pc, d.ln = pc, ln
+	d.srv = &dnscrypt.Server{Provider: provider, Handler: d.handler}
+	return nil
+}
+
+func (d *dnscryptListener) Serve(_ context.Context) error {
+	d.mu.Lock()
+	srv, pc, ln := d.srv, d.pc, d.ln
+	d.mu.Unlock()
+	if srv == nil {
+		return errListenerNotBound
+	}
+
+	zlog.Info("DNS server listening", "net", "dnscrypt", "addr", d.addr, "provider", srv.Provider.Name())
+	for _, stamp := range d.stamps(srv.Provider) {
+		zlog.Info("DNSCrypt stamp", "stamp", stamp)
+	}
+	d.serving.Store(true)
+	defer d.serving.Store(false)
+
+	// Both loops return nil on shutdown; the first to fail otherwise
+	// reports it, and the other serves on until Shutdown.
+	errc := make(chan error, 2)
+	go func() { errc <- srv.ServeUDP(pc) }()
+	go func() { errc <- srv.ServeTCP(ln) }()
+	if err := <-errc; err != nil {
+		return err
+	}
+	return <-errc
+}
+
+// Shutdown stops both sockets and drains the queries in flight; the
+// dnscrypt server closes the sockets once they have answered.
+func (d *dnscryptListener) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	srv := d.srv
+	d.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	zlog.Info("DNS server stopping", "net", "dnscrypt", "addr", d.addr)
+	return srv.Shutdown(ctx)
+}
+
+// passSockets hands the bound sockets to an upgrade.
+func (d *dnscryptListener) passSockets() []PassedSocket {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append(passSocket(d.socket, d.pc), passSocket(d.socket, d.ln)...)
+}
+
+// stamps returns the provider's stamp at each address clients reach the
+// listener on: the one bound, or for a wildcard, the host's own.
+func (d *dnscryptListener) stamps(p *dnscrypt.Provider) []string {
+	host, port, err := net.SplitHostPort(d.addr)
+	if err != nil {
+		return nil
+	}
+	v4, v6 := addrHints(host)
+	ips := append(v4, v6...)
+	if len(ips) == 0 {
+		if ip := net.ParseIP(host); host != "" && ip == nil {
+			// A host name: not what a stamp should carry, but what the
+			// operator configured.
+			return []string{p.Stamp(d.addr, d.props)}
+		}
+		ips = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	stamps := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		stamps = append(stamps, p.Stamp(net.JoinHostPort(ip.String(), port), d.props))
+	}
+	return stamps
+}
+
+// dnscryptProvider returns the provider every dnscrypt listener serves
+// as, its Ed25519 key read from the working directory — and created
+// there on first use, so the stamps clients pin survive restarts.
+func (s *Server) dnscryptProvider() (*dnscrypt.Provider, error) {
+	s.providerMu.Lock()
+	defer s.providerMu.Unlock()
+	if s.provider != nil {
+		return s.provider, nil
+	}
+
+	if s.cfg.Directory != "" {
+		if err := os.MkdirAll(s.cfg.Directory, 0700); err != nil {
+			return nil, err
+		}
+	}
+	path := filepath.Join(s.cfg.Directory, "dnscrypt.key")
+	key, err := loadOrCreateKey(path, func() (ed25519.PrivateKey, error) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dnscrypt provider key: %w", err)
+	}
+	name := s.cfg.DNSCryptProvider
+	if name == "" {
+		name = dnscrypt.ProviderPrefix + "sdns"
+	}
+	if s.provider, err = dnscrypt.NewProvider(name, key); err != nil {
+		return nil, err
+	}
+	return s.provider, nil
+}
+
+// dnscryptProps are the properties the stamps announce.
+func (s *Server) dnscryptProps() dnscrypt.StampProps {
+	if s.cfg.DNSSEC == "off" {
+		return 0
+	}
+	return dnscrypt.StampDNSSEC
+}
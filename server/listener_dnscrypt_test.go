@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/middleware"
+)
+
+func TestDNSCryptProviderKey(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	cfg := &config.Config{Directory: dir}
+	p, err := New(cfg).dnscryptProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != "2.dnscrypt-cert.sdns" {
+		t.Errorf("Name() = %q, want the default", p.Name())
+	}
+	fi, err := os.Stat(filepath.Join(dir, "dnscrypt.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("key mode = %o, want 600", fi.Mode().Perm())
+	}
+
+	// The key outlives the process, and with it the stamp.
+	cfg.DNSCryptProvider = "resolver.example"
+	again, err := New(cfg).dnscryptProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.PublicKey(), p.PublicKey()) {
+		t.Error("provider key changed across servers")
+	}
+	if again.Name() != "2.dnscrypt-cert.resolver.example" {
+		t.Errorf("Name() = %q", again.Name())
+	}
+}
+
+func TestDNSCryptListener(t *testing.T) {
+	middleware.Reset()
+	t.Cleanup(middleware.Reset)
+
+	cfg := &config.Config{
+		Bind:         "127.0.0.1:0",
+		BindDNSCrypt: "127.0.0.1:0",
+		Directory:    t.TempDir(),
+		QueryTimeout: config.Duration{Duration: 2 * time.Second},
+	}
+	middleware.Setup(cfg)
+	s := New(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		deadline := time.Now().Add(5 * time.Second)
+		for !s.Stopped() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	var l *dnscryptListener
+	for _, active := range s.active {
+		if d, ok := active.(*dnscryptListener); ok {
+			l = d
+		}
+	}
+	if l == nil {
+		t.Fatal("no dnscrypt listener")
+	}
+	if got := l.passSockets(); len(got) != 2 || got[0].Name != socketDNSCrypt {
+		t.Errorf("passSockets() = %v, want the UDP and TCP sockets", got)
+	}
+	stamps := l.stamps(l.srv.Provider)
+	if len(stamps) != 1 || !strings.HasPrefix(stamps[0], "sdns://") {
+		t.Errorf("stamps() = %v", stamps)
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("2.dnscrypt-cert.sdns.", dns.TypeTXT)
+	q.SetEdns0(4096, false)
+	for _, c := range []*dns.Client{
+		{Net: "udp", Timeout: 3 * time.Second},
+		{Net: "tcp", Timeout: 3 * time.Second},
+	} {
+		addr := l.pc.LocalAddr().String()
+		if c.Net == "tcp" {
+			addr = l.ln.Addr().String()
+		}
+		var resp *dns.Msg
+		var err error
+		for range 20 {
+			if resp, _, err = c.Exchange(q, addr); err == nil {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("%s certificate query: %v", c.Net, err)
+		}
+		if len(resp.Answer) != 2 {
+			t.Fatalf("%s certificate query answered %d records, want 2", c.Net, len(resp.Answer))
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/zlog/v2"
 )
 
@@ -22,6 +23,11 @@ type dohListener struct {
 	handler http.Handler
 	certs   certProvider
 	timeout time.Duration
+	// policy is the [[listeners]] entry served, nil on a bind address.
+	policy *middleware.ListenerPolicy
+	// socket names the systemd-activated socket served instead of
+	// binding addr (activation.go).
+	socket string
 
 	mu        sync.Mutex
 	srv       *http.Server
@@ -39,6 +45,9 @@ func (d *dohListener) Addr() string   { return d.addr }
 func (d *dohListener) Critical() bool { return false }
 func (d *dohListener) Serving() bool  { return d.serving.Load() }
 
+// Policy returns the [[listeners]] entry served, nil on a bind address.
+func (d *dohListener) Policy() *middleware.ListenerPolicy { return d.policy }
+
 func (d *dohListener) Bind(ctx context.Context) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -53,10 +62,13 @@ func (d *dohListener) Bind(ctx context.Context) error {
 		return errors.New("TLS certificate not available")
 	}
 
-	var lc net.ListenConfig
-	ln, err := lc.Listen(ctx, "tcp", d.addr)
-	if err != nil {
-		return err
+	ln := inheritedListener(d.socket)
+	if ln == nil {
+		var lc net.ListenConfig
+		var err error
+		if ln, err = lc.Listen(ctx, "tcp", d.addr); err != nil {
+			return err
+		}
 	}
 
 	// Reroute http.Server's internal error logging through zlog so we
@@ -107,6 +119,13 @@ func (d *dohListener) Serve(_ context.Context) error {
 	return nil
 }
 
+// passSockets hands the bound socket to an upgrade.
+func (d *dohListener) passSockets() []PassedSocket {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return passSocket(d.socket, d.ln)
+}
+
 func (d *dohListener) Shutdown(_ context.Context) error {
 	d.mu.Lock()
 	srv := d.srv
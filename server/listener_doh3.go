@@ -11,6 +11,7 @@ import (
 
 	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
+	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/zlog/v2"
 )
 
@@ -20,6 +21,11 @@ type doh3Listener struct {
 	addr    string
 	handler http.Handler
 	certs   certProvider
+	// policy is the [[listeners]] entry served, nil on a bind address.
+	policy *middleware.ListenerPolicy
+	// socket names the systemd-activated socket served instead of
+	// binding addr (activation.go).
+	socket string
 
 	mu      sync.Mutex
 	srv     *http3.Server
@@ -36,6 +42,9 @@ func (d *doh3Listener) Addr() string   { return d.addr }
 func (d *doh3Listener) Critical() bool { return false }
 func (d *doh3Listener) Serving() bool  { return d.serving.Load() }
 
+// Policy returns the [[listeners]] entry served, nil on a bind address.
+func (d *doh3Listener) Policy() *middleware.ListenerPolicy { return d.policy }
+
 func (d *doh3Listener) Bind(ctx context.Context) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -50,10 +59,13 @@ func (d *doh3Listener) Bind(ctx context.Context) error {
 		return errors.New("TLS certificate not available")
 	}
 
-	var lc net.ListenConfig
-	pc, err := lc.ListenPacket(ctx, "udp", d.addr)
-	if err != nil {
-		return err
+	pc := inheritedPacketConn(d.socket)
+	if pc == nil {
+		var lc net.ListenConfig
+		var err error
+		if pc, err = lc.ListenPacket(ctx, "udp", d.addr); err != nil {
+			return err
+		}
 	}
 	d.pc = pc
 	d.srv = &http3.Server{
@@ -96,6 +108,13 @@ func (d *doh3Listener) Serve(_ context.Context) error {
 	return nil
 }
 
+// passSockets hands the bound socket to an upgrade.
+func (d *doh3Listener) passSockets() []PassedSocket {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return passSocket(d.socket, d.pc)
+}
+
 func (d *doh3Listener) Shutdown(ctx context.Context) error {
 	d.mu.Lock()
 	srv := d.srv
@@ -9,6 +9,7 @@ import (
 	"sync/atomic"
 
 	"github.com/quic-go/quic-go"
+	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/sdns/server/doq"
 	"github.com/semihalev/zlog/v2"
 )
@@ -18,6 +19,11 @@ type doqListener struct {
 	addr    string
 	handler doq.Handler
 	certs   certProvider
+	// policy is the [[listeners]] entry served, nil on a bind address.
+	policy *middleware.ListenerPolicy
+	// socket names the systemd-activated socket served instead of
+	// binding addr (activation.go).
+	socket string
 
 	mu      sync.Mutex
 	srv     *doq.Server
@@ -35,6 +41,9 @@ func (d *doqListener) Addr() string   { return d.addr }
 func (d *doqListener) Critical() bool { return false }
 func (d *doqListener) Serving() bool  { return d.serving.Load() }
 
+// Policy returns the [[listeners]] entry served, nil on a bind address.
+func (d *doqListener) Policy() *middleware.ListenerPolicy { return d.policy }
+
 func (d *doqListener) Bind(ctx context.Context) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -56,10 +65,13 @@ func (d *doqListener) Bind(ctx context.Context) error {
 	}
 	d.tls = tlsConfig
 
-	var lc net.ListenConfig
-	pc, err := lc.ListenPacket(ctx, "udp", d.addr)
-	if err != nil {
-		return err
+	pc := inheritedPacketConn(d.socket)
+	if pc == nil {
+		var lc net.ListenConfig
+		var err error
+		if pc, err = lc.ListenPacket(ctx, "udp", d.addr); err != nil {
+			return err
+		}
 	}
 	d.pc = pc
 	d.srv = &doq.Server{Addr: d.addr, Handler: d.handler}
@@ -88,6 +100,13 @@ func (d *doqListener) Serve(_ context.Context) error {
 	return nil
 }
 
+// passSockets hands the bound socket to an upgrade.
+func (d *doqListener) passSockets() []PassedSocket {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return passSocket(d.socket, d.pc)
+}
+
 func (d *doqListener) Shutdown(_ context.Context) error {
 	d.mu.Lock()
 	srv := d.srv
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/metric"
+	"github.com/semihalev/sdns/middleware"
+)
+
+// listenerNameStub answers every query with a TXT record naming the
+// listener the query arrived on.
+type listenerNameStub struct{}
+
+func (listenerNameStub) Name() string { return "listener-name-stub" }
+
+func (listenerNameStub) ServeDNS(ctx context.Context, ch *middleware.Chain) {
+	ctx, req := ch.Materialize(ctx)
+	if req == nil {
+		return
+	}
+	name := defaultListener
+	if p := ch.ListenerPolicy(); p != nil {
+		name = p.Name
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Answer = []dns.RR{&dns.TXT{
+		Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+		Txt: []string{name},
+	}}
+	_ = ch.Writer.WriteMsg(resp)
+	ch.Cancel()
+}
+
+func answeredBy(t *testing.T, resp *dns.Msg) string {
+	t.Helper()
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	txt, ok := resp.Answer[0].(*dns.TXT)
+	if !ok || len(txt.Txt) != 1 {
+		t.Fatalf("unexpected answer: %v", resp.Answer[0])
+	}
+	return txt.Txt[0]
+}
+
+func TestServerListeners(t *testing.T) {
+	middleware.Reset()
+	t.Cleanup(middleware.Reset)
+	middleware.Register("listener-name-stub", func(*config.Config) middleware.Handler {
+		return listenerNameStub{}
+	})
+	cfg := &config.Config{
+		Bind: "127.0.0.1:0",
+		Listeners: []config.ListenerConfig{
+			{Name: "lan", Proto: "dns", Addr: "127.0.0.1:0"},
+			{Proto: "udp", Addr: "127.0.0.1:0"},
+		},
+		QueryTimeout: config.Duration{Duration: 2 * time.Second},
+	}
+	middleware.Setup(cfg)
+	s := New(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		deadline := time.Now().Add(5 * time.Second)
+		for !s.Stopped() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	type endpoint struct{ proto, addr, want string }
+	var endpoints []endpoint
+	s.listenersMu.Lock()
+	for _, l := range s.active {
+		endpoints = append(endpoints, endpoint{l.Proto(), boundAddr(t, l), listenerLabel(policyOf(l))})
+	}
+	s.listenersMu.Unlock()
+	if len(endpoints) != 5 {
+		t.Fatalf("%d listeners active, want 5", len(endpoints))
+	}
+
+	for _, e := range endpoints {
+		req := new(dns.Msg)
+		req.SetQuestion("listener.example.", dns.TypeTXT)
+		client := &dns.Client{Net: e.proto, Timeout: 3 * time.Second}
+		var resp *dns.Msg
+		var err error
+		// The listener goroutines may still be coming up.
+		for range 20 {
+			resp, _, err = client.Exchange(req, e.addr)
+			if err == nil {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("exchange over %s %s: %v", e.proto, e.addr, err)
+		}
+		if got := answeredBy(t, resp); got != e.want {
+			t.Errorf("%s %s answered as listener %q, want %q", e.proto, e.addr, got, e.want)
+		}
+	}
+
+	metric.FlushAll()
+	if got := listenerQueries.WithLabelValues("tcp", "lan").Value(); got < 1 {
+		t.Errorf("tcp queries of lan = %d, want at least 1", got)
+	}
+	if got := listenerQueries.WithLabelValues("udp", "udp://127.0.0.1:0").Value(); got < 1 {
+		t.Errorf("udp queries of the unnamed listener = %d, want at least 1", got)
+	}
+}
+
+func TestServerListenersOnly(t *testing.T) {
+	cfg := &config.Config{
+		Listeners: []config.ListenerConfig{
+			{Name: "wg", Proto: "dns", Addr: "127.0.0.1:0"},
+			{Name: "dot", Proto: "tls", Addr: "127.0.0.1:0", TLSCertificate: "wg.crt", TLSPrivateKey: "wg.key"},
+		},
+	}
+	s := New(cfg)
+	if cfg.Bind != "" {
+		t.Fatalf("bind defaulted to %q with listeners configured", cfg.Bind)
+	}
+	if len(s.listeners) != 3 {
+		t.Fatalf("%d listeners, want 3", len(s.listeners))
+	}
+	for _, l := range s.listeners {
+		p := policyOf(l)
+		if p == nil {
+			t.Fatalf("%s listener without a policy", l.Proto())
+		}
+		if want := map[string]string{"udp": "wg", "tcp": "wg", "tls": "dot"}[l.Proto()]; p.Name != want {
+			t.Errorf("%s listener serves %q, want %q", l.Proto(), p.Name, want)
+		}
+	}
+	tlsl := s.listeners[2].(*tlsListener)
+	if _, ok := tlsl.certs.(listenerCerts); !ok {
+		t.Errorf("tls listener certs = %T, want its own", tlsl.certs)
+	}
+}
+
+func TestEndpointStampsPolicy(t *testing.T) {
+	middleware.Reset()
+	t.Cleanup(middleware.Reset)
+	middleware.Register("listener-name-stub", func(*config.Config) middleware.Handler {
+		return listenerNameStub{}
+	})
+	cfg := &config.Config{QueryTimeout: config.Duration{Duration: 2 * time.Second}}
+	middleware.Setup(cfg)
+	s := New(cfg)
+
+	req := new(dns.Msg)
+	req.SetQuestion("listener.example.", dns.TypeTXT)
+	data, err := req.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &middleware.ListenerPolicy{Name: "vpn-doh"}
+	for _, tc := range []struct {
+		handler http.Handler
+		want    string
+	}{
+		{s, defaultListener},
+		{s.endpoint("doh", "127.0.0.1:8443", policy), "vpn-doh"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/dns-query?dns="+base64.RawURLEncoding.EncodeToString(data), nil)
+		w := httptest.NewRecorder()
+		tc.handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d", w.Code)
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(w.Body.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if got := answeredBy(t, resp); got != tc.want {
+			t.Errorf("DoH answered as listener %q, want %q", got, tc.want)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/zlog/v2"
 )
 
@@ -21,6 +22,11 @@ type tcpListener struct {
 	maxConns int
 	plan     resourcePlan
 	timeout  time.Duration
+	// policy is the [[listeners]] entry served, nil on a bind address.
+	policy *middleware.ListenerPolicy
+	// socket names the systemd-activated socket served instead of
+	// binding addr (activation.go).
+	socket string
 
 	mu       sync.Mutex
 	ln       net.Listener
@@ -41,6 +47,9 @@ func (l *tcpListener) Addr() string   { return l.addr }
 func (l *tcpListener) Critical() bool { return true }
 func (l *tcpListener) Serving() bool  { return l.serving.Load() }
 
+// Policy returns the [[listeners]] entry served, nil on a bind address.
+func (l *tcpListener) Policy() *middleware.ListenerPolicy { return l.policy }
+
 // Quiesced reports whether the engine holds no in-flight work.
 func (l *tcpListener) Quiesced() bool {
 	l.mu.Lock()
@@ -54,13 +63,18 @@ func (l *tcpListener) Bind(ctx context.Context) error {
 	if l.ln != nil {
 		return errors.New("tcp listener: Bind called twice")
 	}
-	var lc net.ListenConfig
-	ln, err := lc.Listen(ctx, "tcp", l.addr)
-	if err != nil {
-		return err
+	ln := inheritedListener(l.socket)
+	if ln == nil {
+		var lc net.ListenConfig
+		var err error
+		if ln, err = lc.Listen(ctx, "tcp", l.addr); err != nil {
+			return err
+		}
 	}
 	l.ln = ln
 	l.engine = newTCPEngine(l.handler, "tcp", l.maxConns, l.plan)
+	l.engine.listener = l.policy
+	l.engine.queries = listenerQueryCounter("tcp", l.policy)
 	l.done = make(chan struct{})
 	return nil
 }
@@ -81,7 +95,7 @@ func (l *tcpListener) Serve(_ context.Context) error {
 	if !engine.startAccepting(ln, func() {
 		if !l.closing.Load() {
 			zlog.Error("TCP accept loop exited outside shutdown", "addr", l.addr)
-			recordListenerErr("tcp")
+			recordListenerErr("tcp", l.policy)
 		}
 	}) {
 		// Shutdown got here first and the engine refused the loop rather
@@ -122,6 +136,13 @@ func (l *tcpListener) Shutdown(_ context.Context) error {
 	return l.drainErr
 }
 
+// passSockets hands the bound socket to an upgrade.
+func (l *tcpListener) passSockets() []PassedSocket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return passSocket(l.socket, l.ln)
+}
+
 // TrimIdleMemory drops the engine's parked slabs (see trim.go).
 func (l *tcpListener) TrimIdleMemory() int {
 	l.mu.Lock()
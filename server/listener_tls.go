@@ -9,6 +9,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/zlog/v2"
 )
 
@@ -28,9 +29,17 @@ type tlsListener struct {
 	maxConns int
 	plan     resourcePlan
 	timeout  time.Duration
+	// policy is the [[listeners]] entry served, nil on a bind address.
+	policy *middleware.ListenerPolicy
+	// socket names the systemd-activated socket served instead of
+	// binding addr (activation.go).
+	socket string
+	// devices names clients by the server name they ask for.
+	devices *devices
 
 	mu       sync.Mutex
 	ln       net.Listener
+	tcp      net.Listener // under ln, for an upgrade to take over
 	engine   *tcpEngine
 	done     chan struct{}
 	shutdown sync.Once
@@ -48,6 +57,9 @@ func (l *tlsListener) Addr() string   { return l.addr }
 func (l *tlsListener) Critical() bool { return false }
 func (l *tlsListener) Serving() bool  { return l.serving.Load() }
 
+// Policy returns the [[listeners]] entry served, nil on a bind address.
+func (l *tlsListener) Policy() *middleware.ListenerPolicy { return l.policy }
+
 func (l *tlsListener) Bind(ctx context.Context) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -61,13 +73,20 @@ func (l *tlsListener) Bind(ctx context.Context) error {
 	if tlsConfig == nil {
 		return errors.New("TLS certificate not available")
 	}
-	var lc net.ListenConfig
-	ln, err := lc.Listen(ctx, "tcp", l.addr)
-	if err != nil {
-		return err
+	ln := inheritedListener(l.socket)
+	if ln == nil {
+		var lc net.ListenConfig
+		var err error
+		if ln, err = lc.Listen(ctx, "tcp", l.addr); err != nil {
+			return err
+		}
 	}
 	l.ln = tls.NewListener(ln, tlsConfig)
+	l.tcp = ln
 	l.engine = newTCPEngine(l.handler, "tls", l.maxConns, l.plan)
+	l.engine.listener = l.policy
+	l.engine.devices = l.devices
+	l.engine.queries = listenerQueryCounter("tls", l.policy)
 	l.done = make(chan struct{})
 	return nil
 }
@@ -88,7 +107,7 @@ func (l *tlsListener) Serve(_ context.Context) error {
 	if !engine.startAccepting(ln, func() {
 		if !l.closing.Load() {
 			zlog.Error("DoT accept loop exited outside shutdown", "addr", l.addr)
-			recordListenerErr("tls")
+			recordListenerErr("tls", l.policy)
 		}
 	}) {
 		// Shutdown got here first and the engine refused the loop rather
@@ -136,6 +155,13 @@ func (l *tlsListener) Quiesced() bool {
 	return l.engine == nil || l.engine.quiesced()
 }
 
+// passSockets hands the bound socket to an upgrade.
+func (l *tlsListener) passSockets() []PassedSocket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return passSocket(l.socket, l.tcp)
+}
+
 // TrimIdleMemory drops the engine's parked slabs (see trim.go).
 func (l *tlsListener) TrimIdleMemory() int {
 	l.mu.Lock()
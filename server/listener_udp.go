@@ -8,6 +8,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/zlog/v2"
 )
 
@@ -30,6 +31,11 @@ type udpListener struct {
 	queue   int
 	plan    resourcePlan
 	timeout time.Duration
+	// policy is the [[listeners]] entry served, nil on a bind address.
+	policy *middleware.ListenerPolicy
+	// socket names the systemd-activated socket served instead of
+	// binding addr (activation.go).
+	socket string
 
 	mu       sync.Mutex
 	pcs      []*net.UDPConn
@@ -58,6 +64,9 @@ func (l *udpListener) Addr() string   { return l.addr }
 func (l *udpListener) Critical() bool { return true }
 func (l *udpListener) Serving() bool  { return l.serving.Load() }
 
+// Policy returns the [[listeners]] entry served, nil on a bind address.
+func (l *udpListener) Policy() *middleware.ListenerPolicy { return l.policy }
+
 // Quiesced reports whether the engine holds no in-flight work.
 func (l *udpListener) Quiesced() bool {
 	l.mu.Lock()
@@ -89,6 +98,9 @@ func (l *udpListener) Bind(ctx context.Context) error {
 	if l.engine != nil {
 		return errors.New("udp listener: Bind called twice")
 	}
+	if pcs := inheritedPacketConns(l.socket); len(pcs) > 0 {
+		return l.adopt(pcs)
+	}
 
 	wildcard := bindWildcard(l.addr)
 	control := reusePortControl
@@ -130,6 +142,49 @@ func (l *udpListener) Bind(ctx context.Context) error {
 	}
 
 	l.engine = newUDPEngine(l.handler, l.pcs, wildcard, l.workers, l.queue, l.plan)
+	l.engine.listener = l.policy
+	l.engine.queries = listenerQueryCounter("udp", l.policy)
+	l.done = make(chan struct{})
+	return nil
+}
+
+// adopt serves the sockets systemd opened, one reader each. A wildcard
+// socket gets the pktinfo options its bind would have set.
+func (l *udpListener) adopt(pcs []net.PacketConn) error {
+	wildcard := false
+	for _, pc := range pcs {
+		udpConn, ok := pc.(*net.UDPConn)
+		if !ok {
+			for _, open := range pcs {
+				_ = open.Close()
+			}
+			l.pcs = nil
+			return errors.New("udp listener: activated socket is not UDP")
+		}
+		l.pcs = append(l.pcs, udpConn)
+		if bindWildcard(pc.LocalAddr().String()) {
+			wildcard = true
+		}
+	}
+	if wildcard {
+		for _, pc := range l.pcs {
+			err := errors.New("udp listener: activated socket has no descriptor")
+			if rc, rcErr := pc.SyscallConn(); rcErr == nil {
+				err = pktinfoControl("udp")("udp", pc.LocalAddr().String(), rc)
+			}
+			if err != nil {
+				for _, open := range pcs {
+					_ = open.Close()
+				}
+				l.pcs = nil
+				return err
+			}
+		}
+	}
+
+	l.engine = newUDPEngine(l.handler, l.pcs, wildcard, l.workers, l.queue, l.plan)
+	l.engine.listener = l.policy
+	l.engine.queries = listenerQueryCounter("udp", l.policy)
 	l.done = make(chan struct{})
 	return nil
 }
@@ -170,7 +225,7 @@ func (l *udpListener) Serve(_ context.Context) error {
 		engine.readers.Wait()
 		if !l.closing.Load() {
 			zlog.Error("UDP readers exited outside shutdown", "addr", l.addr)
-			recordListenerErr("udp")
+			recordListenerErr("udp", l.policy)
 		}
 	}()
 
@@ -230,6 +285,17 @@ func (l *udpListener) Shutdown(_ context.Context) error {
 	return l.drainErr
 }
 
+// passSockets hands the bound sockets to an upgrade.
+func (l *udpListener) passSockets() []PassedSocket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []PassedSocket
+	for _, pc := range l.pcs {
+		out = append(out, passSocket(l.socket, pc)...)
+	}
+	return out
+}
+
 // TrimIdleMemory drops the engine's parked slabs (see trim.go).
 func (l *udpListener) TrimIdleMemory() int {
 	l.mu.Lock()
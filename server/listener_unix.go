@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/semihalev/zlog/v2"
+)
+
+// unixListener runs the owned TCP engine over a unix stream socket, for
+// local clients that would rather not go through the network stack:
+// sidecars, stub resolvers sharing a pod or host. Messages carry the
+// two-byte length prefix of DNS over TCP. Non-critical — the socket is
+// served besides the network listeners, never instead of them.
+type unixListener struct {
+	path     string
+	mode     os.FileMode
+	handler  rawHandler
+	maxConns int
+	plan     resourcePlan
+	timeout  time.Duration
+	// socket names the systemd-activated socket served instead of
+	// creating path (activation.go).
+	socket string
+
+	mu       sync.Mutex
+	ln       net.Listener
+	engine   *tcpEngine
+	done     chan struct{}
+	shutdown sync.Once
+	closing  atomic.Bool
+	drainErr error
+	serving  atomic.Bool
+}
+
+func newUnixListener(path string, mode os.FileMode, h rawHandler, timeout time.Duration, maxConns int, plan resourcePlan) *unixListener {
+	return &unixListener{path: path, mode: mode, handler: h, timeout: timeout, maxConns: maxConns, plan: plan}
+}
+
+func (l *unixListener) Proto() string  { return "unix" }
+func (l *unixListener) Addr() string   { return l.path }
+func (l *unixListener) Critical() bool { return false }
+func (l *unixListener) Serving() bool  { return l.serving.Load() }
+
+// Quiesced reports whether the engine holds no in-flight work.
+func (l *unixListener) Quiesced() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.engine == nil || l.engine.quiesced()
+}
+
+func (l *unixListener) Bind(_ context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ln != nil {
+		return errors.New("unix listener: Bind called twice")
+	}
+	ln := inheritedListener(l.socket)
+	if ln == nil {
+		var err error
+		if ln, err = listenUnix(l.path, l.mode); err != nil {
+			return err
+		}
+	}
+	l.ln = loopbackListener{ln}
+	l.engine = newTCPEngine(l.handler, "unix", l.maxConns, l.plan)
+	l.engine.queries = listenerQueryCounter("unix", nil)
+	l.done = make(chan struct{})
+	return nil
+}
+
+func (l *unixListener) Serve(_ context.Context) error {
+	l.mu.Lock()
+	ln, engine, done := l.ln, l.engine, l.done
+	l.mu.Unlock()
+	if ln == nil {
+		return errListenerNotBound
+	}
+
+	zlog.Info("DNS server listening", "net", "unix", "addr", l.path,
+		"maxconns", engine.maxConns, "smalljobs", cap(engine.smallTokens), "largejobs", cap(engine.largeTokens))
+	l.serving.Store(true)
+	defer l.serving.Store(false)
+
+	if !engine.startAccepting(ln, func() {
+		if !l.closing.Load() {
+			zlog.Error("Unix accept loop exited outside shutdown", "addr", l.path)
+			recordListenerErr("unix", nil)
+		}
+	}) {
+		<-done
+		return l.drainErr
+	}
+
+	<-done
+	return l.drainErr
+}
+
+// Shutdown closes the socket, which for one the listener created also
+// removes its file, and drains the engine.
+func (l *unixListener) Shutdown(_ context.Context) error {
+	l.mu.Lock()
+	ln, engine := l.ln, l.engine
+	l.mu.Unlock()
+	if ln == nil {
+		return nil
+	}
+
+	l.shutdown.Do(func() {
+		timeout := l.timeout
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+		zlog.Info("DNS server stopping", "net", "unix", "addr", l.path)
+
+		l.closing.Store(true)
+		if err := ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			l.drainErr = errors.Join(l.drainErr, err)
+		}
+		if err := engine.shutdown(time.Now().Add(timeout)); err != nil {
+			l.drainErr = errors.Join(l.drainErr, err)
+		}
+		close(l.done)
+	})
+	return l.drainErr
+}
+
+// TrimIdleMemory drops the engine's parked slabs (see trim.go).
+func (l *unixListener) TrimIdleMemory() int {
+	l.mu.Lock()
+	engine := l.engine
+	l.mu.Unlock()
+	if engine == nil {
+		return 0
+	}
+	return engine.trimIdle()
+}
+
+// passSockets hands the bound socket to an upgrade.
+func (l *unixListener) passSockets() []PassedSocket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ln, ok := l.ln.(loopbackListener); ok {
+		return passSocket(l.socket, ln.Listener)
+	}
+	return nil
+}
+
+// listenUnix listens on path with the given mode. A socket file left by
+// a run that did not shut down is replaced; any other file in the way is
+// an error.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// unixPeer is the address a unix socket client queries from. A unix
+// peer has no IP for the access list, rate limiter or views to key on,
+// and it is on this host: it is served as loopback, over TCP.
+var unixPeer = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+// loopbackListener hands out its connections as loopback TCP clients.
+type loopbackListener struct{ net.Listener }
+
+func (l loopbackListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &loopbackConn{conn}, nil
+}
+
+type loopbackConn struct{ net.Conn }
+
+func (c *loopbackConn) RemoteAddr() net.Addr { return unixPeer }
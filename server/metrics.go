@@ -3,31 +3,68 @@ package server
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/semihalev/sdns/internal/metric"
+	"github.com/semihalev/sdns/middleware"
 )
 
+// defaultListener is the listener label of the bind/bindtls/binddoh/
+// binddoq/bindunix/binddnscrypt listeners; a [[listeners]] entry is
+// labelled with its name.
+const defaultListener = "default"
+
 // listenerErrors counts listener Serve loops that exited with a
-// non-nil error. Bumped per protocol so operators can tell whether
-// UDP is degrading independently from TLS or DoH. Bounded label set
-// — the value is l.Proto() which is one of udp/tcp/tls/doh/doh3/doq.
+// non-nil error. Bumped per protocol and listener so operators can
+// tell whether UDP is degrading independently from TLS or DoH, and
+// the LAN endpoint independently from the VPN one. Bounded label set
+// — proto is l.Proto(), one of udp/tcp/tls/doh/doh3/doq/unix/dnscrypt, and listener
+// is "default" or a configured [[listeners]] name.
 var (
 	listenerErrors = metric.NewCounterVec(nil, prometheus.CounterOpts{
 		Name: "dns_listener_errors_total",
-		Help: "Listener Serve loops that exited with an error, by transport",
-	}, []string{"proto"})
-
-	listenerErrUDP  = listenerErrors.Register("udp")
-	listenerErrTCP  = listenerErrors.Register("tcp")
-	listenerErrTLS  = listenerErrors.Register("tls")
-	listenerErrDoH  = listenerErrors.Register("doh")
-	listenerErrDoH3 = listenerErrors.Register("doh3")
-	listenerErrDoQ  = listenerErrors.Register("doq")
+		Help: "Listener Serve loops that exited with an error, by transport and listener",
+	}, []string{"proto", "listener"})
+
+	listenerErrUDP  = listenerErrors.Register("udp", defaultListener)
+	listenerErrTCP  = listenerErrors.Register("tcp", defaultListener)
+	listenerErrTLS  = listenerErrors.Register("tls", defaultListener)
+	listenerErrDoH  = listenerErrors.Register("doh", defaultListener)
+	listenerErrDoH3 = listenerErrors.Register("doh3", defaultListener)
+	listenerErrDoQ  = listenerErrors.Register("doq", defaultListener)
+	listenerErrUnix = listenerErrors.Register("unix", defaultListener)
+
+	listenerErrDNSCrypt = listenerErrors.Register("dnscrypt", defaultListener)
+
+	// listenerQueries counts the queries each listener accepted, before
+	// any middleware has seen them. Same closed label set as above.
+	listenerQueries = metric.NewCounterVec(nil, prometheus.CounterOpts{
+		Name: "dns_listener_queries_total",
+		Help: "DNS queries accepted by each listener, by transport and listener",
+	}, []string{"proto", "listener"})
 )
 
+// listenerLabel is the listener label of the listener serving p.
+func listenerLabel(p *middleware.ListenerPolicy) string {
+	if p == nil {
+		return defaultListener
+	}
+	return p.Name
+}
+
+// listenerQueryCounter resolves a listener's query counter once, at
+// construction, so the engines count with a plain Inc.
+func listenerQueryCounter(proto string, p *middleware.ListenerPolicy) *metric.Counter {
+	return listenerQueries.Register(proto, listenerLabel(p))
+}
+
 // recordListenerErr maps a Listener.Proto() string to the matching
-// pre-resolved counter. Unknown protocols fall through to the cold
-// WithLabelValues path so the metric stays correct even if a new
-// listener type lands without a corresponding handle here.
-func recordListenerErr(proto string) {
+// pre-resolved counter. A [[listeners]] entry, and unknown protocols,
+// fall through to the cold WithLabelValues path so the metric stays
+// correct even if a new listener type lands without a corresponding
+// handle here.
+func recordListenerErr(proto string, p *middleware.ListenerPolicy) {
+	if p != nil {
+		listenerErrors.WithLabelValues(proto, p.Name).Inc()
+		return
+	}
 	switch proto {
 	case "udp":
 		listenerErrUDP.Inc()
@@ -41,7 +78,11 @@ func recordListenerErr(proto string) {
 		listenerErrDoH3.Inc()
 	case "doq":
 		listenerErrDoQ.Inc()
+	case "unix":
+		listenerErrUnix.Inc()
+	case "dnscrypt":
+		listenerErrDNSCrypt.Inc()
 	default:
-		listenerErrors.WithLabelValues(proto).Inc()
+		listenerErrors.WithLabelValues(proto, defaultListener).Inc()
 	}
 }
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/semihalev/sdns/internal/odoh"
+	"github.com/semihalev/sdns/server/doh"
+)
+
+// odohTarget returns the key the DoH listeners serve as an Oblivious
+// DoH target with, read from the working directory — and created there
+// on first use, so the configs clients hold stay good across restarts.
+func (s *Server) odohTarget() (*odoh.KeyPair, error) {
+	if s.cfg.Directory != "" {
+		if err := os.MkdirAll(s.cfg.Directory, 0700); err != nil {
+			return nil, err
+		}
+	}
+	key, err := loadOrCreateKey(filepath.Join(s.cfg.Directory, "odoh.key"), func() (*ecdh.PrivateKey, error) {
+		return ecdh.X25519().GenerateKey(rand.Reader)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("odoh target key: %w", err)
+	}
+	return odoh.NewKeyPair(key)
+}
+
+// odohRelay returns the Oblivious DoH relay to the targets configured,
+// each exchange bounded by timeout.
+func (s *Server) odohRelay(timeout time.Duration) *doh.Relay {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	return &doh.Relay{
+		Targets: s.cfg.ODoHRelayTargets,
+		Client: &http.Client{
+			Transport: transport,
+			// A redirect would take the query to a host not listed.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		Timeout: timeout,
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/internal/odoh"
+	"github.com/semihalev/sdns/middleware"
+	"github.com/semihalev/sdns/middleware/accesslist"
+	"github.com/semihalev/sdns/server/doh"
+)
+
+func TestODoHTarget(t *testing.T) {
+	cfg := &config.Config{
+		BindDOH:      "127.0.0.1:443",
+		Directory:    filepath.Join(t.TempDir(), "db"),
+		QueryTimeout: config.Duration{Duration: time.Second},
+	}
+	handler := middleware.HandlerFunc(func(ctx context.Context, ch *middleware.Chain) {
+		resp := new(dns.Msg)
+		resp.SetReply(ch.Request.Msg())
+		rr, _ := dns.NewRR("oblivious.example. 60 IN A 192.0.2.1")
+		resp.Answer = append(resp.Answer, rr)
+		_ = ch.Writer.WriteMsg(resp)
+		ch.Cancel()
+	})
+	s := serverWithHandler(cfg, handler)
+	target, err := s.odohTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.oblivious = doh.NewTarget(target)
+
+	// The key outlives the process, and with it the configs.
+	again, err := s.odohTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Config().PublicKey, target.Config().PublicKey) {
+		t.Error("target key changed across loads")
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, odoh.ConfigsPath, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("configs status = %d, want 200", w.Code)
+	}
+	configs, err := odoh.ParseConfigs(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("oblivious.example.", dns.TypeA)
+	buf, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, qc, err := odoh.EncryptQuery(configs[0], buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/dns-query", bytes.NewReader(sealed))
+	req.Header.Set("Content-Type", odoh.ContentType)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("query status = %d, want 200", w.Code)
+	}
+	buf, err = qc.DecryptResponse(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answer) != 1 || resp.Id != q.Id {
+		t.Errorf("response = %v", resp)
+	}
+
+	// Without the target, the same request is a DoH one of the wrong type.
+	s.oblivious = nil
+	req = httptest.NewRequest(http.MethodPost, "/dns-query", bytes.NewReader(sealed))
+	req.Header.Set("Content-Type", odoh.ContentType)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status without target = %d, want 415", w.Code)
+	}
+}
+
+// A relayed query never reaches the chain, so the listener's access list
+// has to be asked before it goes on: a client outside it is refused.
+func TestODoHRelayAccessList(t *testing.T) {
+	cfg := &config.Config{
+		Listeners: []config.ListenerConfig{
+			{Name: "internal", Proto: "doh", Addr: "127.0.0.1:8443", AccessList: []string{"10.0.0.0/8"}},
+		},
+		ODoHRelayTargets: []string{"127.0.0.1:1"},
+	}
+	registry := middleware.NewRegistry()
+	registry.Register("accesslist", func(cfg *config.Config) middleware.Handler { return accesslist.New(cfg) })
+	s := &Server{cfg: cfg, pipeline: registry.Build(cfg)}
+	for _, h := range s.pipeline.Handlers() {
+		if g, ok := h.(middleware.ClientGate); ok {
+			s.gates = append(s.gates, g)
+		}
+	}
+	s.relay = s.odohRelay(time.Second)
+	e := s.endpoint("doh", "127.0.0.1:8443", middleware.NewListenerPolicies(cfg)[0])
+
+	relayed := func(remote string) int {
+		q := url.Values{"targethost": {"127.0.0.1:1"}, "targetpath": {"/dns-query"}}
+		r := httptest.NewRequest(http.MethodPost, "/proxy?"+q.Encode(), bytes.NewReader([]byte{1}))
+		r.Header.Set("Content-Type", odoh.ContentType)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := relayed("192.0.2.7:5000"); code != http.StatusForbidden {
+		t.Errorf("denied client: status = %d, want 403", code)
+	}
+	// An allowed client gets as far as the target, which is not there.
+	if code := relayed("10.1.2.3:5000"); code != http.StatusBadGateway {
+		t.Errorf("allowed client: status = %d, want 502", code)
+	}
+}
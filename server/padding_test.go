@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/internal/dnsutil"
+)
+
+// dotStrictTestJob is a DoT job: a TCP remote that says it is encrypted,
+// as tcpJob does on a TLS engine.
+type dotStrictTestJob struct{ tcpStrictTestJob }
+
+func (j *dotStrictTestJob) Encrypted() bool { return true }
+
+func packPaddedQuery(t *testing.T, name string) []byte {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	m.SetEdns0(1232, false)
+	dnsutil.Pad(m, dnsutil.QueryPaddingBlock)
+	raw, err := m.Pack()
+	if err != nil {
+		t.Fatalf("pack: %v", err)
+	}
+	return raw
+}
+
+// TestPaddingFollowsTheTransport is RFC 8467 through the full default
+// chain on the byte path: a DoT client that padded its query gets a reply
+// padded to the 468-octet block, and plain TCP never does.
+func TestPaddingFollowsTheTransport(t *testing.T) {
+	s := newRawTestServer(t)
+	padded := func(wrote []byte) bool {
+		r := new(dns.Msg)
+		if err := r.Unpack(wrote); err != nil {
+			t.Fatalf("reply unpack: %v", err)
+		}
+		if opt := r.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				if _, ok := o.(*dns.EDNS0_PADDING); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	dot := &dotStrictTestJob{tcpStrictTestJob{remoteTCP: net.TCPAddr{IP: net.IPv4(203, 0, 113, 9), Port: 4721}}}
+	if !s.ServeRaw(dot, packPaddedQuery(t, "pad.example."), time.Now()) {
+		t.Fatal("eligible packet not handled")
+	}
+	if !padded(dot.wrote) || len(dot.wrote)%dnsutil.ResponsePaddingBlock != 0 {
+		t.Fatalf("DoT reply of %d octets, padded %v", len(dot.wrote), padded(dot.wrote))
+	}
+
+	tcp := &tcpStrictTestJob{remoteTCP: net.TCPAddr{IP: net.IPv4(203, 0, 113, 9), Port: 4722}}
+	if !s.ServeRaw(tcp, packPaddedQuery(t, "pad.example."), time.Now()) {
+		t.Fatal("eligible packet not handled")
+	}
+	if padded(tcp.wrote) {
+		t.Fatal("plain TCP reply padded")
+	}
+}
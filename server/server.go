@@ -4,8 +4,11 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,9 +17,14 @@ import (
 
 	"github.com/semihalev/sdns/config"
 	"github.com/semihalev/sdns/internal/contextutil"
+	"github.com/semihalev/sdns/internal/metric"
 	"github.com/semihalev/sdns/internal/mock"
+	"github.com/semihalev/sdns/internal/odoh"
+	"github.com/semihalev/sdns/internal/tracing"
 	"github.com/semihalev/sdns/middleware"
+	"github.com/semihalev/sdns/server/dnscrypt"
 	"github.com/semihalev/sdns/server/doh"
+	"github.com/semihalev/sdns/server/doq"
 	"github.com/semihalev/zlog/v2"
 )
 
@@ -32,6 +40,23 @@ type Server struct {
 
 	certManager *CertManager
 	certMu      sync.Mutex
+	// listenerCerts are the certificates of [[listeners]] entries that
+	// have their own, keyed by certificate and key path. Guarded by
+	// certMu, created lazily like certManager.
+	listenerCerts map[[2]string]*CertManager
+
+	// provider is the DNSCrypt provider, loaded by the first dnscrypt
+	// listener to bind. Guarded by providerMu.
+	provider   *dnscrypt.Provider
+	providerMu sync.Mutex
+
+	// oblivious is the Oblivious DoH target the DoH listeners serve as,
+	// and relay the ODoH relay they serve; each nil when off.
+	oblivious *doh.Target
+	relay     *doh.Relay
+	// gates are the pipeline's client gates, asked about the client of a
+	// request that is relayed rather than answered.
+	gates []middleware.ClientGate
 
 	listenersMu sync.Mutex
 	listeners   []Listener
@@ -42,6 +67,9 @@ type Server struct {
 	// accepting, while the socket it was handed is closed a few
 	// statements later, by the supervisor.
 	shutdownDone chan struct{}
+	// hold keeps the listeners of a process started for an upgrade from
+	// reading their sockets until StartServing closes it; nil otherwise.
+	hold chan struct{}
 	// trimDone closes when the opt-in trimmer goroutine has exited; nil
 	// when it was never started. Stopped waits on it — a trim is a
 	// process-wide collection, and "stopped" must not be true while one
@@ -59,8 +87,16 @@ type Server struct {
 	served      atomic.Uint64
 	trimEnabled bool
 
+	// devices are the device IDs clients may name themselves by, nil
+	// when none are configured.
+	devices *devices
+
 	running atomic.Int32
 
+	// draining is set by Drain: the server is on its way out of service
+	// and reports itself not ready.
+	draining atomic.Bool
+
 	// certStopped marks the certificate provider closed: Stop has run,
 	// and no caller may lazily create a new manager (and its watcher).
 	// Guarded by certMu.
@@ -69,16 +105,18 @@ type Server struct {
 
 // New return new server.
 func New(cfg *config.Config) *Server {
-	if cfg.Bind == "" {
+	if cfg.Bind == "" && len(cfg.Listeners) == 0 {
 		cfg.Bind = ":53"
 	}
 
-	s := &Server{cfg: cfg, pipeline: middleware.GlobalPipeline(), trimEnabled: cfg.MemoryTrim}
+	s := &Server{cfg: cfg, pipeline: middleware.GlobalPipeline(), trimEnabled: cfg.MemoryTrim, devices: newDevices(cfg)}
 	if s.pipeline != nil {
 		for _, h := range s.pipeline.Handlers() {
 			if b, ok := h.(middleware.InlineBarrier); ok && b.InlineBarrier() {
 				s.inlineReady = true
-				break
+			}
+			if g, ok := h.(middleware.ClientGate); ok {
+				s.gates = append(s.gates, g)
 			}
 		}
 	}
@@ -87,38 +125,170 @@ func New(cfg *config.Config) *Server {
 	// the stream engines share a budget, so the plan has to know how
 	// many there are. The plan is a value on this Server — a second
 	// Server in the same process lives inside its own arithmetic.
-	engines := 1
+	engines := 0
+	if cfg.Bind != "" {
+		engines++
+	}
 	if cfg.BindTLS != "" {
-		engines = 2
+		engines++
+	}
+	if cfg.BindUnix != "" {
+		engines++
+	}
+	for _, lc := range cfg.Listeners {
+		switch lc.Proto {
+		case "dns", "tcp", "tls":
+			engines++
+		}
 	}
 	plan := defaultResourcePlan(engines)
 	plan.publish()
 
 	timeout := cfg.QueryTimeout.Duration
+	if cfg.ODoH {
+		// The DoH listeners serve plain DoH without it; a key that will
+		// not load costs the target, not the server.
+		target, err := s.odohTarget()
+		if err != nil {
+			zlog.Error("Oblivious DoH target disabled", "error", err.Error())
+		} else {
+			s.oblivious = doh.NewTarget(target)
+		}
+	}
+	if len(cfg.ODoHRelayTargets) > 0 {
+		s.relay = s.odohRelay(timeout)
+	}
+
 	// The owned transports feed ServeRaw: raw bytes in, and the server —
 	// not the transport — decides eligibility, decode, and context. DoH
 	// and DoQ enter through ServeMsg with a decoded message — one reshapes
 	// bytes and the other rewrites the reply ID, so neither is a raw sink.
-	s.listeners = []Listener{
-		newUDPListener(cfg.Bind, s, timeout, cfg.IngressWorkers, cfg.IngressQueue, plan),
-		newTCPListener(cfg.Bind, s, timeout, cfg.IngressTCPConns, plan),
+	if cfg.Bind != "" {
+		udp := newUDPListener(cfg.Bind, s, timeout, cfg.IngressWorkers, cfg.IngressQueue, plan)
+		udp.socket = socketDNS
+		tcp := newTCPListener(cfg.Bind, s, timeout, cfg.IngressTCPConns, plan)
+		tcp.socket = socketDNS
+		s.listeners = append(s.listeners, udp, tcp)
+	}
+	if cfg.BindUnix != "" {
+		// Validated with the config; a bad mode cannot get here.
+		mode, _ := cfg.UnixMode()
+		l := newUnixListener(cfg.BindUnix, mode, s, timeout, cfg.IngressTCPConns, plan)
+		l.socket = socketUnix
+		s.listeners = append(s.listeners, l)
+	}
+	var certs certProvider = s
+	if cfg.BindTLS != "" || cfg.BindDOH != "" || cfg.BindDOQ != "" {
+		certs = withDevices(withClientAuth(s, cfg.TLSClientCA, cfg.TLSClientCertRequired), s.devices)
 	}
 	if cfg.BindTLS != "" {
-		s.listeners = append(s.listeners, newTLSListener(cfg.BindTLS, s, s, timeout, cfg.IngressTCPConns, plan))
+		l := newTLSListener(cfg.BindTLS, s, certs, timeout, cfg.IngressTCPConns, plan)
+		l.devices = s.devices
+		l.socket = socketTLS
+		s.listeners = append(s.listeners, l)
 	}
 	if cfg.BindDOH != "" {
-		s.listeners = append(s.listeners,
-			newDOHListener(cfg.BindDOH, s, s, timeout),
-			newDOH3Listener(cfg.BindDOH, s, s),
-		)
+		doh := newDOHListener(cfg.BindDOH, s.endpoint("doh", cfg.BindDOH, nil), certs, timeout)
+		doh.socket = socketDoH
+		doh3 := newDOH3Listener(cfg.BindDOH, s.endpoint("doh3", cfg.BindDOH, nil), certs)
+		doh3.socket = socketDoH
+		s.listeners = append(s.listeners, doh, doh3)
 	}
 	if cfg.BindDOQ != "" {
-		s.listeners = append(s.listeners, newDOQListener(cfg.BindDOQ, s, s))
+		l := newDOQListener(cfg.BindDOQ, s.endpoint("doq", cfg.BindDOQ, nil), certs)
+		l.socket = socketDoQ
+		s.listeners = append(s.listeners, l)
+	}
+	if cfg.BindDNSCrypt != "" {
+		l := newDNSCryptListener(cfg.BindDNSCrypt, s.endpoint("dnscrypt", cfg.BindDNSCrypt, nil), s.dnscryptProvider, s.dnscryptProps())
+		l.socket = socketDNSCrypt
+		s.listeners = append(s.listeners, l)
+	}
+
+	for i, policy := range middleware.NewListenerPolicies(cfg) {
+		s.listeners = append(s.listeners, s.newListeners(cfg.Listeners[i], policy, timeout, plan)...)
 	}
 
 	return s
 }
 
+// newListeners builds the listeners of one [[listeners]] entry, each
+// carrying its policy. Its plain DNS listeners are as critical as the
+// bind address's; the encrypted ones, as optional.
+func (s *Server) newListeners(lc config.ListenerConfig, policy *middleware.ListenerPolicy,
+	timeout time.Duration, plan resourcePlan) []Listener {
+	var certs certProvider = s
+	if lc.TLSCertificate != "" {
+		certs = listenerCerts{s: s, cert: lc.TLSCertificate, key: lc.TLSPrivateKey}
+	}
+	switch lc.Proto {
+	case "tls", "doh", "doq":
+		// The entry's client CA replaces the global one; without one, it
+		// may still demand the global CA's certificates.
+		if lc.TLSClientCA != "" {
+			certs = withClientAuth(certs, lc.TLSClientCA, lc.TLSClientCertRequired)
+		} else {
+			certs = withClientAuth(certs, s.cfg.TLSClientCA, s.cfg.TLSClientCertRequired || lc.TLSClientCertRequired)
+		}
+		certs = withDevices(certs, s.devices)
+	}
+
+	// An entry's sockets go by its name; an unnamed one's, which no
+	// socket unit can name, by its place, for an upgrade to hand over.
+	socket := lc.Name
+	if socket == "" || strings.Contains(socket, ":") {
+		socket = "listener" + strconv.Itoa(policy.Index)
+	}
+
+	newUDP := func() Listener {
+		l := newUDPListener(lc.Addr, s, timeout, s.cfg.IngressWorkers, s.cfg.IngressQueue, plan)
+		l.policy = policy
+		l.socket = socket
+		return l
+	}
+	newTCP := func() Listener {
+		l := newTCPListener(lc.Addr, s, timeout, s.cfg.IngressTCPConns, plan)
+		l.policy = policy
+		l.socket = socket
+		return l
+	}
+
+	switch lc.Proto {
+	case "dns":
+		return []Listener{newUDP(), newTCP()}
+	case "udp":
+		return []Listener{newUDP()}
+	case "tcp":
+		return []Listener{newTCP()}
+	case "tls":
+		l := newTLSListener(lc.Addr, s, certs, timeout, s.cfg.IngressTCPConns, plan)
+		l.policy = policy
+		l.devices = s.devices
+		l.socket = socket
+		return []Listener{l}
+	case "doh":
+		doh := newDOHListener(lc.Addr, s.endpoint("doh", lc.Addr, policy), certs, timeout)
+		doh.policy = policy
+		doh.socket = socket
+		doh3 := newDOH3Listener(lc.Addr, s.endpoint("doh3", lc.Addr, policy), certs)
+		doh3.policy = policy
+		doh3.socket = socket
+		return []Listener{doh, doh3}
+	case "doq":
+		l := newDOQListener(lc.Addr, s.endpoint("doq", lc.Addr, policy), certs)
+		l.policy = policy
+		l.socket = socket
+		return []Listener{l}
+	case "dnscrypt":
+		l := newDNSCryptListener(lc.Addr, s.endpoint("dnscrypt", lc.Addr, policy), s.dnscryptProvider, s.dnscryptProps())
+		l.policy = policy
+		l.socket = socket
+		return []Listener{l}
+	}
+	zlog.Error("Listener has an unknown proto, skipped", "listener", policy.Name, "proto", lc.Proto)
+	return nil
+}
+
 // ServeMsg serves one decoded DNS request under the transport's lifetime
 // and the configured end-to-end middleware/resolution timeout. It is the
 // entry for transports that already hold a message — DNS-over-HTTP and
@@ -208,17 +378,58 @@ func (s *Server) serveMsgBy(
 
 // ServeHTTP implements http.Handler (DoH + DoH3).
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint{s: s, addr: s.cfg.BindDOH}.ServeHTTP(w, r)
+}
+
+// endpoint is the handler a DoH, DoH3, DoQ or DNSCrypt listener serves
+// through: it counts the listener's queries and stamps them with its
+// policy and the client's identity before they enter ServeMsg. The owned
+// UDP, TCP and DoT engines do the same from their jobs.
+type endpoint struct {
+	s       *Server
+	addr    string
+	policy  *middleware.ListenerPolicy
+	queries *metric.Counter
+}
+
+func (s *Server) endpoint(proto, addr string, policy *middleware.ListenerPolicy) endpoint {
+	return endpoint{s: s, addr: addr, policy: policy, queries: listenerQueryCounter(proto, policy)}
+}
+
+// ServeHTTP implements http.Handler for DoH and DoH3.
+func (e endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Server", "sdns")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	if r.ProtoMajor < 3 {
-		_, port, _ := net.SplitHostPort(s.cfg.BindDOH)
+		_, port, _ := net.SplitHostPort(e.addr)
 		w.Header().Set("Alt-Svc", `h3=":`+port+`"; ma=2592000`)
 	}
 
+	// Oblivious DoH: the target's configs, and queries to relay, are
+	// nobody's device and no query of ours. A relayed query never meets
+	// the chain, so its client is put to the chain's gates here.
+	if e.s.oblivious != nil && r.URL.Path == odoh.ConfigsPath {
+		e.s.oblivious.ServeConfigs(w, r)
+		return
+	}
+	if e.s.relay != nil && doh.IsOblivious(r) && doh.IsRelayed(r) {
+		if !e.admit(r) {
+			doh.WriteError(w, http.StatusForbidden)
+			return
+		}
+		e.s.relay.ServeHTTP(w, r)
+		return
+	}
+
+	identity, ok := e.s.devices.identify(r.TLS, r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
 	handle := func(req *dns.Msg) *dns.Msg {
 		mw := mock.NewWriter("doh", r.RemoteAddr)
-		s.ServeMsg(r.Context(), mw, req)
+		e.serve(tracing.Extract(r.Context(), r.Header), mw, req, identity)
 		if !mw.Written() {
 			return nil
 		}
@@ -226,19 +437,91 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var handlerFn func(http.ResponseWriter, *http.Request)
-	if r.Method == http.MethodGet && r.URL.Query().Get("dns") == "" {
+	switch {
+	case e.s.oblivious != nil && doh.IsOblivious(r):
+		e.s.oblivious.ServeQuery(w, r, handle)
+		return
+	case r.Method == http.MethodGet && r.URL.Query().Get("dns") == "":
 		handlerFn = doh.HandleJSON(handle)
-	} else {
+	default:
 		handlerFn = doh.HandleWireFormat(handle)
 	}
 	handlerFn(w, r)
 }
 
+// admit reports whether every client gate lets the client of r use
+// this listener, as a query of its would be asked in the chain.
+func (e endpoint) admit(r *http.Request) bool {
+	if len(e.s.gates) == 0 {
+		return true
+	}
+	var ip net.IP
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = net.ParseIP(host)
+	}
+	identity, _ := e.s.devices.identify(r.TLS, "")
+	for _, g := range e.s.gates {
+		if !g.AdmitClient(ip, identity, e.policy) {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeMsg implements doq.Handler and dnscrypt.Handler.
+func (e endpoint) ServeMsg(ctx context.Context, w middleware.Transport, r *dns.Msg) {
+	var identity string
+	if d, ok := w.(*doq.ResponseWriter); ok && d.Conn != nil {
+		state := d.Conn.ConnectionState().TLS
+		identity, _ = e.s.devices.identify(&state, "")
+	}
+	e.serve(ctx, w, r, identity)
+}
+
+func (e endpoint) serve(ctx context.Context, w middleware.Transport, r *dns.Msg, identity string) {
+	if e.queries != nil {
+		e.queries.Inc()
+	}
+	if e.policy != nil || identity != "" {
+		w = policyTransport{Transport: w, policy: e.policy, identity: identity}
+	}
+	e.s.ServeMsg(ctx, w, r)
+}
+
+// policyTransport stamps a DoH or DoQ writer with its listener's policy
+// and its client's identity, keeping the protocol name the writer
+// reports.
+type policyTransport struct {
+	middleware.Transport
+	policy   *middleware.ListenerPolicy
+	identity string
+}
+
+func (t policyTransport) Proto() string {
+	if p, ok := t.Transport.(interface{ Proto() string }); ok {
+		return p.Proto()
+	}
+	return ""
+}
+
+// Encrypted passes on the writer's own say, for a DNSCrypt one.
+func (t policyTransport) Encrypted() bool {
+	e, ok := t.Transport.(interface{ Encrypted() bool })
+	return ok && e.Encrypted()
+}
+
+func (t policyTransport) ListenerPolicy() *middleware.ListenerPolicy { return t.policy }
+
+func (t policyTransport) ClientIdentity() string { return t.identity }
+
 // Run binds every configured listener synchronously, returns a non-nil
 // error if a critical listener (plain DNS UDP/TCP) could not bind, and
 // otherwise spawns Serve goroutines that run until ctx is cancelled.
 // Run itself is non-blocking — main waits on ctx and polls Stopped
-// for graceful shutdown.
+// for graceful shutdown. In a process started for an upgrade the Serve
+// goroutines wait for StartServing: the process upgraded from still
+// reads the same sockets and answers as configured, so it stays their
+// only reader until this one can too.
 func (s *Server) Run(ctx context.Context) error {
 	s.listenersMu.Lock()
 	listeners := append([]Listener(nil), s.listeners...)
@@ -256,19 +539,33 @@ func (s *Server) Run(ctx context.Context) error {
 	}
 
 	done := make(chan struct{})
+	var hold chan struct{}
+	if upgrading() {
+		hold = make(chan struct{})
+	}
 	s.listenersMu.Lock()
 	s.active = active
 	s.shutdownDone = done
+	s.hold = hold
 	s.listenersMu.Unlock()
 
+	s.publishDDR(active)
+
 	for _, l := range active {
 		s.running.Add(1)
 		go func(l Listener) {
 			defer s.running.Add(-1)
+			if hold != nil {
+				select {
+				case <-hold:
+				case <-ctx.Done():
+					return
+				}
+			}
 			if err := l.Serve(ctx); err != nil {
-				recordListenerErr(l.Proto())
+				recordListenerErr(l.Proto(), policyOf(l))
 				zlog.Error("listener stopped with error",
-					"proto", l.Proto(), "addr", l.Addr(), "error", err.Error())
+					"proto", l.Proto(), "addr", l.Addr(), "listener", listenerLabel(policyOf(l)), "error", err.Error())
 			}
 		}(l)
 	}
@@ -288,6 +585,18 @@ func (s *Server) Run(ctx context.Context) error {
 	return nil
 }
 
+// StartServing lets the listeners of a process started for an upgrade
+// read their sockets. It does nothing in a process started otherwise,
+// whose listeners serve from Run on, or when called again.
+func (s *Server) StartServing() {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	if s.hold != nil {
+		close(s.hold)
+		s.hold = nil
+	}
+}
+
 func (s *Server) superviseShutdown(ctx context.Context, active []Listener, done chan struct{}) {
 	defer close(done)
 	<-ctx.Done()
@@ -359,6 +668,76 @@ func (s *Server) Quiesced() bool {
 	return true
 }
 
+// Ready reports whether the server is in service: Run has bound every
+// critical listener, each is serving, and neither Drain nor a shutdown
+// has begun.
+func (s *Server) Ready() bool {
+	if s.draining.Load() {
+		return false
+	}
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	if s.shutdownDone == nil {
+		return false
+	}
+	select {
+	case <-s.shutdownDone:
+		return false
+	default:
+	}
+	for _, l := range s.active {
+		if l.Critical() && !l.Serving() {
+			return false
+		}
+	}
+	return true
+}
+
+// Draining reports whether Drain has been called.
+func (s *Server) Draining() bool { return s.draining.Load() }
+
+// Drain takes the server out of service ahead of a stop. Ready turns
+// false at once, so a load balancer polling it stops sending traffic; the
+// connection-oriented listeners (TCP, DoT, DoH, DoH3, DoQ) stop accepting
+// and finish the queries already in hand, within the same deadline a
+// shutdown gets. UDP keeps answering whatever still arrives, since it has
+// no connections to refuse, until the server is stopped. Drain returns
+// once the listeners have drained; calling it again is harmless.
+func (s *Server) Drain(ctx context.Context) error {
+	if s.draining.CompareAndSwap(false, true) {
+		zlog.Info("Draining DNS server")
+	}
+
+	s.listenersMu.Lock()
+	active := append([]Listener(nil), s.active...)
+	s.listenersMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, s.shutdownTimeout())
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, l := range active {
+		if l.Proto() == "udp" {
+			continue
+		}
+		wg.Add(1)
+		go func(l Listener) {
+			defer wg.Done()
+			if err := l.Shutdown(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s %s: %w", l.Proto(), l.Addr(), err))
+				mu.Unlock()
+			}
+		}(l)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
 func (s *Server) HasListener(proto string) bool {
 	s.listenersMu.Lock()
 	defer s.listenersMu.Unlock()
@@ -388,11 +767,18 @@ func (s *Server) GetTLSConfig() *tls.Config {
 		return nil
 	}
 
-	if s.cfg.TLSCertificate == "" || s.cfg.TLSPrivateKey == "" {
+	var (
+		cm  *CertManager
+		err error
+	)
+	switch {
+	case s.cfg.ACME.Enabled:
+		cm, err = NewACMECertManager(s.cfg.ACME, s.cfg.Directory)
+	case s.cfg.TLSCertificate != "" && s.cfg.TLSPrivateKey != "":
+		cm, err = NewCertManager(s.cfg.TLSCertificate, s.cfg.TLSPrivateKey)
+	default:
 		return nil
 	}
-
-	cm, err := NewCertManager(s.cfg.TLSCertificate, s.cfg.TLSPrivateKey)
 	if err != nil {
 		zlog.Error("certificate manager init failed", "error", err.Error())
 		return nil
@@ -451,7 +837,7 @@ func (s *Server) Stopped() bool {
 	}
 }
 
-// Stop releases long-lived resources (currently just the cert manager).
+// Stop releases long-lived resources (currently just the cert managers).
 func (s *Server) Stop() {
 	s.certMu.Lock()
 	defer s.certMu.Unlock()
@@ -460,15 +846,76 @@ func (s *Server) Stop() {
 		s.certManager.Stop()
 		s.certManager = nil
 	}
+	for key, cm := range s.listenerCerts {
+		cm.Stop()
+		delete(s.listenerCerts, key)
+	}
 }
 
 // ReloadCertificate forces a certificate reload on all TLS listeners.
 func (s *Server) ReloadCertificate() error {
 	s.certMu.Lock()
 	defer s.certMu.Unlock()
-	if s.certManager == nil {
+	if s.certManager == nil && len(s.listenerCerts) == 0 {
 		return errors.New("no certificate manager configured")
 	}
 	zlog.Info("Reloading TLS certificate")
-	return s.certManager.Reload()
+	var errs []error
+	if s.certManager != nil {
+		errs = append(errs, s.certManager.Reload())
+	}
+	for key, cm := range s.listenerCerts {
+		if err := cm.Reload(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key[0], err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// listenerCerts is the certProvider of a [[listeners]] entry with a
+// certificate of its own. Entries naming the same files share one
+// CertManager.
+type listenerCerts struct {
+	s         *Server
+	cert, key string
+}
+
+func (c listenerCerts) GetTLSConfig() *tls.Config {
+	s := c.s
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	key := [2]string{c.cert, c.key}
+	if cm := s.listenerCerts[key]; cm != nil {
+		return cm.GetTLSConfig()
+	}
+	// Same rule as GetTLSConfig: nothing new after Stop.
+	if s.certStopped {
+		return nil
+	}
+
+	cm, err := NewCertManager(c.cert, c.key)
+	if err != nil {
+		zlog.Error("certificate manager init failed", "cert", c.cert, "error", err.Error())
+		return nil
+	}
+	if s.listenerCerts == nil {
+		s.listenerCerts = make(map[[2]string]*CertManager)
+	}
+	s.listenerCerts[key] = cm
+	return cm.GetTLSConfig()
+}
+
+// policyOf returns the [[listeners]] entry l serves, nil for a bind
+// address or a listener that does not say.
+func policyOf(l Listener) *middleware.ListenerPolicy {
+	if p, ok := l.(policyListener); ok {
+		return p.Policy()
+	}
+	return nil
+}
+
+// policyListener is a Listener that can serve a [[listeners]] entry.
+type policyListener interface {
+	Policy() *middleware.ListenerPolicy
 }
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"fmt"
+	"net"
 	"testing"
 	"time"
 
@@ -257,3 +258,75 @@ func TestServerRestartReleasesSockets(t *testing.T) {
 		s.Stop()
 	}
 }
+
+func TestServerDrain(t *testing.T) {
+	cfg := &config.Config{
+		Bind:         "127.0.0.1:0",
+		QueryTimeout: config.Duration{Duration: 2 * time.Second},
+	}
+	s := New(cfg)
+	if s.Ready() {
+		t.Fatal("server ready before Run")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var tcpAddr string
+	s.listenersMu.Lock()
+	for _, l := range s.active {
+		if l.Proto() == "tcp" {
+			tcpAddr = boundAddr(t, l)
+		}
+	}
+	s.listenersMu.Unlock()
+
+	if err := s.Drain(context.Background()); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if s.Ready() {
+		t.Error("server ready after Drain")
+	}
+	if !s.Draining() {
+		t.Error("Draining is false after Drain")
+	}
+	// Serve notices the closed socket on its own goroutine.
+	deadline = time.Now().Add(5 * time.Second)
+	for s.HasListener("tcp") {
+		if time.Now().After(deadline) {
+			t.Fatal("tcp listener still serving after Drain")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !s.HasListener("udp") {
+		t.Error("udp listener stopped by Drain")
+	}
+	if conn, err := net.DialTimeout("tcp", tcpAddr, time.Second); err == nil {
+		_ = conn.Close()
+		t.Errorf("tcp %s still accepting after Drain", tcpAddr)
+	}
+
+	// A second drain and the later shutdown are both harmless.
+	if err := s.Drain(context.Background()); err != nil {
+		t.Fatalf("second drain: %v", err)
+	}
+	cancel()
+	deadline = time.Now().Add(5 * time.Second)
+	for !s.Stopped() {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not stop within deadline")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
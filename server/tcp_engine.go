@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"net"
@@ -177,6 +178,21 @@ func (j *tcpJob) LocalAddr() net.Addr  { return j.conn.LocalAddr() }
 func (j *tcpJob) RemoteAddr() net.Addr { return j.conn.RemoteAddr() }
 func (j *tcpJob) Close() error         { return j.conn.Close() }
 
+// ListenerPolicy names the [[listeners]] entry the query arrived on.
+func (j *tcpJob) ListenerPolicy() *middleware.ListenerPolicy { return j.engine.listener }
+
+// Encrypted reports a DoT query.
+func (j *tcpJob) Encrypted() bool { return j.engine.proto == "tls" }
+
+// ClientIdentity names the DoT client by its verified certificate or its
+// device ID.
+func (j *tcpJob) ClientIdentity() string {
+	if j.stream == nil {
+		return ""
+	}
+	return j.stream.identity
+}
+
 // tcpEngine owns one listener's accept loop, connection registry, and
 // job ring.
 type tcpEngine struct {
@@ -184,6 +200,15 @@ type tcpEngine struct {
 	proto    string // "tcp" or "tls", for metrics
 	maxConns int64
 
+	// listener is the [[listeners]] entry this engine serves, nil on the
+	// bind addresses; queries counts what it was sent. Both are set by
+	// the listener before it starts accepting.
+	listener *middleware.ListenerPolicy
+	queries  *metric.Counter
+	// devices names DoT clients without a certificate by their server
+	// name; set like listener.
+	devices *devices
+
 	// slabRotor deals slab shards to acquisitions; connections have no
 	// stable index the way the UDP readers do.
 	slabRotor atomic.Uint32
@@ -519,6 +544,15 @@ func (e *tcpEngine) serveConn(conn net.Conn) {
 		if err := stream.body(job.rx[:length]); err != nil {
 			return
 		}
+		if !stream.identified {
+			// The handshake ran inside the first read; the client's
+			// certificate is settled from here on.
+			if tc, ok := conn.(*tls.Conn); ok {
+				state := tc.ConnectionState()
+				stream.identity, _ = e.devices.identify(&state, "")
+			}
+			stream.identified = true
+		}
 		job.conn = conn
 		job.stream = stream
 		job.written = false
@@ -608,6 +642,9 @@ func (e *tcpEngine) serveFrame(j *tcpJob, length int) bool {
 	// The one ingress: the server decides eligibility, decode, and
 	// context. A false return means an undecodable body — FORMERR,
 	// library parity, session continues.
+	if e.queries != nil {
+		e.queries.Inc()
+	}
 	if !e.handler.ServeRaw(j, j.rx[:length], j.readTime) {
 		j.rejectInPlace(acceptFormatError, length)
 	}
@@ -78,10 +78,16 @@ type tcpStream struct {
 	// built at most once per connection and reused, because saturation —
 	// the only time it is needed — is the worst moment to be allocating.
 	wait *time.Timer
+
+	// identity is the DoT client's certificate identity or device ID,
+	// read once the handshake is done; identified says it has been.
+	identity   string
+	identified bool
 }
 
 func (s *tcpStream) reset(conn net.Conn) {
 	s.conn = conn
+	s.identity, s.identified = "", false
 	s.start, s.end = 0, 0
 	s.held = 0
 	s.werr = nil
@@ -10,6 +10,7 @@ import (
 
 	"github.com/miekg/dns"
 	"github.com/semihalev/sdns/internal/dnsclient"
+	"github.com/semihalev/sdns/internal/metric"
 	"github.com/semihalev/sdns/internal/wire"
 	"github.com/semihalev/sdns/middleware"
 	"github.com/semihalev/sdns/middleware/edns"
@@ -139,6 +140,14 @@ func (j *udpJob) setRemote(ap netip.AddrPort) {
 func (j *udpJob) LocalAddr() net.Addr  { return j.pc.LocalAddr() }
 func (j *udpJob) RemoteAddr() net.Addr { return &j.remote }
 
+// ListenerPolicy names the [[listeners]] entry the query arrived on.
+func (j *udpJob) ListenerPolicy() *middleware.ListenerPolicy {
+	if j.engine == nil {
+		return nil
+	}
+	return j.engine.listener
+}
+
 // Write stages the reply in the job's own TX buffer and leaves the send
 // to the worker's burst. Bytes that already live there — the wire path
 // builds its body in the lease this buffer backs — are staged by their
@@ -241,6 +250,12 @@ type udpEngine struct {
 	pcs      []*net.UDPConn
 	wildcard bool
 
+	// listener is the [[listeners]] entry this engine serves, nil on the
+	// bind address; queries counts what it was sent. Both are set by the
+	// listener before the readers start.
+	listener *middleware.ListenerPolicy
+	queries  *metric.Counter
+
 	ready chan *udpJob
 
 	// inFlight counts the slabs between the ready queue and their
@@ -677,18 +692,26 @@ func (e *udpEngine) serve(j *udpJob, burst *udpTXBurst) {
 	// context. A false return means the accepted header hid an
 	// undecodable body — FORMERR, library parity. A replayed job
 	// finishes on the replay contract: its entry-effect middlewares
-	// already fired on the inline pass.
+	// already fired on the inline pass, which also counted it.
 	if j.replay {
 		if !e.inline.ServeRawReplay(j, j.rx[:j.rxLen], j.readTime) {
 			j.rejectInPlace(acceptFormatError)
 		}
 		return
 	}
+	e.countQuery()
 	if !e.handler.ServeRaw(j, j.rx[:j.rxLen], j.readTime) {
 		j.rejectInPlace(acceptFormatError)
 	}
 }
 
+// countQuery counts an accepted query against the engine's listener.
+func (e *udpEngine) countQuery() {
+	if e.queries != nil {
+		e.queries.Inc()
+	}
+}
+
 // serveInline runs one job on its reader, refusing to block: the chain
 // carries the inline-only mark, and a query it cannot finish comes back
 // unserved for the ring. done reports whether the job reached a terminal
@@ -749,6 +772,7 @@ func (e *udpEngine) serveInline(j *udpJob, burst *udpTXBurst) (done bool) {
 		return true
 	}
 
+	e.countQuery()
 	return e.inline.ServeRawInline(j, j.rx[:j.rxLen], j.readTime)
 }
 
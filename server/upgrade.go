@@ -0,0 +1,176 @@
+//go:build !windows
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/semihalev/zlog/v2"
+)
+
+// upgradeReadyTimeout bounds how long an upgrade waits for the new
+// process to serve: long enough for it to load its blocklists and
+// prime, short enough that a binary that hangs does not stall the
+// deploy.
+var upgradeReadyTimeout = 2 * time.Minute
+
+// Upgrade starts the executable this process runs from, with the same
+// arguments, and hands it every socket the active listeners hold, with
+// extra — the API's, say. It returns once the new process is ready on
+// them (NotifyUpgraded); the caller then shuts this one down, and the
+// two drain and serve side by side until it has. The sockets are never
+// closed in between, so no query finds the port shut.
+//
+// On error the new process is gone and this one serves as before. The
+// binary is re-read from its path, so a package manager that replaced
+// it in place is what gets started.
+func (s *Server) Upgrade(extra ...PassedSocket) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	s.listenersMu.Lock()
+	active := slices.Clone(s.active)
+	s.listenersMu.Unlock()
+	var sockets []PassedSocket
+	for _, l := range active {
+		if p, ok := l.(socketPasser); ok {
+			sockets = append(sockets, p.passSockets()...)
+		}
+	}
+	sockets = append(sockets, extra...)
+
+	pid, err := startUpgrade(exe, os.Args, sockets, upgradeReadyTimeout)
+	if err != nil {
+		return err
+	}
+	// The new process serves the unix sockets' paths now; closing ours
+	// must not remove them from under it.
+	for _, p := range sockets {
+		if ul, ok := p.Conn.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	zlog.Info("Upgraded process serves", "pid", pid, "sockets", len(sockets))
+	return nil
+}
+
+// startUpgrade starts exe with the sockets as its inherited descriptors
+// and waits for its readiness byte. The sockets' descriptors are passed
+// as they are: an *os.File of them would be switched to blocking mode
+// on the way, under this process's own readers.
+func startUpgrade(exe string, args []string, sockets []PassedSocket, timeout time.Duration) (int, error) {
+	files := []uintptr{uintptr(syscall.Stdin), uintptr(syscall.Stdout), uintptr(syscall.Stderr)}
+	names := make([]string, 0, len(sockets))
+	for _, p := range sockets {
+		if strings.Contains(p.Name, ":") {
+			return 0, fmt.Errorf("socket name %q has a colon", p.Name)
+		}
+		rc, err := p.Conn.SyscallConn()
+		var fd uintptr
+		if err == nil {
+			err = rc.Control(func(f uintptr) { fd = f })
+		}
+		if err != nil {
+			return 0, fmt.Errorf("socket %s: %w", p.Name, err)
+		}
+		files = append(files, fd)
+		names = append(names, p.Name)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	files = append(files, w.Fd())
+
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", envUpgrade, envUpgradeReady:
+			return true
+		}
+		return false
+	})
+	env = append(env,
+		"LISTEN_FDS="+strconv.Itoa(len(sockets)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		envUpgrade+"="+strconv.Itoa(os.Getpid()),
+		envUpgradeReady+"="+strconv.Itoa(len(files)-1),
+	)
+	dir, err := os.Getwd()
+	if err != nil {
+		_ = w.Close()
+		return 0, err
+	}
+
+	pid, _, err := syscall.StartProcess(exe, args, &syscall.ProcAttr{Dir: dir, Env: env, Files: files})
+	_ = w.Close()
+	if err != nil {
+		return 0, err
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	// The byte comes once the new process serves. End of file instead
+	// means it exited first — a bad config, a port it could not bind.
+	_ = r.SetReadDeadline(time.Now().Add(timeout))
+	var b [1]byte
+	if _, err := r.Read(b[:]); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = fmt.Errorf("not ready after %s", timeout)
+		} else {
+			err = errors.New("exited before serving")
+		}
+		_ = proc.Kill()
+		_, _ = proc.Wait()
+		return 0, fmt.Errorf("upgraded process %d %w", pid, err)
+	}
+	_ = proc.Release()
+	return pid, nil
+}
+
+// upgrading reports whether this process was started for an upgrade and
+// has not yet told the one it replaces that it is ready.
+func upgrading() bool {
+	return os.Getenv(envUpgradeReady) != ""
+}
+
+// NotifyUpgraded tells the process that started this one for an upgrade
+// that it is ready to serve, so that one can stop; it does nothing in a
+// process started otherwise. The caller decides what ready means, and
+// should not call it before the pipeline answers as configured. Under
+// systemd the unit's main process moves here too, which needs
+// NotifyAccess=all.
+func NotifyUpgraded() {
+	v := os.Getenv(envUpgradeReady)
+	if v == "" {
+		return
+	}
+	_ = os.Unsetenv(envUpgradeReady)
+	fd, err := strconv.Atoi(v)
+	if err != nil || fd < listenFDsStart {
+		return
+	}
+	if sock := os.Getenv("NOTIFY_SOCKET"); sock != "" {
+		if conn, err := net.Dial("unixgram", sock); err == nil {
+			_, _ = fmt.Fprintf(conn, "MAINPID=%d", os.Getpid())
+			_ = conn.Close()
+		}
+	}
+	f := os.NewFile(uintptr(fd), "upgrade-ready")
+	_, _ = f.Write([]byte{1})
+	_ = f.Close()
+}
@@ -0,0 +1,146 @@
+//go:build !windows
+
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/middleware"
+)
+
+// TestUpgradeHelperProcess is the process an upgrade starts in the tests
+// below: it answers one connection on the socket it was handed.
+func TestUpgradeHelperProcess(t *testing.T) {
+	mode := os.Getenv("SDNS_TEST_UPGRADE_CHILD")
+	if mode == "" {
+		t.Skip("started by TestUpgrade")
+	}
+	ln := InheritedListener("dns")
+	if ln == nil || mode == "fail" {
+		os.Exit(1)
+	}
+	NotifyUpgraded()
+	conn, err := ln.Accept()
+	if err != nil {
+		os.Exit(1)
+	}
+	_, _ = conn.Write([]byte("upgraded"))
+	_ = conn.Close()
+	os.Exit(0)
+}
+
+func TestUpgrade(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	addr := ln.Addr().String()
+	sockets := []PassedSocket{{Name: "dns", Conn: ln.(*net.TCPListener)}}
+	args := []string{os.Args[0], "-test.run=^TestUpgradeHelperProcess$"}
+
+	// A process that exits before it serves fails the upgrade.
+	t.Setenv("SDNS_TEST_UPGRADE_CHILD", "fail")
+	if _, err := startUpgrade(os.Args[0], args, sockets, 30*time.Second); err == nil || !strings.Contains(err.Error(), "exited before serving") {
+		t.Fatalf("startUpgrade() error = %v, want exited before serving", err)
+	}
+
+	t.Setenv("SDNS_TEST_UPGRADE_CHILD", "serve")
+	pid, err := startUpgrade(os.Args[0], args, sockets, 30*time.Second)
+	if err != nil {
+		t.Fatalf("startUpgrade() error = %v", err)
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _, _ = proc.Wait() }()
+
+	// This process lets go of the socket; the port stays open in the
+	// new one, which answers.
+	_ = ln.Close()
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial after hand-off: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != "upgraded" {
+		t.Fatalf("read %q, %v; want %q", got, err, "upgraded")
+	}
+}
+
+// TestUpgradedServerHoldsServe checks that a process started for an
+// upgrade binds its inherited sockets but leaves them to the process it
+// replaces until StartServing.
+func TestUpgradedServerHoldsServe(t *testing.T) {
+	middleware.Reset()
+	t.Cleanup(middleware.Reset)
+	middleware.Register("listener-name-stub", func(*config.Config) middleware.Handler {
+		return listenerNameStub{}
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setInherited(t,
+		inheritedSocket{name: socketDNS, pc: pc},
+		inheritedSocket{name: socketDNS, ln: ln},
+	)
+	t.Setenv(envUpgradeReady, "3")
+
+	cfg := &config.Config{
+		Bind:         "192.0.2.1:53",
+		QueryTimeout: config.Duration{Duration: 2 * time.Second},
+	}
+	middleware.Setup(cfg)
+	s := New(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		deadline := time.Now().Add(5 * time.Second)
+		for !s.Stopped() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	req := new(dns.Msg)
+	req.SetQuestion("listener.example.", dns.TypeTXT)
+	client := &dns.Client{Net: "udp", Timeout: 300 * time.Millisecond}
+	if _, _, err := client.Exchange(req, pc.LocalAddr().String()); err == nil {
+		t.Fatal("answered before StartServing")
+	}
+	if s.Ready() {
+		t.Fatal("Ready() before StartServing")
+	}
+
+	s.StartServing()
+	client.Timeout = 3 * time.Second
+	resp, _, err := client.Exchange(req, pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("exchange after StartServing: %v", err)
+	}
+	if got := answeredBy(t, resp); got != defaultListener {
+		t.Errorf("answered by %q, want %q", got, defaultListener)
+	}
+	s.StartServing()
+}
@@ -0,0 +1,16 @@
+package server
+
+import "errors"
+
+// Upgrade is not available on Windows, which passes no sockets to a
+// process it starts.
+func (s *Server) Upgrade(...PassedSocket) error {
+	return errors.New("upgrade is not supported on windows")
+}
+
+// NotifyUpgraded does nothing on Windows.
+func NotifyUpgraded() {}
+
+// upgrading is false on Windows, where no process is started for an
+// upgrade.
+func upgrading() bool { return false }
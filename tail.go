@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/semihalev/sdns/config"
+	"github.com/semihalev/sdns/middleware/querylog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tailAPI     string
+	tailToken   string
+	tailClient  string
+	tailQname   string
+	tailRcode   string
+	tailBlocked string
+	tailJSON    bool
+
+	tailCmd = &cobra.Command{
+		Use:   "tail",
+		Short: "Follow the queries a running server answers",
+		Long: `Tail streams the query history of a running sdns from its API, one line
+per query as it is answered. The server needs the API and the query log
+history enabled. The API address and bearer token are read from the config
+file unless given as flags.`,
+		Args: cobra.NoArgs,
+		RunE: runTail,
+	}
+)
+
+func init() {
+	f := tailCmd.Flags()
+	f.StringVar(&tailAPI, "api", "", "API address of the server, a URL or unix:/path/to/socket (default: api_socket or api from the config file)")
+	f.StringVar(&tailToken, "token", "", "API bearer token (default: bearertoken from the config file)")
+	f.StringVar(&tailClient, "client", "", "Only queries from this client address or prefix")
+	f.StringVar(&tailQname, "qname", "", "Only queries for this name and the names below it")
+	f.StringVar(&tailRcode, "rcode", "", "Only responses with this RCODE, e.g. NXDOMAIN")
+	f.StringVar(&tailBlocked, "blocked", "", "Only blocked queries if true, only answered ones if false")
+	f.BoolVar(&tailJSON, "json", false, "Print each query as the server's JSON entry")
+	rootCmd.AddCommand(tailCmd)
+}
+
+func runTail(cmd *cobra.Command, args []string) error {
+	addr, token := tailAPI, tailToken
+	secure := false
+	if addr == "" || token == "" {
+		// Load would generate a missing config; tail only reads one.
+		if _, err := os.Stat(cfgPath); err == nil {
+			c, err := config.Load(cfgPath, version)
+			if err != nil {
+				return fmt.Errorf("config loading failed: %w", err)
+			}
+			if addr == "" {
+				// The socket needs no token and no certificate.
+				switch {
+				case c.APISocket != "":
+					addr = "unix:" + c.APISocket
+				case c.API != "":
+					addr, secure = c.API, c.APITLSCertificate != ""
+				}
+			}
+			if token == "" {
+				token = c.BearerToken
+			}
+		}
+	}
+	if addr == "" {
+		return errors.New("no API address: set api in the config file or pass --api")
+	}
+
+	u, client, err := tailEndpoint(addr, secure)
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	for k, v := range map[string]string{
+		"client":  tailClient,
+		"qname":   tailQname,
+		"rcode":   tailRcode,
+		"blocked": tailBlocked,
+	} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var e struct{ Error string }
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, e.Error)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%s: is the query log history enabled?", resp.Status)
+		}
+		return errors.New(resp.Status)
+	}
+
+	err = followEvents(resp.Body, os.Stdout, tailJSON)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err == nil {
+		err = errors.New("server closed the stream")
+	}
+	return err
+}
+
+// tailEndpoint turns the API address into the stream's URL and the client
+// to reach it with. The address is a URL, unix: and a socket path, or a
+// listen address; a listen address with a wildcard or missing host is
+// reached over loopback, with https when secure.
+func tailEndpoint(addr string, secure bool) (*url.URL, *http.Client, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return new(net.Dialer).DialContext(ctx, "unix", path)
+			},
+		}}
+		return &url.URL{Scheme: "http", Host: "sdns", Path: "/api/v1/querylog/stream"}, client, nil
+	}
+	u, err := tailURL(addr, secure)
+	return u, http.DefaultClient, err
+}
+
+// tailURL turns the API listen address into the stream's URL. A wildcard
+// or missing host is reached over loopback.
+func tailURL(addr string, secure bool) (*url.URL, error) {
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v1/querylog/stream"
+		return u, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API address %q: %w", addr, err)
+	}
+	switch host {
+	case "", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	return &url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(host, port),
+		Path:   "/api/v1/querylog/stream",
+	}, nil
+}
+
+// followEvents reads Server-Sent Events from r and prints each entry to w
+// until the stream ends.
+func followEvents(r io.Reader, w io.Writer, raw bool) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	var data []byte
+	for sc.Scan() {
+		line := sc.Bytes()
+		switch {
+		case bytes.HasPrefix(line, []byte("data:")):
+			if len(data) > 0 {
+				data = append(data, '\n')
+			}
+			data = append(data, bytes.TrimPrefix(bytes.TrimPrefix(line, []byte("data:")), []byte(" "))...)
+		case len(line) == 0 && len(data) > 0:
+			if raw {
+				if _, err := fmt.Fprintf(w, "%s\n", data); err != nil {
+					return err
+				}
+			} else {
+				var e querylog.Entry
+				if err := json.Unmarshal(data, &e); err == nil {
+					if _, err := io.WriteString(w, formatEntry(&e)+"\n"); err != nil {
+						return err
+					}
+				}
+			}
+			data = data[:0]
+		}
+	}
+	return sc.Err()
+}
+
+// formatEntry renders an entry as one line for a terminal:
+//
+//	15:04:05.000 192.0.2.7 udp www.example.com. A NOERROR 12.3ms cache secure -> A 192.0.2.1
+func formatEntry(e *querylog.Entry) string {
+	var b strings.Builder
+	b.WriteString(e.Time.Local().Format("15:04:05.000"))
+	for _, s := range []string{e.ClientIP.String(), e.Transport, e.Qname, e.Qtype, e.Rcode} {
+		b.WriteByte(' ')
+		b.WriteString(s)
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(e.LatencyMS, 'f', 1, 64))
+	b.WriteString("ms")
+	if e.EDE != nil {
+		fmt.Fprintf(&b, " ede=%d", *e.EDE)
+		if e.EDEText != "" {
+			b.WriteString(" " + strconv.Quote(e.EDEText))
+		}
+	}
+	switch {
+	case e.Blocked != "":
+		b.WriteString(" blocked by " + e.Blocked)
+	case e.CacheHit:
+		b.WriteString(" cache")
+	case e.Upstream != "":
+		b.WriteString(" from " + e.Upstream)
+	}
+	if e.DNSSEC != "" {
+		b.WriteString(" " + e.DNSSEC)
+	}
+	if len(e.Answer) > 0 {
+		b.WriteString(" -> " + strings.Join(e.Answer, ", "))
+	}
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	tokenStdin bool
+
+	tokenCmd = &cobra.Command{
+		Use:   "token [name]",
+		Short: "Make an API token and its config entry",
+		Long: `Token makes a random API token and prints it with an [[api_tokens]] entry
+that holds only its SHA-256 digest, so the token itself need not be kept in
+the config file. With --stdin the token is read from standard input instead
+of made, to hash one already in use.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runToken,
+	}
+)
+
+func init() {
+	tokenCmd.Flags().BoolVar(&tokenStdin, "stdin", false, "Hash the token read from standard input instead of making one")
+	rootCmd.AddCommand(tokenCmd)
+}
+
+func runToken(cmd *cobra.Command, args []string) error {
+	name := "name"
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	var token string
+	if tokenStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		token = strings.TrimSpace(line)
+		if token == "" {
+			if err != nil {
+				return fmt.Errorf("read token: %w", err)
+			}
+			return fmt.Errorf("read token: empty")
+		}
+	} else {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		token = hex.EncodeToString(secret)
+		fmt.Fprintf(cmd.OutOrStdout(), "token: %s\n\n", token)
+	}
+
+	digest := sha256.Sum256([]byte(token))
+	fmt.Fprintf(cmd.OutOrStdout(), "[[api_tokens]]\nname = %q\ntoken_sha256 = %q\nscopes = [\"read-metrics\"]\n", name, hex.EncodeToString(digest[:]))
+	return nil
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/semihalev/sdns/api"
+	"github.com/semihalev/sdns/server"
+	"github.com/semihalev/zlog/v2"
+)
+
+// watchUpgrade replaces the process on SIGUSR2: the executable at its
+// path is started with every socket, and once it serves, stop shuts
+// this one down. A new process that fails to come up is stopped, and
+// this one serves on.
+func watchUpgrade(ctx context.Context, srv *server.Server, a *api.API, stop context.CancelFunc) {
+	sigUsr2 := make(chan os.Signal, 1)
+	signal.Notify(sigUsr2, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(sigUsr2)
+		for {
+			select {
+			case <-sigUsr2:
+				zlog.Info("Received SIGUSR2, upgrading")
+				if err := srv.Upgrade(a.Sockets()...); err != nil {
+					zlog.Error("Upgrade failed, serving on", "error", err.Error())
+					continue
+				}
+				stop()
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"context"
+
+	"github.com/semihalev/sdns/api"
+	"github.com/semihalev/sdns/server"
+)
+
+// watchUpgrade does nothing on Windows, which has no SIGUSR2.
+func watchUpgrade(context.Context, *server.Server, *api.API, context.CancelFunc) {}